@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// eventAdapter normalizes one decoded line of `run --format json` output into
+// the canonical {"type": ..., "part": {...}} event understood by
+// runner.EventData. Field names and event shapes have changed across opencode
+// releases; each adapter recognizes one of those shapes.
+type eventAdapter struct {
+	name       string
+	minVersion string // first release known to emit this shape
+	// normalize returns known=false when raw doesn't match the adapter's
+	// schema. A known event may still yield a nil event when there is
+	// nothing to emit (e.g. an incomplete part).
+	normalize func(raw map[string]any) (event map[string]any, known bool)
+}
+
+var cliVersionRe = regexp.MustCompile(`\d+\.\d+(\.\d+)?`)
+
+// eventAdapters lists the known schemas, newest first.
+var eventAdapters = []eventAdapter{
+	{name: "part", minVersion: "0.10.0", normalize: normalizePartEvent},
+	{name: "flat", minVersion: "0.3.0", normalize: normalizeFlatEvent},
+	{name: "bus", minVersion: "0.0.0", normalize: normalizeBusEvent},
+}
+
+var partEventTypes = map[string]bool{
+	"text":        true,
+	"tool_use":    true,
+	"step_start":  true,
+	"step_finish": true,
+	"reasoning":   true,
+}
+
+// normalizePartEvent handles the current shape:
+// {"type":"text","sessionID":"...","part":{...}} and {"type":"error","error":{...}}.
+func normalizePartEvent(raw map[string]any) (map[string]any, bool) {
+	eventType, _ := raw["type"].(string)
+	if eventType == "error" {
+		if _, ok := raw["error"]; ok {
+			return raw, true
+		}
+		return nil, false
+	}
+	if _, ok := raw["part"].(map[string]any); !ok || !partEventTypes[eventType] {
+		return nil, false
+	}
+	return raw, true
+}
+
+// normalizeFlatEvent handles events whose fields sit at the top level:
+// {"type":"text","text":"..."} or {"type":"tool_use","tool":"...","state":{...}}.
+func normalizeFlatEvent(raw map[string]any) (map[string]any, bool) {
+	eventType, _ := raw["type"].(string)
+	if !partEventTypes[eventType] {
+		return nil, false
+	}
+	if _, ok := raw["part"]; ok {
+		return nil, false
+	}
+	switch eventType {
+	case "text":
+		if _, ok := raw["text"].(string); !ok {
+			return nil, false
+		}
+	case "tool_use":
+		if _, ok := raw["tool"].(string); !ok {
+			return nil, false
+		}
+	}
+	part := make(map[string]any, len(raw))
+	for k, v := range raw {
+		if k != "type" {
+			part[k] = v
+		}
+	}
+	return map[string]any{"type": eventType, "part": part}, true
+}
+
+// normalizeBusEvent handles raw bus events as printed by early releases:
+// {"type":"message.part.updated","properties":{"part":{...}}}.
+func normalizeBusEvent(raw map[string]any) (map[string]any, bool) {
+	eventType, _ := raw["type"].(string)
+	if !strings.Contains(eventType, ".") {
+		return nil, false
+	}
+	if eventType != "message.part.updated" {
+		// Other bus events (session.updated, ...) are recognized but not emitted
+		return nil, true
+	}
+	props, _ := raw["properties"].(map[string]any)
+	part, ok := props["part"].(map[string]any)
+	if !ok {
+		return nil, false
+	}
+	event, _ := servePartEvent(part)
+	return event, true
+}
+
+// adaptersFor orders the known adapters so the one matching version is tried
+// first; the rest remain as fallbacks.
+func adaptersFor(version string) []eventAdapter {
+	if version == "" {
+		return eventAdapters
+	}
+	ordered := make([]eventAdapter, 0, len(eventAdapters))
+	var rest []eventAdapter
+	for _, a := range eventAdapters {
+		if len(ordered) == 0 && compareVersions(version, a.minVersion) >= 0 {
+			ordered = append(ordered, a)
+			continue
+		}
+		rest = append(rest, a)
+	}
+	return append(ordered, rest...)
+}
+
+// normalizeEvent runs raw through the adapters for version.
+func normalizeEvent(adapters []eventAdapter, raw map[string]any) (map[string]any, bool) {
+	for _, a := range adapters {
+		if event, known := a.normalize(raw); known {
+			return event, true
+		}
+	}
+	return nil, false
+}
+
+// detectCLIVersion runs `<target> --version` and extracts the version number.
+func detectCLIVersion(target string) string {
+	version, _ := cliVersion(target)
+	return version
+}
+
+// cliVersion is detectCLIVersion, failing when the binary doesn't run. The
+// version is empty when the output contains none.
+func cliVersion(target string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, target, "--version").Output()
+	if err != nil {
+		return "", err
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	return cliVersionRe.FindString(lines[len(lines)-1]), nil
+}
+
+// compareVersions compares dotted numeric versions, returning -1, 0 or 1.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"opencode-mcp/internal/runner"
+)
+
+// Test compareVersions
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.2.3", "1.2.3", 0},
+		{"1.2", "1.2.0", 0},
+		{"0.9.1", "0.10.0", -1},
+		{"1.0.0", "0.10.0", 1},
+	}
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// Test adaptersFor
+func TestAdaptersFor(t *testing.T) {
+	tests := []struct {
+		version string
+		want    string
+	}{
+		{"", "part"},
+		{"1.2.3", "part"},
+		{"0.5.0", "flat"},
+		{"0.1.0", "bus"},
+	}
+	for _, tt := range tests {
+		adapters := adaptersFor(tt.version)
+		if adapters[0].name != tt.want {
+			t.Errorf("adaptersFor(%q)[0] = %s, want %s", tt.version, adapters[0].name, tt.want)
+		}
+		if len(adapters) != len(eventAdapters) {
+			t.Errorf("adaptersFor(%q) dropped fallbacks", tt.version)
+		}
+	}
+}
+
+// Test normalizeEvent across known shapes
+func TestNormalizeEvent(t *testing.T) {
+	tests := []struct {
+		name      string
+		line      string
+		wantKnown bool
+		wantType  string
+		wantText  string
+	}{
+		{
+			name:      "part shape",
+			line:      `{"type":"text","sessionID":"s","part":{"text":"hi"}}`,
+			wantKnown: true, wantType: "text", wantText: "hi",
+		},
+		{
+			name:      "flat shape",
+			line:      `{"type":"text","text":"hi"}`,
+			wantKnown: true, wantType: "text", wantText: "hi",
+		},
+		{
+			name:      "bus shape",
+			line:      `{"type":"message.part.updated","properties":{"part":{"type":"text","text":"hi","time":{"end":1}}}}`,
+			wantKnown: true, wantType: "text", wantText: "hi",
+		},
+		{
+			name:      "bus shape without payload",
+			line:      `{"type":"session.updated","properties":{}}`,
+			wantKnown: true,
+		},
+		{
+			name:      "error event",
+			line:      `{"type":"error","error":{"name":"ProviderAuthError"}}`,
+			wantKnown: true, wantType: "error",
+		},
+		{
+			name: "unknown shape",
+			line: `{"kind":"text","content":"hi"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var raw map[string]any
+			if err := json.Unmarshal([]byte(tt.line), &raw); err != nil {
+				t.Fatal(err)
+			}
+			event, known := normalizeEvent(eventAdapters, raw)
+			if known != tt.wantKnown {
+				t.Fatalf("known = %v, want %v", known, tt.wantKnown)
+			}
+			if tt.wantType == "" {
+				if known && event != nil {
+					t.Errorf("expected no event, got %v", event)
+				}
+				return
+			}
+			if event["type"] != tt.wantType {
+				t.Errorf("type = %v, want %v", event["type"], tt.wantType)
+			}
+			if tt.wantText != "" && runner.EventData(event) != tt.wantText {
+				t.Errorf("text = %v, want %q", runner.EventData(event), tt.wantText)
+			}
+		})
+	}
+}
+
+// Test detectCLIVersion
+func TestDetectCLIVersion(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "opencode")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho 'opencode'\necho '1.2.10'\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if got := detectCLIVersion(script); got != "1.2.10" {
+		t.Errorf("detectCLIVersion() = %q, want %q", got, "1.2.10")
+	}
+	if got := detectCLIVersion("/nonexistent/opencode"); got != "" {
+		t.Errorf("detectCLIVersion() = %q for missing binary", got)
+	}
+}
+
+// Test strict mode fails loudly on unknown events
+func TestStrictEvents(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "opencode")
+	content := `#!/bin/sh
+echo '{"type":"text","part":{"text":"Hello"}}'
+echo '{"kind":"mystery"}'
+echo '{"type":"text","part":{"text":"never seen"}}'
+`
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(strict bool) *toolCallResult {
+		cfg := serverConfig{Target: script, DefaultTimeout: 5 * time.Second, StrictEvents: strict}
+		result, mErr := newToolHandler(cfg)(context.Background(), &toolCall{
+			ID: json.RawMessage("1"), Name: toolRun, Arguments: json.RawMessage(`{"message":"x","model":"m"}`),
+		})
+		if mErr != nil {
+			t.Fatalf("unexpected error: %v", mErr)
+		}
+		return result
+	}
+
+	lenient := run(false)
+	if lenient.IsError || !strings.Contains(lenient.Content[0].Text, "never seen") {
+		t.Errorf("lenient result = %+v", lenient)
+	}
+
+	strict := run(true)
+	if !strict.IsError || !strings.Contains(strict.Content[0].Text, "unrecognized opencode event") {
+		t.Errorf("strict result = %+v", strict)
+	}
+	if strings.Contains(strict.Content[0].Text, "never seen") {
+		t.Error("strict mode should stop reading after the unknown event")
+	}
+}
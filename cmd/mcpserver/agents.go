@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// agentCLI is a coding agent CLI other than opencode that the run tools can
+// front, so teams get the same MCP tools whichever agent they use. Its
+// output is normalized into opencode's event model, so streaming, results
+// and history work unchanged.
+type agentCLI struct {
+	name string
+	// runArgs returns the command line of a run; model may be empty for
+	// the CLI's own default.
+	runArgs func(a runToolArgs, model string) ([]string, error)
+	// event normalizes one output line into an opencode event. known=false
+	// passes the line through as raw output.
+	event func(line string) (event map[string]any, known bool)
+}
+
+const agentOpencode = "opencode"
+
+// agentCLIs are the wrapped agents, by the name used in the config file.
+var agentCLIs = map[string]*agentCLI{
+	"aider": {name: "aider", runArgs: aiderArgs, event: aiderEvent},
+	"codex": {name: "codex", runArgs: codexArgs, event: codexEvent},
+}
+
+func agentNames() string {
+	names := []string{agentOpencode}
+	for name := range agentCLIs {
+		names = append(names, name)
+	}
+	sort.Strings(names[1:])
+	return strings.Join(names, ", ")
+}
+
+func validateAgentName(name string) error {
+	if name != "" && name != agentOpencode && agentCLIs[name] == nil {
+		return fmt.Errorf("unknown agent %q (known: %s)", name, agentNames())
+	}
+	return nil
+}
+
+// validateAgents checks the top-level agent and agents of the config file.
+func validateAgents(agent string, targets map[string]string) error {
+	if err := validateAgentName(agent); err != nil {
+		return fmt.Errorf("agent: %v", err)
+	}
+	for name, target := range targets {
+		if err := validateAgentName(name); err != nil {
+			return fmt.Errorf("agents.%s: %v", name, err)
+		}
+		if target == "" {
+			return fmt.Errorf("agents.%s: missing binary path", name)
+		}
+	}
+	return nil
+}
+
+// agentCLI returns the wrapped agent cfg runs, or nil for opencode.
+func (cfg serverConfig) agentCLI() *agentCLI {
+	return agentCLIs[cfg.Agent]
+}
+
+// withAgent returns cfg with Target pointing at the agent's binary: its
+// path from the config file's agents, else its name on PATH.
+func (cfg serverConfig) withAgent() serverConfig {
+	if a := cfg.agentCLI(); a != nil {
+		cfg.Target = a.name
+		if path := cfg.AgentTargets[a.name]; path != "" {
+			cfg.Target = path
+		}
+	}
+	return cfg
+}
+
+// aiderArgs runs aider non-interactively on one message. aider has no
+// sessions; continue restores the chat history kept in the directory.
+func aiderArgs(a runToolArgs, model string) ([]string, error) {
+	if a.Session != "" {
+		return nil, fmt.Errorf("aider has no sessions; use continue to restore its chat history")
+	}
+	if a.Agent != "" {
+		return nil, fmt.Errorf("aider has no agents")
+	}
+	args := []string{"--message", a.Message, "--yes-always", "--no-pretty", "--no-stream"}
+	if model != "" {
+		args = append(args, "--model", model)
+	}
+	if a.Continue {
+		args = append(args, "--restore-chat-history")
+	}
+	for _, f := range a.Files {
+		args = append(args, "--file", f)
+	}
+	return args, nil
+}
+
+// aiderEvent turns aider's plain-text output into text events.
+func aiderEvent(line string) (map[string]any, bool) {
+	return map[string]any{"type": "text", "part": map[string]any{"type": "text", "text": line + "\n"}}, true
+}
+
+// codexArgs runs `codex exec` with its JSONL event output. Like opencode
+// run, it may edit files in the working directory, which need not be a git
+// repo.
+func codexArgs(a runToolArgs, model string) ([]string, error) {
+	if len(a.Files) > 0 {
+		return nil, fmt.Errorf("codex takes no file attachments; name the files in the message")
+	}
+	if a.Agent != "" {
+		return nil, fmt.Errorf("codex has no agents")
+	}
+	args := []string{"exec", "--json", "--full-auto", "--skip-git-repo-check"}
+	if model != "" {
+		args = append(args, "--model", model)
+	}
+	switch {
+	case a.Session != "":
+		args = append(args, "resume", a.Session)
+	case a.Continue:
+		args = append(args, "resume", "--last")
+	}
+	return append(args, a.Message), nil
+}
+
+// codexEvent maps codex exec --json events onto opencode's: the thread ID
+// becomes the session ID, agent messages text, commands and file changes
+// tool uses, and turn usage a step_finish.
+func codexEvent(line string) (map[string]any, bool) {
+	var raw map[string]any
+	if err := json.Unmarshal([]byte(line), &raw); err != nil {
+		return nil, false
+	}
+	eventType, _ := raw["type"].(string)
+	switch eventType {
+	case "thread.started":
+		id, _ := raw["thread_id"].(string)
+		return map[string]any{"type": "step_start", "sessionID": id, "part": map[string]any{"type": "step-start"}}, true
+	case "turn.completed":
+		usage, _ := raw["usage"].(map[string]any)
+		tokens := map[string]any{"input": usage["input_tokens"], "output": usage["output_tokens"]}
+		return map[string]any{"type": "step_finish", "part": map[string]any{"type": "step-finish", "reason": "stop", "tokens": tokens}}, true
+	case "turn.failed":
+		return map[string]any{"type": "error", "error": raw["error"]}, true
+	case "error":
+		return map[string]any{"type": "error", "error": map[string]any{"message": raw["message"]}}, true
+	case "turn.started", "item.started", "item.updated":
+		return nil, true
+	case "item.completed":
+		item, _ := raw["item"].(map[string]any)
+		return codexItemEvent(item), true
+	}
+	return nil, false
+}
+
+func codexItemEvent(item map[string]any) map[string]any {
+	itemType, _ := item["type"].(string)
+	if itemType == "" {
+		itemType, _ = item["item_type"].(string) // older codex releases
+	}
+	text, _ := item["text"].(string)
+	toolUse := func(tool string, input map[string]any, output any, failed bool) map[string]any {
+		status := "completed"
+		if failed {
+			status = "error"
+		}
+		state := map[string]any{"status": status, "input": input, "output": output}
+		return map[string]any{"type": "tool_use", "part": map[string]any{"type": "tool", "tool": tool, "state": state}}
+	}
+	switch itemType {
+	case "agent_message", "assistant_message":
+		return map[string]any{"type": "text", "part": map[string]any{"type": "text", "text": text}}
+	case "reasoning":
+		return map[string]any{"type": "reasoning", "part": map[string]any{"type": "reasoning", "text": text}}
+	case "command_execution":
+		exitCode, _ := item["exit_code"].(float64)
+		status, _ := item["status"].(string)
+		return toolUse("bash", map[string]any{"command": item["command"]}, item["aggregated_output"], exitCode != 0 || status == "failed")
+	case "file_change":
+		status, _ := item["status"].(string)
+		return toolUse("edit", map[string]any{"changes": item["changes"]}, nil, status == "failed")
+	case "mcp_tool_call":
+		server, _ := item["server"].(string)
+		tool, _ := item["tool"].(string)
+		status, _ := item["status"].(string)
+		return toolUse(server+"_"+tool, map[string]any{"arguments": item["arguments"]}, item["result"], status == "failed")
+	case "web_search":
+		return toolUse("websearch", map[string]any{"query": item["query"]}, nil, false)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test a tenant's runs go to codex, with its events normalized
+func TestAgentCLI(t *testing.T) {
+	dir := t.TempDir()
+	codex := filepath.Join(dir, "codex")
+	content := `#!/bin/sh
+echo "$@" > "$0.args"
+cat <<'EOF'
+{"type":"thread.started","thread_id":"th_1"}
+{"type":"turn.started"}
+{"type":"item.completed","item":{"id":"item_0","type":"reasoning","text":"thinking"}}
+{"type":"item.completed","item":{"id":"item_1","type":"command_execution","command":"ls","aggregated_output":"a.go\n","exit_code":0,"status":"completed"}}
+{"type":"item.completed","item":{"id":"item_2","type":"agent_message","text":"done"}}
+{"type":"turn.completed","usage":{"input_tokens":120,"cached_input_tokens":0,"output_tokens":30}}
+EOF
+`
+	if err := os.WriteFile(codex, []byte(content), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	cfg := serverConfig{
+		Target:         "/nonexistent/opencode",
+		DefaultTimeout: 5 * time.Second,
+		AgentTargets:   map[string]string{"codex": codex},
+		Tenants:        map[string]tenantPolicy{"lab": {Agent: "codex", DefaultModel: "gpt-5-codex"}},
+	}
+	tools := newToolHandler(cfg)
+	call := func(name, args string) (*toolCallResult, *mcpError) {
+		return tools(context.Background(), &toolCall{ID: json.RawMessage("1"), Name: name, Tenant: "lab", Arguments: json.RawMessage(args)})
+	}
+
+	result, mErr := call(toolRun, `{"message":"list files","session":"th_0"}`)
+	if mErr != nil {
+		t.Fatal(mErr)
+	}
+	text := result.Content[0].Text
+	if result.IsError || !strings.HasPrefix(text, "done") || !strings.Contains(text, "[Tool: bash]\na.go") {
+		t.Errorf("text = %q", text)
+	}
+	if result.sessionID != "th_1" || result.usage.InputTokens != 120 || result.usage.OutputTokens != 30 {
+		t.Errorf("session %q, usage %+v", result.sessionID, result.usage)
+	}
+	args, _ := os.ReadFile(codex + ".args")
+	if got := strings.TrimSpace(string(args)); got != "exec --json --full-auto --skip-git-repo-check --model gpt-5-codex resume th_0 list files" {
+		t.Errorf("codex args = %q", got)
+	}
+
+	if _, mErr := call(toolRun, `{"message":"x","files":["a.go"]}`); mErr == nil || mErr.Data.Code != errInvalidArguments.Code {
+		t.Errorf("files: %v", mErr)
+	}
+	if _, mErr := call(toolSessionList, `{}`); mErr == nil || mErr.Data.Code != errInvalidArguments.Code {
+		t.Errorf("session list: %v", mErr)
+	}
+
+	if err := validateTenantPolicies(map[string]tenantPolicy{"x": {Agent: "cursor"}}); err == nil {
+		t.Error("accepted an unknown agent")
+	}
+	if got, _ := aiderArgs(runToolArgs{Message: "fix it", Continue: true, Files: []string{"a.go"}}, ""); strings.Join(got, " ") != "--message fix it --yes-always --no-pretty --no-stream --restore-chat-history --file a.go" {
+		t.Errorf("aider args = %q", got)
+	}
+}
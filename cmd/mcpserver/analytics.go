@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Prompt analytics (MCP_PROMPT_ANALYTICS) tell platform teams how
+// opencode_run is used without storing what is asked: each prompt is
+// reduced to a few coarse features, which only ever exist as daily counts
+// per tenant. Reports add Laplace noise to every stored count, so the
+// presence of a single prompt can't be inferred from them. The noise is
+// derived from the count and a secret kept in the store rather than drawn
+// per read, so repeating a report gives the same numbers instead of samples
+// that average out to the exact counts.
+
+const (
+	analyticsCollection    = "analytics"
+	analyticsKeyCollection = "analyticsKey"
+	// analyticsEpsilon is the privacy budget of a day's counts.
+	analyticsEpsilon = 1.0
+	// analyticsSensitivity is how much one prompt changes a day's counts:
+	// one run, plus one bucket of each of the 4 histograms.
+	analyticsSensitivity = 5.0
+)
+
+// promptFeatures are everything recorded about a prompt.
+type promptFeatures struct {
+	Length      string // length bucket in characters
+	Language    string
+	Attachments bool
+	Category    string
+}
+
+// analyticsDay holds the counts of one tenant and day.
+type analyticsDay struct {
+	Tenant      string         `json:"tenant"`
+	Day         string         `json:"day"` // YYYY-MM-DD
+	Runs        int            `json:"runs"`
+	Length      map[string]int `json:"length"`
+	Language    map[string]int `json:"language"`
+	Attachments map[string]int `json:"attachments"` // "yes" or "no"
+	Category    map[string]int `json:"category"`
+}
+
+var analyticsMu sync.Mutex // serializes count updates
+
+var lengthBuckets = []struct {
+	max   int
+	label string
+}{{100, "0-99"}, {500, "100-499"}, {2000, "500-1999"}, {10000, "2000-9999"}, {math.MaxInt, "10000+"}}
+
+// taskCategories are tried in order; a prompt goes to the one whose
+// keywords it mentions most.
+var taskCategories = []struct {
+	name     string
+	keywords []string
+}{
+	{"fix", []string{"fix", "bug", "error", "crash", "broken", "fail", "exception", "修复", "错误"}},
+	{"test", []string{"test", "spec", "coverage", "测试"}},
+	{"refactor", []string{"refactor", "clean up", "cleanup", "rename", "simplify", "extract", "重构"}},
+	{"docs", []string{"document", "readme", "docstring", "comment", "文档"}},
+	{"review", []string{"review", "audit", "check", "审查"}},
+	{"explain", []string{"explain", "what does", "how does", "why", "understand", "解释", "为什么"}},
+	{"feature", []string{"add", "implement", "create", "build", "support", "new", "实现", "添加"}},
+}
+
+// latinStopwords tell the languages written in Latin script apart.
+var latinStopwords = map[string][]string{
+	"en": {"the", "and", "is", "to", "of", "in", "this", "that", "with"},
+	"es": {"el", "la", "los", "las", "que", "y", "es", "por", "para", "con"},
+	"fr": {"le", "la", "les", "des", "et", "est", "que", "pour", "dans", "avec"},
+	"de": {"der", "die", "das", "und", "ist", "nicht", "mit", "für", "ein", "eine"},
+}
+
+// lengthBucket is the label of the length bucket of message.
+func lengthBucket(message string) string {
+	n := len([]rune(message))
+	for _, b := range lengthBuckets {
+		if n < b.max {
+			return b.label
+		}
+	}
+	return lengthBuckets[len(lengthBuckets)-1].label
+}
+
+// extractFeatures reduces a prompt to its features.
+func extractFeatures(message string, files []string) promptFeatures {
+	f := promptFeatures{Attachments: len(files) > 0, Language: promptLanguage(message), Category: "other"}
+	f.Length = lengthBucket(message)
+	lower := strings.ToLower(message)
+	words := strings.FieldsFunc(lower, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+	best := 0
+	for _, c := range taskCategories {
+		hits := 0
+		for _, k := range c.keywords {
+			if strings.ContainsFunc(k, func(r rune) bool { return r > unicode.MaxASCII }) || strings.Contains(k, " ") {
+				hits += strings.Count(lower, k)
+				continue
+			}
+			for _, w := range words {
+				if w == k || strings.HasPrefix(w, k) && len(k) > 3 {
+					hits++
+				}
+			}
+		}
+		if hits > best {
+			best, f.Category = hits, c.name
+		}
+	}
+	return f
+}
+
+// promptLanguage guesses the language from the script, and for Latin
+// script from common words.
+func promptLanguage(message string) string {
+	counts := map[string]int{}
+	letters := 0
+	for _, r := range message {
+		switch {
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana):
+			counts["ja"] += 2 // Japanese also uses Han
+		case unicode.Is(unicode.Han, r):
+			counts["zh"]++
+		case unicode.Is(unicode.Hangul, r):
+			counts["ko"]++
+		case unicode.Is(unicode.Cyrillic, r):
+			counts["ru"]++
+		case unicode.Is(unicode.Arabic, r):
+			counts["ar"]++
+		case unicode.Is(unicode.Latin, r):
+			counts["latin"]++
+		default:
+			continue
+		}
+		letters++
+	}
+	if letters == 0 {
+		return "unknown"
+	}
+	script, most := "", 0
+	for s, n := range counts {
+		if n > most || n == most && s < script {
+			script, most = s, n
+		}
+	}
+	if script != "latin" {
+		return script
+	}
+	words := strings.Fields(strings.ToLower(message))
+	lang, best := "other-latin", 0
+	for _, l := range []string{"en", "es", "fr", "de"} {
+		hits := 0
+		for _, w := range words {
+			for _, s := range latinStopwords[l] {
+				if w == s {
+					hits++
+				}
+			}
+		}
+		if hits > best {
+			lang, best = l, hits
+		}
+	}
+	if best == 0 && len(words) > 0 {
+		return "en" // short imperative prompts like "fix tests"
+	}
+	return lang
+}
+
+// recordPromptFeatures adds f to today's counts of tenant.
+func recordPromptFeatures(s *store, tenant string, f promptFeatures, now time.Time) error {
+	tenant = tenantOrDefault(tenant)
+	day := localTime(now).Format("2006-01-02")
+	key := tenant + "/" + day
+	analyticsMu.Lock()
+	defer analyticsMu.Unlock()
+	d := analyticsDay{Tenant: tenant, Day: day}
+	if _, err := s.get(analyticsCollection, key, &d); err != nil {
+		return err
+	}
+	d.Runs++
+	attachments := "no"
+	if f.Attachments {
+		attachments = "yes"
+	}
+	for _, h := range []struct {
+		m     *map[string]int
+		label string
+	}{{&d.Length, f.Length}, {&d.Language, f.Language}, {&d.Attachments, attachments}, {&d.Category, f.Category}} {
+		if *h.m == nil {
+			*h.m = map[string]int{}
+		}
+		(*h.m)[h.label]++
+	}
+	return s.put(analyticsCollection, key, d)
+}
+
+// analyticsMiddleware records the features of opencode_run prompts.
+func analyticsMiddleware(cfg serverConfig) toolMiddleware {
+	return func(next toolHandler) toolHandler {
+		return func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+			if !cfg.PromptAnalytics || cfg.Store == nil || call.Name != toolRun {
+				return next(ctx, call)
+			}
+			if args, mErr := parseRunArgs(call); mErr == nil {
+				if err := recordPromptFeatures(cfg.Store, call.Tenant, extractFeatures(args.Message, args.Files), time.Now()); err != nil {
+					log.Printf("[analytics] not recorded: %v", err)
+				}
+			}
+			return next(ctx, call)
+		}
+	}
+}
+
+// analyticsNoise returns the noise added to the stored count value of
+// name in tenant's day.
+type analyticsNoise func(tenant, day, name string, value int) float64
+
+// analyticsReport sums the days since since, for one tenant or all, after
+// adding noise to each stored count.
+func analyticsReport(s *store, tenant string, since time.Time, noise analyticsNoise) analyticsDay {
+	sums := map[string]float64{}
+	total := analyticsDay{Tenant: tenant, Day: localTime(since).Format("2006-01-02"),
+		Length: map[string]int{}, Language: map[string]int{}, Attachments: map[string]int{}, Category: map[string]int{}}
+	prefix := ""
+	if tenant != "" {
+		prefix = tenant + "/"
+	}
+	for _, doc := range s.list(analyticsCollection, prefix) {
+		var d analyticsDay
+		if json.Unmarshal(doc, &d) != nil || d.Day < total.Day {
+			continue
+		}
+		sums["runs"] += float64(d.Runs) + noise(d.Tenant, d.Day, "runs", d.Runs)
+		for _, h := range []struct {
+			name string
+			m    map[string]int
+		}{{"length", d.Length}, {"language", d.Language}, {"attachments", d.Attachments}, {"category", d.Category}} {
+			for k, v := range h.m {
+				name := h.name + "/" + k
+				sums[name] += float64(v) + noise(d.Tenant, d.Day, name, v)
+			}
+		}
+	}
+	histograms := map[string]map[string]int{"length": total.Length, "language": total.Language, "attachments": total.Attachments, "category": total.Category}
+	for name, sum := range sums {
+		v := max(0, int(math.Round(sum)))
+		if histogram, bucket, ok := strings.Cut(name, "/"); ok {
+			histograms[histogram][bucket] = v
+		} else {
+			total.Runs = v
+		}
+	}
+	return total
+}
+
+// laplaceNoise returns noise drawn from Laplace(0, sensitivity/epsilon) by
+// an HMAC of the count under key, so each stored count value always gets
+// the same draw. A day still being counted gets a new draw when its count
+// changes, not when it is read again.
+func laplaceNoise(key []byte) analyticsNoise {
+	return func(tenant, day, name string, value int) float64 {
+		mac := hmac.New(sha256.New, key)
+		fmt.Fprintf(mac, "%s\x00%s\x00%s\x00%d", tenant, day, name, value)
+		// A uniform value in (0, 1), shifted to (-0.5, 0.5)
+		u := (float64(binary.BigEndian.Uint64(mac.Sum(nil))>>11)+0.5)/(1<<53) - 0.5
+		return -math.Copysign(1, u) * math.Log(1-2*math.Abs(u)) * analyticsSensitivity / analyticsEpsilon
+	}
+}
+
+// analyticsKey returns the secret the report noise is derived from,
+// creating it on first use. It lives in the store, so all instances and
+// restarts add the same noise.
+func analyticsKey(s *store) ([]byte, error) {
+	analyticsMu.Lock()
+	defer analyticsMu.Unlock()
+	var doc struct {
+		Key string `json:"key"`
+	}
+	found, err := s.get(analyticsKeyCollection, "noise", &doc)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		doc.Key = hex.EncodeToString(b)
+		if err := s.put(analyticsKeyCollection, "noise", doc); err != nil {
+			return nil, err
+		}
+	}
+	return hex.DecodeString(doc.Key)
+}
+
+// registerAnalyticsRoutes adds GET /admin/analytics?tenant=&since=30d.
+func registerAnalyticsRoutes(mux *http.ServeMux, cfg serverConfig, admin func(http.HandlerFunc) http.HandlerFunc) {
+	mux.HandleFunc("GET /admin/analytics", admin(func(w http.ResponseWriter, r *http.Request) {
+		if !cfg.PromptAnalytics || cfg.Store == nil {
+			http.Error(w, "prompt analytics are off (MCP_PROMPT_ANALYTICS, MCP_STORE_PATH)", http.StatusNotFound)
+			return
+		}
+		q := r.URL.Query().Get("since")
+		if q == "" {
+			q = "30d"
+		}
+		since, err := parseSince(q, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		key, err := analyticsKey(cfg.Store)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, analyticsReport(cfg.Store, r.URL.Query().Get("tenant"), since, laplaceNoise(key)))
+	}))
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test prompts are reduced to their features
+func TestExtractFeatures(t *testing.T) {
+	tests := []struct {
+		message string
+		files   []string
+		want    promptFeatures
+	}{
+		{"fix the failing tests in the parser", nil, promptFeatures{"0-99", "en", false, "fix"}},
+		{"explain what this function does", []string{"a.go"}, promptFeatures{"0-99", "en", true, "explain"}},
+		{"请修复这个错误", nil, promptFeatures{"0-99", "zh", false, "fix"}},
+		{"implement CSV export for the reports page", nil, promptFeatures{"0-99", "en", false, "feature"}},
+		{"¿qué hace la función de los pagos?", nil, promptFeatures{"0-99", "es", false, "other"}},
+		{strings.Repeat("x", 600), nil, promptFeatures{"500-1999", "en", false, "other"}},
+		{"", nil, promptFeatures{"0-99", "unknown", false, "other"}},
+	}
+	for _, tt := range tests {
+		if got := extractFeatures(tt.message, tt.files); got != tt.want {
+			t.Errorf("extractFeatures(%.20q) = %+v, want %+v", tt.message, got, tt.want)
+		}
+	}
+}
+
+// Test runs are counted without their text, and reports are noised
+func TestPromptAnalytics(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "opencode")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho '{\"type\":\"text\",\"part\":{\"text\":\"ok\"}}'\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	st, _ := openStore("")
+	cfg := serverConfig{Target: script, DefaultTimeout: 5 * time.Second, Store: st, PromptAnalytics: true}
+	tools := newToolHandler(cfg)
+	for _, msg := range []string{"fix the secret-project crash", "write a test for the login flow"} {
+		if _, mErr := tools(context.Background(), &toolCall{Name: toolRun, Tenant: "team-a", Arguments: []byte(`{"message":"` + msg + `"}`)}); mErr != nil {
+			t.Fatal(mErr)
+		}
+	}
+	docs := st.list(analyticsCollection, "")
+	if len(docs) != 1 {
+		t.Fatalf("got %d analytics docs", len(docs))
+	}
+	if strings.Contains(string(docs[0]), "secret") {
+		t.Errorf("prompt text stored: %s", docs[0])
+	}
+
+	exact := analyticsReport(st, "team-a", time.Now().Add(-24*time.Hour), noNoise)
+	if exact.Runs != 2 || exact.Category["fix"] != 1 || exact.Category["test"] != 1 || exact.Attachments["no"] != 2 {
+		t.Errorf("report = %+v", exact)
+	}
+	if other := analyticsReport(st, "team-b", time.Now().Add(-24*time.Hour), noNoise); other.Runs != 0 {
+		t.Errorf("team-b sees team-a's runs: %+v", other)
+	}
+	noisy := analyticsReport(st, "", time.Now().Add(-24*time.Hour), func(string, string, string, int) float64 { return -5 })
+	if noisy.Runs != 0 || noisy.Category["fix"] != 0 {
+		t.Errorf("noise not applied or not clamped: %+v", noisy)
+	}
+
+	// Reading again adds the same noise, so averaging reads gains nothing.
+	key, err := analyticsKey(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := analyticsKey(st); string(again) != string(key) {
+		t.Error("analytics key changed between reads")
+	}
+	first := analyticsReport(st, "team-a", time.Now().Add(-24*time.Hour), laplaceNoise(key))
+	for i := 0; i < 10; i++ {
+		if r := analyticsReport(st, "team-a", time.Now().Add(-24*time.Hour), laplaceNoise(key)); !reflect.DeepEqual(r, first) {
+			t.Fatalf("report %d = %+v, first = %+v", i, r, first)
+		}
+	}
+	noise := laplaceNoise(key)
+	if noise("team-a", "2024-01-01", "runs", 2) == noise("team-a", "2024-01-02", "runs", 2) {
+		t.Error("days share their noise")
+	}
+	var sum float64
+	for v := 0; v < 10000; v++ {
+		sum += math.Abs(noise("team-a", "2024-01-01", "runs", v))
+	}
+	// The mean absolute value of Laplace(0, b) is b.
+	if b := sum / 10000; b < analyticsSensitivity/analyticsEpsilon*0.9 || b > analyticsSensitivity/analyticsEpsilon*1.1 {
+		t.Errorf("noise scale = %.2f, want %.0f", b, analyticsSensitivity/analyticsEpsilon)
+	}
+}
+
+func noNoise(string, string, string, int) float64 { return 0 }
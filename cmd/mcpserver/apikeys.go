@@ -0,0 +1,187 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Requests are assigned to a tenant by their API key (X-API-Key or a bearer
+// token): keys are listed by SHA-256 digest in the tenant's "apiKeys" in
+// the config file, or issued through the admin API and kept in the store.
+// The X-MCP-Tenant header is only believed with MCP_TRUST_TENANT_HEADER,
+// for deployments behind an authenticating proxy that sets it; otherwise
+// requests without a known key belong to the default tenant.
+const (
+	apiKeysCollection = "api_keys"
+
+	// apiKeyPrefix marks the keys the admin API issues.
+	apiKeyPrefix = "ocm_"
+)
+
+var apiKeyDigestRe = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// apiKey is an issued key. Only its digest is stored; the key itself is
+// shown once, when it is issued.
+type apiKey struct {
+	ID      string    `json:"id"` // the digest's first 12 digits, for listing and revoking
+	Tenant  string    `json:"tenant"`
+	Name    string    `json:"name,omitempty"`
+	Digest  string    `json:"digest"` // SHA-256 of the key, hex
+	Created time.Time `json:"created"`
+}
+
+func apiKeyDigest(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// tenantForKey returns the tenant the API key belongs to.
+func (cfg serverConfig) tenantForKey(key string) (string, bool) {
+	digest := apiKeyDigest(key)
+	for name, p := range cfg.Tenants {
+		for _, d := range p.APIKeys {
+			if strings.EqualFold(d, digest) {
+				return name, true
+			}
+		}
+	}
+	if cfg.Store != nil {
+		var k apiKey
+		if ok, err := cfg.Store.get(apiKeysCollection, digest, &k); err == nil && ok {
+			return k.Tenant, true
+		}
+	}
+	return "", false
+}
+
+// authenticateTenant returns the tenant of r: the tenant of its API key,
+// else the X-MCP-Tenant header when trusted, else defaultTenant.
+func (cfg serverConfig) authenticateTenant(r *http.Request) string {
+	if key := apiKeyFromRequest(r); key != "" {
+		if tenant, ok := cfg.tenantForKey(key); ok {
+			return tenant
+		}
+	}
+	if cfg.TrustTenant {
+		return tenantFromRequest(r)
+	}
+	return defaultTenant
+}
+
+// tenantAuth replaces the X-MCP-Tenant header of every request with the
+// authenticated tenant, which tenantFromRequest then reads.
+func tenantAuth(cfg serverConfig, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant := cfg.authenticateTenant(r)
+		if r.Header.Get(tenantHeader) != tenant {
+			r = r.Clone(r.Context())
+			r.Header.Set(tenantHeader, tenant)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// issueAPIKey creates a key for tenant and stores its digest.
+func issueAPIKey(st *store, tenant, name string) (string, apiKey, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", apiKey{}, err
+	}
+	key := apiKeyPrefix + hex.EncodeToString(b)
+	k := apiKey{Tenant: tenant, Name: name, Digest: apiKeyDigest(key), Created: time.Now().UTC()}
+	k.ID = k.Digest[:12]
+	return key, k, st.put(apiKeysCollection, k.Digest, k)
+}
+
+// listAPIKeys returns the issued keys, oldest first.
+func listAPIKeys(st *store) []apiKey {
+	keys := []apiKey{}
+	for _, doc := range st.list(apiKeysCollection, "") {
+		var k apiKey
+		if json.Unmarshal(doc, &k) == nil {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Created.Before(keys[j].Created) })
+	return keys
+}
+
+// registerAPIKeyRoutes adds the admin API issuing and revoking API keys.
+func registerAPIKeyRoutes(mux *http.ServeMux, cfg serverConfig, admin func(http.HandlerFunc) http.HandlerFunc) {
+	if cfg.Store == nil {
+		return
+	}
+	mux.HandleFunc("GET /admin/keys", admin(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"keys": listAPIKeys(cfg.Store)})
+	}))
+	mux.HandleFunc("POST /admin/keys", admin(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Tenant string `json:"tenant"`
+			Name   string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !tenantNameRe.MatchString(req.Tenant) {
+			http.Error(w, `want {"tenant": "<tenant>", "name": "<what the key is for>"}`, http.StatusBadRequest)
+			return
+		}
+		if _, ok := cfg.Tenants[defaultTenant]; !ok {
+			http.Error(w, "configure tenants.default in MCP_CONFIG first: it is the policy of requests without a known key", http.StatusConflict)
+			return
+		}
+		key, k, err := issueAPIKey(cfg.Store, req.Tenant, req.Name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("[keys] issued %s for tenant %s", k.ID, k.Tenant)
+		writeJSON(w, http.StatusCreated, map[string]any{"key": key, "id": k.ID, "tenant": k.Tenant, "name": k.Name})
+	}))
+	mux.HandleFunc("DELETE /admin/keys/{id}", admin(func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		for _, k := range listAPIKeys(cfg.Store) {
+			if k.ID != id {
+				continue
+			}
+			if _, err := cfg.Store.delete(apiKeysCollection, k.Digest); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			log.Printf("[keys] revoked %s of tenant %s", k.ID, k.Tenant)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		http.Error(w, "unknown key", http.StatusNotFound)
+	}))
+}
+
+// validateAPIKeyDigests checks the apiKeys of the tenants in the config
+// file: SHA-256 digests, each of one tenant. Tenants with keys need a
+// default tenant, whose policy applies to requests without a known key;
+// otherwise those would run without any allowlist or quota.
+func validateAPIKeyDigests(tenants map[string]tenantPolicy) error {
+	owner := map[string]string{}
+	for name, p := range tenants {
+		for i, d := range p.APIKeys {
+			if !apiKeyDigestRe.MatchString(strings.ToLower(d)) {
+				return fmt.Errorf("tenants.%s.apiKeys[%d]: want the key's SHA-256 digest in hex", name, i)
+			}
+			if other, ok := owner[strings.ToLower(d)]; ok && other != name {
+				return fmt.Errorf("tenants.%s.apiKeys[%d]: also a key of tenant %s", name, i, other)
+			}
+			owner[strings.ToLower(d)] = name
+		}
+	}
+	if _, ok := tenants[defaultTenant]; len(owner) > 0 && !ok {
+		return errors.New("tenants.default: required once tenants have apiKeys, as the policy of requests without a known key")
+	}
+	return nil
+}
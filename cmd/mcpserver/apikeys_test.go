@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Test requests get the tenant of their API key, and X-MCP-Tenant only
+// when it is trusted
+func TestTenantAuth(t *testing.T) {
+	st, _ := openStore("")
+	cfg := serverConfig{Store: st, Tenants: map[string]tenantPolicy{"team-a": {APIKeys: []string{strings.ToUpper(apiKeyDigest("key-a"))}}}}
+	mux := http.NewServeMux()
+	registerAPIKeyRoutes(mux, cfg, func(h http.HandlerFunc) http.HandlerFunc { return h })
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/keys", strings.NewReader(`{"tenant":"team-b","name":"ci"}`)))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("issue without a default tenant: %d", rec.Code)
+	}
+
+	cfg.Tenants[defaultTenant] = tenantPolicy{AllowedDirs: []string{"/nowhere"}}
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write([]byte(tenantFromRequest(r))) })
+	mux = http.NewServeMux()
+	mux.Handle("/", echo)
+	registerAPIKeyRoutes(mux, cfg, func(h http.HandlerFunc) http.HandlerFunc { return h })
+	tenantOf := func(cfg serverConfig, headers map[string]string) string {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		tenantAuth(cfg, mux).ServeHTTP(rec, req)
+		return rec.Body.String()
+	}
+
+	// Issue a key for team-b through the admin API
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/keys", strings.NewReader(`{"tenant":"team-b","name":"ci"}`)))
+	var issued struct {
+		Key string `json:"key"`
+		ID  string `json:"id"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&issued); err != nil || rec.Code != http.StatusCreated || !strings.HasPrefix(issued.Key, apiKeyPrefix) {
+		t.Fatalf("issue: %d %+v %v", rec.Code, issued, err)
+	}
+	if keys := listAPIKeys(st); len(keys) != 1 || keys[0].ID != issued.ID || strings.Contains(keys[0].Digest, issued.Key) {
+		t.Errorf("keys = %+v", keys)
+	}
+
+	trusted := cfg
+	trusted.TrustTenant = true
+	tests := []struct {
+		name    string
+		cfg     serverConfig
+		headers map[string]string
+		want    string
+	}{
+		{"config key", cfg, map[string]string{"X-API-Key": "key-a"}, "team-a"},
+		{"issued key", cfg, map[string]string{"Authorization": "Bearer " + issued.Key}, "team-b"},
+		{"key over header", trusted, map[string]string{"X-API-Key": "key-a", tenantHeader: "team-b"}, "team-a"},
+		{"untrusted header", cfg, map[string]string{tenantHeader: "team-a"}, defaultTenant},
+		{"unknown key", cfg, map[string]string{"X-API-Key": "nope", tenantHeader: "team-a"}, defaultTenant},
+		{"trusted header", trusted, map[string]string{tenantHeader: "team-c"}, "team-c"},
+		{"nothing", cfg, nil, defaultTenant},
+	}
+	for _, tt := range tests {
+		if got := tenantOf(tt.cfg, tt.headers); got != tt.want {
+			t.Errorf("%s: tenant %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/admin/keys/"+issued.ID, nil))
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("revoke: %d", rec.Code)
+	}
+	if got := tenantOf(cfg, map[string]string{"X-API-Key": issued.Key}); got != defaultTenant {
+		t.Errorf("revoked key: tenant %q", got)
+	}
+}
+
+// Test config file key digests are validated
+func TestValidateAPIKeyDigests(t *testing.T) {
+	digest := apiKeyDigest("k")
+	bad := []map[string]tenantPolicy{
+		{"team-a": {APIKeys: []string{"k"}}},
+		{"team-a": {APIKeys: []string{digest}}, "team-b": {APIKeys: []string{digest}}, defaultTenant: {}},
+		{"team-a": {APIKeys: []string{digest}}}, // no default tenant for requests without a key
+	}
+	for _, tenants := range bad {
+		if err := validateTenantPolicies(tenants); err == nil {
+			t.Errorf("validateTenantPolicies(%+v) = nil, want error", tenants)
+		}
+	}
+	if err := validateTenantPolicies(map[string]tenantPolicy{"team-a": {APIKeys: []string{digest}}, defaultTenant: {}}); err != nil {
+		t.Errorf("valid digest: %v", err)
+	}
+}
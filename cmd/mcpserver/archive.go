@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Runs and the opencode sessions they belong to are archived rather than
+// deleted: an archived run is hidden from GET /runs, opencode_history and
+// resources/list unless asked for, is skipped when continue picks a session,
+// and is purged by the janitor once MCP_ARCHIVE_RETENTION_HOURS have passed.
+// Until then it can be restored unchanged.
+
+const (
+	defaultArchiveRetention = 30 * 24 * time.Hour
+
+	// Values of the archived filter of GET /runs and opencode_history;
+	// without one archived runs are hidden.
+	archivedOnly    = "only"
+	archivedInclude = "include"
+)
+
+func validateArchivedFilter(s string) error {
+	if s != "" && s != archivedOnly && s != archivedInclude {
+		return fmt.Errorf("invalid archived %q (want %q or %q)", s, archivedOnly, archivedInclude)
+	}
+	return nil
+}
+
+func (rec runRecord) archived() bool {
+	return rec.ArchivedAt != nil
+}
+
+// setArchived archives or restores the tenant's run id, reporting false
+// when there is no such run.
+func (h runHistory) setArchived(tenant, id string, archived bool) (runRecord, bool, error) {
+	rec, ok, err := h.get(tenant, id)
+	if !ok || err != nil {
+		return rec, ok, err
+	}
+	if rec.archived() == archived {
+		return rec, true, nil
+	}
+	rec.ArchivedAt = nil
+	if archived {
+		now := localTime(time.Now())
+		rec.ArchivedAt = &now
+	}
+	return rec, true, h.store.put(runsCollection, runKey(rec.Tenant, rec.ID), rec)
+}
+
+// setSessionArchived archives or restores every run of the tenant's
+// opencode session, returning how many runs it has.
+func (h runHistory) setSessionArchived(tenant, session string, archived bool) (int, error) {
+	runs, err := h.query(tenant, runFilter{Archived: archivedInclude, Limit: -1})
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, rec := range runs {
+		if rec.Session != session {
+			continue
+		}
+		if _, _, err := h.setArchived(tenant, rec.ID, archived); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// continueSession picks the session continue resumes in cwd when the one
+// opencode would pick is archived: the latest recorded session there that
+// isn't, or "" for a new one. override is false when opencode's own choice
+// stands, i.e. the latest run recorded in cwd isn't archived.
+func (h runHistory) continueSession(tenant, cwd string) (session string, override bool) {
+	runs, err := h.query(tenant, runFilter{Cwd: cwd, Archived: archivedInclude, Limit: -1})
+	if err != nil {
+		return "", false
+	}
+	for _, rec := range runs { // newest first
+		if rec.Session == "" {
+			continue
+		}
+		if !rec.archived() {
+			return rec.Session, override
+		}
+		override = true
+	}
+	return "", override
+}
+
+// purgeArchived deletes the runs, with their transcripts, that were
+// archived more than retention ago.
+func purgeArchived(st *store, retention time.Duration, now time.Time) {
+	if st == nil || retention <= 0 {
+		return
+	}
+	purged := 0
+	for _, doc := range st.list(runsCollection, "") {
+		var rec runRecord
+		if err := json.Unmarshal(doc, &rec); err != nil || !rec.archived() || now.Sub(*rec.ArchivedAt) < retention {
+			continue
+		}
+		key := runKey(rec.Tenant, rec.ID)
+		if _, err := st.delete(runsCollection, key); err != nil {
+			log.Printf("[janitor] purge run %s: %v", key, err)
+			return
+		}
+		if _, err := st.delete(transcriptsCollection, key); err != nil {
+			log.Printf("[janitor] purge transcript %s: %v", key, err)
+		}
+		purged++
+	}
+	if purged > 0 {
+		log.Printf("[janitor] purged %d runs archived over %s ago", purged, retention)
+	}
+}
+
+// registerArchiveRoutes adds POST /runs/{id}/archive and /restore, and the
+// same for /sessions/{id}, which cover every run of an opencode session.
+func registerArchiveRoutes(mux *http.ServeMux, cfg serverConfig) {
+	history := runHistory{cfg.Store}
+
+	for action, archived := range map[string]bool{"archive": true, "restore": false} {
+		mux.HandleFunc("POST /runs/{id}/"+action, func(w http.ResponseWriter, r *http.Request) {
+			rec, ok, err := history.setArchived(tenantFromRequest(r), r.PathValue("id"), archived)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				http.Error(w, "run not found", http.StatusNotFound)
+				return
+			}
+			writeJSON(w, http.StatusOK, rec)
+		})
+		mux.HandleFunc("POST /sessions/{id}/"+action, func(w http.ResponseWriter, r *http.Request) {
+			id := r.PathValue("id")
+			n, err := history.setSessionArchived(tenantFromRequest(r), id, archived)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if n == 0 {
+				http.Error(w, "session not found", http.StatusNotFound)
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]any{"session": id, "archived": archived, "runs": n})
+		})
+	}
+}
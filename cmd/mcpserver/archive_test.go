@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Test archived runs and sessions are hidden, skipped by continue, restored
+// and purged after the retention
+func TestArchive(t *testing.T) {
+	st, _ := openStore("")
+	h := runHistory{st}
+	start := time.Now().Add(-time.Hour)
+	for i, r := range []runRecord{
+		{ID: "r1", Session: "ses_old", Cwd: "/repo"},
+		{ID: "r2", Session: "ses_new", Cwd: "/repo"},
+		{ID: "r3", Session: "ses_new", Cwd: "/repo"},
+	} {
+		r.Tenant, r.StartedAt = "team-a", start.Add(time.Duration(i)*time.Minute)
+		if err := h.record(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, override := h.continueSession("team-a", "/repo"); override {
+		t.Error("continue overridden with no archived session")
+	}
+
+	mux := http.NewServeMux()
+	registerRunRoutes(mux, serverConfig{Store: st})
+	registerArchiveRoutes(mux, serverConfig{Store: st})
+	do := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set(tenantHeader, "team-a")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+	listed := func(query string) []string {
+		var runs []runRecord
+		if err := json.Unmarshal(do(http.MethodGet, "/runs"+query).Body.Bytes(), &runs); err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, r := range runs {
+			ids = append(ids, r.ID)
+		}
+		return ids
+	}
+
+	rec := do(http.MethodPost, "/sessions/ses_new/archive")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("archive session = %d %s", rec.Code, rec.Body.String())
+	}
+	if ids := listed(""); len(ids) != 1 || ids[0] != "r1" {
+		t.Errorf("GET /runs = %v, want archived runs hidden", ids)
+	}
+	if ids := listed("?archived=only"); len(ids) != 2 {
+		t.Errorf("GET /runs?archived=only = %v", ids)
+	}
+	if ids := listed("?archived=include"); len(ids) != 3 {
+		t.Errorf("GET /runs?archived=include = %v", ids)
+	}
+	if rec := do(http.MethodGet, "/runs?archived=yes"); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid archived = %d, want 400", rec.Code)
+	}
+	if rec := do(http.MethodGet, "/runs/r2"); rec.Code != http.StatusOK {
+		t.Errorf("GET archived run = %d, want 200", rec.Code)
+	}
+	if session, override := h.continueSession("team-a", "/repo"); !override || session != "ses_old" {
+		t.Errorf("continueSession = %q, %v, want ses_old", session, override)
+	}
+
+	if rec := do(http.MethodPost, "/runs/r1/archive"); rec.Code != http.StatusOK {
+		t.Fatalf("archive run = %d", rec.Code)
+	}
+	if session, override := h.continueSession("team-a", "/repo"); !override || session != "" {
+		t.Errorf("continueSession = %q, %v, want a new session", session, override)
+	}
+	if rec := do(http.MethodPost, "/runs/r1/restore"); rec.Code != http.StatusOK {
+		t.Fatalf("restore run = %d", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/runs/missing/archive"); rec.Code != http.StatusNotFound {
+		t.Errorf("archive missing run = %d, want 404", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/sessions/missing/restore"); rec.Code != http.StatusNotFound {
+		t.Errorf("restore missing session = %d, want 404", rec.Code)
+	}
+
+	purgeArchived(st, time.Hour, time.Now())
+	if ids := listed("?archived=include"); len(ids) != 3 {
+		t.Errorf("purged before the retention: %v", ids)
+	}
+	purgeArchived(st, time.Hour, time.Now().Add(2*time.Hour))
+	if ids := listed("?archived=include"); len(ids) != 1 || ids[0] != "r1" {
+		t.Errorf("after purge = %v, want only the restored run", ids)
+	}
+}
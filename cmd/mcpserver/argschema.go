@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+
+	"opencode-mcp/internal/mcp"
+)
+
+// schemaMiddleware checks tool arguments against the input schema the tool
+// declares in tools/list, so malformed or unknown arguments are rejected
+// with the JSON pointer of the first offending one instead of being
+// half-ignored. Unknown tools are left to dispatch to report.
+func schemaMiddleware(cfg serverConfig) toolMiddleware {
+	return func(next toolHandler) toolHandler {
+		return func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+			schema, ok := inputSchema(cfg.forTenant(call.Tenant), call.Name)
+			if !ok {
+				return next(ctx, call)
+			}
+			if verr := mcp.ValidateArguments(schema, call.Arguments); verr != nil {
+				mErr := errInvalidArguments.err("invalid arguments: " + verr.Error())
+				mErr.Data.Pointer = verr.Pointer
+				return nil, mErr
+			}
+			return next(ctx, call)
+		}
+	}
+}
+
+// inputSchema returns the input schema of the named tool.
+func inputSchema(cfg serverConfig, name string) (any, bool) {
+	for _, t := range toolDefinitions(cfg) {
+		if t.Name == name {
+			return t.InputSchema, true
+		}
+	}
+	return nil, false
+}
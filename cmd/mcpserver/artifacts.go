@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"opencode-mcp/internal/mcp"
+)
+
+const (
+	defaultArtifactMaxBytes  = 100 << 20 // per run
+	defaultArtifactRetention = 7 * 24 * time.Hour
+	defaultArtifactTotalMB   = 10 << 10
+	maxArtifactsPerRun       = 100
+	janitorInterval          = time.Hour
+	janitorLease             = "janitor"
+
+	// artifactEnv tells the child process where to put its artifacts.
+	artifactEnv = "MCP_ARTIFACT_DIR"
+)
+
+// artifactInfo describes one file captured from a run's artifact directory.
+type artifactInfo struct {
+	Name     string `json:"name"` // slash-separated path inside the run directory
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	URI      string `json:"uri"`
+}
+
+// artifactConfig holds the artifact store settings.
+type artifactConfig struct {
+	Root          string // empty disables artifact capture
+	MaxBytes      int64  // per run
+	TotalMaxBytes int64  // of all runs; 0 is unlimited
+	Retention     time.Duration
+	PublicURL     string // base URL used in resource links
+	Compress      bool   // files are gzipped (MCP_COMPRESSION)
+}
+
+// artifactDir returns the directory of one run's artifacts.
+func (c artifactConfig) artifactDir(tenant, runID string) string {
+	return filepath.Join(c.Root, tenantOrDefault(tenant), runID)
+}
+
+func (c artifactConfig) artifactURI(runID, name string) string {
+	return strings.TrimRight(c.PublicURL, "/") + "/artifacts/" + url.PathEscape(runID) + "/" + escapeArtifactName(name)
+}
+
+func escapeArtifactName(name string) string {
+	parts := strings.Split(name, "/")
+	for i, p := range parts {
+		parts[i] = url.PathEscape(p)
+	}
+	return strings.Join(parts, "/")
+}
+
+// artifactMiddleware gives every opencode_run an artifact directory: the
+// prompt tells the agent to write files that don't belong in the repository
+// there, and after the run the files are captured and returned as resource
+// links, or as image content for images up to mcp.MaxImageBytes.
+func artifactMiddleware(cfg serverConfig) toolMiddleware {
+	return func(next toolHandler) toolHandler {
+		return func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+			if call.Name != toolRun || cfg.Artifacts.Root == "" {
+				return next(ctx, call)
+			}
+			if call.RunID == "" {
+				call.RunID = generateSessionID()
+			}
+			dir := cfg.Artifacts.artifactDir(call.Tenant, call.RunID)
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				log.Printf("[artifacts] run=%s: %v", call.RunID, err)
+				return next(ctx, call)
+			}
+			if args, ok := withArtifactInstruction(call.Arguments, dir); ok {
+				call.Arguments = args
+			}
+			call.ArtifactDir = dir
+
+			result, mErr := next(ctx, call)
+
+			artifacts := captureArtifacts(cfg.Artifacts, call.RunID, dir)
+			if len(artifacts) == 0 {
+				_ = os.Remove(dir) // only succeeds when empty
+			}
+			if result != nil {
+				result.artifacts = artifacts
+				for _, a := range artifacts {
+					result.Content = append(result.Content, artifactContent(dir, a))
+				}
+			}
+			return result, mErr
+		}
+	}
+}
+
+// inlineImageTypes are the artifact types returned as image content.
+var inlineImageTypes = map[string]bool{"image/png": true, "image/jpeg": true, "image/gif": true, "image/webp": true}
+
+// artifactContent returns the result content of the artifact a in dir:
+// the image itself when it is small enough, else a link to it. Images are
+// never gzipped, so they are read as written.
+func artifactContent(dir string, a artifactInfo) toolContent {
+	if inlineImageTypes[a.MimeType] && a.Size <= mcp.MaxImageBytes {
+		if b, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(a.Name))); err == nil {
+			return mcp.ImageContent(a.MimeType, base64.StdEncoding.EncodeToString(b))
+		}
+	}
+	return mcp.ResourceLink(a.URI, a.Name, a.MimeType)
+}
+
+// withArtifactInstruction appends the artifact directory to the run message.
+func withArtifactInstruction(raw json.RawMessage, dir string) (json.RawMessage, bool) {
+	var args map[string]any
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return raw, false
+	}
+	msg, _ := args["message"].(string)
+	if msg == "" {
+		return raw, false
+	}
+	args["message"] = msg + "\n\nIf you produce files for the user that don't belong in the repository (reports, exports, archives), write them to " + dir + " instead."
+	out, err := json.Marshal(args)
+	if err != nil {
+		return raw, false
+	}
+	return out, true
+}
+
+// captureArtifacts lists the files written to dir, deleting those beyond the
+// per-run file count and size limits.
+func captureArtifacts(c artifactConfig, runID, dir string) []artifactInfo {
+	type file struct {
+		path string
+		info fs.FileInfo
+	}
+	var files []file
+	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type()&fs.ModeSymlink != 0 {
+			log.Printf("[artifacts] run=%s removing symlink %s", runID, path)
+			_ = os.Remove(path)
+			return nil
+		}
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			files = append(files, file{path, info})
+		}
+		return nil
+	})
+	sort.Slice(files, func(i, j int) bool { return files[i].path < files[j].path })
+
+	var artifacts []artifactInfo
+	var total int64
+	for _, f := range files {
+		rel, _ := filepath.Rel(dir, f.path)
+		name := filepath.ToSlash(rel)
+		if len(artifacts) >= maxArtifactsPerRun || total+f.info.Size() > c.MaxBytes {
+			log.Printf("[artifacts] run=%s dropping %s (%d bytes): over the per-run limit", runID, name, f.info.Size())
+			_ = os.Remove(f.path)
+			continue
+		}
+		total += f.info.Size()
+		mimeType := mime.TypeByExtension(filepath.Ext(name))
+		if mimeType == "" {
+			mimeType = "application/octet-stream"
+		}
+		artifacts = append(artifacts, artifactInfo{
+			Name:     name,
+			Size:     f.info.Size(),
+			MimeType: mimeType,
+			URI:      c.artifactURI(runID, name),
+		})
+		if c.Compress {
+			compressionStats.record("artifacts", f.info.Size(), compressArtifact(f.path, mimeType, f.info.Size()))
+		}
+	}
+	if len(artifacts) > 0 {
+		log.Printf("[artifacts] run=%s captured %d files (%d bytes)", runID, len(artifacts), total)
+	}
+	return artifacts
+}
+
+// pruneArtifacts removes run directories older than the retention period,
+// then the oldest ones while the total exceeds TotalMaxBytes.
+func pruneArtifacts(c artifactConfig, now time.Time) {
+	tenants, err := os.ReadDir(c.Root)
+	if err != nil {
+		return
+	}
+	type runDir struct {
+		path     string
+		size     int64
+		modified time.Time
+	}
+	var kept []runDir
+	var total int64
+	for _, t := range tenants {
+		if !t.IsDir() {
+			continue
+		}
+		runs, _ := os.ReadDir(filepath.Join(c.Root, t.Name()))
+		for _, r := range runs {
+			info, err := r.Info()
+			if err != nil {
+				continue
+			}
+			path := filepath.Join(c.Root, t.Name(), r.Name())
+			if c.Retention <= 0 || now.Sub(info.ModTime()) < c.Retention {
+				size := dirSize(path)
+				kept = append(kept, runDir{path, size, info.ModTime()})
+				total += size
+				continue
+			}
+			if err := os.RemoveAll(path); err != nil {
+				log.Printf("[artifacts] prune %s: %v", path, err)
+				continue
+			}
+			log.Printf("[artifacts] pruned %s", path)
+		}
+	}
+	if c.TotalMaxBytes <= 0 || total <= c.TotalMaxBytes {
+		return
+	}
+	sort.Slice(kept, func(i, j int) bool { return kept[i].modified.Before(kept[j].modified) })
+	for _, d := range kept {
+		if total <= c.TotalMaxBytes {
+			break
+		}
+		if err := os.RemoveAll(d.path); err != nil {
+			log.Printf("[artifacts] prune %s: %v", d.path, err)
+			continue
+		}
+		total -= d.size
+		log.Printf("[artifacts] pruned %s (%d bytes): over the %d MB total cap", d.path, d.size, c.TotalMaxBytes>>20)
+	}
+}
+
+func dirSize(dir string) int64 {
+	var n int64
+	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				n += info.Size()
+			}
+		}
+		return nil
+	})
+	return n
+}
+
+// registerArtifactRoutes adds GET /artifacts/{run}/{name...}.
+func registerArtifactRoutes(mux *http.ServeMux, cfg serverConfig) {
+	mux.HandleFunc("GET /artifacts/{run}/{name...}", func(w http.ResponseWriter, r *http.Request) {
+		if cfg.Artifacts.Root == "" {
+			http.Error(w, "artifacts disabled", http.StatusNotFound)
+			return
+		}
+		runID, name := r.PathValue("run"), r.PathValue("name")
+		if !isSafeArtifactPath(runID) || !isSafeArtifactPath(name) {
+			http.Error(w, "invalid artifact path", http.StatusBadRequest)
+			return
+		}
+		dir := cfg.Artifacts.artifactDir(tenantFromRequest(r), runID)
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		compressed := false
+		f, info, err := openArtifact(dir, name)
+		if os.IsNotExist(err) {
+			f, info, err = openArtifact(dir, name+artifactGzipSuffix)
+			compressed = true
+		}
+		if err != nil {
+			http.Error(w, "artifact not found", http.StatusNotFound)
+			return
+		}
+		defer f.Close()
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(path)))
+		if compressed {
+			mimeType := mime.TypeByExtension(filepath.Ext(path))
+			if mimeType == "" {
+				mimeType = "application/octet-stream"
+			}
+			serveCompressedArtifact(w, r, f, mimeType)
+			return
+		}
+		http.ServeContent(w, r, filepath.Base(path), info.ModTime(), f)
+	})
+}
+
+// openArtifact opens the regular file name in the artifact directory dir.
+// A run can leave symlinks there, so no component of name may be one.
+func openArtifact(dir, name string) (*os.File, fs.FileInfo, error) {
+	path := dir
+	var info fs.FileInfo
+	for _, part := range strings.Split(name, "/") {
+		path = filepath.Join(path, part)
+		var err error
+		if info, err = os.Lstat(path); err != nil {
+			return nil, nil, err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return nil, nil, fmt.Errorf("%s is a symlink", path)
+		}
+	}
+	if !info.Mode().IsRegular() {
+		return nil, nil, fmt.Errorf("%s is not a regular file", path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The file must still be the one checked above
+	opened, err := f.Stat()
+	if err != nil || !os.SameFile(info, opened) {
+		f.Close()
+		return nil, nil, fmt.Errorf("%s changed while being opened", path)
+	}
+	return f, opened, nil
+}
+
+// isSafeArtifactPath rejects absolute paths and any ".." component.
+func isSafeArtifactPath(p string) bool {
+	if p == "" || strings.HasPrefix(p, "/") || strings.Contains(p, "\\") {
+		return false
+	}
+	for _, part := range strings.Split(p, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
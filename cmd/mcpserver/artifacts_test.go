@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test files written to $MCP_ARTIFACT_DIR come back as resource links, and
+// small images as image content
+func TestArtifactCapture(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "opencode")
+	content := `#!/bin/sh
+mkdir -p "$MCP_ARTIFACT_DIR/reports"
+echo "hello" > "$MCP_ARTIFACT_DIR/reports/summary.txt"
+printf png > "$MCP_ARTIFACT_DIR/chart.png"
+ln -s /etc/hostname "$MCP_ARTIFACT_DIR/host"
+echo "done"
+`
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	st, _ := openStore("")
+	cfg := serverConfig{
+		Target:         script,
+		DefaultTimeout: 5 * time.Second,
+		Store:          st,
+		Artifacts: artifactConfig{
+			Root:      t.TempDir(),
+			MaxBytes:  defaultArtifactMaxBytes,
+			Retention: time.Hour,
+			PublicURL: "http://mcp.example",
+		},
+	}
+	tools := newToolHandler(cfg)
+	call := &toolCall{ID: json.RawMessage("1"), Name: toolRun, Tenant: "team-a", Arguments: json.RawMessage(`{"message":"write a report","model":"m"}`)}
+	result, mErr := tools(context.Background(), call)
+	if mErr != nil {
+		t.Fatalf("run: %v", mErr)
+	}
+
+	var link, image *toolContent
+	for i, c := range result.Content {
+		switch c.Type {
+		case "resource_link":
+			link = &result.Content[i]
+		case "image":
+			image = &result.Content[i]
+		}
+	}
+	wantURI := "http://mcp.example/artifacts/" + call.RunID + "/reports/summary.txt"
+	if link == nil || link.URI != wantURI || link.Name != "reports/summary.txt" || !strings.HasPrefix(link.MimeType, "text/plain") {
+		t.Fatalf("resource link = %+v, want uri %s", link, wantURI)
+	}
+	if image == nil || image.MimeType != "image/png" || image.Data != "cG5n" {
+		t.Errorf("image = %+v", image)
+	}
+	if rec, ok, _ := (runHistory{st}).get("team-a", call.RunID); !ok || len(rec.Artifacts) != 2 {
+		t.Errorf("run record artifacts = %+v", rec.Artifacts)
+	}
+
+	mux := http.NewServeMux()
+	registerArtifactRoutes(mux, cfg)
+	get := func(tenant, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set(tenantHeader, tenant)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+	path := "/artifacts/" + call.RunID + "/reports/summary.txt"
+	rec := get("team-a", path)
+	if body, _ := io.ReadAll(rec.Body); rec.Code != http.StatusOK || string(body) != "hello\n" {
+		t.Errorf("GET %s = %d %q", path, rec.Code, body)
+	}
+	if rec := get("team-b", path); rec.Code != http.StatusNotFound {
+		t.Errorf("other tenant GET = %d, want 404", rec.Code)
+	}
+
+	// Symlinks planted in the run directory are removed, and don't lead
+	// out of it
+	runDir := cfg.Artifacts.artifactDir("team-a", call.RunID)
+	if _, err := os.Lstat(filepath.Join(runDir, "host")); !os.IsNotExist(err) {
+		t.Errorf("symlink kept: %v", err)
+	}
+	secret := filepath.Join(t.TempDir(), "secret.txt")
+	if err := os.WriteFile(secret, []byte("s3cret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(secret, filepath.Join(runDir, "link.txt")); err != nil {
+		t.Skip(err)
+	}
+	if err := os.Symlink(filepath.Dir(secret), filepath.Join(runDir, "linkdir")); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"link.txt", "linkdir/secret.txt"} {
+		if rec := get("team-a", "/artifacts/"+call.RunID+"/"+name); rec.Code != http.StatusNotFound {
+			t.Errorf("GET %s = %d, want 404", name, rec.Code)
+		}
+	}
+}
+
+// Test artifact path validation
+func TestIsSafeArtifactPath(t *testing.T) {
+	tests := map[string]bool{
+		"report.txt":  true,
+		"a/b/c.json":  true,
+		"../secret":   false,
+		"a/../../b":   false,
+		"/etc/passwd": false,
+		"a//b":        false,
+		"a\\..\\b":    false,
+		"":            false,
+	}
+	for in, want := range tests {
+		if got := isSafeArtifactPath(in); got != want {
+			t.Errorf("isSafeArtifactPath(%q) = %v, want %v", in, got, want)
+		}
+	}
+}
+
+// Test expired run directories are pruned
+func TestPruneArtifacts(t *testing.T) {
+	c := artifactConfig{Root: t.TempDir(), Retention: 24 * time.Hour}
+	oldDir := c.artifactDir("t", "old")
+	newDir := c.artifactDir("t", "new")
+	for _, d := range []string{oldDir, newDir} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	past := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(oldDir, past, past); err != nil {
+		t.Fatal(err)
+	}
+	pruneArtifacts(c, time.Now())
+	if _, err := os.Stat(oldDir); !os.IsNotExist(err) {
+		t.Errorf("old run dir still exists: %v", err)
+	}
+	if _, err := os.Stat(newDir); err != nil {
+		t.Errorf("new run dir removed: %v", err)
+	}
+}
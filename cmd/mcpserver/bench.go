@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"opencode-mcp/internal/mcp"
+)
+
+// benchScenarios are the request mixes `bench` can generate. The run
+// scenarios are meant for a server whose MCP_TARGET is the mock runner
+// (see mockRunnerName), so they measure the server rather than a model.
+var benchScenarios = map[string]func(i int) mcpRequest{
+	"run-small": func(i int) mcpRequest {
+		return benchToolCall(i, toolRun, map[string]any{"message": fmt.Sprintf("bench request %d: reply with ok", i)})
+	},
+	"run-interactive": func(i int) mcpRequest {
+		return benchToolCall(i, toolRun, map[string]any{"message": fmt.Sprintf("bench request %d", i), "priority": priorityInteractive})
+	},
+	"models": func(i int) mcpRequest {
+		return benchToolCall(i, toolModels, map[string]any{})
+	},
+	"tools-list": func(i int) mcpRequest {
+		return mcpRequest{Request: mcp.Request{JSONRPC: "2.0", ID: benchID(i), Method: "tools/list"}}
+	},
+}
+
+func benchID(i int) json.RawMessage {
+	return json.RawMessage(strconv.Itoa(i))
+}
+
+func benchToolCall(i int, name string, args map[string]any) mcpRequest {
+	params, _ := json.Marshal(map[string]any{"name": name, "arguments": args})
+	return mcpRequest{Request: mcp.Request{JSONRPC: "2.0", ID: benchID(i), Method: "tools/call", Params: params}}
+}
+
+// benchResult is the outcome of one request.
+type benchResult struct {
+	latency   time.Duration
+	firstByte time.Duration
+	outcome   string // ok, tool error, or the error code or transport error
+}
+
+// benchStatus tracks the peaks seen while polling the server's /status.
+type benchStatus struct {
+	mu             sync.Mutex
+	peakWaiting    int
+	peakRunning    int
+	peakHeap       uint64
+	peakGoroutines int
+	last           map[string]queueStats
+	samples        int
+}
+
+// runBench implements `bench`: it sends the scenario's requests from
+// concurrency workers and reports latency percentiles, outcomes, and the
+// queue and memory behavior of the server.
+func runBench(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	server := fs.String("server", defaultPublicURL(getenv("MCP_ADDR", defaultAddr)), "server base URL")
+	concurrency := fs.Int("concurrency", 20, "concurrent clients")
+	requests := fs.Int("requests", 200, "total requests")
+	scenario := fs.String("scenario", "run-small", "request mix: "+strings.Join(benchScenarioNames(), ", "))
+	timeout := fs.Duration("timeout", 2*time.Minute, "per-request timeout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	build, ok := benchScenarios[*scenario]
+	if !ok {
+		return fmt.Errorf("unknown scenario %q (want %s)", *scenario, strings.Join(benchScenarioNames(), ", "))
+	}
+	if *concurrency <= 0 || *requests <= 0 {
+		return errors.New("-concurrency and -requests must be positive")
+	}
+	base := strings.TrimRight(*server, "/")
+	client := &http.Client{Timeout: *timeout}
+
+	ctx, stop := context.WithCancel(context.Background())
+	status := &benchStatus{}
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		status.poll(ctx, client, base+"/status")
+	}()
+
+	results := make([]benchResult, *requests)
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	start := time.Now()
+	for w := 0; w < *concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(next.Add(1)) - 1
+				if i >= *requests {
+					return
+				}
+				results[i] = benchRequest(client, base+"/mcp", build(i+1))
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	stop()
+	<-sampled
+
+	printBenchReport(os.Stdout, *scenario, *concurrency, elapsed, results, status)
+	return nil
+}
+
+func benchScenarioNames() []string {
+	names := make([]string, 0, len(benchScenarios))
+	for name := range benchScenarios {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// benchRequest sends one MCP request and classifies the response, which
+// is either JSON or an SSE stream ending with the response.
+func benchRequest(client *http.Client, url string, req mcpRequest) benchResult {
+	body, _ := json.Marshal(req)
+	httpReq, _ := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "application/json, text/event-stream")
+	start := time.Now()
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return benchResult{latency: time.Since(start), outcome: "transport error"}
+	}
+	defer resp.Body.Close()
+	r := bufio.NewReader(resp.Body)
+	_, _ = r.Peek(1)
+	res := benchResult{firstByte: time.Since(start)}
+	data, err := io.ReadAll(r)
+	res.latency = time.Since(start)
+	if err != nil {
+		res.outcome = "transport error"
+		return res
+	}
+	if resp.StatusCode != http.StatusOK {
+		res.outcome = fmt.Sprintf("HTTP %d", resp.StatusCode)
+		return res
+	}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		last := ""
+		for _, line := range strings.Split(string(data), "\n") {
+			if d, ok := strings.CutPrefix(line, "data: "); ok {
+				last = d
+			}
+		}
+		data = []byte(last)
+	}
+	var out struct {
+		Result *struct {
+			IsError bool `json:"isError"`
+			Meta    struct {
+				Error *errorData `json:"error"`
+			} `json:"_meta"`
+		} `json:"result"`
+		Error *mcpError `json:"error"`
+	}
+	switch {
+	case json.Unmarshal(data, &out) != nil:
+		res.outcome = "malformed response"
+	case out.Error != nil && out.Error.Data != nil:
+		res.outcome = out.Error.Data.Code
+	case out.Error != nil:
+		res.outcome = fmt.Sprintf("JSON-RPC %d", out.Error.Code)
+	case out.Result != nil && out.Result.IsError && out.Result.Meta.Error != nil:
+		res.outcome = "tool error " + out.Result.Meta.Error.Code
+	case out.Result != nil && out.Result.IsError:
+		res.outcome = "tool error"
+	default:
+		res.outcome = "ok"
+	}
+	return res
+}
+
+// poll samples the server's /status until ctx is done; servers without it
+// are benchmarked without queue and memory figures.
+func (s *benchStatus) poll(ctx context.Context, client *http.Client, url string) {
+	tick := time.NewTicker(250 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		s.sample(ctx, client, url)
+		select {
+		case <-ctx.Done():
+			s.sample(context.Background(), client, url) // final counters
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+func (s *benchStatus) sample(ctx context.Context, client *http.Client, url string) {
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	var st struct {
+		Queue  map[string]queueStats `json:"queue"`
+		Memory struct {
+			HeapAllocBytes uint64 `json:"heapAllocBytes"`
+			Goroutines     int    `json:"goroutines"`
+		} `json:"memory"`
+	}
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&st) != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.samples++
+	waiting, running := 0, 0
+	for _, q := range st.Queue {
+		waiting += q.Waiting
+		running += q.Running
+	}
+	s.peakWaiting = max(s.peakWaiting, waiting)
+	s.peakRunning = max(s.peakRunning, running)
+	s.peakHeap = max(s.peakHeap, st.Memory.HeapAllocBytes)
+	s.peakGoroutines = max(s.peakGoroutines, st.Memory.Goroutines)
+	if st.Queue != nil {
+		s.last = st.Queue
+	}
+}
+
+func printBenchReport(w io.Writer, scenario string, concurrency int, elapsed time.Duration, results []benchResult, status *benchStatus) {
+	outcomes := map[string]int{}
+	var latencies, firstBytes []time.Duration
+	for _, r := range results {
+		outcomes[r.outcome]++
+		latencies = append(latencies, r.latency)
+		firstBytes = append(firstBytes, r.firstByte)
+	}
+	fmt.Fprintf(w, "scenario %s: %d requests, concurrency %d, %s (%.1f req/s)\n",
+		scenario, len(results), concurrency, elapsed.Round(time.Millisecond), float64(len(results))/elapsed.Seconds())
+
+	names := make([]string, 0, len(outcomes))
+	for name := range outcomes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var parts []string
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s %d", name, outcomes[name]))
+	}
+	fmt.Fprintf(w, "  outcomes:   %s\n", strings.Join(parts, ", "))
+	fmt.Fprintf(w, "  latency:    %s\n", formatPercentiles(latencies))
+	fmt.Fprintf(w, "  first byte: %s\n", formatPercentiles(firstBytes))
+
+	status.mu.Lock()
+	defer status.mu.Unlock()
+	if status.samples == 0 {
+		fmt.Fprintln(w, "  (no /status: queue and memory figures unavailable)")
+		return
+	}
+	fmt.Fprintf(w, "  queue:      peak running %d, peak waiting %d\n", status.peakRunning, status.peakWaiting)
+	for _, class := range []string{priorityInteractive, priorityNormal, priorityBatch} {
+		if q, ok := status.last[class]; ok && q.Started > 0 {
+			fmt.Fprintf(w, "    %-11s started %d, wait avg %dms max %dms\n", class, q.Started, q.AvgWaitMs, q.MaxWaitMs)
+		}
+	}
+	fmt.Fprintf(w, "  memory:     peak heap %.1f MB, peak goroutines %d\n", float64(status.peakHeap)/(1<<20), status.peakGoroutines)
+}
+
+func formatPercentiles(ds []time.Duration) string {
+	sorted := append([]time.Duration(nil), ds...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var parts []string
+	for _, p := range []float64{0.5, 0.9, 0.99} {
+		parts = append(parts, fmt.Sprintf("p%d %s", int(p*100), percentile(sorted, p).Round(time.Millisecond)))
+	}
+	parts = append(parts, fmt.Sprintf("max %s", percentile(sorted, 1).Round(time.Millisecond)))
+	return strings.Join(parts, "  ")
+}
+
+// percentile returns the nearest-rank percentile p of sorted durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	return sorted[max(i, 0)]
+}
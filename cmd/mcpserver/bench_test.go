@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test bench classifies SSE and JSON responses and reports percentiles
+func TestBench(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script")
+	}
+	script := filepath.Join(t.TempDir(), "opencode")
+	content := "#!/bin/sh\necho '{\"type\":\"text\",\"part\":{\"text\":\"ok\"}}'\n"
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	cfg := serverConfig{Target: script, DefaultTimeout: 5 * time.Second, DefaultModel: "m", Limiter: newRunLimiter(2)}
+	mux := http.NewServeMux()
+	mux.Handle("/mcp", newMCPHandler(&sessionStore{sessions: make(map[string]*session)}, cfg))
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"queue": cfg.Limiter.queueStatus(), "memory": memoryStatus()})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	if r := benchRequest(client, srv.URL+"/mcp", benchScenarios["run-small"](1)); r.outcome != "ok" || r.latency <= 0 {
+		t.Errorf("run-small = %+v", r)
+	}
+	if r := benchRequest(client, srv.URL+"/mcp", benchToolCall(2, "no_such_tool", nil)); r.outcome != errUnknownTool.Code {
+		t.Errorf("unknown tool = %+v", r)
+	}
+
+	status := &benchStatus{}
+	status.sample(context.Background(), client, srv.URL+"/status")
+	var b strings.Builder
+	results := []benchResult{{latency: 10 * time.Millisecond, outcome: "ok"}, {latency: 30 * time.Millisecond, outcome: "ok"}, {latency: 20 * time.Millisecond, outcome: "OC-3002"}}
+	printBenchReport(&b, "run-small", 2, time.Second, results, status)
+	for _, want := range []string{"3 requests", "OC-3002 1, ok 2", "p50 20ms", "max 30ms", "normal      started 1", "peak heap"} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("report lacks %q:\n%s", want, b.String())
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// cliBinary is an opencode executable the server runs. Each call pins the
+// binary that was active when it started, so swapping MCP_TARGET never
+// affects runs already in flight.
+type cliBinary struct {
+	Path        string    `json:"path"`
+	Version     string    `json:"version,omitempty"`
+	ActiveSince time.Time `json:"activeSince"`
+	inflight    atomic.Int64
+}
+
+func (b *cliBinary) release() {
+	if b != nil {
+		b.inflight.Add(-1)
+	}
+}
+
+// binarySwitch holds the active opencode binary and the retired ones that
+// still have runs in flight.
+type binarySwitch struct {
+	mu       sync.Mutex // serializes swaps and guards retired
+	cur      atomic.Pointer[cliBinary]
+	retired  []*cliBinary
+	previous string
+}
+
+func newBinarySwitch(path, version string) *binarySwitch {
+	s := &binarySwitch{}
+	s.cur.Store(&cliBinary{Path: path, Version: version, ActiveSince: localTime(time.Now())})
+	return s
+}
+
+// acquire pins the active binary for a call; the caller must release it.
+// It returns nil for a nil switch.
+func (s *binarySwitch) acquire() *cliBinary {
+	if s == nil {
+		return nil
+	}
+	b := s.cur.Load()
+	b.inflight.Add(1)
+	return b
+}
+
+// swap makes path the active binary after checking that it runs. Calls
+// already holding the old binary keep using it.
+func (s *binarySwitch) swap(path string) (*cliBinary, error) {
+	resolved, err := exec.LookPath(path)
+	if err != nil {
+		return nil, err
+	}
+	if resolved, err = filepath.Abs(resolved); err != nil {
+		return nil, err
+	}
+	version, err := cliVersion(resolved)
+	if err != nil {
+		return nil, fmt.Errorf("%s --version: %w", resolved, err)
+	}
+	probeJSONFormat(resolved)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	next := &cliBinary{Path: resolved, Version: version, ActiveSince: time.Now()}
+	old := s.cur.Swap(next)
+	s.previous = old.Path
+	s.retired = append(s.retired, old)
+	invalidateModelCache()
+	log.Printf("[binary] switched %s (%s) -> %s (%s); %d runs still on the old binary",
+		old.Path, versionOrUnknown(old.Version), next.Path, versionOrUnknown(next.Version), old.inflight.Load())
+	return next, nil
+}
+
+// binaryStatus describes the switch for /status.
+type binaryStatus struct {
+	Current  *cliBinary       `json:"current"`
+	Previous string           `json:"previous,omitempty"`
+	Draining []drainingBinary `json:"draining,omitempty"`
+}
+
+type drainingBinary struct {
+	Path     string `json:"path"`
+	Version  string `json:"version,omitempty"`
+	Inflight int64  `json:"inflightRuns"`
+}
+
+func (s *binarySwitch) status() binaryStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := binaryStatus{Current: s.cur.Load(), Previous: s.previous}
+	live := s.retired[:0]
+	for _, b := range s.retired {
+		if n := b.inflight.Load(); n > 0 {
+			live = append(live, b)
+			st.Draining = append(st.Draining, drainingBinary{b.Path, b.Version, n})
+		}
+	}
+	s.retired = live
+	return st
+}
+
+func versionOrUnknown(v string) string {
+	if v == "" {
+		return "version unknown"
+	}
+	return v
+}
+
+// withBinary returns cfg set up to run b; a nil b leaves cfg unchanged.
+func (cfg serverConfig) withBinary(b *cliBinary) serverConfig {
+	if b != nil {
+		cfg.Target, cfg.CLIVersion = b.Path, b.Version
+	}
+	return cfg
+}
+
+// binaryMiddleware pins the active opencode binary for the duration of a
+// call. Sub-calls of composite tools inherit their parent's binary.
+func binaryMiddleware(cfg serverConfig) toolMiddleware {
+	return func(next toolHandler) toolHandler {
+		return func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+			if call.Binary == nil && cfg.Binaries != nil {
+				b := cfg.Binaries.acquire()
+				defer b.release()
+				call.Binary = b
+			}
+			return next(ctx, call)
+		}
+	}
+}
+
+// reloadTarget resolves the binary path a reload should switch to: `target`
+// in the MCP_CONFIG file, else MCP_TARGET.
+func reloadTarget() (string, error) {
+	if path := os.Getenv("MCP_CONFIG"); path != "" {
+		fc, err := loadFileConfig(path)
+		if err != nil {
+			return "", err
+		}
+		if fc.Target != "" {
+			return fc.Target, nil
+		}
+	}
+	return getenv("MCP_TARGET", defaultTarget), nil
+}
+
+// registerAdminRoutes adds the admin API when MCP_ADMIN_TOKEN is set.
+func registerAdminRoutes(mux *http.ServeMux, cfg serverConfig, token string) {
+	if token == "" || cfg.Binaries == nil {
+		return
+	}
+	admin := func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			h(w, r)
+		}
+	}
+
+	// POST /admin/binary {"path": "..."} switches the opencode binary; with
+	// no path it reloads MCP_TARGET from the config (e.g. a new version
+	// installed at the same path).
+	mux.HandleFunc("POST /admin/binary", admin(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Path string `json:"path"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Path == "" {
+			path, err := reloadTarget()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			req.Path = path
+		}
+		if _, err := cfg.Binaries.swap(req.Path); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, cfg.Binaries.status())
+	}))
+	registerProjectRoutes(mux, cfg, admin)
+	registerAnalyticsRoutes(mux, cfg, admin)
+	registerToolRoutes(mux, cfg, admin)
+	registerEventRoutes(mux, cfg, admin)
+	registerAPIKeyRoutes(mux, cfg, admin)
+}
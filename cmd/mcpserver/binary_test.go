@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeVersionScript(t *testing.T, version string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "opencode")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\necho "+version+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Test a swap leaves in-flight calls on the binary they started with
+func TestBinarySwitchPinsInflightCalls(t *testing.T) {
+	oldPath, newPath := writeVersionScript(t, "1.0.0"), writeVersionScript(t, "1.1.0")
+	cfg := serverConfig{Binaries: newBinarySwitch(oldPath, "1.0.0")}
+
+	started, finish := make(chan struct{}), make(chan struct{})
+	var ran string
+	h := chainTools(func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+		close(started)
+		<-finish
+		ran = cfg.withBinary(call.Binary).Target
+		return &toolCallResult{}, nil
+	}, binaryMiddleware(cfg))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = h(context.Background(), &toolCall{Name: toolRun})
+	}()
+	<-started
+
+	if _, err := cfg.Binaries.swap(newPath); err != nil {
+		t.Fatal(err)
+	}
+	st := cfg.Binaries.status()
+	if st.Current.Path != newPath || st.Current.Version != "1.1.0" || st.Previous != oldPath {
+		t.Errorf("status = %+v", st)
+	}
+	if len(st.Draining) != 1 || st.Draining[0].Inflight != 1 {
+		t.Errorf("draining = %+v, want the old binary with one run", st.Draining)
+	}
+
+	close(finish)
+	<-done
+	if ran != oldPath {
+		t.Errorf("in-flight call ran %s, want %s", ran, oldPath)
+	}
+	if st := cfg.Binaries.status(); len(st.Draining) != 0 {
+		t.Errorf("draining after completion = %+v", st.Draining)
+	}
+	if b := cfg.Binaries.acquire(); b.Path != newPath {
+		t.Errorf("new call got %s, want %s", b.Path, newPath)
+	}
+
+	if _, err := cfg.Binaries.swap(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("swap to a missing binary succeeded")
+	}
+}
+
+// Test the admin API requires the token and switches binaries
+func TestAdminBinaryRoute(t *testing.T) {
+	oldPath, newPath := writeVersionScript(t, "1.0.0"), writeVersionScript(t, "1.1.0")
+	cfg := serverConfig{Binaries: newBinarySwitch(oldPath, "1.0.0")}
+	mux := http.NewServeMux()
+	registerAdminRoutes(mux, cfg, "secret")
+
+	post := func(token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/admin/binary", strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+	if w := post("wrong", `{"path":"`+newPath+`"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("wrong token: status %d", w.Code)
+	}
+	w := post("secret", `{"path":"`+newPath+`"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	var st binaryStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &st); err != nil || st.Current.Path != newPath {
+		t.Errorf("response = %s (%v)", w.Body, err)
+	}
+
+	t.Setenv("MCP_CONFIG", "")
+	t.Setenv("MCP_TARGET", oldPath)
+	if w := post("secret", ""); w.Code != http.StatusOK || cfg.Binaries.acquire().Path != oldPath {
+		t.Errorf("reload: status %d: %s", w.Code, w.Body)
+	}
+}
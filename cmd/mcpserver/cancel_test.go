@@ -0,0 +1,171 @@
+//go:build !windows
+
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Test notifications/cancelled stops a call and kills the processes opencode started
+func TestCancelledNotification(t *testing.T) {
+	dir := t.TempDir()
+	pidFile := filepath.Join(dir, "pid")
+	script := filepath.Join(dir, "opencode")
+	content := `#!/bin/sh
+sleep 30 &
+echo $! > ` + pidFile + `
+wait
+`
+	if err := os.WriteFile(script, []byte(content), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	cfg := serverConfig{Target: script, DefaultTimeout: time.Minute}
+	srv := httptest.NewServer(newMCPHandler(&sessionStore{sessions: make(map[string]*session)}, cfg))
+	defer srv.Close()
+	post := func(body string) *http.Response {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Error(err)
+			return nil
+		}
+		return resp
+	}
+
+	done := make(chan struct{})
+	start := time.Now()
+	go func() {
+		defer close(done)
+		if resp := post(`{"jsonrpc":"2.0","id":"slow","method":"tools/call","params":{"name":"opencode_exec","arguments":{"args":["x"]}}}`); resp != nil {
+			resp.Body.Close()
+		}
+	}()
+	var pid int
+	for pid == 0 && time.Since(start) < 5*time.Second {
+		time.Sleep(20 * time.Millisecond)
+		data, _ := os.ReadFile(pidFile)
+		pid, _ = strconv.Atoi(strings.TrimSpace(string(data)))
+	}
+	if pid == 0 {
+		t.Fatal("opencode did not start")
+	}
+
+	resp := post(`{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":"slow","reason":"test"}}`)
+	if resp == nil || resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("cancel: %+v", resp)
+	}
+	resp.Body.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("call still running after cancellation")
+	}
+	for time.Since(start) < 5*time.Second && syscall.Kill(pid, 0) == nil {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if syscall.Kill(pid, 0) == nil {
+		t.Errorf("child %d of opencode still running", pid)
+	}
+}
+
+// Test DELETE /mcp ends a session: its calls are cancelled, its stream
+// closed, and later requests get 404
+func TestDeleteSession(t *testing.T) {
+	dir := t.TempDir()
+	pidFile := filepath.Join(dir, "pid")
+	script := filepath.Join(dir, "opencode")
+	content := `#!/bin/sh
+echo $$ > ` + pidFile + `
+exec sleep 30
+`
+	if err := os.WriteFile(script, []byte(content), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	st, _ := openStore("")
+	sessions := &sessionStore{sessions: make(map[string]*session), store: st}
+	srv := httptest.NewServer(newMCPHandler(sessions, serverConfig{Target: script, DefaultTimeout: time.Minute}))
+	defer srv.Close()
+	do := func(method, sessionID, body string) *http.Response {
+		req, _ := http.NewRequest(method, srv.URL, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json, text/event-stream")
+		if sessionID != "" {
+			req.Header.Set("Mcp-Session-Id", sessionID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Error(err)
+			return nil
+		}
+		return resp
+	}
+
+	resp := do(http.MethodPost, "", `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`)
+	resp.Body.Close()
+	id := resp.Header.Get("Mcp-Session-Id")
+	if id == "" {
+		t.Fatal("no session")
+	}
+	streamClosed := make(chan struct{})
+	go func() {
+		defer close(streamClosed)
+		if resp := do(http.MethodGet, id, ""); resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+	}()
+	callDone := make(chan struct{})
+	go func() {
+		defer close(callDone)
+		if resp := do(http.MethodPost, id, `{"jsonrpc":"2.0","id":"slow","method":"tools/call","params":{"name":"opencode_exec","arguments":{"args":["x"]}}}`); resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+	}()
+	start := time.Now()
+	for time.Since(start) < 5*time.Second {
+		if data, _ := os.ReadFile(pidFile); len(data) > 0 {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if resp := do(http.MethodDelete, id, ""); resp == nil || resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("DELETE = %+v", resp)
+	}
+	for name, done := range map[string]chan struct{}{"call": callDone, "stream": streamClosed} {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Errorf("%s still open after DELETE", name)
+		}
+	}
+	if ok, _ := st.get(sessionsCollection, id, &storedSession{}); ok {
+		t.Error("session still in the store")
+	}
+	for _, tc := range []struct {
+		method, session, body string
+		want                  int
+	}{
+		{http.MethodPost, id, `{"jsonrpc":"2.0","id":2,"method":"tools/list"}`, http.StatusNotFound},
+		{http.MethodDelete, id, "", http.StatusNotFound},
+		{http.MethodDelete, "", "", http.StatusBadRequest},
+	} {
+		resp := do(tc.method, tc.session, tc.body)
+		if resp == nil || resp.StatusCode != tc.want {
+			t.Errorf("%s %q after DELETE = %+v, want %d", tc.method, tc.session, resp, tc.want)
+			continue
+		}
+		resp.Body.Close()
+	}
+}
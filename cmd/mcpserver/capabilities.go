@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"opencode-mcp/internal/mcp"
+)
+
+// capabilityReport describes what an instance offers under its current
+// configuration, for fleet tooling that inventories deployed servers.
+type capabilityReport struct {
+	Server           string                `json:"server"`
+	Version          string                `json:"version"`
+	ProtocolVersions []string              `json:"protocolVersions"`
+	Tools            []toolCapability      `json:"tools"`
+	Transports       []transportCapability `json:"transports"`
+	Auth             authCapability        `json:"auth"`
+	Limits           limitCapability       `json:"limits"`
+	Backends         backendCapability     `json:"backends"`
+}
+
+type toolCapability struct {
+	Name     string `json:"name"`
+	Source   string `json:"source"` // builtin, custom, plugin or server
+	ReadOnly bool   `json:"readOnly"`
+}
+
+type transportCapability struct {
+	Name   string `json:"name"`
+	Method string `json:"method"`
+	Path   string `json:"path"`
+}
+
+type authCapability struct {
+	TenantHeader      string   `json:"tenantHeader"`      // set by an authenticating proxy
+	TrustTenantHeader bool     `json:"trustTenantHeader"` // else only API keys select the tenant
+	Tenants           []string `json:"tenants"`           // with a policy in the config file
+	RequireSession    bool     `json:"requireSession"`
+	AdminToken        bool     `json:"adminToken"` // /admin endpoints enabled
+	SigningKey        bool     `json:"signingKey"` // run manifests are signed
+}
+
+type limitCapability struct {
+	TimeoutSec        int      `json:"timeoutSec"`
+	MaxConcurrentRuns int      `json:"maxConcurrentRuns"` // 0 is unlimited
+	MaxMessageChars   int      `json:"maxMessageChars"`   // 0 is unlimited
+	Oversize          string   `json:"oversize"`
+	NotifyRate        int      `json:"notifyRate"` // 0 is unlimited
+	PollWait          string   `json:"pollWait"`
+	DedupeWindow      string   `json:"dedupeWindow"`
+	SessionTTL        string   `json:"sessionTTL"`    // 0s keeps sessions until deleted
+	StdinMaxBytes     int      `json:"stdinMaxBytes"` // 0 is unlimited
+	AttachHosts       []string `json:"attachHosts"`   // hosts URL attachments may come from
+	AttachMaxBytes    int      `json:"attachMaxBytes"`
+	ArtifactMaxMB     int64    `json:"artifactMaxMB"`
+}
+
+type backendCapability struct {
+	Backend    string   `json:"backend"`
+	Target     string   `json:"target"`
+	CLIVersion string   `json:"cliVersion,omitempty"` // "" when the binary doesn't answer --version
+	ServeURL   string   `json:"serveURL,omitempty"`
+	Agent      string   `json:"agent,omitempty"`
+	Store      string   `json:"store"` // memory, file, or the MCP_STORE_URL scheme
+	Plugins    []string `json:"plugins"`
+	Servers    []string `json:"servers"` // downstream MCP servers
+}
+
+// toolSources returns where each tool comes from: builtin, custom, plugin
+// or server.
+func toolSources(cfg serverConfig) map[string]string {
+	source := map[string]string{}
+	for _, t := range allToolDefinitions(cfg) {
+		source[t.Name] = "builtin"
+	}
+	for _, t := range cfg.CustomTools {
+		source[t.Name] = "custom"
+	}
+	for _, pt := range cfg.PluginTools {
+		source[pt.Tool.Name] = "plugin"
+	}
+	for _, dt := range cfg.ServerTools {
+		source[dt.Tool.Name] = "server"
+	}
+	return source
+}
+
+// buildCapabilityReport describes cfg, whose plugin and downstream server
+// tools have been discovered.
+func buildCapabilityReport(cfg serverConfig) capabilityReport {
+	source := toolSources(cfg)
+	r := capabilityReport{
+		Server:           "opencode-mcp",
+		Version:          serverVersion,
+		ProtocolVersions: mcp.SupportedVersions,
+		Transports: []transportCapability{
+			{"streamable-http", "POST", "/mcp"},
+			{"session-stream", "GET", "/mcp"},
+			{"end-session", "DELETE", "/mcp"},
+			{"resume", "GET", "/resume/{key}"},
+			{"exec", "POST", "/exec"},
+			{"exec-stream", "POST", "/exec/stream"},
+		},
+		Auth: authCapability{
+			TenantHeader:      tenantHeader,
+			TrustTenantHeader: cfg.TrustTenant,
+			Tenants:           []string{},
+			RequireSession:    cfg.RequireSession,
+			AdminToken:        os.Getenv("MCP_ADMIN_TOKEN") != "",
+			SigningKey:        os.Getenv("MCP_SIGNING_KEY") != "",
+		},
+		Limits: limitCapability{
+			TimeoutSec:        int(cfg.DefaultTimeout.Seconds()),
+			MaxConcurrentRuns: getenvInt("MCP_MAX_CONCURRENT_RUNS", defaultMaxConcurrentRuns),
+			MaxMessageChars:   cfg.MaxMessageChars,
+			Oversize:          cfg.Oversize,
+			NotifyRate:        cfg.NotifyRate,
+			PollWait:          cfg.PollWait.String(),
+			DedupeWindow:      getenvDuration("MCP_DEDUPE_WINDOW", defaultDedupeWindow).String(),
+			SessionTTL:        cfg.SessionTTL.String(),
+			StdinMaxBytes:     cfg.Stdin.MaxBytes,
+			AttachHosts:       append([]string{}, cfg.Attach.Hosts.AllowHosts...),
+			AttachMaxBytes:    cfg.Attach.MaxBytes,
+			ArtifactMaxMB:     cfg.Artifacts.MaxBytes >> 20,
+		},
+		Backends: backendCapability{
+			Backend:    cfg.Backend,
+			Target:     cfg.Target,
+			CLIVersion: cfg.CLIVersion,
+			Agent:      cfg.Agent,
+			Store:      storeKind(),
+			Plugins:    []string{},
+			Servers:    []string{},
+		},
+	}
+	for _, t := range toolDefinitions(cfg) {
+		readOnly := t.Annotations != nil && t.Annotations.ReadOnlyHint != nil && *t.Annotations.ReadOnlyHint
+		r.Tools = append(r.Tools, toolCapability{Name: t.Name, Source: source[t.Name], ReadOnly: readOnly})
+	}
+	if cfg.Idempotency != nil {
+		r.Transports = append(r.Transports, transportCapability{"long-poll", "POST", pollPath})
+	}
+	for name := range cfg.Tenants {
+		r.Auth.Tenants = append(r.Auth.Tenants, name)
+	}
+	sort.Strings(r.Auth.Tenants)
+	if cfg.Backend == backendServe {
+		r.Backends.ServeURL = cfg.ServeURL
+	}
+	for _, p := range cfg.Plugins {
+		r.Backends.Plugins = append(r.Backends.Plugins, p.Name)
+	}
+	for _, s := range cfg.Servers {
+		r.Backends.Servers = append(r.Backends.Servers, s.Name)
+	}
+	return r
+}
+
+// storeKind names the store the environment selects.
+func storeKind() string {
+	if raw := os.Getenv("MCP_STORE_URL"); raw != "" {
+		if u, err := url.Parse(raw); err == nil && u.Scheme != "" {
+			return u.Scheme
+		}
+		return "url"
+	}
+	if os.Getenv("MCP_STORE_PATH") != "" {
+		return "file"
+	}
+	return "memory"
+}
+
+// summary is the one-line form of the report in the startup banner.
+func (r capabilityReport) summary() string {
+	counts := map[string]int{}
+	for _, t := range r.Tools {
+		counts[t.Source]++
+	}
+	var sources []string
+	for _, s := range []string{"builtin", "custom", "plugin", "server"} {
+		if counts[s] > 0 {
+			sources = append(sources, fmt.Sprintf("%d %s", counts[s], s))
+		}
+	}
+	var transports []string
+	for _, t := range r.Transports {
+		transports = append(transports, t.Name)
+	}
+	return fmt.Sprintf("%d tools (%s); transports %s", len(r.Tools), strings.Join(sources, ", "), strings.Join(transports, ", "))
+}
+
+// runCapabilities implements `capabilities`: it prints the report of the
+// configuration in the environment and MCP_CONFIG, discovering plugin and
+// downstream server tools like the server does at startup.
+func runCapabilities(args []string) error {
+	fs := flag.NewFlagSet("capabilities", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	configPath := fs.String("config", os.Getenv("MCP_CONFIG"), "configuration file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	cfg := configFromEnv()
+	if *configPath != "" {
+		fc, err := loadFileConfig(*configPath)
+		if err != nil {
+			return err
+		}
+		applyFileConfig(&cfg, fc)
+		cfg.PluginTools = discoverPluginTools(cfg)
+		cfg.ServerTools = discoverServerTools(cfg)
+	}
+	cfg.CLIVersion = detectCLIVersion(cfg.Target)
+	r := buildCapabilityReport(cfg)
+
+	if *asJSON {
+		b, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+		return nil
+	}
+	fmt.Printf("%s %s (MCP %s)\n", r.Server, r.Version, strings.Join(r.ProtocolVersions, ", "))
+	fmt.Printf("backend:    %s, %s (version %s), store %s\n", r.Backends.Backend, r.Backends.Target, orUnknown(r.Backends.CLIVersion), r.Backends.Store)
+	fmt.Printf("auth:       tenants from %s (%d policies), require session %t, admin token %t\n", r.Auth.TenantHeader, len(r.Auth.Tenants), r.Auth.RequireSession, r.Auth.AdminToken)
+	fmt.Printf("limits:     timeout %ds, %d concurrent runs, message chars %d, notify rate %d/s\n", r.Limits.TimeoutSec, r.Limits.MaxConcurrentRuns, r.Limits.MaxMessageChars, r.Limits.NotifyRate)
+	fmt.Println("transports:")
+	for _, t := range r.Transports {
+		fmt.Printf("  %-16s %-4s %s\n", t.Name, t.Method, t.Path)
+	}
+	fmt.Println("tools:")
+	for _, t := range r.Tools {
+		access := "read-write"
+		if t.ReadOnly {
+			access = "read-only"
+		}
+		fmt.Printf("  %-28s %-8s %s\n", t.Name, t.Source, access)
+	}
+	return nil
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
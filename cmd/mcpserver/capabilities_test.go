@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildCapabilityReport(t *testing.T) {
+	cfg := serverConfig{
+		Target:         "opencode-cli",
+		Backend:        backendCLI,
+		DefaultTimeout: 90 * time.Second,
+		CustomTools:    []customTool{{Name: "lint_fix", Description: "fix lint", Args: []string{"run", "lint"}}},
+		Tenants:        map[string]tenantPolicy{"team-b": {}, "team-a": {}},
+	}
+	r := buildCapabilityReport(cfg)
+
+	sources := map[string]string{}
+	for _, tool := range r.Tools {
+		sources[tool.Name] = tool.Source
+	}
+	if sources["lint_fix"] != "custom" || sources["opencode_run"] != "builtin" {
+		t.Errorf("tool sources = %v", sources)
+	}
+	if r.Limits.TimeoutSec != 90 {
+		t.Errorf("timeoutSec = %d, want 90", r.Limits.TimeoutSec)
+	}
+	if strings.Join(r.Auth.Tenants, ",") != "team-a,team-b" {
+		t.Errorf("tenants = %v, want sorted policy names", r.Auth.Tenants)
+	}
+	for _, tr := range r.Transports {
+		if tr.Path == pollPath {
+			t.Errorf("long-poll listed without idempotency")
+		}
+	}
+	if !strings.Contains(r.summary(), "1 custom") {
+		t.Errorf("summary = %q", r.summary())
+	}
+
+	cfg.Idempotency = newIdempotencyCache()
+	r = buildCapabilityReport(cfg)
+	if last := r.Transports[len(r.Transports)-1]; last.Path != pollPath {
+		t.Errorf("last transport = %+v, want long-poll", last)
+	}
+}
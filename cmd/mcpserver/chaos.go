@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// chaosConfig injects faults so MCP client authors can test how their
+// integration copes with a misbehaving server. It is enabled by MCP_CHAOS,
+// e.g. "first_byte_delay=2s,drop_sse=0.2,provider_error=0.1,garbage_events=0.05,seed=42",
+// and must never be set in production. A nil *chaosConfig injects nothing.
+type chaosConfig struct {
+	FirstByteDelay time.Duration // before the first byte of every /mcp response
+	DropSSE        float64       // chance per SSE message that the connection is cut before it
+	ProviderError  float64       // chance an opencode_run fails with a synthetic provider error
+	GarbageEvents  float64       // chance per SSE message of a malformed event before it
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// parseChaos parses a MCP_CHAOS spec of comma-separated name=value pairs.
+func parseChaos(spec string) (*chaosConfig, error) {
+	c := &chaosConfig{}
+	seed := time.Now().UnixNano()
+	for _, kv := range strings.Split(spec, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(kv, "=")
+		var err error
+		switch name {
+		case "first_byte_delay":
+			c.FirstByteDelay, err = time.ParseDuration(value)
+		case "drop_sse":
+			c.DropSSE, err = parseChance(value)
+		case "provider_error":
+			c.ProviderError, err = parseChance(value)
+		case "garbage_events":
+			c.GarbageEvents, err = parseChance(value)
+		case "seed":
+			seed, err = strconv.ParseInt(value, 10, 64)
+		default:
+			err = fmt.Errorf("unknown fault (want first_byte_delay, drop_sse, provider_error, garbage_events or seed)")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("MCP_CHAOS %s: %v", name, err)
+		}
+	}
+	c.rnd = rand.New(rand.NewSource(seed))
+	return c, nil
+}
+
+func parseChance(s string) (float64, error) {
+	p, err := strconv.ParseFloat(s, 64)
+	if err != nil || p < 0 || p > 1 {
+		return 0, fmt.Errorf("%q is not a probability between 0 and 1", s)
+	}
+	return p, nil
+}
+
+// roll reports whether a fault with probability p happens.
+func (c *chaosConfig) roll(p float64) bool {
+	if c == nil || p <= 0 {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rnd.Float64() < p
+}
+
+// chaosMiddleware fails opencode_run calls with synthetic provider errors.
+func chaosMiddleware(c *chaosConfig) toolMiddleware {
+	return func(next toolHandler) toolHandler {
+		return func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+			if c == nil || call.Name != toolRun || !c.roll(c.ProviderError) {
+				return next(ctx, call)
+			}
+			log.Printf("[chaos] injecting a provider error into %s", call.Name)
+			code, text := errProviderRateLimit, "chaos: synthetic provider error: 429 Too Many Requests"
+			if c.roll(0.5) {
+				code, text = errProviderAuth, "chaos: synthetic provider error: ProviderAuthError: 401 Unauthorized"
+			}
+			result := &toolCallResult{Content: []toolContent{{Type: "text", Text: text}}, IsError: true}
+			result.setError(code)
+			return result, nil
+		}
+	}
+}
+
+// wrap delays and corrupts the responses of h.
+func (c *chaosConfig) wrap(h http.Handler) http.Handler {
+	if c == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(&chaosWriter{ResponseWriter: w, chaos: c}, r)
+	})
+}
+
+// chaosWriter injects faults into a response as it is written.
+type chaosWriter struct {
+	http.ResponseWriter
+	chaos   *chaosConfig
+	started bool
+	dropped bool
+}
+
+func (w *chaosWriter) WriteHeader(status int) {
+	w.delay()
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *chaosWriter) Write(p []byte) (int, error) {
+	if w.dropped {
+		return 0, http.ErrHijacked
+	}
+	w.delay()
+	if bytes.HasPrefix(p, []byte("data: ")) && strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream") {
+		if w.chaos.roll(w.chaos.DropSSE) {
+			w.drop()
+			return 0, http.ErrHijacked
+		}
+		if w.chaos.roll(w.chaos.GarbageEvents) {
+			log.Printf("[chaos] injecting a garbage SSE event")
+			_, _ = w.ResponseWriter.Write([]byte("data: {\"jsonrpc\":\"2.0\",\"method\":\"notifications/pro\x00gress\",\"params\":{\"progre\n\n"))
+		}
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *chaosWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && !w.dropped {
+		f.Flush()
+	}
+}
+
+func (w *chaosWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *chaosWriter) delay() {
+	if !w.started {
+		w.started = true
+		time.Sleep(w.chaos.FirstByteDelay)
+	}
+}
+
+// drop cuts the connection mid-stream; where it can't be hijacked (HTTP/2)
+// the stream just stops.
+func (w *chaosWriter) drop() {
+	log.Printf("[chaos] dropping the SSE stream")
+	w.dropped = true
+	rc := http.NewResponseController(w.ResponseWriter)
+	_ = rc.Flush()
+	if conn, _, err := rc.Hijack(); err == nil {
+		_ = conn.Close()
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test MCP_CHAOS parsing and the injected faults
+func TestChaos(t *testing.T) {
+	for _, spec := range []string{"drop_sse=2", "unknown=1", "first_byte_delay=soon"} {
+		if _, err := parseChaos(spec); err == nil {
+			t.Errorf("parseChaos(%q) succeeded", spec)
+		}
+	}
+
+	c, err := parseChaos("provider_error=1,seed=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := chainTools(func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+		return &toolCallResult{}, nil
+	}, chaosMiddleware(c))
+	result, _ := h(context.Background(), &toolCall{Name: toolRun})
+	if result == nil || !result.IsError || result.Meta["error"] == nil {
+		t.Errorf("provider_error=1: result = %+v", result)
+	}
+
+	sse := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 1; i <= 3; i++ {
+			fmt.Fprintf(w, "data: {\"n\":%d}\n\n", i)
+			w.(http.Flusher).Flush()
+		}
+	})
+	get := func(spec string) (string, time.Duration) {
+		c, err := parseChaos(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv := httptest.NewServer(c.wrap(sse))
+		defer srv.Close()
+		start := time.Now()
+		resp, err := http.Get(srv.URL)
+		if err != nil {
+			return "", time.Since(start)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body) // a dropped stream ends with an error
+		return string(b), time.Since(start)
+	}
+
+	if body, elapsed := get("first_byte_delay=50ms"); elapsed < 50*time.Millisecond || strings.Count(body, "data: ") != 3 {
+		t.Errorf("first_byte_delay: %q after %s", body, elapsed)
+	}
+	if body, _ := get("drop_sse=1"); strings.Contains(body, `"n"`) {
+		t.Errorf("drop_sse=1 delivered events: %q", body)
+	}
+	if body, _ := get("garbage_events=1"); strings.Count(body, "data: ") != 6 || !strings.HasPrefix(body, "data: {\"jsonrpc\"") {
+		t.Errorf("garbage_events=1: %q", body)
+	}
+}
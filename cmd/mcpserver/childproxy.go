@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// proxySettings is how opencode processes reach model providers on
+// networks where that takes an egress proxy or a gateway: the proxy
+// variables and provider base URLs set in their environment. The top-level
+// "proxy" of the config file applies to every tenant; a tenant's own
+// "proxy" replaces it. The server's own environment is left alone.
+type proxySettings struct {
+	HTTPProxy  string            `json:"httpProxy,omitempty"`
+	HTTPSProxy string            `json:"httpsProxy,omitempty"`
+	NoProxy    string            `json:"noProxy,omitempty"`  // comma-separated hosts and .domains, or *
+	BaseURLs   map[string]string `json:"baseURLs,omitempty"` // provider ID -> URL, set as <PROVIDER>_BASE_URL
+}
+
+// validateProxy checks the top-level proxy of the config file.
+func validateProxy(s *proxySettings) error {
+	if s == nil {
+		return nil
+	}
+	if err := s.validate(); err != nil {
+		return fmt.Errorf("proxy.%v", err)
+	}
+	return nil
+}
+
+var providerIDRe = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+func (s *proxySettings) validate() error {
+	for key, raw := range map[string]string{"httpProxy": s.HTTPProxy, "httpsProxy": s.HTTPSProxy} {
+		if raw == "" {
+			continue
+		}
+		if u, err := url.Parse(raw); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%s: %q is not an http:// or https:// proxy URL", key, raw)
+		}
+	}
+	for provider, raw := range s.BaseURLs {
+		if !providerIDRe.MatchString(provider) {
+			return fmt.Errorf("baseURLs.%s: invalid provider ID", provider)
+		}
+		if u, err := url.Parse(raw); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("baseURLs.%s: %q is not an http:// or https:// URL", provider, raw)
+		}
+	}
+	return nil
+}
+
+// baseURLEnv returns the <PROVIDER>_BASE_URL variables, e.g.
+// ANTHROPIC_BASE_URL for "anthropic".
+func (s *proxySettings) baseURLEnv() map[string]string {
+	env := map[string]string{}
+	if s == nil {
+		return env
+	}
+	for provider, u := range s.BaseURLs {
+		env[strings.ToUpper(strings.ReplaceAll(provider, "-", "_"))+"_BASE_URL"] = u
+	}
+	return env
+}
+
+// proxyEnv returns the proxy variables, in both cases. Unset settings
+// remove the variable, so the server's own proxy doesn't leak through.
+func (s *proxySettings) proxyEnv() map[string]string {
+	env := map[string]string{}
+	for _, v := range []struct{ key, value string }{{"HTTP_PROXY", s.HTTPProxy}, {"HTTPS_PROXY", s.HTTPSProxy}, {"NO_PROXY", s.NoProxy}, {"ALL_PROXY", ""}} {
+		env[v.key] = v.value
+		env[strings.ToLower(v.key)] = v.value
+	}
+	return env
+}
+
+// upstream returns the proxy for a request to host over scheme (http or
+// https), or nil to connect directly.
+func (s *proxySettings) upstream(scheme, host string) *url.URL {
+	if s == nil {
+		return nil
+	}
+	raw := s.HTTPProxy
+	if scheme == "https" {
+		raw = s.HTTPSProxy
+	}
+	if raw == "" || noProxyMatch(s.NoProxy, host) {
+		return nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil
+	}
+	return u
+}
+
+// noProxyMatch reports whether host is excluded by a NO_PROXY list:
+// "*", exact hosts, and domains with or without a leading dot.
+func noProxyMatch(noProxy, host string) bool {
+	host = strings.ToLower(host)
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "*" {
+			return true
+		}
+		entry = strings.TrimPrefix(entry, ".")
+		if entry != "" && (host == entry || strings.HasSuffix(host, "."+entry)) {
+			return true
+		}
+	}
+	return false
+}
+
+// setEnv returns env with the given variables replaced; an empty value
+// removes the variable.
+func setEnv(env []string, vars map[string]string) []string {
+	out := make([]string, 0, len(env)+len(vars))
+	for _, kv := range env {
+		key, _, _ := strings.Cut(kv, "=")
+		if _, ok := vars[key]; !ok {
+			out = append(out, kv)
+		}
+	}
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if vars[k] != "" {
+			out = append(out, k+"="+vars[k])
+		}
+	}
+	return out
+}
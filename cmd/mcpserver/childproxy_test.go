@@ -0,0 +1,120 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// Test proxy settings reach opencode processes, per tenant
+func TestChildProxyEnv(t *testing.T) {
+	t.Setenv("HTTP_PROXY", "http://daemon-only:3128")
+	cfg := serverConfig{
+		Proxy: &proxySettings{HTTPSProxy: "http://corp:3128", NoProxy: ".corp.internal", BaseURLs: map[string]string{"anthropic": "https://llm-gw.corp/anthropic", "azure-openai": "https://llm-gw.corp/azure"}},
+		Tenants: map[string]tenantPolicy{
+			"lab": {Proxy: &proxySettings{HTTPProxy: "http://lab:8080"}},
+		},
+	}
+	env := func(tenant string) string {
+		cmd := exec.Command("true")
+		release, err := prepareChild(cfg.forTenant(tenant), cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		release()
+		return "\n" + strings.Join(cmd.Env, "\n") + "\n"
+	}
+	got := env("team-a")
+	for _, want := range []string{"\nHTTPS_PROXY=http://corp:3128\n", "\nhttps_proxy=http://corp:3128\n", "\nNO_PROXY=.corp.internal\n", "\nANTHROPIC_BASE_URL=https://llm-gw.corp/anthropic\n", "\nAZURE_OPENAI_BASE_URL=https://llm-gw.corp/azure\n"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("default tenant env lacks %q", strings.TrimSpace(want))
+		}
+	}
+	if strings.Contains(got, "daemon-only") {
+		t.Error("the server's own HTTP_PROXY leaked into the child")
+	}
+	got = env("lab")
+	if !strings.Contains(got, "\nHTTP_PROXY=http://lab:8080\n") || strings.Contains(got, "corp") {
+		t.Errorf("lab env = %s", got)
+	}
+
+	if err := (&proxySettings{HTTPProxy: "socks5://x:1"}).validate(); err == nil {
+		t.Error("accepted a socks5 proxy")
+	}
+	if err := (&proxySettings{BaseURLs: map[string]string{"Anthropic": "https://x"}}).validate(); err == nil {
+		t.Error("accepted an upper-case provider ID")
+	}
+	for host, want := range map[string]bool{"api.corp.internal": true, "corp.internal": true, "notcorp.internal": false} {
+		if got := noProxyMatch(".corp.internal, localhost", host); got != want {
+			t.Errorf("noProxyMatch(%q) = %v", host, got)
+		}
+	}
+}
+
+// Test the egress proxy forwards allowed traffic through the tenant's proxy
+func TestEgressUpstreamProxy(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("hi")) }))
+	defer backend.Close()
+	var connects, plain atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Proxy-Authorization") == "" {
+			http.Error(w, "auth", http.StatusProxyAuthRequired)
+			return
+		}
+		if r.Method != http.MethodConnect {
+			plain.Add(1)
+			w.Write([]byte("from upstream"))
+			return
+		}
+		connects.Add(1)
+		conn, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		client, buf, _ := w.(http.Hijacker).Hijack()
+		client.Write([]byte("HTTP/1.1 200 OK\r\n\r\n"))
+		go io.Copy(conn, buf)
+		io.Copy(client, conn)
+		client.Close()
+		conn.Close()
+	}))
+	defer upstream.Close()
+	upstreamURL := strings.Replace(upstream.URL, "http://", "http://user:secret@", 1)
+
+	p := newEgressProxy()
+	proxyURL, release, err := p.grant(&egressPolicy{AllowHosts: []string{"127.0.0.1", "provider.example"}}, &proxySettings{HTTPProxy: upstreamURL, HTTPSProxy: upstreamURL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	u, _ := url.Parse(proxyURL)
+	tr := backend.Client().Transport.(*http.Transport).Clone()
+	tr.Proxy = http.ProxyURL(u)
+	client := &http.Client{Transport: tr}
+
+	resp, err := client.Get(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(b) != "hi" || connects.Load() != 1 {
+		t.Errorf("https: %q, %d CONNECTs upstream", b, connects.Load())
+	}
+	resp, err = client.Get("http://provider.example/v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(b) != "from upstream" || plain.Load() != 1 {
+		t.Errorf("http: %q, %d plain requests upstream", b, plain.Load())
+	}
+}
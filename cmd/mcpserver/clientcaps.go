@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"opencode-mcp/internal/mcp"
+)
+
+// clientCapabilities is what a client declared in initialize. Sampling and
+// roots are recorded for troubleshooting; elicitation lets the server ask
+// for missing arguments, and the protocol version decides how progress and
+// structured results are sent.
+type clientCapabilities struct {
+	ProtocolVersion  string `json:"protocolVersion,omitempty"`
+	Sampling         bool   `json:"sampling,omitempty"`
+	Roots            bool   `json:"roots,omitempty"`
+	RootsListChanged bool   `json:"rootsListChanged,omitempty"`
+	Elicitation      bool   `json:"elicitation,omitempty"`
+}
+
+// Protocol revisions that changed what clients expect.
+const (
+	// protocolProgressTokens is the first revision whose clients are
+	// assumed to follow the spec on progress: they get it only when they
+	// ask with _meta.progressToken.
+	protocolProgressTokens = mcp.VersionProgressTokens
+	// protocolStructuredContent introduced structuredContent and
+	// outputSchema.
+	protocolStructuredContent = mcp.VersionStructuredContent
+	// protocolResourceLinks introduced resource_link content.
+	protocolResourceLinks = mcp.VersionResourceLinks
+)
+
+// parseClientCapabilities reads the params of initialize.
+func parseClientCapabilities(params json.RawMessage) clientCapabilities {
+	var p struct {
+		ProtocolVersion string `json:"protocolVersion"`
+		Capabilities    struct {
+			Sampling    *json.RawMessage `json:"sampling"`
+			Elicitation *json.RawMessage `json:"elicitation"`
+			Roots       *struct {
+				ListChanged bool `json:"listChanged"`
+			} `json:"roots"`
+		} `json:"capabilities"`
+	}
+	_ = json.Unmarshal(params, &p)
+	return clientCapabilities{
+		ProtocolVersion:  p.ProtocolVersion,
+		Sampling:         p.Capabilities.Sampling != nil,
+		Roots:            p.Capabilities.Roots != nil,
+		RootsListChanged: p.Capabilities.Roots != nil && p.Capabilities.Roots.ListChanged,
+		Elicitation:      p.Capabilities.Elicitation != nil,
+	}
+}
+
+// names lists the declared capabilities, e.g. for /status.
+func (c clientCapabilities) names() []string {
+	names := []string{}
+	if c.Elicitation {
+		names = append(names, "elicitation")
+	}
+	if c.Roots {
+		names = append(names, "roots")
+	}
+	if c.RootsListChanged {
+		names = append(names, "roots.listChanged")
+	}
+	if c.Sampling {
+		names = append(names, "sampling")
+	}
+	return names
+}
+
+// speaks reports whether the client declared protocol revision version or
+// a later one. Revisions are dates, so they compare as strings. Clients
+// that declared none (curl, scripts) count as current.
+func (c clientCapabilities) speaks(version string) bool {
+	return c.ProtocolVersion == "" || c.ProtocolVersion >= version
+}
+
+// capabilities returns what the session's client declared; the zero value
+// for calls without a session.
+func (s *session) capabilities() clientCapabilities {
+	if s == nil {
+		return clientCapabilities{}
+	}
+	return s.caps
+}
+
+// progressToken returns the token for the call's progress notifications:
+// the client's _meta.progressToken, or for clients from before
+// protocolProgressTokens (and those that declared no version) the request
+// ID. nil means the client wants no progress.
+func (c *toolCall) progressToken() any {
+	if c.ProgressToken != nil && string(c.ProgressToken) != "null" {
+		return c.ProgressToken
+	}
+	if caps := c.Session.capabilities(); caps.ProtocolVersion != "" && caps.speaks(protocolProgressTokens) {
+		return nil
+	}
+	return c.ID
+}
+
+// adaptProgress gives a progress notification the call's token, reporting
+// false when the client wants none. Other messages are returned as they are.
+func (c *toolCall) adaptProgress(msg any) (any, bool) {
+	m, ok := msg.(map[string]any)
+	if !ok || m["method"] != "notifications/progress" {
+		return msg, true
+	}
+	token := c.progressToken()
+	if token == nil {
+		return nil, false
+	}
+	return withParam(m, "progressToken", token), true
+}
+
+// forClient drops what the client's protocol revision doesn't know from a
+// tool result, and turns resource links into text for clients before
+// them. r itself may be cached, so it is copied.
+func (r *toolCallResult) forClient(caps clientCapabilities) *toolCallResult {
+	if r == nil || caps.speaks(protocolStructuredContent) && caps.speaks(protocolResourceLinks) {
+		return r
+	}
+	out := *r
+	if !caps.speaks(protocolStructuredContent) {
+		out.StructuredContent = nil
+	}
+	out.Content = mcp.ContentFor(r.Content, caps.ProtocolVersion)
+	return &out
+}
+
+// toolsForClient drops the annotations and output schemas of tools from
+// clients whose protocol revision predates them.
+func toolsForClient(tools []mcpTool, caps clientCapabilities) []mcpTool {
+	return mcp.ToolsFor(tools, caps.ProtocolVersion)
+}
+
+// clientSummary counts the sessions of one kind of client in /status.
+type clientSummary struct {
+	Client          string   `json:"client"`
+	ProtocolVersion string   `json:"protocolVersion,omitempty"`
+	Capabilities    []string `json:"capabilities"`
+	Sessions        int      `json:"sessions"`
+}
+
+// clients summarizes the sessions of this instance by client name,
+// protocol version and capabilities.
+func (s *sessionStore) clients() []clientSummary {
+	type kind struct {
+		client string
+		caps   clientCapabilities
+	}
+	s.mu.RLock()
+	counts := map[kind]*clientSummary{}
+	for _, sess := range s.sessions {
+		k := kind{sess.client, sess.caps}
+		if c := counts[k]; c != nil {
+			c.Sessions++
+			continue
+		}
+		counts[k] = &clientSummary{Client: sess.client, ProtocolVersion: sess.caps.ProtocolVersion, Capabilities: sess.caps.names(), Sessions: 1}
+	}
+	s.mu.RUnlock()
+	out := make([]clientSummary, 0, len(counts))
+	for _, c := range counts {
+		out = append(out, *c)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Sessions != out[j].Sessions {
+			return out[i].Sessions > out[j].Sessions
+		}
+		if out[i].Client != out[j].Client {
+			return out[i].Client < out[j].Client
+		}
+		if out[i].ProtocolVersion != out[j].ProtocolVersion {
+			return out[i].ProtocolVersion < out[j].ProtocolVersion
+		}
+		return strings.Join(out[i].Capabilities, ",") < strings.Join(out[j].Capabilities, ",")
+	})
+	return out
+}
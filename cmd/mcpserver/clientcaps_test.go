@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"opencode-mcp/internal/mcp"
+)
+
+// Test declared capabilities are recorded per session and decide how
+// progress, structured results and output schemas are sent
+func TestClientCapabilities(t *testing.T) {
+	sessions := &sessionStore{sessions: make(map[string]*session)}
+	srv := httptest.NewServer(newMCPHandler(sessions, serverConfig{}))
+	defer srv.Close()
+	initialize := func(params string) *session {
+		t.Helper()
+		resp, err := http.Post(srv.URL, "application/json", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":`+params+`}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return sessions.get(resp.Header.Get("Mcp-Session-Id"))
+	}
+	modern := initialize(`{"protocolVersion":"2025-03-26","capabilities":{"sampling":{},"roots":{"listChanged":true}},"clientInfo":{"name":"claude-ai"}}`)
+	current := initialize(`{"protocolVersion":"2025-06-18","capabilities":{"elicitation":{}},"clientInfo":{"name":"vscode"}}`)
+	legacy := initialize(`{"protocolVersion":"2024-11-05","capabilities":{},"clientInfo":{"name":"claude-ai"}}`)
+	if want := (clientCapabilities{ProtocolVersion: "2025-03-26", Sampling: true, Roots: true, RootsListChanged: true}); modern.caps != want {
+		t.Errorf("caps = %+v, want %+v", modern.caps, want)
+	}
+
+	clients := sessions.clients()
+	if len(clients) != 3 || clients[0].Client != "claude-ai" || clients[0].ProtocolVersion != "2024-11-05" || len(clients[0].Capabilities) != 0 {
+		t.Errorf("clients = %+v", clients)
+	}
+	if got := strings.Join(clients[1].Capabilities, ","); got != "roots,roots.listChanged,sampling" {
+		t.Errorf("capabilities = %s", got)
+	}
+
+	for _, tt := range []struct {
+		name  string
+		sess  *session
+		token string
+		want  string // progressToken sent; empty for none
+	}{
+		{"no session", nil, "", "7"},
+		{"legacy", legacy, "", "7"},
+		{"modern without token", modern, "", ""},
+		{"modern with token", modern, `"tok"`, `"tok"`},
+		{"legacy with token", legacy, `3`, `3`},
+	} {
+		var sent []any
+		call := &toolCall{ID: json.RawMessage(`7`), Session: tt.sess, notify: func(msg any) { sent = append(sent, msg) }}
+		if tt.token != "" {
+			call.ProgressToken = json.RawMessage(tt.token)
+		}
+		call.progress(1, "hello")
+		if tt.want == "" {
+			if len(sent) != 0 {
+				t.Errorf("%s: sent %+v", tt.name, sent)
+			}
+			continue
+		}
+		if len(sent) != 1 {
+			t.Fatalf("%s: sent %d notifications", tt.name, len(sent))
+		}
+		token, _ := json.Marshal(sent[0].(map[string]any)["params"].(map[string]any)["progressToken"])
+		if string(token) != tt.want {
+			t.Errorf("%s: progressToken = %s, want %s", tt.name, token, tt.want)
+		}
+	}
+
+	result := &toolCallResult{Content: []toolContent{{Type: "text", Text: "{}"}}, StructuredContent: map[string]any{}}
+	if r := result.forClient(modern.caps); r.StructuredContent != nil || result.StructuredContent == nil {
+		t.Error("structuredContent kept for a 2025-03-26 client, or dropped from the original")
+	}
+	if r := result.forClient(current.caps); r.StructuredContent == nil {
+		t.Error("structuredContent dropped for a 2025-06-18 client")
+	}
+	tools := []mcpTool{{Name: "x", OutputSchema: map[string]any{}, Annotations: mcp.ReadOnly()}}
+	if toolsForClient(tools, legacy.caps)[0].OutputSchema != nil || tools[0].OutputSchema == nil {
+		t.Error("outputSchema listed for a 2024-11-05 client, or dropped from the original")
+	}
+	if toolsForClient(tools, legacy.caps)[0].Annotations != nil || toolsForClient(tools, modern.caps)[0].Annotations == nil {
+		t.Error("annotations listed for a 2024-11-05 client, or dropped for a 2025-03-26 one")
+	}
+}
+
+// Test initialize echoes the client's revision when supported, and later
+// requests naming an unsupported one are refused
+func TestProtocolVersionNegotiation(t *testing.T) {
+	srv := httptest.NewServer(newMCPHandler(&sessionStore{sessions: make(map[string]*session)}, serverConfig{}))
+	defer srv.Close()
+	post := func(body, version string) (*http.Response, map[string]any) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if version != "" {
+			req.Header.Set("MCP-Protocol-Version", version)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var out map[string]any
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp, out
+	}
+	for requested, want := range map[string]string{
+		"2024-11-05": "2024-11-05",
+		"2025-03-26": "2025-03-26",
+		"2025-06-18": "2025-06-18",
+		"2099-01-01": mcp.ProtocolVersion,
+	} {
+		_, out := post(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"`+requested+`"}}`, "")
+		if got := out["result"].(map[string]any)["protocolVersion"]; got != want {
+			t.Errorf("requested %s: protocolVersion = %v, want %s", requested, got, want)
+		}
+	}
+
+	if resp, _ := post(`{"jsonrpc":"2.0","id":2,"method":"tools/list"}`, "2025-06-18"); resp.StatusCode != http.StatusOK {
+		t.Errorf("supported MCP-Protocol-Version: status %d", resp.StatusCode)
+	}
+	if resp, out := post(`{"jsonrpc":"2.0","id":2,"method":"tools/list"}`, "1999-01-01"); resp.StatusCode != http.StatusBadRequest || out["error"] == nil {
+		t.Errorf("unsupported MCP-Protocol-Version: status %d, %v", resp.StatusCode, out)
+	}
+}
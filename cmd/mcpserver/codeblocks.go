@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"opencode-mcp/internal/mcp"
+)
+
+// With "codeBlocks": true, each fenced code block of an opencode_run answer
+// is also returned as its own embedded resource, carrying its language and
+// a suggested filename when one can be derived, so clients can offer to
+// copy or apply it without scraping Markdown.
+
+// codeBlockURIPrefix starts the URIs of extracted code blocks; they name
+// the block within its result and can't be read with resources/read.
+const codeBlockURIPrefix = "opencode://code/"
+
+// codeBlock is a fenced code block of an answer.
+type codeBlock struct {
+	Language string `json:"language,omitempty"`
+	Filename string `json:"filename,omitempty"` // suggested, relative
+	Code     string `json:"code"`
+}
+
+var (
+	// fenceRe matches an opening or closing code fence.
+	fenceRe = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})(.*)$")
+	// headerCommentRe matches a first line naming the file, e.g.
+	// "// cmd/main.go" or "# file: setup.py".
+	headerCommentRe = regexp.MustCompile(`^\s*(?://|#|--|;|/\*|<!--)\s*(?:(?:file(?:name)?|path)\s*:\s*)?([\w./-]+\.[A-Za-z0-9]+)\s*(?:\*/|-->)?\s*$`)
+	// quotedPathRe matches a path in backticks, e.g. in "Update `main.go`:".
+	quotedPathRe = regexp.MustCompile("`([\\w./-]+\\.[A-Za-z0-9]+)`")
+)
+
+// languageByExt names the language of a file extension, for blocks whose
+// fence gives a filename but no language.
+var languageByExt = map[string]string{
+	"go": "go", "py": "python", "js": "javascript", "jsx": "jsx", "ts": "typescript", "tsx": "tsx",
+	"rs": "rust", "java": "java", "kt": "kotlin", "rb": "ruby", "php": "php", "cs": "csharp",
+	"c": "c", "h": "c", "cc": "cpp", "cpp": "cpp", "hpp": "cpp", "swift": "swift",
+	"sh": "bash", "bash": "bash", "zsh": "zsh", "ps1": "powershell", "sql": "sql",
+	"json": "json", "yaml": "yaml", "yml": "yaml", "toml": "toml", "xml": "xml",
+	"html": "html", "css": "css", "md": "markdown", "proto": "protobuf", "tf": "hcl",
+}
+
+// mimeByLanguage overrides the text/x-<language> type of a block.
+var mimeByLanguage = map[string]string{
+	"json": "application/json", "yaml": "application/yaml", "xml": "application/xml",
+	"html": "text/html", "css": "text/css", "markdown": "text/markdown", "javascript": "text/javascript",
+}
+
+// extractCodeBlocks returns the fenced code blocks of answer in order. A
+// block left open runs to the end of the answer.
+func extractCodeBlocks(answer string) []codeBlock {
+	var blocks []codeBlock
+	lines := strings.Split(answer, "\n")
+	for i := 0; i < len(lines); i++ {
+		m := fenceRe.FindStringSubmatch(lines[i])
+		if m == nil || (m[1][0] == '`' && strings.Contains(m[2], "`")) {
+			continue
+		}
+		fence, info := m[1], strings.TrimSpace(m[2])
+		start := i + 1
+		end := len(lines)
+		for j := start; j < len(lines); j++ {
+			if c := fenceRe.FindStringSubmatch(lines[j]); c != nil && c[1][0] == fence[0] && len(c[1]) >= len(fence) && strings.TrimSpace(c[2]) == "" {
+				end = j
+				break
+			}
+		}
+		b := codeBlock{Code: strings.Join(lines[start:end], "\n")}
+		b.Language, b.Filename = parseFenceInfo(info)
+		if b.Filename == "" && start < end {
+			if hm := headerCommentRe.FindStringSubmatch(lines[start]); hm != nil {
+				b.Filename = hm[1]
+			}
+		}
+		if b.Filename == "" {
+			b.Filename = filenameBefore(lines[:i])
+		}
+		if b.Filename != "" && !isSafeArtifactPath(path.Clean(b.Filename)) {
+			b.Filename = ""
+		}
+		if b.Language == "" && b.Filename != "" {
+			b.Language = languageByExt[strings.TrimPrefix(path.Ext(b.Filename), ".")]
+		}
+		blocks = append(blocks, b)
+		i = end
+	}
+	return blocks
+}
+
+// parseFenceInfo reads the language and filename from a fence's info
+// string: "go", "go main.go", "go:main.go", "main.go", or attributes such
+// as title="main.go".
+func parseFenceInfo(info string) (language, filename string) {
+	fields := strings.Fields(info)
+	for i, f := range fields {
+		if k, v, ok := strings.Cut(f, "="); ok {
+			switch strings.ToLower(k) {
+			case "title", "file", "filename", "path":
+				filename = strings.Trim(v, `"'`)
+			}
+			continue
+		}
+		if i == 0 {
+			if lang, file, ok := strings.Cut(f, ":"); ok {
+				language, filename = strings.ToLower(lang), file
+				continue
+			}
+			if !strings.ContainsAny(f, "./") {
+				language = strings.ToLower(f)
+				continue
+			}
+		}
+		if filename == "" && strings.ContainsAny(f, "./") {
+			filename = f
+		}
+	}
+	return language, filename
+}
+
+// filenameBefore returns the path the text right before a block names,
+// e.g. "**`internal/x.go`**" or "Create `setup.py`:".
+func filenameBefore(lines []string) string {
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := strings.TrimSpace(lines[i])
+		if line == "" {
+			continue
+		}
+		matches := quotedPathRe.FindAllStringSubmatch(line, -1)
+		if len(matches) == 0 {
+			return ""
+		}
+		name := matches[len(matches)-1][1]
+		bare := strings.Trim(line, "*_#>:` ")
+		if strings.HasSuffix(line, ":") || bare == name {
+			return name
+		}
+		return ""
+	}
+	return ""
+}
+
+// content is the embedded resource of the block, the i-th of its result.
+func (b codeBlock) content(i int) toolContent {
+	uri := codeBlockURIPrefix + strconv.Itoa(i+1)
+	if b.Filename != "" {
+		uri += "/" + escapeArtifactName(path.Clean(b.Filename))
+	}
+	mimeType := "text/plain"
+	if b.Language != "" {
+		mimeType = "text/x-" + b.Language
+		if t, ok := mimeByLanguage[b.Language]; ok {
+			mimeType = t
+		}
+	}
+	meta := map[string]any{"codeBlock": i + 1}
+	if b.Language != "" {
+		meta["language"] = b.Language
+	}
+	if b.Filename != "" {
+		meta["filename"] = b.Filename
+	}
+	return toolContent{
+		Type:     "resource",
+		Resource: &mcp.ResourceContents{URI: uri, MimeType: mimeType, Text: b.Code},
+		Meta:     meta,
+	}
+}
+
+// codeBlockMiddleware appends the code blocks of an opencode_run answer to
+// its content and structuredContent when the call asks for them.
+func codeBlockMiddleware(next toolHandler) toolHandler {
+	return func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+		if call.Name != toolRun {
+			return next(ctx, call)
+		}
+		var args struct {
+			CodeBlocks bool `json:"codeBlocks"`
+		}
+		if err := json.Unmarshal(call.Arguments, &args); err != nil || !args.CodeBlocks {
+			return next(ctx, call)
+		}
+		result, mErr := next(ctx, call)
+		if result == nil || result.answer == "" {
+			return result, mErr
+		}
+		blocks := extractCodeBlocks(result.answer)
+		for i, b := range blocks {
+			result.Content = append(result.Content, b.content(i))
+		}
+		if rr, ok := result.StructuredContent.(runResult); ok && len(blocks) > 0 {
+			rr.CodeBlocks = blocks
+			result.StructuredContent = rr
+		}
+		return result, mErr
+	}
+}
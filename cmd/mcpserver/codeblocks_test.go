@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExtractCodeBlocks(t *testing.T) {
+	answer := "Here is the fix.\n\n" +
+		"```go title=\"cmd/main.go\"\npackage main\n```\n\n" +
+		"Create `setup.py`:\n\n```python\nfrom setuptools import setup\n```\n\n" +
+		"~~~\n// internal/x.ts\nexport {}\n~~~\n\n" +
+		"```rust:src/lib.rs\nfn f() {}\n```\n" +
+		"````md\n```sh\nnested\n```\n````\n" +
+		"```sh ../../etc/passwd\nrm -rf /\n```\n" +
+		"```\nunclosed"
+	want := []codeBlock{
+		{Language: "go", Filename: "cmd/main.go", Code: "package main"},
+		{Language: "python", Filename: "setup.py", Code: "from setuptools import setup"},
+		{Language: "typescript", Filename: "internal/x.ts", Code: "// internal/x.ts\nexport {}"},
+		{Language: "rust", Filename: "src/lib.rs", Code: "fn f() {}"},
+		{Language: "md", Code: "```sh\nnested\n```"},
+		{Language: "sh", Code: "rm -rf /"},
+		{Code: "unclosed"},
+	}
+	got := extractCodeBlocks(answer)
+	if len(got) != len(want) {
+		t.Fatalf("got %d blocks: %+v", len(got), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("block %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if blocks := extractCodeBlocks("no code, just `inline` spans"); len(blocks) != 0 {
+		t.Errorf("blocks in plain text: %+v", blocks)
+	}
+}
+
+// Test opencode_run returns code blocks as resources only when asked to
+func TestCodeBlockContent(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "opencode")
+	content := `#!/bin/sh
+echo '{"type":"text","part":{"text":"Add this:\n\n` + "```go main.go" + `\npackage main\n` + "```" + `\n"}}'
+`
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	tools := newToolHandler(serverConfig{Target: script, DefaultTimeout: 5 * time.Second})
+	run := func(args string) *toolCallResult {
+		t.Helper()
+		result, mErr := tools(context.Background(), &toolCall{ID: json.RawMessage("1"), Name: toolRun, Arguments: json.RawMessage(args)})
+		if mErr != nil || result.IsError {
+			t.Fatalf("run: %+v, %+v", result, mErr)
+		}
+		return result
+	}
+
+	if result := run(`{"message":"x","model":"m"}`); len(result.Content) != 1 {
+		t.Errorf("content without codeBlocks = %+v", result.Content)
+	}
+	result := run(`{"message":"x","model":"m","codeBlocks":true}`)
+	if len(result.Content) != 2 {
+		t.Fatalf("content = %+v", result.Content)
+	}
+	c := result.Content[1]
+	if c.Type != "resource" || c.Resource.URI != "opencode://code/1/main.go" || c.Resource.MimeType != "text/x-go" || c.Resource.Text != "package main" {
+		t.Errorf("code block content = %+v %+v", c, c.Resource)
+	}
+	if c.Meta["language"] != "go" || c.Meta["filename"] != "main.go" {
+		t.Errorf("code block _meta = %+v", c.Meta)
+	}
+	if rr, ok := result.StructuredContent.(runResult); !ok || len(rr.CodeBlocks) != 1 {
+		t.Errorf("structuredContent = %+v", result.StructuredContent)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	minCompareModels = 2
+	maxCompareModels = 4
+
+	// defaultCompareAgent keeps comparison runs from editing files.
+	defaultCompareAgent = "plan"
+)
+
+// compareArgs are the arguments of opencode_compare.
+type compareArgs struct {
+	Message string   `json:"message"`
+	Models  []string `json:"models"`
+	Cwd     string   `json:"cwd"`
+	Files   []string `json:"files"`
+	Agent   string   `json:"agent"`
+	Labels  []string `json:"labels"`
+}
+
+type compareResult struct {
+	Model     string `json:"model"`
+	IsError   bool   `json:"isError,omitempty"`
+	LatencyMs int64  `json:"latencyMs"`
+	runUsage
+	Text string `json:"text"`
+}
+
+var compareSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"message": map[string]any{
+			"type":        "string",
+			"description": "Prompt sent to every model",
+		},
+		"models": map[string]any{
+			"type":        "array",
+			"items":       map[string]any{"type": "string"},
+			"minItems":    minCompareModels,
+			"maxItems":    maxCompareModels,
+			"description": "Models to compare (provider/name)",
+		},
+		"cwd": map[string]any{
+			"type":        "string",
+			"description": "Working directory",
+		},
+		"files": map[string]any{
+			"type":        "array",
+			"items":       map[string]any{"type": "string"},
+			"description": "Files to attach",
+		},
+		"agent": map[string]any{
+			"type":        "string",
+			"description": "Agent to run with (default 'plan', which doesn't edit files)",
+		},
+		"labels": map[string]any{
+			"type":        "array",
+			"items":       map[string]any{"type": "string"},
+			"description": "Labels recorded with every run in the run history",
+		},
+	},
+	"required": []string{"message", "models"},
+}
+
+// compareTool runs the same prompt on several models concurrently and
+// reports each answer with its latency and cost.
+func compareTool(ctx context.Context, cfg serverConfig, call *toolCall) (*toolCallResult, *mcpError) {
+	var args compareArgs
+	if err := json.Unmarshal(call.Arguments, &args); err != nil {
+		return nil, errInvalidArguments.err("invalid arguments")
+	}
+	if args.Message == "" {
+		return nil, errInvalidArguments.err("missing message")
+	}
+	if len(args.Models) < minCompareModels || len(args.Models) > maxCompareModels {
+		return nil, errInvalidArguments.err(fmt.Sprintf("models must list %d to %d models", minCompareModels, maxCompareModels))
+	}
+	for i, m := range args.Models {
+		if m == "" {
+			return nil, errInvalidArguments.err(fmt.Sprintf("models[%d] is empty", i))
+		}
+	}
+	if args.Agent == "" {
+		args.Agent = defaultCompareAgent
+	}
+	log.Printf("[compare] models=%v agent=%s message=%s", args.Models, args.Agent, truncateForLog(args.Message, 80))
+
+	run := runHandler(cfg)
+	results := make([]compareResult, len(args.Models))
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	done := 0
+	for i, model := range args.Models {
+		runArgs, _ := json.Marshal(runToolArgs{
+			Message: args.Message,
+			Cwd:     args.Cwd,
+			Model:   model,
+			Files:   args.Files,
+			Agent:   args.Agent,
+			Labels:  args.Labels,
+		})
+		wg.Add(1)
+		go func(i int, model string) {
+			defer wg.Done()
+			start := time.Now()
+			result, mErr := run(ctx, &toolCall{ID: call.ID, Name: toolRun, Arguments: runArgs, Cwd: call.Cwd, Session: call.Session, Tenant: call.Tenant, Binary: call.Binary})
+			r := compareResult{Model: model, LatencyMs: time.Since(start).Milliseconds()}
+			if mErr != nil {
+				r.IsError, r.Text = true, mErr.Message
+			} else {
+				r.IsError, r.Text, r.runUsage = result.IsError, resultText(result), result.usage
+			}
+			mu.Lock()
+			results[i] = r
+			done++
+			call.progressf(done, "%s finished (%d/%d)", model, done, len(args.Models))
+			mu.Unlock()
+		}(i, model)
+	}
+	wg.Wait()
+
+	var text strings.Builder
+	failed := 0
+	for _, r := range results {
+		status := ""
+		if r.IsError {
+			status = ", error"
+			failed++
+		}
+		fmt.Fprintf(&text, "=== %s (%.1fs, $%.4f, %d in / %d out tokens%s) ===\n%s\n\n",
+			r.Model, float64(r.LatencyMs)/1000, r.Cost, r.InputTokens, r.OutputTokens, status, r.Text)
+	}
+	log.Printf("[compare] done models=%d failed=%d", len(results), failed)
+
+	return &toolCallResult{
+		Content:           []toolContent{{Type: "text", Text: strings.TrimRight(text.String(), "\n")}},
+		StructuredContent: map[string]any{"results": results},
+		IsError:           failed == len(results),
+	}, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test opencode_compare runs each model in plan mode and reports usage
+func TestCompareTool(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "opencode")
+	content := `#!/bin/sh
+model=; agent=; prev=
+for arg; do
+  case "$prev" in
+  --model) model=$arg ;;
+  --agent) agent=$arg ;;
+  esac
+  prev=$arg
+done
+if [ "$model" = "bad/model" ]; then echo "ProviderModelNotFoundError" >&2; exit 1; fi
+printf '{"type":"text","part":{"text":"%s via %s"}}\n' "$model" "$agent"
+echo '{"type":"step_finish","part":{"cost":0.25,"tokens":{"input":100,"output":20}}}'
+`
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	tools := newToolHandler(serverConfig{Target: script, DefaultTimeout: 5 * time.Second})
+
+	result, mErr := tools(context.Background(), &toolCall{ID: json.RawMessage("1"), Name: toolCompare, Arguments: json.RawMessage(
+		`{"message":"which is better?","models":["a/one","b/two","bad/model"]}`)})
+	if mErr != nil {
+		t.Fatalf("unexpected error: %v", mErr)
+	}
+	if result.IsError {
+		t.Error("compare should succeed while at least one model answers")
+	}
+	results := result.StructuredContent.(map[string]any)["results"].([]compareResult)
+	if len(results) != 3 {
+		t.Fatalf("results = %+v", results)
+	}
+	if results[0].Model != "a/one" || !strings.Contains(results[0].Text, "a/one via plan") {
+		t.Errorf("results[0] = %+v", results[0])
+	}
+	if results[1].Cost != 0.25 || results[1].InputTokens != 100 || results[1].OutputTokens != 20 {
+		t.Errorf("usage = %+v", results[1].runUsage)
+	}
+	if !results[2].IsError {
+		t.Errorf("results[2] = %+v", results[2])
+	}
+	if !strings.Contains(result.Content[0].Text, "=== b/two (") {
+		t.Errorf("text = %q", result.Content[0].Text)
+	}
+
+	_, mErr = tools(context.Background(), &toolCall{ID: json.RawMessage("1"), Name: toolCompare, Arguments: json.RawMessage(`{"message":"x","models":["a/one"]}`)})
+	if mErr == nil || mErr.Code != -32602 {
+		t.Errorf("single model error = %v", mErr)
+	}
+}
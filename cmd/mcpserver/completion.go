@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"opencode-mcp/internal/mcp"
+)
+
+// handleComplete answers completion/complete. Model arguments, of prompts
+// and of opencode_run, complete from the cached model list; other
+// arguments get no suggestions.
+func handleComplete(_ context.Context, cfg serverConfig, req *mcpRequest) (any, *mcpError) {
+	params, err := mcp.ParseCompleteParams(req.Params)
+	if err != nil {
+		return nil, errInvalidArguments.err(err.Message)
+	}
+	var candidates []string
+	if params.CompletesModel() {
+		candidates = fetchAvailableModels(cfg.forTenant(req.Tenant))
+	}
+	log.Printf("[MCP] completion/complete ref=%s%s argument=%s value=%q candidates=%d",
+		params.Ref.Type, params.Ref.Name, params.Argument.Name, params.Argument.Value, len(candidates))
+	return mcp.Completion(candidates, params.Argument.Value), nil
+}
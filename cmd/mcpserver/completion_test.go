@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Test completion/complete suggests models from the cached list
+func TestModelCompletion(t *testing.T) {
+	resetModelCache(t)
+	modelCache.Set([]modelInfo{{ID: "openai/gpt-5"}, {ID: "anthropic/claude-sonnet-4"}, {ID: "openai/o3"}})
+	handler := createMCPHandler(&sessionStore{sessions: make(map[string]*session)}, serverConfig{})
+	complete := func(params string) map[string]any {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"completion/complete","params":`+params+`}`))
+		w := httptest.NewRecorder()
+		handler(w, req)
+		var resp struct {
+			Result struct {
+				Completion map[string]any `json:"completion"`
+			} `json:"result"`
+			Error *mcpError `json:"error"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Error != nil {
+			t.Fatalf("completion/complete = %s", w.Body.String())
+		}
+		return resp.Result.Completion
+	}
+
+	got := complete(`{"ref":{"type":"ref/prompt","name":"code-review"},"argument":{"name":"model","value":"openai/"}}`)
+	if want := []any{"openai/gpt-5", "openai/o3"}; !reflect.DeepEqual(got["values"], want) {
+		t.Errorf("values = %v, want %v", got["values"], want)
+	}
+	got = complete(`{"ref":{"type":"ref/tool","name":"opencode_run"},"argument":{"name":"model","value":"sonnet"}}`)
+	if want := []any{"anthropic/claude-sonnet-4"}; !reflect.DeepEqual(got["values"], want) {
+		t.Errorf("values = %v, want %v", got["values"], want)
+	}
+	got = complete(`{"ref":{"type":"ref/prompt","name":"code-review"},"argument":{"name":"focus","value":""}}`)
+	if values, _ := got["values"].([]any); len(values) != 0 {
+		t.Errorf("focus values = %v", got["values"])
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Transcripts and artifacts of large runs are mostly verbose JSON and text,
+// so they are stored gzip-compressed (MCP_COMPRESSION). Readers decompress
+// transparently, and documents or files written uncompressed, e.g. before
+// compression was enabled, are read as they are.
+const (
+	compressionGzip = "gzip"
+	compressionOff  = "off"
+
+	// compressMinBytes is the size below which compressing isn't worth it.
+	compressMinBytes = 1024
+
+	// artifactGzipSuffix marks an artifact file stored compressed; it is
+	// served under its name without the suffix.
+	artifactGzipSuffix = ".mcpgz"
+)
+
+func validateCompression(s string) error {
+	if s != compressionGzip && s != compressionOff {
+		return fmt.Errorf("invalid compression %q (want %q or %q)", s, compressionGzip, compressionOff)
+	}
+	return nil
+}
+
+// compressedDoc is a store document holding another one compressed.
+type compressedDoc struct {
+	Encoding string `json:"encoding"` // gzip
+	Size     int    `json:"size"`     // of the uncompressed document
+	Data     []byte `json:"data"`
+}
+
+// putCompressed is put, compressing the document when the store compresses
+// and it is worth it.
+func (s *store) putCompressed(collection, key string, v any) error {
+	doc, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	stored := json.RawMessage(doc)
+	if s.compress && len(doc) >= compressMinBytes {
+		if data, err := gzipBytes(doc); err == nil && len(data) < len(doc)*9/10 {
+			if enc, err := json.Marshal(compressedDoc{compressionGzip, len(doc), data}); err == nil && len(enc) < len(doc) {
+				stored = enc
+			}
+		}
+	}
+	compressionStats.record(collection, int64(len(doc)), int64(len(stored)))
+	return s.driver.put(collection, key, stored)
+}
+
+// getCompressed is get for documents written by putCompressed.
+func (s *store) getCompressed(collection, key string, v any) (bool, error) {
+	doc, ok, err := s.driver.get(collection, key)
+	if !ok || err != nil {
+		return false, err
+	}
+	var c compressedDoc
+	if json.Unmarshal(doc, &c) == nil && c.Encoding == compressionGzip && c.Data != nil {
+		zr, err := gzip.NewReader(bytes.NewReader(c.Data))
+		if err != nil {
+			return false, fmt.Errorf("%s/%s: %w", collection, key, err)
+		}
+		if doc, err = io.ReadAll(zr); err != nil {
+			return false, fmt.Errorf("%s/%s: %w", collection, key, err)
+		}
+	}
+	return true, json.Unmarshal(doc, v)
+}
+
+func gzipBytes(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(b); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// compressArtifact replaces the artifact file at path with a compressed
+// copy when that saves space, returning the bytes it now takes. Files of
+// already compressed types are left alone.
+func compressArtifact(path, mimeType string, size int64) int64 {
+	if size < compressMinBytes || precompressedType(mimeType) {
+		return size
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		return size
+	}
+	defer in.Close()
+	out, err := os.Create(path + artifactGzipSuffix)
+	if err != nil {
+		return size
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if err == nil {
+		err = zw.Close()
+	}
+	info, statErr := out.Stat()
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil || statErr != nil || info.Size() >= size*9/10 {
+		if err != nil {
+			log.Printf("[artifacts] compress %s: %v", path, err)
+		}
+		_ = os.Remove(path + artifactGzipSuffix)
+		return size
+	}
+	if err := os.Remove(path); err != nil {
+		_ = os.Remove(path + artifactGzipSuffix)
+		return size
+	}
+	return info.Size()
+}
+
+// precompressedType reports whether files of mimeType are compressed
+// already, so gzip would gain nothing.
+func precompressedType(mimeType string) bool {
+	mimeType, _, _ = strings.Cut(mimeType, ";")
+	switch {
+	case mimeType == "image/svg+xml":
+		return false
+	case strings.HasPrefix(mimeType, "image/"), strings.HasPrefix(mimeType, "video/"), strings.HasPrefix(mimeType, "audio/"):
+		return true
+	}
+	switch mimeType {
+	case "application/zip", "application/gzip", "application/x-gzip", "application/zstd",
+		"application/x-7z-compressed", "application/x-xz", "application/x-bzip2", "application/pdf":
+		return true
+	}
+	return false
+}
+
+// serveCompressedArtifact serves the compressed artifact f as is to clients
+// accepting gzip, and decompressed to others.
+func serveCompressedArtifact(w http.ResponseWriter, r *http.Request, f *os.File, mimeType string) {
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set("Vary", "Accept-Encoding")
+	if acceptsGzip(r.Header.Get("Accept-Encoding")) {
+		w.Header().Set("Content-Encoding", "gzip")
+		if info, err := f.Stat(); err == nil {
+			w.Header().Set("Content-Length", fmt.Sprint(info.Size()))
+		}
+		_, _ = io.Copy(w, f)
+		return
+	}
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		http.Error(w, "corrupt artifact", http.StatusInternalServerError)
+		return
+	}
+	_, _ = io.Copy(w, zr)
+}
+
+// gzipCodings are the content codings matching gzip, by specificity.
+var gzipCodings = map[string]int{"*": 0, "gzip": 1, "x-gzip": 1}
+
+// acceptsGzip reports whether an Accept-Encoding header admits gzip.
+func acceptsGzip(header string) bool {
+	return acceptQuality(header, gzipCodings) > 0
+}
+
+// compressionMetrics totals what was written compressible since startup, by
+// collection or "artifacts", for GET /status.
+type compressionMetrics struct {
+	mu    sync.Mutex
+	kinds map[string]*compressionSnapshot
+}
+
+type compressionSnapshot struct {
+	Items       int64   `json:"items"`
+	RawBytes    int64   `json:"rawBytes"`
+	StoredBytes int64   `json:"storedBytes"`
+	Ratio       float64 `json:"ratio"` // raw / stored
+}
+
+// compressionStats are this process's compression metrics.
+var compressionStats = &compressionMetrics{}
+
+func (m *compressionMetrics) record(kind string, raw, stored int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.kinds == nil {
+		m.kinds = map[string]*compressionSnapshot{}
+	}
+	s := m.kinds[kind]
+	if s == nil {
+		s = &compressionSnapshot{}
+		m.kinds[kind] = s
+	}
+	s.Items++
+	s.RawBytes += raw
+	s.StoredBytes += stored
+}
+
+func (m *compressionMetrics) get() map[string]compressionSnapshot {
+	out := map[string]compressionSnapshot{}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for kind, s := range m.kinds {
+		snap := *s
+		if snap.StoredBytes > 0 {
+			snap.Ratio = math.Round(float64(snap.RawBytes)/float64(snap.StoredBytes)*100) / 100
+		}
+		out[kind] = snap
+	}
+	return out
+}
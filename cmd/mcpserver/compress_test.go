@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test large transcripts are stored compressed and read back transparently,
+// while small and older uncompressed ones are read as they are
+func TestTranscriptCompression(t *testing.T) {
+	st, _ := openStore("")
+	st.compress = true
+	h := runHistory{st}
+	large := runTranscript{Prompt: "p"}
+	for i := 0; i < 200; i++ {
+		large.Entries = append(large.Entries, transcriptEntry{Type: "tool", Tool: "bash", Output: strings.Repeat("go test ./... ok\n", 10)})
+	}
+	if err := h.saveTranscript("t", "big", large); err != nil {
+		t.Fatal(err)
+	}
+	doc, _, _ := st.driver.get(transcriptsCollection, runKey("t", "big"))
+	if !bytes.Contains(doc, []byte(`"encoding":"gzip"`)) {
+		t.Fatalf("large transcript stored uncompressed (%d bytes)", len(doc))
+	}
+	got, ok, err := h.transcript("t", "big")
+	if !ok || err != nil || len(got.Entries) != 200 || got.Entries[199].Output != large.Entries[199].Output {
+		t.Errorf("read back %d entries, %v, %v", len(got.Entries), ok, err)
+	}
+	stats := compressionStats.get()[transcriptsCollection]
+	if stats.Items == 0 || stats.Ratio <= 1 {
+		t.Errorf("metrics = %+v", stats)
+	}
+
+	_ = h.saveTranscript("t", "small", runTranscript{Prompt: "hi"})
+	if err := st.put(transcriptsCollection, runKey("t", "legacy"), runTranscript{Prompt: "old"}); err != nil {
+		t.Fatal(err)
+	}
+	for id, want := range map[string]string{"small": "hi", "legacy": "old"} {
+		doc, _, _ := st.driver.get(transcriptsCollection, runKey("t", id))
+		if bytes.Contains(doc, []byte("encoding")) {
+			t.Errorf("%s stored compressed", id)
+		}
+		if got, _, err := h.transcript("t", id); err != nil || got.Prompt != want {
+			t.Errorf("%s = %+v, %v", id, got, err)
+		}
+	}
+}
+
+// Test captured artifacts are compressed on disk and served decompressed
+// or gzip-encoded
+func TestArtifactCompression(t *testing.T) {
+	c := artifactConfig{Root: t.TempDir(), MaxBytes: defaultArtifactMaxBytes, PublicURL: "http://mcp.example", Compress: true}
+	dir := c.artifactDir("team-a", "r1")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	report := strings.Repeat("line of a verbose report\n", 500)
+	files := map[string]string{"report.txt": report, "small.txt": "tiny", "photo.png": report}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	artifacts := captureArtifacts(c, "r1", dir)
+	if len(artifacts) != 3 {
+		t.Fatalf("artifacts = %+v", artifacts)
+	}
+	for _, a := range artifacts {
+		_, plainErr := os.Stat(filepath.Join(dir, a.Name))
+		_, gzErr := os.Stat(filepath.Join(dir, a.Name+artifactGzipSuffix))
+		if compressed := a.Name == "report.txt"; compressed != (gzErr == nil) || compressed == (plainErr == nil) {
+			t.Errorf("%s: plain %v, compressed %v", a.Name, plainErr, gzErr)
+		}
+		if a.Size != int64(len(files[a.Name])) {
+			t.Errorf("%s: size %d, want the uncompressed size", a.Name, a.Size)
+		}
+	}
+
+	mux := http.NewServeMux()
+	registerArtifactRoutes(mux, serverConfig{Artifacts: c})
+	get := func(name, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/artifacts/r1/"+name, nil)
+		req.Header.Set(tenantHeader, "team-a")
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := get("report.txt", ""); rec.Code != http.StatusOK || rec.Body.String() != report || rec.Header().Get("Content-Encoding") != "" {
+		t.Errorf("plain GET = %d, %d bytes, encoding %q", rec.Code, rec.Body.Len(), rec.Header().Get("Content-Encoding"))
+	}
+	rec := get("report.txt", "gzip, br")
+	if rec.Header().Get("Content-Encoding") != "gzip" || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("gzip GET headers = %v", rec.Header())
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := io.ReadAll(zr); string(body) != report {
+		t.Errorf("gzip GET body = %d bytes", len(body))
+	}
+	if rec := get("photo.png", "gzip"); rec.Code != http.StatusOK || rec.Body.String() != report {
+		t.Errorf("uncompressed artifact GET = %d", rec.Code)
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := map[string]bool{
+		"":                   false,
+		"gzip":               true,
+		"br, gzip;q=0.5":     true,
+		"*":                  true,
+		"identity":           false,
+		"gzip;q=0":           false,
+		"*, gzip;q=0":        false,
+		"GZIP, deflate":      true,
+		"br;q=1, x-gzip;q=1": true,
+	}
+	for header, want := range tests {
+		if got := acceptsGzip(header); got != want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", header, got, want)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"opencode-mcp/internal/mcp"
+)
+
+// fileConfig is the optional JSON configuration file referenced by MCP_CONFIG.
+// Environment variables cover the basic settings; the file holds structured
+// settings that don't fit into a single variable.
+type fileConfig struct {
+	Target        string                  `json:"target,omitempty"` // overrides MCP_TARGET; re-read by POST /admin/binary
+	Tools         []customTool            `json:"tools,omitempty"`
+	Plugins       []pluginConfig          `json:"plugins,omitempty"`
+	Servers       []downstreamServer      `json:"servers,omitempty"` // MCP servers whose tools are re-exported
+	Pricing       map[string]modelCost    `json:"pricing,omitempty"` // USD per million tokens, keyed by model or "provider/*"
+	Tenants       map[string]tenantPolicy `json:"tenants,omitempty"`
+	Projects      map[string]project      `json:"projects,omitempty"`
+	Proxy         *proxySettings          `json:"proxy,omitempty"`         // for opencode processes; tenants may override it
+	Agent         string                  `json:"agent,omitempty"`         // the agent CLI the run tools front; default opencode
+	Agents        map[string]string       `json:"agents,omitempty"`        // agent name -> binary path
+	Notifications *notificationConfig     `json:"notifications,omitempty"` // event type -> notification channel and level
+	DisabledTools []string                `json:"disabledTools,omitempty"` // tool name patterns left out of tools/list
+	ServerInfo    *mcp.ServerInfo         `json:"serverInfo,omitempty"`    // overrides MCP_SERVER_NAME and MCP_SERVER_VERSION
+	Instructions  string                  `json:"instructions,omitempty"`  // overrides MCP_INSTRUCTIONS
+}
+
+// loadFileConfig reads and validates the configuration file at path.
+// Warnings (e.g. overlapping allowlist roots) are logged; errors are
+// returned together, each with its line number.
+func loadFileConfig(path string) (fileConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fileConfig{}, fmt.Errorf("read config: %w", err)
+	}
+	fc, issues := lintFileConfig(path, data)
+	var errs []string
+	for _, i := range issues {
+		if i.Warning {
+			log.Printf("[config] %s", i)
+		} else {
+			errs = append(errs, i.String())
+		}
+	}
+	if len(errs) > 0 {
+		return fc, fmt.Errorf("invalid config (run `config lint` for details):\n  %s", strings.Join(errs, "\n  "))
+	}
+	return fc, nil
+}
+
+// applyFileConfig merges the file configuration into cfg.
+func applyFileConfig(cfg *serverConfig, fc fileConfig) {
+	if fc.Target != "" {
+		cfg.Target = fc.Target
+	}
+	cfg.CustomTools = fc.Tools
+	cfg.Plugins = fc.Plugins
+	cfg.Servers = fc.Servers
+	cfg.Pricing = fc.Pricing
+	cfg.Tenants = fc.Tenants
+	cfg.ProjectConfig = fc.Projects
+	cfg.Proxy = fc.Proxy
+	cfg.Agent = fc.Agent
+	cfg.AgentTargets = fc.Agents
+	cfg.Notifications = fc.Notifications
+	cfg.DisabledTools = fc.DisabledTools
+	if fc.ServerInfo != nil && fc.ServerInfo.Name != "" {
+		cfg.ServerInfo.Name = fc.ServerInfo.Name
+	}
+	if fc.ServerInfo != nil && fc.ServerInfo.Version != "" {
+		cfg.ServerInfo.Version = fc.ServerInfo.Version
+	}
+	if fc.Instructions != "" {
+		cfg.Instructions = fc.Instructions
+	}
+}
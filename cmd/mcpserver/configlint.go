@@ -0,0 +1,600 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"opencode-mcp/internal/mcp"
+)
+
+// configIssue is one problem found in the configuration. Path is the
+// setting's location, e.g. tenants.team-a.allowedDirs[1] or an environment
+// variable name.
+type configIssue struct {
+	Source  string // config file path, or "env"
+	Line    int    // 0 when unknown
+	Path    string
+	Message string
+	Warning bool
+}
+
+func (i configIssue) String() string {
+	loc := i.Source
+	if i.Line > 0 {
+		loc += ":" + strconv.Itoa(i.Line)
+	}
+	level := "error"
+	if i.Warning {
+		level = "warning"
+	}
+	if i.Path != "" {
+		return fmt.Sprintf("%s: %s: %s: %s", loc, level, i.Path, i.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", loc, level, i.Message)
+}
+
+func hasErrors(issues []configIssue) bool {
+	for _, i := range issues {
+		if !i.Warning {
+			return true
+		}
+	}
+	return false
+}
+
+// envSetting describes an environment variable the server reads.
+type envSetting struct {
+	Name string
+	Kind string // string, int, bool, duration, backend, store, ...
+}
+
+var envSettings = []envSetting{
+	{"MCP_ADDR", "string"},
+	{"MCP_TARGET", "string"},
+	{"MCP_TIMEOUT_SEC", "int"},
+	{"MCP_DEFAULT_MODEL", "string"},
+	{"MCP_BACKEND", "backend"},
+	{"MCP_SERVE_URL", "string"},
+	{"MCP_OPENCODE_STORAGE", "string"},
+	{"MCP_STRICT_EVENTS", "bool"},
+	{"MCP_REQUIRE_SESSION", "bool"},
+	{"MCP_TRUST_TENANT_HEADER", "bool"},
+	{"MCP_NOTIFY_RATE", "int"},
+	{"MCP_POLL_WAIT", "duration"},
+	{"MCP_STALL_TIMEOUT", "duration"},
+	{"MCP_SHUTDOWN_DRAIN", "duration"},
+	{"MCP_STDIN_MAX_BYTES", "int"},
+	{"MCP_STDIN_TIMEOUT", "duration"},
+	{"MCP_ATTACH_ALLOW_HOSTS", "hosts"},
+	{"MCP_ATTACH_MAX_BYTES", "int"},
+	{"MCP_AUDIT_LOG", "string"},
+	{"MCP_EVENT_WEBHOOKS", "webhooks"},
+	{"MCP_SESSION_TTL", "duration"},
+	{"MCP_MAX_CONCURRENT_RUNS", "int"},
+	{"MCP_CONFIG", "string"},
+	{"MCP_STORE_PATH", "string"},
+	{"MCP_STORE_URL", "store"},
+	{"MCP_ARTIFACT_DIR", "string"},
+	{"MCP_WORKSPACE_DIR", "string"},
+	{"MCP_PROMPTS_DIR", "string"},
+	{"MCP_GIT_SSH_KEY", "string"},
+	{"MCP_WARMUP_INTERVAL", "duration"},
+	{"MCP_ARTIFACT_MAX_MB", "int"},
+	{"MCP_ARTIFACT_RETENTION_HOURS", "int"},
+	{"MCP_PUBLIC_URL", "string"},
+	{"MCP_IDLE_EXIT", "duration"},
+	{"MCP_PID_FILE", "string"},
+	{"MCP_ADMIN_TOKEN", "string"},
+	{"MCP_DEDUPE_WINDOW", "duration"},
+	{"MCP_TIMEZONE", "timezone"},
+	{"MCP_LOCALE", "locale"},
+	{"MCP_SIGNING_KEY", "string"},
+	{"MCP_CHAOS", "chaos"},
+	{"MCP_ARTIFACT_TOTAL_MAX_MB", "int"},
+	{"MCP_DISK_MIN_FREE_MB", "int"},
+	{"MCP_DISK_WARN_FREE_MB", "int"},
+	{"MCP_TRANSCRIPTS_MAX_MB", "int"},
+	{"MCP_ARCHIVE_RETENTION_HOURS", "int"},
+	{"MCP_PROMPT_ANALYTICS", "bool"},
+	{"MCP_MAX_MESSAGE_CHARS", "int"},
+	{"MCP_OVERSIZE", "oversize"},
+	{"MCP_BINARY_OUTPUT", "binaryoutput"},
+	{"MCP_COMPRESSION", "compression"},
+	{"MCP_ROUTE_TIMEOUTS", "routetimeouts"},
+	{"MCP_SERVER_NAME", "string"},
+	{"MCP_SERVER_VERSION", "string"},
+	{"MCP_INSTRUCTIONS", "string"},
+}
+
+// lintEnv checks the MCP_* variables of environ.
+func lintEnv(environ []string) []configIssue {
+	known := map[string]envSetting{}
+	var names []string
+	for _, s := range envSettings {
+		known[s.Name] = s
+		names = append(names, s.Name)
+	}
+	var issues []configIssue
+	for _, kv := range environ {
+		name, value, _ := strings.Cut(kv, "=")
+		if !strings.HasPrefix(name, "MCP_") {
+			continue
+		}
+		s, ok := known[name]
+		if !ok {
+			issues = append(issues, configIssue{Source: "env", Path: name, Message: "unknown variable" + didYouMean(name, names), Warning: true})
+			continue
+		}
+		if value == "" {
+			continue
+		}
+		var msg string
+		switch s.Kind {
+		case "int":
+			if n, err := strconv.Atoi(value); err != nil || n < 0 {
+				msg = fmt.Sprintf("%q is not a non-negative integer", value)
+			}
+		case "bool":
+			switch strings.ToLower(value) {
+			case "1", "true", "yes", "on", "0", "false", "no", "off":
+			default:
+				msg = fmt.Sprintf("%q is not a boolean (true/false)", value)
+			}
+		case "duration":
+			if d, err := time.ParseDuration(value); err != nil || d < 0 {
+				msg = fmt.Sprintf("%q is not a duration like 30m or 1h30m", value)
+			}
+		case "chaos":
+			if _, err := parseChaos(value); err != nil {
+				msg = err.Error()
+			}
+		case "oversize":
+			if err := validateOversize(value); err != nil {
+				msg = err.Error()
+			}
+		case "binaryoutput":
+			if err := validateBinaryOutput(value); err != nil {
+				msg = err.Error()
+			}
+		case "compression":
+			if err := validateCompression(value); err != nil {
+				msg = err.Error()
+			}
+		case "webhooks":
+			if _, err := parseWebhooks(value); err != nil {
+				msg = err.Error()
+			}
+		case "hosts":
+			if _, err := parseAttachHosts(value); err != nil {
+				msg = err.Error()
+			}
+		case "routetimeouts":
+			if _, err := parseRouteTimeouts(value, 0); err != nil {
+				msg = err.Error()
+			}
+		case "locale":
+			if !supportedLocales[value] {
+				msg = fmt.Sprintf("%q is not a supported language (en or zh)", value)
+			}
+		case "timezone":
+			if _, err := time.LoadLocation(value); err != nil {
+				msg = fmt.Sprintf("%q is not an IANA timezone like Europe/Berlin", value)
+			}
+		case "store":
+			if u, err := url.Parse(value); err != nil {
+				msg = fmt.Sprintf("%q is not a URL", value)
+			} else if !storeSchemes[u.Scheme] {
+				msg = fmt.Sprintf("unsupported scheme %q (want memory, file, redis, postgres or sqlite)", u.Scheme)
+			}
+		case "backend":
+			if value != backendCLI && value != backendServe {
+				msg = fmt.Sprintf("%q is not %q or %q", value, backendCLI, backendServe)
+			}
+		}
+		if msg != "" {
+			issues = append(issues, configIssue{Source: "env", Path: name, Message: msg})
+		}
+	}
+	sort.Slice(issues, func(i, j int) bool { return issues[i].Path < issues[j].Path })
+	return issues
+}
+
+// lintFileConfig parses and validates a configuration file, returning the
+// decoded config and every issue found, located by line where possible.
+func lintFileConfig(source string, data []byte) (fileConfig, []configIssue) {
+	var fc fileConfig
+	issue := func(offset int64, path, msg string, warning bool) configIssue {
+		return configIssue{Source: source, Line: lineAt(data, offset), Path: path, Message: msg, Warning: warning}
+	}
+
+	var raw any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return fc, []configIssue{issue(syntaxErr.Offset, "", syntaxErr.Error(), false)}
+		}
+		return fc, []configIssue{{Source: source, Message: err.Error()}}
+	}
+	lines := jsonPathLines(data)
+	located := func(path, msg string, warning bool) configIssue {
+		return configIssue{Source: source, Line: lines[path], Path: path, Message: msg, Warning: warning}
+	}
+
+	var issues []configIssue
+	checkKeys(raw, reflect.TypeOf(fc), "", func(path, msg string) {
+		issues = append(issues, located(path, msg, false))
+	})
+	if err := json.Unmarshal(data, &fc); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return fc, append(issues, issue(typeErr.Offset, typeErr.Field, fmt.Sprintf("expected %s, got %s", typeErr.Type, typeErr.Value), false))
+		}
+		return fc, append(issues, configIssue{Source: source, Message: err.Error()})
+	}
+
+	for _, err := range []error{
+		validateCustomTools(fc.Tools),
+		validatePlugins(fc.Plugins),
+		validateServers(fc.Servers),
+		validateTenantPolicies(fc.Tenants),
+		validateProjects(fc.Projects),
+		validateProxy(fc.Proxy),
+		validateAgents(fc.Agent, fc.Agents),
+		validateNotifications(fc.Notifications),
+		validateDisabledTools(fc.DisabledTools),
+	} {
+		if err != nil {
+			path, msg := splitIssuePath(err.Error(), lines)
+			issues = append(issues, located(path, msg, false))
+		}
+	}
+	for model, price := range fc.Pricing {
+		if price.Input < 0 || price.Output < 0 {
+			issues = append(issues, located("pricing."+model, "prices must not be negative", false))
+		}
+	}
+	for name, p := range fc.Tenants {
+		for i, dir := range p.AllowedDirs {
+			for j, other := range p.AllowedDirs {
+				if i != j && isWithinDir(other, dir) && (dir != other || j < i) {
+					path := fmt.Sprintf("tenants.%s.allowedDirs[%d]", name, i)
+					issues = append(issues, located(path, fmt.Sprintf("%s is already covered by %s", dir, other), true))
+					break
+				}
+			}
+		}
+	}
+	sort.SliceStable(issues, func(i, j int) bool { return issues[i].Line < issues[j].Line })
+	return fc, issues
+}
+
+// isWithinDir reports whether dir is root or below it.
+func isWithinDir(root, dir string) bool {
+	rel, err := filepath.Rel(filepath.Clean(root), filepath.Clean(dir))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// splitIssuePath splits a validation message like "tools[2] name: missing
+// args" into the longest known path prefix and the rest.
+func splitIssuePath(msg string, lines map[string]int) (string, string) {
+	best := ""
+	for path := range lines {
+		if len(path) > len(best) && strings.HasPrefix(msg, path) && len(msg) > len(path) && strings.ContainsRune(" :", rune(msg[len(path)])) {
+			best = path
+		}
+	}
+	if best == "" {
+		return "", msg
+	}
+	rest := strings.TrimLeft(msg[len(best):], " ")
+	if i := strings.Index(rest, ": "); i >= 0 && !strings.Contains(rest[:i], " ") {
+		rest = rest[i+2:] // drop a "name:" label following the path
+	} else {
+		rest = strings.TrimPrefix(rest, ": ")
+	}
+	return best, rest
+}
+
+// checkKeys reports object keys in v that don't correspond to a field of t.
+func checkKeys(v any, t reflect.Type, path string, report func(path, msg string)) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return // type errors are reported by json.Unmarshal
+		}
+		fields := map[string]reflect.Type{}
+		var names []string
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if !f.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			fields[name] = f.Type
+			names = append(names, name)
+		}
+		for key, val := range obj {
+			p := joinPath(path, key)
+			ft, ok := fields[key]
+			if !ok {
+				report(p, "unknown key"+didYouMean(key, names))
+				continue
+			}
+			checkKeys(val, ft, p, report)
+		}
+	case reflect.Map:
+		if obj, ok := v.(map[string]any); ok {
+			for key, val := range obj {
+				checkKeys(val, t.Elem(), joinPath(path, key), report)
+			}
+		}
+	case reflect.Slice:
+		if arr, ok := v.([]any); ok {
+			for i, val := range arr {
+				checkKeys(val, t.Elem(), fmt.Sprintf("%s[%d]", path, i), report)
+			}
+		}
+	}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// jsonPathLines maps every key and array element path in data to its line.
+func jsonPathLines(data []byte) map[string]int {
+	lines := map[string]int{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	var walk func(path string) error
+	walk = func(path string) error {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if _, ok := lines[path]; !ok && path != "" {
+			lines[path] = lineAt(data, dec.InputOffset())
+		}
+		switch tok {
+		case json.Delim('{'):
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				p := joinPath(path, fmt.Sprint(key))
+				lines[p] = lineAt(data, dec.InputOffset())
+				if err := walk(p); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+		case json.Delim('['):
+			for i := 0; dec.More(); i++ {
+				if err := walk(fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+		}
+		return err
+	}
+	_ = walk("")
+	return lines
+}
+
+// lineAt returns the 1-based line of byte offset in data.
+func lineAt(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// didYouMean suggests the closest candidate to s, if any is close.
+func didYouMean(s string, candidates []string) string {
+	best, bestDist := "", 3
+	for _, c := range candidates {
+		if d := editDistance(strings.ToLower(s), strings.ToLower(c)); d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (did you mean %q?)", best)
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// runConfig implements `config lint` and `config print-effective`.
+func runConfig(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: config lint|print-effective [-config path]")
+	}
+	fs := flag.NewFlagSet("config "+args[0], flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("MCP_CONFIG"), "configuration file")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "lint":
+		issues := lintEnv(os.Environ())
+		if *configPath != "" {
+			data, err := os.ReadFile(*configPath)
+			if err != nil {
+				return err
+			}
+			_, fileIssues := lintFileConfig(*configPath, data)
+			issues = append(issues, fileIssues...)
+		}
+		for _, i := range issues {
+			fmt.Println(i)
+		}
+		if hasErrors(issues) {
+			return errors.New("configuration has errors")
+		}
+		fmt.Println("configuration OK")
+		return nil
+
+	case "print-effective":
+		cfg := configFromEnv()
+		if *configPath != "" {
+			fc, err := loadFileConfig(*configPath)
+			if err != nil {
+				return err
+			}
+			applyFileConfig(&cfg, fc)
+		}
+		return printEffectiveConfig(cfg, *configPath)
+	}
+	return fmt.Errorf("unknown config command %q (want lint or print-effective)", args[0])
+}
+
+// printEffectiveConfig prints the resolved settings with where each
+// environment setting came from.
+func printEffectiveConfig(cfg serverConfig, configPath string) error {
+	type setting struct {
+		Value  any    `json:"value"`
+		Source string `json:"source"` // env, file or default
+	}
+	env := map[string]setting{}
+	set := func(name string, value any) {
+		source := "default"
+		if os.Getenv(name) != "" {
+			source = "env"
+		}
+		env[name] = setting{value, source}
+	}
+	set("MCP_ADDR", cfg.Addr)
+	set("MCP_TARGET", cfg.Target)
+	set("MCP_TIMEOUT_SEC", int(cfg.DefaultTimeout.Seconds()))
+	set("MCP_DEFAULT_MODEL", cfg.DefaultModel)
+	set("MCP_BACKEND", cfg.Backend)
+	set("MCP_SERVE_URL", cfg.ServeURL)
+	set("MCP_OPENCODE_STORAGE", cfg.OpencodeStorage)
+	set("MCP_STRICT_EVENTS", cfg.StrictEvents)
+	set("MCP_REQUIRE_SESSION", cfg.RequireSession)
+	set("MCP_TRUST_TENANT_HEADER", cfg.TrustTenant)
+	set("MCP_NOTIFY_RATE", cfg.NotifyRate)
+	set("MCP_POLL_WAIT", cfg.PollWait.String())
+	set("MCP_STALL_TIMEOUT", cfg.StallTimeout.String())
+	set("MCP_SHUTDOWN_DRAIN", cfg.ShutdownDrain.String())
+	set("MCP_STDIN_MAX_BYTES", cfg.Stdin.MaxBytes)
+	set("MCP_STDIN_TIMEOUT", cfg.Stdin.Timeout.String())
+	set("MCP_ATTACH_ALLOW_HOSTS", os.Getenv("MCP_ATTACH_ALLOW_HOSTS"))
+	set("MCP_ATTACH_MAX_BYTES", cfg.Attach.MaxBytes)
+	set("MCP_AUDIT_LOG", os.Getenv("MCP_AUDIT_LOG"))
+	if webhooks, err := parseWebhooks(os.Getenv("MCP_EVENT_WEBHOOKS")); err == nil {
+		redacted := []string{}
+		for _, w := range webhooks {
+			redacted = append(redacted, webhookHost(w)+"/...")
+		}
+		set("MCP_EVENT_WEBHOOKS", redacted)
+	}
+	set("MCP_SESSION_TTL", cfg.SessionTTL.String())
+	set("MCP_MAX_CONCURRENT_RUNS", getenvInt("MCP_MAX_CONCURRENT_RUNS", defaultMaxConcurrentRuns))
+	set("MCP_STORE_PATH", os.Getenv("MCP_STORE_PATH"))
+	set("MCP_STORE_URL", redactURL(os.Getenv("MCP_STORE_URL")))
+	set("MCP_ARTIFACT_DIR", cfg.Artifacts.Root)
+	set("MCP_WORKSPACE_DIR", os.Getenv("MCP_WORKSPACE_DIR"))
+	set("MCP_PROMPTS_DIR", os.Getenv("MCP_PROMPTS_DIR"))
+	set("MCP_GIT_SSH_KEY", os.Getenv("MCP_GIT_SSH_KEY"))
+	set("MCP_WARMUP_INTERVAL", getenvDuration("MCP_WARMUP_INTERVAL", defaultWarmupInterval).String())
+	set("MCP_ARTIFACT_MAX_MB", cfg.Artifacts.MaxBytes>>20)
+	set("MCP_ARTIFACT_RETENTION_HOURS", int(cfg.Artifacts.Retention/time.Hour))
+	set("MCP_PUBLIC_URL", cfg.Artifacts.PublicURL)
+	set("MCP_IDLE_EXIT", getenvDuration("MCP_IDLE_EXIT", 0).String())
+	set("MCP_PID_FILE", defaultPIDFile())
+	set("MCP_ARTIFACT_TOTAL_MAX_MB", cfg.Artifacts.TotalMaxBytes>>20)
+	set("MCP_DISK_MIN_FREE_MB", cfg.Disk.MinFreeBytes>>20)
+	set("MCP_DISK_WARN_FREE_MB", cfg.Disk.WarnFreeBytes>>20)
+	set("MCP_TRANSCRIPTS_MAX_MB", cfg.Disk.TranscriptMaxBytes>>20)
+	set("MCP_ARCHIVE_RETENTION_HOURS", int(cfg.Disk.ArchiveRetention/time.Hour))
+	set("MCP_PROMPT_ANALYTICS", cfg.PromptAnalytics)
+	set("MCP_MAX_MESSAGE_CHARS", cfg.MaxMessageChars)
+	set("MCP_OVERSIZE", cfg.Oversize)
+	set("MCP_BINARY_OUTPUT", cfg.BinaryOutput)
+	set("MCP_COMPRESSION", cfg.Compression)
+	if rt, err := parseRouteTimeouts(os.Getenv("MCP_ROUTE_TIMEOUTS"), cfg.DefaultTimeout); err == nil {
+		set("MCP_ROUTE_TIMEOUTS", rt.String())
+	}
+	set("MCP_TIMEZONE", outputLocation.String())
+	set("MCP_LOCALE", getenv("MCP_LOCALE", defaultLocale))
+	set("MCP_DEDUPE_WINDOW", getenvDuration("MCP_DEDUPE_WINDOW", defaultDedupeWindow).String())
+	if os.Getenv("MCP_ADMIN_TOKEN") != "" {
+		set("MCP_ADMIN_TOKEN", "(set)")
+	}
+	set("MCP_SIGNING_KEY", os.Getenv("MCP_SIGNING_KEY"))
+	set("MCP_CHAOS", os.Getenv("MCP_CHAOS"))
+	set("MCP_SERVER_NAME", cfg.ServerInfo.Name)
+	set("MCP_SERVER_VERSION", cfg.ServerInfo.Version)
+	set("MCP_INSTRUCTIONS", cfg.Instructions)
+	if cfg.Target != getenv("MCP_TARGET", defaultTarget) {
+		env["MCP_TARGET"] = setting{cfg.Target, "file"}
+	}
+	if cfg.ServerInfo.Name != getenv("MCP_SERVER_NAME", mcp.ServerName) {
+		env["MCP_SERVER_NAME"] = setting{cfg.ServerInfo.Name, "file"}
+	}
+	if cfg.ServerInfo.Version != getenv("MCP_SERVER_VERSION", serverVersion) {
+		env["MCP_SERVER_VERSION"] = setting{cfg.ServerInfo.Version, "file"}
+	}
+	if cfg.Instructions != os.Getenv("MCP_INSTRUCTIONS") {
+		env["MCP_INSTRUCTIONS"] = setting{cfg.Instructions, "file"}
+	}
+
+	out := map[string]any{
+		"env":     env,
+		"config":  configPath,
+		"tools":   cfg.CustomTools,
+		"plugins": cfg.Plugins,
+		"servers": redactedServers(cfg.Servers),
+		"pricing": cfg.Pricing,
+		"tenants": cfg.Tenants,
+	}
+	b, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
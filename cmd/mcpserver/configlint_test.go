@@ -0,0 +1,99 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Test config file issues are reported with paths, line numbers and hints
+func TestLintFileConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    string // substring of the single issue
+		line    int
+		warning bool
+	}{
+		{
+			name: "unknown key",
+			data: "{\n  \"tools\": [\n    {\"name\": \"x\", \"args\": [\"run\"], \"descripton\": \"typo\"}\n  ]\n}",
+			want: `tools[0].descripton: unknown key (did you mean "description"?)`,
+			line: 3,
+		},
+		{
+			name: "wrong type",
+			data: "{\n  \"tenants\": {\n    \"team-a\": {\"timeoutSec\": \"10m\"}\n  }\n}",
+			want: "expected int",
+			line: 3,
+		},
+		{
+			name: "syntax error",
+			data: "{\n  \"tools\": [\n  }\n}",
+			want: "invalid character",
+			line: 3,
+		},
+		{
+			name: "validation error",
+			data: "{\n  \"tools\": [\n    {\"name\": \"ok\", \"args\": [\"run\"]},\n    {\"name\": \"x\"}\n  ]\n}",
+			want: "tools[1]: missing args",
+			line: 4,
+		},
+		{
+			name: "relative allowed dir",
+			data: "{\"tenants\": {\"team-a\": {\n  \"allowedDirs\": [\n    \"/srv\",\n    \"work\"\n  ]\n}}}",
+			want: `tenants.team-a.allowedDirs[1]: "work" is not an absolute path`,
+			line: 4,
+		},
+		{
+			name:    "overlapping roots",
+			data:    "{\"tenants\": {\"team-a\": {\n  \"allowedDirs\": [\n    \"/srv\",\n    \"/srv/repos\"\n  ]\n}}}",
+			want:    "tenants.team-a.allowedDirs[1]: /srv/repos is already covered by /srv",
+			line:    4,
+			warning: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, issues := lintFileConfig("config.json", []byte(tt.data))
+			if len(issues) != 1 {
+				t.Fatalf("issues = %v, want one", issues)
+			}
+			got := issues[0]
+			if !strings.Contains(got.String(), tt.want) || got.Line != tt.line || got.Warning != tt.warning {
+				t.Errorf("issue = %q (line %d, warning %v), want %q at line %d", got, got.Line, got.Warning, tt.want, tt.line)
+			}
+		})
+	}
+
+	_, issues := lintFileConfig("config.json", []byte(`{"tools":[{"name":"lint","args":["run"]}],"tenants":{"a":{"allowedDirs":["/srv/a","/srv/b"]}}}`))
+	if len(issues) != 0 {
+		t.Errorf("valid config: issues = %v", issues)
+	}
+}
+
+// Test environment variables are checked for bad values and typos
+func TestLintEnv(t *testing.T) {
+	issues := lintEnv([]string{
+		"MCP_TIMEOUT_SEC=10m",
+		"MCP_IDLE_EXIT=30",
+		"MCP_BACKEND=http",
+		"MCP_STRICT_EVENTS=maybe",
+		"MCP_TARGE=/usr/bin/opencode",
+		"MCP_ADDR=:9000",
+		"HOME=/root",
+	})
+	var got []string
+	for _, i := range issues {
+		got = append(got, i.String())
+	}
+	want := []string{
+		`env: error: MCP_BACKEND: "http" is not "cli" or "serve"`,
+		`env: error: MCP_IDLE_EXIT: "30" is not a duration like 30m or 1h30m`,
+		`env: error: MCP_STRICT_EVENTS: "maybe" is not a boolean (true/false)`,
+		`env: warning: MCP_TARGE: unknown variable (did you mean "MCP_TARGET"?)`,
+		`env: error: MCP_TIMEOUT_SEC: "10m" is not a non-negative integer`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("lintEnv =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
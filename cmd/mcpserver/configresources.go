@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+
+	"opencode-mcp/internal/mcp"
+)
+
+// Besides sessions, the server offers resources describing itself, so a
+// client can see its constraints ("which models can I use here?") without
+// a tool call. Both are rendered for the caller's tenant: its policy,
+// limits and default model, never other tenants' or secrets.
+
+// serverResources are listed ahead of the sessions in resources/list.
+var serverResources = []mcp.Resource{
+	{
+		URI:         mcp.EffectiveConfigURI,
+		Name:        "effective-config",
+		Title:       "Server configuration",
+		Description: "Limits, tenant policy and tools in effect for this client",
+		MimeType:    mcp.JSONMimeType,
+	},
+	{
+		URI:         mcp.ModelCatalogURI,
+		Name:        "models",
+		Title:       "Model catalog",
+		Description: "Models opencode offers here, with the default and prices",
+		MimeType:    mcp.JSONMimeType,
+	},
+}
+
+// effectiveConfig is the contents of config://effective.
+type effectiveConfig struct {
+	Server  string           `json:"server"`
+	Version string           `json:"version"`
+	Tenant  string           `json:"tenant"`
+	Backend string           `json:"backend"`
+	Agent   string           `json:"agent,omitempty"`
+	Limits  limitCapability  `json:"limits"`
+	Policy  tenantPolicy     `json:"policy"` // empty without a policy for the tenant
+	Tools   []toolCapability `json:"tools"`
+}
+
+// catalogModel is a model of catalog://models.
+type catalogModel struct {
+	modelInfo
+	PriceSource string `json:"priceSource,omitempty"` // config, opencode or builtin
+}
+
+// readServerResource renders uri for tenant, reporting false when it isn't
+// a server resource.
+func readServerResource(cfg serverConfig, tenant, uri string) (mcp.ResourceContents, bool, error) {
+	tcfg := cfg.forTenant(tenant)
+	var v any
+	switch uri {
+	case mcp.EffectiveConfigURI:
+		report := buildCapabilityReport(tcfg)
+		v = effectiveConfig{
+			Server:  report.Server,
+			Version: report.Version,
+			Tenant:  tenantOrDefault(tenant),
+			Backend: tcfg.Backend,
+			Agent:   tcfg.Agent,
+			Limits:  report.Limits,
+			Policy:  redactedPolicy(tcfg.Policy),
+			Tools:   report.Tools,
+		}
+	case mcp.ModelCatalogURI:
+		models := []catalogModel{}
+		for _, m := range fetchModelInfo(tcfg) {
+			cm := catalogModel{modelInfo: m}
+			if price, source, err := priceFor(tcfg, m.ID); err == nil {
+				cm.Cost, cm.PriceSource = &price, source
+			}
+			models = append(models, cm)
+		}
+		v = map[string]any{"default": getDefaultModel(tcfg), "models": models}
+	default:
+		return mcp.ResourceContents{}, false, nil
+	}
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return mcp.ResourceContents{}, true, err
+	}
+	return mcp.ResourceContents{URI: uri, MimeType: mcp.JSONMimeType, Text: string(b)}, true, nil
+}
+
+// redactedPolicy hides credentials in the policy's proxy URLs, and the
+// digests of its API keys.
+func redactedPolicy(p tenantPolicy) tenantPolicy {
+	p.APIKeys = nil
+	if p.Proxy == nil {
+		return p
+	}
+	proxy := *p.Proxy
+	proxy.HTTPProxy, proxy.HTTPSProxy = redactURL(proxy.HTTPProxy), redactURL(proxy.HTTPSProxy)
+	if proxy.BaseURLs != nil {
+		proxy.BaseURLs = make(map[string]string, len(p.Proxy.BaseURLs))
+		for provider, u := range p.Proxy.BaseURLs {
+			proxy.BaseURLs[provider] = redactURL(u)
+		}
+	}
+	p.Proxy = &proxy
+	return p
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Protocol versions a conforming server may answer initialize with.
+var conformanceVersions = []string{"2024-11-05", "2025-03-26", "2025-06-18"}
+
+// conformanceTransport delivers one JSON-RPC message to a server and
+// returns the responses (not notifications) it caused.
+type conformanceTransport interface {
+	send(t *testing.T, msg string) []rpcMessage
+}
+
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Method  string          `json:"method"`
+	Result  json.RawMessage `json:"result"`
+	Error   *struct {
+		Code    *int    `json:"code"`
+		Message *string `json:"message"`
+	} `json:"error"`
+	raw string
+}
+
+func parseRPCMessage(t *testing.T, data string) rpcMessage {
+	t.Helper()
+	var m rpcMessage
+	if err := json.Unmarshal([]byte(data), &m); err != nil {
+		t.Fatalf("server sent invalid JSON %q: %v", data, err)
+	}
+	m.raw = data
+	return m
+}
+
+type httpConformance struct {
+	url     string
+	session string
+}
+
+func (h *httpConformance) send(t *testing.T, msg string) []rpcMessage {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, h.url, strings.NewReader(msg))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	if h.session != "" {
+		req.Header.Set("Mcp-Session-Id", h.session)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if id := resp.Header.Get("Mcp-Session-Id"); id != "" {
+		h.session = id
+	}
+	if resp.StatusCode == http.StatusAccepted {
+		if len(body) != 0 {
+			t.Errorf("202 response has a body: %q", body)
+		}
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("HTTP %d for %s", resp.StatusCode, msg)
+	}
+	var out []rpcMessage
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		for _, line := range strings.Split(string(body), "\n") {
+			if data, ok := strings.CutPrefix(line, "data: "); ok {
+				if m := parseRPCMessage(t, data); m.Method == "" {
+					out = append(out, m)
+				}
+			}
+		}
+		return out
+	}
+	return append(out, parseRPCMessage(t, string(bytes.TrimSpace(body))))
+}
+
+// stdioConformance follows every message with a sentinel ping, so that
+// everything the server writes before the ping's response is the
+// message's reply.
+type stdioConformance struct {
+	stdin io.Writer
+	out   *bufio.Reader
+}
+
+const conformanceSentinel = `"conformance-sentinel"`
+
+func (s *stdioConformance) send(t *testing.T, msg string) []rpcMessage {
+	t.Helper()
+	if _, err := fmt.Fprintf(s.stdin, "%s\n{\"jsonrpc\":\"2.0\",\"id\":%s,\"method\":\"ping\"}\n", msg, conformanceSentinel); err != nil {
+		t.Fatal(err)
+	}
+	var out []rpcMessage
+	for {
+		line, err := s.out.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading stdio server: %v", err)
+		}
+		m := parseRPCMessage(t, strings.TrimSpace(line))
+		if string(m.ID) == conformanceSentinel {
+			return out
+		}
+		if m.Method == "" {
+			out = append(out, m)
+		}
+	}
+}
+
+// Test both transports against the MCP lifecycle, error codes and
+// capabilities
+func TestConformance(t *testing.T) {
+	t.Run("http", func(t *testing.T) {
+		st, err := openStore("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg := serverConfig{Target: "/nonexistent/opencode", DefaultTimeout: time.Second, Store: st}
+		srv := httptest.NewServer(newMCPHandler(&sessionStore{sessions: make(map[string]*session)}, cfg))
+		defer srv.Close()
+		runConformance(t, &httpConformance{url: srv.URL})
+	})
+	t.Run("stdio", func(t *testing.T) {
+		cmd := exec.Command(buildMCPStdio(t))
+		cmd.Env = append(os.Environ(), "MCP_TARGET=/nonexistent/opencode")
+		stdin, _ := cmd.StdinPipe()
+		stdout, _ := cmd.StdoutPipe()
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			stdin.Close()
+			_ = cmd.Wait()
+		}()
+		runConformance(t, &stdioConformance{stdin: stdin, out: bufio.NewReader(stdout)})
+	})
+}
+
+// Test both transports with the reference MCP inspector's CLI mode when
+// MCP_INSPECTOR names it, e.g. "npx -y @modelcontextprotocol/inspector"
+func TestConformanceInspector(t *testing.T) {
+	inspector := strings.Fields(os.Getenv("MCP_INSPECTOR"))
+	if len(inspector) == 0 {
+		t.Skip("MCP_INSPECTOR not set")
+	}
+	st, err := openStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := serverConfig{Target: "/nonexistent/opencode", DefaultTimeout: time.Second, Store: st}
+	srv := httptest.NewServer(newMCPHandler(&sessionStore{sessions: make(map[string]*session)}, cfg))
+	defer srv.Close()
+	stdio := buildMCPStdio(t)
+
+	for _, target := range [][]string{
+		{srv.URL, "--transport", "http"},
+		{"-e", "MCP_TARGET=/nonexistent/opencode", stdio},
+	} {
+		for _, method := range []string{"tools/list", "prompts/list"} {
+			if method == "prompts/list" && target[0] != srv.URL {
+				continue // not a stdio capability
+			}
+			args := append(append(inspector[1:], "--cli"), target...)
+			args = append(args, "--method", method)
+			out, err := exec.Command(inspector[0], args...).CombinedOutput()
+			if err != nil {
+				t.Errorf("%s %s: %v\n%s", strings.Join(inspector, " "), strings.Join(args[len(inspector)-1:], " "), err, out)
+			}
+		}
+	}
+}
+
+func runConformance(t *testing.T, tr conformanceTransport) {
+	var capabilities map[string]json.RawMessage
+
+	steps := []struct {
+		name  string
+		msg   string
+		check func(t *testing.T, resps []rpcMessage)
+	}{
+		{"initialize", `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26","capabilities":{},"clientInfo":{"name":"conformance","version":"1"}}}`,
+			func(t *testing.T, resps []rpcMessage) {
+				var init struct {
+					ProtocolVersion string                     `json:"protocolVersion"`
+					Capabilities    map[string]json.RawMessage `json:"capabilities"`
+					ServerInfo      struct {
+						Name    string `json:"name"`
+						Version string `json:"version"`
+					} `json:"serverInfo"`
+				}
+				wantResult(t, resps, `1`, &init)
+				if !slices.Contains(conformanceVersions, init.ProtocolVersion) {
+					t.Errorf("protocolVersion = %q, want one of %v", init.ProtocolVersion, conformanceVersions)
+				}
+				if init.Capabilities == nil || init.ServerInfo.Name == "" || init.ServerInfo.Version == "" {
+					t.Errorf("initialize result lacks capabilities or serverInfo: %s", resps[0].raw)
+				}
+				capabilities = init.Capabilities
+			}},
+		{"initialized notification", `{"jsonrpc":"2.0","method":"notifications/initialized"}`, wantNoResponse},
+		{"ping", `{"jsonrpc":"2.0","id":"p-1","method":"ping"}`,
+			func(t *testing.T, resps []rpcMessage) {
+				var result map[string]any
+				wantResult(t, resps, `"p-1"`, &result)
+			}},
+		{"large numeric id", `{"jsonrpc":"2.0","id":9007199254740993,"method":"ping"}`,
+			func(t *testing.T, resps []rpcMessage) {
+				var result map[string]any
+				wantResult(t, resps, `9007199254740993`, &result)
+			}},
+		{"tools/list", `{"jsonrpc":"2.0","id":2,"method":"tools/list"}`,
+			func(t *testing.T, resps []rpcMessage) {
+				if _, ok := capabilities["tools"]; !ok {
+					t.Fatal("tools capability not advertised")
+				}
+				var list struct {
+					Tools []struct {
+						Name        string         `json:"name"`
+						InputSchema map[string]any `json:"inputSchema"`
+					} `json:"tools"`
+				}
+				wantResult(t, resps, `2`, &list)
+				if len(list.Tools) == 0 {
+					t.Fatal("no tools")
+				}
+				seen := map[string]bool{}
+				for _, tool := range list.Tools {
+					if tool.Name == "" || seen[tool.Name] {
+						t.Errorf("tool name %q is empty or duplicated", tool.Name)
+					}
+					seen[tool.Name] = true
+					if tool.InputSchema["type"] != "object" {
+						t.Errorf("tool %s inputSchema type = %v, want object", tool.Name, tool.InputSchema["type"])
+					}
+				}
+			}},
+		{"prompts/list if advertised", `{"jsonrpc":"2.0","id":3,"method":"prompts/list"}`,
+			func(t *testing.T, resps []rpcMessage) {
+				if _, ok := capabilities["prompts"]; !ok {
+					return
+				}
+				var list struct {
+					Prompts []any `json:"prompts"`
+				}
+				wantResult(t, resps, `3`, &list)
+				if list.Prompts == nil {
+					t.Errorf("prompts/list result lacks prompts: %s", resps[0].raw)
+				}
+			}},
+		{"unknown method", `{"jsonrpc":"2.0","id":4,"method":"no/such/method"}`,
+			func(t *testing.T, resps []rpcMessage) { wantError(t, resps, `4`, -32601) }},
+		{"unknown notification", `{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":4}}`, wantNoResponse},
+		{"parse error", `{"jsonrpc":"2.0","id":5,`,
+			func(t *testing.T, resps []rpcMessage) { wantError(t, resps, `null`, -32700) }},
+		{"missing method", `{"jsonrpc":"2.0","id":6}`,
+			func(t *testing.T, resps []rpcMessage) { wantError(t, resps, `6`, -32600) }},
+		{"unknown tool", `{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"name":"no_such_tool","arguments":{}}}`,
+			func(t *testing.T, resps []rpcMessage) { wantError(t, resps, `7`, -32602) }},
+		{"malformed tools/call params", `{"jsonrpc":"2.0","id":8,"method":"tools/call","params":"oops"}`,
+			func(t *testing.T, resps []rpcMessage) { wantError(t, resps, `8`, -32602) }},
+	}
+	for _, step := range steps {
+		resps := tr.send(t, step.msg)
+		for _, m := range resps {
+			checkRPCMessage(t, m)
+		}
+		t.Run(step.name, func(t *testing.T) { step.check(t, resps) })
+	}
+}
+
+// checkRPCMessage checks the JSON-RPC 2.0 response envelope.
+func checkRPCMessage(t *testing.T, m rpcMessage) {
+	t.Helper()
+	if m.JSONRPC != "2.0" {
+		t.Errorf("jsonrpc = %q in %s", m.JSONRPC, m.raw)
+	}
+	if m.ID == nil {
+		t.Errorf("response without id: %s", m.raw)
+	}
+	if (m.Result == nil) == (m.Error == nil) {
+		t.Errorf("response must have exactly one of result and error: %s", m.raw)
+	}
+	if m.Error != nil && (m.Error.Code == nil || m.Error.Message == nil) {
+		t.Errorf("error without code or message: %s", m.raw)
+	}
+}
+
+func wantResult(t *testing.T, resps []rpcMessage, id string, v any) {
+	t.Helper()
+	if len(resps) != 1 {
+		t.Fatalf("got %d responses, want 1", len(resps))
+	}
+	m := resps[0]
+	if string(m.ID) != id || m.Error != nil {
+		t.Fatalf("want a result for id %s, got %s", id, m.raw)
+	}
+	if err := json.Unmarshal(m.Result, v); err != nil {
+		t.Fatalf("result %s: %v", m.Result, err)
+	}
+}
+
+func wantError(t *testing.T, resps []rpcMessage, id string, code int) {
+	t.Helper()
+	if len(resps) != 1 {
+		t.Fatalf("got %d responses, want 1", len(resps))
+	}
+	m := resps[0]
+	if string(m.ID) != id || m.Error == nil || m.Error.Code == nil || *m.Error.Code != code {
+		t.Errorf("want error %d for id %s, got %s", code, id, m.raw)
+	}
+}
+
+func wantNoResponse(t *testing.T, resps []rpcMessage) {
+	t.Helper()
+	for _, m := range resps {
+		t.Errorf("notification got a response: %s", m.raw)
+	}
+}
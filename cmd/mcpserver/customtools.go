@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"opencode-mcp/internal/mcp"
+)
+
+// Cwd policies for custom tools
+const (
+	cwdPolicyArgument = "argument" // use the "cwd" argument if given (default)
+	cwdPolicyRequired = "required" // the "cwd" argument must be given
+	cwdPolicyFixed    = "fixed"    // always run in the configured cwd
+	cwdPolicyNone     = "none"     // run in the server's working directory
+)
+
+// customTool is an operator-defined tool declared in the config file. Its Args
+// are an argv template for the target CLI where {{name}} placeholders are
+// replaced by the tool call arguments.
+type customTool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	InputSchema map[string]any `json:"inputSchema,omitempty"`
+	Args        []string       `json:"args"`
+	CwdPolicy   string         `json:"cwdPolicy,omitempty"`
+	Cwd         string         `json:"cwd,omitempty"`
+	// Annotations are listed as they are; without them clients assume a
+	// tool may modify and delete, like opencode_run.
+	Annotations *mcp.ToolAnnotations `json:"annotations,omitempty"`
+}
+
+var placeholderRe = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_]+)\s*\}\}`)
+
+var builtinToolNames = map[string]bool{
+	toolExec:         true,
+	toolRun:          true,
+	toolModels:       true,
+	toolModelInfo:    true,
+	toolFanout:       true,
+	toolPipeline:     true,
+	toolCompare:      true,
+	toolEstimate:     true,
+	toolPromptSave:   true,
+	toolPromptDelete: true,
+	toolPromptList:   true,
+	toolHistory:      true,
+	toolJobCancel:    true,
+	toolCompareRuns:  true,
+	toolSessionList:  true,
+	toolAgentList:    true,
+	toolProjectList:  true,
+}
+
+func validateCustomTools(tools []customTool) error {
+	seen := make(map[string]bool)
+	for i, t := range tools {
+		if t.Name == "" {
+			return fmt.Errorf("tools[%d]: missing name", i)
+		}
+		if builtinToolNames[t.Name] {
+			return fmt.Errorf("tools[%d]: %q conflicts with a built-in tool", i, t.Name)
+		}
+		if seen[t.Name] {
+			return fmt.Errorf("tools[%d]: duplicate tool name %q", i, t.Name)
+		}
+		seen[t.Name] = true
+		if len(t.Args) == 0 {
+			return fmt.Errorf("tools[%d] %s: missing args", i, t.Name)
+		}
+		switch t.CwdPolicy {
+		case "", cwdPolicyArgument, cwdPolicyRequired, cwdPolicyNone:
+		case cwdPolicyFixed:
+			if t.Cwd == "" {
+				return fmt.Errorf("tools[%d] %s: cwdPolicy %q requires cwd", i, t.Name, cwdPolicyFixed)
+			}
+		default:
+			return fmt.Errorf("tools[%d] %s: unknown cwdPolicy %q", i, t.Name, t.CwdPolicy)
+		}
+		if a := t.Annotations; a != nil && a.ReadOnlyHint != nil && *a.ReadOnlyHint && a.DestructiveHint != nil && *a.DestructiveHint {
+			return fmt.Errorf("tools[%d] %s: a readOnlyHint tool can't be destructiveHint", i, t.Name)
+		}
+	}
+	return nil
+}
+
+// definition returns the tools/list entry for the custom tool.
+func (t customTool) definition() mcpTool {
+	props := map[string]any{}
+	schema := map[string]any{"type": "object"}
+	for k, v := range t.InputSchema {
+		schema[k] = v
+	}
+	if p, ok := schema["properties"].(map[string]any); ok {
+		for k, v := range p {
+			props[k] = v
+		}
+	}
+	if t.acceptsCwd() {
+		if _, ok := props["cwd"]; !ok {
+			props["cwd"] = map[string]any{
+				"type":        "string",
+				"description": "Project directory to work in",
+			}
+		}
+	}
+	schema["properties"] = props
+	if t.CwdPolicy == cwdPolicyRequired {
+		schema["required"] = appendUnique(schemaRequired(schema), "cwd")
+	}
+	return mcpTool{
+		Name:        t.Name,
+		Description: t.Description,
+		InputSchema: schema,
+		Annotations: t.Annotations,
+	}
+}
+
+func (t customTool) acceptsCwd() bool {
+	return t.CwdPolicy == "" || t.CwdPolicy == cwdPolicyArgument || t.CwdPolicy == cwdPolicyRequired
+}
+
+// parsesEvents reports whether the template invokes the CLI in JSON event mode.
+func (t customTool) parsesEvents() bool {
+	for i := 0; i+1 < len(t.Args); i++ {
+		if t.Args[i] == "--format" && t.Args[i+1] == "json" {
+			return true
+		}
+	}
+	return false
+}
+
+// buildCommand expands the argv template and resolves the working directory
+// for a call with the given raw arguments.
+func (t customTool) buildCommand(raw json.RawMessage) ([]string, string, error) {
+	args := map[string]any{}
+	if len(raw) > 0 && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &args); err != nil {
+			return nil, "", fmt.Errorf("invalid arguments")
+		}
+	}
+	for _, name := range schemaRequired(t.InputSchema) {
+		if v, ok := args[name]; !ok || v == nil || v == "" {
+			return nil, "", fmt.Errorf("missing %s", name)
+		}
+	}
+
+	var cwd string
+	switch t.CwdPolicy {
+	case cwdPolicyFixed:
+		cwd = t.Cwd
+	case cwdPolicyNone:
+	default:
+		cwd, _ = args["cwd"].(string)
+		if cwd == "" && t.CwdPolicy == cwdPolicyRequired {
+			return nil, "", fmt.Errorf("missing cwd")
+		}
+	}
+
+	argv, err := expandArgTemplate(t.Args, args)
+	if err != nil {
+		return nil, "", err
+	}
+	return argv, cwd, nil
+}
+
+// expandArgTemplate substitutes {{name}} placeholders in tmpl. An element that
+// consists of a single placeholder expands to one element per item when the
+// argument is an array, and is omitted (together with a preceding flag such as
+// "--model") when the argument is absent. Such an element can't take a value
+// starting with "-", which the CLI would read as a flag, unless the template
+// ends option parsing with a "--" element before it.
+func expandArgTemplate(tmpl []string, args map[string]any) ([]string, error) {
+	out := make([]string, 0, len(tmpl))
+	positional := false
+	for i, elem := range tmpl {
+		if elem == "--" {
+			positional = true
+		}
+		if m := placeholderRe.FindStringSubmatch(elem); m != nil && m[0] == elem {
+			v, ok := args[m[1]]
+			if !ok || v == nil || v == "" {
+				if i > 0 && len(out) > 0 && strings.HasPrefix(tmpl[i-1], "-") && out[len(out)-1] == tmpl[i-1] {
+					out = out[:len(out)-1]
+				}
+				continue
+			}
+			items, ok := v.([]any)
+			if !ok {
+				items = []any{v}
+			}
+			for _, item := range items {
+				value := templateValue(item)
+				if strings.HasPrefix(value, "-") && !positional {
+					return nil, fmt.Errorf("invalid %s: values can't start with \"-\"", m[1])
+				}
+				out = append(out, value)
+			}
+			continue
+		}
+		out = append(out, placeholderRe.ReplaceAllStringFunc(elem, func(s string) string {
+			name := placeholderRe.FindStringSubmatch(s)[1]
+			if v, ok := args[name]; ok && v != nil {
+				return templateValue(v)
+			}
+			return ""
+		}))
+	}
+	return out, nil
+}
+
+func templateValue(v any) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(val)
+	default:
+		b, _ := json.Marshal(val)
+		return string(b)
+	}
+}
+
+func schemaRequired(schema map[string]any) []string {
+	var out []string
+	switch req := schema["required"].(type) {
+	case []string:
+		out = append(out, req...)
+	case []any:
+		for _, r := range req {
+			if s, ok := r.(string); ok {
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+func findCustomTool(cfg serverConfig, name string) (customTool, bool) {
+	for _, t := range cfg.CustomTools {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return customTool{}, false
+}
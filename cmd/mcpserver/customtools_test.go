@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"opencode-mcp/internal/mcp"
+)
+
+// Test expandArgTemplate
+func TestExpandArgTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		tmpl    []string
+		args    map[string]any
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "literal args",
+			tmpl: []string{"run", "run golangci-lint and fix findings"},
+			args: map[string]any{},
+			want: []string{"run", "run golangci-lint and fix findings"},
+		},
+		{
+			name: "whole element placeholder",
+			tmpl: []string{"run", "--model", "{{model}}", "{{message}}"},
+			args: map[string]any{"model": "m1", "message": "hi"},
+			want: []string{"run", "--model", "m1", "hi"},
+		},
+		{
+			name: "absent placeholder drops preceding flag",
+			tmpl: []string{"run", "--model", "{{model}}", "{{message}}"},
+			args: map[string]any{"message": "hi"},
+			want: []string{"run", "hi"},
+		},
+		{
+			name: "embedded placeholder",
+			tmpl: []string{"run", "Fix lint issues in {{package}} (max {{limit}})"},
+			args: map[string]any{"package": "./pkg/...", "limit": float64(10)},
+			want: []string{"run", "Fix lint issues in ./pkg/... (max 10)"},
+		},
+		{
+			name: "array expands to multiple elements",
+			tmpl: []string{"run", "{{files}}"},
+			args: map[string]any{"files": []any{"a.go", "b.go"}},
+			want: []string{"run", "a.go", "b.go"},
+		},
+		{
+			name: "bool value",
+			tmpl: []string{"--verbose={{verbose}}"},
+			args: map[string]any{"verbose": true},
+			want: []string{"--verbose=true"},
+		},
+		{
+			name:    "value looking like a flag",
+			tmpl:    []string{"run", "{{message}}"},
+			args:    map[string]any{"message": "--config=/tmp/evil.json"},
+			wantErr: true,
+		},
+		{
+			name:    "array item looking like a flag",
+			tmpl:    []string{"rm", "{{files}}"},
+			args:    map[string]any{"files": []any{"a.go", "-rf"}},
+			wantErr: true,
+		},
+		{
+			name: "value after --",
+			tmpl: []string{"run", "--", "{{message}}"},
+			args: map[string]any{"message": "-rf"},
+			want: []string{"run", "--", "-rf"},
+		},
+		{
+			name: "embedded placeholder may start with -",
+			tmpl: []string{"--limit={{limit}}"},
+			args: map[string]any{"limit": float64(-1)},
+			want: []string{"--limit=-1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expandArgTemplate(tt.tmpl, tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expandArgTemplate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expandArgTemplate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// Test validateCustomTools
+func TestValidateCustomTools(t *testing.T) {
+	tests := []struct {
+		name    string
+		tools   []customTool
+		wantErr string
+	}{
+		{
+			name:  "valid",
+			tools: []customTool{{Name: "lint_project", Args: []string{"run", "lint"}}},
+		},
+		{
+			name:    "missing name",
+			tools:   []customTool{{Args: []string{"run"}}},
+			wantErr: "missing name",
+		},
+		{
+			name:    "builtin conflict",
+			tools:   []customTool{{Name: toolRun, Args: []string{"run"}}},
+			wantErr: "conflicts with a built-in tool",
+		},
+		{
+			name: "duplicate",
+			tools: []customTool{
+				{Name: "a", Args: []string{"run"}},
+				{Name: "a", Args: []string{"run"}},
+			},
+			wantErr: "duplicate tool name",
+		},
+		{
+			name:    "missing args",
+			tools:   []customTool{{Name: "a"}},
+			wantErr: "missing args",
+		},
+		{
+			name:    "read-only and destructive",
+			tools:   []customTool{{Name: "a", Args: []string{"run"}, Annotations: &mcp.ToolAnnotations{ReadOnlyHint: mcp.ReadOnly().ReadOnlyHint, DestructiveHint: mcp.Mutating(true, false).DestructiveHint}}},
+			wantErr: "can't be destructiveHint",
+		},
+		{
+			name:    "fixed without cwd",
+			tools:   []customTool{{Name: "a", Args: []string{"run"}, CwdPolicy: cwdPolicyFixed}},
+			wantErr: "requires cwd",
+		},
+		{
+			name:    "unknown cwd policy",
+			tools:   []customTool{{Name: "a", Args: []string{"run"}, CwdPolicy: "sometimes"}},
+			wantErr: "unknown cwdPolicy",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateCustomTools(tt.tools)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// Test loading custom tools from a config file
+func TestLoadFileConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	data := `{"tools":[{"name":"lint_project","description":"Lint and fix","args":["run","--format","json","run golangci-lint and fix findings"]}]}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fc, err := loadFileConfig(path)
+	if err != nil {
+		t.Fatalf("loadFileConfig() error = %v", err)
+	}
+	if len(fc.Tools) != 1 || fc.Tools[0].Name != "lint_project" {
+		t.Fatalf("tools = %+v", fc.Tools)
+	}
+	if !fc.Tools[0].parsesEvents() {
+		t.Error("expected lint_project to parse JSON events")
+	}
+
+	if err := os.WriteFile(path, []byte(`{"tools":[{"name":"x"}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadFileConfig(path); err == nil {
+		t.Error("expected validation error for tool without args")
+	}
+}
+
+// Test custom tools in tools/list and tools/call
+func TestCustomToolsEndToEnd(t *testing.T) {
+	tmpDir := t.TempDir()
+	mockScript := filepath.Join(tmpDir, "mock-opencode")
+	if err := os.WriteFile(mockScript, []byte("#!/bin/sh\necho \"Args: $@\"\npwd\n"), 0755); err != nil {
+		t.Fatalf("failed to create mock script: %v", err)
+	}
+
+	cfg := serverConfig{
+		Target:         mockScript,
+		DefaultTimeout: 5 * time.Second,
+		CustomTools: []customTool{
+			{
+				Name:        "lint_project",
+				Description: "Run golangci-lint and fix findings",
+				Args:        []string{"run", "--model", "{{model}}", "run golangci-lint and fix findings"},
+			},
+			{
+				Name:      "fixed_dir",
+				Args:      []string{"run", "{{task}}"},
+				CwdPolicy: cwdPolicyFixed,
+				Cwd:       tmpDir,
+				InputSchema: map[string]any{
+					"properties": map[string]any{"task": map[string]any{"type": "string"}},
+					"required":   []any{"task"},
+				},
+			},
+		},
+	}
+	sessions := &sessionStore{sessions: make(map[string]*session)}
+	handler := createMCPHandler(sessions, cfg)
+
+	resp := doMCPRequest(t, handler, "tools/list", 1, map[string]any{})
+	result, _ := resp.Result.(map[string]any)
+	toolsRaw, _ := result["tools"].([]any)
+	found := false
+	for _, raw := range toolsRaw {
+		tool, _ := raw.(map[string]any)
+		if tool["name"] == "lint_project" {
+			found = true
+			schema, _ := tool["inputSchema"].(map[string]any)
+			props, _ := schema["properties"].(map[string]any)
+			if _, ok := props["cwd"]; !ok {
+				t.Error("expected cwd property to be added to lint_project schema")
+			}
+		}
+	}
+	if !found {
+		t.Fatal("lint_project not found in tools/list")
+	}
+
+	callTool := func(name string, args map[string]any) mcpResponse {
+		argsJSON, _ := json.Marshal(args)
+		body, _ := json.Marshal(map[string]any{
+			"jsonrpc": "2.0",
+			"method":  "tools/call",
+			"id":      2,
+			"params":  map[string]any{"name": name, "arguments": json.RawMessage(argsJSON)},
+		})
+		req := httptest.NewRequest(http.MethodPost, "/mcp", bytes.NewReader(body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		resp, _ := parseSSEResponse(rec.Body.Bytes())
+		return resp
+	}
+	resultText := func(resp mcpResponse) string {
+		result, _ := resp.Result.(map[string]any)
+		content, _ := result["content"].([]any)
+		if len(content) == 0 {
+			return ""
+		}
+		first, _ := content[0].(map[string]any)
+		text, _ := first["text"].(string)
+		return text
+	}
+
+	resp = callTool("lint_project", map[string]any{"model": "m1"})
+	if resp.Error != nil {
+		t.Fatalf("unexpected error: %v", resp.Error)
+	}
+	if text := resultText(resp); !strings.Contains(text, "Args: run --model m1 run golangci-lint and fix findings") {
+		t.Errorf("text = %q", text)
+	}
+
+	resp = callTool("fixed_dir", map[string]any{"task": "go", "cwd": "/"})
+	if resp.Error != nil {
+		t.Fatalf("unexpected error: %v", resp.Error)
+	}
+	if text := resultText(resp); !strings.Contains(text, tmpDir) {
+		t.Errorf("expected fixed cwd %q in output, got %q", tmpDir, text)
+	}
+
+	resp = callTool("fixed_dir", map[string]any{})
+	if resp.Error == nil || !strings.Contains(resp.Error.Message, "missing task") {
+		t.Errorf("expected missing task error, got %+v", resp.Error)
+	}
+}
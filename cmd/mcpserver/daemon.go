@@ -0,0 +1,427 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	daemonName         = "opencode-mcp"
+	daemonStartTimeout = 10 * time.Second
+	defaultStopTimeout = (defaultTimeoutSec + 10) * time.Second // outlasts the shutdown grace period
+)
+
+// defaultPIDFile is MCP_PID_FILE, or opencode-mcp.pid in the user's runtime
+// directory.
+func defaultPIDFile() string {
+	if p := os.Getenv("MCP_PID_FILE"); p != "" {
+		return p
+	}
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, daemonName+".pid")
+}
+
+// readPIDFile returns the PID recorded in path.
+func readPIDFile(path string) (int, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("invalid pid file %s", path)
+	}
+	return pid, nil
+}
+
+// acquirePIDFile records the current process in path. It fails if another
+// live process holds the file; stale files are replaced.
+func acquirePIDFile(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err == nil {
+			_, err = fmt.Fprintf(f, "%d\n", os.Getpid())
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			return err
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return err
+		}
+		pid, rerr := readPIDFile(path)
+		if rerr == nil && pid == os.Getpid() {
+			return nil // re-executed after SIGHUP
+		}
+		if rerr == nil && processAlive(pid) {
+			return fmt.Errorf("already running (pid %d, %s)", pid, path)
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return fmt.Errorf("could not acquire %s", path)
+}
+
+// releasePIDFile removes path if it still belongs to this process.
+func releasePIDFile(path string) {
+	if pid, err := readPIDFile(path); err == nil && pid == os.Getpid() {
+		_ = os.Remove(path)
+	}
+}
+
+func healthURL() string {
+	return defaultPublicURL(getenv("MCP_ADDR", defaultAddr)) + "/health"
+}
+
+func checkHealth(url string) error {
+	return probe(url, 2*time.Second)
+}
+
+// probe fails unless GET url answers 200 within timeout.
+func probe(url string, timeout time.Duration) error {
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return nil
+}
+
+// runServe implements `serve [-daemon] [-pid-file path] [-log-file path]
+// [-idle-exit duration]`.
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	daemon := fs.Bool("daemon", false, "run in the background")
+	pidFile := fs.String("pid-file", defaultPIDFile(), "pid/lock file")
+	logFile := fs.String("log-file", "", "log file in daemon mode (default: next to the pid file)")
+	idleExit := fs.Duration("idle-exit", getenvDuration("MCP_IDLE_EXIT", 0), "exit after this long without requests (0 disables)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if !*daemon {
+		if err := acquirePIDFile(*pidFile); err != nil {
+			return err
+		}
+		defer releasePIDFile(*pidFile)
+		runServer(*idleExit)
+		return nil
+	}
+
+	if pid, err := readPIDFile(*pidFile); err == nil && processAlive(pid) {
+		return fmt.Errorf("already running (pid %d)", pid)
+	}
+	if *logFile == "" {
+		*logFile = strings.TrimSuffix(*pidFile, ".pid") + ".log"
+	}
+	logOut, err := os.OpenFile(*logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	defer logOut.Close()
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe, "serve", "-pid-file", *pidFile, "-idle-exit", idleExit.String())
+	cmd.Stdout, cmd.Stderr = logOut, logOut
+	cmd.SysProcAttr = detachedProcAttr()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	url := healthURL()
+	deadline := time.Now().Add(daemonStartTimeout)
+	for time.Now().Before(deadline) {
+		select {
+		case err := <-exited:
+			return fmt.Errorf("server exited during startup (%v); see %s", err, *logFile)
+		case <-time.After(200 * time.Millisecond):
+		}
+		if checkHealth(url) == nil {
+			fmt.Printf("%s started (pid %d), logging to %s\n", daemonName, cmd.Process.Pid, *logFile)
+			return nil
+		}
+	}
+	return fmt.Errorf("server (pid %d) not healthy after %s; see %s", cmd.Process.Pid, daemonStartTimeout, *logFile)
+}
+
+// runHealthcheck implements `healthcheck`: it exits 0 when the server is
+// ready, for container probes in images without curl or wget.
+func runHealthcheck(args []string) error {
+	fs := flag.NewFlagSet("healthcheck", flag.ContinueOnError)
+	url := fs.String("url", defaultPublicURL(getenv("MCP_ADDR", defaultAddr))+"/readyz", "URL that must answer 200")
+	timeout := fs.Duration("timeout", 3*time.Second, "request timeout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	return probe(*url, *timeout)
+}
+
+// runStatus implements `status`: it fails unless the server is running.
+func runStatus(args []string) error {
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	pidFile := fs.String("pid-file", defaultPIDFile(), "pid/lock file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	pid, err := readPIDFile(*pidFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return errors.New("not running")
+	}
+	if err != nil {
+		return err
+	}
+	if !processAlive(pid) {
+		return fmt.Errorf("not running (stale pid file %s)", *pidFile)
+	}
+	health := "healthy"
+	if err := checkHealth(healthURL()); err != nil {
+		health = "unhealthy: " + err.Error()
+	}
+	fmt.Printf("%s running (pid %d), %s\n", daemonName, pid, health)
+	return nil
+}
+
+// runStop implements `stop`: it sends SIGTERM and waits for the server to exit.
+func runStop(args []string) error {
+	fs := flag.NewFlagSet("stop", flag.ContinueOnError)
+	pidFile := fs.String("pid-file", defaultPIDFile(), "pid/lock file")
+	timeout := fs.Duration("timeout", defaultStopTimeout, "how long to wait for in-flight requests")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	pid, err := readPIDFile(*pidFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return errors.New("not running")
+	}
+	if err != nil {
+		return err
+	}
+	if !processAlive(pid) {
+		_ = os.Remove(*pidFile)
+		return errors.New("not running (removed stale pid file)")
+	}
+	if err := terminateProcess(pid); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(*timeout)
+	for processAlive(pid) {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("pid %d still running after %s", pid, *timeout)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	fmt.Printf("%s stopped (pid %d)\n", daemonName, pid)
+	return nil
+}
+
+// unitData fills the service file templates.
+type unitData struct {
+	Name    string
+	Exe     string
+	Env     [][2]string
+	EnvFile string // systemd EnvironmentFile holding the secrets, if any
+	Log     string
+}
+
+var unitFuncs = template.FuncMap{
+	// systemd expands % specifiers and backslash escapes in quoted values.
+	"systemd": strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%").Replace,
+	"xml": func(s string) string {
+		var b strings.Builder
+		_ = xml.EscapeText(&b, []byte(s))
+		return b.String()
+	},
+}
+
+var systemdUnit = template.Must(template.New("systemd").Funcs(unitFuncs).Parse(`[Unit]
+Description=opencode MCP server
+After=network.target
+
+[Service]
+ExecStart={{.Exe | systemd}} serve -pid-file %t/{{.Name}}.pid
+{{- if .EnvFile}}
+EnvironmentFile={{.EnvFile | systemd}}
+{{- end}}
+{{- range .Env}}
+Environment="{{index . 0}}={{index . 1 | systemd}}"
+{{- end}}
+Restart=on-failure
+ExecReload=/bin/kill -HUP $MAINPID
+
+[Install]
+WantedBy=default.target
+`))
+
+var launchdPlist = template.Must(template.New("launchd").Funcs(unitFuncs).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+  <key>Label</key>
+  <string>com.{{.Name}}</string>
+  <key>ProgramArguments</key>
+  <array>
+    <string>{{.Exe | xml}}</string>
+    <string>serve</string>
+  </array>
+  <key>EnvironmentVariables</key>
+  <dict>
+{{- range .Env}}
+    <key>{{index . 0}}</key>
+    <string>{{index . 1 | xml}}</string>
+{{- end}}
+  </dict>
+  <key>RunAtLoad</key>
+  <true/>
+  <key>KeepAlive</key>
+  <true/>
+  <key>StandardOutPath</key>
+  <string>{{.Log | xml}}</string>
+  <key>StandardErrorPath</key>
+  <string>{{.Log | xml}}</string>
+</dict>
+</plist>
+`))
+
+// runUnit implements `unit`: it prints a systemd user unit or launchd agent
+// plist that runs this binary with the current MCP_* environment. Secrets
+// are left out of it: with -env-file they go to a systemd EnvironmentFile
+// readable only by the user.
+func runUnit(args []string) error {
+	fs := flag.NewFlagSet("unit", flag.ContinueOnError)
+	def := "systemd"
+	if runtime.GOOS == "darwin" {
+		def = "launchd"
+	}
+	format := fs.String("format", def, "systemd or launchd")
+	envFile := fs.String("env-file", "", "write the secret MCP_* settings to this file (mode 0600) and load it from the systemd unit")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *format != "systemd" && *format != "launchd" {
+		return fmt.Errorf("unknown format %q (want systemd or launchd)", *format)
+	}
+	if *envFile != "" && *format != "systemd" {
+		return errors.New("-env-file needs -format systemd")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	env, secrets := mcpEnviron(os.Environ())
+	data := unitData{
+		Name: daemonName,
+		Exe:  exe,
+		Env:  env,
+		Log:  filepath.Join(os.TempDir(), daemonName+".log"),
+	}
+	if len(secrets) > 0 {
+		if *envFile == "" {
+			names := make([]string, len(secrets))
+			for i, kv := range secrets {
+				names[i] = kv[0]
+			}
+			hint := "add them to the plist by hand"
+			if *format == "systemd" {
+				hint = "use -env-file"
+			}
+			fmt.Fprintf(os.Stderr, "left out of the unit: %s (%s)\n", strings.Join(names, ", "), hint)
+		} else {
+			if data.EnvFile, err = filepath.Abs(*envFile); err != nil {
+				return err
+			}
+			if err := writeEnvFile(data.EnvFile, secrets); err != nil {
+				return err
+			}
+		}
+	}
+	if *format == "launchd" {
+		if home, err := os.UserHomeDir(); err == nil {
+			data.Log = filepath.Join(home, "Library", "Logs", daemonName+".log")
+		}
+		return launchdPlist.Execute(os.Stdout, data)
+	}
+	return systemdUnit.Execute(os.Stdout, data)
+}
+
+// secretEnv are the settings that hold credentials, or URLs that may
+// embed them, and so stay out of unit files, which are world-readable.
+var secretEnv = map[string]bool{
+	"MCP_ADMIN_TOKEN":    true,
+	"MCP_SIGNING_KEY":    true,
+	"MCP_GIT_SSH_KEY":    true,
+	"MCP_STORE_URL":      true,
+	"MCP_EVENT_WEBHOOKS": true,
+}
+
+// mcpEnviron returns the MCP_* variables of environ, sorted, plus PATH so
+// the service finds the opencode CLI, and apart from them the secrets. The
+// unit sets its own pid file.
+func mcpEnviron(environ []string) (env, secrets [][2]string) {
+	for _, kv := range environ {
+		k, v, _ := strings.Cut(kv, "=")
+		switch {
+		case secretEnv[k]:
+			secrets = append(secrets, [2]string{k, v})
+		case (strings.HasPrefix(k, "MCP_") && k != "MCP_PID_FILE") || k == "PATH":
+			env = append(env, [2]string{k, v})
+		}
+	}
+	sort.Slice(env, func(i, j int) bool { return env[i][0] < env[j][0] })
+	sort.Slice(secrets, func(i, j int) bool { return secrets[i][0] < secrets[j][0] })
+	return env, secrets
+}
+
+// envFileQuote escapes a value for a double-quoted EnvironmentFile line.
+var envFileQuote = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// writeEnvFile writes env to path in systemd's EnvironmentFile format,
+// readable only by the user.
+func writeEnvFile(path string, env [][2]string) error {
+	var b strings.Builder
+	for _, kv := range env {
+		fmt.Fprintf(&b, "%s=\"%s\"\n", kv[0], envFileQuote.Replace(kv[1]))
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	// An existing file keeps its mode on open.
+	if err := f.Chmod(0o600); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.WriteString(b.String()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Test the pid file acts as a lock and recovers from stale files
+func TestAcquirePIDFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run", "mcp.pid")
+	if err := acquirePIDFile(path); err != nil {
+		t.Fatalf("fresh: %v", err)
+	}
+	if pid, err := readPIDFile(path); err != nil || pid != os.Getpid() {
+		t.Errorf("pid file = %d, %v", pid, err)
+	}
+	if err := acquirePIDFile(path); err != nil {
+		t.Errorf("own pid (re-exec): %v", err)
+	}
+
+	// A live process holds the lock.
+	if err := os.WriteFile(path, []byte(strconv.Itoa(os.Getppid())), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := acquirePIDFile(path); err == nil || !strings.Contains(err.Error(), "already running") {
+		t.Errorf("live pid: %v", err)
+	}
+	releasePIDFile(path)
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("released another process's pid file: %v", err)
+	}
+
+	// A stale file is replaced.
+	if err := os.WriteFile(path, []byte("999999999\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := acquirePIDFile(path); err != nil {
+		t.Errorf("stale pid: %v", err)
+	}
+	releasePIDFile(path)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("pid file not released: %v", err)
+	}
+	if err := runStatus([]string{"-pid-file", path}); err == nil || err.Error() != "not running" {
+		t.Errorf("status = %v", err)
+	}
+}
+
+// Test service files carry the MCP environment, escaped
+func TestServiceUnits(t *testing.T) {
+	env, secrets := mcpEnviron([]string{"HOME=/root", "MCP_ADDR=:9876", "PATH=/usr/bin", `MCP_TARGET=/opt/o "c"/100%`, "MCP_ADMIN_TOKEN=s3cret", `MCP_STORE_URL=redis://:p"w@db:6379/0`})
+	if len(env) != 3 || env[0][0] != "MCP_ADDR" || env[2][0] != "PATH" {
+		t.Fatalf("mcpEnviron = %v", env)
+	}
+	if len(secrets) != 2 || secrets[0][0] != "MCP_ADMIN_TOKEN" || secrets[1][0] != "MCP_STORE_URL" {
+		t.Fatalf("mcpEnviron secrets = %v", secrets)
+	}
+	envFile := filepath.Join(t.TempDir(), "opencode-mcp.env")
+	if err := os.WriteFile(envFile, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeEnvFile(envFile, secrets); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(envFile); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Errorf("env file mode = %v, %v; want 0600", fi.Mode(), err)
+	}
+	if b, _ := os.ReadFile(envFile); string(b) != "MCP_ADMIN_TOKEN=\"s3cret\"\nMCP_STORE_URL=\"redis://:p\\\"w@db:6379/0\"\n" {
+		t.Errorf("env file = %q", b)
+	}
+	data := unitData{Name: daemonName, Exe: "/usr/local/bin/mcpserver", Env: env, EnvFile: envFile, Log: "/tmp/x.log"}
+
+	var unit bytes.Buffer
+	if err := systemdUnit.Execute(&unit, data); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"ExecStart=/usr/local/bin/mcpserver serve -pid-file %t/opencode-mcp.pid\n",
+		`Environment="MCP_TARGET=/opt/o \"c\"/100%%"`,
+		"ExecReload=/bin/kill -HUP $MAINPID",
+		"EnvironmentFile=" + envFile + "\n",
+	} {
+		if !strings.Contains(unit.String(), want) {
+			t.Errorf("systemd unit missing %q:\n%s", want, unit.String())
+		}
+	}
+
+	if strings.Contains(unit.String(), "s3cret") {
+		t.Errorf("systemd unit holds a secret:\n%s", unit.String())
+	}
+
+	var plist bytes.Buffer
+	if err := launchdPlist.Execute(&plist, data); err != nil {
+		t.Fatal(err)
+	}
+	if want := "<string>/opt/o &#34;c&#34;/100%</string>"; !strings.Contains(plist.String(), want) {
+		t.Errorf("plist missing %q:\n%s", want, plist.String())
+	}
+}
+
+// Test the healthcheck subcommand's exit status follows the probed URL
+func TestHealthcheck(t *testing.T) {
+	ready := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if !ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	if err := runHealthcheck([]string{"-url", srv.URL + "/readyz"}); err != nil {
+		t.Errorf("ready: %v", err)
+	}
+	ready = false
+	if err := runHealthcheck([]string{"-url", srv.URL + "/readyz"}); err == nil {
+		t.Error("not ready: healthcheck succeeded")
+	}
+	if err := runHealthcheck([]string{"-url", "http://127.0.0.1:1/readyz", "-timeout", "1s"}); err == nil {
+		t.Error("unreachable: healthcheck succeeded")
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultDedupeWindow = 5 * time.Second
+
+// dedupeCache tracks running opencode_run calls by their arguments, so an
+// identical request arriving shortly after attaches to the first run
+// instead of starting another opencode process.
+type dedupeCache struct {
+	window time.Duration
+	mu     sync.Mutex
+	calls  map[string]*dedupedCall
+}
+
+// dedupedCall is a shared run and the number of callers still waiting on it;
+// the run is cancelled once all of them have gone.
+type dedupedCall struct {
+	*idempotentCall
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// newDedupeCache returns nil, which disables deduplication, for a
+// non-positive window.
+func newDedupeCache(window time.Duration) *dedupeCache {
+	if window <= 0 {
+		return nil
+	}
+	return &dedupeCache{window: window, calls: make(map[string]*dedupedCall)}
+}
+
+// claim returns the running call registered under key if it started within
+// the window, or registers a new one and reports that the caller owns it.
+func (c *dedupeCache) claim(key string, now time.Time) (*dedupedCall, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if existing, ok := c.calls[key]; ok && now.Sub(existing.created) < c.window {
+		existing.waiters++
+		return existing, false
+	}
+	call := &dedupedCall{idempotentCall: newIdempotentCall("", now), waiters: 1}
+	c.calls[key] = call
+	return call, true
+}
+
+// leave drops a caller that went away and cancels the run when it was the
+// last one.
+func (c *dedupeCache) leave(call *dedupedCall) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	call.waiters--
+	if call.waiters == 0 && call.cancel != nil {
+		call.cancel()
+	}
+}
+
+// finish unregisters key once its run is done.
+func (c *dedupeCache) finish(key string, call *dedupedCall) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.calls[key] == call {
+		delete(c.calls, key)
+	}
+}
+
+// dedupeMiddleware attaches an opencode_run call to a running call of the
+// same tenant and session, from the same envelope cwd, with byte-identical
+// arguments (after normalizing JSON) that started within the window. The
+// session is part of the key because its preferences and directory
+// mappings change the command the later middlewares build. Callers opt out
+// with "dedupe": false; calls with an idempotency key are left to
+// idempotencyMiddleware.
+func dedupeMiddleware(cache *dedupeCache) toolMiddleware {
+	return func(next toolHandler) toolHandler {
+		return func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+			if cache == nil || call.Name != toolRun || call.IdempotencyKey != "" {
+				return next(ctx, call)
+			}
+			var args struct {
+				Dedupe *bool `json:"dedupe"`
+			}
+			if err := json.Unmarshal(call.Arguments, &args); err == nil && args.Dedupe != nil && !*args.Dedupe {
+				return next(ctx, call)
+			}
+			key := strings.Join([]string{tenantOrDefault(call.Tenant), sessionID(call.Session), call.Cwd, callFingerprint(call.Name, call.Arguments)}, "\x00")
+			entry, owner := cache.claim(key, time.Now())
+			if !owner {
+				log.Printf("[dedupe] tool=%s: attaching to an identical run started %s ago", call.Name, time.Since(entry.created).Round(time.Millisecond))
+				defer entry.attach(call, 0)()
+				select {
+				case <-entry.done:
+					return entry.result, entry.mErr
+				case <-ctx.Done():
+					cache.leave(entry)
+					return nil, errCancelled.err("cancelled while waiting for an identical run")
+				}
+			}
+
+			runCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+			defer cancel()
+			cache.mu.Lock()
+			entry.cancel = cancel
+			cache.mu.Unlock()
+			go func() {
+				select {
+				case <-ctx.Done():
+					cache.leave(entry)
+				case <-entry.done:
+				}
+			}()
+
+			defer entry.attach(call, 0)()
+			call.notify = entry.notify
+			entry.mErr = errInternal.err("internal error")
+			defer close(entry.done)
+			defer cache.finish(key, entry)
+			result, mErr := next(runCtx, call)
+			entry.result, entry.mErr = result, mErr
+			return result, mErr
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Test identical concurrent runs share one execution, unless opted out
+func TestDedupeMiddleware(t *testing.T) {
+	var runs atomic.Int32
+	started, finish := make(chan struct{}), make(chan struct{})
+	cache := newDedupeCache(time.Minute)
+	h := chainTools(func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+		if runs.Add(1) == 1 {
+			close(started)
+			<-finish
+			if ctx.Err() != nil {
+				t.Error("shared run was cancelled while a caller still waited")
+			}
+		}
+		return &toolCallResult{Content: []toolContent{{Type: "text", Text: "done"}}}, nil
+	}, dedupeMiddleware(cache))
+
+	ctx, disconnect := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		h(ctx, &toolCall{ID: json.RawMessage("1"), Name: toolRun, Arguments: []byte(`{"message":"hi","cwd":"/tmp"}`)})
+	}()
+	<-started
+
+	done := make(chan *toolCallResult)
+	go func() {
+		result, _ := h(context.Background(), &toolCall{ID: json.RawMessage("2"), Name: toolRun, Arguments: []byte(`{"cwd":"/tmp","message":"hi"}`)})
+		done <- result
+	}()
+	for waiters := 1; waiters < 2; time.Sleep(time.Millisecond) {
+		cache.mu.Lock()
+		for _, c := range cache.calls {
+			waiters = c.waiters
+		}
+		cache.mu.Unlock()
+	}
+	disconnect() // the first caller leaves; the second keeps the run alive
+
+	if _, mErr := h(context.Background(), &toolCall{Name: toolRun, Arguments: []byte(`{"cwd":"/tmp","message":"hi","dedupe":false}`)}); mErr != nil || runs.Load() != 2 {
+		t.Errorf("opted-out call: runs = %d, err = %v", runs.Load(), mErr)
+	}
+	close(finish)
+	if result := <-done; result == nil || result.Content[0].Text != "done" {
+		t.Errorf("attached result = %+v", result)
+	}
+	wg.Wait()
+	if n := runs.Load(); n != 2 {
+		t.Errorf("runs = %d, want 2", n)
+	}
+
+	// Finished runs aren't shared.
+	h(context.Background(), &toolCall{Name: toolRun, Arguments: []byte(`{"cwd":"/tmp","message":"hi"}`)})
+	if n := runs.Load(); n != 3 {
+		t.Errorf("runs after the first finished = %d, want 3", n)
+	}
+}
+
+// Test calls that differ only in envelope cwd or session run separately
+func TestDedupeKey(t *testing.T) {
+	started, finish := make(chan string, 3), make(chan struct{})
+	h := chainTools(func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+		started <- call.Cwd + " " + sessionID(call.Session)
+		<-finish
+		return &toolCallResult{}, nil
+	}, dedupeMiddleware(newDedupeCache(time.Minute)))
+
+	args := []byte(`{"message":"hi"}`)
+	calls := []*toolCall{
+		{Name: toolRun, Arguments: args, Cwd: "/a"},
+		{Name: toolRun, Arguments: args, Cwd: "/b"},
+		{Name: toolRun, Arguments: args, Cwd: "/a", Session: &session{id: "s2"}},
+	}
+	var wg sync.WaitGroup
+	for _, call := range calls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h(context.Background(), call)
+		}()
+	}
+	seen := map[string]bool{}
+	for range calls {
+		select {
+		case run := <-started:
+			seen[run] = true
+		case <-time.After(2 * time.Second):
+			t.Fatalf("runs started = %v, want one per call", seen)
+		}
+	}
+	close(finish)
+	wg.Wait()
+	if !seen["/a "] || !seen["/b "] || !seen["/a s2"] {
+		t.Errorf("runs = %v", seen)
+	}
+}
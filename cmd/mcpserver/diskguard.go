@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	defaultDiskMinFreeMB    = 100
+	defaultDiskWarnFreeMB   = 1024
+	defaultTranscriptsMaxMB = 100
+)
+
+// diskConfig holds the free-space thresholds checked before each run, the
+// cap on stored transcripts and how long archived runs are kept. Zero
+// disables a check.
+type diskConfig struct {
+	MinFreeBytes       int64 // refuse runs below this
+	WarnFreeBytes      int64 // warn below this
+	TranscriptMaxBytes int64 // total size of stored transcripts
+	ArchiveRetention   time.Duration
+}
+
+// diskGuardMiddleware refuses opencode_run when the filesystem of the cwd,
+// the artifact directory or the store is nearly full, since a run that
+// fails halfway through writing can leave the workspace broken. Below the
+// warning threshold the run goes ahead and the client is told.
+func diskGuardMiddleware(cfg serverConfig) toolMiddleware {
+	return func(next toolHandler) toolHandler {
+		return func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+			if call.Name != toolRun || (cfg.Disk.MinFreeBytes <= 0 && cfg.Disk.WarnFreeBytes <= 0) {
+				return next(ctx, call)
+			}
+			for _, dir := range diskGuardDirs(cfg, call) {
+				free, err := freeDiskBytes(dir)
+				if err != nil {
+					continue // e.g. the cwd doesn't exist; reported later
+				}
+				switch {
+				case free < cfg.Disk.MinFreeBytes:
+					log.Printf("[disk] refusing run: %s has %d MB free", dir, free>>20)
+					return nil, errDiskFull.err(fmt.Sprintf("only %d MB free on the filesystem of %s", free>>20, dir))
+				case free < cfg.Disk.WarnFreeBytes:
+					log.Printf("[disk] low space: %s has %d MB free", dir, free>>20)
+					call.Notify(map[string]any{
+						"jsonrpc": "2.0",
+						"method":  "notifications/message",
+						"params": map[string]any{
+							"level": "warning",
+							"data":  fmt.Sprintf("low disk space: %d MB free on the filesystem of %s", free>>20, dir),
+						},
+					})
+				}
+			}
+			return next(ctx, call)
+		}
+	}
+}
+
+// diskGuardDirs returns the directories a run writes to.
+func diskGuardDirs(cfg serverConfig, call *toolCall) []string {
+	var dirs []string
+	var args struct {
+		Cwd string `json:"cwd"`
+	}
+	_ = json.Unmarshal(call.Arguments, &args)
+	switch {
+	case args.Cwd != "":
+		dirs = append(dirs, args.Cwd)
+	case call.Cwd != "":
+		dirs = append(dirs, call.Cwd)
+	default:
+		dirs = append(dirs, ".")
+	}
+	if cfg.Artifacts.Root != "" {
+		dirs = append(dirs, cfg.Artifacts.Root)
+	}
+	if cfg.Store != nil && cfg.Store.path != "" {
+		dirs = append(dirs, filepath.Dir(cfg.Store.path))
+	}
+	return dirs
+}
+
+// capTranscripts drops the transcripts of the oldest runs while the stored
+// transcripts exceed maxBytes.
+func capTranscripts(st *store, maxBytes int64) {
+	if st == nil || maxBytes <= 0 {
+		return
+	}
+	sizes := st.sizes(transcriptsCollection)
+	var total int64
+	for _, n := range sizes {
+		total += n
+	}
+	if total <= maxBytes {
+		return
+	}
+	type transcriptSize struct {
+		key     string
+		size    int64
+		started time.Time // zero when the run record is gone
+	}
+	var all []transcriptSize
+	for key, n := range sizes {
+		var rec runRecord
+		_, _ = st.get(runsCollection, key, &rec)
+		all = append(all, transcriptSize{key, n, rec.StartedAt})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].started.Before(all[j].started) })
+	dropped := 0
+	for _, t := range all {
+		if total <= maxBytes {
+			break
+		}
+		if _, err := st.delete(transcriptsCollection, t.key); err != nil {
+			log.Printf("[janitor] delete transcript %s: %v", t.key, err)
+			return
+		}
+		total -= t.size
+		dropped++
+	}
+	log.Printf("[janitor] dropped %d transcripts over the %d MB cap", dropped, maxBytes>>20)
+}
+
+// startJanitor periodically prunes artifacts past their retention or the
+// total size cap, archived runs past their retention, and transcripts past
+// their size cap. Artifacts are on this instance's disk; runs and
+// transcripts may be in a store shared with other instances, so only the
+// holder of the janitor lease prunes them.
+func startJanitor(cfg serverConfig) {
+	if cfg.Artifacts.Root == "" && (cfg.Store == nil || (cfg.Disk.TranscriptMaxBytes <= 0 && cfg.Disk.ArchiveRetention <= 0)) {
+		return
+	}
+	go func() {
+		for {
+			if cfg.Artifacts.Root != "" {
+				pruneArtifacts(cfg.Artifacts, time.Now())
+			}
+			if cfg.Store != nil && cfg.Store.acquire(janitorLease, janitorInterval+time.Minute) {
+				purgeArchived(cfg.Store, cfg.Disk.ArchiveRetention, time.Now())
+				capTranscripts(cfg.Store, cfg.Disk.TranscriptMaxBytes)
+			}
+			time.Sleep(janitorInterval)
+		}
+	}()
+}
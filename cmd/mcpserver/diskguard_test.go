@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test runs are refused below the minimum free space and warned about below
+// the warning threshold
+func TestDiskGuardMiddleware(t *testing.T) {
+	var ran bool
+	final := func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+		ran = true
+		return &toolCallResult{}, nil
+	}
+	dir := t.TempDir()
+	args := json.RawMessage(`{"message":"hi","cwd":"` + dir + `"}`)
+
+	h := chainTools(final, diskGuardMiddleware(serverConfig{Disk: diskConfig{MinFreeBytes: 1 << 62}}))
+	if _, mErr := h(context.Background(), &toolCall{Name: toolRun, Arguments: args}); mErr == nil || mErr.Data.Code != errDiskFull.Code || ran {
+		t.Errorf("nearly full disk: err = %+v, ran = %v", mErr, ran)
+	}
+
+	var notes []any
+	h = chainTools(final, diskGuardMiddleware(serverConfig{Disk: diskConfig{WarnFreeBytes: 1 << 62}}))
+	call := &toolCall{Name: toolRun, Arguments: args, notify: func(msg any) { notes = append(notes, msg) }}
+	if _, mErr := h(context.Background(), call); mErr != nil || !ran || len(notes) != 1 {
+		t.Errorf("low disk: err = %v, ran = %v, notifications = %v", mErr, ran, notes)
+	}
+}
+
+// Test the janitor's size caps drop the oldest artifacts and transcripts
+func TestJanitorSizeCaps(t *testing.T) {
+	root := t.TempDir()
+	now := time.Now()
+	for i, run := range []string{"old", "mid", "new"} {
+		dir := filepath.Join(root, "default", run)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "out.bin"), make([]byte, 600<<10), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		mod := now.Add(time.Duration(i-3) * time.Hour)
+		if err := os.Chtimes(dir, mod, mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pruneArtifacts(artifactConfig{Root: root, Retention: 24 * time.Hour, TotalMaxBytes: 1 << 20}, now)
+	entries, _ := os.ReadDir(filepath.Join(root, "default"))
+	if len(entries) != 1 || entries[0].Name() != "new" {
+		t.Errorf("kept artifacts = %v, want only the newest run", entries)
+	}
+
+	st, _ := openStore("")
+	h := runHistory{st}
+	big := strings.Repeat("x", 1000)
+	for i, id := range []string{"r1", "r2", "r3"} {
+		if err := h.record(runRecord{ID: id, StartedAt: now.Add(time.Duration(i) * time.Minute)}); err != nil {
+			t.Fatal(err)
+		}
+		if err := h.saveTranscript("", id, runTranscript{Prompt: big}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	capTranscripts(st, 2500)
+	for id, want := range map[string]bool{"r1": false, "r2": true, "r3": true} {
+		if _, ok, _ := h.transcript("", id); ok != want {
+			t.Errorf("transcript %s kept = %v, want %v", id, ok, want)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"opencode-mcp/internal/mcp"
+)
+
+// The HTTP server answers JSON-RPC methods through the dispatcher it shares
+// with the stdio server (internal/mcp). newMCPHandler keeps what is
+// particular to HTTP: sessions and their headers, statuses, and the
+// streamed and polled tools/call, which write as the call goes. Handlers
+// find the mcpRequest, with its session and tenant, in their context.
+
+type requestKey struct{}
+
+// withRequest returns ctx carrying req for the dispatcher's handlers.
+func withRequest(ctx context.Context, req *mcpRequest) context.Context {
+	return context.WithValue(ctx, requestKey{}, req)
+}
+
+// requestFrom returns the request withRequest put in ctx.
+func requestFrom(ctx context.Context) *mcpRequest {
+	req, _ := ctx.Value(requestKey{}).(*mcpRequest)
+	if req == nil {
+		return &mcpRequest{}
+	}
+	return req
+}
+
+// methodHandler answers one JSON-RPC method of the HTTP server.
+type methodHandler func(ctx context.Context, cfg serverConfig, req *mcpRequest) (any, *mcpError)
+
+// newDispatcher returns the dispatcher of the /mcp endpoint. ping and
+// notifications/cancelled are the shared ones; calls in flight are scoped
+// by session, since request IDs are only unique within one.
+func newDispatcher(cfg serverConfig, sessions *sessionStore, tools toolHandler) *mcp.Dispatcher {
+	d := mcp.NewDispatcher()
+	d.Scope(func(ctx context.Context) string {
+		return sessionID(requestFrom(ctx).Session) + " "
+	})
+	handle := func(method string, h methodHandler) {
+		d.Handle(method, func(ctx context.Context, _ *mcp.Request) (any, *mcp.Error) {
+			result, mErr := h(ctx, cfg, requestFrom(ctx))
+			if mErr != nil {
+				return nil, mErr.rpc()
+			}
+			return result, nil
+		})
+	}
+	handle("initialize", handleInitialize(sessions))
+	handle("logging/setLevel", handleSetLevel)
+	handle("tools/list", handleToolsList)
+	handle("tools/call", func(ctx context.Context, _ serverConfig, req *mcpRequest) (any, *mcpError) {
+		return handleToolsCall(ctx, tools, req)
+	})
+	handle("prompts/list", handlePromptsList)
+	handle("prompts/get", handlePromptsGet)
+	handle("resources/list", handleResourcesList)
+	handle("resources/read", handleResourcesRead)
+	handle(mcp.MethodResourcesSubscribe, handleResourcesSubscribe)
+	handle(mcp.MethodResourcesUnsubscribe, handleResourcesSubscribe)
+	handle(mcp.MethodComplete, handleComplete)
+	return d
+}
+
+// writeResponse writes the dispatcher's response. It has none for
+// notifications, which get 202 Accepted, and for requests the client
+// cancelled, which get a cancelled error since the POST still needs an
+// answer.
+func writeResponse(w http.ResponseWriter, req *mcpRequest, resp *mcp.Response) {
+	if resp == nil && req.ID == nil {
+		log.Printf("[MCP] %s ack", req.Method)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	if resp == nil {
+		log.Printf("[MCP] %s id=%s cancelled", req.Method, req.ID)
+		writeErrorStatus(w, http.StatusOK, req.ID, errCancelled.err("cancelled by the client"))
+		return
+	}
+	if resp.Error != nil {
+		mErr := fromRPC(resp.Error)
+		writeErrorStatus(w, errorStatus(mErr), resp.ID, mErr)
+		return
+	}
+	writeMCPResult(w, resp.ID, resp.Result)
+}
+
+// errorStatus is the HTTP status of an error a handler returned: 400 for a
+// missing session, which the transport should have sent, else 200 like any
+// JSON-RPC error.
+func errorStatus(mErr *mcpError) int {
+	if mErr.Data != nil && mErr.Data.Code == errSessionRequired.Code {
+		return http.StatusBadRequest
+	}
+	return http.StatusOK
+}
+
+// rpcError returns a JSON-RPC error without a catalogue entry; it gets the
+// one of its code when written.
+func rpcError(code int, message string) *mcpError {
+	return &mcpError{Code: code, Message: message}
+}
+
+// rpc converts e for the dispatcher, carrying its catalogue data along.
+func (e *mcpError) rpc() *mcp.Error {
+	out := mcp.NewError(e.Code, e.Message)
+	if e.Data != nil {
+		out.Data = e.Data
+	}
+	return out
+}
+
+// fromRPC converts an error of the dispatcher back.
+func fromRPC(e *mcp.Error) *mcpError {
+	data, _ := e.Data.(*errorData)
+	return &mcpError{Code: e.Code, Message: e.Message, Data: data}
+}
+
+// handleInitialize answers initialize, creating the session whose ID the
+// transport returns in Mcp-Session-Id.
+func handleInitialize(sessions *sessionStore) methodHandler {
+	return func(_ context.Context, cfg serverConfig, req *mcpRequest) (any, *mcpError) {
+		var params struct {
+			ClientInfo struct {
+				Name string `json:"name"`
+			} `json:"clientInfo"`
+		}
+		_ = json.Unmarshal(req.Params, &params)
+		caps := parseClientCapabilities(req.Params)
+		req.Session = sessions.create(params.ClientInfo.Name, caps)
+		log.Printf("[MCP] initialize -> session=%s client=%q protocol=%s capabilities=%v", req.Session.id, req.Session.client, caps.ProtocolVersion, caps.names())
+
+		// The configuration resources need no store, unlike the session ones
+		capabilities := map[string]any{"tools": map[string]any{"listChanged": true}, "logging": map[string]any{}, "completions": map[string]any{}, "resources": map[string]any{}}
+		if cfg.Store != nil || len(cfg.PromptTemplates) > 0 {
+			capabilities["prompts"] = map[string]any{}
+		}
+		if cfg.Transcripts != nil {
+			capabilities["resources"] = map[string]any{"subscribe": true}
+		}
+		return mcp.InitializeResult(caps.ProtocolVersion, capabilities, cfg.serverInfo(), cfg.Instructions), nil
+	}
+}
+
+// handleToolsCall runs a tools/call answered with a single JSON response.
+func handleToolsCall(ctx context.Context, tools toolHandler, req *mcpRequest) (any, *mcpError) {
+	call, mErr := newToolCall(*req)
+	if mErr != nil {
+		return nil, mErr
+	}
+	result, mErr := tools(ctx, call)
+	if mErr != nil {
+		return nil, mErr
+	}
+	return result.forClient(req.Session.capabilities()), nil
+}
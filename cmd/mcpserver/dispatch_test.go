@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"opencode-mcp/internal/mcp"
+)
+
+// Test errors keep their catalogue code through the dispatcher, and a
+// method needing a session answers 400 without one
+func TestDispatcherErrors(t *testing.T) {
+	d := newDispatcher(serverConfig{}, &sessionStore{sessions: map[string]*session{}}, nil)
+	dispatch := func(line string) *httptest.ResponseRecorder {
+		t.Helper()
+		var req mcpRequest
+		if err := json.Unmarshal([]byte(line), &req); err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		writeResponse(rec, &req, d.Dispatch(withRequest(context.Background(), &req), &req.Request))
+		return rec
+	}
+	for _, tt := range []struct {
+		line   string
+		status int
+		code   string
+	}{
+		{`{"jsonrpc":"2.0","id":1,"method":"logging/setLevel","params":{"level":"loud"}}`, http.StatusOK, errInvalidArguments.Code},
+		{`{"jsonrpc":"2.0","id":2,"method":"logging/setLevel","params":{"level":"info"}}`, http.StatusBadRequest, errSessionRequired.Code},
+		{`{"jsonrpc":"2.0","id":3,"method":"nope"}`, http.StatusOK, errUnknownTool.Code},
+	} {
+		rec := dispatch(tt.line)
+		var resp struct {
+			Error *mcpError `json:"error"`
+		}
+		_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+		if rec.Code != tt.status || resp.Error == nil || resp.Error.Data == nil || resp.Error.Data.Code != tt.code {
+			t.Errorf("%s: %d %s, want %d %s", tt.line, rec.Code, rec.Body.String(), tt.status, tt.code)
+		}
+	}
+	if rec := dispatch(`{"jsonrpc":"2.0","method":"notifications/initialized"}`); rec.Code != http.StatusAccepted {
+		t.Errorf("notification: %d", rec.Code)
+	}
+	if rec := dispatch(`{"jsonrpc":"2.0","id":4,"method":"ping"}`); !strings.Contains(rec.Body.String(), `"result":{}`) {
+		t.Errorf("ping: %s", rec.Body.String())
+	}
+	if e := fromRPC(errQuotaExceeded.err("used up").rpc()); e.Data == nil || e.Data.Code != errQuotaExceeded.Code || e.Code != errQuotaExceeded.RPCCode {
+		t.Errorf("round trip = %+v", e)
+	}
+	if e := rpcError(-32603, "x").rpc(); e.Data != nil {
+		t.Errorf("error without data got %#v", e.Data)
+	}
+}
+
+// Test a cancelled request is answered with an error, not 202 Accepted
+func TestDispatcherCancelled(t *testing.T) {
+	started := make(chan struct{})
+	d := newDispatcher(serverConfig{}, &sessionStore{sessions: map[string]*session{}}, func(ctx context.Context, _ *toolCall) (*toolCallResult, *mcpError) {
+		close(started)
+		<-ctx.Done()
+		return nil, errCancelled.err("cancelled")
+	})
+	var req mcpRequest
+	_ = json.Unmarshal([]byte(`{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"name":"opencode_run","arguments":{}}}`), &req)
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		writeResponse(rec, &req, d.Dispatch(withRequest(context.Background(), &req), &req.Request))
+	}()
+	<-started
+	cancel := mcpRequest{Request: mcp.Request{JSONRPC: "2.0", Method: "notifications/cancelled", Params: json.RawMessage(`{"requestId":7}`)}}
+	if resp := d.Dispatch(withRequest(context.Background(), &cancel), &cancel.Request); resp != nil {
+		t.Fatalf("notifications/cancelled answered: %+v", resp)
+	}
+	<-done
+
+	var resp struct {
+		ID    json.RawMessage `json:"id"`
+		Error *mcpError       `json:"error"`
+	}
+	_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+	if rec.Code != http.StatusOK || string(resp.ID) != "7" || resp.Error == nil || resp.Error.Data == nil || resp.Error.Data.Code != errCancelled.Code {
+		t.Errorf("cancelled call: %d %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,577 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"opencode-mcp/internal/mcp"
+)
+
+const (
+	defaultServerTimeout = 60 * time.Second
+	maxServerToolPages   = 100 // tools/list pages read from one downstream server
+)
+
+// downstreamServer declares another MCP server whose tools this server
+// re-exports, so clients that can connect to only one server still get
+// them. The server is either a command speaking MCP over stdio, started
+// once and kept running, or a Streamable HTTP endpoint. Its tools are listed
+// as "<name>_<tool>"; allowTools and denyTools filter them by their
+// downstream names with path.Match patterns.
+type downstreamServer struct {
+	Name       string            `json:"name"`
+	Command    string            `json:"command,omitempty"`
+	Args       []string          `json:"args,omitempty"`
+	Env        map[string]string `json:"env,omitempty"`
+	URL        string            `json:"url,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"` // e.g. Authorization for url
+	AllowTools []string          `json:"allowTools,omitempty"`
+	DenyTools  []string          `json:"denyTools,omitempty"`
+	TimeoutSec int               `json:"timeoutSec,omitempty"`
+}
+
+// downstreamTool is a tool discovered from a downstream server at startup.
+type downstreamTool struct {
+	Client *downstreamClient
+	Name   string  // the tool's name on the downstream server
+	Tool   mcpTool // as re-exported, with the namespaced name
+}
+
+var serverNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9-]*$`)
+
+func validateServers(servers []downstreamServer) error {
+	seen := make(map[string]bool)
+	for i, s := range servers {
+		if !serverNameRe.MatchString(s.Name) {
+			return fmt.Errorf("servers[%d]: name %q must be letters, digits and '-'", i, s.Name)
+		}
+		if seen[s.Name] {
+			return fmt.Errorf("servers[%d]: duplicate server name %q", i, s.Name)
+		}
+		seen[s.Name] = true
+		if (s.Command == "") == (s.URL == "") {
+			return fmt.Errorf("servers[%d] %s: set either command or url", i, s.Name)
+		}
+		if s.URL != "" {
+			if u, err := url.Parse(s.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("servers[%d] %s: url %q is not an http(s) URL", i, s.Name, s.URL)
+			}
+		}
+		for _, pattern := range append(append([]string(nil), s.AllowTools...), s.DenyTools...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("servers[%d] %s: invalid tool pattern %q", i, s.Name, pattern)
+			}
+		}
+		if s.TimeoutSec < 0 {
+			return fmt.Errorf("servers[%d] %s: timeoutSec must not be negative", i, s.Name)
+		}
+	}
+	return nil
+}
+
+func (s downstreamServer) timeout() time.Duration {
+	if s.TimeoutSec > 0 {
+		return time.Duration(s.TimeoutSec) * time.Second
+	}
+	return defaultServerTimeout
+}
+
+// exports reports whether the downstream tool passes the server's filters.
+func (s downstreamServer) exports(tool string) bool {
+	for _, pattern := range s.DenyTools {
+		if ok, _ := path.Match(pattern, tool); ok {
+			return false
+		}
+	}
+	if len(s.AllowTools) == 0 {
+		return true
+	}
+	for _, pattern := range s.AllowTools {
+		if ok, _ := path.Match(pattern, tool); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// redacted returns s without the values of its env and headers, for
+// printing the effective configuration.
+func (s downstreamServer) redacted() downstreamServer {
+	hide := func(m map[string]string) map[string]string {
+		if m == nil {
+			return nil
+		}
+		out := make(map[string]string, len(m))
+		for k := range m {
+			out[k] = "(set)"
+		}
+		return out
+	}
+	s.Env, s.Headers = hide(s.Env), hide(s.Headers)
+	return s
+}
+
+func redactedServers(servers []downstreamServer) []downstreamServer {
+	out := make([]downstreamServer, len(servers))
+	for i, s := range servers {
+		out[i] = s.redacted()
+	}
+	return out
+}
+
+// downstreamConn carries JSON-RPC messages to one downstream server.
+type downstreamConn interface {
+	// request sends a request and returns its result; JSON-RPC errors are
+	// returned as *mcp.Error, anything else means the connection failed.
+	request(ctx context.Context, method string, params any) (json.RawMessage, error)
+	notify(ctx context.Context, method string, params any) error
+	close()
+}
+
+// downstreamClient is the MCP client of one downstream server. It connects
+// and initializes on first use, and again after the connection fails.
+type downstreamClient struct {
+	server downstreamServer
+
+	mu   sync.Mutex
+	conn downstreamConn
+}
+
+func newDownstreamClient(s downstreamServer) *downstreamClient {
+	return &downstreamClient{server: s}
+}
+
+// request sends method to the server, connecting first if needed.
+func (c *downstreamClient) request(ctx context.Context, method string, params any) (json.RawMessage, error) {
+	conn, err := c.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result, err := conn.request(ctx, method, params)
+	var rpcErr *mcp.Error
+	if err != nil && !errors.As(err, &rpcErr) && ctx.Err() == nil {
+		c.drop(conn)
+	}
+	return result, err
+}
+
+func (c *downstreamClient) connect(ctx context.Context) (downstreamConn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		return c.conn, nil
+	}
+	var conn downstreamConn
+	if c.server.URL != "" {
+		conn = &httpConn{url: c.server.URL, headers: c.server.Headers}
+	} else {
+		sc, err := startStdioConn(c.server)
+		if err != nil {
+			return nil, err
+		}
+		conn = sc
+	}
+	_, err := conn.request(ctx, "initialize", map[string]any{
+		"protocolVersion": mcp.ProtocolVersion,
+		"capabilities":    map[string]any{},
+		"clientInfo":      mcp.ServerInfo{Name: mcp.ServerName, Version: serverVersion},
+	})
+	if err == nil {
+		err = conn.notify(ctx, "notifications/initialized", nil)
+	}
+	if err != nil {
+		conn.close()
+		return nil, fmt.Errorf("initialize: %w", err)
+	}
+	c.conn = conn
+	return conn, nil
+}
+
+// drop closes conn unless it was already replaced.
+func (c *downstreamClient) drop(conn downstreamConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == conn {
+		c.conn = nil
+	}
+	conn.close()
+}
+
+// listTools returns the server's tools, following nextCursor.
+func (c *downstreamClient) listTools(ctx context.Context) ([]mcpTool, error) {
+	var tools []mcpTool
+	params := map[string]any{}
+	for page := 0; page < maxServerToolPages; page++ {
+		raw, err := c.request(ctx, "tools/list", params)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Tools      []mcpTool `json:"tools"`
+			NextCursor string    `json:"nextCursor"`
+		}
+		if err := json.Unmarshal(raw, &result); err != nil {
+			return nil, fmt.Errorf("invalid tools/list result: %w", err)
+		}
+		tools = append(tools, result.Tools...)
+		if result.NextCursor == "" {
+			break
+		}
+		params = map[string]any{"cursor": result.NextCursor}
+	}
+	return tools, nil
+}
+
+// discoverServerTools lists the tools of every downstream server and
+// re-exports those its filters let through. Like plugins, servers that fail
+// and names that are already taken are skipped with a log message.
+func discoverServerTools(cfg serverConfig) []downstreamTool {
+	taken := make(map[string]bool)
+	for name := range builtinToolNames {
+		taken[name] = true
+	}
+	for _, t := range cfg.CustomTools {
+		taken[t.Name] = true
+	}
+	for _, pt := range cfg.PluginTools {
+		taken[pt.Tool.Name] = true
+	}
+
+	var out []downstreamTool
+	for _, s := range cfg.Servers {
+		client := newDownstreamClient(s)
+		ctx, cancel := context.WithTimeout(context.Background(), s.timeout())
+		tools, err := client.listTools(ctx)
+		cancel()
+		if err != nil {
+			log.Printf("[server] %s: tools/list failed: %v", s.Name, err)
+			continue
+		}
+		n := 0
+		for _, tool := range tools {
+			if tool.Name == "" || !s.exports(tool.Name) {
+				continue
+			}
+			name := s.Name + "_" + tool.Name
+			if taken[name] {
+				log.Printf("[server] %s: skipping tool %q (%s is taken)", s.Name, tool.Name, name)
+				continue
+			}
+			taken[name] = true
+			exported := tool
+			exported.Name = name
+			if exported.InputSchema == nil {
+				exported.InputSchema = map[string]any{"type": "object", "properties": map[string]any{}}
+			}
+			out = append(out, downstreamTool{Client: client, Name: tool.Name, Tool: exported})
+			n++
+		}
+		log.Printf("[server] %s: re-exporting %d of %d tools", s.Name, n, len(tools))
+	}
+	return out
+}
+
+func findServerTool(cfg serverConfig, name string) (downstreamTool, bool) {
+	for _, dt := range cfg.ServerTools {
+		if dt.Tool.Name == name {
+			return dt, true
+		}
+	}
+	return downstreamTool{}, false
+}
+
+// callServerTool forwards a tools/call to the downstream server that owns
+// the tool. Failures to reach it are tool errors, like a failed plugin.
+func callServerTool(ctx context.Context, dt downstreamTool, call *toolCall) *toolCallResult {
+	ctx, cancel := context.WithTimeout(ctx, dt.Client.server.timeout())
+	defer cancel()
+
+	arguments := call.Arguments
+	if len(arguments) == 0 {
+		arguments = json.RawMessage("{}")
+	}
+	log.Printf("[tools/call] server=%s tool=%s", dt.Client.server.Name, dt.Name)
+	raw, err := dt.Client.request(ctx, "tools/call", map[string]any{"name": dt.Name, "arguments": arguments})
+	var result toolCallResult
+	if err == nil {
+		err = json.Unmarshal(raw, &result)
+	}
+	if err != nil {
+		return &toolCallResult{
+			Content: []toolContent{{Type: "text", Text: fmt.Sprintf("server %s: %v", dt.Client.server.Name, err)}},
+			IsError: true,
+		}
+	}
+	if result.Content == nil {
+		result.Content = []toolContent{}
+	}
+	return &result
+}
+
+// downstreamMessage is a JSON-RPC message from a downstream server.
+type downstreamMessage struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Result json.RawMessage `json:"result"`
+	Error  *mcp.Error      `json:"error"`
+}
+
+func (m downstreamMessage) value() (json.RawMessage, error) {
+	if m.Error != nil {
+		return nil, m.Error
+	}
+	return m.Result, nil
+}
+
+// stdioConn talks to a server process over its stdin and stdout, one JSON
+// message per line.
+type stdioConn struct {
+	name  string
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	nextID  int
+	pending map[string]chan downstreamMessage
+	exited  chan struct{} // closed when stdout ends
+}
+
+func startStdioConn(s downstreamServer) (*stdioConn, error) {
+	cmd := exec.Command(s.Command, s.Args...)
+	cmd.Env = os.Environ()
+	for k, v := range s.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start %s: %w", s.Command, err)
+	}
+	c := &stdioConn{name: s.Name, cmd: cmd, stdin: stdin, pending: make(map[string]chan downstreamMessage), exited: make(chan struct{})}
+	go c.read(stdout)
+	return c, nil
+}
+
+// read delivers responses to the requests waiting for them until the
+// process closes stdout. Requests of the server are refused: this client
+// offers no capabilities.
+func (c *stdioConn) read(stdout io.Reader) {
+	defer close(c.exited)
+	r := bufio.NewReader(stdout)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			var msg downstreamMessage
+			if json.Unmarshal(line, &msg) != nil {
+				log.Printf("[server] %s: invalid message: %s", c.name, truncateForLog(string(line), 200))
+			} else if msg.Method != "" && msg.ID != nil {
+				_ = c.write(map[string]any{"jsonrpc": "2.0", "id": msg.ID, "error": mcp.NewError(mcp.CodeMethodNotFound, "method not found: "+msg.Method)})
+			} else if msg.ID != nil {
+				c.mu.Lock()
+				ch := c.pending[string(msg.ID)]
+				delete(c.pending, string(msg.ID))
+				c.mu.Unlock()
+				if ch != nil {
+					ch <- msg
+				}
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (c *stdioConn) write(msg any) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err = c.stdin.Write(append(b, '\n'))
+	return err
+}
+
+func (c *stdioConn) request(ctx context.Context, method string, params any) (json.RawMessage, error) {
+	c.mu.Lock()
+	c.nextID++
+	id := strconv.Itoa(c.nextID)
+	ch := make(chan downstreamMessage, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	if err := c.write(map[string]any{"jsonrpc": "2.0", "id": json.RawMessage(id), "method": method, "params": params}); err != nil {
+		return nil, fmt.Errorf("write: %w", err)
+	}
+	select {
+	case msg := <-ch:
+		return msg.value()
+	case <-c.exited:
+		return nil, errors.New("server process exited")
+	case <-ctx.Done():
+		_ = c.write(mcp.Notification("notifications/cancelled", map[string]any{"requestId": json.RawMessage(id), "reason": ctx.Err().Error()}))
+		return nil, ctx.Err()
+	}
+}
+
+func (c *stdioConn) notify(_ context.Context, method string, params any) error {
+	msg := map[string]any{"jsonrpc": "2.0", "method": method}
+	if params != nil {
+		msg["params"] = params
+	}
+	return c.write(msg)
+}
+
+// close ends the process: stdio servers exit when stdin closes, and are
+// killed if they don't within a few seconds.
+func (c *stdioConn) close() {
+	_ = c.stdin.Close()
+	go func() {
+		select {
+		case <-c.exited:
+		case <-time.After(5 * time.Second):
+			_ = c.cmd.Process.Kill()
+		}
+		_ = c.cmd.Wait()
+	}()
+}
+
+// httpConn talks to a Streamable HTTP server, which answers a request with
+// JSON or with an event stream ending in the response.
+type httpConn struct {
+	url     string
+	headers map[string]string
+
+	mu        sync.Mutex
+	nextID    int
+	sessionID string
+}
+
+func (c *httpConn) post(ctx context.Context, msg map[string]any) (*http.Response, error) {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range c.headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	c.mu.Lock()
+	if c.sessionID != "" {
+		req.Header.Set("Mcp-Session-Id", c.sessionID)
+		req.Header.Set("MCP-Protocol-Version", mcp.ProtocolVersion)
+	}
+	c.mu.Unlock()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	if id := resp.Header.Get("Mcp-Session-Id"); id != "" {
+		c.mu.Lock()
+		c.sessionID = id
+		c.mu.Unlock()
+	}
+	return resp, nil
+}
+
+func (c *httpConn) request(ctx context.Context, method string, params any) (json.RawMessage, error) {
+	c.mu.Lock()
+	c.nextID++
+	id := json.RawMessage(strconv.Itoa(c.nextID))
+	c.mu.Unlock()
+	resp, err := c.post(ctx, map[string]any{"jsonrpc": "2.0", "id": id, "method": method, "params": params})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		var msg downstreamMessage
+		if err := json.NewDecoder(resp.Body).Decode(&msg); err != nil {
+			return nil, fmt.Errorf("invalid response: %w", err)
+		}
+		return msg.value()
+	}
+	// Notifications and requests of the server precede the response
+	var data []string
+	response := func() (downstreamMessage, bool) {
+		var msg downstreamMessage
+		ok := len(data) > 0 && json.Unmarshal([]byte(strings.Join(data, "\n")), &msg) == nil && msg.Method == "" && string(msg.ID) == string(id)
+		data = nil
+		return msg, ok
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if d, ok := strings.CutPrefix(line, "data:"); ok {
+			data = append(data, strings.TrimPrefix(d, " "))
+		} else if line == "" {
+			if msg, ok := response(); ok {
+				return msg.value()
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if msg, ok := response(); ok {
+		return msg.value()
+	}
+	return nil, errors.New("event stream ended without a response")
+}
+
+func (c *httpConn) notify(ctx context.Context, method string, params any) error {
+	msg := map[string]any{"jsonrpc": "2.0", "method": method}
+	if params != nil {
+		msg["params"] = params
+	}
+	resp, err := c.post(ctx, msg)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (c *httpConn) close() {}
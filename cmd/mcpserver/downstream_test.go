@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"opencode-mcp/internal/mcp"
+)
+
+// downstreamHelper makes the test binary serve downstreamDispatcher over
+// stdio (see TestMain).
+const downstreamHelper = "__downstream-mcp"
+
+// downstreamDispatcher is a downstream server with a harmless tool and a
+// dangerous one.
+func downstreamDispatcher() *mcp.Dispatcher {
+	d := mcp.NewDispatcher()
+	d.Handle("initialize", func(context.Context, *mcp.Request) (any, *mcp.Error) {
+		return map[string]any{"protocolVersion": mcp.ProtocolVersion, "capabilities": map[string]any{"tools": map[string]any{}}, "serverInfo": map[string]any{"name": "downstream"}}, nil
+	})
+	schema := map[string]any{"type": "object", "properties": map[string]any{"text": map[string]any{"type": "string"}}}
+	d.Tool(mcp.Tool{Name: "echo", Description: "Echo text", InputSchema: schema}, func(_ context.Context, _ *mcp.Request, args json.RawMessage) (*mcp.ToolResult, *mcp.Error) {
+		var a struct{ Text string }
+		_ = json.Unmarshal(args, &a)
+		return &mcp.ToolResult{Content: []mcp.Content{{Type: "text", Text: "echo: " + a.Text}}}, nil
+	})
+	d.Tool(mcp.Tool{Name: "delete_all", Description: "Delete everything", InputSchema: schema}, func(context.Context, *mcp.Request, json.RawMessage) (*mcp.ToolResult, *mcp.Error) {
+		return &mcp.ToolResult{Content: []mcp.Content{{Type: "text", Text: "deleted"}}}, nil
+	})
+	return d
+}
+
+func serveDownstreamStdio() int {
+	d := downstreamDispatcher()
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		req, errResp := mcp.Decode(scanner.Bytes())
+		resp := errResp
+		if req != nil {
+			resp = d.Dispatch(context.Background(), req)
+		}
+		if resp != nil {
+			b, _ := json.Marshal(resp)
+			fmt.Printf("%s\n", b)
+		}
+	}
+	return 0
+}
+
+// serveDownstreamHTTP answers tool calls as an event stream with a
+// notification before the response, and everything else as JSON.
+func serveDownstreamHTTP(t *testing.T) *httptest.Server {
+	d := downstreamDispatcher()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body json.RawMessage
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		req, errResp := mcp.Decode(body)
+		resp := errResp
+		if req != nil {
+			resp = d.Dispatch(r.Context(), req)
+		}
+		if resp == nil {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		b, _ := json.Marshal(resp)
+		w.Header().Set("Mcp-Session-Id", "downstream-session")
+		if req != nil && req.Method == "tools/call" {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprintf(w, "data: %s\n\ndata: %s\n\n", `{"jsonrpc":"2.0","method":"notifications/message","params":{"level":"info","data":"working"}}`, b)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(b)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// Test tools of stdio and HTTP downstream servers are re-exported under
+// their server's name, filtered, and forwarded
+func TestServerTools(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	servers := map[string]downstreamServer{
+		"stdio": {Name: "local", Command: exe, Args: []string{downstreamHelper}, DenyTools: []string{"delete_*"}},
+		"http":  {Name: "remote", URL: serveDownstreamHTTP(t).URL, AllowTools: []string{"echo"}},
+	}
+	for transport, s := range servers {
+		t.Run(transport, func(t *testing.T) {
+			cfg := serverConfig{Servers: []downstreamServer{s}}
+			cfg.ServerTools = discoverServerTools(cfg)
+			var names []string
+			for _, dt := range cfg.ServerTools {
+				names = append(names, dt.Tool.Name)
+			}
+			if want := s.Name + "_echo"; strings.Join(names, ",") != want {
+				t.Fatalf("re-exported tools = %v, want %s", names, want)
+			}
+
+			h := newToolHandler(cfg)
+			result, mErr := h(context.Background(), &toolCall{Name: s.Name + "_echo", Arguments: []byte(`{"text":"hi"}`)})
+			if mErr != nil || result.IsError || result.Content[0].Text != "echo: hi" {
+				t.Errorf("result = %+v, %v", result, mErr)
+			}
+			if _, mErr := h(context.Background(), &toolCall{Name: s.Name + "_delete_all", Arguments: []byte(`{}`)}); mErr == nil || mErr.Data.Code != errUnknownTool.Code {
+				t.Errorf("filtered tool: err = %v", mErr)
+			}
+		})
+	}
+}
+
+func TestValidateServers(t *testing.T) {
+	tests := []struct {
+		servers []downstreamServer
+		wantErr string
+	}{
+		{[]downstreamServer{{Name: "fs", Command: "mcp-fs"}, {Name: "gh", URL: "https://example.com/mcp"}}, ""},
+		{[]downstreamServer{{Name: "fs_1", Command: "mcp-fs"}}, "must be letters"},
+		{[]downstreamServer{{Name: "fs", Command: "mcp-fs"}, {Name: "fs", Command: "mcp-fs"}}, "duplicate"},
+		{[]downstreamServer{{Name: "fs"}}, "either command or url"},
+		{[]downstreamServer{{Name: "fs", Command: "mcp-fs", URL: "https://example.com"}}, "either command or url"},
+		{[]downstreamServer{{Name: "gh", URL: "ftp://example.com"}}, "not an http(s) URL"},
+		{[]downstreamServer{{Name: "fs", Command: "mcp-fs", DenyTools: []string{"["}}}, "invalid tool pattern"},
+	}
+	for _, tt := range tests {
+		err := validateServers(tt.servers)
+		if (tt.wantErr == "") != (err == nil) || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("validateServers(%+v) = %v, want %q", tt.servers, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"opencode-mcp/internal/fakeopencode"
+	"opencode-mcp/internal/mcp"
+)
+
+// TestMain lets the test binary double as a fake opencode (see
+// internal/fakeopencode) and as a downstream MCP server.
+func TestMain(m *testing.M) {
+	if fakeopencode.Active() {
+		os.Exit(fakeopencode.Main(os.Args[1:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == netnsHelper {
+		os.Exit(runNetnsHelper(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == downstreamHelper {
+		os.Exit(serveDownstreamStdio())
+	}
+	os.Exit(m.Run())
+}
+
+// e2eServer serves /mcp with the test binary as the opencode target.
+func e2eServer(t *testing.T, script fakeopencode.Script, timeout time.Duration) *httptest.Server {
+	t.Helper()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(fakeopencode.EnvScript, script.Encode())
+	cfg := serverConfig{Target: exe, DefaultTimeout: timeout, DefaultModel: "fake/model", Backend: backendCLI}
+	srv := httptest.NewServer(newMCPHandler(&sessionStore{sessions: make(map[string]*session)}, cfg))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// e2eResponse is a tools/call reply split into its SSE frames.
+type e2eResponse struct {
+	contentType string
+	frames      []map[string]any // every data: frame, the response last
+	result      struct {
+		Content []toolContent  `json:"content"`
+		IsError bool           `json:"isError"`
+		Meta    map[string]any `json:"_meta"`
+	}
+	rpcErr *mcpError
+}
+
+func e2eCall(ctx context.Context, t *testing.T, url string, args map[string]any) (*e2eResponse, error) {
+	t.Helper()
+	params, _ := json.Marshal(map[string]any{"name": toolRun, "arguments": args})
+	body, _ := json.Marshal(mcpRequest{Request: mcp.Request{JSONRPC: "2.0", ID: json.RawMessage("7"), Method: "tools/call", Params: params}})
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	out := &e2eResponse{contentType: resp.Header.Get("Content-Type")}
+	var last []byte
+	if strings.HasPrefix(out.contentType, "text/event-stream") {
+		for _, frame := range strings.Split(strings.TrimSuffix(string(data), "\n\n"), "\n\n") {
+			payload, ok := strings.CutPrefix(frame, "data: ")
+			if !ok || strings.Contains(payload, "\n") {
+				t.Fatalf("malformed SSE frame %q", frame)
+			}
+			var m map[string]any
+			if err := json.Unmarshal([]byte(payload), &m); err != nil {
+				t.Fatalf("SSE frame is not JSON: %q", payload)
+			}
+			out.frames = append(out.frames, m)
+			last = []byte(payload)
+		}
+	} else {
+		last = data
+	}
+	var final struct {
+		ID     any             `json:"id"`
+		Result json.RawMessage `json:"result"`
+		Error  *mcpError       `json:"error"`
+	}
+	if err := json.Unmarshal(last, &final); err != nil {
+		t.Fatalf("final message %q: %v", last, err)
+	}
+	if final.ID != float64(7) {
+		t.Errorf("final message id = %v, want 7", final.ID)
+	}
+	out.rpcErr = final.Error
+	if final.Result != nil {
+		_ = json.Unmarshal(final.Result, &out.result)
+	}
+	return out, nil
+}
+
+func (r *e2eResponse) text() string {
+	var b strings.Builder
+	for _, c := range r.result.Content {
+		b.WriteString(c.Text)
+	}
+	return b.String()
+}
+
+func (r *e2eResponse) errorCode() string {
+	e, _ := r.result.Meta["error"].(map[string]any)
+	code, _ := e["code"].(string)
+	return code
+}
+
+// Test opencode_run end to end over HTTP against scripted opencode behavior
+func TestE2ERun(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  fakeopencode.Script
+		timeout time.Duration
+		check   func(t *testing.T, r *e2eResponse, elapsed time.Duration)
+	}{
+		{
+			name:   "events are streamed as SSE frames",
+			script: fakeopencode.Script{Lines: []string{fakeopencode.Text("hello "), fakeopencode.Text("world")}, LineDelay: 10 * time.Millisecond},
+			check: func(t *testing.T, r *e2eResponse, _ time.Duration) {
+				if !strings.HasPrefix(r.contentType, "text/event-stream") || len(r.frames) < 3 {
+					t.Fatalf("content type %q with %d frames, want progress frames and the response", r.contentType, len(r.frames))
+				}
+				for _, f := range r.frames[:len(r.frames)-1] {
+					if m, _ := f["method"].(string); !strings.HasPrefix(m, "notifications/") {
+						t.Errorf("frame before the response = %v", f)
+					}
+				}
+				if r.result.IsError || !strings.Contains(r.text(), "hello world") {
+					t.Errorf("result = %+v", r.result)
+				}
+			},
+		},
+		{
+			name:   "lines up to 1 MB are parsed",
+			script: fakeopencode.Script{HugeLine: 512 << 10},
+			check: func(t *testing.T, r *e2eResponse, _ time.Duration) {
+				if r.result.IsError || !strings.Contains(r.text(), strings.Repeat("x", 512<<10)) {
+					t.Errorf("isError=%v, text length %d", r.result.IsError, len(r.text()))
+				}
+			},
+		},
+		{
+			name:   "longer lines are skipped without stalling the run",
+			script: fakeopencode.Script{HugeLine: 3 << 20, Lines: []string{fakeopencode.Text("after")}},
+			check: func(t *testing.T, r *e2eResponse, elapsed time.Duration) {
+				if r.result.IsError || !strings.Contains(r.text(), "after") || elapsed > 5*time.Second {
+					t.Errorf("isError=%v text=%.80q after %s", r.result.IsError, r.text(), elapsed)
+				}
+			},
+		},
+		{
+			name:   "nonzero exits are run failures",
+			script: fakeopencode.Script{Lines: []string{fakeopencode.Text("partial")}, Stderr: "boom", ExitCode: 3},
+			check: func(t *testing.T, r *e2eResponse, _ time.Duration) {
+				if !r.result.IsError || r.errorCode() != errRunFailed.Code || !strings.Contains(r.text(), "boom") {
+					t.Errorf("result = %+v", r.result)
+				}
+			},
+		},
+		{
+			name:    "hung runs time out",
+			script:  fakeopencode.Script{Lines: []string{fakeopencode.Text("thinking")}, Hang: true},
+			timeout: 300 * time.Millisecond,
+			check: func(t *testing.T, r *e2eResponse, elapsed time.Duration) {
+				if !r.result.IsError || r.errorCode() != errTimeout.Code || elapsed > 5*time.Second {
+					t.Errorf("result = %+v after %s", r.result, elapsed)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			timeout := tt.timeout
+			if timeout == 0 {
+				timeout = 20 * time.Second
+			}
+			srv := e2eServer(t, tt.script, timeout)
+			start := time.Now()
+			r, err := e2eCall(context.Background(), t, srv.URL, map[string]any{"message": "hi"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.rpcErr != nil {
+				t.Fatalf("JSON-RPC error: %+v", r.rpcErr)
+			}
+			tt.check(t, r, time.Since(start))
+		})
+	}
+}
+
+// Test a client disconnecting kills the opencode process
+func TestE2ECancel(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	srv := e2eServer(t, fakeopencode.Script{Lines: []string{fakeopencode.Text("working")}, Hang: true, PIDFile: pidFile}, 20*time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = e2eCall(ctx, t, srv.URL, map[string]any{"message": "hi"})
+	}()
+
+	var pid int
+	for deadline := time.Now().Add(5 * time.Second); pid == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("fake opencode never started")
+		}
+		b, _ := os.ReadFile(pidFile)
+		pid, _ = strconv.Atoi(string(b))
+	}
+	cancel()
+	<-done
+	for deadline := time.Now().Add(5 * time.Second); processAlive(pid); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("opencode process %d still running after the client disconnected", pid)
+		}
+	}
+}
+
+// Test both servers answer ping while a tools/call is still streaming
+func TestE2EPingDuringRun(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	srv := e2eServer(t, fakeopencode.Script{Lines: []string{fakeopencode.Text("working")}, Hang: true, PIDFile: pidFile}, 20*time.Second)
+	waitStarted := func() {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+			if b, _ := os.ReadFile(pidFile); len(b) > 0 {
+				_ = os.Remove(pidFile)
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("fake opencode never started")
+			}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = e2eCall(ctx, t, srv.URL, map[string]any{"message": "hi"})
+	}()
+	waitStarted()
+	client := &http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Post(srv.URL, "application/json", strings.NewReader(`{"jsonrpc":"2.0","id":"p","method":"ping"}`))
+	if err != nil {
+		t.Fatalf("HTTP ping during a run: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), `"result":{}`) {
+		t.Errorf("HTTP ping during a run = %s", body)
+	}
+	cancel()
+	<-done
+
+	exe, _ := os.Executable()
+	cmd := exec.Command(buildMCPStdio(t))
+	cmd.Env = append(os.Environ(), "MCP_TARGET="+exe)
+	stdin, _ := cmd.StdinPipe()
+	stdout, _ := cmd.StdoutPipe()
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}()
+	fmt.Fprintln(stdin, `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"opencode_run","arguments":{"message":"hi","model":"fake/model"}}}`)
+	waitStarted()
+	fmt.Fprintln(stdin, `{"jsonrpc":"2.0","id":"p","method":"ping"}`)
+	pong := make(chan string, 1)
+	go func() {
+		sc := bufio.NewScanner(stdout)
+		for sc.Scan() {
+			var msg struct {
+				ID json.RawMessage `json:"id"`
+			}
+			if json.Unmarshal(sc.Bytes(), &msg) == nil && string(msg.ID) == `"p"` {
+				pong <- sc.Text()
+				return
+			}
+		}
+	}()
+	select {
+	case line := <-pong:
+		if !strings.Contains(line, `"result":{}`) {
+			t.Errorf("stdio ping during a run = %s", line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("stdio ping not answered during a run")
+	}
+}
+
+// Test the stdio server returns what the HTTP server does for the same
+// opencode output
+func TestE2EStdioParity(t *testing.T) {
+	stdio := buildMCPStdio(t)
+	exe, _ := os.Executable()
+
+	for _, script := range []fakeopencode.Script{
+		{Lines: []string{fakeopencode.Text("hello "), fakeopencode.Text("world")}},
+		{Lines: []string{fakeopencode.Text("partial")}, ExitCode: 3},
+	} {
+		t.Run(fmt.Sprintf("exit %d", script.ExitCode), func(t *testing.T) {
+			srv := e2eServer(t, script, 20*time.Second)
+			httpResp, err := e2eCall(context.Background(), t, srv.URL, map[string]any{"message": "hi", "model": "fake/model"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cmd := exec.Command(stdio)
+			cmd.Env = append(os.Environ(), "MCP_TARGET="+exe)
+			cmd.Stdin = strings.NewReader(`{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"name":"opencode_run","arguments":{"message":"hi","model":"fake/model"}}}` + "\n")
+			out, err := cmd.Output()
+			if err != nil {
+				t.Fatalf("mcpstdio: %v", err)
+			}
+			var stdioResult struct {
+				Content []toolContent `json:"content"`
+				IsError bool          `json:"isError"`
+			}
+			sc := bufio.NewScanner(bytes.NewReader(out))
+			for sc.Scan() {
+				var msg struct {
+					ID     any             `json:"id"`
+					Result json.RawMessage `json:"result"`
+				}
+				if json.Unmarshal(sc.Bytes(), &msg) == nil && msg.ID == float64(7) {
+					_ = json.Unmarshal(msg.Result, &stdioResult)
+				}
+			}
+			if len(stdioResult.Content) == 0 {
+				t.Fatalf("no stdio response in %q", out)
+			}
+			if stdioResult.IsError != httpResp.result.IsError {
+				t.Errorf("isError: stdio %v, HTTP %v", stdioResult.IsError, httpResp.result.IsError)
+			}
+			if text := stdioResult.Content[0].Text; !strings.HasPrefix(httpResp.text(), text) {
+				t.Errorf("text: stdio %q, HTTP %q", text, httpResp.text())
+			}
+		})
+	}
+}
+
+// buildMCPStdio builds cmd/mcpstdio and returns the binary's path, or skips
+// the test when that isn't possible.
+func buildMCPStdio(t *testing.T) string {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("builds cmd/mcpstdio")
+	}
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go not installed")
+	}
+	stdio := filepath.Join(t.TempDir(), "mcpstdio")
+	if out, err := exec.Command(goBin, "build", "-o", stdio, "../mcpstdio").CombinedOutput(); err != nil {
+		t.Fatalf("build mcpstdio: %v\n%s", err, out)
+	}
+	return stdio
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// egressPolicy restricts where a tenant's opencode processes may connect.
+// Their HTTP(S) traffic goes through the server's egress proxy, which
+// only lets allowHosts through; with isolate (Linux only) they run in a
+// network namespace whose only way out is that proxy, so tools that
+// ignore the proxy variables can't reach anything either.
+type egressPolicy struct {
+	AllowHosts []string `json:"allowHosts"` // host names; *.example.com also matches subdomains
+	Isolate    bool     `json:"isolate,omitempty"`
+}
+
+var egressHostRe = regexp.MustCompile(`^(\*\.)?[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?)*$`)
+
+func (p *egressPolicy) validate() error {
+	if len(p.AllowHosts) == 0 {
+		return fmt.Errorf("allowHosts must list the model provider hosts")
+	}
+	for i, host := range p.AllowHosts {
+		if !egressHostRe.MatchString(host) {
+			return fmt.Errorf("allowHosts[%d]: %q is not a host name or *.domain pattern", i, host)
+		}
+	}
+	return nil
+}
+
+// allows reports whether host (without port) is in allowHosts.
+func (p *egressPolicy) allows(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, pattern := range p.AllowHosts {
+		pattern = strings.ToLower(pattern)
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if host == suffix || strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// egressProxy is an HTTP proxy for child processes under an egress
+// policy. Each process gets its own proxy credentials, which select the
+// policy its requests are checked against. It listens on loopback for
+// ordinary children and on a unix socket for isolated ones, which can't
+// reach the host's loopback.
+type egressProxy struct {
+	once sync.Once
+	err  error
+	addr string // 127.0.0.1:port
+	sock string
+
+	mu     sync.Mutex
+	grants map[string]egressGrant // by proxy password
+}
+
+// egressGrant is what one process may reach, and through which proxy.
+type egressGrant struct {
+	policy   *egressPolicy
+	upstream *proxySettings // the tenant's proxy settings; nil connects directly
+}
+
+func newEgressProxy() *egressProxy {
+	return &egressProxy{grants: make(map[string]egressGrant)}
+}
+
+// start starts the listeners on first use.
+func (p *egressProxy) start() error {
+	p.once.Do(func() {
+		tcp, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			p.err = err
+			return
+		}
+		dir, err := os.MkdirTemp("", "mcp-egress-")
+		if err != nil {
+			p.err = err
+			return
+		}
+		p.sock = filepath.Join(dir, "proxy.sock")
+		unix, err := net.Listen("unix", p.sock)
+		if err != nil {
+			p.err = err
+			return
+		}
+		p.addr = tcp.Addr().String()
+		srv := &http.Server{Handler: p, ReadHeaderTimeout: 30 * time.Second}
+		go func() { _ = srv.Serve(tcp) }()
+		go func() { _ = srv.Serve(unix) }()
+		log.Printf("[egress] proxy listening on %s and %s", p.addr, p.sock)
+	})
+	return p.err
+}
+
+// grant registers policy for one process and returns its proxy URL.
+// Allowed connections go through upstream's proxies.
+func (p *egressProxy) grant(policy *egressPolicy, upstream *proxySettings) (proxyURL string, release func(), err error) {
+	if err := p.start(); err != nil {
+		return "", nil, fmt.Errorf("egress proxy: %w", err)
+	}
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	token := hex.EncodeToString(b)
+	p.mu.Lock()
+	p.grants[token] = egressGrant{policy, upstream}
+	p.mu.Unlock()
+	release = func() {
+		p.mu.Lock()
+		delete(p.grants, token)
+		p.mu.Unlock()
+	}
+	return "http://mcp:" + token + "@" + p.addr, release, nil
+}
+
+func (p *egressProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var grant egressGrant
+	if r.Header.Get("Proxy-Authorization") != "" {
+		probe := &http.Request{Header: http.Header{"Authorization": r.Header["Proxy-Authorization"]}}
+		if _, token, ok := probe.BasicAuth(); ok {
+			p.mu.Lock()
+			grant = p.grants[token]
+			p.mu.Unlock()
+		}
+	}
+	if grant.policy == nil {
+		w.Header().Set("Proxy-Authenticate", `Basic realm="mcp-egress"`)
+		http.Error(w, "proxy credentials required", http.StatusProxyAuthRequired)
+		return
+	}
+	host := r.URL.Hostname()
+	if r.Method == http.MethodConnect {
+		host, _, _ = net.SplitHostPort(r.Host)
+	}
+	if !grant.policy.allows(host) {
+		log.Printf("[egress] denied %s %s", r.Method, host)
+		http.Error(w, "host not allowed by the egress policy: "+host, http.StatusForbidden)
+		return
+	}
+	if r.Method == http.MethodConnect {
+		p.tunnel(w, r, grant.upstream.upstream("https", host))
+		return
+	}
+	if r.URL.Scheme != "http" {
+		http.Error(w, "unsupported proxy request", http.StatusBadRequest)
+		return
+	}
+	out := r.Clone(context.WithValue(r.Context(), upstreamKey{}, grant.upstream.upstream("http", host)))
+	out.RequestURI = ""
+	out.Header.Del("Proxy-Authorization")
+	out.Header.Del("Proxy-Connection")
+	resp, err := egressTransport.RoundTrip(out)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, _ = io.Copy(w, resp.Body)
+}
+
+type upstreamKey struct{}
+
+var egressTransport = &http.Transport{
+	Proxy: func(r *http.Request) (*url.URL, error) {
+		u, _ := r.Context().Value(upstreamKey{}).(*url.URL)
+		return u, nil
+	},
+	DialContext: (&net.Dialer{Timeout: 30 * time.Second}).DialContext,
+}
+
+// tunnel answers a CONNECT, through the proxy via when it isn't nil.
+func (p *egressProxy) tunnel(w http.ResponseWriter, r *http.Request, via *url.URL) {
+	upstream, err := dialTunnel(r.Context(), r.Host, via)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		upstream.Close()
+		http.Error(w, "tunneling not supported", http.StatusInternalServerError)
+		return
+	}
+	client, buf, err := hj.Hijack()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+	_, _ = client.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	go func() {
+		_, _ = io.Copy(upstream, buf)
+		upstream.Close()
+	}()
+	_, _ = io.Copy(client, upstream)
+	client.Close()
+}
+
+// dialTunnel connects to addr, directly or with a CONNECT through via.
+func dialTunnel(ctx context.Context, addr string, via *url.URL) (net.Conn, error) {
+	d := &net.Dialer{Timeout: 30 * time.Second}
+	if via == nil {
+		return d.DialContext(ctx, "tcp", addr)
+	}
+	proxyAddr := via.Host
+	if via.Port() == "" {
+		port := "80"
+		if via.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddr = net.JoinHostPort(via.Hostname(), port)
+	}
+	conn, err := d.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	if via.Scheme == "https" {
+		tc := tls.Client(conn, &tls.Config{ServerName: via.Hostname()})
+		if err := tc.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tc
+	}
+	req := &http.Request{Method: http.MethodConnect, URL: &url.URL{Opaque: addr}, Host: addr, Header: http.Header{}}
+	if via.User != nil {
+		pass, _ := via.User.Password()
+		req.SetBasicAuth(via.User.Username(), pass)
+		req.Header["Proxy-Authorization"] = req.Header["Authorization"]
+		delete(req.Header, "Authorization")
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("upstream proxy %s: %s", via.Host, resp.Status)
+	}
+	if br.Buffered() > 0 {
+		conn.Close()
+		return nil, fmt.Errorf("upstream proxy %s sent data before the tunnel", via.Host)
+	}
+	return conn, nil
+}
+
+// proxyEnvKeys are the variables through which HTTP clients pick a proxy.
+var proxyEnvKeys = []string{"HTTP_PROXY", "HTTPS_PROXY", "ALL_PROXY", "NO_PROXY", "http_proxy", "https_proxy", "all_proxy", "no_proxy"}
+
+// withProxyEnv returns env with the proxy variables replaced: proxyURL for
+// all traffic except to loopback.
+func withProxyEnv(env []string, proxyURL string) []string {
+	vars := make(map[string]string, len(proxyEnvKeys))
+	for _, k := range proxyEnvKeys {
+		vars[k] = proxyURL
+		if strings.EqualFold(k, "NO_PROXY") {
+			vars[k] = "localhost,127.0.0.1,::1"
+		}
+	}
+	return setEnv(env, vars)
+}
+
+// prepareChild applies the tenant's proxy settings and egress policy to
+// an opencode process before it starts. release must be called once the
+// process has exited.
+func prepareChild(cfg serverConfig, cmd *exec.Cmd) (release func(), err error) {
+	policy := cfg.Policy.Egress
+	if policy == nil && cfg.Proxy == nil {
+		return func() {}, nil
+	}
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = setEnv(cmd.Env, cfg.Proxy.baseURLEnv())
+	if policy == nil {
+		cmd.Env = setEnv(cmd.Env, cfg.Proxy.proxyEnv())
+		return func() {}, nil
+	}
+	if cfg.Egress == nil {
+		return nil, fmt.Errorf("egress policy set but no egress proxy configured")
+	}
+	proxyURL, release, err := cfg.Egress.grant(policy, cfg.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Env = withProxyEnv(cmd.Env, proxyURL)
+	if policy.Isolate {
+		_, port, _ := net.SplitHostPort(cfg.Egress.addr)
+		if err := isolateChild(cmd, cfg.Egress.sock, port); err != nil {
+			release()
+			return nil, fmt.Errorf("egress isolation: %w", err)
+		}
+	}
+	return release, nil
+}
+
+// forwardToSocket accepts connections on ln and pipes each one to the unix
+// socket sock; isolated children reach the proxy through it.
+func forwardToSocket(ctx context.Context, ln net.Listener, sock string) {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer c.Close()
+			var d net.Dialer
+			up, err := d.DialContext(ctx, "unix", sock)
+			if err != nil {
+				return
+			}
+			defer up.Close()
+			go func() { _, _ = io.Copy(up, c) }()
+			_, _ = io.Copy(c, up)
+		}()
+	}
+}
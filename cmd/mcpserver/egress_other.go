@@ -0,0 +1,21 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+const netnsHelper = "__egress-netns"
+
+func isolateChild(cmd *exec.Cmd, sock, port string) error {
+	return errors.New("network isolation requires Linux")
+}
+
+func runNetnsHelper(args []string) int {
+	fmt.Fprintln(os.Stderr, netnsHelper+": requires Linux")
+	return 1
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// Test the egress proxy only lets a process's granted hosts through
+func TestEgressProxy(t *testing.T) {
+	policy := &egressPolicy{AllowHosts: []string{"127.0.0.1", "*.example.com"}}
+	for host, want := range map[string]bool{"127.0.0.1": true, "api.example.com": true, "example.com": true, "example.com.evil.io": false, "localhost": false} {
+		if got := policy.allows(host); got != want {
+			t.Errorf("allows(%q) = %v", host, got)
+		}
+	}
+	if (&egressPolicy{AllowHosts: []string{"api.example.com:443"}}).validate() == nil {
+		t.Error("accepted a host with a port")
+	}
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("hi")) }))
+	defer backend.Close()
+	tlsBackend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("hi")) }))
+	defer tlsBackend.Close()
+
+	p := newEgressProxy()
+	get := func(proxyURL, target string) int {
+		t.Helper()
+		tr := tlsBackend.Client().Transport.(*http.Transport).Clone()
+		u, _ := url.Parse(proxyURL)
+		tr.Proxy = http.ProxyURL(u)
+		resp, err := (&http.Client{Transport: tr}).Get(target)
+		if err != nil {
+			if strings.Contains(err.Error(), "Forbidden") {
+				return http.StatusForbidden // CONNECT refused
+			}
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	allowed, release, err := p.grant(policy, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	denied, releaseDenied, _ := p.grant(&egressPolicy{AllowHosts: []string{"api.example.com"}}, nil)
+	defer releaseDenied()
+	if code := get(allowed, backend.URL); code != http.StatusOK {
+		t.Errorf("allowed http: %d", code)
+	}
+	if code := get(allowed, tlsBackend.URL); code != http.StatusOK {
+		t.Errorf("allowed https: %d", code)
+	}
+	if code := get(denied, backend.URL); code != http.StatusForbidden {
+		t.Errorf("denied http: %d", code)
+	}
+	if code := get(denied, tlsBackend.URL); code != http.StatusForbidden {
+		t.Errorf("denied https: %d", code)
+	}
+	release()
+	if code := get(allowed, backend.URL); code != http.StatusProxyAuthRequired {
+		t.Errorf("after release: %d", code)
+	}
+
+	env := withProxyEnv([]string{"PATH=/bin", "HTTPS_PROXY=http://corp:3128", "no_proxy=*"}, "http://x@127.0.0.1:1")
+	if strings.Contains(strings.Join(env, " "), "corp") || strings.Contains(strings.Join(env, " "), "no_proxy=*") || env[0] != "PATH=/bin" {
+		t.Errorf("env = %v", env)
+	}
+}
+
+// Test an isolated process reaches the network only through the proxy
+func TestEgressIsolation(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("isolation requires Linux")
+	}
+	if _, err := exec.LookPath("curl"); err != nil {
+		t.Skip("curl not installed")
+	}
+	if err := exec.Command("unshare", "-rn", "true").Run(); err != nil {
+		t.Skip("user namespaces unavailable")
+	}
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("hi")) }))
+	defer backend.Close()
+	cfg := serverConfig{Egress: newEgressProxy(), Policy: tenantPolicy{Egress: &egressPolicy{AllowHosts: []string{"127.0.0.1"}, Isolate: true}}}
+
+	run := func(script string) (string, error) {
+		cmd := exec.Command("sh", "-c", script)
+		release, err := prepareChild(cfg, cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer release()
+		out, err := cmd.CombinedOutput()
+		return string(out), err
+	}
+	// Loopback is in NO_PROXY, so curl connects directly: the host's
+	// loopback is not reachable from the namespace
+	if out, err := run("curl -sS --max-time 5 " + backend.URL); err == nil {
+		t.Errorf("direct connection succeeded: %s", out)
+	}
+	if out, err := run(`curl -sS --max-time 5 --noproxy '' -x "$HTTP_PROXY" ` + backend.URL); err != nil || out != "hi" {
+		t.Errorf("through the proxy: %q, %v", out, err)
+	}
+}
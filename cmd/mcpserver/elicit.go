@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"opencode-mcp/internal/mcp"
+)
+
+// When an opencode_run lacks a value the server can't default, it asks the
+// user with MCP elicitation instead of failing, if the client declared the
+// capability: the working directory of a call with neither cwd nor project
+// (and no tenant default), and the project of a call naming an unknown or
+// ambiguous one. The elicitation/create request goes out on the call's SSE
+// stream, and the client POSTs its response to /mcp.
+
+// elicitTimeout bounds the wait for the user's answer.
+const elicitTimeout = 5 * time.Minute
+
+// clientResponse is a client's response to a request from the server.
+type clientResponse struct {
+	Result json.RawMessage
+	Error  *mcp.Error
+}
+
+// clientRequests tracks the requests sent to a session's client until it
+// responds. The zero value is ready to use.
+type clientRequests struct {
+	mu      sync.Mutex
+	next    int
+	waiting map[string]chan clientResponse
+}
+
+// start allocates an ID for a request to the client. wait receives the
+// response; done forgets the request.
+func (r *clientRequests) start() (id json.RawMessage, wait <-chan clientResponse, done func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.waiting == nil {
+		r.waiting = map[string]chan clientResponse{}
+	}
+	r.next++
+	id = json.RawMessage(strconv.Quote("srv-" + strconv.Itoa(r.next)))
+	ch := make(chan clientResponse, 1)
+	r.waiting[string(id)] = ch
+	return id, ch, func() {
+		r.mu.Lock()
+		delete(r.waiting, string(id))
+		r.mu.Unlock()
+	}
+}
+
+// deliver hands a response to the request it answers, reporting whether
+// one was waiting.
+func (r *clientRequests) deliver(id json.RawMessage, resp clientResponse) bool {
+	r.mu.Lock()
+	ch, ok := r.waiting[string(id)]
+	delete(r.waiting, string(id))
+	r.mu.Unlock()
+	if ok {
+		ch <- resp
+	}
+	return ok
+}
+
+// canElicit reports whether the call's client can be asked for input.
+func (c *toolCall) canElicit() bool {
+	return c.ask != nil && c.Session.capabilities().Elicitation
+}
+
+// elicit asks the user to fill schema's properties, returning what they
+// entered. Declining or cancelling fails the call.
+func (c *toolCall) elicit(ctx context.Context, message string, properties map[string]any, required []string) (map[string]any, *mcpError) {
+	ctx, cancel := context.WithTimeout(ctx, elicitTimeout)
+	defer cancel()
+	log.Printf("[elicit] session=%s asking: %s", c.Session.id, message)
+	raw, err := c.ask(ctx, "elicitation/create", map[string]any{
+		"message": message,
+		"requestedSchema": map[string]any{
+			"type":       "object",
+			"properties": properties,
+			"required":   required,
+		},
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, errCancelled.err("no answer from the user")
+		}
+		return nil, errInvalidArguments.err(fmt.Sprintf("asking for input failed: %v", err))
+	}
+	var result struct {
+		Action  string         `json:"action"`
+		Content map[string]any `json:"content"`
+	}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, errInvalidArguments.err(fmt.Sprintf("asking for input failed: %v", err))
+	}
+	if result.Action != "accept" {
+		return nil, errInvalidArguments.err(fmt.Sprintf("the user did not answer (%s)", result.Action))
+	}
+	return result.Content, nil
+}
+
+// projectCandidates returns the projects name may have meant: those whose
+// name contains it, ignoring case, or else all of them.
+func projectCandidates(r *projectRegistry, name string) (matches, all []string) {
+	for _, p := range r.list() {
+		all = append(all, p.Name)
+		if strings.Contains(strings.ToLower(p.Name), strings.ToLower(name)) {
+			matches = append(matches, p.Name)
+		}
+	}
+	return matches, all
+}
+
+// elicitProject asks which project an unknown or ambiguous name meant. It
+// returns the error the call fails with when the client can't be asked.
+func elicitProject(ctx context.Context, cfg serverConfig, call *toolCall, name string) (project, *mcpError) {
+	matches, all := projectCandidates(cfg.Projects, name)
+	unknown := errInvalidArguments.err(fmt.Sprintf("unknown project %q (see %s)", name, toolProjectList))
+	if len(matches) > 1 {
+		unknown = errInvalidArguments.err(fmt.Sprintf("project %q is ambiguous: it may be %s", name, strings.Join(matches, ", ")))
+	}
+	choices := matches
+	if len(choices) == 0 {
+		choices = all
+	}
+	if !call.canElicit() || len(choices) == 0 {
+		return project{}, unknown
+	}
+	content, mErr := call.elicit(ctx, call.sprintf("Which project did you mean by %q?", name), map[string]any{
+		"project": map[string]any{"type": "string", "title": "Project", "enum": choices},
+	}, []string{"project"})
+	if mErr != nil {
+		return project{}, mErr
+	}
+	chosen, _ := content["project"].(string)
+	p, ok := cfg.Projects.get(chosen)
+	if !ok {
+		return project{}, errInvalidArguments.err(fmt.Sprintf("unknown project %q (see %s)", chosen, toolProjectList))
+	}
+	return p, nil
+}
+
+// elicitMiddleware asks for the working directory of an opencode_run that
+// has none and can't get one from a project, a repo or the tenant's
+// policy. Clients that can't be asked run in the server's directory, as
+// before.
+func elicitMiddleware(cfg serverConfig) toolMiddleware {
+	return func(next toolHandler) toolHandler {
+		return func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+			if call.Name != toolRun || call.Cwd != "" || !call.canElicit() || cfg.forTenant(call.Tenant).Policy.defaultDir() != "" {
+				return next(ctx, call)
+			}
+			var args map[string]any
+			if json.Unmarshal(call.Arguments, &args) != nil {
+				return next(ctx, call)
+			}
+			if cwd, _ := args["cwd"].(string); cwd != "" {
+				return next(ctx, call)
+			}
+			properties := map[string]any{
+				"cwd": map[string]any{"type": "string", "title": "Working directory", "description": "Absolute path of the directory to run opencode in"},
+			}
+			var required []string
+			_, projects := projectCandidates(cfg.Projects, "")
+			if len(projects) > 0 {
+				properties["project"] = map[string]any{"type": "string", "title": "Project", "description": "Or one of the registered projects", "enum": projects}
+			} else {
+				required = []string{"cwd"}
+			}
+			content, mErr := call.elicit(ctx, call.sprintf("Which directory should opencode work in?"), properties, required)
+			if mErr != nil {
+				return nil, mErr
+			}
+			if name, _ := content["project"].(string); name != "" {
+				p, ok := cfg.Projects.get(name)
+				if !ok {
+					return nil, errInvalidArguments.err(fmt.Sprintf("unknown project %q (see %s)", name, toolProjectList))
+				}
+				applyProject(call, args, p)
+				return next(ctx, call)
+			}
+			cwd, _ := content["cwd"].(string)
+			if cwd == "" {
+				return nil, errInvalidArguments.err("no working directory given")
+			}
+			if err := validateCwd(cwd); err != nil {
+				return nil, errInvalidCwd.err(err.Error())
+			}
+			args["cwd"] = cwd
+			call.Arguments, _ = json.Marshal(args)
+			call.Cwd = cwd
+			return next(ctx, call)
+		}
+	}
+}
+
+// registerClientResponse delivers a response the client POSTed to a
+// request of the server.
+func registerClientResponse(sess *session, id json.RawMessage, resp clientResponse) {
+	if sess == nil || !sess.requests.deliver(id, resp) {
+		log.Printf("[elicit] response to unknown request id=%s", id)
+		return
+	}
+	log.Printf("[elicit] response id=%s session=%s", id, sess.id)
+}
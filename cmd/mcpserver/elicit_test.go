@@ -0,0 +1,140 @@
+//go:build !windows
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test elicitMiddleware and projectMiddleware ask eliciting clients for a
+// missing cwd or the project they meant, and fail as before otherwise
+func TestElicitMiddleware(t *testing.T) {
+	st, _ := openStore("")
+	cfg := serverConfig{Projects: newProjectRegistry(map[string]project{
+		"billing-api": {Path: "/srv/billing-api", Model: "p/fast"},
+		"billing-web": {Path: "/srv/billing-web"},
+	}, st)}
+	var got *toolCall
+	handler := projectMiddleware(cfg)(elicitMiddleware(cfg)(func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+		got = call
+		return &toolCallResult{}, nil
+	}))
+	eliciting := &session{id: "s1", caps: clientCapabilities{Elicitation: true}}
+	var asked []string
+	answer := func(content string) func(context.Context, string, any) (json.RawMessage, error) {
+		return func(_ context.Context, method string, params any) (json.RawMessage, error) {
+			asked = append(asked, params.(map[string]any)["message"].(string))
+			if method != "elicitation/create" {
+				t.Errorf("method = %s", method)
+			}
+			return json.RawMessage(content), nil
+		}
+	}
+	call := func(sess *session, ask func(context.Context, string, any) (json.RawMessage, error), args string) (string, *mcpError) {
+		got, asked = nil, nil
+		_, mErr := handler(context.Background(), &toolCall{Name: toolRun, Session: sess, ask: ask, Arguments: json.RawMessage(args)})
+		if got == nil {
+			return "", mErr
+		}
+		return got.Cwd, mErr
+	}
+
+	dir := t.TempDir()
+	if cwd, mErr := call(eliciting, answer(`{"action":"accept","content":{"cwd":"`+dir+`"}}`), `{"message":"hi"}`); mErr != nil || cwd != dir || len(asked) != 1 {
+		t.Errorf("missing cwd: cwd %q, asked %v, %v", cwd, asked, mErr)
+	}
+	if cwd, mErr := call(eliciting, answer(`{"action":"accept","content":{"project":"billing-api"}}`), `{"message":"hi"}`); mErr != nil || cwd != "/srv/billing-api" || !strings.Contains(string(got.Arguments), "p/fast") {
+		t.Errorf("missing cwd, project chosen: cwd %q, %v", cwd, mErr)
+	}
+	if cwd, mErr := call(eliciting, answer(`{"action":"accept","content":{"project":"billing-web"}}`), `{"message":"hi","project":"billing"}`); mErr != nil || cwd != "/srv/billing-web" || len(asked) != 1 {
+		t.Errorf("ambiguous project: cwd %q, asked %v, %v", cwd, asked, mErr)
+	}
+	if _, mErr := call(eliciting, answer(`{"action":"decline"}`), `{"message":"hi","project":"billing"}`); mErr == nil || mErr.Data.Code != errInvalidArguments.Code {
+		t.Errorf("declined: %v", mErr)
+	}
+	if _, mErr := call(eliciting, answer(`{"action":"accept","content":{"cwd":"relative"}}`), `{"message":"hi"}`); mErr == nil || mErr.Data.Code != errInvalidCwd.Code {
+		t.Errorf("relative cwd: %v", mErr)
+	}
+
+	// Clients without the capability get the error, or the server's directory
+	if _, mErr := call(&session{id: "s2"}, answer(`{}`), `{"message":"hi","project":"billing"}`); mErr == nil || !strings.Contains(mErr.Message, "ambiguous") || len(asked) != 0 {
+		t.Errorf("ambiguous project without elicitation: asked %v, %v", asked, mErr)
+	}
+	if cwd, mErr := call(nil, nil, `{"message":"hi"}`); mErr != nil || cwd != "" {
+		t.Errorf("missing cwd without elicitation: cwd %q, %v", cwd, mErr)
+	}
+}
+
+// Test the elicitation request goes out on the call's SSE stream and the
+// response POSTed by the client resumes the run in the chosen directory
+func TestElicitOverHTTP(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "opencode")
+	content := `#!/bin/sh
+printf '{"type":"text","sessionID":"ses_x","part":{"text":"in %s"}}\n' "$(pwd)"
+`
+	if err := os.WriteFile(script, []byte(content), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	work := t.TempDir()
+	cfg := serverConfig{Target: script, DefaultTimeout: time.Minute}
+	srv := httptest.NewServer(newMCPHandler(&sessionStore{sessions: make(map[string]*session)}, cfg))
+	defer srv.Close()
+	post := func(sessionID, body string) *http.Response {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if sessionID != "" {
+			req.Header.Set("Mcp-Session-Id", sessionID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := post("", `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-06-18","capabilities":{"elicitation":{}},"clientInfo":{"name":"test"}}}`)
+	resp.Body.Close()
+	sessionID := resp.Header.Get("Mcp-Session-Id")
+
+	resp = post(sessionID, `{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"opencode_run","arguments":{"message":"hi"}}}`)
+	defer resp.Body.Close()
+	var result string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var msg struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+			Result json.RawMessage `json:"result"`
+		}
+		if err := json.Unmarshal([]byte(data), &msg); err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case msg.Method == "elicitation/create":
+			answer := post(sessionID, `{"jsonrpc":"2.0","id":`+string(msg.ID)+`,"result":{"action":"accept","content":{"cwd":"`+work+`"}}}`)
+			answer.Body.Close()
+			if answer.StatusCode != http.StatusAccepted {
+				t.Errorf("response to elicitation: %d", answer.StatusCode)
+			}
+		case string(msg.ID) == "2":
+			result = string(msg.Result)
+		}
+	}
+	if !strings.Contains(result, "in "+work) {
+		t.Errorf("result = %s, want a run in %s", result, work)
+	}
+}
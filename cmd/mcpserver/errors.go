@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+)
+
+// errorCode is an entry of the error catalogue. Codes are stable so client
+// automation can match on them instead of messages: never renumber or
+// reuse one.
+type errorCode struct {
+	Code        string `json:"code"`
+	RPCCode     int    `json:"rpcCode,omitempty"` // JSON-RPC error code; 0 when only reported in tool results
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Retryable   bool   `json:"retryable"`
+}
+
+// errorData is the data member of JSON-RPC errors, and the error member of
+// a failed tool result's _meta.
+type errorData struct {
+	Code      string `json:"code"`
+	Retryable bool   `json:"retryable,omitempty"`
+	Pointer   string `json:"pointer,omitempty"` // JSON pointer of the invalid argument, for schema violations
+}
+
+var (
+	// 1xxx: the request is invalid.
+	errInvalidArguments    = errorCode{"OC-1000", -32602, "invalid arguments", "The tool arguments or request parameters are missing or malformed.", false}
+	errInvalidCwd          = errorCode{"OC-1001", -32602, "invalid cwd", "The working directory does not exist or is not a directory.", false}
+	errUnknownTool         = errorCode{"OC-1002", -32602, "unknown tool", "No tool, prompt or method with this name exists.", false}
+	errInvalidRequest      = errorCode{"OC-1003", -32600, "invalid request", "The body is not a valid JSON-RPC request.", false}
+	errIdempotencyConflict = errorCode{"OC-1004", -32602, "idempotency conflict", "The idempotency key was already used for a call with different arguments.", false}
+	errSessionNotFound     = errorCode{"OC-1005", -32001, "session not found", "The Mcp-Session-Id is unknown, e.g. from before a server restart; initialize a new session.", false}
+	errSessionRequired     = errorCode{"OC-1006", -32600, "session required", "The server requires an Mcp-Session-Id from initialize on every other request (MCP_REQUIRE_SESSION).", false}
+	errMessageTooLong      = errorCode{"OC-1007", -32602, "message too long", "The message and its attachments exceed MCP_MAX_MESSAGE_CHARS; shorten them, or have the server split them (MCP_OVERSIZE=split).", false}
+	errAttachmentRejected  = errorCode{"OC-1008", -32602, "attachment rejected", "A URL attachment could not be fetched, exceeds MCP_ATTACH_MAX_BYTES, or is neither text nor an image.", false}
+
+	// 2xxx: the opencode run failed.
+	errTimeout           = errorCode{"OC-2001", 0, "timeout", "The run exceeded its timeout and was killed.", true}
+	errCancelled         = errorCode{"OC-2002", -32000, "cancelled", "The client cancelled the call or disconnected.", true}
+	errRunFailed         = errorCode{"OC-2003", 0, "run failed", "opencode exited with a non-zero status.", false}
+	errStartFailed       = errorCode{"OC-2004", -32000, "start failed", "The opencode binary could not be started.", false}
+	errUnrecognizedEvent = errorCode{"OC-2005", 0, "unrecognized event", "opencode emitted an event the server doesn't understand (MCP_STRICT_EVENTS).", false}
+	errCheckoutFailed    = errorCode{"OC-2006", -32000, "checkout failed", "The repo could not be cloned or updated into the workspace cache.", true}
+	errStalled           = errorCode{"OC-2007", 0, "stalled", "opencode printed nothing for MCP_STALL_TIMEOUT and was stopped.", true}
+	errStdinTimeout      = errorCode{"OC-2008", 0, "stdin timeout", "The command didn't read all of its stdin within MCP_STDIN_TIMEOUT and was killed.", false}
+
+	// 3xxx: a tenant policy denied the call.
+	errPolicyDenied     = errorCode{"OC-3001", -32602, "policy denied", "The directory or repo is outside the tenant's allowedDirs or allowedRepos.", false}
+	errQuotaExceeded    = errorCode{"OC-3002", -32000, "quota exceeded", "The tenant's daily run or cost quota is used up.", true}
+	errAttachmentDenied = errorCode{"OC-3003", -32602, "attachment denied", "The host of a URL attachment is not in MCP_ATTACH_ALLOW_HOSTS or the tenant's egress allowHosts.", false}
+
+	// 4xxx: the model provider rejected the request.
+	errProviderAuth      = errorCode{"OC-4001", 0, "provider auth", "The model provider rejected opencode's credentials.", false}
+	errProviderRateLimit = errorCode{"OC-4002", 0, "provider rate limit", "The model provider is rate limiting requests.", true}
+
+	// 5xxx: the server failed.
+	errInternal = errorCode{"OC-5001", -32603, "internal error", "An unexpected server error; see the server log.", true}
+	errDiskFull = errorCode{"OC-5002", -32000, "disk full", "Free space on the workspace, artifact or store filesystem is below MCP_DISK_MIN_FREE_MB.", true}
+)
+
+// errorCatalogue lists every code, for GET /errors.
+var errorCatalogue = []errorCode{
+	errInvalidArguments, errInvalidCwd, errUnknownTool, errInvalidRequest, errIdempotencyConflict, errSessionNotFound, errSessionRequired, errMessageTooLong, errAttachmentRejected,
+	errTimeout, errCancelled, errRunFailed, errStartFailed, errUnrecognizedEvent, errCheckoutFailed, errStalled, errStdinTimeout,
+	errPolicyDenied, errQuotaExceeded, errAttachmentDenied,
+	errProviderAuth, errProviderRateLimit,
+	errInternal, errDiskFull,
+}
+
+func (c errorCode) data() *errorData {
+	return &errorData{Code: c.Code, Retryable: c.Retryable}
+}
+
+// err returns a JSON-RPC error with this code.
+func (c errorCode) err(message string) *mcpError {
+	return &mcpError{Code: c.RPCCode, Message: message, Data: c.data()}
+}
+
+// withDefaultData fills in error data from the JSON-RPC code for errors
+// created without a catalogue entry.
+func (e *mcpError) withDefaultData() *mcpError {
+	if e == nil || e.Data != nil {
+		return e
+	}
+	c := errInternal
+	switch e.Code {
+	case -32700, -32600:
+		c = errInvalidRequest
+	case -32601:
+		c = errUnknownTool
+	case -32602:
+		c = errInvalidArguments
+	case -32000:
+		c = errRunFailed
+	}
+	out := *e
+	out.Data = c.data()
+	return &out
+}
+
+// setError records code in the _meta of a failed tool result.
+func (r *toolCallResult) setError(c errorCode) {
+	if r.Meta == nil {
+		r.Meta = map[string]any{}
+	}
+	r.Meta["error"] = c.data()
+}
+
+var (
+	providerAuthRe      = regexp.MustCompile(`(?i)ProviderAuthError|\b401\b|unauthori[sz]ed|invalid (x-)?api[ _-]?key|authentication (failed|error)`)
+	providerRateLimitRe = regexp.MustCompile(`(?i)\b429\b|rate[ _-]?limit|too many requests`)
+)
+
+// classifyFailure picks the code of a failed run from its context error
+// and output.
+func classifyFailure(err error, output string) errorCode {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return errTimeout
+	case errors.Is(err, context.Canceled):
+		return errCancelled
+	case providerAuthRe.MatchString(output):
+		return errProviderAuth
+	case providerRateLimitRe.MatchString(output):
+		return errProviderRateLimit
+	}
+	return errRunFailed
+}
+
+// registerErrorRoutes documents the catalogue at GET /errors, in the
+// language of the request's Accept-Language.
+func registerErrorRoutes(mux *http.ServeMux, cfg serverConfig) {
+	mux.HandleFunc("GET /errors", func(w http.ResponseWriter, r *http.Request) {
+		lang := requestLocale(r, cfg)
+		w.Header().Set("Content-Language", lang)
+		writeJSON(w, http.StatusOK, localizedCatalogue(lang))
+	})
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// Test catalogue codes are well-formed and unique
+func TestErrorCatalogue(t *testing.T) {
+	codeRe := regexp.MustCompile(`^OC-[1-5]\d{3}$`)
+	seen := map[string]bool{}
+	for _, c := range errorCatalogue {
+		if !codeRe.MatchString(c.Code) || seen[c.Code] {
+			t.Errorf("bad or duplicate code %q", c.Code)
+		}
+		seen[c.Code] = true
+		if c.Title == "" || c.Description == "" {
+			t.Errorf("%s: missing title or description", c.Code)
+		}
+	}
+}
+
+// Test failed runs are classified from the context error and output
+func TestClassifyFailure(t *testing.T) {
+	tests := []struct {
+		err    error
+		output string
+		want   errorCode
+	}{
+		{context.DeadlineExceeded, "", errTimeout},
+		{fmt.Errorf("post: %w", context.Canceled), "", errCancelled},
+		{nil, `{"name":"ProviderAuthError","data":{"message":"missing key"}}`, errProviderAuth},
+		{nil, "Error: 401 Unauthorized", errProviderAuth},
+		{nil, "AI_APICallError: 429 Too Many Requests", errProviderRateLimit},
+		{nil, "panic: something else", errRunFailed},
+		{errors.New("exit status 1"), "", errRunFailed},
+	}
+	for _, tt := range tests {
+		if got := classifyFailure(tt.err, tt.output); got.Code != tt.want.Code {
+			t.Errorf("classifyFailure(%v, %q) = %s, want %s", tt.err, tt.output, got.Code, tt.want.Code)
+		}
+	}
+}
+
+// Test a timed-out run reports its code in the result _meta
+func TestRunTimeoutErrorCode(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "opencode")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\n[ \"$1\" = run ] && exec sleep 5\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	cfg := serverConfig{Target: script, DefaultModel: "test/model", DefaultTimeout: 200 * time.Millisecond}
+	result, mErr := newToolHandler(cfg)(context.Background(), &toolCall{Name: toolRun, Arguments: []byte(`{"message":"hi"}`)})
+	if mErr != nil {
+		t.Fatal(mErr)
+	}
+	data, _ := result.Meta["error"].(*errorData)
+	if !result.IsError || data == nil || data.Code != errTimeout.Code {
+		t.Errorf("result = %+v, want %s", result, errTimeout.Code)
+	}
+}
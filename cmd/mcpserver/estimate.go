@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// estimateOverheadTokens approximates opencode's system prompt and tool
+	// definitions, which are sent with every request.
+	estimateOverheadTokens = 10000
+
+	defaultEstimateOutputTokens = 1000
+)
+
+// estimateArgs are the arguments of opencode_estimate.
+type estimateArgs struct {
+	Message      string   `json:"message"`
+	Files        []string `json:"files"`
+	Model        string   `json:"model"`
+	Cwd          string   `json:"cwd"`
+	OutputTokens int      `json:"output_tokens"`
+}
+
+type costEstimate struct {
+	Model          string    `json:"model"`
+	MessageTokens  int       `json:"messageTokens"`
+	FileTokens     int       `json:"fileTokens"`
+	OverheadTokens int       `json:"overheadTokens"`
+	InputTokens    int       `json:"inputTokens"`
+	OutputTokens   int       `json:"outputTokens"`
+	Price          modelCost `json:"price"`
+	PriceSource    string    `json:"priceSource,omitempty"`
+	Cost           float64   `json:"cost"`
+	Warnings       []string  `json:"warnings,omitempty"`
+}
+
+var estimateSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"message": map[string]any{
+			"type":        "string",
+			"description": "Message that would be sent to opencode_run",
+		},
+		"files": map[string]any{
+			"type":        "array",
+			"items":       map[string]any{"type": "string"},
+			"description": "Files that would be attached (relative to cwd or absolute)",
+		},
+		"model": map[string]any{
+			"type":        "string",
+			"description": "Model to price (defaults to the server's default model)",
+		},
+		"cwd": map[string]any{
+			"type":        "string",
+			"description": "Directory relative file paths are resolved against",
+		},
+		"output_tokens": map[string]any{
+			"type":        "integer",
+			"description": fmt.Sprintf("Expected output tokens (default %d)", defaultEstimateOutputTokens),
+		},
+	},
+	"required": []string{"message"},
+}
+
+// estimateTool previews the input tokens and cost of an opencode_run without
+// running it. Token counts use a ~4 characters per token approximation.
+func estimateTool(cfg serverConfig, call *toolCall) (*toolCallResult, *mcpError) {
+	var args estimateArgs
+	if err := json.Unmarshal(call.Arguments, &args); err != nil {
+		return nil, errInvalidArguments.err("invalid arguments")
+	}
+	if args.Message == "" {
+		return nil, errInvalidArguments.err("missing message")
+	}
+	if args.OutputTokens <= 0 {
+		args.OutputTokens = defaultEstimateOutputTokens
+	}
+	dir := args.Cwd
+	if dir == "" {
+		dir = call.Cwd
+	}
+	if dir != "" {
+		if err := validateCwd(dir); err != nil {
+			return nil, errInvalidCwd.err(err.Error())
+		}
+		if err := cfg.Policy.checkDir(dir); err != nil {
+			return nil, errPolicyDenied.err(err.Error())
+		}
+	}
+
+	est := costEstimate{
+		Model:          args.Model,
+		MessageTokens:  estimateTokens(args.Message),
+		OverheadTokens: estimateOverheadTokens,
+		OutputTokens:   args.OutputTokens,
+	}
+	if est.Model == "" {
+		est.Model = getDefaultModel(cfg)
+	}
+	for _, f := range args.Files {
+		path := f
+		if !filepath.IsAbs(path) && dir != "" {
+			path = filepath.Join(dir, path)
+		}
+		if !cfg.Policy.allows(path) {
+			est.Warnings = append(est.Warnings, fmt.Sprintf("skipped %s: outside the allowed directories", f))
+			continue
+		}
+		// The size is all the estimate needs, so files aren't read
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			est.Warnings = append(est.Warnings, fmt.Sprintf("skipped %s: not a readable file", f))
+			continue
+		}
+		est.FileTokens += tokensForBytes(info.Size())
+	}
+	est.InputTokens = est.MessageTokens + est.FileTokens + est.OverheadTokens
+
+	price, source, err := priceFor(cfg, est.Model)
+	if err != nil {
+		est.Warnings = append(est.Warnings, err.Error())
+	} else {
+		est.Price, est.PriceSource = price, source
+		est.Cost = price.cost(est.InputTokens, est.OutputTokens)
+	}
+
+	text := fmt.Sprintf("Model: %s\nInput: ~%d tokens (message %d, files %d, overhead %d)\nOutput: ~%d tokens\n",
+		est.Model, est.InputTokens, est.MessageTokens, est.FileTokens, est.OverheadTokens, est.OutputTokens)
+	if source != "" {
+		text += fmt.Sprintf("Estimated cost: $%.4f ($%g/$%g per 1M tokens, %s pricing)\n", est.Cost, price.Input, price.Output, source)
+	}
+	for _, w := range est.Warnings {
+		text += "[warning] " + w + "\n"
+	}
+	return &toolCallResult{
+		Content:           []toolContent{{Type: "text", Text: strings.TrimRight(text, "\n")}},
+		StructuredContent: est,
+	}, nil
+}
+
+// estimateTokens approximates the token count of text.
+func estimateTokens(text string) int {
+	return tokensForBytes(int64(len(text)))
+}
+
+// tokensForBytes approximates the token count of n bytes of text.
+func tokensForBytes(n int64) int {
+	return int((n + 3) / 4)
+}
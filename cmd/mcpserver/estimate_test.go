@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test priceFor lookup order
+func TestPriceFor(t *testing.T) {
+	resetModelCache(t)
+	modelCache.Set([]modelInfo{{ID: "acme/fast", Provider: "acme", Name: "fast", Cost: &modelCost{Input: 1, Output: 2}}})
+
+	cfg := serverConfig{Pricing: map[string]modelCost{"openai/gpt-5": {Input: 9, Output: 9}}}
+	tests := []struct {
+		model      string
+		wantSource string
+		wantInput  float64
+	}{
+		{"openai/gpt-5", "config", 9},
+		{"acme/fast", "opencode", 1},
+		{"anthropic/claude-sonnet-4", "builtin", 3},
+		{"github-copilot/gpt-4o", "builtin", 0},
+	}
+	for _, tt := range tests {
+		price, source, err := priceFor(cfg, tt.model)
+		if err != nil || source != tt.wantSource || price.Input != tt.wantInput {
+			t.Errorf("priceFor(%q) = %+v, %q, %v", tt.model, price, source, err)
+		}
+	}
+	if _, _, err := priceFor(cfg, "unknown/model"); err == nil {
+		t.Error("expected an error for an unpriced model")
+	}
+}
+
+// Test opencode_estimate
+func TestEstimateTool(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "big.go"), []byte(strings.Repeat("x", 4000)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tools := newToolHandler(serverConfig{DefaultTimeout: time.Second})
+	result, mErr := tools(context.Background(), &toolCall{ID: json.RawMessage("1"), Name: toolEstimate, Arguments: json.RawMessage(
+		`{"message":"` + strings.Repeat("a", 400) + `","files":["big.go","missing.go"],"cwd":"` + dir + `","model":"anthropic/claude-sonnet-4","output_tokens":2000}`)})
+	if mErr != nil {
+		t.Fatalf("unexpected error: %v", mErr)
+	}
+	est := result.StructuredContent.(costEstimate)
+	if est.MessageTokens != 100 || est.FileTokens != 1000 || est.InputTokens != 1100+estimateOverheadTokens {
+		t.Errorf("tokens = %+v", est)
+	}
+	want := (float64(est.InputTokens)*3 + 2000*15) / 1e6
+	if math.Abs(est.Cost-want) > 1e-9 {
+		t.Errorf("cost = %f, want %f", est.Cost, want)
+	}
+	if len(est.Warnings) != 1 || !strings.Contains(est.Warnings[0], "missing.go") {
+		t.Errorf("warnings = %v", est.Warnings)
+	}
+	if !strings.Contains(result.Content[0].Text, "builtin pricing") {
+		t.Errorf("text = %q", result.Content[0].Text)
+	}
+
+	// A tenant's files outside its allowed directories aren't looked at
+	outside := filepath.Join(t.TempDir(), "secret.txt")
+	if err := os.WriteFile(outside, []byte("s3cret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tools = newToolHandler(serverConfig{DefaultTimeout: time.Second, Tenants: map[string]tenantPolicy{"team-a": {AllowedDirs: []string{dir}}}})
+	result, mErr = tools(context.Background(), &toolCall{ID: json.RawMessage("1"), Name: toolEstimate, Tenant: "team-a", Arguments: json.RawMessage(
+		`{"message":"hi","files":["big.go","` + outside + `","/nonexistent/x"],"model":"anthropic/claude-sonnet-4"}`)})
+	if mErr != nil {
+		t.Fatalf("unexpected error: %v", mErr)
+	}
+	est = result.StructuredContent.(costEstimate)
+	if est.FileTokens != 1000 || len(est.Warnings) != 2 {
+		t.Errorf("tenant estimate = %+v", est)
+	}
+	for _, w := range est.Warnings {
+		if !strings.HasSuffix(w, "outside the allowed directories") {
+			t.Errorf("warning %q", w)
+		}
+	}
+	_, mErr = tools(context.Background(), &toolCall{ID: json.RawMessage("1"), Name: toolEstimate, Tenant: "team-a", Arguments: json.RawMessage(
+		`{"message":"hi","cwd":"` + filepath.Dir(outside) + `"}`)})
+	if mErr == nil {
+		t.Error("estimate in a cwd outside the allowed directories")
+	}
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The event bus is the server's changefeed: runs queueing, starting and
+// finishing, sessions created and ended, policy denials and exhausted
+// quotas are published once, as serverEvents, and each consumer
+// subscribes to them: GET /events for integrators, the MCP_AUDIT_LOG file,
+// the MCP_EVENT_WEBHOOKS and the counts in /status. Events are numbered
+// per instance and kept only in memory, the last eventBusBuffer of them
+// for subscribers resuming with Last-Event-ID.
+const (
+	// Runs publish run.queued, run.started and run.finished, named after
+	// their states in notifications/opencode/run.
+	eventSessionCreated = "session.created"
+	eventSessionEnded   = "session.ended"
+	eventPolicyDenied   = "policy.denied"
+	eventQuotaExceeded  = "quota.exceeded"
+
+	// auditGap is the audit log's record of events it missed. It isn't
+	// published: the gap is in the log that would hold it.
+	auditGap = "audit.gap"
+
+	// eventBusBuffer is how many events a slow subscriber may fall behind
+	// before it misses newer ones, and how many are kept for resuming.
+	eventBusBuffer = 256
+
+	webhookTimeout  = 10 * time.Second
+	webhookAttempts = 3
+)
+
+// serverEvent is one entry of the changefeed.
+type serverEvent struct {
+	ID      int64          `json:"id"`
+	Type    string         `json:"type"`
+	Time    time.Time      `json:"time"`
+	Tenant  string         `json:"tenant,omitempty"`
+	Session string         `json:"session,omitempty"`
+	Data    map[string]any `json:"data,omitempty"`
+}
+
+// eventBus fans events out to its subscribers. A nil *eventBus drops them.
+type eventBus struct {
+	mu     sync.Mutex
+	seq    int64
+	recent []serverEvent
+	subs   map[chan serverEvent]string // subscriber name, for logs
+	counts map[string]int64
+	missed map[string]int64 // events dropped, by subscriber name
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subs: map[chan serverEvent]string{}, counts: map[string]int64{}, missed: map[string]int64{}}
+}
+
+// publish numbers an event and hands it to every subscriber. Subscribers
+// that are too far behind miss it rather than hold up the publisher; drops
+// counts what each missed.
+func (b *eventBus) publish(eventType, tenant, session string, data map[string]any) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.seq++
+	ev := serverEvent{ID: b.seq, Type: eventType, Time: localTime(time.Now()), Tenant: tenant, Session: session, Data: data}
+	b.counts[eventType]++
+	b.recent = append(b.recent, ev)
+	if len(b.recent) > eventBusBuffer {
+		b.recent = b.recent[1:]
+	}
+	for ch, name := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+			b.missed[name]++
+			log.Printf("[events] %s is behind, dropped event %d", name, ev.ID)
+		}
+	}
+}
+
+// subscribe starts delivering events to a subscriber named name, beginning
+// with the recent ones after lastID; stop ends it.
+func (b *eventBus) subscribe(name string, lastID int64) (events <-chan serverEvent, stop func()) {
+	ch := make(chan serverEvent, eventBusBuffer)
+	b.mu.Lock()
+	if lastID > 0 {
+		for _, ev := range b.recent {
+			if ev.ID > lastID {
+				ch <- ev
+			}
+		}
+	}
+	b.subs[ch] = name
+	b.mu.Unlock()
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subs, ch)
+		b.mu.Unlock()
+	}
+}
+
+// latest returns the ID of the last event published.
+func (b *eventBus) latest() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.seq
+}
+
+// drops returns how many events each subscriber missed, for /status.
+func (b *eventBus) drops() map[string]int64 {
+	m := map[string]int64{}
+	if b == nil {
+		return m
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for name, n := range b.missed {
+		m[name] = n
+	}
+	return m
+}
+
+// stats returns how many events of each type were published, for /status.
+func (b *eventBus) stats() map[string]int64 {
+	m := map[string]int64{}
+	if b == nil {
+		return m
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for t, n := range b.counts {
+		m[t] = n
+	}
+	return m
+}
+
+// sessionID is the ID of sess, or "" for none.
+func sessionID(sess *session) string {
+	if sess == nil {
+		return ""
+	}
+	return sess.id
+}
+
+// eventMiddleware publishes the calls denied by a tenant policy or quota.
+func eventMiddleware(cfg serverConfig) toolMiddleware {
+	return func(next toolHandler) toolHandler {
+		return func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+			result, mErr := next(ctx, call)
+			if mErr == nil || mErr.Data == nil {
+				return result, mErr
+			}
+			eventType := eventPolicyDenied
+			switch {
+			case mErr.Data.Code == errQuotaExceeded.Code:
+				eventType = eventQuotaExceeded
+			case !strings.HasPrefix(mErr.Data.Code, "OC-3"):
+				return result, mErr
+			}
+			cfg.Events.publish(eventType, call.Tenant, sessionID(call.Session), map[string]any{
+				"tool":    call.Name,
+				"code":    mErr.Data.Code,
+				"message": mErr.Message,
+			})
+			return result, mErr
+		}
+	}
+}
+
+// eventFilter selects events by type patterns (path.Match, e.g. "run.*")
+// and tenant; empty selects all.
+type eventFilter struct {
+	types  []string
+	tenant string
+}
+
+func (f eventFilter) match(ev serverEvent) bool {
+	if f.tenant != "" && ev.Tenant != f.tenant {
+		return false
+	}
+	if len(f.types) == 0 {
+		return true
+	}
+	for _, p := range f.types {
+		if ok, _ := path.Match(p, ev.Type); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// registerEventRoutes adds GET /events, the changefeed as an event stream.
+// ?types=run.*,policy.denied and ?tenant= filter it, and Last-Event-ID
+// resumes it.
+func registerEventRoutes(mux *http.ServeMux, cfg serverConfig, admin func(http.HandlerFunc) http.HandlerFunc) {
+	mux.HandleFunc("GET /events", admin(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+		if cfg.Events == nil {
+			http.Error(w, "no event bus", http.StatusNotFound)
+			return
+		}
+		filter := eventFilter{tenant: r.URL.Query().Get("tenant")}
+		if types := r.URL.Query().Get("types"); types != "" {
+			filter.types = strings.Split(types, ",")
+			for _, p := range filter.types {
+				if _, err := path.Match(p, ""); err != nil {
+					http.Error(w, fmt.Sprintf("invalid type pattern %q", p), http.StatusBadRequest)
+					return
+				}
+			}
+		}
+		lastID, _ := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64)
+		events, stop := cfg.Events.subscribe("GET /events from "+r.RemoteAddr, lastID)
+		defer stop()
+
+		stream := &sseStream{w: w}
+		stream.open()
+		flusher.Flush()
+		keepAlive := time.NewTicker(sessionStreamKeepAlive)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case ev := <-events:
+				if filter.match(ev) {
+					stream.send(sseEvent{ID: strconv.FormatInt(ev.ID, 10), Msg: ev})
+				}
+			case <-keepAlive.C:
+				stream.comment("keep-alive")
+			}
+		}
+	}))
+}
+
+// startAuditLog appends every event to the file at path as a JSON line.
+// Events it fell too far behind to receive are recorded in their place, as
+// an audit.gap line with the range of IDs missed, so the log shows what it
+// lacks.
+func startAuditLog(bus *eventBus, path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("MCP_AUDIT_LOG: %w", err)
+	}
+	last := bus.latest()
+	events, _ := bus.subscribe("audit log", 0)
+	go writeAuditLog(f, events, last)
+	return nil
+}
+
+// writeAuditLog writes events to w as JSON lines until events is closed,
+// with an audit.gap line for the IDs missing after last.
+func writeAuditLog(w io.Writer, events <-chan serverEvent, last int64) {
+	enc := json.NewEncoder(w)
+	for ev := range events {
+		if ev.ID > last+1 {
+			log.Printf("[events] audit log missed events %d-%d", last+1, ev.ID-1)
+			gap := serverEvent{Type: auditGap, Time: localTime(time.Now()), Data: map[string]any{
+				"fromId": last + 1,
+				"toId":   ev.ID - 1,
+				"missed": ev.ID - 1 - last,
+			}}
+			if err := enc.Encode(gap); err != nil {
+				log.Printf("[events] audit log: %v", err)
+			}
+		}
+		last = ev.ID
+		if err := enc.Encode(ev); err != nil {
+			log.Printf("[events] audit log: %v", err)
+		}
+	}
+}
+
+// parseWebhooks parses MCP_EVENT_WEBHOOKS, a comma-separated list of http(s)
+// URLs.
+func parseWebhooks(spec string) ([]string, error) {
+	var urls []string
+	for _, s := range strings.Split(spec, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		u, err := url.Parse(s)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("MCP_EVENT_WEBHOOKS: %q is not an http(s) URL", s)
+		}
+		urls = append(urls, s)
+	}
+	return urls, nil
+}
+
+// webhookHost identifies a webhook in logs without the secrets its path or
+// query often carry.
+func webhookHost(target string) string {
+	u, err := url.Parse(target)
+	if err != nil {
+		return "(invalid URL)"
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// startWebhook POSTs every event to target as JSON, signed with the
+// server's key when it has one, retrying failed deliveries.
+func startWebhook(bus *eventBus, target string, sign *signer) {
+	events, _ := bus.subscribe("webhook "+webhookHost(target), 0)
+	client := &http.Client{Timeout: webhookTimeout}
+	go func() {
+		for ev := range events {
+			body, _ := json.Marshal(ev)
+			var err error
+			for attempt := 1; attempt <= webhookAttempts; attempt++ {
+				if err = deliverWebhook(client, target, ev, body, sign); err == nil {
+					break
+				}
+				time.Sleep(time.Duration(attempt) * time.Second)
+			}
+			if err != nil {
+				log.Printf("[events] webhook %s: event %d not delivered: %v", webhookHost(target), ev.ID, err)
+			}
+		}
+	}()
+}
+
+func deliverWebhook(client *http.Client, target string, ev serverEvent, body []byte, sign *signer) error {
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Opencode-Event", ev.Type)
+	req.Header.Set("X-Opencode-Event-Id", strconv.FormatInt(ev.ID, 10))
+	if att := sign.sign(body); att != nil {
+		req.Header.Set("X-Opencode-Signature", att.Signature)
+		req.Header.Set("X-Opencode-Key-Id", att.KeyID)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
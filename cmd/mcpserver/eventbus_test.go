@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// nextEvent returns the next event on events, failing after a second.
+func nextEvent(t *testing.T, events <-chan serverEvent) serverEvent {
+	t.Helper()
+	select {
+	case ev := <-events:
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("no event")
+		return serverEvent{}
+	}
+}
+
+func TestEventBus(t *testing.T) {
+	bus := newEventBus()
+	bus.publish(eventSessionCreated, "", "s1", nil)
+	bus.publish(eventPolicyDenied, "team-a", "", map[string]any{"code": "OC-3001"})
+
+	events, stop := bus.subscribe("test", 1)
+	if ev := nextEvent(t, events); ev.ID != 2 || ev.Type != eventPolicyDenied || ev.Tenant != "team-a" {
+		t.Errorf("replayed %+v", ev)
+	}
+	bus.publish(eventSessionEnded, "", "s1", nil)
+	if ev := nextEvent(t, events); ev.ID != 3 {
+		t.Errorf("live %+v", ev)
+	}
+	stop()
+	bus.publish(eventSessionCreated, "", "s2", nil)
+	if len(events) != 0 {
+		t.Error("delivered after stop")
+	}
+	if got := bus.stats(); got[eventSessionCreated] != 2 || got[eventPolicyDenied] != 1 {
+		t.Errorf("stats = %v", got)
+	}
+
+	f := eventFilter{types: []string{"session.*"}, tenant: "team-a"}
+	if f.match(serverEvent{Type: eventSessionCreated}) || !f.match(serverEvent{Type: eventSessionCreated, Tenant: "team-a"}) || f.match(serverEvent{Type: eventPolicyDenied, Tenant: "team-a"}) {
+		t.Error("filter")
+	}
+}
+
+// Test a subscriber that falls behind is counted, and the audit log
+// records the events it missed as a gap
+func TestEventGaps(t *testing.T) {
+	bus := newEventBus()
+	_, stop := bus.subscribe("slow", 0)
+	defer stop()
+	for i := 0; i < eventBusBuffer+10; i++ {
+		bus.publish(eventSessionCreated, "", "s", nil)
+	}
+	if got := bus.drops(); got["slow"] != 10 {
+		t.Errorf("drops = %v, want 10 for slow", got)
+	}
+
+	events := make(chan serverEvent, 3)
+	events <- serverEvent{ID: 5, Type: eventSessionCreated}
+	events <- serverEvent{ID: 6, Type: eventSessionEnded}
+	events <- serverEvent{ID: 9, Type: eventSessionCreated}
+	close(events)
+	var buf strings.Builder
+	writeAuditLog(&buf, events, 4)
+	var lines []serverEvent
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var ev serverEvent
+		if err := json.Unmarshal([]byte(line), &ev); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, ev)
+	}
+	if len(lines) != 4 || lines[2].Type != auditGap || lines[2].Data["fromId"] != 7.0 || lines[2].Data["toId"] != 8.0 || lines[2].Data["missed"] != 2.0 || lines[3].ID != 9 {
+		t.Errorf("audit log = %s", buf.String())
+	}
+}
+
+// Test runs, sessions and policy denials are published
+func TestServerEvents(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell script")
+	}
+	script := filepath.Join(t.TempDir(), "opencode")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\n[ \"$1\" = models ] && exit 0\necho hi\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	st, _ := openStore("")
+	cfg := serverConfig{
+		Target:         script,
+		DefaultTimeout: 5 * time.Second,
+		Store:          st,
+		Events:         newEventBus(),
+		Tenants:        map[string]tenantPolicy{"team-a": {AllowedDirs: []string{t.TempDir()}}},
+	}
+	events, stop := cfg.Events.subscribe("test", 0)
+	defer stop()
+
+	sessions := &sessionStore{sessions: map[string]*session{}, events: cfg.Events}
+	sess := sessions.create("cursor", clientCapabilities{})
+	if ev := nextEvent(t, events); ev.Type != eventSessionCreated || ev.Session != sess.id || ev.Data["client"] != "cursor" {
+		t.Errorf("created %+v", ev)
+	}
+
+	tools := newToolHandler(cfg)
+	if _, mErr := tools(context.Background(), &toolCall{ID: json.RawMessage("1"), Name: toolRun, Arguments: json.RawMessage(`{"message":"hi","model":"m"}`)}); mErr != nil {
+		t.Fatal(mErr)
+	}
+	started := nextEvent(t, events)
+	finished := nextEvent(t, events)
+	if started.Type != "run.started" || finished.Type != "run.finished" || finished.Data["status"] != "ok" || finished.Data["runId"] != started.Data["runId"] {
+		t.Errorf("run events %+v, %+v", started, finished)
+	}
+
+	if _, mErr := tools(context.Background(), &toolCall{ID: json.RawMessage("2"), Name: toolRun, Tenant: "team-a", Session: sess, Arguments: json.RawMessage(`{"message":"hi","cwd":"/"}`)}); mErr == nil {
+		t.Fatal("run outside allowedDirs succeeded")
+	}
+	ev := nextEvent(t, events)
+	for strings.HasPrefix(ev.Type, "run.") { // the directory is checked once the run started
+		ev = nextEvent(t, events)
+	}
+	if ev.Type != eventPolicyDenied || ev.Tenant != "team-a" || ev.Session != sess.id || ev.Data["code"] != errPolicyDenied.Code {
+		t.Errorf("denied %+v", ev)
+	}
+
+	sessions.remove(sess.id)
+	if ev := nextEvent(t, events); ev.Type != eventSessionEnded || ev.Data["reason"] != "deleted" {
+		t.Errorf("ended %+v", ev)
+	}
+}
+
+// Test the audit log, webhooks and GET /events receive the events
+func TestEventSinks(t *testing.T) {
+	bus := newEventBus()
+
+	audit := filepath.Join(t.TempDir(), "audit.jsonl")
+	if err := startAuditLog(bus, audit); err != nil {
+		t.Fatal(err)
+	}
+	delivered := make(chan *http.Request, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev serverEvent
+		if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+			t.Errorf("webhook body: %v", err)
+		}
+		if ev.Type == eventQuotaExceeded {
+			delivered <- r
+		}
+	}))
+	defer hook.Close()
+	if _, err := parseWebhooks("ftp://x"); err == nil {
+		t.Error("ftp webhook accepted")
+	}
+	_, key, _ := ed25519.GenerateKey(nil)
+	startWebhook(bus, hook.URL+"/hook", newSigner(key))
+
+	mux := http.NewServeMux()
+	registerAdminRoutes(mux, serverConfig{Binaries: newBinarySwitch("opencode", ""), Events: bus}, "secret")
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/events?types=quota.*", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /events: %s", resp.Status)
+	}
+
+	bus.publish(eventSessionCreated, "", "s", nil)
+	bus.publish(eventQuotaExceeded, "team-a", "", map[string]any{"tool": toolRun})
+
+	lines := bufio.NewScanner(resp.Body)
+	var got []string
+	for len(got) < 2 && lines.Scan() {
+		if line := lines.Text(); strings.HasPrefix(line, "id: ") || strings.HasPrefix(line, "data: ") {
+			got = append(got, line)
+		}
+	}
+	if len(got) != 2 || got[0] != "id: 2" || !strings.Contains(got[1], `"type":"quota.exceeded"`) {
+		t.Errorf("stream = %q", got)
+	}
+
+	select {
+	case r := <-delivered:
+		if r.Header.Get("X-Opencode-Event") != eventQuotaExceeded || r.Header.Get("X-Opencode-Signature") == "" {
+			t.Errorf("webhook headers %v", r.Header)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not called")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		data, _ := os.ReadFile(audit)
+		if strings.Count(string(data), "\n") == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("audit log = %s", data)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"opencode-mcp/internal/mcp"
+	"opencode-mcp/internal/runner"
+)
+
+// eventCollector turns opencode output into client notifications and
+// accumulates the final tool result. It is shared by all run backends.
+type eventCollector struct {
+	call            *toolCall
+	text            strings.Builder
+	lines           []string // raw output lines, for structured parsing
+	toolOutputs     []runToolOutput
+	sessionID       string // first session ID seen in the event stream
+	usage           runUsage
+	transcript      []transcriptEntry
+	files           runner.RunFiles // files written and images attached by tools
+	eventCount      int
+	eventTypeCounts map[string]int
+}
+
+func newEventCollector(call *toolCall) *eventCollector {
+	return &eventCollector{call: call, eventTypeCounts: make(map[string]int)}
+}
+
+// event handles one opencode --format json event.
+func (ec *eventCollector) event(event map[string]any) {
+	eventType, _ := event["type"].(string)
+	eventData := runner.EventData(event)
+	ec.eventTypeCounts[eventType]++
+	ec.eventCount++
+	ec.files.Add(event)
+	if ec.sessionID == "" {
+		ec.sessionID = eventSessionID(event)
+	}
+	if entry, ok := transcriptEntryFrom(eventType, eventData); ok {
+		entry.Time = localTime(time.Now())
+		ec.transcript = append(ec.transcript, entry)
+		if ec.call.appended != nil {
+			ec.call.appended(ec.sessionID, entry)
+		}
+	}
+
+	// Log every event with step details for observability
+	switch eventType {
+	case "text":
+		if text, ok := eventData.(string); ok {
+			log.Printf("[stream] event#%d type=text len=%d", ec.eventCount, len(text))
+			log.Printf("[stream]   content: %s", truncateForLog(text, 300))
+		}
+	case "tool_use":
+		if m, ok := eventData.(map[string]any); ok {
+			toolName, _ := m["tool"].(string)
+			status, _ := m["status"].(string)
+			inputPreview := ""
+			if input, ok := m["input"].(map[string]any); ok {
+				inputJSON, _ := json.Marshal(input)
+				inputPreview = truncateForLog(string(inputJSON), 200)
+			}
+			outputPreview := ""
+			switch out := m["output"].(type) {
+			case string:
+				outputPreview = truncateForLog(out, 300)
+			default:
+				if out != nil {
+					b, _ := json.Marshal(out)
+					outputPreview = truncateForLog(string(b), 300)
+				}
+			}
+			log.Printf("[stream] event#%d type=tool_use tool=%s status=%s", ec.eventCount, toolName, status)
+			if inputPreview != "" {
+				log.Printf("[stream]   input:  %s", inputPreview)
+			}
+			if outputPreview != "" {
+				log.Printf("[stream]   output: %s", outputPreview)
+			}
+		}
+	case "step_start":
+		if part, ok := event["part"].(map[string]any); ok {
+			reason, _ := part["reason"].(string)
+			snapshot, _ := part["snapshot"].(string)
+			partType, _ := part["type"].(string)
+			log.Printf("[stream] event#%d type=step_start reason=%q partType=%s snapshot=%s",
+				ec.eventCount, reason, partType, truncateForLog(snapshot, 12))
+		} else {
+			log.Printf("[stream] event#%d type=step_start", ec.eventCount)
+		}
+	case "step_finish":
+		ec.call.estimate.step()
+		if part, ok := event["part"].(map[string]any); ok {
+			reason, _ := part["reason"].(string)
+			snapshot, _ := part["snapshot"].(string)
+			cost, _ := part["cost"].(float64)
+			tokens, _ := part["tokens"].(map[string]any)
+			log.Printf("[stream] event#%d type=step_finish reason=%q cost=$%.4f", ec.eventCount, reason, cost)
+			ec.usage.Cost += cost
+			if tokens != nil {
+				in, _ := tokens["input"].(float64)
+				out, _ := tokens["output"].(float64)
+				ec.usage.InputTokens += int(in)
+				ec.usage.OutputTokens += int(out)
+				log.Printf("[stream]   tokens: input=%.0f output=%.0f snapshot=%s", in, out, truncateForLog(snapshot, 12))
+			}
+		} else {
+			log.Printf("[stream] event#%d type=step_finish", ec.eventCount)
+		}
+	default:
+		log.Printf("[stream] event#%d type=%s", ec.eventCount, eventType)
+	}
+
+	// Progress and message notifications follow the client's routes
+	route, routed := ec.call.Routes.route(eventType)
+	progressed := false
+	progress := func(message string) {
+		if !routed || route.Channel == channelProgress {
+			ec.call.progress(ec.eventCount, message)
+			progressed = true
+		}
+	}
+
+	// Collect text and tool outputs for final response
+	if eventType == "text" {
+		if text, ok := eventData.(string); ok {
+			ec.text.WriteString(text)
+			// Send progress with accumulated text for real-time display
+			progress(ec.text.String())
+		}
+	} else if eventType == "tool_use" {
+		if m, ok := eventData.(map[string]any); ok {
+			toolName, _ := m["tool"].(string)
+			status, _ := m["status"].(string)
+			if status == "completed" {
+				if toolName != "" {
+					if output, ok := m["output"].(string); ok && output != "" {
+						ec.toolOutputs = append(ec.toolOutputs, runToolOutput{Tool: toolName, Output: output})
+					}
+				}
+				// Progress: tool completed (user sees activity)
+				progress(ec.call.sprintf("Tool %s completed", toolName))
+			}
+		}
+	} else if eventType == "step_start" || eventType == "step_finish" {
+		// Progress: step update (user sees activity)
+		if m, ok := eventData.(map[string]any); ok {
+			reason, _ := m["reason"].(string)
+			if reason != "" {
+				progress(ec.call.sprintf(eventType+": %s", reason))
+			} else {
+				progress(ec.call.sprintf(eventType))
+			}
+		}
+	}
+
+	if routed && route.Channel == channelProgress && !progressed {
+		summary := ec.call.sprintf(eventType)
+		if text, ok := eventData.(string); ok {
+			summary = text
+		}
+		progress(summary)
+	}
+	if routed && route.Channel != channelMessage {
+		return
+	}
+
+	// Stream event to client
+	params := map[string]any{
+		"type": eventType,
+		"data": eventData,
+	}
+	if routed {
+		params["level"] = route.Level
+		params["logger"] = "opencode"
+	}
+	ec.call.Notify(map[string]any{
+		"jsonrpc": "2.0",
+		"method":  "notifications/message",
+		"params":  params,
+	})
+}
+
+// rawLine handles a line of non-JSON output (models, session list, exec, or
+// non-JSON opencode_run output).
+func (ec *eventCollector) rawLine(line string) {
+	ec.eventCount++
+	log.Printf("[stream] raw#%d len=%d preview=%s", ec.eventCount, len(line), truncateForLog(line, 150))
+	ec.text.WriteString(line)
+	ec.text.WriteString("\n")
+	ec.lines = append(ec.lines, line)
+	if _, binary := sniffBinary([]byte(line), true); binary {
+		return // the result carries it; as a JSON string it would be mangled
+	}
+	notification := map[string]any{
+		"jsonrpc": "2.0",
+		"method":  "notifications/progress",
+		"params": map[string]any{
+			"data": line,
+		},
+	}
+	ec.call.Notify(notification)
+}
+
+// result builds the final tool result from everything collected so far.
+func (ec *eventCollector) result(stderr string, exitCode int) *toolCallResult {
+	// Build final result: text + tool outputs (for completeness)
+	resultText := ec.text.String()
+	if len(ec.toolOutputs) > 0 {
+		if resultText != "" {
+			resultText += "\n\n--- Tool Outputs ---\n"
+		} else {
+			resultText = "--- Tool Outputs ---\n"
+		}
+		outputs := make([]string, 0, len(ec.toolOutputs))
+		for _, o := range ec.toolOutputs {
+			outputs = append(outputs, fmt.Sprintf("[Tool: %s]\n%s", o.Tool, o.Output))
+		}
+		resultText += strings.Join(outputs, "\n\n")
+	}
+	if stderr != "" {
+		if resultText != "" {
+			resultText += "\n\n"
+		}
+		resultText += "[stderr]\n" + stderr
+	}
+	if exitCode != 0 {
+		resultText += fmt.Sprintf("\n[exit code: %d]", exitCode)
+	}
+
+	// Log completion summary
+	if len(ec.eventTypeCounts) > 0 {
+		counts := make([]string, 0, len(ec.eventTypeCounts))
+		for k, v := range ec.eventTypeCounts {
+			counts = append(counts, fmt.Sprintf("%s=%d", k, v))
+		}
+		ec.call.logf("info", "[tools/call] done tool=%s events=%d counts=%v resultLen=%d exitCode=%d stderrLen=%d",
+			ec.call.Name, ec.eventCount, counts, len(resultText), exitCode, len(stderr))
+	} else {
+		ec.call.logf("info", "[tools/call] done tool=%s lines=%d resultLen=%d exitCode=%d stderrLen=%d",
+			ec.call.Name, ec.eventCount, len(resultText), exitCode, len(stderr))
+	}
+	if stderr != "" {
+		ec.call.logf("warning", "[tools/call] stderr of tool=%s: %s", ec.call.Name, truncateForLog(stderr, 1000))
+	}
+	ec.call.logf("debug", "[tools/call] result preview: %s", truncateForLog(resultText, 200))
+
+	return &toolCallResult{
+		Content:   append([]toolContent{{Type: "text", Text: resultText}}, ec.fileContent()...),
+		IsError:   exitCode != 0,
+		sessionID: ec.sessionID,
+		answer:    ec.text.String(),
+		usage:     ec.usage,
+		exitCode:  exitCode,
+
+		toolOutputs: ec.toolOutputs,
+		transcript:  ec.transcript,
+	}
+}
+
+// fileContent returns the images the run's tools attached as image content
+// and the files they wrote as resource links, rather than paths in the text.
+// Images over mcp.MaxImageBytes are left out.
+func (ec *eventCollector) fileContent() []toolContent {
+	var content []toolContent
+	for _, img := range ec.files.Images {
+		if img.Size > mcp.MaxImageBytes {
+			ec.call.logf("warning", "[tools/call] %s image of %d bytes left out of the result", img.MimeType, img.Size)
+			continue
+		}
+		content = append(content, mcp.ImageContent(img.MimeType, img.Data))
+	}
+	for _, p := range ec.files.Paths {
+		if link, ok := mcp.FileLink(p); ok {
+			content = append(content, link)
+		}
+	}
+	return content
+}
+
+// runUsage totals the cost and tokens reported by step_finish events.
+type runUsage struct {
+	Cost         float64 `json:"cost"`
+	InputTokens  int     `json:"inputTokens"`
+	OutputTokens int     `json:"outputTokens"`
+}
+
+// eventSessionID returns the session ID carried by an event or its part.
+func eventSessionID(event map[string]any) string {
+	if id, ok := event["sessionID"].(string); ok {
+		return id
+	}
+	part, _ := event["part"].(map[string]any)
+	id, _ := part["sessionID"].(string)
+	return id
+}
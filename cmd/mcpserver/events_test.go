@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// Test files the run wrote come back as resource links and images its
+// tools attached as image content; older clients get the links as text
+func TestRunFileContent(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell script")
+	}
+	script := filepath.Join(t.TempDir(), "opencode")
+	body := "#!/bin/sh\n" +
+		`echo '{"type":"tool_use","part":{"tool":"write","state":{"status":"completed","input":{"filePath":"/work/plot.py"}}}}'` + "\n" +
+		`echo '{"type":"tool_use","part":{"tool":"read","state":{"status":"completed","input":{"filePath":"/work/plot.png"},"attachments":[{"url":"data:image/png;base64,cG5n"}]}}}'` + "\n" +
+		`echo '{"type":"text","part":{"text":"plotted"}}'` + "\n"
+	if err := os.WriteFile(script, []byte(body), 0755); err != nil {
+		t.Fatal(err)
+	}
+	cfg := serverConfig{Target: script, DefaultTimeout: 5 * time.Second}
+	result, mErr := newToolHandler(cfg)(context.Background(), &toolCall{ID: json.RawMessage("1"), Name: toolRun, Arguments: json.RawMessage(`{"message":"plot it"}`)})
+	if mErr != nil {
+		t.Fatal(mErr)
+	}
+	if len(result.Content) != 3 {
+		t.Fatalf("content = %+v", result.Content)
+	}
+	if image := result.Content[1]; image.Type != "image" || image.MimeType != "image/png" || image.Data != "cG5n" {
+		t.Errorf("image = %+v", image)
+	}
+	if link := result.Content[2]; link.Type != "resource_link" || link.URI != "file:///work/plot.py" || link.Name != "plot.py" {
+		t.Errorf("link = %+v", link)
+	}
+
+	old := result.forClient(clientCapabilities{ProtocolVersion: "2025-03-26"})
+	if link := old.Content[2]; link.Type != "text" || link.Text != "plot.py: file:///work/plot.py" {
+		t.Errorf("2025-03-26 link = %+v", link)
+	}
+	if result.Content[2].Type != "resource_link" {
+		t.Error("result modified for an old client")
+	}
+}
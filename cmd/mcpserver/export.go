@@ -0,0 +1,284 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// exportCollections are the store collections carried by export/import.
+// A feature that keeps state in a new collection adds it here.
+var exportCollections = []string{
+	sessionsCollection,
+	promptsCollection,
+	projectsCollection,
+	runsCollection,
+	transcriptsCollection,
+	preferencesCollection,
+	analyticsCollection,
+	analyticsKeyCollection,
+	toolTogglesCollection,
+	progressStatsCollection,
+	apiKeysCollection,
+}
+
+const exportFormat = 1
+
+// exportManifest is manifest.json, the first entry of an export archive.
+type exportManifest struct {
+	Format      int            `json:"format"`
+	ExportedAt  time.Time      `json:"exportedAt"`
+	Collections map[string]int `json:"collections"` // documents per collection
+}
+
+// exportDoc is one line of collections/<name>.jsonl.
+type exportDoc struct {
+	Key string          `json:"key"`
+	Doc json.RawMessage `json:"doc"`
+}
+
+// runExport implements `export`: it writes the store's collections (see
+// exportCollections) to a zstd-compressed tar archive, for backups and for moving
+// between store backends with `import`.
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	out := fs.String("out", "", "archive to write (state.tar.zst), - for stdout")
+	storeURL := fs.String("store", "", "store URL to export (default MCP_STORE_URL or MCP_STORE_PATH)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *out == "" {
+		return errors.New("-out is required")
+	}
+	st, err := openStoreFlag(*storeURL)
+	if err != nil {
+		return err
+	}
+	if *out == "-" {
+		_, err := exportStore(os.Stdout, st, time.Now())
+		return err
+	}
+	// The archive holds API key digests and transcripts: only the owner
+	// may read it, and an existing file is never overwritten.
+	f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	m, err := exportStore(f, st, time.Now())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(*out)
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "exported %s to %s\n", describeCounts(m.Collections), *out)
+	return nil
+}
+
+// runImport implements `import`: it writes every document of an export
+// archive into the store, replacing documents with the same key.
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	in := fs.String("in", "", "archive to read (state.tar.zst or .tar.gz), - for stdin")
+	storeURL := fs.String("store", "", "store URL to import into (default MCP_STORE_URL or MCP_STORE_PATH)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *in == "" {
+		return errors.New("-in is required")
+	}
+	st, err := openStoreFlag(*storeURL)
+	if err != nil {
+		return err
+	}
+	r := io.Reader(os.Stdin)
+	if *in != "-" {
+		f, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	counts, err := importStore(r, st)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "imported %s\n", describeCounts(counts))
+	return nil
+}
+
+func openStoreFlag(rawURL string) (*store, error) {
+	if rawURL != "" {
+		return openStoreURL(rawURL)
+	}
+	return openStoreFromEnv()
+}
+
+// exportStore writes the archive: manifest.json, then one
+// collections/<name>.jsonl per collection.
+func exportStore(w io.Writer, st *store, now time.Time) (exportManifest, error) {
+	m := exportManifest{Format: exportFormat, ExportedAt: now.UTC(), Collections: map[string]int{}}
+	files := map[string][]byte{}
+	for _, name := range exportCollections {
+		docs, err := st.driver.scan(name, "")
+		if err != nil {
+			return m, fmt.Errorf("export %s: %w", name, err)
+		}
+		var b strings.Builder
+		enc := json.NewEncoder(&b)
+		for _, key := range sortedKeys(docs) {
+			if err := enc.Encode(exportDoc{key, docs[key]}); err != nil {
+				return m, err
+			}
+		}
+		files[name] = []byte(b.String())
+		m.Collections[name] = len(docs)
+	}
+
+	zw, err := zstd.NewWriter(w)
+	if err != nil {
+		return m, err
+	}
+	tw := tar.NewWriter(zw)
+	manifest, _ := json.MarshalIndent(m, "", "  ")
+	if err := writeTarFile(tw, "manifest.json", manifest, now); err != nil {
+		return m, err
+	}
+	for _, name := range exportCollections {
+		if err := writeTarFile(tw, "collections/"+name+".jsonl", files[name], now); err != nil {
+			return m, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return m, err
+	}
+	return m, zw.Close()
+}
+
+func writeTarFile(tw *tar.Writer, name string, b []byte, mtime time.Time) error {
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(b)), ModTime: mtime}); err != nil {
+		return err
+	}
+	_, err := tw.Write(b)
+	return err
+}
+
+// batchDriver is a store driver that writes many documents at once more
+// cheaply than one put each.
+type batchDriver interface {
+	putMany(collection string, docs map[string]json.RawMessage) error
+}
+
+// importStore reads an archive written by exportStore into st and returns
+// the documents imported per collection. Collections this version doesn't
+// know are skipped. Archives of earlier versions, which were gzipped, are
+// still read.
+func importStore(r io.Reader, st *store) (map[string]int, error) {
+	zr, err := decompressArchive(r)
+	if err != nil {
+		return nil, fmt.Errorf("not an export archive: %w", err)
+	}
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+	batch, _ := st.driver.(batchDriver)
+	known := map[string]bool{}
+	for _, name := range exportCollections {
+		known[name] = true
+	}
+	counts := map[string]int{}
+	sawManifest := false
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return counts, err
+		}
+		if h.Name == "manifest.json" {
+			var m exportManifest
+			if err := json.NewDecoder(tr).Decode(&m); err != nil {
+				return counts, fmt.Errorf("manifest.json: %w", err)
+			}
+			if m.Format > exportFormat {
+				return counts, fmt.Errorf("archive format %d is newer than this server supports (%d)", m.Format, exportFormat)
+			}
+			sawManifest = true
+			continue
+		}
+		name, ok := strings.CutSuffix(path.Base(h.Name), ".jsonl")
+		if !ok || path.Dir(h.Name) != "collections" || !known[name] {
+			continue
+		}
+		if !sawManifest {
+			return counts, errors.New("archive has no manifest.json before its collections")
+		}
+		docs := map[string]json.RawMessage{}
+		sc := bufio.NewScanner(tr)
+		sc.Buffer(nil, 64<<20) // transcripts can be large
+		for sc.Scan() {
+			var d exportDoc
+			if err := json.Unmarshal(sc.Bytes(), &d); err != nil {
+				return counts, fmt.Errorf("%s: %w", h.Name, err)
+			}
+			if batch != nil {
+				docs[d.Key] = d.Doc
+				continue
+			}
+			if err := st.driver.put(name, d.Key, d.Doc); err != nil {
+				return counts, fmt.Errorf("import %s/%s: %w", name, d.Key, err)
+			}
+			counts[name]++
+		}
+		if err := sc.Err(); err != nil {
+			return counts, fmt.Errorf("%s: %w", h.Name, err)
+		}
+		if len(docs) > 0 {
+			if err := batch.putMany(name, docs); err != nil {
+				return counts, fmt.Errorf("import %s: %w", name, err)
+			}
+			counts[name] += len(docs)
+		}
+	}
+	if !sawManifest {
+		return counts, errors.New("archive has no manifest.json")
+	}
+	return counts, nil
+}
+
+// decompressArchive returns the tar stream of r, which is zstd- or
+// gzip-compressed.
+func decompressArchive(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(4)
+	if bytes.Equal(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}) {
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	}
+	return gzip.NewReader(br)
+}
+
+func describeCounts(counts map[string]int) string {
+	parts := make([]string, 0, len(exportCollections))
+	for _, name := range exportCollections {
+		parts = append(parts, fmt.Sprintf("%d %s", counts[name], name))
+	}
+	return strings.Join(parts, ", ")
+}
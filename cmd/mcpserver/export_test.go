@@ -0,0 +1,101 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test export/import moves every collection between stores
+func TestExportImport(t *testing.T) {
+	dir := t.TempDir()
+	src, _ := openStore(filepath.Join(dir, "src.json"))
+	_ = src.put(sessionsCollection, "s1", storedSession{ID: "s1"})
+	_ = src.put(promptsCollection, "default/review", map[string]string{"name": "review"})
+	_ = src.put(runsCollection, "default/r1", runRecord{ID: "r1"})
+	_ = src.put(runsCollection, "team/r2", runRecord{ID: "r2"})
+	_ = src.put(transcriptsCollection, "default/r1", []string{strings.Repeat("x", 100000)})
+	_ = src.put(preferencesCollection, "default/alice", map[string]string{"model": "m"})
+	_ = src.put(analyticsCollection, "default/r1", map[string]int{"words": 3})
+	_ = src.put(analyticsKeyCollection, "noise", map[string]string{"key": "00"})
+	_ = src.put(toolTogglesCollection, "default", map[string]bool{"opencode_run": false})
+	_ = src.put(progressStatsCollection, "k", map[string]int{"runs": 5})
+	_ = src.put(apiKeysCollection, apiKeyDigest("ocm_x"), apiKey{Tenant: "team", Digest: apiKeyDigest("ocm_x")})
+	_ = src.put("scratch", "k", 1) // not exported
+
+	archive := filepath.Join(dir, "state.tar.zst")
+	if err := runExport([]string{"-store", "file://" + filepath.Join(dir, "src.json"), "-out", archive}); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(archive); !bytes.HasPrefix(b, []byte{0x28, 0xb5, 0x2f, 0xfd}) {
+		t.Errorf("archive isn't zstd-compressed: % x", b[:min(len(b), 4)])
+	}
+	if fi, err := os.Stat(archive); err != nil {
+		t.Fatal(err)
+	} else if fi.Mode().Perm() != 0o600 {
+		t.Errorf("archive mode = %v, want 0600", fi.Mode().Perm())
+	}
+	if err := runExport([]string{"-store", "file://" + filepath.Join(dir, "src.json"), "-out", archive}); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("export over an existing archive: err = %v", err)
+	}
+	failed := filepath.Join(dir, "failed.tar.zst")
+	if err := runExport([]string{"-store", "redis://127.0.0.1:1", "-out", failed}); err == nil {
+		t.Error("export from an unreachable store succeeded")
+	}
+	if _, err := os.Stat(failed); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("failed export left %s behind: %v", failed, err)
+	}
+	dst := filepath.Join(dir, "dst.json")
+	if err := runImport([]string{"-store", "file://" + dst, "-in", archive}); err != nil {
+		t.Fatal(err)
+	}
+	st, _ := openStore(dst)
+	for collection, want := range map[string]int{
+		sessionsCollection: 1, promptsCollection: 1, runsCollection: 2, transcriptsCollection: 1,
+		preferencesCollection: 1, analyticsCollection: 1, analyticsKeyCollection: 1, toolTogglesCollection: 1, progressStatsCollection: 1, apiKeysCollection: 1,
+		"scratch": 0,
+	} {
+		if got := len(st.list(collection, "")); got != want {
+			t.Errorf("%s: %d documents, want %d", collection, got, want)
+		}
+	}
+	if tenant, ok := (serverConfig{Store: st}).tenantForKey("ocm_x"); !ok || tenant != "team" {
+		t.Errorf("imported API key: tenant = %q, %v", tenant, ok)
+	}
+	var rec runRecord
+	if ok, _ := st.get(runsCollection, "team/r2", &rec); !ok || rec.ID != "r2" {
+		t.Errorf("run team/r2 = %+v, %v", rec, ok)
+	}
+
+	// Gzipped archives of earlier versions are still read
+	gzipped := func(files ...string) *bytes.Buffer {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(zw)
+		for i := 0; i < len(files); i += 2 {
+			_ = writeTarFile(tw, files[i], []byte(files[i+1]), time.Now())
+		}
+		tw.Close()
+		zw.Close()
+		return &buf
+	}
+	old := gzipped("manifest.json", `{"format":1}`, "collections/prompts.jsonl", `{"key":"default/old","doc":{"name":"old"}}`+"\n")
+	if counts, err := importStore(old, st); err != nil || counts[promptsCollection] != 1 {
+		t.Errorf("gzipped archive: counts = %v, err = %v", counts, err)
+	}
+
+	// Archives from newer versions and non-archives are refused
+	if _, err := importStore(gzipped("manifest.json", `{"format":99}`), st); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Errorf("newer format: err = %v", err)
+	}
+	if _, err := importStore(strings.NewReader("{}"), st); err == nil {
+		t.Error("imported a non-archive")
+	}
+}
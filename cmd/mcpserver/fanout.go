@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+const maxFanoutShards = 16
+
+// fanoutArgs are the arguments of opencode_fanout.
+type fanoutArgs struct {
+	Message         string        `json:"message"`
+	Shards          []fanoutShard `json:"shards"`
+	Cwd             string        `json:"cwd"`
+	Model           string        `json:"model"`
+	Synthesize      bool          `json:"synthesize"`
+	SynthesisPrompt string        `json:"synthesis_prompt"`
+	Labels          []string      `json:"labels"`
+}
+
+// fanoutShard is one slice of the task, e.g. a module or a group of files.
+type fanoutShard struct {
+	Label   string   `json:"label"`
+	Message string   `json:"message"`
+	Files   []string `json:"files"`
+	Cwd     string   `json:"cwd"`
+}
+
+type fanoutShardResult struct {
+	Label   string `json:"label"`
+	IsError bool   `json:"isError,omitempty"`
+	Text    string `json:"text"`
+}
+
+var fanoutSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"message": map[string]any{
+			"type":        "string",
+			"description": "Task shared by every shard",
+		},
+		"shards": map[string]any{
+			"type":        "array",
+			"minItems":    1,
+			"maxItems":    maxFanoutShards,
+			"description": "Slices of the task, each run as a separate opencode_run",
+			"items": map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"label":   map[string]any{"type": "string", "description": "Name shown in the results (defaults to shard-N)"},
+					"message": map[string]any{"type": "string", "description": "Shard-specific instructions appended to the task"},
+					"files":   map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "description": "Files to attach for this shard"},
+					"cwd":     map[string]any{"type": "string", "description": "Working directory for this shard (defaults to cwd)"},
+				},
+			},
+		},
+		"cwd": map[string]any{
+			"type":        "string",
+			"description": "Working directory for all shards",
+		},
+		"model": map[string]any{
+			"type":        "string",
+			"description": "Model to use for every shard",
+		},
+		"synthesize": map[string]any{
+			"type":        "boolean",
+			"description": "Run a final pass that combines the shard results",
+		},
+		"synthesis_prompt": map[string]any{
+			"type":        "string",
+			"description": "Instructions for the synthesis pass",
+		},
+		"labels": map[string]any{
+			"type":        "array",
+			"items":       map[string]any{"type": "string"},
+			"description": "Labels recorded with every run in the run history",
+		},
+	},
+	"required": []string{"message", "shards"},
+}
+
+// fanoutTool splits a task across parallel opencode_run invocations. Shards
+// go through the run limiter like any other run, so at most
+// MCP_MAX_CONCURRENT_RUNS execute at once.
+func fanoutTool(ctx context.Context, cfg serverConfig, call *toolCall) (*toolCallResult, *mcpError) {
+	var args fanoutArgs
+	if err := json.Unmarshal(call.Arguments, &args); err != nil {
+		return nil, errInvalidArguments.err("invalid arguments")
+	}
+	if args.Message == "" {
+		return nil, errInvalidArguments.err("missing message")
+	}
+	if len(args.Shards) == 0 {
+		return nil, errInvalidArguments.err("missing shards")
+	}
+	if len(args.Shards) > maxFanoutShards {
+		return nil, errInvalidArguments.err(fmt.Sprintf("too many shards (max %d)", maxFanoutShards))
+	}
+	log.Printf("[fanout] shards=%d model=%s synthesize=%t message=%s", len(args.Shards), args.Model, args.Synthesize, truncateForLog(args.Message, 80))
+
+	run := runHandler(cfg)
+	results := make([]fanoutShardResult, len(args.Shards))
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	done := 0
+	for i, shard := range args.Shards {
+		label := shard.Label
+		if label == "" {
+			label = fmt.Sprintf("shard-%d", i+1)
+		}
+		message := args.Message
+		if shard.Message != "" {
+			message += "\n\n" + shard.Message
+		}
+		cwd := shard.Cwd
+		if cwd == "" {
+			cwd = args.Cwd
+		}
+		shardArgs, _ := json.Marshal(runToolArgs{Message: message, Cwd: cwd, Model: args.Model, Files: shard.Files, Labels: args.Labels})
+
+		wg.Add(1)
+		go func(i int, label string) {
+			defer wg.Done()
+			result := runShard(ctx, run, call, label, shardArgs)
+			mu.Lock()
+			results[i] = result
+			done++
+			call.progressf(done, "%s finished (%d/%d)", label, done, len(args.Shards))
+			mu.Unlock()
+		}(i, label)
+	}
+	wg.Wait()
+
+	var text strings.Builder
+	failed := 0
+	for _, r := range results {
+		status := "ok"
+		if r.IsError {
+			status = "error"
+			failed++
+		}
+		fmt.Fprintf(&text, "=== %s (%s) ===\n%s\n\n", r.Label, status, strings.TrimSpace(r.Text))
+	}
+
+	structured := map[string]any{"shards": results}
+	if args.Synthesize {
+		synthesis := runShard(ctx, run, call, "synthesis", synthesisArgs(args, results))
+		structured["synthesis"] = synthesis
+		fmt.Fprintf(&text, "=== synthesis ===\n%s\n", strings.TrimSpace(synthesis.Text))
+		if synthesis.IsError {
+			failed++
+		}
+	}
+	log.Printf("[fanout] done shards=%d failed=%d", len(results), failed)
+
+	return &toolCallResult{
+		Content:           []toolContent{{Type: "text", Text: strings.TrimRight(text.String(), "\n")}},
+		StructuredContent: structured,
+		IsError:           failed > 0,
+	}, nil
+}
+
+// runShard executes one opencode_run through the tool dispatcher.
+func runShard(ctx context.Context, run toolHandler, parent *toolCall, label string, args json.RawMessage) fanoutShardResult {
+	result, mErr := run(ctx, &toolCall{
+		ID:        parent.ID,
+		Name:      toolRun,
+		Arguments: args,
+		Cwd:       parent.Cwd,
+		Session:   parent.Session,
+		Tenant:    parent.Tenant,
+		Binary:    parent.Binary,
+	})
+	if mErr != nil {
+		return fanoutShardResult{Label: label, IsError: true, Text: mErr.Message}
+	}
+	return fanoutShardResult{Label: label, IsError: result.IsError, Text: resultText(result)}
+}
+
+// synthesisArgs builds the opencode_run arguments of the synthesis pass.
+func synthesisArgs(args fanoutArgs, results []fanoutShardResult) json.RawMessage {
+	prompt := args.SynthesisPrompt
+	if prompt == "" {
+		prompt = "The task below was split into parts that ran in parallel. Combine their results into one coherent answer, noting any conflicts or failed parts."
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n\nTask:\n%s\n", prompt, args.Message)
+	for _, r := range results {
+		status := ""
+		if r.IsError {
+			status = " (failed)"
+		}
+		fmt.Fprintf(&b, "\n### %s%s\n%s\n", r.Label, status, strings.TrimSpace(r.Text))
+	}
+	out, _ := json.Marshal(runToolArgs{Message: b.String(), Cwd: args.Cwd, Model: args.Model, Labels: args.Labels})
+	return out
+}
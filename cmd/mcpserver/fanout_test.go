@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Test runLimiter bounds concurrent acquisitions
+func TestRunLimiter(t *testing.T) {
+	l := newRunLimiter(1)
+	if err := l.acquire(context.Background(), priorityNormal); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := l.acquire(ctx, priorityNormal); err == nil {
+		t.Fatal("second acquire should block until ctx is done")
+	}
+	l.release(priorityNormal)
+	if err := l.acquire(context.Background(), priorityNormal); err != nil {
+		t.Fatalf("acquire after release: %v", err)
+	}
+
+	unlimited := newRunLimiter(0)
+	if unlimited != nil || unlimited.acquire(context.Background(), priorityBatch) != nil {
+		t.Error("a zero limit should be unlimited")
+	}
+	unlimited.release(priorityBatch)
+}
+
+// Test queued interactive runs start before batch ones, and aged batch runs
+// before fresh interactive ones
+func TestRunLimiterPriority(t *testing.T) {
+	l := newRunLimiter(1)
+	if err := l.acquire(context.Background(), priorityNormal); err != nil {
+		t.Fatal(err)
+	}
+	var order []string
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	enqueue := func(class string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := l.acquire(context.Background(), class); err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			order = append(order, class)
+			mu.Unlock()
+			l.release(class)
+		}()
+		for waiting := 0; waiting == 0; time.Sleep(time.Millisecond) {
+			waiting = l.queueStatus()[class].Waiting
+		}
+	}
+	enqueue(priorityBatch)
+	enqueue(priorityInteractive)
+	l.release(priorityNormal)
+	wg.Wait()
+	if strings.Join(order, ",") != "interactive,batch" {
+		t.Errorf("start order = %v, want interactive first", order)
+	}
+	if s := l.queueStatus()[priorityBatch]; s.Started != 1 || s.Waiting != 0 || s.Running != 0 {
+		t.Errorf("batch stats = %+v", s)
+	}
+
+	now := time.Now()
+	aged := &queuedRun{class: priorityBatch, enqueued: now.Add(-3 * priorityAging)}
+	fresh := &queuedRun{class: priorityInteractive, enqueued: now}
+	if effectiveRank(aged, now) >= effectiveRank(fresh, now) {
+		t.Error("a batch run waiting 3 aging intervals should overtake a fresh interactive one")
+	}
+}
+
+// Test opencode_fanout runs every shard and the synthesis pass
+func TestFanoutTool(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "opencode")
+	content := `#!/bin/sh
+for last; do :; done
+case "$last" in
+*fail-me*) echo "boom" >&2; exit 1 ;;
+esac
+first=$(printf '%s' "$last" | tail -n 1)
+printf '{"type":"text","part":{"text":"did: %s"}}\n' "$first"
+`
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	cfg := serverConfig{Target: script, DefaultTimeout: 5 * time.Second, Limiter: newRunLimiter(2)}
+	tools := newToolHandler(cfg)
+
+	var progress int
+	call := &toolCall{ID: json.RawMessage("1"), Name: toolFanout, Arguments: json.RawMessage(`{
+		"message": "rename foo to bar",
+		"model": "m",
+		"synthesize": true,
+		"shards": [{"label":"api","message":"pkg api"},{"message":"pkg db"},{"label":"bad","message":"fail-me"}]
+	}`)}
+	call.notify = func(msg any) { progress++ }
+	result, mErr := tools(context.Background(), call)
+	if mErr != nil {
+		t.Fatalf("unexpected error: %v", mErr)
+	}
+
+	text := result.Content[0].Text
+	for _, want := range []string{"=== api (ok) ===\ndid: pkg api", "=== shard-2 (ok) ===\ndid: pkg db", "=== bad (error) ===", "=== synthesis ==="} {
+		if !strings.Contains(text, want) {
+			t.Errorf("result missing %q:\n%s", want, text)
+		}
+	}
+	if !result.IsError {
+		t.Error("a failed shard should mark the result as an error")
+	}
+	if progress != 3 {
+		t.Errorf("progress notifications = %d, want 3", progress)
+	}
+	shards := result.StructuredContent.(map[string]any)["shards"].([]fanoutShardResult)
+	if len(shards) != 3 || shards[1].Label != "shard-2" || !shards[2].IsError {
+		t.Errorf("shards = %+v", shards)
+	}
+}
+
+// Test opencode_fanout argument validation
+func TestFanoutToolValidation(t *testing.T) {
+	tools := newToolHandler(serverConfig{DefaultTimeout: time.Second})
+	tooMany := `{"message":"x","shards":[` + strings.TrimSuffix(strings.Repeat(`{},`, maxFanoutShards+1), ",") + `]}`
+	for _, args := range []string{`{"shards":[{}]}`, `{"message":"x"}`, tooMany} {
+		_, mErr := tools(context.Background(), &toolCall{ID: json.RawMessage("1"), Name: toolFanout, Arguments: json.RawMessage(args)})
+		if mErr == nil || mErr.Code != -32602 {
+			t.Errorf("args %s: error = %v, want invalid params", args, mErr)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"opencode-mcp/internal/runner"
+)
+
+// Fuzz the opencode event-stream parsers with malformed CLI output
+func FuzzParseJSONEventStream(f *testing.F) {
+	for _, seed := range []string{
+		`{"type":"text","part":{"text":"hello"}}`,
+		`{"type":"tool_use","part":{"tool":"bash","state":{"status":"completed","input":{"command":"ls"},"output":"a\nb"}}}` + "\n" +
+			`{"type":"tool_use","part":{"tool":"bash","state":{"status":"error","error":"boom"}}}`,
+		`{"type":"step_start","part":{"reason":"x"}}` + "\n\n" + `{"type":"step_finish","part":{"reason":null}}`,
+		`{"type":"tool_use","part":{"tool":["x"],"state":"done"}}`,
+		`{"type":"message.part.updated","properties":{"part":{"type":"text","text":"v2"}}}`,
+		`{"type":"text","part":null}`,
+		`{"type":1,"part":[]}`,
+		`not json` + "\n" + `{"type":"text"`,
+		`[{"type":"text"}]`,
+		`{"type":"text","part":{"text":"` + strings.Repeat(`é`, 100) + `"}}`,
+	} {
+		f.Add(seed)
+	}
+	adapters := adaptersFor("")
+	f.Fuzz(func(t *testing.T, data string) {
+		_ = runner.FormatEvents(data)
+		for _, line := range strings.Split(data, "\n") {
+			var raw map[string]any
+			if json.Unmarshal([]byte(line), &raw) != nil {
+				continue
+			}
+			_ = runner.EventData(raw)
+			if event, known := normalizeEvent(adapters, raw); known && event != nil {
+				_ = runner.EventData(event)
+			}
+		}
+	})
+}
+
+// Fuzz JSON-RPC request decoding with hostile request bodies; every
+// response must be well-formed JSON-RPC
+func FuzzMCPRequest(f *testing.F) {
+	for _, seed := range []string{
+		`{"jsonrpc":"2.0","id":1,"method":"tools/list"}`,
+		`{"jsonrpc":"2.0","id":"a","method":"initialize","params":{"protocolVersion":"2025-03-26"}}`,
+		`{"jsonrpc":"2.0","id":1e308,"method":"tools/list"}`,
+		`{"jsonrpc":"2.0","id":1e400,"method":"tools/list"}`,
+		`{"jsonrpc":"2.0","id":123456789012345678901234567890,"method":"prompts/list"}`,
+		`{"jsonrpc":"2.0","id":[1,"a",{"b":null}],"method":"tools/list"}`,
+		`{"jsonrpc":"2.0","id":{"x":1},"method":"nope"}`,
+		`{"jsonrpc":"2.0","id":true,"method":"prompts/get","params":{"name":1}}`,
+		`{"jsonrpc":"2.0","id":null,"method":"tools/call","params":{"name":"opencode_run","arguments":{"message":{"a":[[[[[]]]]]}}}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"opencode_fanout","arguments":{"tasks":[{"message":1},null]}}}`,
+		`{"jsonrpc":"2.0","id":3,"method":"tools/call","params":"oops"}`,
+		`{"jsonrpc":"2.0","id":4,"method":"tools/call","params":{"name":null,"arguments":[]}}`,
+		`{"jsonrpc":"2.0","id":5,"method":"tools/call","params":{"name":"opencode_pipeline","arguments":{"steps":[{"message":"a"},{"message":"{{.prev}}"}]}}}`,
+		`{"method":"tools/list"}{"method":"tools/list"}`,
+		`[{"jsonrpc":"2.0","id":1,"method":"tools/list"}]`,
+		`{"jsonrpc":"2.0","id":1,"method":"` + strings.Repeat("a", 1000) + `"}`,
+		``,
+	} {
+		f.Add([]byte(seed))
+	}
+	cfg := serverConfig{Target: "/nonexistent/opencode", DefaultTimeout: time.Second, DefaultModel: "m"}
+	handler := newMCPHandler(&sessionStore{sessions: make(map[string]*session)}, cfg)
+	f.Fuzz(func(t *testing.T, body []byte) {
+		req := httptest.NewRequest(http.MethodPost, "/mcp", bytes.NewReader(body))
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+
+		final := rec.Body.Bytes()
+		switch ct := rec.Header().Get("Content-Type"); {
+		case rec.Code == http.StatusNoContent:
+			return
+		case strings.HasPrefix(ct, "text/event-stream"):
+			for _, frame := range strings.Split(strings.TrimSuffix(rec.Body.String(), "\n\n"), "\n\n") {
+				_, data, ok := strings.Cut(frame, "data: ")
+				if !ok || !json.Valid([]byte(data)) {
+					t.Fatalf("invalid SSE frame %q for %q", frame, body)
+				}
+				final = []byte(data)
+			}
+		}
+		var resp struct {
+			JSONRPC string          `json:"jsonrpc"`
+			ID      json.RawMessage `json:"id"`
+		}
+		if err := json.Unmarshal(final, &resp); err != nil || resp.JSONRPC != "2.0" {
+			t.Fatalf("response %q (HTTP %d) for %q is not JSON-RPC", rec.Body.String(), rec.Code, body)
+		}
+
+		// A decodable request gets its ID back unchanged
+		var sent struct {
+			Method string          `json:"method"`
+			ID     json.RawMessage `json:"id"`
+		}
+		if json.Unmarshal(body, &sent) != nil || sent.Method == "" {
+			return
+		}
+		if want, got := exactJSON(sent.ID), exactJSON(resp.ID); !reflect.DeepEqual(want, got) {
+			t.Fatalf("id %s was echoed as %s", sent.ID, resp.ID)
+		}
+	})
+}
+
+// exactJSON decodes raw keeping numbers as their literal text.
+func exactJSON(raw json.RawMessage) any {
+	if len(raw) == 0 {
+		return nil
+	}
+	var v any
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	_ = dec.Decode(&v)
+	return v
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Server-generated text (error messages, progress messages, the error
+// catalogue) is written in English and translated on its way out, into the
+// language negotiated from the request's Accept-Language, or MCP_LOCALE
+// without one. Error codes are never translated. Messages without a
+// translation, e.g. opencode's own output, keep their text behind a
+// translated title.
+
+const defaultLocale = "en"
+
+var supportedLocales = map[string]bool{"en": true, "zh": true}
+
+// negotiateLocale picks the supported language the client prefers most,
+// matching on the primary tag (zh-CN and zh-Hant are zh), or fallback.
+func negotiateLocale(acceptLanguage, fallback string) string {
+	best, bestQ := fallback, 0.0
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		primary, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if primary == "*" {
+			primary = fallback
+		}
+		if supportedLocales[primary] && q > bestQ {
+			best, bestQ = primary, q
+		}
+	}
+	return best
+}
+
+// requestLocale is the language of the response to r.
+func requestLocale(r *http.Request, cfg serverConfig) string {
+	fallback := cfg.Locale
+	if fallback == "" {
+		fallback = defaultLocale
+	}
+	return negotiateLocale(r.Header.Get("Accept-Language"), fallback)
+}
+
+// translations maps English text, or fmt formats, to each language. Formats
+// may reorder their arguments with %[n]s.
+var translations = map[string]map[string]string{
+	"zh": {
+		// Error catalogue titles and descriptions
+		"invalid arguments":    "参数无效",
+		"invalid cwd":          "工作目录无效",
+		"unknown tool":         "未知工具",
+		"invalid request":      "请求无效",
+		"idempotency conflict": "幂等键冲突",
+		"session not found":    "会话不存在",
+		"session required":     "需要会话",
+		"message too long":     "消息过长",
+		"attachment rejected":  "附件被拒绝",
+		"timeout":              "超时",
+		"cancelled":            "已取消",
+		"run failed":           "运行失败",
+		"start failed":         "启动失败",
+		"unrecognized event":   "无法识别的事件",
+		"checkout failed":      "检出失败",
+		"stalled":              "运行停滞",
+		"stdin timeout":        "标准输入超时",
+		"policy denied":        "策略拒绝",
+		"quota exceeded":       "配额已用尽",
+		"attachment denied":    "附件来源不允许",
+		"provider auth":        "模型服务认证失败",
+		"provider rate limit":  "模型服务限流",
+		"internal error":       "内部错误",
+		"disk full":            "磁盘空间不足",
+
+		"The tool arguments or request parameters are missing or malformed.":                                                              "工具参数或请求参数缺失或格式错误。",
+		"The working directory does not exist or is not a directory.":                                                                     "工作目录不存在或不是目录。",
+		"No tool, prompt or method with this name exists.":                                                                                "不存在该名称的工具、提示词或方法。",
+		"The body is not a valid JSON-RPC request.":                                                                                       "请求体不是有效的 JSON-RPC 请求。",
+		"The idempotency key was already used for a call with different arguments.":                                                       "该幂等键已用于参数不同的调用。",
+		"The Mcp-Session-Id is unknown, e.g. from before a server restart; initialize a new session.":                                     "Mcp-Session-Id 未知（例如来自服务器重启之前）；请重新 initialize 一个会话。",
+		"The server requires an Mcp-Session-Id from initialize on every other request (MCP_REQUIRE_SESSION).":                             "服务器要求除 initialize 外的每个请求都携带 Mcp-Session-Id（MCP_REQUIRE_SESSION）。",
+		"The message and its attachments exceed MCP_MAX_MESSAGE_CHARS; shorten them, or have the server split them (MCP_OVERSIZE=split).": "消息及其附件超过 MCP_MAX_MESSAGE_CHARS；请缩短，或让服务器拆分发送（MCP_OVERSIZE=split）。",
+		"A URL attachment could not be fetched, exceeds MCP_ATTACH_MAX_BYTES, or is neither text nor an image.":                           "URL 附件无法获取、超过 MCP_ATTACH_MAX_BYTES，或既不是文本也不是图片。",
+		"The run exceeded its timeout and was killed.":                                                                                    "运行超时，已被终止。",
+		"The client cancelled the call or disconnected.":                                                                                  "客户端取消了调用或已断开连接。",
+		"opencode exited with a non-zero status.":                                                                                         "opencode 以非零状态退出。",
+		"The opencode binary could not be started.":                                                                                       "无法启动 opencode 程序。",
+		"opencode emitted an event the server doesn't understand (MCP_STRICT_EVENTS).":                                                    "opencode 输出了服务器无法识别的事件（MCP_STRICT_EVENTS）。",
+		"opencode printed nothing for MCP_STALL_TIMEOUT and was stopped.":                                                                 "opencode 在 MCP_STALL_TIMEOUT 内没有任何输出，已被停止。",
+		"The command didn't read all of its stdin within MCP_STDIN_TIMEOUT and was killed.":                                               "命令未在 MCP_STDIN_TIMEOUT 内读完其标准输入，已被终止。",
+		"The repo could not be cloned or updated into the workspace cache.":                                                               "无法将仓库克隆或更新到工作区缓存。",
+		"The directory or repo is outside the tenant's allowedDirs or allowedRepos.":                                                      "目录或仓库不在租户的 allowedDirs 或 allowedRepos 范围内。",
+		"The host of a URL attachment is not in MCP_ATTACH_ALLOW_HOSTS or the tenant's egress allowHosts.":                                "URL 附件的主机不在 MCP_ATTACH_ALLOW_HOSTS 或租户的 egress allowHosts 中。",
+		"The tenant's daily run or cost quota is used up.":                                                                                "租户当日的运行次数或费用配额已用尽。",
+		"The model provider rejected opencode's credentials.":                                                                             "模型服务拒绝了 opencode 的凭据。",
+		"The model provider is rate limiting requests.":                                                                                   "模型服务正在限流。",
+		"An unexpected server error; see the server log.":                                                                                 "服务器发生意外错误，请查看服务器日志。",
+		"Free space on the workspace, artifact or store filesystem is below MCP_DISK_MIN_FREE_MB.":                                        "工作区、产物或存储所在文件系统的可用空间低于 MCP_DISK_MIN_FREE_MB。",
+
+		// Error messages
+		"invalid JSON":             "JSON 无效",
+		"missing method":           "缺少 method",
+		"method not found: %s":     "方法不存在：%s",
+		"unknown prompt: %s":       "未知提示词：%s",
+		"invalid params":           "params 无效",
+		"missing message":          "缺少 message",
+		"missing name":             "缺少 name",
+		"missing args":             "缺少 args",
+		"missing steps":            "缺少 steps",
+		"missing shards":           "缺少 shards",
+		"missing Mcp-Session-Id":   "缺少 Mcp-Session-Id",
+		"idempotency key too long": "幂等键过长",
+		"idempotency key was already used for a different request": "该幂等键已用于不同的请求",
+		"cancelled while waiting for a free run slot":              "等待空闲运行槽位时被取消",
+		"cancelled while waiting for an identical run":             "等待相同的运行时被取消",
+		"cancelled while waiting for the original call":            "等待原始调用时被取消",
+		"pass either project or cwd, not both":                     "project 和 cwd 只能传其中一个",
+		"pass either repo or cwd/project, not both":                "repo 与 cwd/project 只能传其中一个",
+		"invalid repo, branch or depth":                            "repo、branch 或 depth 无效",
+		"repo is not enabled on this server (MCP_WORKSPACE_DIR)":   "此服务器未启用 repo（MCP_WORKSPACE_DIR）",
+		"unknown tool: %s":                            "未知工具：%s",
+		"unknown project %q (see %s)":                 "未知项目 %q（见 %s）",
+		"invalid branch %q":                           "分支 %q 无效",
+		"repo %s is not in the tenant's allowedRepos": "仓库 %s 不在租户的 allowedRepos 中",
+		"step %d: missing message":                    "第 %d 步：缺少 message",
+		"too many steps (max %d)":                     "步骤过多（最多 %d 个）",
+		"too many shards (max %d)":                    "分片过多（最多 %d 个）",
+		"models[%d] is empty":                         "models[%d] 为空",
+		"models must list %d to %d models":            "models 必须列出 %d 到 %d 个模型",
+		"only %d MB free on the filesystem of %s":     "%[2]s 所在文件系统仅剩 %[1]d MB 可用空间",
+		"the tenant's egress policy needs MCP_BACKEND=cli: opencode serve is shared by all tenants": "租户的出站策略需要 MCP_BACKEND=cli：opencode serve 由所有租户共享",
+		"%s is not supported by the %s agent":                                                       "%[2]s 智能体不支持 %[1]s",
+		"aider has no sessions; use continue to restore its chat history":                           "aider 没有会话；请使用 continue 恢复其聊天记录",
+		"aider has no agents": "aider 没有 agent",
+		"codex takes no file attachments; name the files in the message": "codex 不接受附件；请在消息中写明文件",
+		"invalid level %q":                                     "日志级别 %q 无效",
+		"a and b are required":                                 "a 和 b 为必填项",
+		"unsupported MCP-Protocol-Version %q":                  "不支持的 MCP-Protocol-Version %q",
+		"%.0f%% of the daily run quota used (%d of %d)":        "已用每日运行配额的 %.0f%%（%d / %d）",
+		"%.0f%% of the daily cost quota used ($%.2f of $%.2f)": "已用每日费用配额的 %.0f%%（$%.2f / $%.2f）",
+		"the message and its attachments are %d characters, over the limit of %d: shorten the message, attach fewer or smaller files, or split the work into steps with %s": "消息及其附件共 %d 个字符，超过上限 %d：请缩短消息、减少或缩小附件，或用 %s 将工作拆成多个步骤",
+		"codex has no agents":                   "codex 没有 agent",
+		"project %q is ambiguous: it may be %s": "项目 %q 有歧义：可能是 %s",
+		"asking for input failed: %v":           "请求用户输入失败：%v",
+		"the user did not answer (%s)":          "用户未作答（%s）",
+		"no answer from the user":               "用户没有回答",
+		"no working directory given":            "未提供工作目录",
+		"unknown run %q":                        "未知的运行 %q",
+		"id is required":                        "缺少 id",
+
+		// Elicitation messages
+		"Which project did you mean by %q?":        "%q 指的是哪个项目？",
+		"Which directory should opencode work in?": "opencode 应在哪个目录中工作？",
+
+		// Progress messages
+		"Tool %s completed":   "工具 %s 已完成",
+		"%s started (%d/%d)":  "%s 已开始（%d/%d）",
+		"%s finished (%d/%d)": "%s 已完成（%d/%d）",
+		"step_start":          "步骤开始",
+		"step_finish":         "步骤结束",
+		"step_start: %s":      "步骤开始：%s",
+		"step_finish: %s":     "步骤结束：%s",
+	},
+}
+
+// translateFormat returns format in lang, or format itself.
+func translateFormat(lang, format string) string {
+	if t, ok := translations[lang][format]; ok {
+		return t
+	}
+	return format
+}
+
+// messagePattern matches text produced by an English format, to
+// re-render it from the captured arguments in another language.
+type messagePattern struct {
+	re     *regexp.Regexp
+	format string // the English format
+}
+
+var (
+	fmtVerbRe        = regexp.MustCompile(`%(\[\d+\])?[sqdv]`)
+	messagePatterns  []messagePattern
+	messagePatternsO sync.Once
+)
+
+func patterns() []messagePattern {
+	messagePatternsO.Do(func() {
+		seen := map[string]bool{}
+		for _, table := range translations {
+			for format := range table {
+				if seen[format] || !fmtVerbRe.MatchString(format) {
+					continue
+				}
+				seen[format] = true
+				parts := fmtVerbRe.Split(format, -1)
+				for i := range parts {
+					parts[i] = regexp.QuoteMeta(parts[i])
+				}
+				re := regexp.MustCompile("^" + strings.Join(parts, "(.+?)") + "$")
+				messagePatterns = append(messagePatterns, messagePattern{re, format})
+			}
+		}
+		// Longer formats are more specific: try them first
+		sort.Slice(messagePatterns, func(i, j int) bool {
+			return len(messagePatterns[i].format) > len(messagePatterns[j].format)
+		})
+	})
+	return messagePatterns
+}
+
+// translateMessage translates a message produced from one of the formats
+// in translations, reporting whether it could.
+func translateMessage(lang, msg string) (string, bool) {
+	table := translations[lang]
+	if table == nil {
+		return msg, lang == defaultLocale
+	}
+	if t, ok := table[msg]; ok {
+		return t, true
+	}
+	for _, p := range patterns() {
+		m := p.re.FindStringSubmatch(msg)
+		t, ok := table[p.format]
+		if m == nil || !ok {
+			continue
+		}
+		args := make([]any, len(m)-1)
+		for i, s := range m[1:] {
+			args[i] = s
+		}
+		// The captured arguments are already formatted: print them as-is
+		return fmt.Sprintf(fmtVerbRe.ReplaceAllString(t, "%${1}s"), args...), true
+	}
+	return msg, false
+}
+
+// localize returns e with its message in lang. Messages without a
+// translation are prefixed with the translated title of their code.
+func (e *mcpError) localize(lang string) *mcpError {
+	if e == nil || lang == "" || lang == defaultLocale {
+		return e
+	}
+	out := *e
+	if t, ok := translateMessage(lang, e.Message); ok {
+		out.Message = t
+	} else if e.Data != nil {
+		for _, c := range errorCatalogue {
+			if c.Code == e.Data.Code {
+				out.Message = translateFormat(lang, c.Title) + "：" + e.Message
+				break
+			}
+		}
+	}
+	return &out
+}
+
+// localizedCatalogue returns the error catalogue in lang.
+func localizedCatalogue(lang string) []errorCode {
+	out := make([]errorCode, len(errorCatalogue))
+	for i, c := range errorCatalogue {
+		c.Title = translateFormat(lang, c.Title)
+		c.Description = translateFormat(lang, c.Description)
+		out[i] = c
+	}
+	return out
+}
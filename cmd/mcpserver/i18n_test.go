@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Test Accept-Language negotiation
+func TestNegotiateLocale(t *testing.T) {
+	for header, want := range map[string]string{
+		"":                           "en",
+		"zh-CN,zh;q=0.9,en;q=0.8":    "zh",
+		"en-US,en;q=0.9,zh;q=0.8":    "en",
+		"fr-FR, zh-Hant;q=0.5":       "zh",
+		"fr, de":                     "en",
+		"zh;q=0, en;q=0.1":           "en",
+		"*":                          "en",
+		"de;q=1, en;q=0.4, zh;q=0.6": "zh",
+	} {
+		if got := negotiateLocale(header, "en"); got != want {
+			t.Errorf("negotiateLocale(%q) = %q, want %q", header, got, want)
+		}
+	}
+	if got := negotiateLocale("", "zh"); got != "zh" {
+		t.Errorf("MCP_LOCALE fallback = %q", got)
+	}
+}
+
+// Test error messages are translated, with their codes unchanged
+func TestLocalizeError(t *testing.T) {
+	for _, tc := range []struct {
+		err  *mcpError
+		want string
+	}{
+		{errInvalidArguments.err("missing message"), "缺少 message"},
+		{errInvalidArguments.err(`unknown project "web" (see opencode_project_list)`), `未知项目 "web"（见 opencode_project_list）`},
+		{errDiskFull.err("only 12 MB free on the filesystem of /srv"), "/srv 所在文件系统仅剩 12 MB 可用空间"},
+		{errStartFailed.err("fork/exec /bin/x: no such file"), "启动失败：fork/exec /bin/x: no such file"},
+	} {
+		got := tc.err.localize("zh")
+		if got.Message != tc.want || got.Data.Code != tc.err.Data.Code || got.Code != tc.err.Code {
+			t.Errorf("%q -> %q (%s), want %q", tc.err.Message, got.Message, got.Data.Code, tc.want)
+		}
+		if tc.err.localize("en").Message != tc.err.Message {
+			t.Errorf("en changed %q", tc.err.Message)
+		}
+	}
+	// Every catalogue entry has a translation
+	for _, c := range localizedCatalogue("zh") {
+		if c.Title == "" || c.Description == "" || !strings.ContainsFunc(c.Title+c.Description, func(r rune) bool { return r >= 0x4e00 && r <= 0x9fff }) {
+			t.Errorf("%s is not translated: %+v", c.Code, c)
+		}
+	}
+}
+
+// Test the transport answers in the negotiated language
+func TestLocalizedResponses(t *testing.T) {
+	cfg := serverConfig{Locale: "en"}
+	handler := createMCPHandler(&sessionStore{sessions: make(map[string]*session)}, cfg)
+	req := httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"nope"}`))
+	req.Header.Set("Accept-Language", "zh-CN,zh;q=0.9")
+	w := httptest.NewRecorder()
+	handler(w, req)
+	var resp mcpResponse
+	_ = json.NewDecoder(w.Body).Decode(&resp)
+	if resp.Error == nil || resp.Error.Message != "方法不存在：nope" || resp.Error.Data.Code != errUnknownTool.Code || w.Header().Get("Content-Language") != "zh" {
+		t.Errorf("zh error = %+v, Content-Language %q", resp.Error, w.Header().Get("Content-Language"))
+	}
+
+	mux := http.NewServeMux()
+	registerErrorRoutes(mux, serverConfig{Locale: "zh"})
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/errors", nil))
+	var catalogue []errorCode
+	_ = json.NewDecoder(w.Body).Decode(&catalogue)
+	if len(catalogue) != len(errorCatalogue) || catalogue[0].Title != "参数无效" || catalogue[0].Code != "OC-1000" {
+		t.Errorf("MCP_LOCALE=zh catalogue starts %+v", catalogue[0])
+	}
+
+	call := &toolCall{ID: json.RawMessage("1"), Locale: "zh"}
+	var got string
+	call.notify = func(msg any) {
+		got = msg.(map[string]any)["params"].(map[string]any)["message"].(string)
+	}
+	call.progressf(2, "%s finished (%d/%d)", "shard-1", 2, 3)
+	if got != "shard-1 已完成（2/3）" {
+		t.Errorf("progress = %q", got)
+	}
+}
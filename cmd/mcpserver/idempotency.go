@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	idempotencyTTL        = 24 * time.Hour
+	maxIdempotencyEntries = 10000
+	maxIdempotencyKeyLen  = 255
+	maxResumeEvents       = 1000 // notifications kept per call for resuming clients
+)
+
+// idempotentCall is a tool call registered under an idempotency key. Retries
+// with the same key attach to it instead of starting another run.
+type idempotentCall struct {
+	fingerprint string
+	created     time.Time
+	done        chan struct{}
+	result      *toolCallResult
+	mErr        *mcpError
+
+	mu          sync.Mutex
+	events      []callEvent // the last maxResumeEvents notifications
+	lastID      int
+	changed     chan struct{} // closed and replaced on every notification
+	subscribers map[*toolCall]func(ev callEvent)
+}
+
+func newIdempotentCall(fingerprint string, now time.Time) *idempotentCall {
+	return &idempotentCall{
+		fingerprint: fingerprint,
+		created:     now,
+		done:        make(chan struct{}),
+		changed:     make(chan struct{}),
+		subscribers: make(map[*toolCall]func(ev callEvent)),
+	}
+}
+
+// callEvent is a notification of an idempotent call, numbered so resuming
+// clients can ask for the ones they missed (SSE Last-Event-ID).
+type callEvent struct {
+	ID   int       `json:"id"`
+	Time time.Time `json:"time"`
+	Msg  any       `json:"message"`
+}
+
+// notify forwards msg to the original caller and every attached retry.
+func (c *idempotentCall) notify(msg any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastID++
+	ev := callEvent{ID: c.lastID, Time: localTime(time.Now()), Msg: msg}
+	c.events = append(c.events, ev)
+	if len(c.events) > maxResumeEvents {
+		c.events = c.events[len(c.events)-maxResumeEvents:]
+	}
+	for _, send := range c.subscribers {
+		send(ev)
+	}
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// waitAfter blocks until the call has an event with an ID above after, is
+// done, or ctx ends.
+func (c *idempotentCall) waitAfter(ctx context.Context, after int) {
+	for {
+		c.mu.Lock()
+		lastID, changed := c.lastID, c.changed
+		c.mu.Unlock()
+		if lastID > after {
+			return
+		}
+		select {
+		case <-changed:
+		case <-c.done:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// eventsAfter returns the kept events with IDs above after, and whether
+// older ones the client hasn't seen were dropped.
+func (c *idempotentCall) eventsAfter(after int) ([]callEvent, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var out []callEvent
+	for _, ev := range c.events {
+		if ev.ID > after {
+			out = append(out, ev)
+		}
+	}
+	truncated := len(c.events) > 0 && c.events[0].ID > after+1
+	return out, truncated
+}
+
+// attach replays the notifications after lastSeen to call and subscribes it
+// to the rest, as SSE events with IDs and with progress tokens rewritten to
+// call's. The returned func unsubscribes.
+func (c *idempotentCall) attach(call *toolCall, lastSeen int) (detach func()) {
+	notify := call.notify
+	if notify == nil {
+		return func() {}
+	}
+	send := func(ev callEvent) {
+		if msg, ok := call.adaptProgress(ev.Msg); ok {
+			notify(sseEvent{ID: strconv.Itoa(ev.ID), Msg: msg})
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, ev := range c.events {
+		if ev.ID > lastSeen {
+			send(ev)
+		}
+	}
+	c.subscribers[call] = send
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.subscribers, call)
+	}
+}
+
+// status describes the call for resuming clients.
+func (c *idempotentCall) status() string {
+	select {
+	case <-c.done:
+		return "done"
+	default:
+		return "running"
+	}
+}
+
+// idempotencyCache remembers calls by tenant and key for idempotencyTTL.
+type idempotencyCache struct {
+	mu    sync.Mutex
+	calls map[string]*idempotentCall
+}
+
+func newIdempotencyCache() *idempotencyCache {
+	return &idempotencyCache{calls: make(map[string]*idempotentCall)}
+}
+
+// claim returns the call registered under key, or registers a new one and
+// reports that the caller owns it.
+func (c *idempotencyCache) claim(key, fingerprint string, now time.Time) (*idempotentCall, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if existing, ok := c.calls[key]; ok && now.Sub(existing.created) < idempotencyTTL {
+		return existing, false
+	}
+	c.prune(now)
+	call := newIdempotentCall(fingerprint, now)
+	c.calls[key] = call
+	return call, true
+}
+
+// get returns the unexpired call registered under key, or nil.
+func (c *idempotencyCache) get(key string, now time.Time) *idempotentCall {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if call, ok := c.calls[key]; ok && now.Sub(call.created) < idempotencyTTL {
+		return call
+	}
+	return nil
+}
+
+// prune drops expired calls, and the oldest finished ones when full.
+func (c *idempotencyCache) prune(now time.Time) {
+	var oldestKey string
+	var oldest time.Time
+	for key, call := range c.calls {
+		select {
+		case <-call.done:
+		default:
+			continue // still running
+		}
+		if now.Sub(call.created) >= idempotencyTTL {
+			delete(c.calls, key)
+		} else if oldestKey == "" || call.created.Before(oldest) {
+			oldestKey, oldest = key, call.created
+		}
+	}
+	if len(c.calls) >= maxIdempotencyEntries && oldestKey != "" {
+		delete(c.calls, oldestKey)
+	}
+}
+
+// callFingerprint identifies the request a key was first used for;
+// arguments are compared after normalizing key order and whitespace.
+func callFingerprint(name string, args json.RawMessage) string {
+	var v any
+	if err := json.Unmarshal(args, &v); err == nil {
+		args, _ = json.Marshal(v)
+	}
+	sum := sha256.Sum256(append([]byte(name+"\x00"), args...))
+	return hex.EncodeToString(sum[:])
+}
+
+// idempotencyMiddleware runs each idempotency key at most once per tenant:
+// retries get the original result, or attach to its notification stream
+// while it is still running. Keyed calls keep running when the client
+// disconnects so a retry can pick them up.
+func idempotencyMiddleware(cache *idempotencyCache) toolMiddleware {
+	return func(next toolHandler) toolHandler {
+		return func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+			if call.IdempotencyKey == "" || cache == nil {
+				return next(ctx, call)
+			}
+			if len(call.IdempotencyKey) > maxIdempotencyKeyLen {
+				return nil, errInvalidArguments.err("idempotency key too long")
+			}
+			fp := callFingerprint(call.Name, call.Arguments)
+			entry, owner := cache.claim(tenantOrDefault(call.Tenant)+"/"+call.IdempotencyKey, fp, time.Now())
+			if !owner {
+				if entry.fingerprint != fp {
+					return nil, errIdempotencyConflict.err("idempotency key was already used for a different request")
+				}
+				if call.claimed != nil {
+					call.claimed(entry)
+				}
+				log.Printf("[idempotency] key=%s tool=%s: attaching to the original call (last event %d)", call.IdempotencyKey, call.Name, call.LastEventID)
+				call.Notify(map[string]any{
+					"jsonrpc": "2.0",
+					"method":  "notifications/message",
+					"params": map[string]any{
+						"level": "info",
+						"data":  map[string]any{"resumed": true, "status": entry.status(), "startedAt": localTime(entry.created)},
+					},
+				})
+				defer entry.attach(call, call.LastEventID)()
+				select {
+				case <-entry.done:
+					return entry.result, entry.mErr
+				case <-ctx.Done():
+					return nil, errCancelled.err("cancelled while waiting for the original call")
+				}
+			}
+
+			defer entry.attach(call, 0)()
+			call.notify = entry.notify
+			if call.claimed != nil {
+				call.claimed(entry)
+			}
+
+			// Reported to attached retries if next panics.
+			entry.mErr = errInternal.err("internal error")
+			defer close(entry.done)
+			result, mErr := next(context.WithoutCancel(ctx), call)
+			entry.result, entry.mErr = result, mErr
+			return result, mErr
+		}
+	}
+}
+
+// registerResumeRoutes adds GET /resume/{key}?after=N, which reports a keyed
+// call's status, the notifications after event N and, once done, its
+// result. It lets clients on unstable links poll instead of holding a
+// stream open.
+func registerResumeRoutes(mux *http.ServeMux, cfg serverConfig) {
+	if cfg.Idempotency == nil {
+		return
+	}
+	mux.HandleFunc("GET /resume/{key}", func(w http.ResponseWriter, r *http.Request) {
+		after, _ := strconv.Atoi(r.URL.Query().Get("after"))
+		entry := cfg.Idempotency.get(tenantFromRequest(r)+"/"+r.PathValue("key"), time.Now())
+		if entry == nil {
+			http.Error(w, "unknown idempotency key", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, entry.resumeState(after))
+	})
+}
+
+// resumeState reports the call's status, its events after event after and,
+// once done, its result, for clients that poll instead of streaming.
+func (c *idempotentCall) resumeState(after int) map[string]any {
+	events, truncated := c.eventsAfter(after)
+	lastID := after
+	if len(events) > 0 {
+		lastID = events[len(events)-1].ID
+	}
+	resp := map[string]any{
+		"status":      c.status(),
+		"startedAt":   localTime(c.created),
+		"events":      events,
+		"lastEventId": lastID,
+		"truncated":   truncated,
+	}
+	if resp["status"] == "done" {
+		if c.mErr != nil {
+			resp["error"] = c.mErr.withDefaultData()
+		} else {
+			resp["result"] = c.result
+		}
+	}
+	return resp
+}
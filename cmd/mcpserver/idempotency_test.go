@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Test retries with the same key attach to the original run
+func TestIdempotencyMiddleware(t *testing.T) {
+	var runs atomic.Int32
+	started, finish := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	cache := newIdempotencyCache()
+	h := chainTools(func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+		runs.Add(1)
+		call.progress(1, "working")
+		once.Do(func() { close(started) })
+		<-finish
+		if ctx.Err() != nil {
+			t.Error("keyed call was cancelled with its client")
+		}
+		return &toolCallResult{Content: []toolContent{{Type: "text", Text: "done"}}}, nil
+	}, idempotencyMiddleware(cache))
+
+	ctx, disconnect := context.WithCancel(context.Background())
+	first := &toolCall{ID: json.RawMessage("1"), Name: toolRun, Arguments: []byte(`{"message":"hi","cwd":"/tmp"}`), IdempotencyKey: "k1"}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if result, _ := h(ctx, first); result == nil || result.Content[0].Text != "done" {
+			t.Errorf("original result = %+v", result)
+		}
+	}()
+	<-started
+	disconnect() // e.g. the SSE stream dropped
+
+	var notes []any
+	var mu sync.Mutex
+	retry := &toolCall{ID: json.RawMessage("2"), Name: toolRun, Arguments: []byte(`{"cwd":"/tmp", "message":"hi"}`), IdempotencyKey: "k1",
+		notify: func(msg any) { mu.Lock(); notes = append(notes, msg); mu.Unlock() }}
+	done := make(chan *toolCallResult)
+	go func() {
+		result, _ := h(context.Background(), retry)
+		done <- result
+	}()
+
+	_, mErr := h(context.Background(), &toolCall{ID: json.RawMessage("3"), Name: toolRun, Arguments: []byte(`{"message":"other"}`), IdempotencyKey: "k1"})
+	if mErr == nil || mErr.Data.Code != errIdempotencyConflict.Code {
+		t.Errorf("reused key with other arguments: %+v", mErr)
+	}
+
+	// Wait for the retry to attach before letting the run finish.
+	entry := cache.calls["default/k1"]
+	for attached := false; !attached; time.Sleep(time.Millisecond) {
+		entry.mu.Lock()
+		attached = len(entry.subscribers) == 1 // the original has no notify
+		entry.mu.Unlock()
+	}
+	close(finish)
+	if result := <-done; result == nil || result.Content[0].Text != "done" {
+		t.Errorf("retry result = %+v", result)
+	}
+	wg.Wait()
+	if n := runs.Load(); n != 1 {
+		t.Errorf("runs = %d, want 1", n)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(notes) != 2 {
+		t.Fatalf("retry notifications = %v, want status and the replayed progress", notes)
+	}
+	ev, ok := notes[1].(sseEvent)
+	if !ok || ev.ID != "1" {
+		t.Fatalf("replayed notification = %#v, want SSE event 1", notes[1])
+	}
+	params := ev.Msg.(map[string]any)["params"].(map[string]any)
+	if token, _ := params["progressToken"].(json.RawMessage); string(token) != "2" {
+		t.Errorf("progressToken = %v, want the retry's request ID", params["progressToken"])
+	}
+
+	// Keys are scoped per tenant, and calls without a key always run.
+	if _, mErr := h(context.Background(), &toolCall{Name: toolRun, Arguments: []byte(`{}`), IdempotencyKey: "k1", Tenant: "other"}); mErr != nil || runs.Load() != 2 {
+		t.Errorf("other tenant: runs = %d, err = %v", runs.Load(), mErr)
+	}
+}
+
+// Test resuming clients get only the events they missed, over SSE and
+// GET /resume/{key}
+func TestResumeKeyedCall(t *testing.T) {
+	cfg := serverConfig{Idempotency: newIdempotencyCache()}
+	h := chainTools(func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+		for i := 1; i <= 3; i++ {
+			call.progress(i, fmt.Sprintf("step %d", i))
+		}
+		return &toolCallResult{Content: []toolContent{{Type: "text", Text: "done"}}}, nil
+	}, idempotencyMiddleware(cfg.Idempotency))
+	if _, mErr := h(context.Background(), &toolCall{ID: json.RawMessage("1"), Name: toolRun, Arguments: []byte(`{}`), IdempotencyKey: "k"}); mErr != nil {
+		t.Fatal(mErr)
+	}
+
+	var ids []string
+	resumed := &toolCall{ID: json.RawMessage("2"), Name: toolRun, Arguments: []byte(`{}`), IdempotencyKey: "k", LastEventID: 1,
+		notify: func(msg any) {
+			if ev, ok := msg.(sseEvent); ok {
+				ids = append(ids, ev.ID)
+			}
+		}}
+	if result, _ := h(context.Background(), resumed); result == nil || result.Content[0].Text != "done" {
+		t.Errorf("resumed result = %+v", result)
+	}
+	if strings.Join(ids, ",") != "2,3" {
+		t.Errorf("replayed events = %v, want 2,3", ids)
+	}
+
+	mux := http.NewServeMux()
+	registerResumeRoutes(mux, cfg)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/resume/k?after=2", nil))
+	var resp struct {
+		Status string          `json:"status"`
+		Events []callEvent     `json:"events"`
+		Result *toolCallResult `json:"result"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("%v: %s", err, w.Body)
+	}
+	if resp.Status != "done" || len(resp.Events) != 1 || resp.Events[0].ID != 3 || resp.Result == nil {
+		t.Errorf("GET /resume = %s", w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/resume/unknown", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unknown key: status %d", w.Code)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// subcommands are dispatched on the first command-line argument; without
+// one the server starts.
+var subcommands = map[string]func(args []string) error{
+	"self-update":  runSelfUpdate,
+	"healthcheck":  runHealthcheck,
+	"serve":        runServe,
+	"status":       runStatus,
+	"stop":         runStop,
+	"unit":         runUnit,
+	"config":       runConfig,
+	"bench":        runBench,
+	"export":       runExport,
+	"import":       runImport,
+	"capabilities": runCapabilities,
+}
+
+// runSubcommand runs the subcommand named by args[0], reporting whether
+// there was one.
+func runSubcommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	cmd, ok := subcommands[args[0]]
+	if !ok {
+		return false
+	}
+	if err := cmd(args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", args[0], err)
+		os.Exit(1)
+	}
+	return true
+}
+
+// listenFDsStart is the first file descriptor passed by systemd socket
+// activation (SD_LISTEN_FDS_START).
+const listenFDsStart = 3
+
+// activationSocket keeps the inherited socket open: its finalizer would
+// otherwise close fd 3, which must survive a SIGHUP re-exec.
+var activationSocket *os.File
+
+// listen returns the socket passed by systemd socket activation
+// (LISTEN_PID/LISTEN_FDS), or a new listener on addr.
+func listen(addr string) (net.Listener, error) {
+	if os.Getenv("LISTEN_PID") == strconv.Itoa(os.Getpid()) {
+		if n, _ := strconv.Atoi(os.Getenv("LISTEN_FDS")); n >= 1 {
+			activationSocket = os.NewFile(listenFDsStart, "systemd-socket")
+			ln, err := net.FileListener(activationSocket)
+			if err != nil {
+				return nil, fmt.Errorf("socket activation: %w", err)
+			}
+			return ln, nil
+		}
+	}
+	return net.Listen("tcp", addr)
+}
+
+// idleTracker reports when no request has been in flight for timeout.
+type idleTracker struct {
+	timeout time.Duration
+	active  atomic.Int64
+	last    atomic.Int64 // unix nanoseconds of the last request end
+}
+
+// newIdleTracker returns nil (never idle) for a non-positive timeout.
+func newIdleTracker(timeout time.Duration) *idleTracker {
+	if timeout <= 0 {
+		return nil
+	}
+	t := &idleTracker{timeout: timeout}
+	t.last.Store(time.Now().UnixNano())
+	return t
+}
+
+// probeRoutes are polled by health checks and load balancers, so they
+// don't count as use.
+var probeRoutes = map[string]bool{"/health": true, "/readyz": true}
+
+func (t *idleTracker) wrap(h http.Handler) http.Handler {
+	if t == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if probeRoutes[r.URL.Path] {
+			h.ServeHTTP(w, r)
+			return
+		}
+		t.active.Add(1)
+		defer func() {
+			t.last.Store(time.Now().UnixNano())
+			t.active.Add(-1)
+		}()
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (t *idleTracker) idleSince(now time.Time) time.Duration {
+	if t.active.Load() > 0 {
+		return 0
+	}
+	return now.Sub(time.Unix(0, t.last.Load()))
+}
+
+// expired is closed once the server has been idle for the timeout. It never
+// fires for a nil tracker.
+func (t *idleTracker) expired() <-chan struct{} {
+	ch := make(chan struct{})
+	if t == nil {
+		return ch
+	}
+	interval := min(t.timeout/4, time.Minute)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			if t.idleSince(now) >= t.timeout {
+				close(ch)
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// defaultShutdownDrain is how long /readyz fails before the listeners
+// close, so load balancers stop routing new requests here first.
+const defaultShutdownDrain = 5 * time.Second
+
+// serveUntilSignal serves on ln until SIGINT/SIGTERM or the idle timeout
+// (shut down), or SIGHUP (restart with the binary now on disk, e.g. after
+// self-update). On a signal, draining is set and the server keeps serving
+// for drain (a second signal cuts that short); then in-flight requests get
+// up to grace to finish.
+func serveUntilSignal(srv *http.Server, ln net.Listener, grace, drain time.Duration, draining *atomic.Bool, idle *idleTracker) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	restart := make(chan bool, 1)
+	go func() {
+		var hup bool
+		select {
+		case sig := <-sigCh:
+			log.Printf("received %s, shutting down (drain %s, grace %s)", sig, drain, grace)
+			hup = sig == syscall.SIGHUP
+		case <-idle.expired():
+			// Nothing is routing requests here, so there is nothing to drain.
+			log.Printf("idle for %s, exiting", idle.timeout)
+			drain = 0
+		}
+		shutdown(srv, grace, drain, draining, sigCh)
+		restart <- hup
+	}()
+
+	if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+	if <-restart {
+		restartSelf()
+	}
+}
+
+// shutdown sets draining, waits out drain or the next value on skip, and
+// then shuts srv down, giving in-flight requests up to grace.
+func shutdown(srv *http.Server, grace, drain time.Duration, draining *atomic.Bool, skip <-chan os.Signal) {
+	draining.Store(true)
+	if drain > 0 {
+		timer := time.NewTimer(drain)
+		select {
+		case <-timer.C:
+		case sig := <-skip:
+			timer.Stop()
+			log.Printf("received %s, skipping the drain", sig)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("shutdown: %v", err)
+	}
+}
+
+// restartSelf replaces the process with a fresh copy of its executable.
+func restartSelf() {
+	exe, err := os.Executable()
+	if err != nil {
+		log.Fatalf("restart: %v", err)
+	}
+	log.Printf("restarting %s", exe)
+	if err := syscall.Exec(exe, os.Args, os.Environ()); err != nil {
+		log.Fatalf("restart: %v", err)
+	}
+}
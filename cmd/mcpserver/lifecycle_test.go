@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Test the idle tracker ignores time spent serving requests
+func TestIdleTracker(t *testing.T) {
+	if newIdleTracker(0) != nil {
+		t.Error("zero timeout should disable idle exit")
+	}
+
+	idle := newIdleTracker(40 * time.Millisecond)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	h := idle.wrap(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		close(started)
+		<-release
+	}))
+	go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	<-started
+
+	expired := idle.expired()
+	select {
+	case <-expired:
+		t.Fatal("expired while a request was in flight")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case <-expired:
+	case <-time.After(time.Second):
+		t.Fatal("not expired after the request finished")
+	}
+}
+
+// Test health probes don't keep the server from going idle
+func TestIdleTrackerIgnoresProbes(t *testing.T) {
+	idle := newIdleTracker(40 * time.Millisecond)
+	h := idle.wrap(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	time.Sleep(50 * time.Millisecond)
+	for _, path := range []string{"/health", "/readyz"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	if d := idle.idleSince(time.Now()); d < 40*time.Millisecond {
+		t.Errorf("idle for %s after probes, want the time since start", d)
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/models", nil))
+	if d := idle.idleSince(time.Now()); d >= 40*time.Millisecond {
+		t.Errorf("idle for %s after a request", d)
+	}
+}
+
+// Test shutdown fails readiness and keeps serving for the drain period
+// before closing the listeners, and a second signal skips the drain
+func TestShutdownDrain(t *testing.T) {
+	var draining atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if draining.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	done := make(chan struct{})
+	go func() {
+		shutdown(srv.Config, time.Second, 200*time.Millisecond, &draining, nil)
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("request while draining: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("readiness while draining = %d, want 503", resp.StatusCode)
+	}
+	select {
+	case <-done:
+		t.Fatal("shut down before the drain period ended")
+	case <-time.After(50 * time.Millisecond):
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("not shut down after the drain period")
+	}
+
+	skip := make(chan os.Signal, 1)
+	skip <- syscall.SIGTERM
+	start := time.Now()
+	shutdown(&http.Server{}, time.Second, time.Minute, &draining, skip)
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("second signal didn't skip the drain (took %s)", d)
+	}
+}
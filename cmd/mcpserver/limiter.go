@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// defaultMaxConcurrentRuns leaves runs unlimited unless the operator sets
+// MCP_MAX_CONCURRENT_RUNS.
+const defaultMaxConcurrentRuns = 0
+
+// Priority classes of opencode_run, highest first. When all slots are busy,
+// queued runs start in class order; interactive editor requests overtake
+// queued batch jobs.
+const (
+	priorityInteractive = "interactive"
+	priorityNormal      = "normal"
+	priorityBatch       = "batch"
+)
+
+var priorityRank = map[string]int{priorityInteractive: 0, priorityNormal: 1, priorityBatch: 2}
+
+// priorityAging moves a queued run up one class per interval waited, so
+// batch jobs aren't starved by a steady stream of interactive ones.
+const priorityAging = 30 * time.Second
+
+func validatePriority(p string) error {
+	if _, ok := priorityRank[p]; !ok && p != "" {
+		return fmt.Errorf("unknown priority %q (want %s, %s or %s)", p, priorityInteractive, priorityNormal, priorityBatch)
+	}
+	return nil
+}
+
+// runLimiter bounds how many opencode runs execute at once, queueing the
+// rest by priority. A nil limiter is unlimited.
+type runLimiter struct {
+	mu      sync.Mutex
+	limit   int
+	running int
+	queue   []*queuedRun
+	stats   map[string]*queueStats
+}
+
+type queuedRun struct {
+	class    string
+	enqueued time.Time
+	ready    chan struct{} // closed when the run gets a slot
+}
+
+// queueStats are the per-class counters reported in /status.
+type queueStats struct {
+	Waiting    int   `json:"waiting"`
+	Running    int   `json:"running"`
+	Started    int64 `json:"started"`
+	TotalWait  int64 `json:"-"` // milliseconds
+	AvgWaitMs  int64 `json:"avgWaitMs"`
+	MaxWaitMs  int64 `json:"maxWaitMs"`
+	LastWaitMs int64 `json:"lastWaitMs"`
+}
+
+func newRunLimiter(n int) *runLimiter {
+	if n <= 0 {
+		return nil
+	}
+	l := &runLimiter{limit: n, stats: make(map[string]*queueStats)}
+	for class := range priorityRank {
+		l.stats[class] = &queueStats{}
+	}
+	return l
+}
+
+// acquire blocks until a slot is free for a run of the given priority class
+// or ctx is done.
+func (l *runLimiter) acquire(ctx context.Context, class string) error {
+	return l.acquireNotify(ctx, class, nil)
+}
+
+// acquireNotify is acquire calling queued, if not nil, with the run's
+// place in the queue when it has to wait.
+func (l *runLimiter) acquireNotify(ctx context.Context, class string, queued func(position int)) error {
+	if l == nil {
+		return nil
+	}
+	if _, ok := priorityRank[class]; !ok {
+		class = priorityNormal
+	}
+	now := time.Now()
+	l.mu.Lock()
+	if l.running < l.limit && len(l.queue) == 0 {
+		l.start(class, 0)
+		l.mu.Unlock()
+		return nil
+	}
+	q := &queuedRun{class: class, enqueued: now, ready: make(chan struct{})}
+	l.queue = append(l.queue, q)
+	l.stats[class].Waiting++
+	position := len(l.queue)
+	l.mu.Unlock()
+	if queued != nil {
+		queued(position)
+	}
+
+	select {
+	case <-q.ready:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		for i, other := range l.queue {
+			if other == q {
+				l.queue = append(l.queue[:i], l.queue[i+1:]...)
+				l.stats[class].Waiting--
+				return ctx.Err()
+			}
+		}
+		// Granted a slot just as ctx ended: hand it on.
+		l.finish(class)
+		return ctx.Err()
+	}
+}
+
+// release frees the slot of a run of the given class.
+func (l *runLimiter) release(class string) {
+	if l == nil {
+		return
+	}
+	if _, ok := priorityRank[class]; !ok {
+		class = priorityNormal
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.finish(class)
+}
+
+// start records a run of class taking a slot after waiting wait. l.mu is held.
+func (l *runLimiter) start(class string, wait time.Duration) {
+	l.running++
+	s := l.stats[class]
+	s.Running++
+	s.Started++
+	ms := wait.Milliseconds()
+	s.TotalWait += ms
+	s.LastWaitMs = ms
+	s.MaxWaitMs = max(s.MaxWaitMs, ms)
+}
+
+// finish frees a slot and starts queued runs. l.mu is held.
+func (l *runLimiter) finish(class string) {
+	l.running--
+	l.stats[class].Running--
+	now := time.Now()
+	for l.running < l.limit && len(l.queue) > 0 {
+		best := 0
+		for i, q := range l.queue[1:] {
+			if effectiveRank(q, now) < effectiveRank(l.queue[best], now) {
+				best = i + 1 // the queue is in arrival order, so ties go to the earliest
+			}
+		}
+		q := l.queue[best]
+		l.queue = append(l.queue[:best], l.queue[best+1:]...)
+		l.stats[q.class].Waiting--
+		l.start(q.class, now.Sub(q.enqueued))
+		close(q.ready)
+	}
+}
+
+func effectiveRank(q *queuedRun, now time.Time) int {
+	return priorityRank[q.class] - int(now.Sub(q.enqueued)/priorityAging)
+}
+
+// queueStatus returns the per-class queue counters.
+func (l *runLimiter) queueStatus() map[string]queueStats {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make(map[string]queueStats, len(l.stats))
+	for class, s := range l.stats {
+		c := *s
+		if c.Started > 0 {
+			c.AvgWaitMs = c.TotalWait / c.Started
+		}
+		out[class] = c
+	}
+	return out
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"time"
+
+	"opencode-mcp/internal/runner"
+)
+
+// sessionEntry is one row of opencode_session_list structuredContent.
+type sessionEntry struct {
+	ID      string `json:"id"`
+	Title   string `json:"title"`
+	Updated string `json:"updated,omitempty"`
+}
+
+// agentEntry is one row of opencode_agent_list structuredContent.
+type agentEntry struct {
+	Name        string `json:"name"`
+	Mode        string `json:"mode,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+var (
+	columnSepRe  = regexp.MustCompile(`\s{2,}`)
+	agentLineRe  = regexp.MustCompile(`^(\S+)(?:\s+\(([^)]+)\))?(?:\s*(?::|\s-)\s*(.*))?$`)
+	ruleOnlyRe   = regexp.MustCompile(`^[\s\-─━=┄+|│]+$`)
+	sessionIDRe  = regexp.MustCompile(`^[A-Za-z0-9_\-]+$`)
+	agentNameRe  = regexp.MustCompile(`^[A-Za-z0-9_.\-]+$`)
+	listHeaderRe = regexp.MustCompile(`(?i)^(session\s+id|id\s+title|name\s+mode|agents?:|sessions?:)`)
+)
+
+// Output schemas advertised for the listing tools.
+var (
+	sessionListSchema = map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"sessions": map[string]any{
+				"type": "array",
+				"items": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"id":      map[string]any{"type": "string"},
+						"title":   map[string]any{"type": "string"},
+						"updated": map[string]any{"type": "string"},
+					},
+					"required": []string{"id", "title"},
+				},
+			},
+		},
+		"required": []string{"sessions"},
+	}
+	agentListSchema = map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"agents": map[string]any{
+				"type": "array",
+				"items": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"name":        map[string]any{"type": "string"},
+						"mode":        map[string]any{"type": "string"},
+						"description": map[string]any{"type": "string"},
+					},
+					"required": []string{"name"},
+				},
+			},
+		},
+		"required": []string{"agents"},
+	}
+)
+
+// structuredListing parses the plain output of a listing tool into
+// structuredContent. ok is false when the output can't be parsed, in which
+// case the tool falls back to returning the raw text only.
+func structuredListing(tool string, lines []string) (any, bool) {
+	switch tool {
+	case toolSessionList:
+		sessions, ok := parseSessionList(lines)
+		if !ok {
+			return nil, false
+		}
+		return map[string]any{"sessions": sessions}, true
+	case toolAgentList:
+		agents, ok := parseAgentList(lines)
+		if !ok {
+			return nil, false
+		}
+		return map[string]any{"agents": agents}, true
+	}
+	return nil, false
+}
+
+// parseSessionList parses `session list` output: either a JSON array or a
+// table of "ID  Title  Updated" columns separated by two or more spaces.
+func parseSessionList(lines []string) ([]sessionEntry, bool) {
+	text := strings.TrimSpace(runner.StripANSI(strings.Join(lines, "\n")))
+	if text == "" {
+		return []sessionEntry{}, true
+	}
+	if strings.HasPrefix(text, "[") {
+		var raw []serveSession
+		if err := json.Unmarshal([]byte(text), &raw); err != nil {
+			return nil, false
+		}
+		sessions := make([]sessionEntry, 0, len(raw))
+		for _, s := range raw {
+			sessions = append(sessions, newSessionEntry(s))
+		}
+		return sessions, true
+	}
+
+	sessions := []sessionEntry{}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || ruleOnlyRe.MatchString(line) || listHeaderRe.MatchString(line) {
+			continue
+		}
+		cols := columnSepRe.Split(line, -1)
+		if !sessionIDRe.MatchString(cols[0]) {
+			return nil, false
+		}
+		s := sessionEntry{ID: cols[0]}
+		if len(cols) > 1 {
+			s.Title = cols[1]
+		}
+		if len(cols) > 2 {
+			s.Updated = strings.Join(cols[2:], " ")
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, true
+}
+
+// parseAgentList parses `agent list` output: one "name (mode)" line per
+// agent, optionally followed by ": description". Indented lines (permission
+// details) are skipped.
+func parseAgentList(lines []string) ([]agentEntry, bool) {
+	agents := []agentEntry{}
+	for _, line := range lines {
+		line = runner.StripANSI(line)
+		if strings.TrimSpace(line) == "" || line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		line = strings.TrimSpace(line)
+		if ruleOnlyRe.MatchString(line) || listHeaderRe.MatchString(line) {
+			continue
+		}
+		m := agentLineRe.FindStringSubmatch(line)
+		if m == nil || !agentNameRe.MatchString(m[1]) {
+			return nil, false
+		}
+		agents = append(agents, agentEntry{Name: m[1], Mode: m[2], Description: strings.TrimSpace(m[3])})
+	}
+	return agents, true
+}
+
+func newSessionEntry(s serveSession) sessionEntry {
+	entry := sessionEntry{ID: s.ID, Title: s.Title}
+	if s.Time.Updated > 0 {
+		entry.Updated = localTime(time.UnixMilli(s.Time.Updated)).Format(time.RFC3339)
+	}
+	return entry
+}
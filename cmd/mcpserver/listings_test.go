@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test parseSessionList
+func TestParseSessionList(t *testing.T) {
+	table := strings.Split(`Session ID                      Title                           Updated
+───────────────────────────────────────────────────────────────────────────────
+ses_abc123                      Fix login bug                   10:32 AM · 10/5/2025
+ses_def456                      Refactor parser                 9:01 AM · 10/4/2025`, "\n")
+
+	got, ok := parseSessionList(table)
+	if !ok {
+		t.Fatal("parseSessionList() failed on table output")
+	}
+	want := []sessionEntry{
+		{ID: "ses_abc123", Title: "Fix login bug", Updated: "10:32 AM · 10/5/2025"},
+		{ID: "ses_def456", Title: "Refactor parser", Updated: "9:01 AM · 10/4/2025"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sessions = %+v, want %+v", got, want)
+	}
+
+	got, ok = parseSessionList([]string{`[{"id":"ses_1","title":"JSON","time":{"updated":0}}]`})
+	if !ok || len(got) != 1 || got[0].Title != "JSON" {
+		t.Errorf("JSON output = %+v, %v", got, ok)
+	}
+
+	if _, ok := parseSessionList([]string{"Error: no project found at /tmp"}); ok {
+		t.Error("expected parse failure for an error message")
+	}
+}
+
+// Test parseAgentList
+func TestParseAgentList(t *testing.T) {
+	lines := []string{
+		"build (primary)",
+		`  [{"permission":"edit","action":"allow"}]`,
+		"plan (primary): Read-only planning",
+		"general (subagent) - General purpose",
+		"",
+	}
+	got, ok := parseAgentList(lines)
+	if !ok {
+		t.Fatal("parseAgentList() failed")
+	}
+	want := []agentEntry{
+		{Name: "build", Mode: "primary"},
+		{Name: "plan", Mode: "primary", Description: "Read-only planning"},
+		{Name: "general", Mode: "subagent", Description: "General purpose"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("agents = %+v, want %+v", got, want)
+	}
+
+	if _, ok := parseAgentList([]string{"something went wrong!"}); ok {
+		t.Error("expected parse failure for unstructured output")
+	}
+}
+
+// Test structuredContent on the CLI backend, with raw text fallback
+func TestListingStructuredContent(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "opencode")
+	content := `#!/bin/sh
+case "$1" in
+session) echo "ses_1  First session  today" ;;
+agent) echo "weird output!" ;;
+esac
+`
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	tools := newToolHandler(serverConfig{Target: script, DefaultTimeout: 5 * time.Second})
+	call := func(name string) *toolCallResult {
+		result, mErr := tools(context.Background(), &toolCall{ID: json.RawMessage("1"), Name: name, Arguments: json.RawMessage(`{}`)})
+		if mErr != nil {
+			t.Fatalf("unexpected error: %v", mErr)
+		}
+		return result
+	}
+
+	sessions := call(toolSessionList)
+	b, _ := json.Marshal(sessions.StructuredContent)
+	if string(b) != `{"sessions":[{"id":"ses_1","title":"First session","updated":"today"}]}` {
+		t.Errorf("structuredContent = %s", b)
+	}
+
+	agents := call(toolAgentList)
+	if agents.StructuredContent != nil {
+		t.Errorf("expected raw fallback, got %v", agents.StructuredContent)
+	}
+	if !strings.Contains(agents.Content[0].Text, "weird output!") {
+		t.Errorf("text = %q", agents.Content[0].Text)
+	}
+}
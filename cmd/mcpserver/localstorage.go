@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// opencode keeps its state as JSON files under its data directory:
+//
+//	storage/project/<projectID>.json             {"id", "worktree", ...}
+//	storage/session/<projectID>/<sessionID>.json {"id", "title", "parentID", "time": {...}, ...}
+//
+// With MCP_OPENCODE_STORAGE set, opencode_session_list reads these directly,
+// which is fast and works without spawning the CLI. Anything unexpected
+// (missing directory, unknown layout, no project for the cwd) falls back to
+// the CLI, so a changed storage schema costs speed, not correctness.
+
+var errLocalStorage = errors.New("opencode storage unavailable")
+
+// localStorageDir resolves MCP_OPENCODE_STORAGE: "auto" is opencode's
+// default location under $XDG_DATA_HOME (~/.local/share); "" disables it.
+func localStorageDir(value string) string {
+	if value != "auto" {
+		return value
+	}
+	data := os.Getenv("XDG_DATA_HOME")
+	if data == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		data = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(data, "opencode", "storage")
+}
+
+type localProject struct {
+	ID       string `json:"id"`
+	Worktree string `json:"worktree"`
+}
+
+type localSession struct {
+	serveSession
+	ParentID string `json:"parentID"`
+}
+
+// localSessions lists the top-level sessions of the project containing
+// dir, most recently updated first, like `opencode session list` run in
+// dir. Errors wrap errLocalStorage.
+func localSessions(root, dir string) ([]serveSession, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errLocalStorage, err)
+	}
+	project, err := localProjectFor(root, dir)
+	if err != nil {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(root, "session", project.ID, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errLocalStorage, err)
+	}
+	sessions := []serveSession{}
+	for _, f := range files {
+		var s localSession
+		if err := readLocalJSON(f, &s); err != nil {
+			return nil, err
+		}
+		if s.ID == "" || s.Time.Created == 0 {
+			return nil, fmt.Errorf("%w: unknown session schema in %s", errLocalStorage, f)
+		}
+		if s.ParentID == "" {
+			sessions = append(sessions, s.serveSession)
+		}
+	}
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].Time.Updated > sessions[j].Time.Updated
+	})
+	return sessions, nil
+}
+
+// localProjectFor returns the project whose worktree is the deepest one
+// containing dir.
+func localProjectFor(root, dir string) (localProject, error) {
+	files, err := filepath.Glob(filepath.Join(root, "project", "*.json"))
+	if err != nil || len(files) == 0 {
+		return localProject{}, fmt.Errorf("%w: no projects under %s", errLocalStorage, root)
+	}
+	var best localProject
+	for _, f := range files {
+		var p localProject
+		if err := readLocalJSON(f, &p); err != nil {
+			return localProject{}, err
+		}
+		if p.ID == "" || p.Worktree == "" {
+			return localProject{}, fmt.Errorf("%w: unknown project schema in %s", errLocalStorage, f)
+		}
+		wt := filepath.Clean(p.Worktree)
+		if (dir == wt || strings.HasPrefix(dir, wt+string(filepath.Separator))) && len(wt) > len(best.Worktree) {
+			best = localProject{ID: p.ID, Worktree: wt}
+		}
+	}
+	if best.ID == "" {
+		return localProject{}, fmt.Errorf("%w: no project contains %s", errLocalStorage, dir)
+	}
+	return best, nil
+}
+
+func readLocalJSON(path string, v any) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errLocalStorage, err)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%w: %s: %v", errLocalStorage, path, err)
+	}
+	return nil
+}
+
+// localSessionList answers opencode_session_list from opencode's storage,
+// reporting false (use the CLI) when it can't.
+func localSessionList(cfg serverConfig, call *toolCall) (*toolCallResult, bool) {
+	dir := call.Cwd
+	if dir == "" {
+		dir = "."
+	}
+	if validateCwd(dir) != nil || cfg.Policy.checkDir(dir) != nil {
+		return nil, false // the CLI path reports the error
+	}
+	sessions, err := localSessions(cfg.OpencodeStorage, dir)
+	if err != nil {
+		log.Printf("[storage] %v; falling back to the CLI", err)
+		return nil, false
+	}
+	log.Printf("[storage] session list: %d sessions from %s", len(sessions), cfg.OpencodeStorage)
+	return sessionListResult(call, sessions), true
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test session lists are read from opencode's storage, and fall back to the
+// CLI when its layout is unknown
+func TestLocalSessionList(t *testing.T) {
+	root := t.TempDir()
+	repo := filepath.Join(t.TempDir(), "repo")
+	if err := os.MkdirAll(filepath.Join(repo, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	write := func(rel, content string) {
+		t.Helper()
+		path := filepath.Join(root, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("project/p1.json", `{"id":"p1","worktree":"`+repo+`"}`)
+	write("project/p2.json", `{"id":"p2","worktree":"/elsewhere"}`)
+	write("session/p1/ses_a.json", `{"id":"ses_a","title":"older","time":{"created":1,"updated":1000}}`)
+	write("session/p1/ses_b.json", `{"id":"ses_b","title":"newer","time":{"created":2,"updated":2000}}`)
+	write("session/p1/ses_c.json", `{"id":"ses_c","title":"subagent","parentID":"ses_b","time":{"created":3,"updated":3000}}`)
+	write("session/p2/ses_d.json", `{"id":"ses_d","title":"other project","time":{"created":4,"updated":4000}}`)
+
+	cfg := serverConfig{Target: "/nonexistent/opencode", OpencodeStorage: root}
+	result, mErr := dispatchTool(cfg)(context.Background(), &toolCall{ID: json.RawMessage("1"), Name: toolSessionList, Arguments: json.RawMessage(`{}`), Cwd: filepath.Join(repo, "sub")})
+	if mErr != nil || result.IsError {
+		t.Fatalf("result = %+v, %v", result, mErr)
+	}
+	entries := result.StructuredContent.(map[string]any)["sessions"].([]sessionEntry)
+	if len(entries) != 2 || entries[0].ID != "ses_b" || entries[1].Title != "older" {
+		t.Errorf("sessions = %+v, want ses_b then ses_a", entries)
+	}
+
+	if _, err := localSessions(root, t.TempDir()); !errors.Is(err, errLocalStorage) || !strings.Contains(err.Error(), "no project") {
+		t.Errorf("outside any project: err = %v", err)
+	}
+	write("session/p1/ses_e.json", `{"sessionId":"ses_e"}`)
+	if _, err := localSessions(root, repo); !errors.Is(err, errLocalStorage) {
+		t.Errorf("unknown schema: err = %v", err)
+	}
+	if _, ok := localSessionList(cfg, &toolCall{Name: toolSessionList, Cwd: repo}); ok {
+		t.Error("unknown schema should fall back to the CLI")
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Some proxies strip both SSE and WebSocket, or buffer a stream until it
+// ends. POST /mcp/poll takes the same requests as /mcp, but answers a
+// tools/call with its events instead of a stream: the server holds the
+// request until there are events after the client's Last-Event-ID, the call
+// is done, or MCP_POLL_WAIT elapses. The client then polls again with the
+// same request, idempotency key and the last event ID it got. Polled calls
+// are idempotent calls, so the events come from the history /resume reads.
+const (
+	pollPath        = "/mcp/poll"
+	defaultPollWait = 25 * time.Second
+)
+
+// handleToolsCallPoll starts a tools/call, or finds the one an earlier poll
+// with the same idempotency key started, and answers with its state after
+// req.LastEventID.
+func handleToolsCallPoll(w http.ResponseWriter, ctx context.Context, tools toolHandler, cfg serverConfig, req mcpRequest) {
+	call, mErr := newToolCall(req)
+	if mErr != nil {
+		writeError(w, req.ID, mErr)
+		return
+	}
+	if call.IdempotencyKey == "" {
+		call.IdempotencyKey = newPollKey()
+	}
+	w.Header().Set("Idempotency-Key", call.IdempotencyKey)
+	wait := cfg.PollWait
+	if wait <= 0 {
+		wait = defaultPollWait
+	}
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+
+	entry := cfg.Idempotency.get(tenantOrDefault(call.Tenant)+"/"+call.IdempotencyKey, time.Now())
+	switch {
+	case entry == nil:
+		if entry, mErr = startPolledCall(ctx, tools, call); mErr != nil {
+			writeError(w, req.ID, mErr)
+			return
+		}
+		log.Printf("[poll] key=%s tool=%s: started", call.IdempotencyKey, call.Name)
+	case entry.fingerprint != callFingerprint(call.Name, call.Arguments):
+		writeError(w, req.ID, errIdempotencyConflict.err("idempotency key was already used for a different request"))
+		return
+	}
+
+	entry.waitAfter(ctx, call.LastEventID)
+	state := entry.resumeState(call.LastEventID)
+	state["idempotencyKey"] = call.IdempotencyKey
+	writeJSON(w, http.StatusOK, state)
+}
+
+// startPolledCall runs call in the background and returns its idempotency
+// entry, or the error that rejected it before it was registered. The run
+// outlives ctx; only a call that found another poll's entry stops with it.
+func startPolledCall(ctx context.Context, tools toolHandler, call *toolCall) (*idempotentCall, *mcpError) {
+	claimed := make(chan *idempotentCall, 1)
+	call.claimed = func(entry *idempotentCall) { claimed <- entry }
+	finished := make(chan *mcpError, 1)
+	go func() {
+		_, mErr := tools(ctx, call)
+		finished <- mErr
+	}()
+	select {
+	case entry := <-claimed:
+		return entry, nil
+	case mErr := <-finished:
+		select {
+		case entry := <-claimed:
+			return entry, nil
+		default:
+		}
+		if mErr == nil {
+			mErr = errInternal.err("call was not registered")
+		}
+		return nil, mErr
+	}
+}
+
+func newPollKey() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test a tools/call over /mcp/poll is followed to its result by re-polling
+// with the last event ID
+func TestPollToolsCall(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "opencode")
+	events := `#!/bin/sh
+echo '{"type":"text","part":{"text":"first"}}'
+sleep 0.3
+echo '{"type":"text","part":{"text":"second"}}'
+`
+	if err := os.WriteFile(script, []byte(events), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	cfg := serverConfig{Target: script, DefaultTimeout: 5 * time.Second, Idempotency: newIdempotencyCache(), PollWait: 2 * time.Second}
+	handler := createMCPHandler(&sessionStore{sessions: make(map[string]*session)}, cfg)
+	body := `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"opencode_run","arguments":{"message":"hi"}}}`
+
+	key, lastID := "", 0
+	for polls := 1; ; polls++ {
+		if polls > 20 {
+			t.Fatal("call not done after 20 polls")
+		}
+		r := httptest.NewRequest(http.MethodPost, pollPath, strings.NewReader(body))
+		if key != "" {
+			r.Header.Set("Idempotency-Key", key)
+			r.Header.Set("Last-Event-ID", strconv.Itoa(lastID))
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		var resp struct {
+			Status         string          `json:"status"`
+			Events         []callEvent     `json:"events"`
+			LastEventID    int             `json:"lastEventId"`
+			IdempotencyKey string          `json:"idempotencyKey"`
+			Result         *toolCallResult `json:"result"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("poll %d: %v: %s", polls, err, w.Body)
+		}
+		if key == "" {
+			key = w.Header().Get("Idempotency-Key")
+			if key == "" || resp.IdempotencyKey != key {
+				t.Fatalf("first poll: key header %q, body %q", key, resp.IdempotencyKey)
+			}
+		}
+		for _, ev := range resp.Events {
+			if ev.ID <= lastID {
+				t.Errorf("poll %d: event %d again after %d", polls, ev.ID, lastID)
+			}
+		}
+		lastID = resp.LastEventID
+		if resp.Status == "done" {
+			if resp.Result == nil || !strings.Contains(resp.Result.Content[0].Text, "second") {
+				t.Errorf("result = %s", w.Body)
+			}
+			break
+		}
+		if len(resp.Events) == 0 {
+			t.Errorf("poll %d returned while running without events", polls)
+		}
+	}
+}
+
+// Test calls rejected before they start are JSON-RPC errors
+func TestPollInvalidCall(t *testing.T) {
+	cfg := serverConfig{Idempotency: newIdempotencyCache(), PollWait: time.Second}
+	handler := createMCPHandler(&sessionStore{sessions: make(map[string]*session)}, cfg)
+	body := `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"opencode_run","arguments":{"message":"hi","bogus":1}}}`
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodPost, pollPath, strings.NewReader(body)))
+	var resp mcpResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Error == nil {
+		t.Errorf("response = %s", w.Body)
+	}
+}
@@ -1,9 +1,9 @@
 package main
 
 import (
-	"bufio"
 	"context"
 	"crypto/rand"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -13,9 +13,14 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"opencode-mcp/internal/mcp"
+	"opencode-mcp/internal/throttle"
 )
 
 const (
@@ -25,79 +30,172 @@ const (
 	defaultModel      = "github-copilot/gpt-5.2-codex" // Default model - Codex 5.2
 )
 
-// Available models cache
-var (
-	availableModels     []string
-	availableModelsOnce sync.Once
-	modelCacheMu        sync.RWMutex
-	modelCacheTime      time.Time
-	modelCacheTTL       = 5 * time.Minute
-)
+// version is set at build time (-ldflags "-X main.version=..."); when empty
+// the version comes from the build info.
+var version string
+
+// serverVersion is the version of this build, see mcp.BuildVersion.
+var serverVersion = mcp.BuildVersion(version)
 
 type serverConfig struct {
-	Addr           string
-	Target         string
-	DefaultTimeout time.Duration
-	DefaultModel   string
+	Addr            string
+	Target          string
+	Binaries        *binarySwitch // active opencode binary; swappable at runtime
+	DefaultTimeout  time.Duration
+	DefaultModel    string
+	Backend         string
+	CLIVersion      string        // detected at startup; selects the event adapter
+	StrictEvents    bool          // fail runs whose output matches no known event schema
+	RequireSession  bool          // reject non-initialize requests without an Mcp-Session-Id
+	PromptAnalytics bool          // record prompt features (never text) for /admin/analytics
+	TrustTenant     bool          // MCP_TRUST_TENANT_HEADER: believe X-MCP-Tenant from an authenticating proxy
+	MaxMessageChars int           // MCP_MAX_MESSAGE_CHARS: cap on an opencode_run message plus attachments; 0 is unlimited
+	Oversize        string        // MCP_OVERSIZE: reject or split messages over MaxMessageChars
+	BinaryOutput    string        // MCP_BINARY_OUTPUT: resource (base64) or text for binary command output
+	NotifyRate      int           // max notifications per second per session; 0 is unlimited
+	PollWait        time.Duration // MCP_POLL_WAIT: how long /mcp/poll holds a request without new events
+	StallTimeout    time.Duration // MCP_STALL_TIMEOUT: how long a child may print nothing; 0 disables the watchdog
+	Compression     string        // MCP_COMPRESSION: gzip or off for stored transcripts and artifacts
+	SessionTTL      time.Duration // MCP_SESSION_TTL: how long an idle session lives; 0 keeps sessions until deleted
+	ShutdownDrain   time.Duration // MCP_SHUTDOWN_DRAIN: how long /readyz fails before the listeners close
+	Stdin           stdinConfig   // MCP_STDIN_MAX_BYTES and MCP_STDIN_TIMEOUT: limits on the stdin of exec calls
+	Attach          attachConfig  // MCP_ATTACH_ALLOW_HOSTS and MCP_ATTACH_MAX_BYTES: fetching URL attachments
+	Events          *eventBus     // the changefeed of runs, sessions and denials
+	RouteTimeouts   routeTimeouts // MCP_ROUTE_TIMEOUTS: read, handler and slow-log thresholds by route class
+	ServeURL        string
+	OpencodeStorage string      // opencode's storage directory, read for session lists; "" to always ask opencode
+	Limiter         *runLimiter // bounds concurrent opencode_run executions
+	Idempotency     *idempotencyCache
+	Dedupe          *dedupeCache // shares identical concurrent opencode_run calls
+	Running         *runningRuns // opencode_run calls in progress, for cancelling by run ID
+	Metrics         *runMetrics  // resource usage of opencode processes
+	CustomTools     []customTool
+	Plugins         []pluginConfig
+	PluginTools     []pluginTool
+	Servers         []downstreamServer // downstream MCP servers whose tools are re-exported
+	ServerTools     []downstreamTool
+	Pricing         map[string]modelCost
+	Tenants         map[string]tenantPolicy
+	Policy          tenantPolicy        // the current tenant's policy; set by forTenant
+	Store           *store              // prompts and other persisted state
+	PromptTemplates []promptTemplate    // built-in and MCP_PROMPTS_DIR prompts expanding into opencode_run
+	ProjectConfig   map[string]project  // projects of the config file
+	DisabledTools   []string            // tool name patterns of the config file
+	ToolToggles     *toolToggles        // which tools are enabled; nil enables all
+	Transcripts     *transcriptFeed     // transcripts of runs in progress, and their subscribers
+	Projects        *projectRegistry    // named projects for the "project" argument
+	Workspaces      *workspaceCache     // clones for the "repo" argument; nil unless MCP_WORKSPACE_DIR is set
+	Warmup          *warmupCache        // runs the projects' warmup commands; nil when MCP_WARMUP_INTERVAL is 0
+	Egress          *egressProxy        // enforces tenants' egress policies
+	Proxy           *proxySettings      // proxy and provider URLs for opencode processes; the tenant's after forTenant
+	Agent           string              // "opencode" or a wrapped agent CLI; the tenant's after forTenant
+	AgentTargets    map[string]string   // agent name -> binary path
+	Notifications   *notificationConfig // routes events to notification channels per client
+	Locale          string              // MCP_LOCALE: language of messages for clients without Accept-Language
+	ServerInfo      mcp.ServerInfo      // MCP_SERVER_NAME and MCP_SERVER_VERSION: serverInfo of the initialize result
+	Instructions    string              // MCP_INSTRUCTIONS: guidance for the client's model in the initialize result
+	Artifacts       artifactConfig
+	Disk            diskConfig
+	Signer          *signer      // signs run manifests; nil unless MCP_SIGNING_KEY is set
+	Chaos           *chaosConfig // fault injection for client testing; nil unless MCP_CHAOS is set
 }
 
+// mcpRequest is a JSON-RPC message with what the HTTP transport knows of
+// it; handlers on the dispatcher find it with requestFrom.
 type mcpRequest struct {
-	JSONRPC string          `json:"jsonrpc"`
-	Method  string          `json:"method"`
-	Params  json.RawMessage `json:"params"`
-	ID      any             `json:"id"`
-	Cwd     string          `json:"cwd,omitempty"`
+	mcp.Request
+	Cwd string `json:"cwd,omitempty"`
+
+	// A response to a request of the server (elicitation/create) has no
+	// method but a result or an error.
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *mcp.Error      `json:"error,omitempty"`
+
+	// Set by the transport, not decoded from the request.
+	Tenant         string            `json:"-"`
+	Session        *session          `json:"-"`
+	IdempotencyKey string            `json:"-"` // Idempotency-Key header
+	LastEventID    int               `json:"-"` // Last-Event-ID header of a resuming SSE client
+	Streaming      bool              `json:"-"` // the client accepts an event stream
+	Locale         string            `json:"-"` // language of server-generated messages, from Accept-Language
+	Routes         eventRoutes       `json:"-"` // notification routes for the session's client
+	Preferences    clientPreferences `json:"-"` // saved for the caller's API key
+
+	NotifyLimiter *throttle.Limiter `json:"-"` // nil when notifications are unlimited
 }
 
 type mcpResponse struct {
-	JSONRPC string    `json:"jsonrpc"`
-	ID      any       `json:"id"`
-	Result  any       `json:"result,omitempty"`
-	Error   *mcpError `json:"error,omitempty"`
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"` // null when nil
+	Result  any             `json:"result,omitempty"`
+	Error   *mcpError       `json:"error,omitempty"`
 }
 
 type mcpError struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
+	Code    int        `json:"code"`
+	Message string     `json:"message"`
+	Data    *errorData `json:"data,omitempty"` // catalogue code, see errors.go
 }
 
-type mcpTool struct {
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	InputSchema any    `json:"inputSchema"`
-}
+type mcpTool = mcp.Tool
 
 type toolsListResult struct {
-	Tools []mcpTool `json:"tools"`
+	Tools      []mcpTool `json:"tools"`
+	NextCursor string    `json:"nextCursor,omitempty"`
 }
 
 type toolCallParams struct {
 	Name      string          `json:"name"`
 	Arguments json.RawMessage `json:"arguments"`
+	Meta      struct {
+		IdempotencyKey string          `json:"idempotencyKey"`
+		ProgressToken  json.RawMessage `json:"progressToken"`
+	} `json:"_meta"`
 }
 
-type toolContent struct {
-	Type string `json:"type"`
-	Text string `json:"text,omitempty"`
-}
+type toolContent = mcp.Content
 
 type toolCallResult struct {
-	Content []toolContent `json:"content"`
-	IsError bool          `json:"isError,omitempty"`
+	Content           []toolContent  `json:"content"`
+	StructuredContent any            `json:"structuredContent,omitempty"`
+	IsError           bool           `json:"isError,omitempty"`
+	Meta              map[string]any `json:"_meta,omitempty"` // "error" holds the catalogue code of a failed run
+
+	// Not serialized; used by tools that compose runs.
+	sessionID    string // session the run executed in
+	answer       string // assistant text without tool outputs or stderr
+	usage        runUsage
+	exitCode     int
+	toolOutputs  []runToolOutput
+	resources    *resourceUsage // of the opencode process, when one ran
+	artifacts    []artifactInfo
+	manifest     *runManifest
+	attestation  *attestation
+	reproduction *reproduction // the CLI invocation, when one ran
+	stall        *stallReport  // set when the watchdog stopped the run
+
+	transcript []transcriptEntry
 }
 
 type execArgs struct {
-	Args  []string `json:"args"`
-	Cwd   string   `json:"cwd,omitempty"`
-	Stdin string   `json:"stdin,omitempty"`
+	Args        []string `json:"args"`
+	Cwd         string   `json:"cwd,omitempty"`
+	Stdin       string   `json:"stdin,omitempty"`
+	StdinBase64 string   `json:"stdinBase64,omitempty"` // binary stdin, instead of Stdin
 }
 
 type execResponse struct {
-	OK       bool   `json:"ok"`
-	Stdout   string `json:"stdout,omitempty"`
+	OK     bool   `json:"ok"`
+	Stdout string `json:"stdout,omitempty"`
+
+	// Binary stdout, when MCP_BINARY_OUTPUT is resource
+	StdoutBase64   string `json:"stdoutBase64,omitempty"`
+	StdoutMimeType string `json:"stdoutMimeType,omitempty"`
+
 	Stderr   string `json:"stderr,omitempty"`
 	ExitCode int    `json:"exitCode,omitempty"`
 	Error    string `json:"error,omitempty"`
+	Code     string `json:"code,omitempty"` // catalogue code when not OK
 }
 
 type jsonResponseWriter struct {
@@ -118,34 +216,225 @@ func (j jsonResponseWriter) Write(p []byte) (int, error) {
 
 // Tool names
 const (
-	toolExec        = "opencode_exec"
-	toolRun         = "opencode_run"
-	toolModels      = "opencode_models"
-	toolSessionList = "opencode_session_list"
-	toolAgentList   = "opencode_agent_list"
+	toolExec         = mcp.ToolExec
+	toolRun          = mcp.ToolRun
+	toolModels       = mcp.ToolModels
+	toolModelInfo    = "opencode_model_info"
+	toolSessionList  = "opencode_session_list"
+	toolAgentList    = "opencode_agent_list"
+	toolFanout       = "opencode_fanout"
+	toolPipeline     = "opencode_pipeline"
+	toolCompare      = "opencode_compare"
+	toolEstimate     = "opencode_estimate"
+	toolPromptSave   = "opencode_prompt_save"
+	toolPromptDelete = "opencode_prompt_delete"
+	toolPromptList   = "opencode_prompt_list"
+	toolHistory      = "opencode_history"
 )
 
 func main() {
+	if isMockRunner(os.Args[0]) {
+		os.Exit(runMockRunner(os.Args[1:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == netnsHelper {
+		os.Exit(runNetnsHelper(os.Args[2:]))
+	}
+	if err := configureTimestamps(os.Getenv("MCP_TIMEZONE")); err != nil {
+		log.Printf("[config] MCP_TIMEZONE: %v; using UTC", err)
+	}
+	if runSubcommand(os.Args[1:]) {
+		return
+	}
+	runServer(getenvDuration("MCP_IDLE_EXIT", 0))
+}
+
+// configFromEnv builds the server configuration from MCP_* variables.
+func configFromEnv() serverConfig {
 	cfg := serverConfig{
-		Addr:           getenv("MCP_ADDR", defaultAddr),
-		Target:         getenv("MCP_TARGET", defaultTarget),
-		DefaultTimeout: time.Duration(getenvInt("MCP_TIMEOUT_SEC", defaultTimeoutSec)) * time.Second,
-		DefaultModel:   getenv("MCP_DEFAULT_MODEL", defaultModel),
+		Addr:            getenv("MCP_ADDR", defaultAddr),
+		Target:          getenv("MCP_TARGET", defaultTarget),
+		DefaultTimeout:  time.Duration(getenvInt("MCP_TIMEOUT_SEC", defaultTimeoutSec)) * time.Second,
+		DefaultModel:    getenv("MCP_DEFAULT_MODEL", defaultModel),
+		Backend:         getenv("MCP_BACKEND", backendCLI),
+		ServeURL:        getenv("MCP_SERVE_URL", defaultServeURL),
+		StrictEvents:    getenvBool("MCP_STRICT_EVENTS", false),
+		RequireSession:  getenvBool("MCP_REQUIRE_SESSION", false),
+		PromptAnalytics: getenvBool("MCP_PROMPT_ANALYTICS", false),
+		TrustTenant:     getenvBool("MCP_TRUST_TENANT_HEADER", false),
+		MaxMessageChars: getenvInt("MCP_MAX_MESSAGE_CHARS", 0),
+		Oversize:        getenv("MCP_OVERSIZE", oversizeReject),
+		BinaryOutput:    getenv("MCP_BINARY_OUTPUT", binaryOutputResource),
+		NotifyRate:      getenvInt("MCP_NOTIFY_RATE", 0),
+		PollWait:        getenvDuration("MCP_POLL_WAIT", defaultPollWait),
+		StallTimeout:    getenvDuration("MCP_STALL_TIMEOUT", defaultStallTimeout),
+		Compression:     getenv("MCP_COMPRESSION", compressionGzip),
+		SessionTTL:      getenvDuration("MCP_SESSION_TTL", defaultSessionTTL),
+		ShutdownDrain:   getenvDuration("MCP_SHUTDOWN_DRAIN", defaultShutdownDrain),
+		Stdin:           stdinConfig{MaxBytes: getenvInt("MCP_STDIN_MAX_BYTES", defaultStdinMaxBytes), Timeout: getenvDuration("MCP_STDIN_TIMEOUT", defaultStdinTimeout)},
+		Attach:          attachConfig{MaxBytes: getenvInt("MCP_ATTACH_MAX_BYTES", defaultAttachMaxBytes)},
+		OpencodeStorage: localStorageDir(os.Getenv("MCP_OPENCODE_STORAGE")),
+		Workspaces:      newWorkspaceCache(os.Getenv("MCP_WORKSPACE_DIR"), os.Getenv("MCP_GIT_SSH_KEY")),
+		Egress:          newEgressProxy(),
+		Warmup:          newWarmupCache(getenvDuration("MCP_WARMUP_INTERVAL", defaultWarmupInterval)),
+		Limiter:         newRunLimiter(getenvInt("MCP_MAX_CONCURRENT_RUNS", defaultMaxConcurrentRuns)),
+		Idempotency:     newIdempotencyCache(),
+		Dedupe:          newDedupeCache(getenvDuration("MCP_DEDUPE_WINDOW", defaultDedupeWindow)),
+		Running:         newRunningRuns(),
+		Metrics:         &runMetrics{},
+		Events:          newEventBus(),
+		Transcripts:     newTranscriptFeed(),
+		Locale:          getenv("MCP_LOCALE", defaultLocale),
+		ServerInfo:      mcp.ServerInfo{Name: getenv("MCP_SERVER_NAME", mcp.ServerName), Version: getenv("MCP_SERVER_VERSION", serverVersion)},
+		Instructions:    os.Getenv("MCP_INSTRUCTIONS"),
+	}
+	cfg.Artifacts = artifactConfig{
+		Root:      os.Getenv("MCP_ARTIFACT_DIR"),
+		MaxBytes:  int64(getenvInt("MCP_ARTIFACT_MAX_MB", defaultArtifactMaxBytes>>20)) << 20,
+		Retention: time.Duration(getenvInt("MCP_ARTIFACT_RETENTION_HOURS", int(defaultArtifactRetention/time.Hour))) * time.Hour,
+		PublicURL: getenv("MCP_PUBLIC_URL", defaultPublicURL(cfg.Addr)),
+
+		TotalMaxBytes: int64(getenvInt("MCP_ARTIFACT_TOTAL_MAX_MB", defaultArtifactTotalMB)) << 20,
+		Compress:      cfg.Compression == compressionGzip,
+	}
+	cfg.Disk = diskConfig{
+		MinFreeBytes:       int64(getenvInt("MCP_DISK_MIN_FREE_MB", defaultDiskMinFreeMB)) << 20,
+		WarnFreeBytes:      int64(getenvInt("MCP_DISK_WARN_FREE_MB", defaultDiskWarnFreeMB)) << 20,
+		TranscriptMaxBytes: int64(getenvInt("MCP_TRANSCRIPTS_MAX_MB", defaultTranscriptsMaxMB)) << 20,
+		ArchiveRetention:   time.Duration(getenvInt("MCP_ARCHIVE_RETENTION_HOURS", int(defaultArchiveRetention/time.Hour))) * time.Hour,
+	}
+	return cfg
+}
+
+// runServer configures the server from the environment and serves until a
+// shutdown signal or, with a positive idleExit, until idle that long.
+func runServer(idleExit time.Duration) {
+	for _, issue := range lintEnv(os.Environ()) {
+		log.Printf("[config] %s", issue)
+	}
+	cfg := configFromEnv()
+	if cfg.Backend != backendCLI && cfg.Backend != backendServe {
+		log.Fatalf("invalid MCP_BACKEND %q (want %q or %q)", cfg.Backend, backendCLI, backendServe)
+	}
+	if err := validateOversize(cfg.Oversize); err != nil {
+		log.Fatalf("invalid MCP_OVERSIZE: %v", err)
+	}
+	if err := validateBinaryOutput(cfg.BinaryOutput); err != nil {
+		log.Fatalf("invalid MCP_BINARY_OUTPUT: %v", err)
+	}
+	if err := validateCompression(cfg.Compression); err != nil {
+		log.Fatalf("invalid MCP_COMPRESSION: %v", err)
+	}
+	configPath := os.Getenv("MCP_CONFIG")
+	if configPath != "" {
+		fc, err := loadFileConfig(configPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		applyFileConfig(&cfg, fc)
+		cfg.PluginTools = discoverPluginTools(cfg)
+		cfg.ServerTools = discoverServerTools(cfg)
+	}
+
+	cfg.CLIVersion = detectCLIVersion(cfg.Target)
+	probeJSONFormat(cfg.Target)
+	cfg.Binaries = newBinarySwitch(cfg.Target, cfg.CLIVersion)
+
+	storePath, storeURL := os.Getenv("MCP_STORE_PATH"), os.Getenv("MCP_STORE_URL")
+	st, err := openStoreFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	st.compress = cfg.Compression == compressionGzip
+	cfg.Store = st
+	cfg.Projects = newProjectRegistry(cfg.ProjectConfig, st)
+	cfg.ToolToggles = newToolToggles(cfg.DisabledTools, st)
+	if cfg.PromptTemplates, err = loadPromptTemplates(os.Getenv("MCP_PROMPTS_DIR")); err != nil {
+		log.Fatal(err)
+	}
+
+	if cfg.Attach.Hosts.AllowHosts, err = parseAttachHosts(os.Getenv("MCP_ATTACH_ALLOW_HOSTS")); err != nil {
+		log.Fatal(err)
+	}
+	if cfg.RouteTimeouts, err = parseRouteTimeouts(os.Getenv("MCP_ROUTE_TIMEOUTS"), cfg.DefaultTimeout); err != nil {
+		log.Fatal(err)
+	}
+
+	if spec := os.Getenv("MCP_CHAOS"); spec != "" {
+		if cfg.Chaos, err = parseChaos(spec); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("[chaos] WARNING: fault injection enabled (MCP_CHAOS=%s); never use this in production", spec)
+	}
+
+	if keyPath := os.Getenv("MCP_SIGNING_KEY"); keyPath != "" {
+		if cfg.Signer, err = loadSigner(keyPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if auditPath := os.Getenv("MCP_AUDIT_LOG"); auditPath != "" {
+		if err := startAuditLog(cfg.Events, auditPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+	webhooks, err := parseWebhooks(os.Getenv("MCP_EVENT_WEBHOOKS"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, target := range webhooks {
+		startWebhook(cfg.Events, target, cfg.Signer)
 	}
 
 	log.Printf("=== opencode-mcp server starting ===")
+	log.Printf("  serverInfo:      %s %s (build %s)", cfg.serverInfo().Name, cfg.serverInfo().Version, serverVersion)
+	if cfg.Instructions != "" {
+		log.Printf("  Instructions:    %d chars", len(cfg.Instructions))
+	}
 	log.Printf("  MCP_ADDR:        %s", cfg.Addr)
 	log.Printf("  MCP_TARGET:      %s", cfg.Target)
 	log.Printf("  MCP_TIMEOUT_SEC: %d", int(cfg.DefaultTimeout.Seconds()))
 	log.Printf("  MCP_DEFAULT_MODEL: %s", cfg.DefaultModel)
-	log.Printf("  Endpoints:       POST /mcp (MCP), GET /health, POST /exec, POST /exec/stream")
+	log.Printf("  MCP_BACKEND:     %s", cfg.Backend)
+	log.Printf("  MCP_MAX_CONCURRENT_RUNS: %d", getenvInt("MCP_MAX_CONCURRENT_RUNS", defaultMaxConcurrentRuns))
+	if cfg.CLIVersion != "" {
+		log.Printf("  CLI version:     %s (event adapter: %s, strict=%t)", cfg.CLIVersion, adaptersFor(cfg.CLIVersion)[0].name, cfg.StrictEvents)
+	} else {
+		log.Printf("  CLI version:     unknown (strict=%t)", cfg.StrictEvents)
+	}
+	if cfg.Backend == backendServe {
+		log.Printf("  MCP_SERVE_URL:   %s", cfg.ServeURL)
+	}
+	if a := cfg.withAgent(); a.agentCLI() != nil {
+		log.Printf("  Agent:           %s (%s)", cfg.Agent, a.Target)
+	}
+	if storeURL != "" {
+		log.Printf("  MCP_STORE_URL:   %s", redactURL(storeURL))
+	} else if storePath != "" {
+		log.Printf("  MCP_STORE_PATH:  %s", storePath)
+	} else {
+		log.Printf("  MCP_STORE_PATH:  (memory only)")
+	}
+	if cfg.Artifacts.Root != "" {
+		log.Printf("  MCP_ARTIFACT_DIR: %s (max %d MB/run, retention %s)", cfg.Artifacts.Root, cfg.Artifacts.MaxBytes>>20, cfg.Artifacts.Retention)
+	}
+	if cfg.Signer != nil {
+		log.Printf("  MCP_SIGNING_KEY: Ed25519 key %s (GET /signing-key)", cfg.Signer.keyID)
+	}
+	if os.Getenv("MCP_ADMIN_TOKEN") != "" {
+		log.Printf("  Admin API:       POST /admin/binary, GET /events (bearer token)")
+	}
+	if auditPath := os.Getenv("MCP_AUDIT_LOG"); auditPath != "" {
+		log.Printf("  MCP_AUDIT_LOG:   %s", auditPath)
+	}
+	if len(webhooks) > 0 {
+		log.Printf("  Event webhooks:  %d", len(webhooks))
+	}
+	if configPath != "" {
+		log.Printf("  MCP_CONFIG:      %s (%d custom tools, %d plugin tools, %d downstream server tools, %d tenant policies)", configPath, len(cfg.CustomTools), len(cfg.PluginTools), len(cfg.ServerTools), len(cfg.Tenants))
+	}
+	log.Printf("  Capabilities:    %s", buildCapabilityReport(cfg).summary())
+	log.Printf("  Endpoints:       POST /mcp (MCP), GET /health, GET /readyz, GET /status, GET /errors, POST /exec, POST /exec/stream")
 	log.Printf("================================")
 
-	// Pre-fetch available models in background
-	go func() {
-		fetchAvailableModels(cfg.Target)
-	}()
-
 	mux := http.NewServeMux()
 
 	// Health check
@@ -154,77 +443,67 @@ func main() {
 		_ = json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 	})
 
-	// Session store for MCP
-	sessions := &sessionStore{sessions: make(map[string]*session)}
-
-	// MCP endpoint - handles standard MCP protocol methods (Streamable HTTP)
-	mux.HandleFunc("/mcp", func(w http.ResponseWriter, r *http.Request) {
-		// Handle OPTIONS for endpoint discovery
-		if r.Method == http.MethodOptions {
-			w.Header().Set("Allow", "POST, OPTIONS")
-			w.Header().Set("Accept", "application/json")
-			w.WriteHeader(http.StatusNoContent)
-			return
-		}
-
-		if r.Method != http.MethodPost {
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
-
-		var req mcpRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			writeMCPError(w, nil, -32700, "invalid JSON")
-			return
-		}
-		if req.Method == "" {
-			writeMCPError(w, req.ID, -32600, "missing method")
+	// Readiness: fails once shutdown starts so load balancers stop routing,
+	// for MCP_SHUTDOWN_DRAIN before the listeners close
+	var shuttingDown atomic.Bool
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, _ *http.Request) {
+		if shuttingDown.Load() {
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
 			return
 		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+	})
 
-		log.Printf("[MCP] request method=%s id=%v", req.Method, req.ID)
-
-		// Handle session
-		sessionID := r.Header.Get("Mcp-Session-Id")
-		var sess *session
-
-		switch req.Method {
-		case "initialize":
-			// Create new session
-			sess = sessions.create()
-			sessionID = sess.id
-			w.Header().Set("Mcp-Session-Id", sessionID)
-			log.Printf("[MCP] initialize -> session=%s", sessionID)
-			handleInitialize(w, req)
-			return
-		case "notifications/initialized":
-			// Client notification, just acknowledge
-			log.Printf("[MCP] notifications/initialized ack")
-			w.WriteHeader(http.StatusNoContent)
-			return
-		default:
-			// Validate session for non-init requests
-			if sessionID != "" {
-				sess = sessions.get(sessionID)
-			}
-			// Allow requests without session for flexibility
-		}
+	// Session store for MCP
+	sessions := &sessionStore{sessions: make(map[string]*session), store: cfg.Store, ttl: cfg.SessionTTL, events: cfg.Events, transcripts: cfg.Transcripts}
+	if cfg.SessionTTL > 0 {
+		go sweepSessions(sessions, sessionSweepInterval)
+	}
+	// Pre-fetch available models in background, then watch them for changes
+	go watchModels(cfg, sessions, modelWatchInterval)
+	go watchTools(cfg, sessions, toolWatchInterval)
+
+	// Server status, including the active opencode binary and the clients
+	// connected to this instance
+	started := time.Now()
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"version":     serverVersion,
+			"backend":     cfg.Backend,
+			"uptime":      time.Since(started).Round(time.Second).String(),
+			"opencode":    cfg.Binaries.status(),
+			"queue":       cfg.Limiter.queueStatus(),
+			"resources":   cfg.Metrics.get(),
+			"compression": compressionStats.get(),
+			"memory":      memoryStatus(),
+			"warmup":      cfg.Warmup.status(),
+			"clients":     sessions.clients(),
+			"events":      cfg.Events.stats(),
+			"eventGaps":   cfg.Events.drops(),
+		})
+	})
+	registerAdminRoutes(mux, cfg, os.Getenv("MCP_ADMIN_TOKEN"))
 
-		if sess != nil {
-			w.Header().Set("Mcp-Session-Id", sess.id)
-		}
+	// MCP endpoint - handles standard MCP protocol methods (Streamable HTTP)
+	mcpHandler := cfg.Chaos.wrap(newMCPHandler(sessions, cfg))
+	mux.Handle("/mcp", mcpHandler)
+	if cfg.Idempotency != nil {
+		mux.Handle("POST "+pollPath, mcpHandler)
+	}
 
-		switch req.Method {
-		case "tools/list":
-			log.Printf("[MCP] tools/list -> returning tool list")
-			handleToolsList(w, req)
-		case "tools/call":
-			// Always use SSE for real-time streaming of opencode output
-			handleToolsCallSSE(w, r.Context(), cfg, req)
-		default:
-			writeMCPError(w, req.ID, -32601, fmt.Sprintf("method not found: %s", req.Method))
-		}
-	})
+	// Prompt library, run history and artifact REST endpoints
+	registerPromptRoutes(mux, cfg)
+	registerPreferenceRoutes(mux, cfg)
+	registerRunRoutes(mux, cfg)
+	registerArchiveRoutes(mux, cfg)
+	registerRunCancelRoute(mux, cfg)
+	registerTranscriptRoutes(mux, cfg)
+	registerErrorRoutes(mux, cfg)
+	registerResumeRoutes(mux, cfg)
+	registerSigningRoutes(mux, cfg)
+	registerArtifactRoutes(mux, cfg)
+	startJanitor(cfg)
+	cfg.Warmup.start(cfg.Projects)
 
 	// Direct exec endpoint (non-MCP, for convenience)
 	mux.HandleFunc("/exec", func(w http.ResponseWriter, r *http.Request) {
@@ -234,31 +513,57 @@ func main() {
 		}
 
 		var req execArgs
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "invalid JSON", http.StatusBadRequest)
+		stdin, ok := decodeExecArgs(w, r, cfg.Stdin, &req)
+		if !ok {
 			return
 		}
-		if len(req.Args) == 0 {
-			http.Error(w, "missing args", http.StatusBadRequest)
-			return
+		binary := cfg.Binaries.acquire()
+		defer binary.release()
+		cfg := cfg.forTenant(tenantFromRequest(r)).withBinary(binary).withAgent()
+		if req.Cwd == "" {
+			req.Cwd = cfg.Policy.defaultDir()
 		}
 		if err := validateCwd(req.Cwd); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		if err := cfg.Policy.checkDir(req.Cwd); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
 
 		ctx, cancel := context.WithTimeout(r.Context(), cfg.DefaultTimeout)
 		defer cancel()
 
-		stdout, stderr, exitCode, err := runCommand(ctx, cfg.Target, req.Args, req.Stdin, req.Cwd)
+		cmd := exec.CommandContext(ctx, cfg.Target, req.Args...)
+		release, err := prepareChild(cfg, cmd)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer release()
+		feed := feedStdin(cmd, stdin, cfg.Stdin.Timeout, cancel)
+		stdout, stderr, exitCode, err := runPreparedCommand(cmd, req.Cwd)
+		stdinTimedOut := feed.done()
 		resp := execResponse{
 			OK:       err == nil,
 			Stdout:   stdout,
 			Stderr:   stderr,
 			ExitCode: exitCode,
 		}
-		if err != nil {
+		if mimeType, binary := sniffBinary([]byte(stdout), false); binary && cfg.binarySafe() {
+			resp.Stdout = ""
+			resp.StdoutBase64 = base64.StdEncoding.EncodeToString([]byte(stdout))
+			resp.StdoutMimeType = mimeType
+		}
+		switch {
+		case stdinTimedOut:
+			resp.OK = false
+			resp.Error = fmt.Sprintf("stdin not read within %s", cfg.Stdin.Timeout)
+			resp.Code = errStdinTimeout.Code
+		case err != nil:
 			resp.Error = err.Error()
+			resp.Code = classifyFailure(ctx.Err(), stderr).Code
 		}
 		w.Header().Set("Content-Type", "application/json")
 		_ = json.NewEncoder(w).Encode(resp)
@@ -272,27 +577,39 @@ func main() {
 		}
 
 		var req execArgs
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "invalid JSON", http.StatusBadRequest)
+		stdin, ok := decodeExecArgs(w, r, cfg.Stdin, &req)
+		if !ok {
 			return
 		}
-		if len(req.Args) == 0 {
-			http.Error(w, "missing args", http.StatusBadRequest)
-			return
+		binary := cfg.Binaries.acquire()
+		defer binary.release()
+		cfg := cfg.forTenant(tenantFromRequest(r)).withBinary(binary).withAgent()
+		if req.Cwd == "" {
+			req.Cwd = cfg.Policy.defaultDir()
 		}
 		if err := validateCwd(req.Cwd); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		if err := cfg.Policy.checkDir(req.Cwd); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
 
 		ctx, cancel := context.WithTimeout(r.Context(), cfg.DefaultTimeout)
 		defer cancel()
 
 		cmd := exec.CommandContext(ctx, cfg.Target, req.Args...)
-		cmd.Stdin = strings.NewReader(req.Stdin)
+		feed := feedStdin(cmd, stdin, cfg.Stdin.Timeout, cancel)
 		if req.Cwd != "" {
 			cmd.Dir = req.Cwd
 		}
+		release, err := prepareChild(cfg, cmd)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer release()
 
 		stdout, err := cmd.StdoutPipe()
 		if err != nil {
@@ -325,112 +642,347 @@ func main() {
 			}
 		}()
 
-		if err := streamLines(stdout, w, flusher); err != nil {
+		if err := streamLines(stdout, w, flusher, cfg.binarySafe()); err != nil {
 			log.Printf("stdout stream error: %v", err)
 		}
 
 		_ = cmd.Wait()
+		if feed.done() {
+			data, _ := json.Marshal(map[string]string{"error": fmt.Sprintf("stdin not read within %s", cfg.Stdin.Timeout), "code": errStdinTimeout.Code})
+			_, _ = fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+			flusher.Flush()
+		}
+		cfg.Metrics.record(processResources(cmd.ProcessState))
 	})
 
+	idle := newIdleTracker(idleExit)
+	// Bodies and handlers are bounded by route (see routetimeouts.go), so
+	// the server only bounds headers and idle connections
 	srv := &http.Server{
-		Addr:         cfg.Addr,
-		Handler:      mux,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 0,
+		Addr:              cfg.Addr,
+		Handler:           idle.wrap(cfg.RouteTimeouts.wrap(tenantAuth(cfg, mux))),
+		ReadHeaderTimeout: 15 * time.Second,
+		IdleTimeout:       2 * time.Minute,
 	}
 
-	log.Printf("mcpserver listening on %s (ready)", cfg.Addr)
-	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+	ln, err := listen(cfg.Addr)
+	if err != nil {
 		log.Fatal(err)
 	}
+	if idle != nil {
+		log.Printf("  MCP_IDLE_EXIT:   %s", idleExit)
+	}
+	log.Printf("mcpserver listening on %s (ready)", ln.Addr())
+	serveUntilSignal(srv, ln, cfg.DefaultTimeout, cfg.ShutdownDrain, &shuttingDown, idle)
 }
 
-func handleInitialize(w http.ResponseWriter, req mcpRequest) {
-	resp := mcpResponse{
-		JSONRPC: "2.0",
-		ID:      req.ID,
-		Result: map[string]any{
-			"protocolVersion": "2024-11-05",
-			"capabilities": map[string]any{
-				"tools": map[string]any{},
-			},
-			"serverInfo": map[string]any{
-				"name":    "opencode-mcp",
-				"version": "0.1.0",
-			},
-		},
+// newMCPHandler returns the /mcp handler implementing the Streamable HTTP transport.
+func newMCPHandler(sessions *sessionStore, cfg serverConfig) http.HandlerFunc {
+	tools := newToolHandler(cfg)
+	d := newDispatcher(cfg, sessions, tools)
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Handle OPTIONS for endpoint discovery
+		if r.Method == http.MethodOptions {
+			w.Header().Set("Allow", "GET, POST, DELETE, OPTIONS")
+			w.Header().Set("Accept", "application/json")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if r.Method == http.MethodGet {
+			// The session's stream of notifications outside any request
+			sessionID := r.Header.Get("Mcp-Session-Id")
+			if sessionID == "" {
+				http.Error(w, "missing Mcp-Session-Id", http.StatusBadRequest)
+				return
+			}
+			if !acceptsEventStream(r.Header.Get("Accept")) {
+				http.Error(w, "the session stream is text/event-stream", http.StatusNotAcceptable)
+				return
+			}
+			sess := sessions.get(sessionID)
+			if sess == nil {
+				http.Error(w, "session not found", http.StatusNotFound)
+				return
+			}
+			serveSessionStream(w, r, sess)
+			return
+		}
+
+		if r.Method == http.MethodDelete {
+			// The client ends its session: its calls in flight are
+			// cancelled and its streams closed
+			sessionID := r.Header.Get("Mcp-Session-Id")
+			if sessionID == "" {
+				http.Error(w, "missing Mcp-Session-Id", http.StatusBadRequest)
+				return
+			}
+			if !sessions.remove(sessionID) {
+				http.Error(w, "session not found", http.StatusNotFound)
+				return
+			}
+			n := d.Inflight().CancelPrefix(sessionID + " ")
+			log.Printf("[MCP] session=%s deleted, %d calls cancelled", sessionID, n)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "GET, POST, DELETE, OPTIONS")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Errors are written in the response's Content-Language; a saved
+		// language stands in for a missing Accept-Language
+		prefs := loadPreferences(cfg, r)
+		locale := requestLocale(r, cfg)
+		if r.Header.Get("Accept-Language") == "" && prefs.Language != "" {
+			locale = prefs.Language
+		}
+		w.Header().Set("Content-Language", locale)
+
+		var req mcpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeMCPError(w, nil, -32700, "invalid JSON")
+			return
+		}
+		if req.Method == "" && req.ID != nil && (req.Result != nil || req.Error != nil) {
+			// Sessions live in this instance's memory, like the call
+			// waiting for the response
+			registerClientResponse(sessions.get(r.Header.Get("Mcp-Session-Id")), req.ID, clientResponse{req.Result, req.Error})
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		if req.Method == "" {
+			writeMCPError(w, req.ID, -32600, "missing method")
+			return
+		}
+
+		log.Printf("[MCP] request method=%s id=%s", req.Method, req.ID)
+
+		// Requests may omit the session unless MCP_REQUIRE_SESSION is set,
+		// but an unknown one gets 404 so the client knows to initialize again.
+		// Health checks ping without one.
+		var sess *session
+		sessionID := r.Header.Get("Mcp-Session-Id")
+		if sessionID == "" && cfg.RequireSession && req.Method != "initialize" && req.Method != "ping" {
+			writeErrorStatus(w, http.StatusBadRequest, req.ID, errSessionRequired.err("missing Mcp-Session-Id"))
+			return
+		}
+		if sessionID != "" && req.Method != "initialize" {
+			if sess = sessions.get(sessionID); sess == nil {
+				log.Printf("[MCP] unknown session=%s", sessionID)
+				writeErrorStatus(w, http.StatusNotFound, req.ID, errSessionNotFound.err("session not found"))
+				return
+			}
+		}
+
+		// Clients send the negotiated revision on every later request
+		if v := r.Header.Get("MCP-Protocol-Version"); v != "" && req.Method != "initialize" && !mcp.SupportsVersion(v) {
+			writeErrorStatus(w, http.StatusBadRequest, req.ID, errInvalidRequest.err(fmt.Sprintf("unsupported MCP-Protocol-Version %q", v)))
+			return
+		}
+
+		req.Session = sess
+		req.Tenant = tenantFromRequest(r)
+		req.IdempotencyKey = r.Header.Get("Idempotency-Key")
+		req.LastEventID, _ = strconv.Atoi(r.Header.Get("Last-Event-ID"))
+		req.Streaming = acceptsEventStream(r.Header.Get("Accept"))
+		req.Locale = w.Header().Get("Content-Language")
+		req.Preferences = prefs
+		client := ""
+		if sess != nil {
+			client = sess.client
+		}
+		req.Routes = cfg.Notifications.routesFor(client)
+		req.NotifyLimiter = notifyLimiter(sess, cfg.NotifyRate)
+		ctx := withRequest(r.Context(), &req)
+
+		// SSE for real-time streaming of opencode output when the client
+		// accepts it, except for clients behind proxies that buffer streams;
+		// every other request goes to the dispatcher
+		if req.Method == "tools/call" && ((r.URL.Path == pollPath && cfg.Idempotency != nil) || req.Streaming) {
+			if sess != nil {
+				w.Header().Set("Mcp-Session-Id", sess.id)
+			}
+			if r.URL.Path == pollPath && cfg.Idempotency != nil {
+				handleToolsCallPoll(w, r.Context(), tools, cfg, req)
+				return
+			}
+			ctx, done := d.Inflight().Start(ctx, d.InflightKey(ctx, req.ID))
+			defer done()
+			handleToolsCallSSE(w, ctx, tools, req)
+			return
+		}
+		resp := d.Dispatch(ctx, &req.Request)
+		if req.Session != nil {
+			w.Header().Set("Mcp-Session-Id", req.Session.id)
+		}
+		writeResponse(w, &req, resp)
 	}
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(resp)
 }
 
-func handleToolsList(w http.ResponseWriter, req mcpRequest) {
+// serverInfo is the configured serverInfo, with the defaults for what
+// isn't set.
+func (cfg serverConfig) serverInfo() mcp.ServerInfo {
+	info := cfg.ServerInfo
+	if info.Name == "" {
+		info.Name = mcp.ServerName
+	}
+	if info.Version == "" {
+		info.Version = serverVersion
+	}
+	return info
+}
+
+// handleSetLevel sets the least severe level of the session's message
+// notifications.
+func handleSetLevel(_ context.Context, _ serverConfig, req *mcpRequest) (any, *mcpError) {
+	var params struct {
+		Level string `json:"level"`
+	}
+	_ = json.Unmarshal(req.Params, &params)
+	rank := logLevelRank(params.Level)
+	switch {
+	case rank < 0:
+		return nil, errInvalidArguments.err(fmt.Sprintf("invalid level %q", params.Level))
+	case req.Session == nil:
+		return nil, errSessionRequired.err("missing Mcp-Session-Id")
+	}
+	sess := req.Session
+	sess.logLevel.Store(int32(rank))
+	sess.logLevelSet.Store(true)
+	log.Printf("[MCP] session=%s logging level=%s", sess.id, params.Level)
+	return map[string]any{}, nil
+}
+
+func handleToolsList(_ context.Context, cfg serverConfig, req *mcpRequest) (any, *mcpError) {
+	log.Printf("[MCP] tools/list -> returning tool list")
+	tools, next, mErr := mcp.Paginate(req.Params, toolsForClient(toolDefinitions(cfg), req.Session.capabilities()), mcp.PageSize)
+	if mErr != nil {
+		return nil, errInvalidArguments.err(mErr.Message)
+	}
+	return toolsListResult{Tools: tools, NextCursor: next}, nil
+}
+
+// toolDefinitions returns the enabled tools the server offers under cfg.
+func toolDefinitions(cfg serverConfig) []mcpTool {
+	return cfg.ToolToggles.filter(allToolDefinitions(cfg))
+}
+
+// allToolDefinitions returns the tools the server offers under cfg, enabled
+// or not: built-in tools, whose schemas reject undeclared arguments, then
+// custom and plugin tools.
+func allToolDefinitions(cfg serverConfig) []mcpTool {
 	tools := []mcpTool{
+		mcp.ExecTool(),
+		mcp.RunTool().WithProperties(map[string]any{
+			"files": map[string]any{
+				"type":        "array",
+				"items":       map[string]any{"type": "string"},
+				"description": "File paths to attach to the message for context (relative to cwd or absolute), or http(s) URLs of text or images on the hosts in MCP_ATTACH_ALLOW_HOSTS, which the server fetches",
+			},
+			"labels": map[string]any{
+				"type":        "array",
+				"items":       map[string]any{"type": "string"},
+				"description": "Labels to tag the run with in the run history (e.g. 'dependency-upgrade')",
+			},
+			"priority": map[string]any{
+				"type":        "string",
+				"enum":        []string{priorityInteractive, priorityNormal, priorityBatch},
+				"description": "Queue priority when all run slots are busy: interactive runs start before queued batch jobs (default normal, or the tenant's priority)",
+			},
+			"dedupe": map[string]any{
+				"type":        "boolean",
+				"description": "Share the output of an identical run that is already in progress instead of starting another (default true)",
+			},
+			"newSession": map[string]any{
+				"type":        "boolean",
+				"description": "Start a new opencode session instead of continuing the one this MCP session's earlier runs in the directory used; later runs continue the new one (default false)",
+			},
+			"codeBlocks": map[string]any{
+				"type":        "boolean",
+				"description": "Also return each fenced code block of the answer as its own resource content item, with its language and a suggested filename when derivable (default false)",
+			},
+		}).WithOutputSchema(runResultSchema),
+		{
+			Name:        toolFanout,
+			Description: "Split a task across parallel opencode_run invocations (one per shard) and optionally synthesize the results",
+			InputSchema: fanoutSchema,
+			Annotations: mcp.Mutating(true, false),
+		},
+		{
+			Name:        toolPipeline,
+			Description: "Run ordered steps (e.g. plan → implement → review) in one session, passing each step's answer to the next",
+			InputSchema: pipelineSchema,
+			Annotations: mcp.Mutating(true, false),
+		},
+		{
+			Name:        toolCompare,
+			Description: "Run one prompt on 2-4 models concurrently (read-only by default) and compare answers, latency and cost",
+			InputSchema: compareSchema,
+			Annotations: mcp.Mutating(true, false),
+		},
+		{
+			Name:        toolEstimate,
+			Description: "Estimate input tokens and cost of a run from its message, attachments and model, without running it",
+			InputSchema: estimateSchema,
+			Annotations: mcp.ReadOnly(),
+		},
+		{
+			Name:        toolPromptSave,
+			Description: "Create or update a named prompt in the shared prompt library (available via prompts/list)",
+			InputSchema: promptSaveSchema,
+			Annotations: mcp.Mutating(true, true),
+		},
+		{
+			Name:        toolPromptDelete,
+			Description: "Delete a named prompt from the prompt library",
+			InputSchema: promptNameSchema,
+			Annotations: mcp.Mutating(true, true),
+		},
 		{
-			Name:        toolExec,
-			Description: "Run any opencode-cli command with custom arguments. Use this for advanced operations.",
+			Name:        toolPromptList,
+			Description: "List the prompts in the prompt library",
 			InputSchema: map[string]any{
-				"type": "object",
-				"properties": map[string]any{
-					"args": map[string]any{
-						"type":        "array",
-						"items":       map[string]any{"type": "string"},
-						"description": "Command arguments (e.g., ['run', '--model', 'gpt-4', 'Hello'])",
-					},
-					"cwd": map[string]any{
-						"type":        "string",
-						"description": "Working directory for the command",
-					},
-					"stdin": map[string]any{
-						"type":        "string",
-						"description": "Standard input to pass to the command",
-					},
-				},
-				"required": []string{"args"},
+				"type":       "object",
+				"properties": map[string]any{},
 			},
+			Annotations: mcp.ReadOnly(),
+		},
+		{
+			Name:        toolHistory,
+			Description: "Search the run history by label, directory, status and time",
+			InputSchema: historySchema,
+			Annotations: mcp.ReadOnly(),
+		},
+		{
+			Name:         toolCompareRuns,
+			Description:  "Compare two recorded runs, e.g. of one task with different prompts or models: their answers diffed, the files each changed, and the cost, latency and token deltas",
+			InputSchema:  compareRunsSchema,
+			OutputSchema: runComparisonSchema,
+			Annotations:  mcp.ReadOnly(),
+		},
+		{
+			Name:        toolJobCancel,
+			Description: "Stop an opencode_run by its run ID, e.g. after losing its connection, and return its partial result",
+			InputSchema: jobCancelSchema,
+			Annotations: mcp.Mutating(true, true),
 		},
+		mcp.ModelsTool(),
 		{
-			Name:        toolRun,
-			Description: "Run AI code assistant with a message. This is the main tool for code editing, analysis, and generation.",
+			Name:        toolModelInfo,
+			Description: "Show provider, name and display name of available models as JSON",
 			InputSchema: map[string]any{
 				"type": "object",
 				"properties": map[string]any{
-					"message": map[string]any{
-						"type":        "string",
-						"description": "The message/prompt to send to the AI assistant",
-					},
-					"cwd": map[string]any{
-						"type":        "string",
-						"description": "Project directory to work in",
-					},
 					"model": map[string]any{
 						"type":        "string",
-						"description": "Model to use (e.g., 'github-copilot/claude-sonnet-4')",
-					},
-					"session": map[string]any{
-						"type":        "string",
-						"description": "Session ID to continue a previous conversation",
-					},
-					"continue": map[string]any{
-						"type":        "boolean",
-						"description": "Continue the last session",
-					},
-					"files": map[string]any{
-						"type":        "array",
-						"items":       map[string]any{"type": "string"},
-						"description": "File paths to attach to the message for context (relative to cwd or absolute)",
+						"description": "Model ID (provider/name) or bare name; omit to list all models",
 					},
 				},
-				"required": []string{"message"},
-			},
-		},
-		{
-			Name:        toolModels,
-			Description: "List all available AI models",
-			InputSchema: map[string]any{
-				"type":       "object",
-				"properties": map[string]any{},
 			},
+			Annotations: mcp.ReadOnly(),
 		},
 		{
 			Name:        toolSessionList,
@@ -439,6 +991,8 @@ func handleToolsList(w http.ResponseWriter, req mcpRequest) {
 				"type":       "object",
 				"properties": map[string]any{},
 			},
+			OutputSchema: sessionListSchema,
+			Annotations:  mcp.ReadOnly(),
 		},
 		{
 			Name:        toolAgentList,
@@ -447,154 +1001,44 @@ func handleToolsList(w http.ResponseWriter, req mcpRequest) {
 				"type":       "object",
 				"properties": map[string]any{},
 			},
+			OutputSchema: agentListSchema,
+			Annotations:  mcp.ReadOnly(),
 		},
 	}
-
-	resp := mcpResponse{
-		JSONRPC: "2.0",
-		ID:      req.ID,
-		Result: toolsListResult{
-			Tools: tools,
-		},
-	}
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(resp)
-}
-
-func handleToolsCall(w http.ResponseWriter, ctx context.Context, cfg serverConfig, req mcpRequest) {
-	var params toolCallParams
-	if err := json.Unmarshal(req.Params, &params); err != nil {
-		writeMCPError(w, req.ID, -32602, "invalid params")
-		return
-	}
-
-	ctx, cancel := context.WithTimeout(ctx, cfg.DefaultTimeout)
-	defer cancel()
-
-	var stdout, stderr string
-	var exitCode int
-	var err error
-
-	switch params.Name {
-	case toolExec:
-		var args execArgs
-		if err := json.Unmarshal(params.Arguments, &args); err != nil {
-			writeMCPError(w, req.ID, -32602, "invalid arguments")
-			return
-		}
-		if len(args.Args) == 0 {
-			writeMCPError(w, req.ID, -32602, "missing args")
-			return
-		}
-		if args.Cwd == "" {
-			args.Cwd = req.Cwd
-		}
-		if err := validateCwd(args.Cwd); err != nil {
-			writeMCPError(w, req.ID, -32602, err.Error())
-			return
-		}
-		stdout, stderr, exitCode, err = runCommand(ctx, cfg.Target, args.Args, args.Stdin, args.Cwd)
-
-	case toolRun:
-		var runArgs struct {
-			Message  string   `json:"message"`
-			Cwd      string   `json:"cwd"`
-			Model    string   `json:"model"`
-			Session  string   `json:"session"`
-			Continue bool     `json:"continue"`
-			Files    []string `json:"files"`
-		}
-		if err := json.Unmarshal(params.Arguments, &runArgs); err != nil {
-			writeMCPError(w, req.ID, -32602, "invalid arguments")
-			return
-		}
-		if runArgs.Message == "" {
-			writeMCPError(w, req.ID, -32602, "missing message")
-			return
-		}
-		cwd := runArgs.Cwd
-		if cwd == "" {
-			cwd = req.Cwd
-		}
-		if err := validateCwd(cwd); err != nil {
-			writeMCPError(w, req.ID, -32602, err.Error())
-			return
-		}
-
-		// Use default model if not specified
-		model := runArgs.Model
-		if model == "" {
-			model = getDefaultModel(cfg)
-			if model != "" {
-				log.Printf("Using default model: %s", model)
-			}
-		}
-
-		cmdArgs := []string{"run", "--format", "json"}
-		if model != "" {
-			cmdArgs = append(cmdArgs, "--model", model)
-		}
-		if runArgs.Session != "" {
-			cmdArgs = append(cmdArgs, "--session", runArgs.Session)
-		}
-		if runArgs.Continue {
-			cmdArgs = append(cmdArgs, "--continue")
-		}
-		for _, file := range runArgs.Files {
-			cmdArgs = append(cmdArgs, "--file", file)
-		}
-		cmdArgs = append(cmdArgs, runArgs.Message)
-		stdout, stderr, exitCode, err = runCommand(ctx, cfg.Target, cmdArgs, "", cwd)
-
-	case toolModels:
-		stdout, stderr, exitCode, err = runCommand(ctx, cfg.Target, []string{"models"}, "", "")
-
-	case toolSessionList:
-		stdout, stderr, exitCode, err = runCommand(ctx, cfg.Target, []string{"session", "list"}, "", "")
-
-	case toolAgentList:
-		stdout, stderr, exitCode, err = runCommand(ctx, cfg.Target, []string{"agent", "list"}, "", "")
-
-	default:
-		writeMCPError(w, req.ID, -32602, fmt.Sprintf("unknown tool: %s", params.Name))
-		return
-	}
-
-	// Build result
-	resultText := stdout
-
-	// For toolRun, parse the JSON event stream to extract readable text
-	if params.Name == toolRun && stdout != "" {
-		parsed := parseJSONEventStream(stdout)
-		if parsed != "" {
-			resultText = parsed
+	tools = append(tools, mcpTool{
+		Name:         toolProjectList,
+		Description:  "List the registered projects, which other tools accept as \"project\" instead of a cwd",
+		InputSchema:  map[string]any{"type": "object", "properties": map[string]any{}},
+		OutputSchema: projectListSchema,
+		Annotations:  mcp.ReadOnly(),
+	})
+	for i := range tools {
+		tools[i].InputSchema = withProjectArg(tools[i].InputSchema)
+		if cfg.Workspaces != nil {
+			tools[i].InputSchema = withRepoArgs(tools[i].InputSchema)
 		}
+		tools[i].InputSchema = mcp.Closed(tools[i].InputSchema)
 	}
-
-	if stderr != "" {
-		resultText += "\n[stderr]\n" + stderr
-	}
-	if err != nil {
-		resultText += fmt.Sprintf("\n[exit code: %d]", exitCode)
+	for _, t := range cfg.CustomTools {
+		tools = append(tools, t.definition())
 	}
-
-	result := toolCallResult{
-		Content: []toolContent{{Type: "text", Text: resultText}},
-		IsError: err != nil && exitCode != 0,
+	for _, pt := range cfg.PluginTools {
+		tools = append(tools, pt.Tool)
 	}
-
-	resp := mcpResponse{
-		JSONRPC: "2.0",
-		ID:      req.ID,
-		Result:  result,
+	for _, dt := range cfg.ServerTools {
+		tools = append(tools, dt.Tool)
 	}
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(resp)
+	return tools
 }
 
 func runCommand(ctx context.Context, target string, args []string, stdin, cwd string) (string, string, int, error) {
 	cmd := exec.CommandContext(ctx, target, args...)
 	cmd.Stdin = strings.NewReader(stdin)
+	return runPreparedCommand(cmd, cwd)
+}
+
+// runPreparedCommand runs cmd, whose stdin is set already, in cwd.
+func runPreparedCommand(cmd *exec.Cmd, cwd string) (string, string, int, error) {
 	if cwd != "" {
 		cmd.Dir = cwd
 	}
@@ -610,24 +1054,51 @@ func runCommand(ctx context.Context, target string, args []string, stdin, cwd st
 
 }
 
-func writeMCPError(w http.ResponseWriter, id any, code int, message string) {
+func writeMCPResult(w http.ResponseWriter, id json.RawMessage, result any) {
 	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(mcpResponse{JSONRPC: "2.0", ID: id, Result: result})
+}
+
+// writeJSON writes v as a JSON response with the given status.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeMCPError(w http.ResponseWriter, id json.RawMessage, code int, message string) {
+	writeError(w, id, &mcpError{Code: code, Message: message})
+}
+
+// writeError writes mErr as a JSON-RPC error response.
+func writeError(w http.ResponseWriter, id json.RawMessage, mErr *mcpError) {
+	writeErrorStatus(w, http.StatusOK, id, mErr)
+}
+
+// writeErrorStatus writes mErr with an HTTP status, for errors the
+// transport also signals at the HTTP level.
+func writeErrorStatus(w http.ResponseWriter, status int, id json.RawMessage, mErr *mcpError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
 	resp := mcpResponse{
 		JSONRPC: "2.0",
 		ID:      id,
-		Error: &mcpError{
-			Code:    code,
-			Message: message,
-		},
+		Error:   mErr.withDefaultData().localize(w.Header().Get("Content-Language")),
 	}
 	_ = json.NewEncoder(w).Encode(resp)
 }
 
-func streamLines(r io.Reader, w io.Writer, flusher http.Flusher) error {
+// streamLines sends each chunk of r as an SSE data event. With binarySafe,
+// binary chunks are sent base64-encoded as "binary" events instead, since
+// trimming and SSE framing would corrupt them.
+func streamLines(r io.Reader, w io.Writer, flusher http.Flusher, binarySafe bool) error {
 	buf := make([]byte, 4096)
 	for {
 		n, err := r.Read(buf)
-		if n > 0 {
+		if _, binary := sniffBinary(buf[:n], true); binary && binarySafe {
+			_, _ = fmt.Fprintf(w, "event: binary\ndata: %s\n\n", base64.StdEncoding.EncodeToString(buf[:n]))
+			flusher.Flush()
+		} else if n > 0 {
 			chunk := strings.TrimSpace(string(buf[:n]))
 			if chunk != "" {
 				_, _ = fmt.Fprintf(w, "data: %s\n\n", chunk)
@@ -669,6 +1140,14 @@ func getenv(key, def string) string {
 	return def
 }
 
+// defaultPublicURL derives the server's base URL from its listen address.
+func defaultPublicURL(addr string) string {
+	if strings.HasPrefix(addr, ":") {
+		return "http://localhost" + addr
+	}
+	return "http://" + addr
+}
+
 func getenvInt(key string, def int) int {
 	if v := os.Getenv(key); v != "" {
 		var out int
@@ -680,157 +1159,276 @@ func getenvInt(key string, def int) int {
 	return def
 }
 
+func getenvDuration(key string, def time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return d
+	}
+	return def
+}
+
+func getenvBool(key string, def bool) bool {
+	switch strings.ToLower(os.Getenv(key)) {
+	case "1", "true", "yes", "on":
+		return true
+	case "0", "false", "no", "off":
+		return false
+	}
+	return def
+}
+
 // Session management for MCP
 type session struct {
-	id        string
-	createdAt time.Time
+	id          string
+	createdAt   time.Time
+	client      string             // clientInfo name from initialize
+	caps        clientCapabilities // declared in initialize
+	logLevel    atomic.Int32       // rank of the level set by logging/setLevel; 0 sends everything
+	logLevelSet atomic.Bool        // logging/setLevel was called
+	requests    clientRequests     // sent to the client, awaiting its response
+	streams     sessionStreams     // open GET streams
+	lastSeen    atomic.Int64       // unix nanoseconds of the latest request
+	persisted   atomic.Int64       // lastSeen as last written to the store
+
+	opencodeMu sync.Mutex
+	opencode   opencodeSessions // continued by opencode_run, by directory
+
+	limiterOnce sync.Once
+	limiter     *throttle.Limiter // notification budget shared by the session's calls
 }
 
+// sessionStore keeps the sessions of this instance, and records them in
+// store (if any) so the other instances sharing it accept them too.
+// Sessions idle for ttl expire; 0 keeps them until deleted.
 type sessionStore struct {
 	mu       sync.RWMutex
 	sessions map[string]*session
+	store    *store
+	ttl      time.Duration
+	events   *eventBus // told about sessions created and ended
+
+	transcripts *transcriptFeed // drops the subscriptions of ended sessions
+}
+
+const (
+	sessionsCollection = "sessions"
+
+	// defaultSessionTTL is how long a session may go without a request.
+	defaultSessionTTL = 24 * time.Hour
+	// sessionSweepInterval is how often expired sessions are dropped;
+	// requests find them expired in between.
+	sessionSweepInterval = time.Minute
+)
+
+// storedSession is the persisted form of a session.
+type storedSession struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	LastSeen  time.Time `json:"lastSeen,omitempty"` // refreshed every quarter of the TTL
+	Client    string    `json:"client,omitempty"`
+
+	Capabilities     clientCapabilities `json:"capabilities"`
+	OpencodeSessions opencodeSessions   `json:"opencodeSessions,omitempty"`
 }
 
-func (s *sessionStore) create() *session {
+// create starts a session for the client named in initialize.
+func (s *sessionStore) create(client string, caps clientCapabilities) *session {
 	id := generateSessionID()
 	sess := &session{
 		id:        id,
 		createdAt: time.Now(),
+		client:    client,
+		caps:      caps,
 	}
+	sess.lastSeen.Store(sess.createdAt.UnixNano())
+	sess.persisted.Store(sess.createdAt.UnixNano())
 	s.mu.Lock()
 	s.sessions[id] = sess
 	s.mu.Unlock()
+	s.persist(sess)
+	s.events.publish(eventSessionCreated, "", id, map[string]any{"client": client})
 	return sess
 }
 
-func (s *sessionStore) get(id string) *session {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	return s.sessions[id]
+// persist records sess in the store, if any.
+func (s *sessionStore) persist(sess *session) {
+	if s.store != nil {
+		saveSession(s.store, sess)
+	}
 }
 
-func generateSessionID() string {
-	b := make([]byte, 16)
-	_, _ = rand.Read(b)
-	return hex.EncodeToString(b)
+// saveSession records sess in st.
+func saveSession(st *store, sess *session) {
+	stored := storedSession{
+		ID:               sess.id,
+		CreatedAt:        sess.createdAt,
+		LastSeen:         time.Unix(0, sess.lastSeen.Load()),
+		Client:           sess.client,
+		Capabilities:     sess.caps,
+		OpencodeSessions: sess.opencodeSnapshot(),
+	}
+	if err := st.put(sessionsCollection, sess.id, stored); err != nil {
+		log.Printf("[MCP] session=%s not persisted: %v", sess.id, err)
+	}
 }
 
-// fetchAvailableModels fetches and caches the list of available models
-func fetchAvailableModels(target string) []string {
-	modelCacheMu.RLock()
-	if len(availableModels) > 0 && time.Since(modelCacheTime) < modelCacheTTL {
-		models := availableModels
-		modelCacheMu.RUnlock()
-		return models
+// get returns the session id, marking it as used, or nil when it is unknown
+// or has expired.
+func (s *sessionStore) get(id string) *session {
+	now := time.Now()
+	s.mu.RLock()
+	sess := s.sessions[id]
+	s.mu.RUnlock()
+	if sess == nil && s.store != nil {
+		sess = s.load(id)
 	}
-	modelCacheMu.RUnlock()
-
-	modelCacheMu.Lock()
-	defer modelCacheMu.Unlock()
-
-	// Double-check after acquiring write lock
-	if len(availableModels) > 0 && time.Since(modelCacheTime) < modelCacheTTL {
-		return availableModels
+	if sess == nil {
+		return nil
 	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, target, "models")
-	output, err := cmd.Output()
-	if err != nil {
-		log.Printf("Failed to fetch models: %v", err)
+	if s.expired(sess, now) {
+		log.Printf("[MCP] session=%s expired", id)
+		s.drop(sess, "expired")
 		return nil
 	}
+	sess.lastSeen.Store(now.UnixNano())
+	if s.store != nil && s.ttl > 0 && now.Sub(time.Unix(0, sess.persisted.Load())) > s.ttl/4 {
+		sess.persisted.Store(now.UnixNano())
+		s.persist(sess)
+	}
+	return sess
+}
 
-	var models []string
-	lines := strings.Split(string(output), "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line != "" && !strings.HasPrefix(line, "#") && !strings.HasPrefix(line, "Available") {
-			// Extract model ID (first column or whole line)
-			parts := strings.Fields(line)
-			if len(parts) > 0 {
-				models = append(models, parts[0])
-			}
+// load adds the session id recorded in the store by any instance.
+func (s *sessionStore) load(id string) *session {
+	var stored storedSession
+	if ok, err := s.store.get(sessionsCollection, id, &stored); !ok {
+		if err != nil {
+			log.Printf("[MCP] session=%s lookup: %v", id, err)
 		}
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess := s.sessions[id]
+	if sess == nil {
+		sess = &session{id: stored.ID, createdAt: stored.CreatedAt, client: stored.Client, caps: stored.Capabilities, opencode: stored.OpencodeSessions}
+		lastSeen := stored.LastSeen
+		if lastSeen.IsZero() {
+			lastSeen = stored.CreatedAt
+		}
+		sess.lastSeen.Store(lastSeen.UnixNano())
+		sess.persisted.Store(lastSeen.UnixNano())
+		s.sessions[id] = sess
 	}
+	return sess
+}
 
-	if len(models) > 0 {
-		availableModels = models
-		modelCacheTime = time.Now()
-		log.Printf("Cached %d available models", len(models))
+// expired reports whether sess has been idle for the TTL. A session with
+// an open stream is in use, and so is one that another instance sharing
+// the store has seen since.
+func (s *sessionStore) expired(sess *session, now time.Time) bool {
+	idle := func() bool { return now.Sub(time.Unix(0, sess.lastSeen.Load())) > s.ttl }
+	if s.ttl <= 0 || !idle() || sess.streaming() {
+		return false
+	}
+	if s.store != nil {
+		var stored storedSession
+		if ok, _ := s.store.get(sessionsCollection, sess.id, &stored); ok && stored.LastSeen.UnixNano() > sess.lastSeen.Load() {
+			sess.lastSeen.Store(stored.LastSeen.UnixNano())
+			sess.persisted.Store(stored.LastSeen.UnixNano())
+		}
 	}
+	return idle()
+}
 
-	return models
+// remove ends the session id, here and in the store, reporting false when
+// there is no such session.
+func (s *sessionStore) remove(id string) bool {
+	sess := s.get(id)
+	if sess == nil {
+		return false
+	}
+	s.drop(sess, "deleted")
+	return true
 }
 
-// getDefaultModel returns the best available model, or empty string to let opencode use its default.
-// When fetchAvailableModels fails (e.g., wrong opencode binary), we return "" to avoid ProviderModelNotFoundError.
-func getDefaultModel(cfg serverConfig) string {
-	models := fetchAvailableModels(cfg.Target)
-
-	// Preferred models in order (provider/model format per opencode.ai docs)
-	preferredModels := []string{
-		"github-copilot/gpt-5.2-codex",
-		"github-copilot/gpt-5.1-codex",
-		"opencode/gpt-5.2-codex",
-		"opencode/gpt-5.1-codex",
-		"github-copilot/gpt-4o",
-		"github-copilot/claude-sonnet-4.5",
-	}
-
-	for _, preferred := range preferredModels {
-		for _, available := range models {
-			if available == preferred {
-				log.Printf("Selected preferred model: %s", available)
-				return available
-			}
+// drop forgets sess, here and in the store, and closes its streams; reason
+// says why, for the event bus.
+func (s *sessionStore) drop(sess *session, reason string) {
+	s.mu.Lock()
+	if s.sessions[sess.id] == sess {
+		delete(s.sessions, sess.id)
+	}
+	s.mu.Unlock()
+	if s.store != nil {
+		if _, err := s.store.delete(sessionsCollection, sess.id); err != nil {
+			log.Printf("[MCP] session=%s not deleted from the store: %v", sess.id, err)
 		}
 	}
+	sess.end()
+	s.transcripts.forget(sess)
+	s.events.publish(eventSessionEnded, "", sess.id, map[string]any{"reason": reason})
+}
 
-	for _, preferred := range preferredModels {
-		for _, available := range models {
-			if strings.Contains(available, preferred) {
-				log.Printf("Selected partial match model: %s", available)
-				return available
-			}
+// sweep drops the sessions that have expired, in memory and in the store,
+// returning how many.
+func (s *sessionStore) sweep(now time.Time) int {
+	if s.ttl <= 0 {
+		return 0
+	}
+	s.mu.RLock()
+	sessions := make([]*session, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		sessions = append(sessions, sess)
+	}
+	s.mu.RUnlock()
+	// expired may read the store, so it runs without holding s.mu
+	n := 0
+	for _, sess := range sessions {
+		if s.expired(sess, now) {
+			s.drop(sess, "expired")
+			n++
 		}
 	}
-
-	for _, available := range models {
-		if strings.HasPrefix(available, "github-copilot/") || strings.HasPrefix(available, "opencode/") {
-			log.Printf("Selected first available model: %s", available)
-			return available
+	if s.store == nil {
+		return n
+	}
+	for _, doc := range s.store.list(sessionsCollection, "") {
+		var stored storedSession
+		if err := json.Unmarshal(doc, &stored); err != nil {
+			continue
+		}
+		lastSeen := stored.LastSeen
+		if lastSeen.IsZero() {
+			lastSeen = stored.CreatedAt
+		}
+		s.mu.RLock()
+		_, here := s.sessions[stored.ID]
+		s.mu.RUnlock()
+		if here || now.Sub(lastSeen) <= s.ttl {
+			continue
+		}
+		if ok, err := s.store.delete(sessionsCollection, stored.ID); err != nil {
+			log.Printf("[MCP] session=%s not deleted from the store: %v", stored.ID, err)
+		} else if ok {
+			n++
 		}
 	}
+	return n
+}
 
-	if len(models) > 0 {
-		log.Printf("Selected first available model: %s", models[0])
-		return models[0]
+// sweepSessions drops expired sessions every interval.
+func sweepSessions(sessions *sessionStore, interval time.Duration) {
+	for now := range time.Tick(interval) {
+		if n := sessions.sweep(now); n > 0 {
+			log.Printf("[MCP] %d sessions expired after %s idle", n, sessions.ttl)
+		}
 	}
-
-	// Don't use hardcoded fallback - let opencode use its own default to avoid ProviderModelNotFoundError
-	log.Printf("No models from 'opencode models', omitting --model (opencode will use its default)")
-	return ""
 }
 
-// sendProgress sends MCP notifications/progress for real-time client display
-func sendProgress(w io.Writer, flusher http.Flusher, id any, progress int, message string) {
-	notif := map[string]any{
-		"jsonrpc": "2.0",
-		"method":  "notifications/progress",
-		"params": map[string]any{
-			"progressToken": id,
-			"progress":      progress,
-			"message":       message,
-		},
-	}
-	b, _ := json.Marshal(notif)
-	_, _ = fmt.Fprintf(w, "data: %s\n\n", b)
-	if flusher != nil {
-		flusher.Flush()
-	}
+func generateSessionID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
 }
 
 // truncateForLog returns s truncated to maxLen with "..." if longer
@@ -842,464 +1440,155 @@ func truncateForLog(s string, maxLen int) string {
 }
 
 // SSE streaming for tools/call
-func handleToolsCallSSE(w http.ResponseWriter, ctx context.Context, cfg serverConfig, req mcpRequest) {
-	var params toolCallParams
-	if err := json.Unmarshal(req.Params, &params); err != nil {
-		log.Printf("[tools/call] invalid params: %v", err)
-		writeMCPError(w, req.ID, -32602, "invalid params")
+func handleToolsCallSSE(w http.ResponseWriter, ctx context.Context, tools toolHandler, req mcpRequest) {
+	call, mErr := newToolCall(req)
+	if mErr != nil {
+		writeError(w, req.ID, mErr)
 		return
 	}
 
-	log.Printf("[tools/call] tool=%s id=%v", params.Name, req.ID)
-
-	// Build command args based on tool
-	var cmdArgs []string
-	var cwd string
-	var stdin string
-
-	switch params.Name {
-	case toolExec:
-		var args execArgs
-		if err := json.Unmarshal(params.Arguments, &args); err != nil {
-			writeMCPError(w, req.ID, -32602, "invalid arguments")
-			return
-		}
-		if len(args.Args) == 0 {
-			writeMCPError(w, req.ID, -32602, "missing args")
-			return
-		}
-		cmdArgs = args.Args
-		cwd = args.Cwd
-		stdin = args.Stdin
-		log.Printf("[tools/call] exec args=%v cwd=%q", args.Args, cwd)
-
-	case toolRun:
-		var runArgs struct {
-			Message  string   `json:"message"`
-			Cwd      string   `json:"cwd"`
-			Model    string   `json:"model"`
-			Session  string   `json:"session"`
-			Continue bool     `json:"continue"`
-			Files    []string `json:"files"`
-		}
-		if err := json.Unmarshal(params.Arguments, &runArgs); err != nil {
-			writeMCPError(w, req.ID, -32602, "invalid arguments")
-			return
-		}
-		if runArgs.Message == "" {
-			writeMCPError(w, req.ID, -32602, "missing message")
-			return
-		}
-
-		// Use default model if not specified
-		model := runArgs.Model
-		if model == "" {
-			model = getDefaultModel(cfg)
-			if model != "" {
-				log.Printf("SSE: Using default model: %s", model)
+	stream := &sseStream{w: w}
+	notify := throttle.New(req.NotifyLimiter, stream.send, mergeNotifications)
+	call.notify = notify.Send
+	if req.Session != nil {
+		call.ask = func(ctx context.Context, method string, params any) (json.RawMessage, error) {
+			id, wait, done := req.Session.requests.start()
+			defer done()
+			notify.Flush()
+			stream.send(map[string]any{"jsonrpc": "2.0", "id": id, "method": method, "params": params})
+			select {
+			case resp := <-wait:
+				if resp.Error != nil {
+					return nil, fmt.Errorf("%s (%d)", resp.Error.Message, resp.Error.Code)
+				}
+				return resp.Result, nil
+			case <-ctx.Done():
+				return nil, ctx.Err()
 			}
 		}
-
-		cmdArgs = []string{"run", "--format", "json"}
-		if model != "" {
-			cmdArgs = append(cmdArgs, "--model", model)
-		}
-		if runArgs.Session != "" {
-			cmdArgs = append(cmdArgs, "--session", runArgs.Session)
-		}
-		if runArgs.Continue {
-			cmdArgs = append(cmdArgs, "--continue")
-		}
-		for _, file := range runArgs.Files {
-			cmdArgs = append(cmdArgs, "--file", file)
-		}
-		cmdArgs = append(cmdArgs, runArgs.Message)
-		cwd = runArgs.Cwd
-		log.Printf("[tools/call] run message=%s model=%s cwd=%q session=%s files=%v",
-			truncateForLog(runArgs.Message, 80), model, cwd, runArgs.Session, runArgs.Files)
-
-	case toolModels:
-		cmdArgs = []string{"models"}
-		log.Printf("[tools/call] models")
-
-	case toolSessionList:
-		cmdArgs = []string{"session", "list"}
-		log.Printf("[tools/call] session list")
-
-	case toolAgentList:
-		cmdArgs = []string{"agent", "list"}
-		log.Printf("[tools/call] agent list")
-
-	default:
-		writeMCPError(w, req.ID, -32602, fmt.Sprintf("unknown tool: %s", params.Name))
-		return
 	}
 
-	if cwd == "" {
-		cwd = req.Cwd
-	}
-	if err := validateCwd(cwd); err != nil {
-		writeMCPError(w, req.ID, -32602, err.Error())
-		return
-	}
-
-	ctx, cancel := context.WithTimeout(ctx, cfg.DefaultTimeout)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, cfg.Target, cmdArgs...)
-	cmd.Stdin = strings.NewReader(stdin)
-	if cwd != "" {
-		cmd.Dir = cwd
+	result, mErr := tools(ctx, call)
+	resp := mcpResponse{JSONRPC: "2.0", ID: req.ID}
+	if mErr != nil {
+		resp.Error = mErr.withDefaultData().localize(req.Locale)
+	} else {
+		resp.Result = result.forClient(req.Session.capabilities())
 	}
+	notify.Flush()
 
-	log.Printf("[tools/call] exec: %s %s (cwd=%q)", cfg.Target, strings.Join(cmdArgs, " "), cwd)
-
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		writeMCPError(w, req.ID, -32000, err.Error())
-		return
-	}
-	stderrPipe, err := cmd.StderrPipe()
-	if err != nil {
-		writeMCPError(w, req.ID, -32000, err.Error())
+	// Nothing was streamed (e.g. validation errors, plugin tools): reply with plain JSON
+	if !stream.started {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(resp)
 		return
 	}
+	stream.send(resp)
+}
 
-	if err := cmd.Start(); err != nil {
-		writeMCPError(w, req.ID, -32000, err.Error())
-		return
-	}
+// eventStreamRanges are the media ranges matching text/event-stream, by
+// specificity.
+var eventStreamRanges = map[string]int{"*/*": 0, "text/*": 1, "text/event-stream": 2}
 
-	// SSE response - disable buffering for real-time streaming
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("X-Accel-Buffering", "no") // nginx: disable proxy buffering
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		writeMCPError(w, req.ID, -32000, "streaming unsupported")
-		return
+// acceptsEventStream reports whether an Accept header admits
+// text/event-stream, going by the most specific matching media range. A
+// missing header accepts anything.
+func acceptsEventStream(accept string) bool {
+	if strings.TrimSpace(accept) == "" {
+		return true
 	}
+	return acceptQuality(accept, eventStreamRanges) > 0
+}
 
-	// Collect stderr in background
-	var stderrBuf strings.Builder
-	go func() {
-		_, _ = io.Copy(&stderrBuf, stderrPipe)
-	}()
-
-	// Collect text and tool outputs for final response
-	var textCollector strings.Builder
-	var toolOutputs []string
-	var eventCount int
-	eventTypeCounts := make(map[string]int)
-
-	// Stream stdout line by line for better JSON event handling
-	scanner := bufio.NewScanner(stdout)
-	scanner.Buffer(make([]byte, 1024*1024), 1024*1024) // 1MB buffer for large JSON lines
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line == "" {
+// acceptQuality returns the q value of the most specific of ranges, by
+// specificity, that an Accept-style header lists, or 0 when it lists none.
+func acceptQuality(header string, ranges map[string]int) float64 {
+	best, q := -1, 0.0
+	for _, part := range strings.Split(header, ",") {
+		value, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		specificity, ok := ranges[strings.ToLower(strings.TrimSpace(value))]
+		if !ok {
 			continue
 		}
-
-		// For opencode_run with --format json, parse and extract useful info
-		if params.Name == toolRun {
-			var event map[string]any
-			if err := json.Unmarshal([]byte(line), &event); err == nil {
-				eventType, _ := event["type"].(string)
-				eventData := extractEventData(event)
-				eventTypeCounts[eventType]++
-				eventCount++
-
-				// Log every event with step details for observability
-				switch eventType {
-				case "text":
-					if text, ok := eventData.(string); ok {
-						log.Printf("[stream] event#%d type=text len=%d", eventCount, len(text))
-						log.Printf("[stream]   content: %s", truncateForLog(text, 300))
-					}
-				case "tool_use":
-					if m, ok := eventData.(map[string]any); ok {
-						toolName, _ := m["tool"].(string)
-						status, _ := m["status"].(string)
-						inputPreview := ""
-						if input, ok := m["input"].(map[string]any); ok {
-							inputJSON, _ := json.Marshal(input)
-							inputPreview = truncateForLog(string(inputJSON), 200)
-						}
-						outputPreview := ""
-						switch out := m["output"].(type) {
-						case string:
-							outputPreview = truncateForLog(out, 300)
-						default:
-							if out != nil {
-								b, _ := json.Marshal(out)
-								outputPreview = truncateForLog(string(b), 300)
-							}
-						}
-						log.Printf("[stream] event#%d type=tool_use tool=%s status=%s", eventCount, toolName, status)
-						if inputPreview != "" {
-							log.Printf("[stream]   input:  %s", inputPreview)
-						}
-						if outputPreview != "" {
-							log.Printf("[stream]   output: %s", outputPreview)
-						}
-					}
-				case "step_start":
-					if part, ok := event["part"].(map[string]any); ok {
-						reason, _ := part["reason"].(string)
-						snapshot, _ := part["snapshot"].(string)
-						partType, _ := part["type"].(string)
-						log.Printf("[stream] event#%d type=step_start reason=%q partType=%s snapshot=%s",
-							eventCount, reason, partType, truncateForLog(snapshot, 12))
-					} else {
-						log.Printf("[stream] event#%d type=step_start", eventCount)
-					}
-				case "step_finish":
-					if part, ok := event["part"].(map[string]any); ok {
-						reason, _ := part["reason"].(string)
-						snapshot, _ := part["snapshot"].(string)
-						cost, _ := part["cost"].(float64)
-						tokens, _ := part["tokens"].(map[string]any)
-						log.Printf("[stream] event#%d type=step_finish reason=%q cost=$%.4f", eventCount, reason, cost)
-						if tokens != nil {
-							in, _ := tokens["input"].(float64)
-							out, _ := tokens["output"].(float64)
-							log.Printf("[stream]   tokens: input=%.0f output=%.0f snapshot=%s", in, out, truncateForLog(snapshot, 12))
-						}
-					} else {
-						log.Printf("[stream] event#%d type=step_finish", eventCount)
-					}
-				default:
-					log.Printf("[stream] event#%d type=%s", eventCount, eventType)
-				}
-
-				// Collect text and tool outputs for final response
-				if eventType == "text" {
-					if text, ok := eventData.(string); ok {
-						textCollector.WriteString(text)
-						// Send progress with accumulated text for real-time display
-						sendProgress(w, flusher, req.ID, eventCount, textCollector.String())
-					}
-				} else if eventType == "tool_use" {
-					if m, ok := eventData.(map[string]any); ok {
-						toolName, _ := m["tool"].(string)
-						status, _ := m["status"].(string)
-						if status == "completed" {
-							if toolName != "" {
-								if output, ok := m["output"].(string); ok && output != "" {
-									toolOutputs = append(toolOutputs, fmt.Sprintf("[Tool: %s]\n%s", toolName, output))
-								}
-							}
-							// Progress: tool completed (user sees activity)
-							sendProgress(w, flusher, req.ID, eventCount, fmt.Sprintf("Tool %s completed", toolName))
-						}
-					}
-				} else if eventType == "step_start" || eventType == "step_finish" {
-					// Progress: step update (user sees activity)
-					if m, ok := eventData.(map[string]any); ok {
-						reason, _ := m["reason"].(string)
-						msg := eventType
-						if reason != "" {
-							msg = fmt.Sprintf("%s: %s", eventType, reason)
-						}
-						sendProgress(w, flusher, req.ID, eventCount, msg)
-					}
+		weight := 1.0
+		for _, p := range strings.Split(params, ";") {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(p), "q="); ok {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					weight = f
 				}
-
-				// Stream event to client
-				notification := map[string]any{
-					"jsonrpc": "2.0",
-					"method":  "notifications/message",
-					"params": map[string]any{
-						"type": eventType,
-						"data": eventData,
-					},
-				}
-				eventJSON, _ := json.Marshal(notification)
-				_, _ = fmt.Fprintf(w, "data: %s\n\n", eventJSON)
-				flusher.Flush()
-				continue
 			}
 		}
-
-		// Generic: send raw line (for models, session list, exec, or non-JSON toolRun output)
-		eventCount++
-		log.Printf("[stream] raw#%d len=%d preview=%s", eventCount, len(line), truncateForLog(line, 150))
-		textCollector.WriteString(line)
-		textCollector.WriteString("\n")
-		notification := map[string]any{
-			"jsonrpc": "2.0",
-			"method":  "notifications/progress",
-			"params": map[string]any{
-				"data": line,
-			},
+		if specificity > best {
+			best, q = specificity, weight
 		}
-		eventJSON, _ := json.Marshal(notification)
-		_, _ = fmt.Fprintf(w, "data: %s\n\n", eventJSON)
-		flusher.Flush()
 	}
+	return q
+}
 
-	exitCode := 0
-	waitErr := cmd.Wait()
-	if waitErr != nil {
-		var exitErr *exec.ExitError
-		if errors.As(waitErr, &exitErr) {
-			exitCode = exitErr.ExitCode()
-		}
-	}
+// sseEvent is a message sent with an SSE event ID, so the client can resume
+// from it with Last-Event-ID.
+type sseEvent struct {
+	ID  string
+	Msg any
+}
 
-	// Build final result: text + tool outputs (for completeness)
-	resultText := textCollector.String()
-	if len(toolOutputs) > 0 {
-		if resultText != "" {
-			resultText += "\n\n--- Tool Outputs ---\n"
-		} else {
-			resultText = "--- Tool Outputs ---\n"
-		}
-		resultText += strings.Join(toolOutputs, "\n\n")
-	}
-	stderrStr := stderrBuf.String()
-	if stderrStr != "" {
-		if resultText != "" {
-			resultText += "\n\n"
-		}
-		resultText += "[stderr]\n" + stderrStr
-	}
-	if exitCode != 0 {
-		resultText += fmt.Sprintf("\n[exit code: %d]", exitCode)
-	}
+// sseStream switches the response to an event stream on the first message.
+type sseStream struct {
+	w       http.ResponseWriter
+	mu      sync.Mutex
+	started bool
+}
 
-	// Log completion summary
-	if len(eventTypeCounts) > 0 {
-		counts := make([]string, 0, len(eventTypeCounts))
-		for k, v := range eventTypeCounts {
-			counts = append(counts, fmt.Sprintf("%s=%d", k, v))
-		}
-		log.Printf("[tools/call] done tool=%s events=%d counts=%v resultLen=%d exitCode=%d stderrLen=%d",
-			params.Name, eventCount, counts, len(resultText), exitCode, len(stderrStr))
-	} else {
-		log.Printf("[tools/call] done tool=%s lines=%d resultLen=%d exitCode=%d stderrLen=%d",
-			params.Name, eventCount, len(resultText), exitCode, len(stderrStr))
+func (s *sseStream) send(msg any) {
+	var id string
+	if ev, ok := msg.(sseEvent); ok {
+		id, msg = ev.ID, ev.Msg
 	}
-	log.Printf("[tools/call] result preview: %s", truncateForLog(resultText, 200))
-
-	result := toolCallResult{
-		Content: []toolContent{{Type: "text", Text: resultText}},
-		IsError: exitCode != 0,
+	b, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("[sse] marshal error: %v", err)
+		return
 	}
 
-	resp := mcpResponse{
-		JSONRPC: "2.0",
-		ID:      req.ID,
-		Result:  result,
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.start()
+	if id != "" {
+		_, _ = fmt.Fprintf(s.w, "id: %s\n", id)
+	}
+	_, _ = fmt.Fprintf(s.w, "data: %s\n\n", b)
+	if flusher, ok := s.w.(http.Flusher); ok {
+		flusher.Flush()
 	}
-	respJSON, _ := json.Marshal(resp)
-	_, _ = fmt.Fprintf(w, "data: %s\n\n", respJSON)
-	flusher.Flush()
 }
 
-// extractEventData extracts readable content from opencode-cli JSON events
-func extractEventData(event map[string]any) any {
-	eventType, _ := event["type"].(string)
-	part, ok := event["part"].(map[string]any)
-	if !ok {
-		return event
-	}
+// open starts the event stream before the first message.
+func (s *sseStream) open() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.start()
+}
 
-	switch eventType {
-	case "text":
-		if text, ok := part["text"].(string); ok {
-			return text
-		}
-	case "tool_use":
-		toolName, _ := part["tool"].(string)
-		if state, ok := part["state"].(map[string]any); ok {
-			status, _ := state["status"].(string)
-			result := map[string]any{
-				"tool":   toolName,
-				"status": status,
-			}
-			if input, ok := state["input"].(map[string]any); ok {
-				result["input"] = input
-			}
-			if output, ok := state["output"]; ok {
-				result["output"] = output
-			}
-			if errMsg, ok := state["error"].(string); ok && errMsg != "" {
-				result["error"] = errMsg
-			}
-			return result
-		}
-		return map[string]any{"tool": toolName, "status": "unknown"}
-	case "step_start":
-		reason, _ := part["reason"].(string)
-		return map[string]any{"type": "step_start", "reason": reason}
-	case "step_finish":
-		reason, _ := part["reason"].(string)
-		return map[string]any{"type": "step_finish", "reason": reason}
+// comment writes an SSE comment, which clients ignore.
+func (s *sseStream) comment(text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.start()
+	_, _ = fmt.Fprintf(s.w, ": %s\n\n", text)
+	if flusher, ok := s.w.(http.Flusher); ok {
+		flusher.Flush()
 	}
-
-	return event
 }
 
-// parseJSONEventStream parses opencode-cli JSON event stream and extracts readable text.
-// Preserves step_start, step_finish, tool_use (all states: in_progress, completed, error).
-func parseJSONEventStream(jsonLines string) string {
-	var parts []string
-
-	lines := strings.Split(jsonLines, "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-
-		var event map[string]any
-		if err := json.Unmarshal([]byte(line), &event); err != nil {
-			continue
-		}
-
-		eventType, _ := event["type"].(string)
-		part, ok := event["part"].(map[string]any)
-		if !ok {
-			continue
-		}
-
-		switch eventType {
-		case "text":
-			if text, ok := part["text"].(string); ok && text != "" {
-				parts = append(parts, text)
-			}
-		case "step_start":
-			if reason, ok := part["reason"].(string); ok && reason != "" {
-				parts = append(parts, fmt.Sprintf("\n[Step started: %s]\n", reason))
-			}
-		case "step_finish":
-			if reason, ok := part["reason"].(string); ok && reason != "" {
-				parts = append(parts, fmt.Sprintf("[Step finished: %s]\n", reason))
-			}
-		case "tool_use":
-			toolName, _ := part["tool"].(string)
-			if state, ok := part["state"].(map[string]any); ok {
-				status, _ := state["status"].(string)
-				switch status {
-				case "in_progress":
-					parts = append(parts, fmt.Sprintf("[Tool: %s] running...\n", toolName))
-				case "completed":
-					if output, ok := state["output"].(string); ok && output != "" {
-						parts = append(parts, fmt.Sprintf("[Tool: %s]\n%s\n", toolName, output))
-					}
-				case "error":
-					errMsg, _ := state["error"].(string)
-					if errMsg == "" {
-						errMsg = "unknown error"
-					}
-					parts = append(parts, fmt.Sprintf("[Tool: %s] error: %s\n", toolName, errMsg))
-				}
-			}
-		}
+// start sets the event stream headers once. s.mu is held.
+func (s *sseStream) start() {
+	if s.started {
+		return
 	}
-
-	return strings.Join(parts, "")
+	// SSE response - disable buffering for real-time streaming
+	s.w.Header().Set("Content-Type", "text/event-stream")
+	s.w.Header().Set("Cache-Control", "no-cache")
+	s.w.Header().Set("Connection", "keep-alive")
+	s.w.Header().Set("X-Accel-Buffering", "no") // nginx: disable proxy buffering
+	s.started = true
 }
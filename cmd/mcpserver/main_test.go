@@ -4,15 +4,17 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
+
+	"opencode-mcp/internal/mcp"
 )
 
 // Test helpers
@@ -269,7 +271,7 @@ func TestSessionStore(t *testing.T) {
 	store := &sessionStore{sessions: make(map[string]*session)}
 
 	// Create session
-	sess1 := store.create()
+	sess1 := store.create("", clientCapabilities{})
 	if sess1 == nil {
 		t.Fatal("create() returned nil")
 	}
@@ -290,100 +292,66 @@ func TestSessionStore(t *testing.T) {
 	}
 
 	// Create multiple sessions
-	sess2 := store.create()
+	sess2 := store.create("", clientCapabilities{})
 	if sess2.id == sess1.id {
 		t.Error("session IDs should be unique")
 	}
 }
 
-// Test extractEventData
-func TestExtractEventData(t *testing.T) {
-	tests := []struct {
-		name  string
-		event map[string]any
-		check func(t *testing.T, result any)
-	}{
-		{
-			name: "text event",
-			event: map[string]any{
-				"type": "text",
-				"part": map[string]any{
-					"text": "Hello, world!",
-				},
-			},
-			check: func(t *testing.T, result any) {
-				if result != "Hello, world!" {
-					t.Errorf("expected 'Hello, world!', got %v", result)
-				}
-			},
-		},
-		{
-			name: "tool_use event",
-			event: map[string]any{
-				"type": "tool_use",
-				"part": map[string]any{
-					"tool": "read_file",
-					"state": map[string]any{
-						"status": "completed",
-						"input":  map[string]any{"path": "/tmp/test.txt"},
-						"output": "file contents",
-					},
-				},
-			},
-			check: func(t *testing.T, result any) {
-				m, ok := result.(map[string]any)
-				if !ok {
-					t.Fatalf("expected map, got %T", result)
-				}
-				if m["tool"] != "read_file" {
-					t.Errorf("expected tool 'read_file', got %v", m["tool"])
-				}
-				if m["status"] != "completed" {
-					t.Errorf("expected status 'completed', got %v", m["status"])
-				}
-			},
-		},
-		{
-			name: "step_start event",
-			event: map[string]any{
-				"type": "step_start",
-				"part": map[string]any{
-					"reason": "user_request",
-				},
-			},
-			check: func(t *testing.T, result any) {
-				m, ok := result.(map[string]any)
-				if !ok {
-					t.Fatalf("expected map, got %T", result)
-				}
-				if m["type"] != "step_start" {
-					t.Errorf("expected type 'step_start', got %v", m["type"])
-				}
-			},
-		},
-		{
-			name: "event without part",
-			event: map[string]any{
-				"type": "unknown",
-				"data": "something",
-			},
-			check: func(t *testing.T, result any) {
-				m, ok := result.(map[string]any)
-				if !ok {
-					t.Fatalf("expected map, got %T", result)
-				}
-				if m["type"] != "unknown" {
-					t.Errorf("expected original event to be returned")
-				}
-			},
-		},
+// Test idle sessions expire, here and in the store, unless streaming or
+// seen by another instance
+func TestSessionExpiry(t *testing.T) {
+	st, _ := openStore("")
+	ttl := time.Hour
+	sessions := &sessionStore{sessions: make(map[string]*session), store: st, ttl: ttl}
+	idle := func(sess *session) {
+		sess.lastSeen.Store(time.Now().Add(-2 * ttl).UnixNano())
+		sessions.persist(sess)
 	}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			result := extractEventData(tt.event)
-			tt.check(t, result)
-		})
+	stale := sessions.create("", clientCapabilities{})
+	idle(stale)
+	handler := createMCPHandler(sessions, serverConfig{})
+	req := httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"tools/list"}`))
+	req.Header.Set("Mcp-Session-Id", stale.id)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("request with an expired session = %d, want 404", rec.Code)
+	}
+	if ok, _ := st.get(sessionsCollection, stale.id, &storedSession{}); ok {
+		t.Error("expired session still in the store")
+	}
+
+	live := sessions.create("", clientCapabilities{})
+	streaming := sessions.create("", clientCapabilities{})
+	idle(streaming)
+	_, stop := streaming.subscribe(0)
+	defer stop()
+	swept := sessions.create("", clientCapabilities{})
+	idle(swept)
+	elsewhere := sessions.create("", clientCapabilities{})
+	idle(elsewhere) // seen recently by another instance
+	_ = st.put(sessionsCollection, elsewhere.id, storedSession{ID: elsewhere.id, LastSeen: time.Now()})
+	_ = st.put(sessionsCollection, "gone", storedSession{ID: "gone", CreatedAt: time.Now().Add(-2 * ttl)})
+
+	if n := sessions.sweep(time.Now()); n != 2 {
+		t.Errorf("swept %d sessions, want 2", n)
+	}
+	for _, sess := range []*session{live, streaming, elsewhere} {
+		if sessions.get(sess.id) != sess {
+			t.Errorf("session %s dropped", sess.id)
+		}
+	}
+	if sessions.get(swept.id) != nil || sessions.get("gone") != nil {
+		t.Error("expired sessions kept")
+	}
+
+	keep := &sessionStore{sessions: make(map[string]*session)}
+	old := keep.create("", clientCapabilities{})
+	old.lastSeen.Store(time.Now().Add(-2 * ttl).UnixNano())
+	if keep.sweep(time.Now()) != 0 || keep.get(old.id) != old {
+		t.Error("session expired without a TTL")
 	}
 }
 
@@ -425,8 +393,8 @@ func TestMCPOptionsEndpoint(t *testing.T) {
 	if rec.Code != http.StatusNoContent {
 		t.Errorf("status code = %d, want %d", rec.Code, http.StatusNoContent)
 	}
-	if allow := rec.Header().Get("Allow"); allow != "POST, OPTIONS" {
-		t.Errorf("Allow header = %q, want %q", allow, "POST, OPTIONS")
+	if allow := rec.Header().Get("Allow"); allow != "GET, POST, DELETE, OPTIONS" {
+		t.Errorf("Allow header = %q, want %q", allow, "GET, POST, DELETE, OPTIONS")
 	}
 }
 
@@ -471,8 +439,45 @@ func TestMCPInitialize(t *testing.T) {
 	if !ok {
 		t.Fatal("result is not a map")
 	}
-	if result["protocolVersion"] != "2024-11-05" {
-		t.Errorf("protocolVersion = %v, want %v", result["protocolVersion"], "2024-11-05")
+	if result["protocolVersion"] != mcp.ProtocolVersion {
+		t.Errorf("protocolVersion = %v, want %v", result["protocolVersion"], mcp.ProtocolVersion)
+	}
+}
+
+// Test the config file sets serverInfo and instructions, defaulting to the
+// build's version
+func TestServerInfo(t *testing.T) {
+	fc, issues := lintFileConfig("config.json", []byte(`{"serverInfo":{"name":"acme-agent"},"instructions":"Always pass cwd."}`))
+	if len(issues) != 0 {
+		t.Fatalf("issues: %v", issues)
+	}
+	cfg := serverConfig{}
+	applyFileConfig(&cfg, fc)
+	handler := createMCPHandler(&sessionStore{sessions: make(map[string]*session)}, cfg)
+	initialize := func(protocol string) map[string]any {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"`+protocol+`"}}`))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		var resp struct {
+			Result map[string]any `json:"result"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp.Result
+	}
+
+	result := initialize("2025-06-18")
+	info, _ := result["serverInfo"].(map[string]any)
+	if info["name"] != "acme-agent" || info["version"] != serverVersion || serverVersion == "0.1.0" {
+		t.Errorf("serverInfo = %v", info)
+	}
+	if result["instructions"] != "Always pass cwd." {
+		t.Errorf("instructions = %v", result["instructions"])
+	}
+	if result := initialize("2024-11-05"); result["instructions"] != nil {
+		t.Errorf("instructions for 2024-11-05: %v", result["instructions"])
 	}
 }
 
@@ -522,6 +527,7 @@ func TestMCPToolsList(t *testing.T) {
 		toolExec:        false,
 		toolRun:         false,
 		toolModels:      false,
+		toolModelInfo:   false,
 		toolSessionList: false,
 		toolAgentList:   false,
 	}
@@ -535,6 +541,26 @@ func TestMCPToolsList(t *testing.T) {
 		if _, exists := expectedTools[name]; exists {
 			expectedTools[name] = true
 		}
+		// Every built-in tool tells clients whether it modifies anything
+		annotations, _ := tool["annotations"].(map[string]any)
+		if _, ok := annotations["readOnlyHint"].(bool); builtinToolNames[name] && !ok {
+			t.Errorf("%s: annotations = %v, want a readOnlyHint", name, tool["annotations"])
+		}
+	}
+	// The tools both servers offer are annotated alike
+	for _, shared := range []mcpTool{mcp.RunTool(), mcp.ExecTool(), mcp.ModelsTool()} {
+		for _, toolRaw := range toolsRaw {
+			tool := toolRaw.(map[string]any)
+			if tool["name"] != shared.Name {
+				continue
+			}
+			var want any
+			b, _ := json.Marshal(shared.Annotations)
+			_ = json.Unmarshal(b, &want)
+			if !reflect.DeepEqual(tool["annotations"], want) {
+				t.Errorf("%s: annotations = %v, want the stdio server's %v", shared.Name, tool["annotations"], want)
+			}
+		}
 	}
 
 	for name, found := range expectedTools {
@@ -544,6 +570,34 @@ func TestMCPToolsList(t *testing.T) {
 	}
 }
 
+// Test list methods reject cursors they didn't issue, and need no cursor
+// while everything fits on one page
+func TestMCPListCursor(t *testing.T) {
+	st, _ := openStore("")
+	handler := createMCPHandler(&sessionStore{sessions: make(map[string]*session)}, serverConfig{Store: st})
+	for _, method := range []string{"tools/list", "prompts/list", "resources/list"} {
+		for _, params := range []string{`{}`, `{"cursor":"bogus"}`} {
+			body := `{"jsonrpc":"2.0","id":1,"method":"` + method + `","params":` + params + `}`
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(body)))
+			var resp struct {
+				Result map[string]any `json:"result"`
+				Error  *mcpError      `json:"error"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("%s %s: %v", method, params, err)
+			}
+			if params == `{}` {
+				if _, more := resp.Result["nextCursor"]; resp.Error != nil || more {
+					t.Errorf("%s: %s", method, rec.Body)
+				}
+			} else if resp.Error == nil || resp.Error.Code != -32602 {
+				t.Errorf("%s with a bogus cursor: %s", method, rec.Body)
+			}
+		}
+	}
+}
+
 // Test MCP error responses
 func TestMCPErrors(t *testing.T) {
 	sessions := &sessionStore{sessions: make(map[string]*session)}
@@ -554,24 +608,35 @@ func TestMCPErrors(t *testing.T) {
 		body     string
 		wantCode int
 		wantMsg  string
+		wantData string
 	}{
 		{
 			name:     "invalid JSON",
 			body:     "not json",
 			wantCode: -32700,
 			wantMsg:  "invalid JSON",
+			wantData: "OC-1003",
 		},
 		{
 			name:     "missing method",
 			body:     `{"jsonrpc":"2.0","id":1}`,
 			wantCode: -32600,
 			wantMsg:  "missing method",
+			wantData: "OC-1003",
 		},
 		{
 			name:     "unknown method",
 			body:     `{"jsonrpc":"2.0","method":"unknown/method","id":1}`,
 			wantCode: -32601,
 			wantMsg:  "method not found",
+			wantData: "OC-1002",
+		},
+		{
+			name:     "invalid cwd",
+			body:     `{"jsonrpc":"2.0","method":"tools/call","id":1,"params":{"name":"opencode_run","arguments":{"message":"hi","cwd":"/nonexistent"}}}`,
+			wantCode: -32602,
+			wantMsg:  "invalid cwd",
+			wantData: "OC-1001",
 		},
 	}
 
@@ -597,6 +662,43 @@ func TestMCPErrors(t *testing.T) {
 			if !strings.Contains(resp.Error.Message, tt.wantMsg) {
 				t.Errorf("error message = %q, want containing %q", resp.Error.Message, tt.wantMsg)
 			}
+			if resp.Error.Data == nil || resp.Error.Data.Code != tt.wantData {
+				t.Errorf("error data = %+v, want code %s", resp.Error.Data, tt.wantData)
+			}
+		})
+	}
+}
+
+// Test ids are echoed exactly as sent in results, errors and progress tokens
+func TestMCPRequestIDs(t *testing.T) {
+	handler := createMCPHandler(&sessionStore{sessions: make(map[string]*session)}, serverConfig{})
+	progress := func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+		call.progress(1, "working")
+		return &toolCallResult{Content: []toolContent{{Type: "text", Text: "done"}}}, nil
+	}
+
+	for _, id := range []string{`1`, `1.0`, `-0`, `1e3`, `9007199254740993`, `123456789012345678901234567890`, `"abc"`, `"1"`} {
+		t.Run(id, func(t *testing.T) {
+			for _, method := range []string{"tools/list", "no/such/method"} {
+				body := `{"jsonrpc":"2.0","id":` + id + `,"method":"` + method + `"}`
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(body)))
+				var resp mcpResponse
+				if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+					t.Fatal(err)
+				}
+				if string(resp.ID) != id {
+					t.Errorf("%s: id = %s, want %s", method, resp.ID, id)
+				}
+			}
+
+			rec := httptest.NewRecorder()
+			handleToolsCallSSE(rec, context.Background(), progress, mcpRequest{Request: mcp.Request{ID: json.RawMessage(id), Params: json.RawMessage(`{"name":"x"}`)}})
+			for _, want := range []string{`"progressToken":` + id, `"id":` + id} {
+				if !strings.Contains(rec.Body.String(), want) {
+					t.Errorf("SSE stream lacks %s:\n%s", want, rec.Body.String())
+				}
+			}
 		})
 	}
 }
@@ -699,7 +801,7 @@ func TestJsonResponseWriter(t *testing.T) {
 // Test writeMCPError
 func TestWriteMCPError(t *testing.T) {
 	rec := httptest.NewRecorder()
-	writeMCPError(rec, 42, -32000, "test error")
+	writeMCPError(rec, json.RawMessage("42"), -32000, "test error")
 
 	if rec.Header().Get("Content-Type") != "application/json" {
 		t.Errorf("Content-Type = %q, want %q", rec.Header().Get("Content-Type"), "application/json")
@@ -713,8 +815,8 @@ func TestWriteMCPError(t *testing.T) {
 	if resp.JSONRPC != "2.0" {
 		t.Errorf("jsonrpc = %q, want %q", resp.JSONRPC, "2.0")
 	}
-	if resp.ID != float64(42) { // JSON numbers are float64
-		t.Errorf("id = %v, want 42", resp.ID)
+	if string(resp.ID) != "42" {
+		t.Errorf("id = %s, want 42", resp.ID)
 	}
 	if resp.Error == nil {
 		t.Fatal("expected error")
@@ -972,9 +1074,10 @@ func TestToolsCallValidation(t *testing.T) {
 	handler := createMCPHandler(sessions, cfg)
 
 	tests := []struct {
-		name    string
-		params  map[string]any
-		wantErr string
+		name        string
+		params      map[string]any
+		wantErr     string
+		wantPointer string
 	}{
 		{
 			name: "exec missing args",
@@ -1000,6 +1103,33 @@ func TestToolsCallValidation(t *testing.T) {
 			},
 			wantErr: "invalid cwd",
 		},
+		{
+			name: "run wrong type",
+			params: map[string]any{
+				"name":      toolRun,
+				"arguments": json.RawMessage(`{"message":"test","files":"a.go"}`),
+			},
+			wantErr:     "/files: must be array, got string",
+			wantPointer: "/files",
+		},
+		{
+			name: "run unknown priority",
+			params: map[string]any{
+				"name":      toolRun,
+				"arguments": json.RawMessage(`{"message":"test","priority":"urgent"}`),
+			},
+			wantErr:     "/priority: must be one of",
+			wantPointer: "/priority",
+		},
+		{
+			name: "run unknown argument",
+			params: map[string]any{
+				"name":      toolRun,
+				"arguments": json.RawMessage(`{"message":"test","modle":"a/b"}`),
+			},
+			wantErr:     "/modle: is not a known argument",
+			wantPointer: "/modle",
+		},
 	}
 
 	for _, tt := range tests {
@@ -1028,6 +1158,9 @@ func TestToolsCallValidation(t *testing.T) {
 			if !strings.Contains(resp.Error.Message, tt.wantErr) {
 				t.Errorf("error message = %q, want containing %q", resp.Error.Message, tt.wantErr)
 			}
+			if tt.wantPointer != "" && (resp.Error.Data == nil || resp.Error.Data.Pointer != tt.wantPointer) {
+				t.Errorf("error data = %+v, want pointer %q", resp.Error.Data, tt.wantPointer)
+			}
 		})
 	}
 }
@@ -1082,12 +1215,67 @@ echo '{"type":"text","part":{"text":"World"}}'
 	}
 }
 
+// Test tools/call replies with plain JSON to clients that don't accept an
+// event stream
+func TestToolsCallJSONWithoutEventStream(t *testing.T) {
+	mockScript := filepath.Join(t.TempDir(), "mock-opencode")
+	mockContent := `#!/bin/sh
+echo '{"type":"text","part":{"text":"Hello"}}'
+`
+	if err := os.WriteFile(mockScript, []byte(mockContent), 0755); err != nil {
+		t.Fatalf("failed to create mock script: %v", err)
+	}
+	handler := createMCPHandler(&sessionStore{sessions: make(map[string]*session)}, serverConfig{Target: mockScript, DefaultTimeout: 5 * time.Second})
+
+	body := `{"jsonrpc":"2.0","method":"tools/call","id":1,"params":{"name":"` + toolRun + `","arguments":{"message":"test"}}}`
+	req := httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	var resp mcpResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("response is not a single JSON message: %v\n%s", err, rec.Body.String())
+	}
+	if resp.Error != nil || !strings.Contains(rec.Body.String(), "Hello") {
+		t.Errorf("response = %s", rec.Body.String())
+	}
+}
+
+// Test Accept negotiation of text/event-stream
+func TestAcceptsEventStream(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{"", true},
+		{"application/json, text/event-stream", true},
+		{"text/event-stream", true},
+		{"*/*", true},
+		{"text/*;q=0.5", true},
+		{"application/json", false},
+		{"application/json, text/event-stream;q=0", false},
+		{"*/*, text/event-stream;q=0", false},
+		{"text/event-stream;q=0, */*", false},
+		{"Text/Event-Stream", true},
+	}
+	for _, tt := range tests {
+		if got := acceptsEventStream(tt.accept); got != tt.want {
+			t.Errorf("acceptsEventStream(%q) = %v, want %v", tt.accept, got, tt.want)
+		}
+	}
+}
+
 // Test HTTP method validation
 func TestHTTPMethodValidation(t *testing.T) {
 	sessions := &sessionStore{sessions: make(map[string]*session)}
 	handler := createMCPHandler(sessions, serverConfig{})
 
-	methods := []string{http.MethodGet, http.MethodPut, http.MethodDelete, http.MethodPatch}
+	methods := []string{http.MethodPut, http.MethodPatch}
 	for _, method := range methods {
 		t.Run(method, func(t *testing.T) {
 			req := httptest.NewRequest(method, "/mcp", nil)
@@ -1098,86 +1286,88 @@ func TestHTTPMethodValidation(t *testing.T) {
 			if rec.Code != http.StatusMethodNotAllowed {
 				t.Errorf("status code = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
 			}
+			if allow := rec.Header().Get("Allow"); allow != "GET, POST, DELETE, OPTIONS" {
+				t.Errorf("Allow header = %q, want %q", allow, "GET, POST, DELETE, OPTIONS")
+			}
 		})
 	}
 }
 
-// Helper to create MCP handler for testing
-func createMCPHandler(sessions *sessionStore, cfg serverConfig) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		// Handle OPTIONS for endpoint discovery
-		if r.Method == http.MethodOptions {
-			w.Header().Set("Allow", "POST, OPTIONS")
-			w.Header().Set("Accept", "application/json")
-			w.WriteHeader(http.StatusNoContent)
-			return
-		}
-
-		if r.Method != http.MethodPost {
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
-
-		var req mcpRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			writeMCPError(w, nil, -32700, "invalid JSON")
-			return
-		}
-		if req.Method == "" {
-			writeMCPError(w, req.ID, -32600, "missing method")
-			return
-		}
+// Test Streamable HTTP status codes for sessions and notifications
+func TestMCPSessionStatus(t *testing.T) {
+	sessions := &sessionStore{sessions: make(map[string]*session)}
+	handler := createMCPHandler(sessions, serverConfig{})
+	strict := createMCPHandler(sessions, serverConfig{RequireSession: true})
+	known := sessions.create("", clientCapabilities{}).id
 
-		// Handle session
-		sessionID := r.Header.Get("Mcp-Session-Id")
-		var sess *session
-
-		switch req.Method {
-		case "initialize":
-			// Create new session
-			sess = sessions.create()
-			sessionID = sess.id
-			w.Header().Set("Mcp-Session-Id", sessionID)
-			handleInitialize(w, req)
-			return
-		case "notifications/initialized":
-			// Client notification, just acknowledge
-			w.WriteHeader(http.StatusNoContent)
-			return
-		default:
-			// Validate session for non-init requests
-			if sessionID != "" {
-				sess = sessions.get(sessionID)
+	tests := []struct {
+		name       string
+		strict     bool // MCP_REQUIRE_SESSION
+		session    string
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{"request without session", false, "", `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`, http.StatusOK, ""},
+		{"request with known session", false, known, `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`, http.StatusOK, ""},
+		{"request with unknown session", false, "stale", `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`, http.StatusNotFound, errSessionNotFound.Code},
+		{"ping with unknown session", false, "stale", `{"jsonrpc":"2.0","id":1,"method":"ping"}`, http.StatusNotFound, errSessionNotFound.Code},
+		{"notification", false, known, `{"jsonrpc":"2.0","method":"notifications/initialized"}`, http.StatusAccepted, ""},
+		{"notification with unknown session", false, "stale", `{"jsonrpc":"2.0","method":"notifications/initialized"}`, http.StatusNotFound, errSessionNotFound.Code},
+		{"initialize ignores a stale session", false, "stale", `{"jsonrpc":"2.0","id":1,"method":"initialize"}`, http.StatusOK, ""},
+		{"strict: request without session", true, "", `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`, http.StatusBadRequest, errSessionRequired.Code},
+		{"strict: notification without session", true, "", `{"jsonrpc":"2.0","method":"notifications/initialized"}`, http.StatusBadRequest, errSessionRequired.Code},
+		{"strict: request with known session", true, known, `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`, http.StatusOK, ""},
+		{"strict: request with unknown session", true, "stale", `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`, http.StatusNotFound, errSessionNotFound.Code},
+		{"strict: initialize without session", true, "", `{"jsonrpc":"2.0","id":1,"method":"initialize"}`, http.StatusOK, ""},
+		{"strict: ping without session", true, "", `{"jsonrpc":"2.0","id":1,"method":"ping"}`, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := handler
+			if tt.strict {
+				h = strict
 			}
-		}
-
-		if sess != nil {
-			w.Header().Set("Mcp-Session-Id", sess.id)
-		}
+			req := httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(tt.body))
+			if tt.session != "" {
+				req.Header.Set("Mcp-Session-Id", tt.session)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
 
-		switch req.Method {
-		case "tools/list":
-			handleToolsList(w, req)
-		case "tools/call":
-			handleToolsCallSSE(w, r.Context(), cfg, req)
-		default:
-			writeMCPError(w, req.ID, -32601, fmt.Sprintf("method not found: %s", req.Method))
-		}
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusAccepted && rec.Body.Len() != 0 {
+				t.Errorf("202 body = %q, want empty", rec.Body.String())
+			}
+			if tt.wantCode != "" {
+				var resp mcpResponse
+				if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Error == nil || resp.Error.Data == nil || resp.Error.Data.Code != tt.wantCode {
+					t.Errorf("body = %s, want error %s", rec.Body.String(), tt.wantCode)
+				}
+			}
+		})
 	}
 }
 
+// Helper to create MCP handler for testing
+func createMCPHandler(sessions *sessionStore, cfg serverConfig) http.HandlerFunc {
+	return newMCPHandler(sessions, cfg)
+}
+
 // Benchmark tests
 func BenchmarkSessionCreate(b *testing.B) {
 	store := &sessionStore{sessions: make(map[string]*session)}
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		store.create()
+		store.create("", clientCapabilities{})
 	}
 }
 
 func BenchmarkSessionGet(b *testing.B) {
 	store := &sessionStore{sessions: make(map[string]*session)}
-	sess := store.create()
+	sess := store.create("", clientCapabilities{})
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		store.get(sess.id)
@@ -1192,19 +1382,6 @@ func BenchmarkValidateCwd(b *testing.B) {
 	}
 }
 
-func BenchmarkExtractEventData(b *testing.B) {
-	event := map[string]any{
-		"type": "text",
-		"part": map[string]any{
-			"text": "Hello, world!",
-		},
-	}
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		extractEventData(event)
-	}
-}
-
 // Test streamLines function
 func TestStreamLines(t *testing.T) {
 	input := "line1\nline2\nline3\n"
@@ -1214,7 +1391,7 @@ func TestStreamLines(t *testing.T) {
 	// Mock flusher
 	flusher := &mockFlusher{w: &buf}
 
-	err := streamLines(reader, flusher, flusher)
+	err := streamLines(reader, flusher, flusher, true)
 	if err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const gitTimeout = 5 * time.Second
+
+// runManifest records what an opencode_run executed with, so its result can
+// be audited and reproduced.
+type runManifest struct {
+	ServerVersion   string           `json:"serverVersion"`
+	OpencodeVersion string           `json:"opencodeVersion,omitempty"`
+	Backend         string           `json:"backend,omitempty"`
+	Model           string           `json:"model,omitempty"`
+	Agent           string           `json:"agent,omitempty"`
+	Cwd             string           `json:"cwd,omitempty"`
+	GitCommitBefore string           `json:"gitCommitBefore,omitempty"`
+	GitCommitAfter  string           `json:"gitCommitAfter,omitempty"`
+	DiffSHA256      string           `json:"diffSha256,omitempty"` // of `git diff --binary <gitCommitBefore>` after the run
+	Attachments     []attachmentHash `json:"attachments,omitempty"`
+}
+
+type attachmentHash struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256,omitempty"` // empty when the file couldn't be read
+}
+
+// manifestMiddleware attaches a runManifest to every opencode_run result.
+func manifestMiddleware(cfg serverConfig) toolMiddleware {
+	return func(next toolHandler) toolHandler {
+		return func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+			if call.Name != toolRun {
+				return next(ctx, call)
+			}
+			runArgs, mErr := parseRunArgs(call)
+			if mErr != nil {
+				return next(ctx, call)
+			}
+			m := runManifest{
+				ServerVersion:   serverVersion,
+				OpencodeVersion: cfg.withBinary(call.Binary).CLIVersion,
+				Backend:         cfg.Backend,
+				Model:           runArgs.Model,
+				Agent:           runArgs.Agent,
+				Cwd:             runArgs.Cwd,
+			}
+			if m.Cwd == "" {
+				m.Cwd = call.Cwd
+			}
+			if m.Model == "" {
+				m.Model = getDefaultModel(cfg.forTenant(call.Tenant))
+			}
+			m.GitCommitBefore = gitHead(ctx, m.Cwd)
+			for _, f := range runArgs.Files {
+				m.Attachments = append(m.Attachments, hashAttachment(m.Cwd, f))
+			}
+
+			result, mErr := next(ctx, call)
+			if result == nil {
+				return result, mErr
+			}
+			m.GitCommitAfter = gitHead(ctx, m.Cwd)
+			m.DiffSHA256 = gitDiffHash(ctx, m.Cwd, m.GitCommitBefore)
+			result.manifest = &m
+			if cfg.Signer != nil {
+				payload, _ := json.Marshal(m)
+				result.attestation = cfg.Signer.sign(payload)
+				if result.Meta == nil {
+					result.Meta = map[string]any{}
+				}
+				result.Meta["attestation"] = result.attestation
+			}
+			if result.StructuredContent == nil {
+				result.StructuredContent = result.runResult()
+			}
+			return result, mErr
+		}
+	}
+}
+
+// gitHead returns the commit checked out in dir, or "" when dir isn't inside
+// a git repository.
+func gitHead(ctx context.Context, dir string) string {
+	if dir == "" {
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(ctx, gitTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "git", "-C", dir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// gitDiffHash hashes the changes in dir since commit, committed or not
+// (untracked files aren't included), or returns "" without a commit.
+func gitDiffHash(ctx context.Context, dir, commit string) string {
+	if commit == "" {
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(ctx, gitTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "git", "-C", dir, "diff", "--binary", commit).Output()
+	if err != nil {
+		log.Printf("[manifest] git diff in %s: %v", dir, err)
+		return ""
+	}
+	sum := sha256.Sum256(out)
+	return hex.EncodeToString(sum[:])
+}
+
+// hashAttachment hashes an attached file; relative paths are resolved
+// against cwd like opencode does.
+func hashAttachment(cwd, path string) attachmentHash {
+	h := attachmentHash{Path: path}
+	full := path
+	if !filepath.IsAbs(full) && cwd != "" {
+		full = filepath.Join(cwd, full)
+	}
+	f, err := os.Open(full)
+	if err != nil {
+		log.Printf("[manifest] hash %s: %v", path, err)
+		return h
+	}
+	defer f.Close()
+	sum := sha256.New()
+	n, err := io.Copy(sum, f)
+	if err != nil {
+		log.Printf("[manifest] hash %s: %v", path, err)
+		return h
+	}
+	h.Size = n
+	h.SHA256 = hex.EncodeToString(sum.Sum(nil))
+	return h
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test opencode_run results carry a reproducibility manifest
+func TestRunManifest(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	repo := t.TempDir()
+	gitEnv := []string{"GIT_AUTHOR_NAME=t", "GIT_AUTHOR_EMAIL=t@example.com", "GIT_COMMITTER_NAME=t", "GIT_COMMITTER_EMAIL=t@example.com"}
+	for _, args := range [][]string{{"init", "-q"}, {"commit", "-q", "--allow-empty", "-m", "base"}} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		cmd.Env = append(os.Environ(), gitEnv...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	before := gitHead(context.Background(), repo)
+	if err := os.WriteFile(filepath.Join(repo, "spec.md"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	script := filepath.Join(t.TempDir(), "opencode")
+	content := "#!/bin/sh\ngit commit -q --allow-empty -m run\necho done\n"
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, kv := range gitEnv {
+		k, v, _ := strings.Cut(kv, "=")
+		t.Setenv(k, v)
+	}
+	st, _ := openStore("")
+	pub, key, _ := ed25519.GenerateKey(nil)
+	cfg := serverConfig{Target: script, DefaultTimeout: 5 * time.Second, CLIVersion: "1.2.3", Backend: backendCLI, Store: st, Signer: newSigner(key)}
+	call := &toolCall{ID: json.RawMessage("1"), Name: toolRun, Arguments: json.RawMessage(`{"message":"go","model":"m","cwd":"` + repo + `","files":["spec.md"]}`)}
+	result, mErr := newToolHandler(cfg)(context.Background(), call)
+	if mErr != nil {
+		t.Fatalf("run: %v", mErr)
+	}
+
+	m := result.manifest
+	if m == nil {
+		t.Fatal("no manifest")
+	}
+	if m.ServerVersion != serverVersion || m.OpencodeVersion != "1.2.3" || m.Model != "m" || m.Cwd != repo {
+		t.Errorf("manifest = %+v", m)
+	}
+	if m.GitCommitBefore != before || m.GitCommitAfter == "" || m.GitCommitAfter == before {
+		t.Errorf("commits before=%q after=%q, want before=%q and a new after", m.GitCommitBefore, m.GitCommitAfter, before)
+	}
+	// sha256("hello")
+	want := attachmentHash{Path: "spec.md", Size: 5, SHA256: "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"}
+	if len(m.Attachments) != 1 || m.Attachments[0] != want {
+		t.Errorf("attachments = %+v", m.Attachments)
+	}
+	// sha256 of the empty diff: the run only added an empty commit
+	if m.DiffSHA256 != "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" {
+		t.Errorf("diffSha256 = %q", m.DiffSHA256)
+	}
+	a := result.attestation
+	if a == nil || result.Meta["attestation"] != a {
+		t.Fatalf("attestation = %+v, _meta = %v", a, result.Meta)
+	}
+	payload, _ := base64.StdEncoding.DecodeString(a.Payload)
+	sig, _ := base64.StdEncoding.DecodeString(a.Signature)
+	var signed runManifest
+	if !ed25519.Verify(pub, payload, sig) || json.Unmarshal(payload, &signed) != nil || signed.GitCommitAfter != m.GitCommitAfter {
+		t.Errorf("attestation doesn't verify against the manifest: %+v", a)
+	}
+	if sc, ok := result.StructuredContent.(runResult); !ok || sc.Manifest == nil || sc.Attestation == nil {
+		t.Errorf("structuredContent = %+v", result.StructuredContent)
+	}
+	if rec, ok, _ := (runHistory{st}).get("", call.RunID); !ok || rec.Manifest == nil || rec.Manifest.GitCommitAfter != m.GitCommitAfter || rec.Attestation == nil {
+		t.Errorf("run record manifest = %+v", rec.Manifest)
+	}
+}
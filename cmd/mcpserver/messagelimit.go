@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// MCP_MAX_MESSAGE_CHARS caps the characters an opencode_run sends to the
+// model: the message plus its attachments. MCP_OVERSIZE says what happens
+// to a larger one: reject it with guidance, or split it into parts sent in
+// one session, each to be summarized, followed by a final step that acts on
+// the whole request.
+const (
+	oversizeReject = "reject"
+	oversizeSplit  = "split"
+
+	// minPartChars keeps a tiny limit from splitting a request into
+	// thousands of runs.
+	minPartChars = 1000
+)
+
+// deliveryInfo reports a split delivery in _meta.delivery.
+type deliveryInfo struct {
+	Strategy string `json:"strategy"`
+	Length   int    `json:"length"` // characters of message and attachments
+	Limit    int    `json:"limit"`
+	Parts    int    `json:"parts"` // summarize steps before the final one
+	Session  string `json:"session,omitempty"`
+}
+
+func validateOversize(s string) error {
+	if s != "" && s != oversizeReject && s != oversizeSplit {
+		return fmt.Errorf("%q is not %q or %q", s, oversizeReject, oversizeSplit)
+	}
+	return nil
+}
+
+// attachment is the text of an attached file.
+type attachment struct {
+	name, text string
+}
+
+// readAttachments reads the files of a run; unreadable ones are left for
+// opencode to report.
+func readAttachments(dir string, files []string) []attachment {
+	var out []attachment
+	for _, f := range files {
+		path := f
+		if !filepath.IsAbs(path) && dir != "" {
+			path = filepath.Join(dir, path)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		out = append(out, attachment{f, string(data)})
+	}
+	return out
+}
+
+// messageLimitMiddleware enforces MCP_MAX_MESSAGE_CHARS on opencode_run.
+func messageLimitMiddleware(cfg serverConfig) toolMiddleware {
+	return func(next toolHandler) toolHandler {
+		return func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+			if cfg.MaxMessageChars <= 0 || call.Name != toolRun {
+				return next(ctx, call)
+			}
+			args, mErr := parseRunArgs(call)
+			if mErr != nil {
+				return next(ctx, call)
+			}
+			dir := args.Cwd
+			if dir == "" {
+				dir = call.Cwd
+			}
+			files := readAttachments(dir, args.Files)
+			length := utf8.RuneCountInString(args.Message)
+			for _, f := range files {
+				length += utf8.RuneCountInString(f.text)
+			}
+			if length <= cfg.MaxMessageChars {
+				return next(ctx, call)
+			}
+			if cfg.Oversize != oversizeSplit || cfg.MaxMessageChars < minPartChars+splitOverhead {
+				return nil, errMessageTooLong.err(call.sprintf("the message and its attachments are %d characters, over the limit of %d: shorten the message, attach fewer or smaller files, or split the work into steps with %s", length, cfg.MaxMessageChars, toolPipeline))
+			}
+			return splitRun(ctx, call, next, args, files, deliveryInfo{Strategy: oversizeSplit, Length: length, Limit: cfg.MaxMessageChars})
+		}
+	}
+}
+
+const (
+	splitPartPrompt  = "This is part %d of %d of a request too long to send at once. Reply with a short summary of this part, keeping every detail needed to carry out the request later. Do not change any files yet.\n\n"
+	splitFinalPrompt = "All %d parts of the request have now been sent. Carry out the request they contain, using your summaries and the details above."
+	splitOverhead    = len(splitPartPrompt) + 20
+)
+
+// splitRun sends the request in parts within one session, then asks for it
+// to be carried out. Attachments are inlined into the parts.
+func splitRun(ctx context.Context, call *toolCall, next toolHandler, args runToolArgs, files []attachment, info deliveryInfo) (*toolCallResult, *mcpError) {
+	var text strings.Builder
+	text.WriteString(args.Message)
+	for _, f := range files {
+		fmt.Fprintf(&text, "\n\n=== Attached file: %s ===\n%s", f.name, f.text)
+	}
+	parts := splitText(text.String(), info.Limit-splitOverhead)
+	info.Parts = len(parts)
+	info.Session = args.Session
+	log.Printf("[message-limit] splitting %d characters into %d parts (limit %d)", info.Length, len(parts), info.Limit)
+
+	var raw map[string]any
+	_ = json.Unmarshal(call.Arguments, &raw)
+	step := func(message string, first bool) (*toolCallResult, *mcpError) {
+		stepArgs := make(map[string]any, len(raw))
+		for k, v := range raw {
+			stepArgs[k] = v
+		}
+		stepArgs["message"] = message
+		delete(stepArgs, "files")
+		if !first {
+			// Later steps continue the session of the first; wrapped
+			// agents without session IDs continue their last one
+			delete(stepArgs, "session")
+			stepArgs["continue"] = info.Session == ""
+			if info.Session != "" {
+				stepArgs["session"] = info.Session
+			}
+		}
+		c := *call
+		c.Arguments, _ = json.Marshal(stepArgs)
+		result, mErr := next(ctx, &c)
+		if result != nil && info.Session == "" {
+			info.Session = result.sessionID
+		}
+		return result, mErr
+	}
+
+	for i, part := range parts {
+		call.progressf(i, "%s started (%d/%d)", fmt.Sprintf("part-%d", i+1), i+1, len(parts)+1)
+		result, mErr := step(fmt.Sprintf(splitPartPrompt, i+1, len(parts))+part, i == 0)
+		if mErr != nil || result.IsError {
+			if result != nil {
+				result.setDelivery(info)
+			}
+			return result, mErr
+		}
+	}
+	call.progressf(len(parts), "%s started (%d/%d)", "final", len(parts)+1, len(parts)+1)
+	result, mErr := step(fmt.Sprintf(splitFinalPrompt, len(parts)), false)
+	if result != nil {
+		result.setDelivery(info)
+	}
+	return result, mErr
+}
+
+func (r *toolCallResult) setDelivery(info deliveryInfo) {
+	if r.Meta == nil {
+		r.Meta = map[string]any{}
+	}
+	r.Meta["delivery"] = info
+}
+
+// splitText cuts s into parts of at most size characters, at line breaks
+// where possible.
+func splitText(s string, size int) []string {
+	var parts []string
+	for utf8.RuneCountInString(s) > size {
+		cut := len(s)
+		n := 0
+		for i := range s {
+			if n == size {
+				cut = i
+				break
+			}
+			n++
+		}
+		if nl := strings.LastIndexByte(s[:cut], '\n'); nl > cut/2 {
+			cut = nl + 1
+		}
+		parts = append(parts, s[:cut])
+		s = s[cut:]
+	}
+	if s != "" {
+		parts = append(parts, s)
+	}
+	return parts
+}
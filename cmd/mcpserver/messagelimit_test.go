@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test oversized runs are rejected, or split into summarize steps and a final one
+func TestMessageLimit(t *testing.T) {
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+	script := filepath.Join(dir, "opencode")
+	content := `#!/bin/sh
+printf '%s\n' "$*" >> ` + calls + `
+echo '{"type":"step_start","sessionID":"ses_split","part":{"type":"step-start"}}'
+echo '{"type":"text","part":{"text":"noted"}}'
+`
+	if err := os.WriteFile(script, []byte(content), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "big.txt"), []byte(strings.Repeat("line of the attached file\n", 200)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	args := []byte(`{"message":"rewrite big.txt","cwd":"` + dir + `","model":"m/x","files":["big.txt"]}`)
+
+	cfg := serverConfig{Target: script, DefaultTimeout: 5 * time.Second, MaxMessageChars: 2000, Oversize: oversizeReject}
+	_, mErr := newToolHandler(cfg)(context.Background(), &toolCall{Name: toolRun, Arguments: args})
+	if mErr == nil || mErr.Data.Code != errMessageTooLong.Code || !strings.Contains(mErr.Message, toolPipeline) {
+		t.Fatalf("reject: %+v", mErr)
+	}
+
+	cfg.Oversize = oversizeSplit
+	result, mErr := newToolHandler(cfg)(context.Background(), &toolCall{Name: toolRun, Arguments: args})
+	if mErr != nil || result.IsError {
+		t.Fatalf("split: %+v %+v", mErr, result)
+	}
+	info, _ := result.Meta["delivery"].(deliveryInfo)
+	if info.Strategy != oversizeSplit || info.Parts != 3 || info.Session != "ses_split" || info.Length != 15+200*26 {
+		t.Errorf("delivery = %+v", info)
+	}
+	data, _ := os.ReadFile(calls)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) < info.Parts+1 {
+		t.Fatalf("ran %d steps: %s", len(lines), data)
+	}
+	if strings.Contains(string(data), "--file") || strings.Contains(lines[0], "--session") {
+		t.Errorf("first step: %s", lines[0])
+	}
+	if final := lines[len(lines)-1]; !strings.Contains(final, "--session ses_split") || !strings.Contains(final, "Carry out the request") {
+		t.Errorf("final step: %s", final)
+	}
+
+	for _, tt := range []struct {
+		s    string
+		size int
+		want string
+	}{
+		{"aaaa\nbbbb\ncc", 7, "aaaa\n|bbbb\ncc"},
+		{"ab\ncdef\ngh", 6, "ab\ncde|f\ngh"},
+		{"ééé", 2, "éé|é"},
+	} {
+		if got := strings.Join(splitText(tt.s, tt.size), "|"); got != tt.want {
+			t.Errorf("splitText(%q, %d) = %q, want %q", tt.s, tt.size, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// mockRunnerName is the executable name that turns this binary into a fake
+// opencode for load tests: symlink it as opencode-mock and point MCP_TARGET
+// at the link, so benchmarks exercise the server without model calls.
+const mockRunnerName = "opencode-mock"
+
+func isMockRunner(argv0 string) bool {
+	return strings.TrimSuffix(filepath.Base(argv0), ".exe") == mockRunnerName
+}
+
+// runMockRunner answers the opencode commands the server uses. `run` waits
+// OPENCODE_MOCK_DELAY (default 100ms) and then prints a short event stream.
+func runMockRunner(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "opencode-mock: missing command")
+		return 1
+	}
+	switch args[0] {
+	case "--version":
+		fmt.Println("0.0.0-mock")
+	case "models":
+		fmt.Println(`["mock/small","mock/large"]`)
+	case "run":
+		time.Sleep(getenvDuration("OPENCODE_MOCK_DELAY", 100*time.Millisecond))
+		fmt.Println(`{"type":"step_start","sessionID":"ses_mock","part":{"type":"step-start"}}`)
+		fmt.Println(`{"type":"text","sessionID":"ses_mock","part":{"text":"mock answer"}}`)
+		fmt.Println(`{"type":"step_finish","sessionID":"ses_mock","part":{"cost":0,"tokens":{"input":1,"output":2}}}`)
+	default:
+		fmt.Fprintf(os.Stderr, "opencode-mock: unsupported command %q\n", args[0])
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"opencode-mcp/internal/mcp"
+	"opencode-mcp/internal/runner"
+)
+
+// modelInfo describes one model offered by opencode.
+type modelInfo struct {
+	ID          string     `json:"id"` // provider/name, as accepted by --model
+	Provider    string     `json:"provider"`
+	Name        string     `json:"name"`
+	DisplayName string     `json:"displayName,omitempty"`
+	Cost        *modelCost `json:"cost,omitempty"` // when reported by opencode
+}
+
+// modelCache holds the available models
+var modelCache runner.ModelCache[modelInfo]
+
+// invalidateModelCache forces the next lookup to ask opencode again, e.g.
+// after switching binaries.
+func invalidateModelCache() {
+	modelCache.Invalidate()
+}
+
+// modelWatchInterval is how often the model list is checked for changes
+// while a session has a GET /mcp stream open.
+const modelWatchInterval = 5 * time.Minute
+
+// watchModels announces changes of the model list, e.g. a provider logged
+// in or a binary switched, on the sessions' GET /mcp streams. Without an
+// open stream opencode isn't asked.
+func watchModels(cfg serverConfig, sessions *sessionStore, interval time.Duration) {
+	known := fetchAvailableModels(cfg)
+	for range time.Tick(interval) {
+		known = announceModelChanges(cfg, sessions, known)
+	}
+}
+
+// announceModelChanges fetches the model list afresh and broadcasts how it
+// differs from known, returning the list now known.
+func announceModelChanges(cfg serverConfig, sessions *sessionStore, known []string) []string {
+	if !sessions.streaming() {
+		return known
+	}
+	invalidateModelCache()
+	models := fetchAvailableModels(cfg)
+	if len(models) == 0 {
+		return known // opencode didn't answer; keep what we know
+	}
+	added, removed := diffModels(known, models)
+	if len(added) == 0 && len(removed) == 0 {
+		return known
+	}
+	log.Printf("[models] %d added, %d removed", len(added), len(removed))
+	sessions.broadcast(mcp.Notification(mcp.MethodModelsChanged, map[string]any{
+		"added":   added,
+		"removed": removed,
+		"count":   len(models),
+	}))
+	return models
+}
+
+// diffModels returns the IDs in after but not before, and the reverse.
+func diffModels(before, after []string) (added, removed []string) {
+	in := func(list []string) map[string]bool {
+		m := make(map[string]bool, len(list))
+		for _, id := range list {
+			m[id] = true
+		}
+		return m
+	}
+	was, is := in(before), in(after)
+	added, removed = []string{}, []string{}
+	for _, id := range after {
+		if !was[id] {
+			added = append(added, id)
+		}
+	}
+	for _, id := range before {
+		if !is[id] {
+			removed = append(removed, id)
+		}
+	}
+	return added, removed
+}
+
+// newModelInfo splits a "provider/name" ID.
+func newModelInfo(id, displayName string) modelInfo {
+	provider, name, _ := strings.Cut(id, "/")
+	if displayName == name || displayName == id {
+		displayName = ""
+	}
+	return modelInfo{ID: id, Provider: provider, Name: name, DisplayName: displayName}
+}
+
+// fetchAvailableModels returns the IDs of the available models.
+func fetchAvailableModels(cfg serverConfig) []string {
+	infos := fetchModelInfo(cfg)
+	models := make([]string, 0, len(infos))
+	for _, m := range infos {
+		models = append(models, m.ID)
+	}
+	return models
+}
+
+// fetchModelInfo fetches and caches the list of available models
+func fetchModelInfo(cfg serverConfig) []modelInfo {
+	return modelCache.Get(func(ctx context.Context) ([]modelInfo, error) {
+		if cfg.Backend == backendServe {
+			return newServeClient(cfg.ServeURL).listModels(ctx)
+		}
+		return listCLIModels(ctx, cfg.Target)
+	})
+}
+
+// listCLIModels runs `models --format json`, falling back to parsing the
+// plain table output on CLIs without JSON support.
+func listCLIModels(ctx context.Context, target string) ([]modelInfo, error) {
+	output, err := exec.CommandContext(ctx, target, "models", "--format", "json").Output()
+	if err == nil {
+		if models, ok := parseModelsJSON(output); ok {
+			return models, nil
+		}
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	output, err = exec.CommandContext(ctx, target, "models").Output()
+	if err != nil {
+		return nil, err
+	}
+	return parseModelsTable(string(output)), nil
+}
+
+// parseModelsJSON accepts the JSON shapes printed by `models --format json`:
+// an array of IDs, an array of model objects, a provider → models map, or an
+// object wrapping one of those under "models" or "providers".
+func parseModelsJSON(data []byte) ([]modelInfo, bool) {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, false
+	}
+	var models []modelInfo
+	collectModels(v, "", &models)
+	if len(models) == 0 {
+		return nil, false
+	}
+	sort.SliceStable(models, func(i, j int) bool { return models[i].ID < models[j].ID })
+	return models, true
+}
+
+func collectModels(v any, provider string, out *[]modelInfo) {
+	switch v := v.(type) {
+	case string:
+		id := v
+		if provider != "" && !strings.HasPrefix(id, provider+"/") {
+			id = provider + "/" + id
+		}
+		if strings.Contains(id, "/") {
+			*out = append(*out, newModelInfo(id, ""))
+		}
+	case []any:
+		for _, item := range v {
+			collectModels(item, provider, out)
+		}
+	case map[string]any:
+		for _, key := range []string{"models", "providers"} {
+			if inner, ok := v[key]; ok {
+				p := provider
+				if key == "models" {
+					p = firstString(v, "id", "providerID", "provider")
+					if p == "" {
+						p = provider
+					}
+				}
+				collectModels(inner, p, out)
+				return
+			}
+		}
+		if m, ok := modelFromObject(v, provider); ok {
+			*out = append(*out, m)
+			return
+		}
+		// provider → models map, or model ID → model object map
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			switch inner := v[k].(type) {
+			case map[string]any:
+				if m, ok := modelFromObject(inner, provider); ok {
+					*out = append(*out, m)
+				} else if provider != "" {
+					m := newModelInfo(provider+"/"+k, firstString(inner, "name"))
+					m.Cost = modelCostFrom(inner)
+					*out = append(*out, m)
+				} else {
+					collectModels(inner, k, out)
+				}
+			default:
+				collectModels(inner, k, out)
+			}
+		}
+	}
+}
+
+// modelFromObject reads a model object such as
+// {"id":"gpt-5","providerID":"openai","name":"GPT-5"}.
+func modelFromObject(obj map[string]any, provider string) (modelInfo, bool) {
+	id := firstString(obj, "id", "modelID", "model")
+	if id == "" {
+		return modelInfo{}, false
+	}
+	if p := firstString(obj, "providerID", "provider"); p != "" {
+		provider = p
+	}
+	if !strings.Contains(id, "/") {
+		if provider == "" {
+			return modelInfo{}, false
+		}
+		id = provider + "/" + id
+	}
+	m := newModelInfo(id, firstString(obj, "name", "displayName"))
+	m.Cost = modelCostFrom(obj)
+	return m, true
+}
+
+// modelCostFrom reads {"cost":{"input":3,"output":15}} (USD per million tokens).
+func modelCostFrom(obj map[string]any) *modelCost {
+	cost, ok := obj["cost"].(map[string]any)
+	if !ok {
+		return nil
+	}
+	in, _ := cost["input"].(float64)
+	out, _ := cost["output"].(float64)
+	return &modelCost{Input: in, Output: out}
+}
+
+func firstString(obj map[string]any, keys ...string) string {
+	for _, k := range keys {
+		if s, ok := obj[k].(string); ok && s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// parseModelsTable parses the human-readable `models` output.
+func parseModelsTable(output string) []modelInfo {
+	var models []modelInfo
+	for _, m := range runner.ParseModelsTable(output) {
+		models = append(models, newModelInfo(m.ID, m.DisplayName))
+	}
+	return models
+}
+
+// findModelInfo looks up a model by ID, or by bare name when unambiguous.
+func findModelInfo(models []modelInfo, query string) (modelInfo, error) {
+	var matches []modelInfo
+	for _, m := range models {
+		if m.ID == query {
+			return m, nil
+		}
+		if m.Name == query {
+			matches = append(matches, m)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return modelInfo{}, fmt.Errorf("unknown model: %s", query)
+	case 1:
+		return matches[0], nil
+	}
+	return modelInfo{}, fmt.Errorf("ambiguous model %q: specify provider/name", query)
+}
+
+// modelInfoTool answers opencode_model_info from the model cache.
+func modelInfoTool(cfg serverConfig, call *toolCall) (*toolCallResult, *mcpError) {
+	var args struct {
+		Model string `json:"model"`
+	}
+	if len(call.Arguments) > 0 {
+		if err := json.Unmarshal(call.Arguments, &args); err != nil {
+			return nil, errInvalidArguments.err("invalid arguments")
+		}
+	}
+
+	models := fetchModelInfo(cfg)
+	var v any = models
+	if args.Model != "" {
+		m, err := findModelInfo(models, args.Model)
+		if err != nil {
+			return errorResult(err), nil
+		}
+		v = m
+	} else if models == nil {
+		v = []modelInfo{}
+	}
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, errInternal.err(err.Error())
+	}
+	return &toolCallResult{Content: []toolContent{{Type: "text", Text: string(b)}}}, nil
+}
+
+// getDefaultModel returns the best available model, or empty string to let opencode use its default.
+// When fetchAvailableModels fails (e.g., wrong opencode binary), we return "" to avoid ProviderModelNotFoundError.
+func getDefaultModel(cfg serverConfig) string {
+	if cfg.Policy.DefaultModel != "" {
+		return cfg.Policy.DefaultModel
+	}
+	if model := runner.DefaultModel(fetchAvailableModels(cfg)); model != "" {
+		return model
+	}
+
+	// Don't use hardcoded fallback - let opencode use its own default to avoid ProviderModelNotFoundError
+	log.Printf("No models from 'opencode models', omitting --model (opencode will use its default)")
+	return ""
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// resetModelCache clears the package-level model cache for a test.
+func resetModelCache(t *testing.T) {
+	t.Helper()
+	reset := func() { modelCache.Set(nil) }
+	reset()
+	t.Cleanup(reset)
+}
+
+// Test parseModelsJSON across the supported shapes
+func TestParseModelsJSON(t *testing.T) {
+	want := []string{"anthropic/claude-sonnet-4", "openai/gpt-5"}
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"string array", `["openai/gpt-5","anthropic/claude-sonnet-4"]`},
+		{"object array", `[{"id":"gpt-5","providerID":"openai","name":"GPT-5"},{"id":"anthropic/claude-sonnet-4"}]`},
+		{"provider map", `{"openai":["gpt-5"],"anthropic":{"claude-sonnet-4":{"name":"Claude Sonnet 4"}}}`},
+		{"providers wrapper", `{"providers":[{"id":"openai","models":{"gpt-5":{}}},{"id":"anthropic","models":["claude-sonnet-4"]}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			models, ok := parseModelsJSON([]byte(tt.data))
+			if !ok {
+				t.Fatal("parseModelsJSON() failed")
+			}
+			var ids []string
+			for _, m := range models {
+				ids = append(ids, m.ID)
+			}
+			if !reflect.DeepEqual(ids, want) {
+				t.Errorf("ids = %v, want %v", ids, want)
+			}
+		})
+	}
+
+	if _, ok := parseModelsJSON([]byte("openai/gpt-5\n")); ok {
+		t.Error("plain text should not parse as JSON")
+	}
+}
+
+// Test parseModelsTable skips headers and keeps display names
+func TestParseModelsTable(t *testing.T) {
+	output := "Available models:\n" +
+		"\x1b[1mMODEL                      NAME\x1b[0m\n" +
+		"-------------------------  ------------\n" +
+		"anthropic/claude-sonnet-4  Claude Sonnet 4\n" +
+		"openai/gpt-5\n" +
+		"\n" +
+		"# comment\n"
+	got := parseModelsTable(output)
+	want := []modelInfo{
+		{ID: "anthropic/claude-sonnet-4", Provider: "anthropic", Name: "claude-sonnet-4", DisplayName: "Claude Sonnet 4"},
+		{ID: "openai/gpt-5", Provider: "openai", Name: "gpt-5"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseModelsTable() = %+v, want %+v", got, want)
+	}
+}
+
+// Test listCLIModels falls back to the table output without JSON support
+func TestListCLIModelsFallback(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "opencode")
+	content := `#!/bin/sh
+if [ "$2" = "--format" ]; then
+  echo "error: unknown option --format" >&2
+  exit 1
+fi
+echo "openai/gpt-5  GPT-5"
+`
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	models, err := listCLIModels(context.Background(), script)
+	if err != nil {
+		t.Fatalf("listCLIModels() error: %v", err)
+	}
+	if len(models) != 1 || models[0].DisplayName != "GPT-5" {
+		t.Errorf("models = %+v", models)
+	}
+}
+
+// Test the opencode_model_info tool
+func TestModelInfoTool(t *testing.T) {
+	resetModelCache(t)
+	script := filepath.Join(t.TempDir(), "opencode")
+	content := `#!/bin/sh
+echo '[{"id":"gpt-5","providerID":"openai","name":"GPT-5"},{"id":"gpt-5","providerID":"azure"},{"id":"claude-sonnet-4","providerID":"anthropic"}]'
+`
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	tools := newToolHandler(serverConfig{Target: script, DefaultTimeout: 5 * time.Second})
+	call := func(args string) *toolCallResult {
+		result, mErr := tools(context.Background(), &toolCall{ID: json.RawMessage("1"), Name: toolModelInfo, Arguments: json.RawMessage(args)})
+		if mErr != nil {
+			t.Fatalf("unexpected error: %v", mErr)
+		}
+		return result
+	}
+
+	var all []modelInfo
+	if err := json.Unmarshal([]byte(call(`{}`).Content[0].Text), &all); err != nil || len(all) != 3 {
+		t.Fatalf("all models = %v (%v)", all, err)
+	}
+
+	var one modelInfo
+	if err := json.Unmarshal([]byte(call(`{"model":"claude-sonnet-4"}`).Content[0].Text), &one); err != nil {
+		t.Fatal(err)
+	}
+	if one.ID != "anthropic/claude-sonnet-4" || one.Provider != "anthropic" {
+		t.Errorf("model = %+v", one)
+	}
+
+	if result := call(`{"model":"gpt-5"}`); !result.IsError || !strings.Contains(result.Content[0].Text, "ambiguous") {
+		t.Errorf("ambiguous lookup = %+v", result)
+	}
+	if result := call(`{"model":"nope/none"}`); !result.IsError {
+		t.Errorf("unknown lookup = %+v", result)
+	}
+}
+
+// Test changes of the model list are announced on open session streams
+func TestAnnounceModelChanges(t *testing.T) {
+	resetModelCache(t)
+	dir := t.TempDir()
+	list := filepath.Join(dir, "models.json")
+	script := filepath.Join(dir, "opencode")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\ncat "+list+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(list, []byte(`["a/one","b/two"]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := serverConfig{Target: script}
+	sessions := &sessionStore{sessions: map[string]*session{"s": {id: "s"}}}
+	known := fetchAvailableModels(cfg)
+
+	if err := os.WriteFile(list, []byte(`["a/one","c/three"]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := announceModelChanges(cfg, sessions, known); !reflect.DeepEqual(got, known) {
+		t.Errorf("checked without an open stream: %v", got)
+	}
+
+	events, stop := sessions.sessions["s"].subscribe(0)
+	defer stop()
+	known = announceModelChanges(cfg, sessions, known)
+	if !reflect.DeepEqual(known, []string{"a/one", "c/three"}) {
+		t.Errorf("known = %v", known)
+	}
+	select {
+	case ev := <-events:
+		params := ev.Msg.(map[string]any)["params"].(map[string]any)
+		if !reflect.DeepEqual(params["added"], []string{"c/three"}) || !reflect.DeepEqual(params["removed"], []string{"b/two"}) {
+			t.Errorf("params = %v", params)
+		}
+	default:
+		t.Fatal("no notification")
+	}
+	announceModelChanges(cfg, sessions, known)
+	if len(events) != 0 {
+		t.Error("notified without a change")
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Clients display notification channels very differently: some show
+// notifications/message as a log, others render only progress. The
+// "notifications" section of the config file routes each opencode event
+// type (text, tool_use, reasoning, step_start, step_finish, error, ...) to
+// one channel, with overrides per client. Unrouted events keep the default:
+// a message notification, plus progress for text, completed tools and steps.
+type notificationConfig struct {
+	Events  map[string]eventRoute            `json:"events,omitempty"`  // by event type; "*" for the rest
+	Clients map[string]map[string]eventRoute `json:"clients,omitempty"` // by clientInfo name prefix, e.g. "cursor"
+}
+
+// eventRoute is where an event type goes.
+type eventRoute struct {
+	Channel string `json:"channel"`         // message, progress or none
+	Level   string `json:"level,omitempty"` // logging level of messages; default info
+}
+
+const (
+	channelMessage  = "message"
+	channelProgress = "progress"
+	channelNone     = "none"
+)
+
+// logLevels are the MCP logging levels, least severe first.
+var logLevels = []string{"debug", "info", "notice", "warning", "error", "critical", "alert", "emergency"}
+
+// logLevelRank returns the severity of level, or -1 if it isn't one.
+func logLevelRank(level string) int {
+	for i, l := range logLevels {
+		if l == level {
+			return i
+		}
+	}
+	return -1
+}
+
+// validateNotifications checks the notifications section of the config file.
+func validateNotifications(c *notificationConfig) error {
+	if c == nil {
+		return nil
+	}
+	if err := validateRoutes("notifications.events", c.Events); err != nil {
+		return err
+	}
+	for client, routes := range c.Clients {
+		if client == "" || client != strings.ToLower(client) {
+			return fmt.Errorf("notifications.clients.%s: client names must be lower case", client)
+		}
+		if err := validateRoutes("notifications.clients."+client, routes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateRoutes(path string, routes map[string]eventRoute) error {
+	for eventType, r := range routes {
+		switch r.Channel {
+		case channelMessage, channelProgress, channelNone:
+		default:
+			return fmt.Errorf("%s.%s.channel: %q is not message, progress or none", path, eventType, r.Channel)
+		}
+		if r.Level != "" && (r.Channel != channelMessage || logLevelRank(r.Level) < 0) {
+			return fmt.Errorf("%s.%s.level: %q is not a logging level of a message route (%s)", path, eventType, r.Level, strings.Join(logLevels, ", "))
+		}
+	}
+	return nil
+}
+
+// eventRoutes are the routes in effect for one client.
+type eventRoutes map[string]eventRoute
+
+// routesFor merges the routes for client, whose clientInfo name is matched
+// case-insensitively against the longest client prefix.
+func (c *notificationConfig) routesFor(client string) eventRoutes {
+	if c == nil {
+		return nil
+	}
+	routes := make(eventRoutes, len(c.Events))
+	for eventType, r := range c.Events {
+		routes[eventType] = r
+	}
+	client = strings.ToLower(client)
+	best := ""
+	for prefix := range c.Clients {
+		if strings.HasPrefix(client, prefix) && len(prefix) > len(best) {
+			best = prefix
+		}
+	}
+	for eventType, r := range c.Clients[best] {
+		routes[eventType] = r
+	}
+	if len(routes) == 0 {
+		return nil
+	}
+	return routes
+}
+
+// route returns the route of eventType; ok is false for the default.
+func (r eventRoutes) route(eventType string) (route eventRoute, ok bool) {
+	if route, ok = r[eventType]; !ok {
+		route, ok = r["*"]
+	}
+	if route.Level == "" {
+		route.Level = "info"
+	}
+	return route, ok
+}
+
+// allowsNotification reports whether msg is at or above the level the
+// client asked for with logging/setLevel. Only leveled messages are held
+// back.
+func (s *session) allowsNotification(msg any) bool {
+	min := s.logLevel.Load()
+	if min == 0 {
+		return true
+	}
+	m, _ := msg.(map[string]any)
+	if m["method"] != "notifications/message" {
+		return true
+	}
+	params, _ := m["params"].(map[string]any)
+	level, ok := params["level"].(string)
+	return !ok || int32(logLevelRank(level)) >= min
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Test events go to the channels routed for the client
+func TestNotificationRoutes(t *testing.T) {
+	nc := &notificationConfig{
+		Events: map[string]eventRoute{
+			"tool_use":   {Channel: channelMessage, Level: "debug"},
+			"step_start": {Channel: channelNone},
+			"reasoning":  {Channel: channelProgress},
+		},
+		Clients: map[string]map[string]eventRoute{"cursor": {"text": {Channel: channelProgress}}},
+	}
+	run := func(client string) []string {
+		var sent []string
+		call := &toolCall{ID: json.RawMessage("1"), Routes: nc.routesFor(client), notify: func(msg any) {
+			m := msg.(map[string]any)
+			params := m["params"].(map[string]any)
+			switch m["method"] {
+			case "notifications/progress":
+				sent = append(sent, "progress:"+params["message"].(string))
+			default:
+				level, _ := params["level"].(string)
+				sent = append(sent, "message:"+params["type"].(string)+":"+level)
+			}
+		}}
+		ec := newEventCollector(call)
+		ec.event(map[string]any{"type": "step_start", "part": map[string]any{}})
+		ec.event(map[string]any{"type": "text", "part": map[string]any{"text": "hi"}})
+		ec.event(map[string]any{"type": "tool_use", "part": map[string]any{"tool": "bash", "state": map[string]any{"status": "completed"}}})
+		ec.event(map[string]any{"type": "reasoning", "part": map[string]any{"text": "hmm"}})
+		return sent
+	}
+	if got := strings.Join(run("claude-ai"), " "); got != "progress:hi message:text: message:tool_use:debug progress:reasoning" {
+		t.Errorf("claude-ai: %s", got)
+	}
+	if got := strings.Join(run("Cursor-VSCode"), " "); got != "progress:hi message:tool_use:debug progress:reasoning" {
+		t.Errorf("cursor: %s", got)
+	}
+	if (&notificationConfig{}).routesFor("x") != nil {
+		t.Error("an empty config changed the defaults")
+	}
+	for _, bad := range []*notificationConfig{
+		{Events: map[string]eventRoute{"text": {Channel: "log"}}},
+		{Events: map[string]eventRoute{"text": {Channel: channelProgress, Level: "info"}}},
+		{Events: map[string]eventRoute{"text": {Channel: channelMessage, Level: "verbose"}}},
+		{Clients: map[string]map[string]eventRoute{"Cursor": {}}},
+	} {
+		if validateNotifications(bad) == nil {
+			t.Errorf("accepted %+v", bad)
+		}
+	}
+}
+
+// Test logging/setLevel holds back less severe messages for the session
+func TestLoggingSetLevel(t *testing.T) {
+	sessions := &sessionStore{sessions: make(map[string]*session)}
+	handler := createMCPHandler(sessions, serverConfig{})
+	post := func(sessionID, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(body))
+		if sessionID != "" {
+			req.Header.Set("Mcp-Session-Id", sessionID)
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+	w := post("", `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"clientInfo":{"name":"cursor-vscode"}}}`)
+	id := w.Header().Get("Mcp-Session-Id")
+	if !strings.Contains(w.Body.String(), `"logging":{}`) {
+		t.Errorf("initialize = %s", w.Body.String())
+	}
+	sess := sessions.get(id)
+	if sess.client != "cursor-vscode" {
+		t.Errorf("client = %q", sess.client)
+	}
+	if w := post(id, `{"jsonrpc":"2.0","id":2,"method":"logging/setLevel","params":{"level":"loud"}}`); !strings.Contains(w.Body.String(), errInvalidArguments.Code) {
+		t.Errorf("invalid level: %s", w.Body.String())
+	}
+	if w := post(id, `{"jsonrpc":"2.0","id":3,"method":"logging/setLevel","params":{"level":"warning"}}`); strings.Contains(w.Body.String(), "error") {
+		t.Errorf("setLevel: %s", w.Body.String())
+	}
+	message := func(level string) any {
+		return map[string]any{"method": "notifications/message", "params": map[string]any{"level": level}}
+	}
+	if sess.allowsNotification(message("info")) || !sess.allowsNotification(message("error")) {
+		t.Error("level filter not applied")
+	}
+	if !sess.allowsNotification(map[string]any{"method": "notifications/message", "params": map[string]any{"type": "text"}}) {
+		t.Error("unleveled messages must pass")
+	}
+}
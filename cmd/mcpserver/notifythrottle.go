@@ -0,0 +1,80 @@
+package main
+
+import "opencode-mcp/internal/throttle"
+
+// notifyLimiter returns the notification budget of a request: shared by
+// all calls of sess, or per call without a session. nil when
+// MCP_NOTIFY_RATE is unset.
+func notifyLimiter(sess *session, rate int) *throttle.Limiter {
+	if rate <= 0 {
+		return nil
+	}
+	if sess == nil {
+		return throttle.NewLimiter(rate)
+	}
+	sess.limiterOnce.Do(func() { sess.limiter = throttle.NewLimiter(rate) })
+	return sess.limiter
+}
+
+// mergeNotifications coalesces queued notifications: text deltas are
+// concatenated, and a progress update replaces the one before it. The two
+// kinds commute, everything else keeps its order. Resumable events keep the
+// later event ID, since the merged message covers both.
+func mergeNotifications(queued, next any) (any, throttle.Merge) {
+	if a, ok := queued.(sseEvent); ok {
+		b, ok := next.(sseEvent)
+		if !ok {
+			return nil, throttle.Separate
+		}
+		msg, m := mergeNotifications(a.Msg, b.Msg)
+		if m == throttle.Merged {
+			msg = sseEvent{ID: b.ID, Msg: msg}
+		}
+		return msg, m
+	}
+	ka, ta := notificationKind(queued)
+	kb, tb := notificationKind(next)
+	switch {
+	case ka == "" || kb == "":
+		return nil, throttle.Separate
+	case ka != kb:
+		return nil, throttle.Commute
+	case ka == "text":
+		return withParam(next.(map[string]any), "data", ta+tb), throttle.Merged
+	}
+	return next, throttle.Merged // progress: the latest wins
+}
+
+// notificationKind classifies msg as a "text" delta (returning the text)
+// or a "progress" update; "" for anything else.
+func notificationKind(msg any) (kind, text string) {
+	m, _ := msg.(map[string]any)
+	params, _ := m["params"].(map[string]any)
+	switch m["method"] {
+	case "notifications/message":
+		if s, ok := params["data"].(string); ok && params["type"] == "text" {
+			return "text", s
+		}
+	case "notifications/progress":
+		if _, ok := params["progress"]; ok {
+			return "progress", "" // raw output lines (no progress) are all kept
+		}
+	}
+	return "", ""
+}
+
+// withParam returns a copy of notification msg with params[key] = value.
+func withParam(msg map[string]any, key string, value any) map[string]any {
+	params, _ := msg["params"].(map[string]any)
+	p := make(map[string]any, len(params)+1)
+	for k, v := range params {
+		p[k] = v
+	}
+	p[key] = value
+	out := make(map[string]any, len(msg))
+	for k, v := range msg {
+		out[k] = v
+	}
+	out["params"] = p
+	return out
+}
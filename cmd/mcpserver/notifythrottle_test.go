@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"opencode-mcp/internal/mcp"
+	"opencode-mcp/internal/throttle"
+)
+
+// Test a throttled call coalesces its text deltas and delivers them all
+// before the response
+func TestNotifyThrottle(t *testing.T) {
+	chatty := func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+		for i := 0; i < 200; i++ {
+			call.Notify(map[string]any{"jsonrpc": "2.0", "method": "notifications/message", "params": map[string]any{"type": "text", "data": "x"}})
+			call.progress(i+1, "working")
+		}
+		call.Notify(map[string]any{"jsonrpc": "2.0", "method": "notifications/message", "params": map[string]any{"type": "tool_use", "data": map[string]any{"tool": "bash"}}})
+		return &toolCallResult{Content: []toolContent{{Type: "text", Text: "done"}}}, nil
+	}
+	rec := httptest.NewRecorder()
+	handleToolsCallSSE(rec, context.Background(), chatty, mcpRequest{Request: mcp.Request{ID: json.RawMessage("1"), Params: json.RawMessage(`{"name":"x"}`)}, NotifyLimiter: throttle.NewLimiter(5)})
+
+	var frames []map[string]any
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		if data, ok := strings.CutPrefix(line, "data: "); ok {
+			var m map[string]any
+			if err := json.Unmarshal([]byte(data), &m); err != nil {
+				t.Fatal(err)
+			}
+			frames = append(frames, m)
+		}
+	}
+	if len(frames) > 20 {
+		t.Errorf("%d frames for 401 notifications at 5/s, want them coalesced", len(frames))
+	}
+	var text strings.Builder
+	var lastProgress float64
+	sawTool := false
+	for _, f := range frames[:len(frames)-1] {
+		params, _ := f["params"].(map[string]any)
+		switch {
+		case params["type"] == "text":
+			text.WriteString(params["data"].(string))
+		case params["type"] == "tool_use":
+			sawTool = true
+		case f["method"] == "notifications/progress":
+			lastProgress = params["progress"].(float64)
+		}
+	}
+	if text.String() != strings.Repeat("x", 200) || lastProgress != 200 || !sawTool {
+		t.Errorf("text %d bytes, last progress %v, tool event %v; want all delivered", text.Len(), lastProgress, sawTool)
+	}
+	if final := frames[len(frames)-1]; final["result"] == nil {
+		t.Errorf("last frame = %v, want the response", final)
+	}
+}
+
+// Test resumable events merge into the later event ID
+func TestMergeNotifications(t *testing.T) {
+	text := func(s string) map[string]any {
+		return map[string]any{"method": "notifications/message", "params": map[string]any{"type": "text", "data": s}}
+	}
+	merged, m := mergeNotifications(sseEvent{ID: "3", Msg: text("a")}, sseEvent{ID: "4", Msg: text("b")})
+	ev, _ := merged.(sseEvent)
+	if m != throttle.Merged || ev.ID != "4" || ev.Msg.(map[string]any)["params"].(map[string]any)["data"] != "ab" {
+		t.Errorf("merged = %#v, %v", merged, m)
+	}
+	if _, m := mergeNotifications(text("a"), sseEvent{ID: "4", Msg: text("b")}); m != throttle.Separate {
+		t.Error("merged a plain message with a resumable event")
+	}
+	raw := map[string]any{"method": "notifications/progress", "params": map[string]any{"data": "line"}}
+	if _, m := mergeNotifications(raw, raw); m != throttle.Separate {
+		t.Error("merged raw output lines")
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+)
+
+const (
+	maxPipelineSteps = 10
+
+	// previousOutputPlaceholder marks where a step wants the previous step's
+	// answer; without it the answer is appended to the step's message.
+	previousOutputPlaceholder = "{{previous}}"
+)
+
+// pipelineArgs are the arguments of opencode_pipeline.
+type pipelineArgs struct {
+	Steps   []pipelineStep `json:"steps"`
+	Cwd     string         `json:"cwd"`
+	Model   string         `json:"model"`
+	Session string         `json:"session"`
+	Labels  []string       `json:"labels"`
+}
+
+type pipelineStep struct {
+	Label   string   `json:"label"`
+	Message string   `json:"message"`
+	Model   string   `json:"model"`
+	Files   []string `json:"files"`
+}
+
+type pipelineStepResult struct {
+	Label   string `json:"label"`
+	Status  string `json:"status"` // ok, error or skipped
+	Text    string `json:"text,omitempty"`
+	Session string `json:"session,omitempty"`
+}
+
+var pipelineSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"steps": map[string]any{
+			"type":        "array",
+			"minItems":    1,
+			"maxItems":    maxPipelineSteps,
+			"description": "Ordered steps; each step's answer is passed to the next (at {{previous}} or appended)",
+			"items": map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"label":   map[string]any{"type": "string", "description": "Name shown in the results (defaults to step-N)"},
+					"message": map[string]any{"type": "string", "description": "Prompt for this step"},
+					"model":   map[string]any{"type": "string", "description": "Model for this step (defaults to model)"},
+					"files":   map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "description": "Files to attach for this step"},
+				},
+				"required": []string{"message"},
+			},
+		},
+		"cwd": map[string]any{
+			"type":        "string",
+			"description": "Working directory for all steps",
+		},
+		"model": map[string]any{
+			"type":        "string",
+			"description": "Model to use for every step",
+		},
+		"session": map[string]any{
+			"type":        "string",
+			"description": "Existing session to run the pipeline in (a new one is created otherwise)",
+		},
+		"labels": map[string]any{
+			"type":        "array",
+			"items":       map[string]any{"type": "string"},
+			"description": "Labels recorded with every run in the run history",
+		},
+	},
+	"required": []string{"steps"},
+}
+
+// pipelineTool runs steps in order within one opencode session, feeding each
+// step's answer into the next prompt. The first failing step aborts the rest.
+func pipelineTool(ctx context.Context, cfg serverConfig, call *toolCall) (*toolCallResult, *mcpError) {
+	var args pipelineArgs
+	if err := json.Unmarshal(call.Arguments, &args); err != nil {
+		return nil, errInvalidArguments.err("invalid arguments")
+	}
+	if len(args.Steps) == 0 {
+		return nil, errInvalidArguments.err("missing steps")
+	}
+	if len(args.Steps) > maxPipelineSteps {
+		return nil, errInvalidArguments.err(fmt.Sprintf("too many steps (max %d)", maxPipelineSteps))
+	}
+	for i, step := range args.Steps {
+		if step.Message == "" {
+			return nil, errInvalidArguments.err(fmt.Sprintf("step %d: missing message", i+1))
+		}
+	}
+	log.Printf("[pipeline] steps=%d model=%s session=%s", len(args.Steps), args.Model, args.Session)
+
+	run := runHandler(cfg)
+	results := make([]pipelineStepResult, len(args.Steps))
+	sessionID := args.Session
+	previous := ""
+	failed := false
+	for i, step := range args.Steps {
+		label := step.Label
+		if label == "" {
+			label = fmt.Sprintf("step-%d", i+1)
+		}
+		results[i] = pipelineStepResult{Label: label, Status: "skipped"}
+		if failed {
+			continue
+		}
+
+		model := step.Model
+		if model == "" {
+			model = args.Model
+		}
+		runArgs, _ := json.Marshal(runToolArgs{
+			Message: pipelineMessage(step.Message, previous, i > 0),
+			Cwd:     args.Cwd,
+			Model:   model,
+			Session: sessionID,
+			Files:   step.Files,
+			Labels:  args.Labels,
+		})
+		call.progressf(i, "%s started (%d/%d)", label, i+1, len(args.Steps))
+		result, mErr := run(ctx, &toolCall{ID: call.ID, Name: toolRun, Arguments: runArgs, Cwd: call.Cwd, Session: call.Session, Tenant: call.Tenant, Binary: call.Binary})
+
+		switch {
+		case mErr != nil:
+			results[i].Status, results[i].Text = "error", mErr.Message
+		case result.IsError:
+			results[i].Status, results[i].Text = "error", resultText(result)
+		default:
+			results[i].Status, results[i].Text = "ok", strings.TrimSpace(result.answer)
+			previous = results[i].Text
+		}
+		if result != nil && result.sessionID != "" && sessionID == "" {
+			sessionID = result.sessionID
+		}
+		results[i].Session = sessionID
+		if results[i].Status == "error" {
+			log.Printf("[pipeline] %s failed, skipping %d remaining steps", label, len(args.Steps)-i-1)
+			failed = true
+		}
+	}
+
+	var text strings.Builder
+	for _, r := range results {
+		fmt.Fprintf(&text, "=== %s (%s) ===\n", r.Label, r.Status)
+		if r.Text != "" {
+			fmt.Fprintf(&text, "%s\n", r.Text)
+		}
+		text.WriteString("\n")
+	}
+	if sessionID != "" {
+		fmt.Fprintf(&text, "[session: %s]", sessionID)
+	}
+
+	return &toolCallResult{
+		Content:           []toolContent{{Type: "text", Text: strings.TrimRight(text.String(), "\n")}},
+		StructuredContent: map[string]any{"steps": results, "session": sessionID},
+		IsError:           failed,
+		sessionID:         sessionID,
+		answer:            previous,
+	}, nil
+}
+
+// pipelineMessage injects the previous step's answer into message.
+func pipelineMessage(message, previous string, hasPrevious bool) string {
+	if !hasPrevious {
+		return strings.ReplaceAll(message, previousOutputPlaceholder, "")
+	}
+	if strings.Contains(message, previousOutputPlaceholder) {
+		return strings.ReplaceAll(message, previousOutputPlaceholder, previous)
+	}
+	return message + "\n\nOutput of the previous step:\n" + previous
+}
+
+// resultText joins the text content of a tool result.
+func resultText(result *toolCallResult) string {
+	var b strings.Builder
+	for _, c := range result.Content {
+		b.WriteString(c.Text)
+	}
+	return strings.TrimSpace(b.String())
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pipelineScript answers with the first line of the prompt and the session
+// it was given, and fails prompts containing "fail-me".
+const pipelineScript = `#!/bin/sh
+session=none
+prev=
+for arg; do
+  if [ "$prev" = "--session" ]; then session=$arg; fi
+  prev=$arg
+  last=$arg
+done
+case "$last" in
+*fail-me*) echo "boom" >&2; exit 1 ;;
+esac
+first=$(printf '%s' "$last" | head -n 1)
+printf '{"type":"text","sessionID":"ses_pipe","part":{"text":"%s [in %s]"}}\n' "$first" "$session"
+`
+
+// Test opencode_pipeline passes answers and the session between steps
+func TestPipelineTool(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "opencode")
+	if err := os.WriteFile(script, []byte(pipelineScript), 0755); err != nil {
+		t.Fatal(err)
+	}
+	tools := newToolHandler(serverConfig{Target: script, DefaultTimeout: 5 * time.Second})
+	call := func(args string) *toolCallResult {
+		result, mErr := tools(context.Background(), &toolCall{ID: json.RawMessage("1"), Name: toolPipeline, Arguments: json.RawMessage(args)})
+		if mErr != nil {
+			t.Fatalf("unexpected error: %v", mErr)
+		}
+		return result
+	}
+
+	result := call(`{"model":"m","steps":[{"label":"plan","message":"plan it"},{"label":"review","message":"review: {{previous}}"}]}`)
+	if result.IsError {
+		t.Fatalf("pipeline failed: %s", result.Content[0].Text)
+	}
+	steps := result.StructuredContent.(map[string]any)["steps"].([]pipelineStepResult)
+	if steps[0].Text != "plan it [in none]" {
+		t.Errorf("step 1 = %q", steps[0].Text)
+	}
+	if steps[1].Text != "review: plan it [in none] [in ses_pipe]" {
+		t.Errorf("step 2 = %q", steps[1].Text)
+	}
+	if !strings.HasSuffix(result.Content[0].Text, "[session: ses_pipe]") {
+		t.Errorf("text = %q", result.Content[0].Text)
+	}
+
+	result = call(`{"model":"m","steps":[{"message":"fail-me"},{"message":"never"}]}`)
+	if !result.IsError {
+		t.Error("a failed step should mark the pipeline as an error")
+	}
+	steps = result.StructuredContent.(map[string]any)["steps"].([]pipelineStepResult)
+	if steps[0].Status != "error" || steps[1].Status != "skipped" {
+		t.Errorf("steps = %+v", steps)
+	}
+}
+
+// Test pipelineMessage
+func TestPipelineMessage(t *testing.T) {
+	if got := pipelineMessage("first {{previous}}", "", false); got != "first " {
+		t.Errorf("first step = %q", got)
+	}
+	if got := pipelineMessage("check", "answer", true); got != "check\n\nOutput of the previous step:\nanswer" {
+		t.Errorf("appended = %q", got)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const defaultPluginTimeout = 10 * time.Second
+
+// pluginConfig declares an external executable that provides additional tools.
+//
+// The plugin is started once per operation with a single JSON request on stdin
+// and must write a single JSON response to stdout:
+//
+//	{"method":"tools/list"}                                   -> {"tools":[{"name":...,"description":...,"inputSchema":{...}}]}
+//	{"method":"tools/call","params":{"name":..,"arguments":{..}}} -> {"content":[{"type":"text","text":...}],"isError":false}
+//
+// Either response may instead be {"error":"message"}.
+type pluginConfig struct {
+	Name       string            `json:"name"`
+	Command    string            `json:"command"`
+	Args       []string          `json:"args,omitempty"`
+	Env        map[string]string `json:"env,omitempty"`
+	TimeoutSec int               `json:"timeoutSec,omitempty"`
+}
+
+// pluginTool is a tool discovered from a plugin at startup.
+type pluginTool struct {
+	Plugin pluginConfig
+	Tool   mcpTool
+}
+
+type pluginRequest struct {
+	Method string `json:"method"`
+	Params any    `json:"params,omitempty"`
+}
+
+type pluginResponse struct {
+	Tools   []mcpTool     `json:"tools,omitempty"`
+	Content []toolContent `json:"content,omitempty"`
+	IsError bool          `json:"isError,omitempty"`
+	Error   string        `json:"error,omitempty"`
+}
+
+func validatePlugins(plugins []pluginConfig) error {
+	seen := make(map[string]bool)
+	for i, p := range plugins {
+		if p.Name == "" {
+			return fmt.Errorf("plugins[%d]: missing name", i)
+		}
+		if seen[p.Name] {
+			return fmt.Errorf("plugins[%d]: duplicate plugin name %q", i, p.Name)
+		}
+		seen[p.Name] = true
+		if p.Command == "" {
+			return fmt.Errorf("plugins[%d] %s: missing command", i, p.Name)
+		}
+		if p.TimeoutSec < 0 {
+			return fmt.Errorf("plugins[%d] %s: timeoutSec must not be negative", i, p.Name)
+		}
+	}
+	return nil
+}
+
+func (p pluginConfig) timeout() time.Duration {
+	if p.TimeoutSec > 0 {
+		return time.Duration(p.TimeoutSec) * time.Second
+	}
+	return defaultPluginTimeout
+}
+
+// invoke runs the plugin executable with req on stdin and decodes its response.
+func (p pluginConfig) invoke(ctx context.Context, req pluginRequest) (pluginResponse, error) {
+	var resp pluginResponse
+	input, err := json.Marshal(req)
+	if err != nil {
+		return resp, err
+	}
+
+	cmd := exec.CommandContext(ctx, p.Command, p.Args...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Env = os.Environ()
+	for k, v := range p.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return resp, fmt.Errorf("plugin %s failed: %s", p.Name, strings.TrimSpace(stderr.String()))
+		}
+		return resp, fmt.Errorf("plugin %s: %w", p.Name, err)
+	}
+	if err := json.Unmarshal(output, &resp); err != nil {
+		return resp, fmt.Errorf("plugin %s: invalid response: %w", p.Name, err)
+	}
+	if resp.Error != "" {
+		return resp, fmt.Errorf("plugin %s: %s", p.Name, resp.Error)
+	}
+	return resp, nil
+}
+
+// discoverPluginTools asks every plugin for its tools. Plugins that fail and
+// tools whose names are already taken are skipped with a log message so one
+// broken plugin doesn't take the server down.
+func discoverPluginTools(cfg serverConfig) []pluginTool {
+	taken := make(map[string]bool)
+	for name := range builtinToolNames {
+		taken[name] = true
+	}
+	for _, t := range cfg.CustomTools {
+		taken[t.Name] = true
+	}
+
+	var out []pluginTool
+	for _, p := range cfg.Plugins {
+		ctx, cancel := context.WithTimeout(context.Background(), p.timeout())
+		resp, err := p.invoke(ctx, pluginRequest{Method: "tools/list"})
+		cancel()
+		if err != nil {
+			log.Printf("[plugin] %s: tools/list failed: %v", p.Name, err)
+			continue
+		}
+		registered := 0
+		for _, tool := range resp.Tools {
+			if tool.Name == "" || taken[tool.Name] {
+				log.Printf("[plugin] %s: skipping tool %q (missing or duplicate name)", p.Name, tool.Name)
+				continue
+			}
+			if tool.InputSchema == nil {
+				tool.InputSchema = map[string]any{"type": "object", "properties": map[string]any{}}
+			}
+			taken[tool.Name] = true
+			out = append(out, pluginTool{Plugin: p, Tool: tool})
+			registered++
+		}
+		log.Printf("[plugin] %s: registered %d of %d tools", p.Name, registered, len(resp.Tools))
+	}
+	return out
+}
+
+func findPluginTool(cfg serverConfig, name string) (pluginTool, bool) {
+	for _, pt := range cfg.PluginTools {
+		if pt.Tool.Name == name {
+			return pt, true
+		}
+	}
+	return pluginTool{}, false
+}
+
+// callPluginTool forwards a tools/call to the plugin that owns the tool.
+func callPluginTool(ctx context.Context, pt pluginTool, call *toolCall) *toolCallResult {
+	ctx, cancel := context.WithTimeout(ctx, pt.Plugin.timeout())
+	defer cancel()
+
+	arguments := call.Arguments
+	if len(arguments) == 0 {
+		arguments = json.RawMessage("{}")
+	}
+	log.Printf("[tools/call] plugin=%s tool=%s", pt.Plugin.Name, pt.Tool.Name)
+	resp, err := pt.Plugin.invoke(ctx, pluginRequest{
+		Method: "tools/call",
+		Params: map[string]any{"name": pt.Tool.Name, "arguments": arguments},
+	})
+	if err != nil {
+		return &toolCallResult{
+			Content: []toolContent{{Type: "text", Text: err.Error()}},
+			IsError: true,
+		}
+	}
+
+	result := &toolCallResult{Content: resp.Content, IsError: resp.IsError}
+	if result.Content == nil {
+		result.Content = []toolContent{}
+	}
+	return result
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestPlugin creates a plugin script that lists one tool and echoes its
+// arguments back on tools/call.
+func writeTestPlugin(t *testing.T) string {
+	t.Helper()
+	script := filepath.Join(t.TempDir(), "plugin")
+	content := `#!/bin/sh
+req=$(cat)
+case "$req" in
+  *'"tools/list"'*)
+    echo '{"tools":[{"name":"acme_ticket","description":"Look up a ticket","inputSchema":{"type":"object","properties":{"id":{"type":"string"}}}},{"name":"opencode_run"}]}'
+    ;;
+  *'"fail"'*)
+    echo "boom" >&2
+    exit 3
+    ;;
+  *)
+    echo '{"content":[{"type":"text","text":"ticket for '"$PLUGIN_PREFIX"'"}]}'
+    ;;
+esac
+`
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatalf("failed to create plugin script: %v", err)
+	}
+	return script
+}
+
+// Test validatePlugins
+func TestValidatePlugins(t *testing.T) {
+	tests := []struct {
+		name    string
+		plugins []pluginConfig
+		wantErr string
+	}{
+		{name: "valid", plugins: []pluginConfig{{Name: "acme", Command: "/bin/true"}}},
+		{name: "missing name", plugins: []pluginConfig{{Command: "/bin/true"}}, wantErr: "missing name"},
+		{name: "missing command", plugins: []pluginConfig{{Name: "acme"}}, wantErr: "missing command"},
+		{
+			name:    "duplicate",
+			plugins: []pluginConfig{{Name: "acme", Command: "a"}, {Name: "acme", Command: "b"}},
+			wantErr: "duplicate plugin name",
+		},
+		{name: "negative timeout", plugins: []pluginConfig{{Name: "acme", Command: "a", TimeoutSec: -1}}, wantErr: "timeoutSec"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validatePlugins(tt.plugins)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// Test plugin discovery and tools/call forwarding
+func TestPluginTools(t *testing.T) {
+	script := writeTestPlugin(t)
+	cfg := serverConfig{
+		Target:         "echo",
+		DefaultTimeout: 5 * time.Second,
+		Plugins: []pluginConfig{
+			{Name: "acme", Command: script, Env: map[string]string{"PLUGIN_PREFIX": "ACME-1"}},
+			{Name: "broken", Command: "/nonexistent/plugin"},
+		},
+	}
+	cfg.PluginTools = discoverPluginTools(cfg)
+
+	// The built-in name collision must be skipped and the broken plugin ignored.
+	if len(cfg.PluginTools) != 1 || cfg.PluginTools[0].Tool.Name != "acme_ticket" {
+		t.Fatalf("plugin tools = %+v", cfg.PluginTools)
+	}
+
+	sessions := &sessionStore{sessions: make(map[string]*session)}
+	handler := createMCPHandler(sessions, cfg)
+
+	resp := doMCPRequest(t, handler, "tools/list", 1, map[string]any{})
+	result, _ := resp.Result.(map[string]any)
+	toolsRaw, _ := result["tools"].([]any)
+	found := false
+	for _, raw := range toolsRaw {
+		if tool, _ := raw.(map[string]any); tool["name"] == "acme_ticket" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("acme_ticket not found in tools/list")
+	}
+
+	call := func(args string) toolCallResult {
+		body, _ := json.Marshal(map[string]any{
+			"jsonrpc": "2.0",
+			"method":  "tools/call",
+			"id":      2,
+			"params":  map[string]any{"name": "acme_ticket", "arguments": json.RawMessage(args)},
+		})
+		req := httptest.NewRequest(http.MethodPost, "/mcp", bytes.NewReader(body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		var resp struct {
+			Result toolCallResult `json:"result"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to parse response: %v, body: %s", err, rec.Body.String())
+		}
+		return resp.Result
+	}
+
+	res := call(`{"id":"42"}`)
+	if res.IsError || len(res.Content) != 1 || res.Content[0].Text != "ticket for ACME-1" {
+		t.Errorf("unexpected result: %+v", res)
+	}
+
+	res = call(`{"id":"fail"}`)
+	if !res.IsError || !strings.Contains(res.Content[0].Text, "boom") {
+		t.Errorf("expected plugin failure in result, got %+v", res)
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tenantPolicy overrides server defaults for one tenant. Request arguments
+// take precedence over the tenant policy, which takes precedence over the
+// global settings.
+type tenantPolicy struct {
+	DefaultModel string         `json:"defaultModel,omitempty"`
+	TimeoutSec   int            `json:"timeoutSec,omitempty"`
+	AllowedDirs  []string       `json:"allowedDirs,omitempty"` // the first one is the default cwd
+	Quota        tenantQuota    `json:"quota,omitempty"`
+	Priority     string         `json:"priority,omitempty"`     // default opencode_run queue priority
+	AllowedRepos []string       `json:"allowedRepos,omitempty"` // patterns like github.com/org/* for the "repo" argument
+	Egress       *egressPolicy  `json:"egress,omitempty"`       // network allowlist for opencode processes
+	Proxy        *proxySettings `json:"proxy,omitempty"`        // replaces the config file's top-level proxy
+	Agent        string         `json:"agent,omitempty"`        // replaces the config file's top-level agent
+	APIKeys      []string       `json:"apiKeys,omitempty"`      // SHA-256 digests of the keys authenticating as the tenant
+}
+
+// tenantQuota limits a tenant's opencode_run usage over a rolling 24 hours.
+type tenantQuota struct {
+	RunsPerDay int     `json:"runsPerDay,omitempty"`
+	CostPerDay float64 `json:"costPerDay,omitempty"` // USD
+}
+
+// validateTenantPolicies checks the tenants section of the config file.
+func validateTenantPolicies(tenants map[string]tenantPolicy) error {
+	for name, p := range tenants {
+		if !tenantNameRe.MatchString(name) {
+			return fmt.Errorf("tenants.%s: invalid tenant name %q", name, name)
+		}
+		if p.TimeoutSec < 0 || p.Quota.RunsPerDay < 0 || p.Quota.CostPerDay < 0 {
+			return fmt.Errorf("tenants.%s: limits must not be negative", name)
+		}
+		if err := validatePriority(p.Priority); err != nil {
+			return fmt.Errorf("tenants.%s.priority: %v", name, err)
+		}
+		if p.Proxy != nil {
+			if err := p.Proxy.validate(); err != nil {
+				return fmt.Errorf("tenants.%s.proxy.%v", name, err)
+			}
+		}
+		if err := validateAgentName(p.Agent); err != nil {
+			return fmt.Errorf("tenants.%s.agent: %v", name, err)
+		}
+		if p.Egress != nil {
+			if err := p.Egress.validate(); err != nil {
+				return fmt.Errorf("tenants.%s.egress.%v", name, err)
+			}
+		}
+		for i, pattern := range p.AllowedRepos {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("tenants.%s.allowedRepos[%d]: invalid pattern %q", name, i, pattern)
+			}
+		}
+		for i, dir := range p.AllowedDirs {
+			if !filepath.IsAbs(dir) {
+				return fmt.Errorf("tenants.%s.allowedDirs[%d]: %q is not an absolute path", name, i, dir)
+			}
+		}
+	}
+	return validateAPIKeyDigests(tenants)
+}
+
+// forTenant returns cfg with the tenant's policy applied.
+func (cfg serverConfig) forTenant(tenant string) serverConfig {
+	p, ok := cfg.Tenants[tenantOrDefault(tenant)]
+	if !ok {
+		return cfg
+	}
+	cfg.Policy = p
+	if p.Proxy != nil {
+		cfg.Proxy = p.Proxy
+	}
+	if p.Agent != "" {
+		cfg.Agent = p.Agent
+	}
+	if p.TimeoutSec > 0 {
+		cfg.DefaultTimeout = time.Duration(p.TimeoutSec) * time.Second
+	}
+	return cfg
+}
+
+// defaultDir is the cwd used when neither the request nor the session sets one.
+func (p tenantPolicy) defaultDir() string {
+	if len(p.AllowedDirs) == 0 {
+		return ""
+	}
+	return p.AllowedDirs[0]
+}
+
+// checkDir rejects directories outside the allowlist.
+func (p tenantPolicy) checkDir(dir string) error {
+	if len(p.AllowedDirs) == 0 {
+		return nil
+	}
+	if dir == "" {
+		return fmt.Errorf("cwd is required")
+	}
+	if !p.allows(dir) {
+		return fmt.Errorf("cwd %s is outside the allowed directories", resolveDir(dir))
+	}
+	return nil
+}
+
+// allows reports whether path, a file or directory, is inside the
+// allowlist; with none, every path is.
+func (p tenantPolicy) allows(path string) bool {
+	if len(p.AllowedDirs) == 0 {
+		return true
+	}
+	path = resolveDir(path)
+	for _, allowed := range p.AllowedDirs {
+		rel, err := filepath.Rel(resolveDir(allowed), path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkFiles rejects the local files of an opencode_run call outside the
+// allowlist, resolving relative ones against its cwd like opencode does.
+// URLs are left to remoteFilesMiddleware.
+func checkFiles(p tenantPolicy, call *toolCall) error {
+	var args struct {
+		Cwd   string   `json:"cwd"`
+		Files []string `json:"files"`
+	}
+	if len(p.AllowedDirs) == 0 || json.Unmarshal(call.Arguments, &args) != nil {
+		return nil
+	}
+	if args.Cwd == "" {
+		args.Cwd = call.Cwd
+	}
+	for _, f := range args.Files {
+		if isRemoteFile(f) {
+			continue
+		}
+		path := f
+		if !filepath.IsAbs(path) && args.Cwd != "" {
+			path = filepath.Join(args.Cwd, path)
+		}
+		if !p.allows(path) {
+			return fmt.Errorf("file %s is outside the allowed directories", f)
+		}
+	}
+	return nil
+}
+
+// resolveDir makes dir absolute and resolves symlinks where possible.
+func resolveDir(dir string) string {
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	if real, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = real
+	}
+	return dir
+}
+
+// quotaUsage is what a tenant's runs used of its quota over the last 24
+// hours.
+type quotaUsage struct {
+	Runs int
+	Cost float64
+}
+
+// dailyUsage returns the tenant's usage; the zero value when it has no
+// quota to count against.
+func dailyUsage(cfg serverConfig, tenant string, now time.Time) (quotaUsage, error) {
+	q := cfg.Policy.Quota
+	if (q.RunsPerDay == 0 && q.CostPerDay == 0) || cfg.Store == nil {
+		return quotaUsage{}, nil
+	}
+	// Archiving a run doesn't give its quota back
+	runs, err := runHistory{cfg.Store}.query(tenant, runFilter{Since: now.Add(-24 * time.Hour), Archived: archivedInclude, Limit: -1})
+	if err != nil {
+		return quotaUsage{}, err
+	}
+	u := quotaUsage{Runs: len(runs)}
+	for _, r := range runs {
+		u.Cost += r.Cost
+	}
+	return u, nil
+}
+
+// quotaReservations counts the runs per tenant that passed the quota check
+// and haven't been recorded yet, so concurrent runs can't all pass it.
+var quotaReservations = struct {
+	sync.Mutex
+	runs map[string]int
+}{runs: map[string]int{}}
+
+// reserveQuota checks whether the tenant may start another run, counting
+// the runs in progress, and reserves it. release gives the reservation back
+// and must be called once the run is recorded. The cost of runs in
+// progress is unknown until they finish, so only recorded cost counts.
+func reserveQuota(cfg serverConfig, tenant string, now time.Time) (release func(), err error) {
+	q := cfg.Policy.Quota
+	if (q.RunsPerDay == 0 && q.CostPerDay == 0) || cfg.Store == nil {
+		return func() {}, nil
+	}
+	tenant = tenantOrDefault(tenant)
+	quotaReservations.Lock()
+	defer quotaReservations.Unlock()
+	if err := checkQuota(cfg, tenant, quotaReservations.runs[tenant], now); err != nil {
+		return nil, err
+	}
+	quotaReservations.runs[tenant]++
+	return func() {
+		quotaReservations.Lock()
+		defer quotaReservations.Unlock()
+		if quotaReservations.runs[tenant]--; quotaReservations.runs[tenant] <= 0 {
+			delete(quotaReservations.runs, tenant)
+		}
+	}, nil
+}
+
+// checkQuota reports whether the tenant may start another run while
+// inProgress others are running.
+func checkQuota(cfg serverConfig, tenant string, inProgress int, now time.Time) error {
+	q := cfg.Policy.Quota
+	u, err := dailyUsage(cfg, tenant, now)
+	if err != nil {
+		return err
+	}
+	if q.RunsPerDay > 0 && u.Runs+inProgress >= q.RunsPerDay {
+		return fmt.Errorf("daily run quota of %d reached", q.RunsPerDay)
+	}
+	if q.CostPerDay > 0 && u.Cost >= q.CostPerDay {
+		return fmt.Errorf("daily cost quota of $%.2f reached ($%.2f spent)", q.CostPerDay, u.Cost)
+	}
+	return nil
+}
+
+// policyMiddleware applies the tenant's default cwd, keeps the files of
+// opencode_run within its allowed directories and enforces its run quota.
+// It must run outside historyMiddleware so rejected runs aren't counted.
+func policyMiddleware(cfg serverConfig) toolMiddleware {
+	return func(next toolHandler) toolHandler {
+		return func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+			tcfg := cfg.forTenant(call.Tenant)
+			if call.Cwd == "" {
+				call.Cwd = tcfg.Policy.defaultDir()
+			}
+			if call.Name == toolRun {
+				if err := checkFiles(tcfg.Policy, call); err != nil {
+					return nil, errPolicyDenied.err(err.Error())
+				}
+				release, err := reserveQuota(tcfg, call.Tenant, time.Now())
+				if err != nil {
+					return nil, errQuotaExceeded.err(err.Error())
+				}
+				defer release()
+			}
+			return next(ctx, call)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test the directory allowlist
+func TestTenantPolicyCheckDir(t *testing.T) {
+	root := t.TempDir()
+	allowed := filepath.Join(root, "work")
+	if err := os.MkdirAll(filepath.Join(allowed, "repo"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	p := tenantPolicy{AllowedDirs: []string{allowed}}
+	tests := map[string]bool{
+		allowed:                        true,
+		filepath.Join(allowed, "repo"): true,
+		filepath.Join(allowed, "repo", "..", ".."): false,
+		root:               false,
+		root + "/workshop": false,
+		"":                 false,
+	}
+	for dir, want := range tests {
+		if err := p.checkDir(dir); (err == nil) != want {
+			t.Errorf("checkDir(%q) = %v, want allowed=%v", dir, err, want)
+		}
+	}
+	if err := (tenantPolicy{}).checkDir(root); err != nil {
+		t.Errorf("no allowlist: checkDir = %v", err)
+	}
+}
+
+// Test validation of the tenants config section
+func TestValidateTenantPolicies(t *testing.T) {
+	if err := validateTenantPolicies(map[string]tenantPolicy{"team-a": {AllowedDirs: []string{"/srv"}}}); err != nil {
+		t.Errorf("valid policy: %v", err)
+	}
+	bad := []map[string]tenantPolicy{
+		{"team a": {}},
+		{"team-a": {AllowedDirs: []string{"relative"}}},
+		{"team-a": {Quota: tenantQuota{RunsPerDay: -1}}},
+	}
+	for _, tenants := range bad {
+		if err := validateTenantPolicies(tenants); err == nil {
+			t.Errorf("validateTenantPolicies(%+v) = nil, want error", tenants)
+		}
+	}
+}
+
+// Test tenant defaults are applied with request > tenant > global precedence
+// and the daily run quota is enforced
+func TestTenantPolicyResolution(t *testing.T) {
+	tenantDir := t.TempDir()
+	script := filepath.Join(t.TempDir(), "opencode")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho \"Args: $@\"\npwd\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	st, _ := openStore("")
+	cfg := serverConfig{
+		Target:         script,
+		DefaultTimeout: 5 * time.Second,
+		DefaultModel:   "global/model",
+		Store:          st,
+		Tenants: map[string]tenantPolicy{
+			"team-a": {DefaultModel: "tenant/model", TimeoutSec: 9, AllowedDirs: []string{tenantDir}, Quota: tenantQuota{RunsPerDay: 2}},
+		},
+	}
+	if got := cfg.forTenant("team-a").DefaultTimeout; got != 9*time.Second {
+		t.Errorf("tenant timeout = %v", got)
+	}
+	if got := cfg.forTenant("team-b").DefaultTimeout; got != 5*time.Second {
+		t.Errorf("other tenant timeout = %v", got)
+	}
+
+	tools := newToolHandler(cfg)
+	run := func(args string) (*toolCallResult, *mcpError) {
+		return tools(context.Background(), &toolCall{ID: json.RawMessage("1"), Name: toolRun, Tenant: "team-a", Arguments: json.RawMessage(args)})
+	}
+	result, mErr := run(`{"message":"hi"}`)
+	if mErr != nil {
+		t.Fatalf("run: %v", mErr)
+	}
+	if text := result.Content[0].Text; !strings.Contains(text, "--model tenant/model") || !strings.Contains(text, tenantDir) {
+		t.Errorf("tenant defaults not applied: %s", text)
+	}
+	result, mErr = run(`{"message":"hi","model":"request/model"}`)
+	if mErr != nil || !strings.Contains(result.Content[0].Text, "--model request/model") {
+		t.Errorf("request model not preferred: %v %+v", mErr, result)
+	}
+	if _, mErr := run(`{"message":"hi","cwd":"/"}`); mErr == nil || mErr.Code != -32000 {
+		t.Errorf("third run: %v, want quota error", mErr)
+	}
+
+	// A fresh quota still rejects directories outside the allowlist.
+	cfg.Store, _ = openStore("")
+	tools = newToolHandler(cfg)
+	if _, mErr := run(`{"message":"hi","cwd":"/"}`); mErr == nil || mErr.Code != -32602 || !strings.Contains(mErr.Message, "outside") {
+		t.Errorf("cwd outside allowlist: %v", mErr)
+	}
+	// So are attached files
+	for _, files := range []string{`["/etc/passwd"]`, `["notes.md","../secret"]`} {
+		if _, mErr := run(`{"message":"hi","files":` + files + `}`); mErr == nil || mErr.Code != -32602 || !strings.Contains(mErr.Message, "outside") {
+			t.Errorf("files %s: %v", files, mErr)
+		}
+	}
+	if _, mErr := run(`{"message":"hi","files":["notes.md"]}`); mErr != nil {
+		t.Errorf("file inside allowlist: %v", mErr)
+	}
+}
+
+// Test concurrent runs can't all pass the quota check
+func TestQuotaReservation(t *testing.T) {
+	dir := t.TempDir()
+	done := filepath.Join(dir, "done")
+	script := filepath.Join(dir, "opencode")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\nwhile [ ! -f "+done+" ]; do sleep 0.02; done\necho ok\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	st, _ := openStore("")
+	cfg := serverConfig{
+		Target:         script,
+		DefaultTimeout: 5 * time.Second,
+		Store:          st,
+		Tenants:        map[string]tenantPolicy{"team-a": {Quota: tenantQuota{RunsPerDay: 2}}},
+	}
+	tools := newToolHandler(cfg)
+	errs := make(chan *mcpError, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			_, mErr := tools(context.Background(), &toolCall{ID: json.RawMessage("1"), Name: toolRun, Tenant: "team-a", Arguments: json.RawMessage(`{"message":"hi"}`)})
+			errs <- mErr
+		}()
+	}
+	// The run over the quota is rejected while the others still run
+	select {
+	case mErr := <-errs:
+		if mErr == nil || !strings.Contains(mErr.Message, "quota") {
+			t.Errorf("first to return: %v, want the quota error", mErr)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("all three runs started")
+	}
+	if err := os.WriteFile(done, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if mErr := <-errs; mErr != nil {
+			t.Errorf("run within the quota: %v", mErr)
+		}
+	}
+	if n := len(quotaReservations.runs); n != 0 {
+		t.Errorf("%d reservations left", n)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const preferencesCollection = "preferences"
+
+// clientPreferences are saved per API key so thin clients don't have to
+// resend them on every call. They fill in what a call leaves out: explicit
+// arguments and headers always win.
+type clientPreferences struct {
+	Model         string    `json:"model,omitempty"`         // opencode_run model when neither the call nor its project sets one
+	Render        string    `json:"render,omitempty"`        // full (default) or answer: just the final answer
+	Notifications string    `json:"notifications,omitempty"` // all (default), progress or none
+	Language      string    `json:"language,omitempty"`      // used without an Accept-Language header
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
+
+const (
+	renderFull   = "full"
+	renderAnswer = "answer"
+)
+
+func (p clientPreferences) validate() error {
+	switch p.Render {
+	case "", renderFull, renderAnswer:
+	default:
+		return fmt.Errorf("render must be full or answer")
+	}
+	switch p.Notifications {
+	case "", "all", channelProgress, channelNone:
+	default:
+		return fmt.Errorf("notifications must be all, progress or none")
+	}
+	if p.Language != "" && !supportedLocales[p.Language] {
+		return fmt.Errorf("unsupported language %q", p.Language)
+	}
+	return nil
+}
+
+// apiKeyFromRequest returns the caller's API key, from X-API-Key or a
+// bearer token.
+func apiKeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+// preferencesKey is where the preferences of apiKey are stored: keys are
+// only stored hashed.
+func preferencesKey(tenant, apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return tenantOrDefault(tenant) + "/" + hex.EncodeToString(sum[:16])
+}
+
+// loadPreferences returns the preferences saved for the request's API key;
+// none without a key or a store.
+func loadPreferences(cfg serverConfig, r *http.Request) clientPreferences {
+	var p clientPreferences
+	key := apiKeyFromRequest(r)
+	if key == "" || cfg.Store == nil {
+		return p
+	}
+	if _, err := cfg.Store.get(preferencesCollection, preferencesKey(tenantFromRequest(r), key), &p); err != nil {
+		log.Printf("[preferences] lookup: %v", err)
+	}
+	return p
+}
+
+// preferencesMiddleware applies the caller's preferences to a call.
+func preferencesMiddleware(next toolHandler) toolHandler {
+	return func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+		p := call.Preferences
+		if p.Model != "" && call.Name == toolRun {
+			var args map[string]any
+			if json.Unmarshal(call.Arguments, &args) == nil && args["model"] == nil {
+				args["model"] = p.Model
+				call.Arguments, _ = json.Marshal(args)
+			}
+		}
+		if notify := call.notify; notify != nil && p.Notifications != "" && p.Notifications != "all" {
+			call.notify = func(msg any) {
+				m, _ := msg.(map[string]any)
+				if p.Notifications == channelProgress && m["method"] == "notifications/progress" {
+					notify(msg)
+				}
+			}
+		}
+		result, mErr := next(ctx, call)
+		if result != nil && !result.IsError && p.Render == renderAnswer && result.answer != "" && len(result.Content) > 0 {
+			result.Content[0].Text = result.answer
+		}
+		return result, mErr
+	}
+}
+
+// registerPreferenceRoutes adds GET/PUT/DELETE /preferences for the
+// caller's API key.
+func registerPreferenceRoutes(mux *http.ServeMux, cfg serverConfig) {
+	handle := func(fn func(w http.ResponseWriter, r *http.Request, key string)) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			apiKey := apiKeyFromRequest(r)
+			switch {
+			case apiKey == "":
+				http.Error(w, "missing API key (X-API-Key or Authorization: Bearer)", http.StatusUnauthorized)
+			case cfg.Store == nil:
+				http.Error(w, errNoStore.Error(), http.StatusServiceUnavailable)
+			default:
+				fn(w, r, preferencesKey(tenantFromRequest(r), apiKey))
+			}
+		}
+	}
+
+	mux.HandleFunc("GET /preferences", handle(func(w http.ResponseWriter, r *http.Request, key string) {
+		var p clientPreferences
+		if _, err := cfg.Store.get(preferencesCollection, key, &p); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, p)
+	}))
+	mux.HandleFunc("PUT /preferences", handle(func(w http.ResponseWriter, r *http.Request, key string) {
+		var p clientPreferences
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			http.Error(w, "invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if err := p.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		p.UpdatedAt = localTime(time.Now())
+		if err := cfg.Store.put(preferencesCollection, key, p); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, p)
+	}))
+	mux.HandleFunc("DELETE /preferences", handle(func(w http.ResponseWriter, r *http.Request, key string) {
+		if _, err := cfg.Store.delete(preferencesCollection, key); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+}
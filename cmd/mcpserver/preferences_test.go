@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test saved preferences apply to calls of the same API key only
+func TestClientPreferences(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "opencode")
+	content := `#!/bin/sh
+model=; prev=
+for arg; do
+  [ "$prev" = --model ] && model=$arg
+  prev=$arg
+done
+printf '{"type":"text","part":{"text":"answer from %s"}}\n' "$model"
+echo '{"type":"tool_use","part":{"tool":"bash","state":{"status":"completed","output":"tool noise"}}}'
+`
+	if err := os.WriteFile(script, []byte(content), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	st, _ := openStore("")
+	cfg := serverConfig{Target: script, DefaultTimeout: 5 * time.Second, Store: st}
+	mux := http.NewServeMux()
+	registerPreferenceRoutes(mux, cfg)
+	mux.Handle("/mcp", newMCPHandler(&sessionStore{sessions: make(map[string]*session)}, cfg))
+	do := func(method, path, apiKey, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if apiKey != "" {
+			req.Header.Set("Authorization", "Bearer "+apiKey)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do(http.MethodPut, "/preferences", "", `{}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("no key: %d", w.Code)
+	}
+	if w := do(http.MethodPut, "/preferences", "k1", `{"render":"pretty"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid render: %d", w.Code)
+	}
+	if w := do(http.MethodPut, "/preferences", "k1", `{"model":"pref/model","render":"answer","notifications":"none","language":"zh"}`); w.Code != http.StatusOK {
+		t.Fatalf("put: %d %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodGet, "/preferences", "k1", ""); !strings.Contains(w.Body.String(), `"model":"pref/model"`) {
+		t.Errorf("get = %s", w.Body)
+	}
+	for _, doc := range st.list(preferencesCollection, "") {
+		if strings.Contains(string(doc), "k1") {
+			t.Error("the API key was stored")
+		}
+	}
+
+	run := func(apiKey, args string) (contentType string, text string) {
+		w := do(http.MethodPost, "/mcp", apiKey, `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"opencode_run","arguments":`+args+`}}`)
+		body := w.Body.String()
+		if i := strings.LastIndex(body, "data: "); i >= 0 {
+			body = body[i+len("data: "):]
+		}
+		var resp struct {
+			Result toolCallResult `json:"result"`
+		}
+		_ = json.Unmarshal([]byte(body), &resp)
+		if len(resp.Result.Content) == 0 {
+			t.Fatalf("response = %s", w.Body)
+		}
+		return w.Header().Get("Content-Type"), resp.Result.Content[0].Text
+	}
+	if ct, text := run("k1", `{"message":"hi"}`); text != "answer from pref/model" || ct != "application/json" {
+		t.Errorf("k1: %s %q", ct, text)
+	}
+	if _, text := run("k1", `{"message":"hi","model":"explicit/model"}`); text != "answer from explicit/model" {
+		t.Errorf("explicit model: %q", text)
+	}
+	if ct, text := run("k2", `{"message":"hi"}`); strings.Contains(text, "pref/model") || !strings.Contains(text, "tool noise") || !strings.HasPrefix(ct, "text/event-stream") {
+		t.Errorf("k2: %s %q", ct, text)
+	}
+
+	w := do(http.MethodPost, "/mcp", "k1", `{"jsonrpc":"2.0","id":2,"method":"nope"}`)
+	if w.Header().Get("Content-Language") != "zh" || !strings.Contains(w.Body.String(), "方法不存在") {
+		t.Errorf("language: %s %s", w.Header().Get("Content-Language"), w.Body)
+	}
+	if w := do(http.MethodDelete, "/preferences", "k1", ""); w.Code != http.StatusNoContent {
+		t.Errorf("delete: %d", w.Code)
+	}
+}
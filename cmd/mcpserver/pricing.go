@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// modelCost is a model's price in USD per million tokens.
+type modelCost struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+}
+
+// defaultPricing is a small built-in catalog used when neither the config
+// file nor opencode reports a price. Keys are "provider/name" or
+// "provider/*" for a whole provider.
+var defaultPricing = map[string]modelCost{
+	"anthropic/claude-sonnet-4":   {Input: 3, Output: 15},
+	"anthropic/claude-sonnet-4-5": {Input: 3, Output: 15},
+	"anthropic/claude-opus-4-1":   {Input: 15, Output: 75},
+	"anthropic/claude-haiku-4-5":  {Input: 1, Output: 5},
+	"openai/gpt-5":                {Input: 1.25, Output: 10},
+	"openai/gpt-5-mini":           {Input: 0.25, Output: 2},
+	"openai/gpt-4o":               {Input: 2.5, Output: 10},
+	"github-copilot/*":            {}, // covered by the Copilot subscription
+}
+
+// priceFor looks up the price of model: config file pricing first, then the
+// cost reported by opencode, then the built-in catalog. source names where
+// the price came from.
+func priceFor(cfg serverConfig, model string) (price modelCost, source string, err error) {
+	if p, ok := lookupPrice(cfg.Pricing, model); ok {
+		return p, "config", nil
+	}
+	for _, m := range fetchModelInfo(cfg) {
+		if m.ID == model && m.Cost != nil {
+			return *m.Cost, "opencode", nil
+		}
+	}
+	if p, ok := lookupPrice(defaultPricing, model); ok {
+		return p, "builtin", nil
+	}
+	return modelCost{}, "", fmt.Errorf("no pricing for model %s (add it under \"pricing\" in MCP_CONFIG)", model)
+}
+
+func lookupPrice(catalog map[string]modelCost, model string) (modelCost, bool) {
+	if p, ok := catalog[model]; ok {
+		return p, true
+	}
+	if provider, _, ok := strings.Cut(model, "/"); ok {
+		p, ok := catalog[provider+"/*"]
+		return p, ok
+	}
+	return modelCost{}, false
+}
+
+// cost returns the USD cost of the given token counts.
+func (c modelCost) cost(inputTokens, outputTokens int) float64 {
+	return (float64(inputTokens)*c.Input + float64(outputTokens)*c.Output) / 1e6
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	progressStatsCollection = "progress_stats"
+
+	// minEstimateRuns is how many finished runs of a kind it takes before
+	// their progress is estimated.
+	minEstimateRuns = 3
+	// estimateWindow is the number of recent runs the averages follow;
+	// older runs fade out.
+	estimateWindow = 20
+)
+
+var progressStatsMu sync.Mutex // serializes stats updates
+
+// progressStats are the average step count and duration of a tenant's
+// successful runs of one profile (agent and model) and prompt length
+// bucket.
+type progressStats struct {
+	Runs       int     `json:"runs"`
+	Steps      float64 `json:"steps"`
+	DurationMs float64 `json:"durationMs"`
+}
+
+// add folds a run into the averages, weighting the last estimateWindow
+// runs alike.
+func (s *progressStats) add(steps int, d time.Duration) {
+	s.Runs++
+	w := 1 / float64(min(s.Runs, estimateWindow))
+	s.Steps += w * (float64(steps) - s.Steps)
+	s.DurationMs += w * (float64(d.Milliseconds()) - s.DurationMs)
+}
+
+// progressStatsKey groups runs by tenant, profile and prompt length.
+func progressStatsKey(tenant, agent, model, message string) string {
+	if agent == "" {
+		agent = "default"
+	}
+	if model == "" {
+		model = "default"
+	}
+	return tenantOrDefault(tenant) + "/" + agent + "/" + model + "/" + lengthBucket(message)
+}
+
+// runEstimate follows a run's steps and, with a baseline of similar runs,
+// estimates how far along it is.
+type runEstimate struct {
+	start    time.Time
+	steps    atomic.Int32
+	baseline progressStats // zero Runs: nothing to estimate from
+}
+
+// step counts a finished step.
+func (e *runEstimate) step() {
+	if e != nil {
+		e.steps.Add(1)
+	}
+}
+
+// percent estimates the run's completion from its steps and elapsed time,
+// whichever is further along against the baseline. It approaches but
+// never reaches 100 when the run takes longer than usual, so that the
+// value keeps increasing as progress must. ok is false without a baseline.
+func (e *runEstimate) percent(now time.Time) (pct float64, ok bool) {
+	if e == nil || e.baseline.Runs < minEstimateRuns || e.baseline.DurationMs <= 0 {
+		return 0, false
+	}
+	f := now.Sub(e.start).Seconds() * 1000 / e.baseline.DurationMs
+	if e.baseline.Steps > 0 {
+		f = max(f, float64(e.steps.Load())/e.baseline.Steps)
+	}
+	if f <= 0.9 {
+		return 100 * f, true
+	}
+	return 90 + 9.9*(1-math.Exp(-5*(f-0.9))), true
+}
+
+// progressEstimateMiddleware gives opencode_run calls an estimate from the
+// tenant's earlier runs of the same profile and prompt length, and adds
+// successful runs to the averages.
+func progressEstimateMiddleware(cfg serverConfig) toolMiddleware {
+	return func(next toolHandler) toolHandler {
+		return func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+			if cfg.Store == nil || call.Name != toolRun {
+				return next(ctx, call)
+			}
+			args, mErr := parseRunArgs(call)
+			if mErr != nil {
+				return next(ctx, call)
+			}
+			if args.Model == "" {
+				args.Model = cfg.DefaultModel
+			}
+			key := progressStatsKey(call.Tenant, args.Agent, args.Model, args.Message)
+			est := &runEstimate{start: time.Now()}
+			if _, err := cfg.Store.get(progressStatsCollection, key, &est.baseline); err != nil {
+				log.Printf("[progress] stats %s: %v", key, err)
+			}
+			call.estimate = est
+
+			result, mErr := next(ctx, call)
+			if mErr == nil && result != nil && !result.IsError {
+				if err := recordProgressStats(cfg.Store, key, int(est.steps.Load()), time.Since(est.start)); err != nil {
+					log.Printf("[progress] stats %s not recorded: %v", key, err)
+				}
+			}
+			return result, mErr
+		}
+	}
+}
+
+// recordProgressStats adds a successful run to the stats under key.
+func recordProgressStats(s *store, key string, steps int, d time.Duration) error {
+	progressStatsMu.Lock()
+	defer progressStatsMu.Unlock()
+	var stats progressStats
+	if _, err := s.get(progressStatsCollection, key, &stats); err != nil {
+		return err
+	}
+	stats.add(steps, d)
+	return s.put(progressStatsCollection, key, stats)
+}
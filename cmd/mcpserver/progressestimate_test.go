@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// Test runs get a percentage once similar runs have finished, and only
+// runs of the same profile and prompt length count as similar
+func TestProgressEstimate(t *testing.T) {
+	st, _ := openStore("")
+	cfg := serverConfig{Store: st, DefaultModel: "p/m"}
+	var sent []map[string]any
+	fail := false
+	handler := progressEstimateMiddleware(cfg)(func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+		call.estimate.step()
+		call.progress(1, "step")
+		time.Sleep(5 * time.Millisecond)
+		call.estimate.step()
+		if fail {
+			return &toolCallResult{IsError: true}, nil
+		}
+		return &toolCallResult{}, nil
+	})
+	run := func(args string) map[string]any {
+		sent = nil
+		call := &toolCall{ID: json.RawMessage("1"), Name: toolRun, Arguments: json.RawMessage(args)}
+		call.notify = func(msg any) { sent = append(sent, msg.(map[string]any)["params"].(map[string]any)) }
+		if _, mErr := handler(context.Background(), call); mErr != nil {
+			t.Fatal(mErr)
+		}
+		return sent[0]
+	}
+
+	for i := 0; i < minEstimateRuns; i++ {
+		if p := run(`{"message":"fix the tests"}`); p["total"] != nil || p["progress"] != 1 {
+			t.Fatalf("run %d without a baseline: %v", i, p)
+		}
+	}
+	// Failed runs don't count
+	fail = true
+	run(`{"message":"fix the tests"}`)
+	fail = false
+
+	var stats progressStats
+	if ok, _ := st.get(progressStatsCollection, progressStatsKey("", "", "p/m", "fix the tests"), &stats); !ok || stats.Runs != minEstimateRuns || stats.Steps != 2 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	p := run(`{"message":"fix the tests"}`)
+	if pct, _ := p["progress"].(float64); p["total"] != 100 || pct < 50 || pct >= 100 {
+		t.Errorf("after %d runs: %v, want about half of 100 after 1 of 2 steps", minEstimateRuns, p)
+	}
+	if p := run(`{"message":"fix the tests","agent":"plan"}`); p["total"] != nil {
+		t.Errorf("other agent: %v", p)
+	}
+	if p := run(`{"message":"fix the tests","model":"p/other"}`); p["total"] != nil {
+		t.Errorf("other model: %v", p)
+	}
+}
+
+// Test the estimate keeps increasing past the usual duration without
+// reaching 100
+func TestRunEstimatePercent(t *testing.T) {
+	start := time.Now()
+	e := &runEstimate{start: start, baseline: progressStats{Runs: 5, Steps: 4, DurationMs: 1000}}
+	last := -1.0
+	for _, elapsed := range []time.Duration{0, 300 * time.Millisecond, 900 * time.Millisecond, time.Second, 2 * time.Second, time.Minute} {
+		pct, ok := e.percent(start.Add(elapsed))
+		if !ok || pct <= last || pct >= 100 {
+			t.Errorf("after %s: %v (last %v)", elapsed, pct, last)
+		}
+		last = pct
+	}
+	e.steps.Store(2)
+	if pct, _ := e.percent(start); pct != 50 {
+		t.Errorf("2 of 4 steps at once = %v, want 50", pct)
+	}
+	if _, ok := (&runEstimate{baseline: progressStats{Runs: 1, DurationMs: 1000}}).percent(start); ok {
+		t.Error("estimated from a single run")
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	toolProjectList    = "opencode_project_list"
+	projectsCollection = "projects"
+)
+
+// project is a registered project: a friendly name for a directory, with
+// defaults for the runs in it. Tool calls may pass "project" instead of
+// "cwd".
+type project struct {
+	Name        string   `json:"name,omitempty"` // the key in the config file
+	Path        string   `json:"path"`
+	Model       string   `json:"model,omitempty"`
+	Agent       string   `json:"agent,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Warmup      []string `json:"warmup,omitempty"` // shell commands that fill dependency caches
+	Source      string   `json:"source,omitempty"` // config or admin; set when listing
+}
+
+var projectListSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"projects": map[string]any{
+			"type": "array",
+			"items": map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"name":        map[string]any{"type": "string"},
+					"path":        map[string]any{"type": "string"},
+					"model":       map[string]any{"type": "string"},
+					"agent":       map[string]any{"type": "string"},
+					"description": map[string]any{"type": "string"},
+					"warmup":      map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+					"source":      map[string]any{"type": "string"},
+				},
+				"required": []string{"name", "path"},
+			},
+		},
+	},
+	"required": []string{"projects"},
+}
+
+var projectNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+func (p project) validate() error {
+	if !projectNameRe.MatchString(p.Name) {
+		return fmt.Errorf("invalid project name %q", p.Name)
+	}
+	if !filepath.IsAbs(p.Path) {
+		return fmt.Errorf("path %q is not an absolute path", p.Path)
+	}
+	for i, command := range p.Warmup {
+		if strings.TrimSpace(command) == "" {
+			return fmt.Errorf("warmup[%d] is empty", i)
+		}
+	}
+	return nil
+}
+
+func validateProjects(projects map[string]project) error {
+	for name, p := range projects {
+		p.Name = name
+		if err := p.validate(); err != nil {
+			return fmt.Errorf("projects.%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// projectRegistry resolves project names: those of the config file, and
+// those registered through the admin API, which are kept in the store so
+// every instance sharing it sees them.
+type projectRegistry struct {
+	static map[string]project
+	store  *store
+}
+
+func newProjectRegistry(static map[string]project, st *store) *projectRegistry {
+	r := &projectRegistry{static: make(map[string]project, len(static)), store: st}
+	for name, p := range static {
+		p.Name, p.Source = name, "config"
+		r.static[name] = p
+	}
+	return r
+}
+
+// get returns the named project; config file entries win over registered
+// ones.
+func (r *projectRegistry) get(name string) (project, bool) {
+	if r == nil {
+		return project{}, false
+	}
+	if p, ok := r.static[name]; ok {
+		return p, true
+	}
+	var p project
+	if r.store == nil {
+		return p, false
+	}
+	ok, err := r.store.get(projectsCollection, name, &p)
+	if err != nil {
+		log.Printf("[projects] get %s: %v", name, err)
+	}
+	p.Source = "admin"
+	return p, ok && err == nil
+}
+
+// list returns all projects ordered by name.
+func (r *projectRegistry) list() []project {
+	if r == nil {
+		return []project{}
+	}
+	byName := map[string]project{}
+	if r.store != nil {
+		for _, doc := range r.store.list(projectsCollection, "") {
+			var p project
+			if err := json.Unmarshal(doc, &p); err == nil {
+				p.Source = "admin"
+				byName[p.Name] = p
+			}
+		}
+	}
+	for name, p := range r.static {
+		byName[name] = p
+	}
+	out := make([]project, 0, len(byName))
+	for _, p := range byName {
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+var errConfigProject = errors.New("defined in the config file")
+
+// put registers or replaces a project.
+func (r *projectRegistry) put(p project) error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+	if _, ok := r.static[p.Name]; ok {
+		return errConfigProject
+	}
+	if r.store == nil {
+		return errors.New("no store to register projects in")
+	}
+	p.Source = ""
+	return r.store.put(projectsCollection, p.Name, p)
+}
+
+// delete removes a registered project, reporting whether it existed.
+func (r *projectRegistry) delete(name string) (bool, error) {
+	if _, ok := r.static[name]; ok {
+		return false, errConfigProject
+	}
+	if r.store == nil {
+		return false, nil
+	}
+	return r.store.delete(projectsCollection, name)
+}
+
+// projectMiddleware resolves a "project" argument into the project's path
+// as "cwd", and, for opencode_run, its default model and agent.
+func projectMiddleware(cfg serverConfig) toolMiddleware {
+	return func(next toolHandler) toolHandler {
+		return func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+			var args map[string]any
+			if json.Unmarshal(call.Arguments, &args) != nil {
+				return next(ctx, call)
+			}
+			name, _ := args["project"].(string)
+			if name == "" {
+				return next(ctx, call)
+			}
+			p, ok := cfg.Projects.get(name)
+			if !ok {
+				var mErr *mcpError
+				if p, mErr = elicitProject(ctx, cfg, call, name); mErr != nil {
+					return nil, mErr
+				}
+			}
+			if cwd, _ := args["cwd"].(string); cwd != "" && filepath.Clean(cwd) != filepath.Clean(p.Path) {
+				return nil, errInvalidArguments.err("pass either project or cwd, not both")
+			}
+			applyProject(call, args, p)
+			return next(ctx, call)
+		}
+	}
+}
+
+// applyProject points the call with arguments args at project p: its path
+// as the cwd and, for opencode_run, its model and agent unless given.
+func applyProject(call *toolCall, args map[string]any, p project) {
+	delete(args, "project")
+	args["cwd"] = p.Path
+	if call.Name == toolRun {
+		if args["model"] == nil && p.Model != "" {
+			args["model"] = p.Model
+		}
+		if args["agent"] == nil && p.Agent != "" {
+			args["agent"] = p.Agent
+		}
+	}
+	call.Arguments, _ = json.Marshal(args)
+	call.Cwd = p.Path
+}
+
+// projectListTool implements opencode_project_list.
+func projectListTool(cfg serverConfig) *toolCallResult {
+	projects := cfg.Projects.list()
+	var lines []string
+	for _, p := range projects {
+		line := p.Name + "  " + p.Path
+		if p.Description != "" {
+			line += "  " + p.Description
+		}
+		lines = append(lines, line)
+	}
+	return &toolCallResult{
+		Content:           []toolContent{{Type: "text", Text: strings.Join(lines, "\n")}},
+		StructuredContent: map[string]any{"projects": projects},
+	}
+}
+
+// withProjectArg returns schema with a "project" property added when it
+// has a "cwd" one.
+func withProjectArg(schema any) any {
+	s, ok := schema.(map[string]any)
+	if !ok {
+		return schema
+	}
+	props, _ := s["properties"].(map[string]any)
+	if _, ok := props["cwd"]; !ok {
+		return schema
+	}
+	p := make(map[string]any, len(props)+1)
+	for k, v := range props {
+		p[k] = v
+	}
+	p["project"] = map[string]any{
+		"type":        "string",
+		"description": "Registered project name (see " + toolProjectList + "), instead of cwd",
+	}
+	out := make(map[string]any, len(s))
+	for k, v := range s {
+		out[k] = v
+	}
+	out["properties"] = p
+	return out
+}
+
+// registerProjectRoutes adds the admin API for the registry:
+// GET /admin/projects, PUT and DELETE /admin/projects/{name}.
+func registerProjectRoutes(mux *http.ServeMux, cfg serverConfig, admin func(http.HandlerFunc) http.HandlerFunc) {
+	mux.HandleFunc("GET /admin/projects", admin(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"projects": cfg.Projects.list()})
+	}))
+	mux.HandleFunc("PUT /admin/projects/{name}", admin(func(w http.ResponseWriter, r *http.Request) {
+		var p project
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		p.Name = r.PathValue("name")
+		if err := cfg.Projects.put(p); err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, errConfigProject) {
+				status = http.StatusConflict
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		log.Printf("[projects] registered %s -> %s", p.Name, p.Path)
+		go cfg.Warmup.warm(p)
+		writeJSON(w, http.StatusOK, p)
+	}))
+	mux.HandleFunc("DELETE /admin/projects/{name}", admin(func(w http.ResponseWriter, r *http.Request) {
+		ok, err := cfg.Projects.delete(r.PathValue("name"))
+		switch {
+		case errors.Is(err, errConfigProject):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		case !ok:
+			http.Error(w, "unknown project", http.StatusNotFound)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Test a "project" argument resolves to the project's path and defaults
+func TestProjectMiddleware(t *testing.T) {
+	st, _ := openStore("")
+	cfg := serverConfig{Projects: newProjectRegistry(map[string]project{
+		"billing": {Path: "/srv/billing", Model: "p/fast", Agent: "build"},
+	}, st)}
+	if err := cfg.Projects.put(project{Name: "web", Path: "/srv/web"}); err != nil {
+		t.Fatal(err)
+	}
+	var got *toolCall
+	handler := projectMiddleware(cfg)(func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+		got = call
+		return &toolCallResult{}, nil
+	})
+	call := func(name, args string) (map[string]any, *mcpError) {
+		got = nil
+		_, mErr := handler(context.Background(), &toolCall{Name: name, Arguments: json.RawMessage(args)})
+		var out map[string]any
+		if got != nil {
+			_ = json.Unmarshal(got.Arguments, &out)
+		}
+		return out, mErr
+	}
+
+	args, mErr := call(toolRun, `{"message":"hi","project":"billing","model":"p/slow"}`)
+	if mErr != nil || args["cwd"] != "/srv/billing" || args["model"] != "p/slow" || args["agent"] != "build" || args["project"] != nil || got.Cwd != "/srv/billing" {
+		t.Errorf("billing run: args = %v, cwd %q, %v", args, got.Cwd, mErr)
+	}
+	if args, mErr = call(toolExec, `{"args":["x"],"project":"web"}`); mErr != nil || args["cwd"] != "/srv/web" || args["model"] != nil {
+		t.Errorf("web exec: args = %v, %v", args, mErr)
+	}
+	if _, mErr = call(toolRun, `{"message":"hi","project":"nope"}`); mErr == nil || mErr.Data.Code != errInvalidArguments.Code {
+		t.Errorf("unknown project: %v", mErr)
+	}
+	if _, mErr = call(toolRun, `{"message":"hi","project":"web","cwd":"/tmp"}`); mErr == nil {
+		t.Error("accepted both project and a different cwd")
+	}
+
+	result := projectListTool(cfg)
+	projects := result.StructuredContent.(map[string]any)["projects"].([]project)
+	if len(projects) != 2 || projects[0].Name != "billing" || projects[0].Source != "config" || projects[1].Source != "admin" {
+		t.Errorf("projects = %+v", projects)
+	}
+}
+
+// Test the admin API registers projects and protects config ones
+func TestProjectAdminRoutes(t *testing.T) {
+	st, _ := openStore("")
+	cfg := serverConfig{Projects: newProjectRegistry(map[string]project{"billing": {Path: "/srv/billing"}}, st)}
+	mux := http.NewServeMux()
+	registerProjectRoutes(mux, cfg, func(h http.HandlerFunc) http.HandlerFunc { return h })
+	do := func(method, path, body string) int {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec.Code
+	}
+	for _, tc := range []struct {
+		method, path, body string
+		want               int
+	}{
+		{"PUT", "/admin/projects/web", `{"path":"/srv/web","model":"p/m"}`, http.StatusOK},
+		{"PUT", "/admin/projects/web", `{"path":"relative"}`, http.StatusBadRequest},
+		{"PUT", "/admin/projects/billing", `{"path":"/elsewhere"}`, http.StatusConflict},
+		{"DELETE", "/admin/projects/billing", "", http.StatusConflict},
+		{"DELETE", "/admin/projects/web", "", http.StatusNoContent},
+		{"DELETE", "/admin/projects/web", "", http.StatusNotFound},
+	} {
+		if got := do(tc.method, tc.path, tc.body); got != tc.want {
+			t.Errorf("%s %s = %d, want %d", tc.method, tc.path, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"opencode-mcp/internal/mcp"
+)
+
+const promptsCollection = "prompts"
+
+var (
+	promptNameRe        = regexp.MustCompile(`^[A-Za-z0-9_.\-]{1,64}$`)
+	promptPlaceholderRe = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_]+)\s*\}\}`)
+
+	errNoStore = errors.New("no store configured")
+)
+
+// storedPrompt is a named prompt template saved by a tenant. Placeholders
+// like {{file}} are filled in from the prompts/get arguments.
+type storedPrompt struct {
+	Name        string           `json:"name"`
+	Description string           `json:"description,omitempty"`
+	Template    string           `json:"template"`
+	Arguments   []promptArgument `json:"arguments,omitempty"`
+	UpdatedAt   time.Time        `json:"updatedAt"`
+}
+
+type promptArgument struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Required    bool   `json:"required,omitempty"`
+	Default     string `json:"default,omitempty"` // used when the argument is not given
+}
+
+// promptLibrary is the per-tenant prompt CRUD on top of the store.
+type promptLibrary struct {
+	store *store
+}
+
+func promptKey(tenant, name string) string {
+	return tenantOrDefault(tenant) + "/" + name
+}
+
+// save creates or replaces p. Arguments default to the template's
+// placeholders, all required.
+func (l promptLibrary) save(tenant string, p storedPrompt) (storedPrompt, error) {
+	if l.store == nil {
+		return p, errNoStore
+	}
+	if !promptNameRe.MatchString(p.Name) {
+		return p, fmt.Errorf("invalid prompt name %q", p.Name)
+	}
+	if strings.TrimSpace(p.Template) == "" {
+		return p, errors.New("missing template")
+	}
+	if len(p.Arguments) == 0 {
+		for _, name := range promptPlaceholders(p.Template) {
+			p.Arguments = append(p.Arguments, promptArgument{Name: name, Required: true})
+		}
+	}
+	p.UpdatedAt = localTime(time.Now())
+	return p, l.store.put(promptsCollection, promptKey(tenant, p.Name), p)
+}
+
+func (l promptLibrary) get(tenant, name string) (storedPrompt, bool, error) {
+	var p storedPrompt
+	if l.store == nil {
+		return p, false, errNoStore
+	}
+	ok, err := l.store.get(promptsCollection, promptKey(tenant, name), &p)
+	return p, ok, err
+}
+
+func (l promptLibrary) list(tenant string) ([]storedPrompt, error) {
+	if l.store == nil {
+		return nil, errNoStore
+	}
+	prompts := []storedPrompt{}
+	for _, doc := range l.store.list(promptsCollection, promptKey(tenant, "")) {
+		var p storedPrompt
+		if err := json.Unmarshal(doc, &p); err != nil {
+			return nil, err
+		}
+		prompts = append(prompts, p)
+	}
+	return prompts, nil
+}
+
+func (l promptLibrary) remove(tenant, name string) (bool, error) {
+	if l.store == nil {
+		return false, errNoStore
+	}
+	return l.store.delete(promptsCollection, promptKey(tenant, name))
+}
+
+// render fills the template's placeholders from args.
+func (p storedPrompt) render(args map[string]string) (string, error) {
+	values, err := promptValues(p.Arguments, args)
+	if err != nil {
+		return "", err
+	}
+	return promptPlaceholderRe.ReplaceAllStringFunc(p.Template, func(m string) string {
+		return values[promptPlaceholderRe.FindStringSubmatch(m)[1]]
+	}), nil
+}
+
+// promptValues checks args has the required arguments and fills in the
+// defaults of missing ones.
+func promptValues(declared []promptArgument, args map[string]string) (map[string]string, error) {
+	values := make(map[string]string, len(args))
+	for k, v := range args {
+		values[k] = v
+	}
+	for _, a := range declared {
+		if values[a.Name] != "" {
+			continue
+		}
+		if a.Required {
+			return nil, fmt.Errorf("missing argument %q", a.Name)
+		}
+		values[a.Name] = a.Default
+	}
+	return values, nil
+}
+
+// promptPlaceholders returns the distinct placeholder names of template.
+func promptPlaceholders(template string) []string {
+	var names []string
+	for _, m := range promptPlaceholderRe.FindAllStringSubmatch(template, -1) {
+		names = appendUnique(names, m[1])
+	}
+	return names
+}
+
+// handlePromptsList answers prompts/list with the tenant's saved prompts,
+// then the templates they don't shadow.
+func handlePromptsList(_ context.Context, cfg serverConfig, req *mcpRequest) (any, *mcpError) {
+	prompts, err := promptLibrary{cfg.Store}.list(req.Tenant)
+	if err != nil && !errors.Is(err, errNoStore) {
+		return nil, rpcError(-32603, err.Error())
+	}
+	entries := make([]map[string]any, 0, len(prompts)+len(cfg.PromptTemplates))
+	saved := map[string]bool{}
+	add := func(name, description string, arguments []promptArgument) {
+		entry := map[string]any{"name": name, "arguments": arguments}
+		if description != "" {
+			entry["description"] = description
+		}
+		entries = append(entries, entry)
+	}
+	for _, p := range prompts {
+		saved[p.Name] = true
+		add(p.Name, p.Description, p.Arguments)
+	}
+	for _, t := range cfg.PromptTemplates {
+		if !saved[t.Name] {
+			add(t.Name, t.Description, t.Arguments)
+		}
+	}
+	page, next, mErr := mcp.Paginate(req.Params, entries, mcp.PageSize)
+	if mErr != nil {
+		return nil, errInvalidArguments.err(mErr.Message)
+	}
+	return mcp.ListResult("prompts", page, next), nil
+}
+
+// handlePromptsGet answers prompts/get by rendering the named prompt.
+func handlePromptsGet(_ context.Context, cfg serverConfig, req *mcpRequest) (any, *mcpError) {
+	var params struct {
+		Name      string            `json:"name"`
+		Arguments map[string]string `json:"arguments"`
+	}
+	if err := json.Unmarshal(req.Params, &params); err != nil || params.Name == "" {
+		return nil, rpcError(-32602, "invalid params")
+	}
+	p, ok, err := promptLibrary{cfg.Store}.get(req.Tenant, params.Name)
+	if err != nil && !errors.Is(err, errNoStore) {
+		return nil, rpcError(-32603, err.Error())
+	}
+	var text string
+	if ok {
+		text, err = p.render(params.Arguments)
+	} else if t, found := promptTemplateByName(cfg, params.Name); found {
+		var run map[string]any
+		run, err = t.render(params.Arguments)
+		p.Description, text = t.Description, runPromptMessage(run)
+	} else {
+		return nil, rpcError(-32602, fmt.Sprintf("unknown prompt: %s", params.Name))
+	}
+	if err != nil {
+		return nil, rpcError(-32602, err.Error())
+	}
+	return map[string]any{
+		"description": p.Description,
+		"messages": []map[string]any{{
+			"role":    "user",
+			"content": toolContent{Type: "text", Text: text},
+		}},
+	}, nil
+}
+
+// promptToolArgs are the arguments of the prompt library tools.
+type promptToolArgs struct {
+	Name        string           `json:"name"`
+	Description string           `json:"description"`
+	Template    string           `json:"template"`
+	Arguments   []promptArgument `json:"arguments"`
+}
+
+var promptSaveSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"name":        map[string]any{"type": "string", "description": "Prompt name (letters, digits, '_', '-', '.')"},
+		"description": map[string]any{"type": "string", "description": "Shown in prompts/list"},
+		"template":    map[string]any{"type": "string", "description": "Prompt text; {{arg}} placeholders are filled from prompts/get arguments"},
+		"arguments": map[string]any{
+			"type":        "array",
+			"description": "Argument definitions (default: every placeholder, required)",
+			"items": map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"name":        map[string]any{"type": "string"},
+					"description": map[string]any{"type": "string"},
+					"required":    map[string]any{"type": "boolean"},
+				},
+				"required": []string{"name"},
+			},
+		},
+	},
+	"required": []string{"name", "template"},
+}
+
+var promptNameSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"name": map[string]any{"type": "string", "description": "Prompt name"},
+	},
+	"required": []string{"name"},
+}
+
+// promptTool handles opencode_prompt_save, opencode_prompt_delete and
+// opencode_prompt_list for the caller's tenant.
+func promptTool(cfg serverConfig, call *toolCall) (*toolCallResult, *mcpError) {
+	var args promptToolArgs
+	if len(call.Arguments) > 0 {
+		if err := json.Unmarshal(call.Arguments, &args); err != nil {
+			return nil, errInvalidArguments.err("invalid arguments")
+		}
+	}
+	lib := promptLibrary{cfg.Store}
+
+	switch call.Name {
+	case toolPromptSave:
+		p, err := lib.save(call.Tenant, storedPrompt{
+			Name:        args.Name,
+			Description: args.Description,
+			Template:    args.Template,
+			Arguments:   args.Arguments,
+		})
+		if err != nil {
+			return nil, errInvalidArguments.err(err.Error())
+		}
+		log.Printf("[prompts] saved tenant=%s name=%s", call.Tenant, p.Name)
+		return &toolCallResult{
+			Content:           []toolContent{{Type: "text", Text: fmt.Sprintf("Saved prompt %s", p.Name)}},
+			StructuredContent: p,
+		}, nil
+
+	case toolPromptDelete:
+		if args.Name == "" {
+			return nil, errInvalidArguments.err("missing name")
+		}
+		ok, err := lib.remove(call.Tenant, args.Name)
+		if err != nil {
+			return nil, errInternal.err(err.Error())
+		}
+		if !ok {
+			return errorResult(fmt.Errorf("unknown prompt: %s", args.Name)), nil
+		}
+		log.Printf("[prompts] deleted tenant=%s name=%s", call.Tenant, args.Name)
+		return &toolCallResult{Content: []toolContent{{Type: "text", Text: fmt.Sprintf("Deleted prompt %s", args.Name)}}}, nil
+	}
+
+	prompts, err := lib.list(call.Tenant)
+	if err != nil {
+		return nil, errInternal.err(err.Error())
+	}
+	var lines []string
+	for _, p := range prompts {
+		line := p.Name
+		if p.Description != "" {
+			line += ": " + p.Description
+		}
+		lines = append(lines, line)
+	}
+	return &toolCallResult{
+		Content:           []toolContent{{Type: "text", Text: strings.Join(lines, "\n")}},
+		StructuredContent: map[string]any{"prompts": prompts},
+	}, nil
+}
+
+// registerPromptRoutes adds the REST endpoints of the prompt library:
+// GET /prompts, and GET/PUT/DELETE /prompts/{name}.
+func registerPromptRoutes(mux *http.ServeMux, cfg serverConfig) {
+	lib := promptLibrary{cfg.Store}
+
+	mux.HandleFunc("GET /prompts", func(w http.ResponseWriter, r *http.Request) {
+		prompts, err := lib.list(tenantFromRequest(r))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, prompts)
+	})
+	mux.HandleFunc("GET /prompts/{name}", func(w http.ResponseWriter, r *http.Request) {
+		p, ok, err := lib.get(tenantFromRequest(r), r.PathValue("name"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "prompt not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, p)
+	})
+	mux.HandleFunc("PUT /prompts/{name}", func(w http.ResponseWriter, r *http.Request) {
+		var p storedPrompt
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			http.Error(w, "invalid JSON", http.StatusBadRequest)
+			return
+		}
+		p.Name = r.PathValue("name")
+		p, err := lib.save(tenantFromRequest(r), p)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, p)
+	})
+	mux.HandleFunc("DELETE /prompts/{name}", func(w http.ResponseWriter, r *http.Request) {
+		ok, err := lib.remove(tenantFromRequest(r), r.PathValue("name"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "prompt not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test prompt CRUD via tools, prompts/list and prompts/get
+func TestPromptLibrary(t *testing.T) {
+	st, _ := openStore("")
+	cfg := serverConfig{Store: st}
+	tools := newToolHandler(cfg)
+
+	result, mErr := tools(context.Background(), &toolCall{ID: json.RawMessage("1"), Name: toolPromptSave, Arguments: json.RawMessage(
+		`{"name":"review","description":"Review a file","template":"Review {{file}} for {{ focus }} issues"}`)})
+	if mErr != nil {
+		t.Fatalf("save: %v", mErr)
+	}
+	saved := result.StructuredContent.(storedPrompt)
+	if len(saved.Arguments) != 2 || !saved.Arguments[1].Required || saved.Arguments[1].Name != "focus" {
+		t.Errorf("derived arguments = %+v", saved.Arguments)
+	}
+
+	handler := createMCPHandler(&sessionStore{sessions: make(map[string]*session)}, cfg)
+	resp := doMCPRequest(t, handler, "prompts/list", 1, nil)
+	b, _ := json.Marshal(resp.Result)
+	if !strings.Contains(string(b), `"name":"review"`) {
+		t.Errorf("prompts/list = %s", b)
+	}
+
+	resp = doMCPRequest(t, handler, "prompts/get", 2, map[string]any{
+		"name": "review", "arguments": map[string]string{"file": "main.go", "focus": "security"},
+	})
+	b, _ = json.Marshal(resp.Result)
+	if !strings.Contains(string(b), "Review main.go for security issues") {
+		t.Errorf("prompts/get = %s", b)
+	}
+
+	resp = doMCPRequest(t, handler, "prompts/get", 3, map[string]any{"name": "review", "arguments": map[string]string{"file": "x"}})
+	if resp.Error == nil || resp.Error.Code != -32602 {
+		t.Errorf("missing argument error = %+v", resp.Error)
+	}
+
+	if _, mErr := tools(context.Background(), &toolCall{ID: json.RawMessage("1"), Name: toolPromptDelete, Arguments: json.RawMessage(`{"name":"review"}`)}); mErr != nil {
+		t.Fatalf("delete: %v", mErr)
+	}
+	resp = doMCPRequest(t, handler, "prompts/get", 4, map[string]any{"name": "review"})
+	if resp.Error == nil {
+		t.Error("deleted prompt should be gone")
+	}
+}
+
+// Test the prompt REST endpoints are scoped per tenant
+func TestPromptRoutesTenantScope(t *testing.T) {
+	st, _ := openStore("")
+	mux := http.NewServeMux()
+	registerPromptRoutes(mux, serverConfig{Store: st})
+
+	do := func(method, path, tenant, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader([]byte(body)))
+		if tenant != "" {
+			req.Header.Set(tenantHeader, tenant)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(http.MethodPut, "/prompts/triage", "team-a", `{"template":"Triage {{issue}}"}`); rec.Code != http.StatusOK {
+		t.Fatalf("PUT = %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(http.MethodGet, "/prompts/triage", "team-a", ""); rec.Code != http.StatusOK {
+		t.Errorf("GET own prompt = %d", rec.Code)
+	}
+	if rec := do(http.MethodGet, "/prompts/triage", "team-b", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("GET other tenant's prompt = %d, want 404", rec.Code)
+	}
+	if rec := do(http.MethodGet, "/prompts", "", ""); strings.TrimSpace(rec.Body.String()) != "[]" {
+		t.Errorf("default tenant list = %s", rec.Body.String())
+	}
+	if rec := do(http.MethodPut, "/prompts/bad%20name", "team-a", `{"template":"x"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid name = %d, want 400", rec.Code)
+	}
+	if rec := do(http.MethodDelete, "/prompts/triage", "team-a", ""); rec.Code != http.StatusNoContent {
+		t.Errorf("DELETE = %d", rec.Code)
+	}
+}
+
+// Test templates from MCP_PROMPTS_DIR replace and extend the built-ins, and
+// expand into opencode_run arguments without a store
+func TestPromptTemplates(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"explain-diff.json": `{"description":"Team version","run":{"message":"Explain {{range}} briefly","files":["{{file}}"]}}`,
+		"triage.json":       `{"arguments":[{"name":"issue","required":true}],"run":{"message":"Triage {{issue}}","agent":"plan"}}`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	templates, err := loadPromptTemplates(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, tmpl := range templates {
+		names = append(names, tmpl.Name)
+	}
+	if strings.Join(names, ",") != "code-review,explain-diff,fix-failing-tests,triage" {
+		t.Errorf("templates = %v", names)
+	}
+	if diff, _ := promptTemplateByName(serverConfig{PromptTemplates: templates}, "explain-diff"); len(diff.Arguments) != 2 || !diff.Arguments[1].Required {
+		t.Errorf("derived arguments = %+v", diff.Arguments)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "bad.json"), []byte(`{"run":{}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadPromptTemplates(dir); err == nil || !strings.Contains(err.Error(), "bad.json") {
+		t.Errorf("template without a message: %v", err)
+	}
+
+	handler := createMCPHandler(&sessionStore{sessions: make(map[string]*session)}, serverConfig{PromptTemplates: builtinPromptTemplates})
+	resp := doMCPRequest(t, handler, "prompts/list", 1, nil)
+	b, _ := json.Marshal(resp.Result)
+	if !strings.Contains(string(b), `"name":"code-review"`) || !strings.Contains(string(b), `"name":"fix-failing-tests"`) {
+		t.Errorf("prompts/list without a store = %s", b)
+	}
+	resp = doMCPRequest(t, handler, "prompts/get", 2, map[string]any{"name": "code-review", "arguments": map[string]string{"target": "main.go"}})
+	b, _ = json.Marshal(resp.Result)
+	for _, want := range []string{"opencode_run", `\"message\": \"Review main.go. Focus on correctness, security and readability.`, `\"agent\": \"plan\"`} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("prompts/get = %s, want %s", b, want)
+		}
+	}
+	if strings.Contains(string(b), "cwd") {
+		t.Errorf("empty cwd kept: %s", b)
+	}
+	if resp = doMCPRequest(t, handler, "prompts/get", 3, map[string]any{"name": "fix-failing-tests"}); resp.Error == nil || resp.Error.Code != -32602 {
+		t.Errorf("missing command = %+v", resp.Error)
+	}
+
+	// A tenant's saved prompt shadows the template of the same name
+	st, _ := openStore("")
+	if _, err := (promptLibrary{st}).save("", storedPrompt{Name: "code-review", Template: "Our review of {{target}}"}); err != nil {
+		t.Fatal(err)
+	}
+	handler = createMCPHandler(&sessionStore{sessions: make(map[string]*session)}, serverConfig{Store: st, PromptTemplates: builtinPromptTemplates})
+	resp = doMCPRequest(t, handler, "prompts/list", 4, nil)
+	if b, _ = json.Marshal(resp.Result); strings.Count(string(b), `"name":"code-review"`) != 1 {
+		t.Errorf("prompts/list with a saved code-review = %s", b)
+	}
+	resp = doMCPRequest(t, handler, "prompts/get", 5, map[string]any{"name": "code-review", "arguments": map[string]string{"target": "x"}})
+	if b, _ = json.Marshal(resp.Result); !strings.Contains(string(b), "Our review of x") {
+		t.Errorf("prompts/get with a saved code-review = %s", b)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"opencode-mcp/internal/runner"
+)
+
+// promptTemplate is a prompt that expands into an opencode_run call: the
+// placeholders in the string values of Run are filled from the prompts/get
+// arguments. The built-in templates can be replaced and extended by JSON
+// files in MCP_PROMPTS_DIR; a tenant's saved prompt of the same name takes
+// precedence over both.
+type promptTemplate struct {
+	Name        string           `json:"name"`
+	Description string           `json:"description,omitempty"`
+	Arguments   []promptArgument `json:"arguments,omitempty"`
+	Run         map[string]any   `json:"run"` // opencode_run arguments
+}
+
+// builtinPromptTemplates are offered unless a file replaces them.
+var builtinPromptTemplates = []promptTemplate{
+	{
+		Name:        "code-review",
+		Description: "Review files or a change without modifying anything",
+		Arguments: []promptArgument{
+			{Name: "target", Description: "Files, a directory or a git range to review", Required: true},
+			{Name: "focus", Description: "What to look for", Default: "correctness, security and readability"},
+			{Name: "cwd", Description: "Directory of the repository"},
+			{Name: "model", Description: "Model to run with (default: the server's)"},
+		},
+		Run: map[string]any{
+			"message": "Review {{target}}. Focus on {{focus}}. Report concrete issues with file and line references, most severe first, and suggest a fix for each. Do not modify any files.",
+			"agent":   "plan",
+			"cwd":     "{{cwd}}",
+			"model":   "{{model}}",
+		},
+	},
+	{
+		Name:        "fix-failing-tests",
+		Description: "Run the tests and fix the code until they pass",
+		Arguments: []promptArgument{
+			{Name: "command", Description: "Command that runs the failing tests", Required: true},
+			{Name: "cwd", Description: "Directory of the repository"},
+			{Name: "model", Description: "Model to run with (default: the server's)"},
+		},
+		Run: map[string]any{
+			"message": "Run `{{command}}` and fix the failing tests. Change the code under test rather than the tests, unless a test is wrong. Rerun `{{command}}` until it passes, then summarize what you changed and why.",
+			"cwd":     "{{cwd}}",
+			"model":   "{{model}}",
+		},
+	},
+	{
+		Name:        "explain-diff",
+		Description: "Explain what a change does and what is risky about it",
+		Arguments: []promptArgument{
+			{Name: "range", Description: "Arguments of git diff, e.g. main...HEAD", Default: "HEAD"},
+			{Name: "cwd", Description: "Directory of the repository"},
+			{Name: "model", Description: "Model to run with (default: the server's)"},
+		},
+		Run: map[string]any{
+			"message": "Explain the changes shown by `git diff {{range}}`: what they do, why they were likely made, and anything risky or surprising. Do not modify any files.",
+			"agent":   "plan",
+			"cwd":     "{{cwd}}",
+			"model":   "{{model}}",
+		},
+	},
+}
+
+// loadPromptTemplates returns the built-in templates, replaced and extended
+// by the *.json files of dir. A file's name is the template's unless it
+// sets one; without "arguments", every placeholder is a required argument.
+func loadPromptTemplates(dir string) ([]promptTemplate, error) {
+	byName := map[string]promptTemplate{}
+	for _, t := range builtinPromptTemplates {
+		byName[t.Name] = t
+	}
+	if dir != "" {
+		if _, err := os.Stat(dir); err != nil {
+			return nil, fmt.Errorf("prompt templates: %w", err)
+		}
+		paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			t, err := readPromptTemplate(path)
+			if err != nil {
+				return nil, fmt.Errorf("prompt template %s: %w", path, err)
+			}
+			byName[t.Name] = t
+		}
+	}
+	templates := make([]promptTemplate, 0, len(byName))
+	for _, t := range byName {
+		templates = append(templates, t)
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+	return templates, nil
+}
+
+func readPromptTemplate(path string) (promptTemplate, error) {
+	var t promptTemplate
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return t, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&t); err != nil {
+		return t, err
+	}
+	if t.Name == "" {
+		t.Name = strings.TrimSuffix(filepath.Base(path), ".json")
+	}
+	if !promptNameRe.MatchString(t.Name) {
+		return t, fmt.Errorf("invalid prompt name %q", t.Name)
+	}
+	if message, _ := t.Run["message"].(string); strings.TrimSpace(message) == "" {
+		return t, errors.New("run.message is missing")
+	}
+	if len(t.Arguments) == 0 {
+		for _, name := range runPlaceholders(t.Run, nil) {
+			t.Arguments = append(t.Arguments, promptArgument{Name: name, Required: true})
+		}
+	}
+	return t, nil
+}
+
+// runPlaceholders appends the placeholder names in the strings of v.
+func runPlaceholders(v any, names []string) []string {
+	switch v := v.(type) {
+	case string:
+		for _, name := range promptPlaceholders(v) {
+			names = appendUnique(names, name)
+		}
+	case []any:
+		for _, e := range v {
+			names = runPlaceholders(e, names)
+		}
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			names = runPlaceholders(v[k], names)
+		}
+	}
+	return names
+}
+
+// render fills the placeholders of the template's run arguments. Strings
+// left empty, like the cwd of a call that gave none, are dropped.
+func (t promptTemplate) render(args map[string]string) (map[string]any, error) {
+	values, err := promptValues(t.Arguments, args)
+	if err != nil {
+		return nil, err
+	}
+	run, _ := fillPlaceholders(t.Run, values).(map[string]any)
+	return run, nil
+}
+
+func fillPlaceholders(v any, values map[string]string) any {
+	switch v := v.(type) {
+	case string:
+		return promptPlaceholderRe.ReplaceAllStringFunc(v, func(m string) string {
+			return values[promptPlaceholderRe.FindStringSubmatch(m)[1]]
+		})
+	case []any:
+		out := make([]any, 0, len(v))
+		for _, e := range v {
+			if e = fillPlaceholders(e, values); e != "" {
+				out = append(out, e)
+			}
+		}
+		return out
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, e := range v {
+			if e = fillPlaceholders(e, values); e != "" {
+				out[k] = e
+			}
+		}
+		return out
+	}
+	return v
+}
+
+// runPromptMessage is the prompts/get message of a rendered template: it
+// has the model make the opencode_run call.
+func runPromptMessage(run map[string]any) string {
+	b, _ := json.MarshalIndent(run, "", "  ")
+	return "Call the " + toolRun + " tool with these arguments:\n\n" + strings.TrimSpace(runner.MarkdownFence(string(b), "json"))
+}
+
+// promptTemplateByName looks name up in cfg's templates.
+func promptTemplateByName(cfg serverConfig, name string) (promptTemplate, bool) {
+	for _, t := range cfg.PromptTemplates {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return promptTemplate{}, false
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// An opencode_run "files" entry may be an http(s) URL, e.g. a gist or a raw
+// file to analyze. The server fetches it and attaches the local copy, but
+// only from hosts in MCP_ATTACH_ALLOW_HOSTS (and the tenant's egress
+// allowlist, if it has one), redirects included. The content must be text
+// or an image and at most MCP_ATTACH_MAX_BYTES. Each run fetches a URL
+// once, however often it is listed, and the copies are removed when the run
+// ends.
+const (
+	defaultAttachMaxBytes = 1 << 20
+	attachFetchTimeout    = 30 * time.Second
+	attachMaxRedirects    = 5
+)
+
+// attachConfig governs URL attachments; without hosts, none are fetched.
+type attachConfig struct {
+	Hosts     egressPolicy
+	MaxBytes  int
+	Transport http.RoundTripper // nil uses http.DefaultTransport
+}
+
+// parseAttachHosts parses MCP_ATTACH_ALLOW_HOSTS, a comma-separated list of
+// host names and *.domain patterns.
+func parseAttachHosts(spec string) ([]string, error) {
+	var hosts []string
+	for _, h := range strings.Split(spec, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, nil
+	}
+	p := egressPolicy{AllowHosts: hosts}
+	if err := p.validate(); err != nil {
+		return nil, fmt.Errorf("MCP_ATTACH_ALLOW_HOSTS: %v", err)
+	}
+	return hosts, nil
+}
+
+// isRemoteFile reports whether a files entry is a URL rather than a path.
+func isRemoteFile(f string) bool {
+	return strings.HasPrefix(f, "https://") || strings.HasPrefix(f, "http://")
+}
+
+var errHostNotAllowed = errors.New("host not allowed")
+
+// allows checks that URL attachments may come from u, for a tenant with
+// the given egress policy.
+func (c attachConfig) allows(u *url.URL, tenant *egressPolicy) error {
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return fmt.Errorf("%w: %s is not http or https", errHostNotAllowed, u.Scheme)
+	}
+	host := u.Hostname()
+	if len(c.Hosts.AllowHosts) == 0 || !c.Hosts.allows(host) {
+		return fmt.Errorf("%w: %s is not in MCP_ATTACH_ALLOW_HOSTS", errHostNotAllowed, host)
+	}
+	if tenant != nil && !tenant.allows(host) {
+		return fmt.Errorf("%w: %s is not in the tenant's egress allowHosts", errHostNotAllowed, host)
+	}
+	return nil
+}
+
+// attachTypes are the content types fetched attachments may have, besides
+// text/*.
+var attachTypes = map[string]bool{
+	"application/json":       true,
+	"application/xml":        true,
+	"application/javascript": true,
+	"application/x-yaml":     true,
+	"application/yaml":       true,
+	"application/toml":       true,
+	"application/x-sh":       true,
+	"image/png":              true,
+	"image/jpeg":             true,
+	"image/gif":              true,
+	"image/webp":             true,
+}
+
+// checkAttachmentType checks that data, declared as contentType, is text
+// or an image it looks like, returning its media type.
+func checkAttachmentType(contentType string, data []byte) (string, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	sniffed, binary := sniffBinary(data, false)
+	if mediaType == "" || mediaType == "application/octet-stream" {
+		mediaType = sniffed
+		if !binary {
+			mediaType = "text/plain"
+		}
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "image/"):
+		if !attachTypes[mediaType] || sniffed != mediaType {
+			return "", fmt.Errorf("content is not a %s image", mediaType)
+		}
+	case strings.HasPrefix(mediaType, "text/"), attachTypes[mediaType],
+		strings.HasSuffix(mediaType, "+json"), strings.HasSuffix(mediaType, "+xml"):
+		if binary {
+			return "", fmt.Errorf("%s content is binary", mediaType)
+		}
+	default:
+		return "", fmt.Errorf("content type %s is not text or an image", mediaType)
+	}
+	return mediaType, nil
+}
+
+var unsafeNameRe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// attachmentName is the file name of the copy of u, keeping the name in
+// its path so opencode and the model see e.g. main.go.
+func attachmentName(u *url.URL, mediaType string) string {
+	name := unsafeNameRe.ReplaceAllString(path.Base(u.Path), "_")
+	if name == "" || name == "." || name == "_" || strings.Trim(name, ".") == "" {
+		name = "attachment"
+	}
+	if path.Ext(name) == "" {
+		if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+			name += exts[0]
+		}
+	}
+	return name
+}
+
+// fetch downloads rawURL into its own directory under dir, returning the
+// path of the copy.
+func (c attachConfig) fetch(ctx context.Context, rawURL, dir string, tenant *egressPolicy) (string, *mcpError) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return "", errInvalidArguments.err(fmt.Sprintf("invalid attachment URL %q", rawURL))
+	}
+	if err := c.allows(u, tenant); err != nil {
+		return "", errAttachmentDenied.err(fmt.Sprintf("%s: %v", rawURL, err))
+	}
+	client := &http.Client{
+		Transport: c.Transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= attachMaxRedirects {
+				return fmt.Errorf("more than %d redirects", attachMaxRedirects)
+			}
+			return c.allows(req.URL, tenant)
+		},
+	}
+	ctx, cancel := context.WithTimeout(ctx, attachFetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", errInvalidArguments.err(fmt.Sprintf("invalid attachment URL %q", rawURL))
+	}
+	req.Header.Set("Accept", "text/*, application/json, image/*;q=0.9, */*;q=0.1")
+	resp, err := client.Do(req)
+	if err != nil {
+		if errors.Is(err, errHostNotAllowed) {
+			return "", errAttachmentDenied.err(fmt.Sprintf("%s redirects elsewhere: %v", rawURL, err))
+		}
+		return "", errAttachmentRejected.err(fmt.Sprintf("fetch %s: %v", rawURL, err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errAttachmentRejected.err(fmt.Sprintf("fetch %s: %s", rawURL, resp.Status))
+	}
+	limit := int64(c.MaxBytes)
+	if limit > 0 && resp.ContentLength > limit {
+		return "", errAttachmentRejected.err(fmt.Sprintf("%s is %d bytes, over MCP_ATTACH_MAX_BYTES (%d)", rawURL, resp.ContentLength, limit))
+	}
+	body := io.Reader(resp.Body)
+	if limit > 0 {
+		body = io.LimitReader(resp.Body, limit+1)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return "", errAttachmentRejected.err(fmt.Sprintf("fetch %s: %v", rawURL, err))
+	}
+	if limit > 0 && int64(len(data)) > limit {
+		return "", errAttachmentRejected.err(fmt.Sprintf("%s is over MCP_ATTACH_MAX_BYTES (%d)", rawURL, limit))
+	}
+	mediaType, err := checkAttachmentType(resp.Header.Get("Content-Type"), data)
+	if err != nil {
+		return "", errAttachmentRejected.err(fmt.Sprintf("%s: %v", rawURL, err))
+	}
+
+	sub, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		return "", errInternal.err(err.Error())
+	}
+	file := filepath.Join(sub, attachmentName(resp.Request.URL, mediaType))
+	if err := os.WriteFile(file, data, 0o600); err != nil {
+		return "", errInternal.err(err.Error())
+	}
+	log.Printf("[attach] fetched %s: %d bytes of %s", rawURL, len(data), mediaType)
+	return file, nil
+}
+
+// remoteFilesMiddleware replaces the URLs among the files of opencode_run
+// with local copies for the length of the run.
+func remoteFilesMiddleware(cfg serverConfig) toolMiddleware {
+	return func(next toolHandler) toolHandler {
+		return func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+			if call.Name != toolRun {
+				return next(ctx, call)
+			}
+			var args map[string]any
+			if json.Unmarshal(call.Arguments, &args) != nil {
+				return next(ctx, call)
+			}
+			files, _ := args["files"].([]any)
+			var remote []int
+			for i, f := range files {
+				if s, ok := f.(string); ok && isRemoteFile(s) {
+					remote = append(remote, i)
+				}
+			}
+			if len(remote) == 0 {
+				return next(ctx, call)
+			}
+
+			dir, err := os.MkdirTemp("", "opencode-attach-")
+			if err != nil {
+				return nil, errInternal.err(err.Error())
+			}
+			defer os.RemoveAll(dir)
+			egress := cfg.forTenant(call.Tenant).Policy.Egress
+			fetched := map[string]string{} // URL -> copy
+			for _, i := range remote {
+				rawURL := files[i].(string)
+				file, ok := fetched[rawURL]
+				if !ok {
+					var mErr *mcpError
+					if file, mErr = cfg.Attach.fetch(ctx, rawURL, dir, egress); mErr != nil {
+						return nil, mErr
+					}
+					fetched[rawURL] = file
+				}
+				files[i] = file
+			}
+			args["files"] = files
+			call.Arguments, _ = json.Marshal(args)
+			return next(ctx, call)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCheckAttachmentType(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	for _, c := range []struct {
+		contentType string
+		data        []byte
+		want        string // "" if rejected
+	}{
+		{"text/plain; charset=utf-8", []byte("package main"), "text/plain"},
+		{"application/json", []byte(`{"a":1}`), "application/json"},
+		{"application/vnd.api+json", []byte(`{}`), "application/vnd.api+json"},
+		{"", []byte("hello"), "text/plain"},
+		{"image/png", png, "image/png"},
+		{"application/octet-stream", png, "image/png"},
+		{"image/png", []byte("not a png"), ""},
+		{"text/plain", png, ""},
+		{"application/zip", []byte("PK\x03\x04"), ""},
+	} {
+		got, err := checkAttachmentType(c.contentType, c.data)
+		if got != c.want || (err == nil) != (c.want != "") {
+			t.Errorf("checkAttachmentType(%q) = %q, %v; want %q", c.contentType, got, err, c.want)
+		}
+	}
+}
+
+// Test URL files are fetched once from allowed hosts and attached as local
+// copies that are removed after the run
+func TestRemoteFiles(t *testing.T) {
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		switch r.URL.Path {
+		case "/raw/main.go":
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Write([]byte("package main"))
+		case "/big.txt":
+			w.Write([]byte(strings.Repeat("x", 2048)))
+		case "/archive":
+			w.Header().Set("Content-Type", "application/zip")
+			w.Write([]byte("PK\x03\x04"))
+		case "/elsewhere":
+			http.Redirect(w, r, "http://"+strings.Replace(r.Host, "127.0.0.1", "localhost", 1)+"/raw/main.go", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	cfg := serverConfig{Attach: attachConfig{Hosts: egressPolicy{AllowHosts: []string{"127.0.0.1"}}, MaxBytes: 1024}}
+	var seen []string
+	h := chainTools(func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+		var args runToolArgs
+		json.Unmarshal(call.Arguments, &args)
+		seen = args.Files
+		for _, f := range args.Files[1:] {
+			if _, err := os.Stat(f); err != nil {
+				t.Errorf("attachment %s: %v", f, err)
+			}
+		}
+		return &toolCallResult{}, nil
+	}, remoteFilesMiddleware(cfg))
+	run := func(files ...string) *mcpError {
+		args, _ := json.Marshal(map[string]any{"message": "x", "files": files})
+		_, mErr := h(context.Background(), &toolCall{Name: toolRun, Arguments: args})
+		return mErr
+	}
+
+	if mErr := run("local.go", srv.URL+"/raw/main.go", srv.URL+"/raw/main.go"); mErr != nil {
+		t.Fatal(mErr)
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("fetched %d times", n)
+	}
+	if len(seen) != 3 || seen[0] != "local.go" || seen[1] != seen[2] || filepath.Base(seen[1]) != "main.go" {
+		t.Fatalf("files = %v", seen)
+	}
+	if _, err := os.Stat(seen[1]); !os.IsNotExist(err) {
+		t.Errorf("copy left after the run: %v", err)
+	}
+
+	for _, c := range []struct {
+		path string
+		code errorCode
+	}{
+		{"/big.txt", errAttachmentRejected},
+		{"/archive", errAttachmentRejected},
+		{"/missing", errAttachmentRejected},
+		{"/elsewhere", errAttachmentDenied},
+	} {
+		if mErr := run(srv.URL + c.path); mErr == nil || mErr.Data.Code != c.code.Code {
+			t.Errorf("%s: %+v", c.path, mErr)
+		}
+	}
+	denied := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1) + "/raw/main.go"
+	if mErr := run(denied); mErr == nil || mErr.Data.Code != errAttachmentDenied.Code {
+		t.Errorf("host not allowed: %+v", mErr)
+	}
+	cfg.Tenants = map[string]tenantPolicy{"team-a": {Egress: &egressPolicy{AllowHosts: []string{"api.openai.com"}}}}
+	h = chainTools(func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+		return &toolCallResult{}, nil
+	}, remoteFilesMiddleware(cfg))
+	args, _ := json.Marshal(map[string]any{"message": "x", "files": []string{srv.URL + "/raw/main.go"}})
+	if _, mErr := h(context.Background(), &toolCall{Name: toolRun, Tenant: "team-a", Arguments: args}); mErr == nil || mErr.Data.Code != errAttachmentDenied.Code {
+		t.Errorf("host outside the tenant's egress policy: %+v", mErr)
+	}
+	if mErr := run(srv.URL + "/raw/main.go"); mErr != nil {
+		t.Errorf("another tenant's egress policy applied: %+v", mErr)
+	}
+}
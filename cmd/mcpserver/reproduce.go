@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+)
+
+// reproduction is the exact CLI invocation behind a tool call, ready to
+// paste: as a shell command, and as a curl against /exec. Environment
+// settings (proxies, artifact dir) are left out, since they may carry
+// credentials.
+type reproduction struct {
+	Shell string `json:"shell"`
+	Curl  string `json:"curl"`
+}
+
+// newReproduction describes running target with args in cwd for tenant.
+func newReproduction(cfg serverConfig, tenant string, args []string, cwd, stdin string) *reproduction {
+	var sh strings.Builder
+	if cwd != "" {
+		sh.WriteString("cd " + shellQuote(cwd) + " && ")
+	}
+	input := execArgs{Args: args, Cwd: cwd, Stdin: stdin}
+	if !utf8.ValidString(stdin) {
+		input.Stdin, input.StdinBase64 = "", base64.StdEncoding.EncodeToString([]byte(stdin))
+	}
+	switch {
+	case input.StdinBase64 != "":
+		sh.WriteString("printf '%s' " + input.StdinBase64 + " | base64 -d | ")
+	case stdin != "":
+		sh.WriteString("printf '%s' " + shellQuote(stdin) + " | ")
+	}
+	sh.WriteString(shellQuote(cfg.Target))
+	for _, a := range args {
+		sh.WriteString(" " + shellQuote(a))
+	}
+
+	body, _ := json.Marshal(input)
+	curl := "curl -sS -X POST " + shellQuote(strings.TrimRight(cfg.Artifacts.PublicURL, "/")+"/exec")
+	switch {
+	case tenant == "" || tenant == defaultTenant:
+	case cfg.TrustTenant:
+		curl += " -H " + shellQuote(tenantHeader+": "+tenant)
+	default:
+		// The tenant's key isn't known here, so the caller supplies it
+		curl += ` -H "X-API-Key: $MCP_API_KEY"`
+	}
+	curl += " -H 'Content-Type: application/json' -d " + shellQuote(string(body))
+	return &reproduction{Shell: sh.String(), Curl: curl}
+}
+
+// shellQuote quotes s for POSIX shells, leaving plain words bare.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./:=@,+%") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
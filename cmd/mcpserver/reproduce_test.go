@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test the reproduction commands rerun the exact invocation
+func TestReproduction(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "open code")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\npwd\nfor a; do echo \"<$a>\"; done\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	cfg := serverConfig{Target: script, DefaultTimeout: 5 * time.Second, Artifacts: artifactConfig{PublicURL: "http://mcp.example/"}}
+	tools := newToolHandler(cfg)
+	msg := "fix the \"bug\" in it's\nparser; rm -rf $HOME"
+	args, _ := json.Marshal(map[string]any{"args": []string{"run", "--model", "a/b", msg}, "cwd": dir})
+	result, mErr := tools(context.Background(), &toolCall{ID: json.RawMessage("1"), Name: toolExec, Tenant: "team-a", Arguments: args})
+	if mErr != nil {
+		t.Fatal(mErr)
+	}
+	rep, ok := result.Meta["reproduce"].(*reproduction)
+	if !ok {
+		t.Fatalf("no reproduction in %+v", result.Meta)
+	}
+
+	out, err := exec.Command("sh", "-c", rep.Shell).Output()
+	if err != nil {
+		t.Fatalf("%s: %v", rep.Shell, err)
+	}
+	if want := strings.TrimSuffix(result.Content[0].Text, "\n"); strings.TrimSuffix(string(out), "\n") != want {
+		t.Errorf("shell command printed %q, the run %q", out, want)
+	}
+
+	out, err = exec.Command("sh", "-c", `curl() { printf '%s\n' "$@"; }; `+rep.Curl).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+	var body execArgs
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &body); err != nil || len(body.Args) != 4 || body.Args[3] != msg || body.Cwd != dir {
+		t.Errorf("curl body = %+v (%v)", body, err)
+	}
+	if !strings.Contains(string(out), "http://mcp.example/exec\n") || !strings.Contains(rep.Curl, `-H "X-API-Key: $MCP_API_KEY"`) {
+		t.Errorf("curl = %s", out)
+	}
+	cfg.TrustTenant = true
+	if rep := newReproduction(cfg, "team-a", []string{"models"}, "", ""); !strings.Contains(rep.Curl, "'X-MCP-Tenant: team-a'") {
+		t.Errorf("curl with a trusted tenant header = %s", rep.Curl)
+	}
+
+	for s, want := range map[string]string{"run": "run", "a/b": "a/b", "": "''", "it's": `'it'\''s'`, "$x": "'$x'"} {
+		if got := shellQuote(s); got != want {
+			t.Errorf("shellQuote(%q) = %s, want %s", s, got, want)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"opencode-mcp/internal/mcp"
+)
+
+// The opencode sessions of the tenant's recorded runs are offered as
+// resources (opencode://session/<id>), so clients can pull a prior
+// conversation into context without a tool call. Reading one renders the
+// transcripts of its runs, oldest first, ending with those still running.
+// Like the run history this needs the store, and only covers runs made
+// through this server.
+
+// sessionRuns groups the tenant's recorded runs by opencode session, each
+// oldest first, with the sessions ordered by their latest run. archived is
+// the runFilter's.
+func sessionRuns(h runHistory, tenant, archived string) ([]string, map[string][]runRecord, error) {
+	runs, err := h.query(tenant, runFilter{Archived: archived, Limit: -1})
+	if err != nil {
+		return nil, nil, err
+	}
+	var order []string
+	bySession := map[string][]runRecord{}
+	for _, rec := range runs { // newest first
+		if rec.Session == "" {
+			continue
+		}
+		if _, ok := bySession[rec.Session]; !ok {
+			order = append(order, rec.Session)
+		}
+		bySession[rec.Session] = append([]runRecord{rec}, bySession[rec.Session]...)
+	}
+	return order, bySession, nil
+}
+
+// handleResourcesList answers resources/list with the server resources and
+// the tenant's sessions that aren't archived.
+func handleResourcesList(_ context.Context, cfg serverConfig, req *mcpRequest) (any, *mcpError) {
+	order, bySession, err := sessionRuns(runHistory{cfg.Store}, req.Tenant, "")
+	if err != nil && !errors.Is(err, errNoStore) {
+		return nil, rpcError(-32603, err.Error())
+	}
+	resources := append(make([]mcp.Resource, 0, len(serverResources)+len(order)), serverResources...)
+	for _, id := range order {
+		runs := bySession[id]
+		last := runs[len(runs)-1]
+		desc := fmt.Sprintf("Last run %s", localTime(last.StartedAt).Format(time.RFC3339))
+		if last.Cwd != "" {
+			desc += " in " + last.Cwd
+		}
+		if len(runs) > 1 {
+			desc += fmt.Sprintf(" (%d runs)", len(runs))
+		}
+		resources = append(resources, mcp.Resource{
+			URI:         mcp.SessionURI(id),
+			Name:        id,
+			Title:       truncateForLog(strings.Join(strings.Fields(runs[0].Message), " "), 80),
+			Description: desc,
+			MimeType:    mcp.TranscriptMimeType,
+		})
+	}
+	page, next, mErr := mcp.Paginate(req.Params, resources, mcp.PageSize)
+	if mErr != nil {
+		return nil, errInvalidArguments.err(mErr.Message)
+	}
+	return mcp.ListResult("resources", page, next), nil
+}
+
+// handleResourcesRead answers resources/read with a server resource or a
+// session's transcript.
+func handleResourcesRead(_ context.Context, cfg serverConfig, req *mcpRequest) (any, *mcpError) {
+	var params struct {
+		URI string `json:"uri"`
+	}
+	if err := json.Unmarshal(req.Params, &params); err != nil || params.URI == "" {
+		return nil, rpcError(-32602, "invalid params")
+	}
+	if contents, ok, err := readServerResource(cfg, req.Tenant, params.URI); ok {
+		if err != nil {
+			return nil, rpcError(-32603, err.Error())
+		}
+		return map[string]any{"contents": []mcp.ResourceContents{contents}}, nil
+	}
+	h := runHistory{cfg.Store}
+	_, bySession, err := sessionRuns(h, req.Tenant, archivedInclude)
+	if err != nil && !errors.Is(err, errNoStore) {
+		return nil, rpcError(-32603, err.Error())
+	}
+	id, ok := mcp.SessionID(params.URI)
+	runs := bySession[id]
+	live := cfg.Transcripts.running(req.Tenant, id)
+	if !ok || len(runs)+len(live) == 0 {
+		return nil, rpcError(mcp.CodeResourceNotFound, fmt.Sprintf("resource not found: %s", params.URI))
+	}
+	parts := make([]string, 0, len(runs)+len(live))
+	recorded := map[string]bool{}
+	for _, rec := range runs {
+		recorded[rec.ID] = true
+		t, _, err := h.transcript(req.Tenant, rec.ID)
+		if err != nil {
+			return nil, rpcError(-32603, err.Error())
+		}
+		parts = append(parts, renderTranscript(rec, t))
+	}
+	for _, lr := range live {
+		if !recorded[lr.rec.ID] { // recorded as it finished
+			parts = append(parts, renderTranscript(lr.rec, lr.transcript))
+		}
+	}
+	return map[string]any{"contents": []mcp.ResourceContents{{
+		URI:      params.URI,
+		MimeType: mcp.TranscriptMimeType,
+		Text:     strings.Join(parts, "\n"),
+	}}}, nil
+}
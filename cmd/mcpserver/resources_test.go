@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"opencode-mcp/internal/mcp"
+)
+
+// Test the opencode sessions of a tenant's runs are listed and read as resources
+func TestSessionResources(t *testing.T) {
+	st, _ := openStore("")
+	h := runHistory{st}
+	now := time.Now()
+	for i, rec := range []runRecord{
+		{ID: "r1", Tenant: "team-a", Session: "ses_a", Message: "plan the change", Cwd: "/src", StartedAt: now.Add(-3 * time.Hour)},
+		{ID: "r2", Tenant: "team-a", Session: "ses_b", Message: "other work", StartedAt: now.Add(-2 * time.Hour)},
+		{ID: "r3", Tenant: "team-a", Session: "ses_a", Message: "now implement it", Cwd: "/src", StartedAt: now.Add(-time.Hour)},
+		{ID: "r4", Tenant: "team-a", Message: "no session", StartedAt: now},
+		{ID: "r5", Tenant: "team-b", Session: "ses_c", Message: "not yours", StartedAt: now},
+	} {
+		if err := h.record(rec); err != nil {
+			t.Fatal(err)
+		}
+		if i == 2 {
+			_ = h.saveTranscript("team-a", "r3", runTranscript{Prompt: rec.Message, Entries: []transcriptEntry{{Type: "text", Text: "Implemented."}}})
+		}
+	}
+	srv := httptest.NewServer(newMCPHandler(&sessionStore{sessions: make(map[string]*session)}, serverConfig{Store: st}))
+	defer srv.Close()
+	call := func(method, params string, result any) *mcpError {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"`+method+`","params":`+params+`}`))
+		req.Header.Set(tenantHeader, "team-a")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var out struct {
+			Result json.RawMessage `json:"result"`
+			Error  *mcpError       `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			t.Fatal(err)
+		}
+		if out.Error == nil {
+			_ = json.Unmarshal(out.Result, result)
+		}
+		return out.Error
+	}
+
+	var list struct {
+		Resources []mcp.Resource `json:"resources"`
+	}
+	if err := call("resources/list", `{}`, &list); err != nil {
+		t.Fatal(err)
+	}
+	sessions := list.Resources[len(serverResources):]
+	if len(sessions) != 2 || sessions[0].URI != "opencode://session/ses_a" || sessions[1].Name != "ses_b" {
+		t.Fatalf("resources = %+v", list.Resources)
+	}
+	if r := sessions[0]; r.Title != "plan the change" || !strings.HasSuffix(r.Description, "in /src (2 runs)") || r.MimeType != "text/markdown" {
+		t.Errorf("resource = %+v", r)
+	}
+
+	var read struct {
+		Contents []mcp.ResourceContents `json:"contents"`
+	}
+	if err := call("resources/read", `{"uri":"opencode://session/ses_a"}`, &read); err != nil {
+		t.Fatal(err)
+	}
+	text := read.Contents[0].Text
+	if first, second := strings.Index(text, "# opencode run r1"), strings.Index(text, "# opencode run r3"); first < 0 || second < first || !strings.Contains(text, "Implemented.") {
+		t.Errorf("transcript:\n%s", text)
+	}
+	for _, uri := range []string{"opencode://session/ses_c", "opencode://session/nope", "file:///etc/passwd"} {
+		if err := call("resources/read", `{"uri":"`+uri+`"}`, &read); err == nil || err.Code != mcp.CodeResourceNotFound {
+			t.Errorf("read %s: %+v", uri, err)
+		}
+	}
+}
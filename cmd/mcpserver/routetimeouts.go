@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Routes differ too much for one server-wide timeout: a health check should
+// answer in milliseconds, an opencode_run takes minutes, and a session
+// stream stays open for hours. Each class of routes gets its own deadline
+// for reading the request body and for the handler, and requests slower
+// than the class's threshold are logged with where the time went.
+const (
+	routeHealth  = "health"  // /health, /readyz, /status
+	routeMCP     = "mcp"     // POST and DELETE /mcp, /mcp/poll
+	routeExec    = "exec"    // /exec
+	routeStream  = "stream"  // GET /mcp, /exec/stream
+	routeDefault = "default" // everything else
+
+	// handlerGrace is how long a handler may keep writing after its
+	// context is done before the connection is cut.
+	handlerGrace = 5 * time.Second
+)
+
+var routeClasses = []string{routeHealth, routeMCP, routeExec, routeStream, routeDefault}
+
+// routeTimeout bounds the requests of a class of routes; 0 disables a bound.
+type routeTimeout struct {
+	Read    time.Duration `json:"read"`    // to read the request body
+	Handler time.Duration `json:"handler"` // to answer
+	Slow    time.Duration `json:"slow"`    // logged when slower
+}
+
+// routeTimeouts are the timeouts of each class of routes (MCP_ROUTE_TIMEOUTS).
+type routeTimeouts map[string]routeTimeout
+
+// defaultRouteTimeouts are the timeouts for runs of up to runTimeout.
+func defaultRouteTimeouts(runTimeout time.Duration) routeTimeouts {
+	return routeTimeouts{
+		routeHealth:  {Read: 5 * time.Second, Handler: 10 * time.Second, Slow: time.Second},
+		routeMCP:     {Read: 30 * time.Second, Handler: runTimeout + time.Minute, Slow: 2 * time.Minute},
+		routeExec:    {Read: 30 * time.Second, Handler: runTimeout + time.Minute, Slow: 2 * time.Minute},
+		routeStream:  {Read: 30 * time.Second},
+		routeDefault: {Read: 15 * time.Second, Handler: time.Minute, Slow: 5 * time.Second},
+	}
+}
+
+// parseRouteTimeouts overrides the default timeouts with spec, a list of
+// class=read/handler[/slow] such as "health=2s/5s,stream=10s/0".
+func parseRouteTimeouts(spec string, runTimeout time.Duration) (routeTimeouts, error) {
+	rt := defaultRouteTimeouts(runTimeout)
+	for _, kv := range strings.Split(spec, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		class, value, _ := strings.Cut(kv, "=")
+		if _, ok := rt[class]; !ok {
+			return nil, fmt.Errorf("MCP_ROUTE_TIMEOUTS: unknown route class %q (want %s)", class, strings.Join(routeClasses, ", "))
+		}
+		parts := strings.Split(value, "/")
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil, fmt.Errorf("MCP_ROUTE_TIMEOUTS %s: %q is not read/handler[/slow]", class, value)
+		}
+		var d [3]time.Duration
+		for i, p := range parts {
+			var err error
+			if d[i], err = time.ParseDuration(p); err != nil || d[i] < 0 {
+				return nil, fmt.Errorf("MCP_ROUTE_TIMEOUTS %s: %q is not a duration like 30s", class, p)
+			}
+		}
+		t := routeTimeout{Read: d[0], Handler: d[1], Slow: rt[class].Slow}
+		if len(parts) == 3 {
+			t.Slow = d[2]
+		}
+		rt[class] = t
+	}
+	return rt, nil
+}
+
+func (rt routeTimeouts) String() string {
+	var classes []string
+	for class := range rt {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	var b strings.Builder
+	for i, class := range classes {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		t := rt[class]
+		fmt.Fprintf(&b, "%s=%s/%s/%s", class, t.Read, t.Handler, t.Slow)
+	}
+	return b.String()
+}
+
+// routeClass returns the class of the route r is for.
+func routeClass(r *http.Request) string {
+	switch path := r.URL.Path; {
+	case path == "/health" || path == "/readyz" || path == "/status":
+		return routeHealth
+	case path == "/mcp" && r.Method == http.MethodGet, path == "/exec/stream":
+		return routeStream
+	case path == "/mcp" || path == pollPath:
+		return routeMCP
+	case path == "/exec":
+		return routeExec
+	}
+	return routeDefault
+}
+
+// wrap enforces the timeouts of each request's route class on h. The body
+// read deadline is lifted once the body is read, as the connection's
+// background read would otherwise cancel a long handler. The handler's
+// context ends at its timeout, and its connection is cut handlerGrace later
+// if it is still writing.
+func (rt routeTimeouts) wrap(h http.Handler) http.Handler {
+	if rt == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		class := routeClass(r)
+		t := rt[class]
+		tw := &timedWriter{ResponseWriter: w, start: time.Now()}
+		rc := http.NewResponseController(w)
+		if t.Read > 0 && r.Body != nil && r.Body != http.NoBody {
+			_ = rc.SetReadDeadline(tw.start.Add(t.Read))
+			r.Body = &timedBody{ReadCloser: r.Body, w: tw, rc: rc}
+		}
+		if t.Handler > 0 {
+			ctx, cancel := context.WithTimeout(r.Context(), t.Handler)
+			defer cancel()
+			r = r.WithContext(ctx)
+			_ = rc.SetWriteDeadline(tw.start.Add(t.Handler + handlerGrace))
+			defer func() { _ = rc.SetWriteDeadline(time.Time{}) }()
+		}
+
+		h.ServeHTTP(tw, r)
+
+		total := time.Since(tw.start)
+		switch {
+		case t.Handler > 0 && errors.Is(r.Context().Err(), context.DeadlineExceeded):
+			log.Printf("[http] %s %s (%s) hit its %s handler timeout: %s", r.Method, r.URL.Path, class, t.Handler, tw.phases(total))
+		case tw.bodyTimedOut:
+			log.Printf("[http] %s %s (%s) body not read within %s: %s", r.Method, r.URL.Path, class, t.Read, tw.phases(total))
+		case t.Slow > 0 && total > t.Slow:
+			log.Printf("[http] slow %s %s (%s) %d: %s", r.Method, r.URL.Path, class, tw.status, tw.phases(total))
+		}
+	})
+}
+
+// timedWriter records when a response starts, for the phase breakdown of
+// slow requests.
+type timedWriter struct {
+	http.ResponseWriter
+	start     time.Time
+	bodyRead  time.Duration // when the body was read, if it was
+	firstByte time.Duration // when the response started
+	status    int
+
+	bodyTimedOut bool
+}
+
+func (w *timedWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+		w.firstByte = time.Since(w.start)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *timedWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+		w.firstByte = time.Since(w.start)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *timedWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *timedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// phases describes where the total time of a request went: reading its
+// body, the handler working until the response started, and writing it.
+func (w *timedWriter) phases(total time.Duration) string {
+	round := func(d time.Duration) time.Duration { return max(d, 0).Round(time.Millisecond) }
+	read := w.bodyRead
+	first := w.firstByte
+	if first == 0 {
+		first = total
+	}
+	return fmt.Sprintf("total %s, read %s, handler %s, write %s", round(total), round(read), round(first-read), round(total-first))
+}
+
+// timedBody lifts the read deadline once the body is read, and records
+// when that was.
+type timedBody struct {
+	io.ReadCloser
+	w    *timedWriter
+	rc   *http.ResponseController
+	done bool
+}
+
+func (b *timedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && !b.done {
+		b.done = true
+		b.w.bodyRead = time.Since(b.w.start)
+		_ = b.rc.SetReadDeadline(time.Time{})
+		b.w.bodyTimedOut = isTimeout(err)
+	}
+	return n, err
+}
+
+// isTimeout reports whether err is a deadline being exceeded.
+func isTimeout(err error) bool {
+	var te interface{ Timeout() bool }
+	return errors.As(err, &te) && te.Timeout()
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRouteTimeouts(t *testing.T) {
+	rt, err := parseRouteTimeouts("health=2s/5s, stream=10s/0/0,exec=1m/10m/30s", 2*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]routeTimeout{
+		routeHealth: {Read: 2 * time.Second, Handler: 5 * time.Second, Slow: time.Second},
+		routeStream: {Read: 10 * time.Second},
+		routeExec:   {Read: time.Minute, Handler: 10 * time.Minute, Slow: 30 * time.Second},
+		routeMCP:    {Read: 30 * time.Second, Handler: 3 * time.Minute, Slow: 2 * time.Minute},
+	}
+	for class, w := range want {
+		if rt[class] != w {
+			t.Errorf("%s = %+v, want %+v", class, rt[class], w)
+		}
+	}
+	for _, spec := range []string{"nope=1s/1s", "mcp=1s", "mcp=1s/2s/3s/4s", "mcp=1s/soon", "mcp=-1s/1s"} {
+		if _, err := parseRouteTimeouts(spec, time.Minute); err == nil {
+			t.Errorf("%q accepted", spec)
+		}
+	}
+}
+
+func TestRouteClass(t *testing.T) {
+	tests := []struct{ method, path, class string }{
+		{"GET", "/health", routeHealth},
+		{"GET", "/status", routeHealth},
+		{"POST", "/mcp", routeMCP},
+		{"DELETE", "/mcp", routeMCP},
+		{"GET", "/mcp", routeStream},
+		{"POST", pollPath, routeMCP},
+		{"POST", "/exec", routeExec},
+		{"POST", "/exec/stream", routeStream},
+		{"GET", "/runs", routeDefault},
+	}
+	for _, tt := range tests {
+		if got := routeClass(httptest.NewRequest(tt.method, tt.path, nil)); got != tt.class {
+			t.Errorf("%s %s = %s, want %s", tt.method, tt.path, got, tt.class)
+		}
+	}
+}
+
+// Test slow bodies and handlers are cut off, handlers outliving the body
+// deadline aren't, and slow requests are logged with their phases
+func TestRouteTimeouts(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/exec", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusRequestTimeout)
+			return
+		}
+		time.Sleep(300 * time.Millisecond) // past the read deadline
+		if err := r.Context().Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte("done"))
+	})
+	mux.HandleFunc("/runs", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		http.Error(w, r.Context().Err().Error(), http.StatusServiceUnavailable)
+	})
+	rt := routeTimeouts{
+		routeExec:    {Read: 100 * time.Millisecond, Handler: 5 * time.Second, Slow: 200 * time.Millisecond},
+		routeDefault: {Read: time.Second, Handler: 200 * time.Millisecond},
+	}
+	srv := httptest.NewServer(rt.wrap(mux))
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/exec", "application/json", strings.NewReader(`{"args":["x"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "done" {
+		t.Errorf("handler outliving the read deadline: %d %s", resp.StatusCode, body)
+	}
+	if !strings.Contains(logs.String(), "slow POST /exec (exec) 200: total 3") || !strings.Contains(logs.String(), ", handler 3") {
+		t.Errorf("slow request log: %s", logs.String())
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		_, _ = pw.Write([]byte(`{"args":`))
+		time.Sleep(500 * time.Millisecond)
+		_ = pw.Close()
+	}()
+	if resp, err := http.Post(srv.URL+"/exec", "application/json", pr); err == nil {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusRequestTimeout {
+			t.Errorf("slow body: %d %s", resp.StatusCode, body)
+		}
+	}
+	if !strings.Contains(logs.String(), "body not read within 100ms") {
+		t.Errorf("slow body log: %s", logs.String())
+	}
+
+	start := time.Now()
+	resp, err = http.Get(srv.URL + "/runs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || time.Since(start) > 2*time.Second {
+		t.Errorf("slow handler: %d after %s", resp.StatusCode, time.Since(start))
+	}
+	if !strings.Contains(logs.String(), "GET /runs (default) hit its 200ms handler timeout") {
+		t.Errorf("handler timeout log: %s", logs.String())
+	}
+}
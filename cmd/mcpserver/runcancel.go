@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	toolJobCancel = "opencode_job_cancel"
+
+	// finishedRunRetention is how long a finished run's result stays with
+	// the registry, so that repeating a cancel returns it again.
+	finishedRunRetention = 10 * time.Minute
+	// cancelWait bounds how long a cancel request waits for the run to
+	// stop and return its partial result.
+	cancelWait = 30 * time.Second
+)
+
+// errCancelRequested is the cause of the context of a run stopped by
+// opencode_job_cancel or POST /runs/{id}/cancel.
+var errCancelRequested = errors.New("cancelled by request")
+
+// runningRuns tracks the opencode_run executions of this instance by tenant
+// and run ID, so that a client that lost the connection of a run can still
+// stop it. Runs live in memory: behind a load balancer the cancel request
+// must reach the instance running it.
+type runningRuns struct {
+	mu   sync.Mutex
+	runs map[string]*runningRun
+}
+
+type runningRun struct {
+	id        string
+	cancel    context.CancelCauseFunc
+	done      chan struct{} // closed when the run returned
+	cancelled bool          // a cancel request stopped it
+	finished  time.Time
+	result    *toolCallResult
+	mErr      *mcpError
+}
+
+func newRunningRuns() *runningRuns {
+	return &runningRuns{runs: map[string]*runningRun{}}
+}
+
+// start registers the run id, returning the context it runs in.
+func (r *runningRuns) start(ctx context.Context, tenant, id string) (context.Context, *runningRun) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	run := &runningRun{id: id, cancel: cancel, done: make(chan struct{})}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, old := range r.runs {
+		if !old.finished.IsZero() && time.Since(old.finished) > finishedRunRetention {
+			delete(r.runs, key)
+		}
+	}
+	r.runs[runKey(tenant, id)] = run
+	return ctx, run
+}
+
+// finish records the outcome of run and releases its context.
+func (r *runningRuns) finish(run *runningRun, result *toolCallResult, mErr *mcpError) {
+	r.mu.Lock()
+	run.finished = time.Now()
+	run.result, run.mErr = result, mErr
+	r.mu.Unlock()
+	run.cancel(nil)
+	close(run.done)
+}
+
+// get returns the tenant's run id, in progress or recently finished.
+func (r *runningRuns) get(tenant, id string) *runningRun {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.runs[runKey(tenant, id)]
+}
+
+// cancelOutcome answers a cancel request.
+type cancelOutcome struct {
+	ID string `json:"id"`
+	// Status is cancelled when a cancel request stopped the run, ok or
+	// error when it had already finished, and cancelling when it didn't
+	// stop within cancelWait.
+	Status string          `json:"status"`
+	Result *toolCallResult `json:"result,omitempty"` // the run's final, possibly partial, result
+}
+
+// cancelRun stops the tenant's run id and waits for its result. Cancelling
+// a finished run is not an error: it returns the run's outcome, from the
+// registry or else the run history. ok is false for unknown runs.
+func cancelRun(ctx context.Context, cfg serverConfig, tenant, id string) (cancelOutcome, bool, error) {
+	out := cancelOutcome{ID: id}
+	run := cfg.Running.get(tenant, id)
+	if run == nil {
+		rec, ok, err := runHistory{cfg.Store}.get(tenant, id)
+		if err != nil && !errors.Is(err, errNoStore) {
+			return out, false, err
+		}
+		out.Status = rec.Status
+		return out, ok, nil
+	}
+
+	cfg.Running.mu.Lock()
+	if run.finished.IsZero() && !run.cancelled {
+		run.cancelled = true
+		log.Printf("[runs] cancelling run %s", id)
+		run.cancel(errCancelRequested)
+	}
+	cfg.Running.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, cancelWait)
+	defer cancel()
+	select {
+	case <-run.done:
+	case <-ctx.Done():
+		out.Status = "cancelling"
+		return out, true, nil
+	}
+	switch {
+	case run.cancelled:
+		out.Status = "cancelled"
+	case run.mErr != nil || run.result == nil || run.result.IsError:
+		out.Status = "error"
+	default:
+		out.Status = "ok"
+	}
+	out.Result = run.result
+	if out.Result == nil && run.mErr != nil {
+		out.Result = &toolCallResult{Content: []toolContent{{Type: "text", Text: run.mErr.Message}}, IsError: true}
+	}
+	return out, true, nil
+}
+
+// runningMiddleware registers every opencode_run with cfg.Running and
+// tells the client the run's ID, which it needs to cancel the run from
+// another connection.
+func runningMiddleware(runs *runningRuns) toolMiddleware {
+	return func(next toolHandler) toolHandler {
+		return func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+			if runs == nil || call.Name != toolRun {
+				return next(ctx, call)
+			}
+			if call.RunID == "" {
+				call.RunID = generateSessionID()
+			}
+			ctx, run := runs.start(ctx, call.Tenant, call.RunID)
+			call.Notify(map[string]any{
+				"jsonrpc": "2.0",
+				"method":  "notifications/message",
+				"params": map[string]any{
+					"level": "info",
+					"data":  map[string]any{"runId": call.RunID, "cancel": "POST /runs/" + call.RunID + "/cancel"},
+				},
+			})
+			result, mErr := next(ctx, call)
+			runs.finish(run, result, mErr)
+			return result, mErr
+		}
+	}
+}
+
+var jobCancelSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"id": map[string]any{"type": "string", "description": "Run ID, from the run's first notification or " + toolHistory},
+	},
+	"required": []string{"id"},
+}
+
+// jobCancelTool implements opencode_job_cancel.
+func jobCancelTool(ctx context.Context, cfg serverConfig, call *toolCall) (*toolCallResult, *mcpError) {
+	var args struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(call.Arguments, &args); err != nil || args.ID == "" {
+		return nil, errInvalidArguments.err("id is required")
+	}
+	out, ok, err := cancelRun(ctx, cfg, call.Tenant, args.ID)
+	if err != nil {
+		return nil, errInternal.err(err.Error())
+	}
+	if !ok {
+		return nil, errInvalidArguments.err(fmt.Sprintf("unknown run %q", args.ID))
+	}
+	content := []toolContent{{Type: "text", Text: fmt.Sprintf("run %s: %s", out.ID, out.Status)}}
+	if out.Result != nil {
+		content = append(content, out.Result.Content...)
+	}
+	return &toolCallResult{
+		Content:           content,
+		StructuredContent: map[string]any{"id": out.ID, "status": out.Status},
+	}, nil
+}
+
+// registerRunCancelRoute adds POST /runs/{id}/cancel.
+func registerRunCancelRoute(mux *http.ServeMux, cfg serverConfig) {
+	mux.HandleFunc("POST /runs/{id}/cancel", func(w http.ResponseWriter, r *http.Request) {
+		out, ok, err := cancelRun(r.Context(), cfg, tenantFromRequest(r), r.PathValue("id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "run not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, out)
+	})
+}
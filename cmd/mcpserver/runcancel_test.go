@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"opencode-mcp/internal/fakeopencode"
+)
+
+// Test POST /runs/{id}/cancel and opencode_job_cancel stop a run from
+// another connection and return its partial result, repeatedly
+func TestRunCancel(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(fakeopencode.EnvScript, fakeopencode.Script{Lines: []string{fakeopencode.Text("working")}, Hang: true}.Encode())
+	st, _ := openStore("")
+	cfg := serverConfig{Target: exe, DefaultTimeout: 20 * time.Second, DefaultModel: "fake/model", Backend: backendCLI, Store: st, Running: newRunningRuns()}
+	tools := newToolHandler(cfg)
+	mux := http.NewServeMux()
+	registerRunCancelRoute(mux, cfg)
+
+	var (
+		mu    sync.Mutex
+		runID string
+		text  bool
+	)
+	call := &toolCall{ID: json.RawMessage("1"), Name: toolRun, Tenant: "team-a", Arguments: json.RawMessage(`{"message":"hi"}`)}
+	call.notify = func(msg any) {
+		b, _ := json.Marshal(msg)
+		mu.Lock()
+		defer mu.Unlock()
+		var m struct {
+			Params struct {
+				Data struct {
+					RunID string `json:"runId"`
+				} `json:"data"`
+			} `json:"params"`
+		}
+		if json.Unmarshal(b, &m) == nil && m.Params.Data.RunID != "" {
+			runID = m.Params.Data.RunID
+		}
+		text = text || strings.Contains(string(b), "working")
+	}
+	type outcome struct {
+		result *toolCallResult
+		mErr   *mcpError
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		result, mErr := tools(context.Background(), call)
+		done <- outcome{result, mErr}
+	}()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		mu.Lock()
+		started := runID != "" && text
+		mu.Unlock()
+		if started {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("run never started")
+		}
+	}
+
+	cancel := func(tenant, id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/runs/"+id+"/cancel", nil)
+		req.Header.Set(tenantHeader, tenant)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := cancel("team-b", runID); rec.Code != http.StatusNotFound {
+		t.Errorf("other tenant's cancel = %d", rec.Code)
+	}
+	rec := cancel("team-a", runID)
+	var out cancelOutcome
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("cancel = %d %s", rec.Code, rec.Body)
+	}
+	if out.Status != "cancelled" || out.Result == nil || !out.Result.IsError || !strings.Contains(out.Result.Content[0].Text, "working") {
+		t.Errorf("cancel outcome = %+v", out)
+	}
+	select {
+	case o := <-done:
+		if o.result == nil || !o.result.IsError {
+			t.Errorf("cancelled run returned %+v, %v", o.result, o.mErr)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("run still going after cancel")
+	}
+
+	result, mErr := tools(context.Background(), &toolCall{ID: json.RawMessage("2"), Name: toolJobCancel, Tenant: "team-a", Arguments: json.RawMessage(`{"id":"` + runID + `"}`)})
+	if mErr != nil || result.StructuredContent.(map[string]any)["status"] != "cancelled" || !strings.Contains(result.Content[1].Text, "working") {
+		t.Errorf("repeated cancel = %+v, %v", result, mErr)
+	}
+	if _, mErr := tools(context.Background(), &toolCall{ID: json.RawMessage("3"), Name: toolJobCancel, Arguments: json.RawMessage(`{"id":"nope"}`)}); mErr == nil || mErr.Data.Code != errInvalidArguments.Code {
+		t.Errorf("unknown run: %v", mErr)
+	}
+
+	// Once it has left the registry, the history still knows the run
+	cfg.Running = newRunningRuns()
+	if out, ok, err := cancelRun(context.Background(), cfg, "team-a", runID); !ok || err != nil || out.Status != "error" {
+		t.Errorf("cancel of a recorded run = %+v, %v, %v", out, ok, err)
+	}
+}
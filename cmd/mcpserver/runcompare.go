@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"opencode-mcp/internal/runner"
+)
+
+const (
+	toolCompareRuns = "opencode_compare_runs"
+
+	// maxCompareAnswerLines caps the lines of each answer that are diffed;
+	// the diff is quadratic in them.
+	maxCompareAnswerLines = 1000
+)
+
+// runSide is one run of a comparison.
+type runSide struct {
+	ID           string   `json:"id"`
+	Model        string   `json:"model,omitempty"`
+	Status       string   `json:"status"`
+	Message      string   `json:"message"`
+	Answer       string   `json:"answer"`
+	Files        []string `json:"files"`
+	Cost         float64  `json:"cost"`
+	DurationMs   int64    `json:"durationMs"`
+	InputTokens  int      `json:"inputTokens"`
+	OutputTokens int      `json:"outputTokens"`
+}
+
+// runComparison is the structured result of opencode_compare_runs. Deltas
+// are b minus a.
+type runComparison struct {
+	A          runSide `json:"a"`
+	B          runSide `json:"b"`
+	SameAnswer bool    `json:"sameAnswer"`
+	AnswerDiff string  `json:"answerDiff"` // line diff of the answers; empty when they are the same
+	Files      struct {
+		Both  []string `json:"both"`
+		OnlyA []string `json:"onlyA"`
+		OnlyB []string `json:"onlyB"`
+	} `json:"files"`
+	CostDelta         float64 `json:"costDelta"`
+	DurationDeltaMs   int64   `json:"durationDeltaMs"`
+	InputTokensDelta  int     `json:"inputTokensDelta"`
+	OutputTokensDelta int     `json:"outputTokensDelta"`
+}
+
+var compareRunsSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"a": map[string]any{"type": "string", "description": "ID of the first run, from " + toolHistory},
+		"b": map[string]any{"type": "string", "description": "ID of the second run"},
+	},
+	"required": []string{"a", "b"},
+}
+
+var (
+	stringListSchema = map[string]any{"type": "array", "items": map[string]any{"type": "string"}}
+
+	runSideSchema = map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"id":           map[string]any{"type": "string"},
+			"model":        map[string]any{"type": "string"},
+			"status":       map[string]any{"type": "string"},
+			"message":      map[string]any{"type": "string"},
+			"answer":       map[string]any{"type": "string"},
+			"files":        stringListSchema,
+			"cost":         map[string]any{"type": "number"},
+			"durationMs":   map[string]any{"type": "integer"},
+			"inputTokens":  map[string]any{"type": "integer"},
+			"outputTokens": map[string]any{"type": "integer"},
+		},
+		"required": []string{"id", "status", "answer", "files"},
+	}
+	runComparisonSchema = map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"a":          runSideSchema,
+			"b":          runSideSchema,
+			"sameAnswer": map[string]any{"type": "boolean"},
+			"answerDiff": map[string]any{"type": "string"},
+			"files": map[string]any{
+				"type":       "object",
+				"properties": map[string]any{"both": stringListSchema, "onlyA": stringListSchema, "onlyB": stringListSchema},
+			},
+			"costDelta":         map[string]any{"type": "number"},
+			"durationDeltaMs":   map[string]any{"type": "integer"},
+			"inputTokensDelta":  map[string]any{"type": "integer"},
+			"outputTokensDelta": map[string]any{"type": "integer"},
+		},
+		"required": []string{"a", "b", "sameAnswer", "answerDiff", "files"},
+	}
+)
+
+// compareRunsTool implements opencode_compare_runs over the caller's run
+// history.
+func compareRunsTool(cfg serverConfig, call *toolCall) (*toolCallResult, *mcpError) {
+	var args struct {
+		A string `json:"a"`
+		B string `json:"b"`
+	}
+	if err := json.Unmarshal(call.Arguments, &args); err != nil || args.A == "" || args.B == "" {
+		return nil, errInvalidArguments.err("a and b are required")
+	}
+	history := runHistory{cfg.Store}
+	side := func(id string) (runSide, *mcpError) {
+		rec, ok, err := history.get(call.Tenant, id)
+		if err != nil {
+			return runSide{}, errInternal.err(err.Error())
+		}
+		if !ok {
+			return runSide{}, errInvalidArguments.err(fmt.Sprintf("unknown run %q", id))
+		}
+		t, _, err := history.transcript(call.Tenant, id)
+		if err != nil {
+			log.Printf("[runs] transcript %s: %v", id, err)
+		}
+		return runSide{
+			ID:           rec.ID,
+			Model:        rec.Model,
+			Status:       rec.Status,
+			Message:      rec.Message,
+			Answer:       t.answer(),
+			Files:        changedFiles(t.Entries),
+			Cost:         rec.Cost,
+			DurationMs:   rec.DurationMs,
+			InputTokens:  rec.InputTokens,
+			OutputTokens: rec.OutputTokens,
+		}, nil
+	}
+	a, mErr := side(args.A)
+	if mErr != nil {
+		return nil, mErr
+	}
+	b, mErr := side(args.B)
+	if mErr != nil {
+		return nil, mErr
+	}
+
+	c := compareRuns(a, b)
+	return &toolCallResult{
+		Content:           []toolContent{{Type: "text", Text: c.summary()}},
+		StructuredContent: c,
+	}, nil
+}
+
+// compareRuns diffs two runs.
+func compareRuns(a, b runSide) runComparison {
+	c := runComparison{
+		A:                 a,
+		B:                 b,
+		SameAnswer:        strings.TrimSpace(a.Answer) == strings.TrimSpace(b.Answer),
+		CostDelta:         b.Cost - a.Cost,
+		DurationDeltaMs:   b.DurationMs - a.DurationMs,
+		InputTokensDelta:  b.InputTokens - a.InputTokens,
+		OutputTokensDelta: b.OutputTokens - a.OutputTokens,
+	}
+	if !c.SameAnswer {
+		c.AnswerDiff = runner.LineDiff(firstLines(a.Answer, maxCompareAnswerLines), firstLines(b.Answer, maxCompareAnswerLines))
+	}
+	inB := map[string]bool{}
+	for _, f := range b.Files {
+		inB[f] = true
+	}
+	c.Files.Both, c.Files.OnlyA, c.Files.OnlyB = []string{}, []string{}, []string{}
+	for _, f := range a.Files {
+		if inB[f] {
+			c.Files.Both = append(c.Files.Both, f)
+			delete(inB, f)
+		} else {
+			c.Files.OnlyA = append(c.Files.OnlyA, f)
+		}
+	}
+	for _, f := range b.Files {
+		if inB[f] {
+			c.Files.OnlyB = append(c.Files.OnlyB, f)
+		}
+	}
+	return c
+}
+
+// summary is the text content of a comparison.
+func (c runComparison) summary() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "a: %s  %s  %s  $%.4f  %dms\n", c.A.ID, c.A.Model, c.A.Status, c.A.Cost, c.A.DurationMs)
+	fmt.Fprintf(&sb, "b: %s  %s  %s  $%.4f  %dms\n", c.B.ID, c.B.Model, c.B.Status, c.B.Cost, c.B.DurationMs)
+	fmt.Fprintf(&sb, "cost %+.4f, duration %+dms, tokens %+d in / %+d out\n", c.CostDelta, c.DurationDeltaMs, c.InputTokensDelta, c.OutputTokensDelta)
+	fmt.Fprintf(&sb, "files: %d in both, %d only in a, %d only in b\n", len(c.Files.Both), len(c.Files.OnlyA), len(c.Files.OnlyB))
+	for _, f := range c.Files.OnlyA {
+		sb.WriteString("  - " + f + "\n")
+	}
+	for _, f := range c.Files.OnlyB {
+		sb.WriteString("  + " + f + "\n")
+	}
+	if c.SameAnswer {
+		sb.WriteString("\nThe answers are the same.\n")
+	} else {
+		sb.WriteString("\nAnswer diff (- a, + b):\n\n" + runner.MarkdownFence(c.AnswerDiff, "diff"))
+	}
+	return sb.String()
+}
+
+// answer is the run's final answer: its last text part.
+func (t runTranscript) answer() string {
+	for i := len(t.Entries) - 1; i >= 0; i-- {
+		if t.Entries[i].Type == "text" {
+			return t.Entries[i].Text
+		}
+	}
+	return ""
+}
+
+// changedFiles lists, sorted, the files that the completed edit tool calls
+// of a transcript name.
+func changedFiles(entries []transcriptEntry) []string {
+	seen := map[string]bool{}
+	for _, e := range entries {
+		if e.Type != "tool" || e.Status != "completed" || !runner.FileEditTools[e.Tool] {
+			continue
+		}
+		var input map[string]any
+		_ = json.Unmarshal(e.Input, &input)
+		for _, p := range runner.EditedPaths(input) {
+			seen[p] = true
+		}
+	}
+	files := make([]string, 0, len(seen))
+	for f := range seen {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// firstLines returns s cut to its first n lines.
+func firstLines(s string, n int) string {
+	lines := strings.SplitAfterN(s, "\n", n+1)
+	if len(lines) <= n {
+		return s
+	}
+	return strings.Join(lines[:n], "") + "…\n"
+}
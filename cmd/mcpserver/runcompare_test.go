@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Test two recorded runs are compared by answer, changed files, cost and
+// latency, and unknown runs are rejected
+func TestCompareRuns(t *testing.T) {
+	st, _ := openStore("")
+	h := runHistory{st}
+	edit := func(tool, input string) transcriptEntry {
+		return transcriptEntry{Type: "tool", Tool: tool, Status: "completed", Input: json.RawMessage(input)}
+	}
+	for _, r := range []struct {
+		rec     runRecord
+		entries []transcriptEntry
+	}{
+		{runRecord{ID: "a", Tenant: "team-a", Model: "p/one", Status: "ok", Cost: 0.5, DurationMs: 4000, InputTokens: 100, OutputTokens: 50}, []transcriptEntry{
+			{Type: "step"},
+			edit("edit", `{"filePath":"main.go"}`),
+			edit("write", `{"filePath":"util.go"}`),
+			{Type: "tool", Tool: "edit", Status: "error", Input: json.RawMessage(`{"filePath":"broken.go"}`)},
+			{Type: "text", Text: "Fixed the bug.\nAdded a helper."},
+		}},
+		{runRecord{ID: "b", Tenant: "team-a", Model: "p/two", Status: "ok", Cost: 0.2, DurationMs: 6500, InputTokens: 80, OutputTokens: 70}, []transcriptEntry{
+			edit("edit", `{"changes":[{"path":"main.go","kind":"update"},{"path":"main_test.go","kind":"add"}]}`),
+			edit("bash", `{"command":"go test"}`),
+			{Type: "text", Text: "Fixed the bug.\nAdded a test."},
+		}},
+	} {
+		if err := h.record(r.rec); err != nil {
+			t.Fatal(err)
+		}
+		if err := h.saveTranscript(r.rec.Tenant, r.rec.ID, runTranscript{Entries: r.entries}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tools := newToolHandler(serverConfig{Store: st})
+	result, mErr := tools(context.Background(), &toolCall{ID: json.RawMessage("1"), Name: toolCompareRuns, Tenant: "team-a", Arguments: json.RawMessage(`{"a":"a","b":"b"}`)})
+	if mErr != nil {
+		t.Fatal(mErr)
+	}
+	c := result.StructuredContent.(runComparison)
+	if c.SameAnswer || c.AnswerDiff != "  Fixed the bug.\n- Added a helper.\n+ Added a test.\n" {
+		t.Errorf("answer diff = %q", c.AnswerDiff)
+	}
+	if !reflect.DeepEqual(c.Files.Both, []string{"main.go"}) || !reflect.DeepEqual(c.Files.OnlyA, []string{"util.go"}) || !reflect.DeepEqual(c.Files.OnlyB, []string{"main_test.go"}) {
+		t.Errorf("files = %+v", c.Files)
+	}
+	if c.CostDelta != -0.3 || c.DurationDeltaMs != 2500 || c.InputTokensDelta != -20 || c.OutputTokensDelta != 20 {
+		t.Errorf("deltas = %+v", c)
+	}
+	if text := result.Content[0].Text; !strings.Contains(text, "+ main_test.go") || !strings.Contains(text, "duration +2500ms") {
+		t.Errorf("summary = %s", text)
+	}
+
+	same := compareRuns(runSide{Answer: "done\n"}, runSide{Answer: "done"})
+	if !same.SameAnswer || same.AnswerDiff != "" {
+		t.Errorf("same answers: %+v", same)
+	}
+
+	for _, args := range []string{`{"a":"a"}`, `{"a":"a","b":"c"}`} {
+		if _, mErr := tools(context.Background(), &toolCall{ID: json.RawMessage("2"), Name: toolCompareRuns, Tenant: "team-a", Arguments: json.RawMessage(args)}); mErr == nil || mErr.Data.Code != errInvalidArguments.Code {
+			t.Errorf("%s: %v", args, mErr)
+		}
+	}
+	// Runs are per tenant
+	if _, mErr := tools(context.Background(), &toolCall{ID: json.RawMessage("3"), Name: toolCompareRuns, Tenant: "team-b", Arguments: json.RawMessage(`{"a":"a","b":"b"}`)}); mErr == nil {
+		t.Error("compared another tenant's runs")
+	}
+}
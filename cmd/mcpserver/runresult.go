@@ -0,0 +1,82 @@
+package main
+
+// runResult is the structuredContent of opencode_run: the parts of its text
+// result a downstream agent would otherwise have to parse back out.
+type runResult struct {
+	Text        string          `json:"text"` // the assistant's answer, without tool outputs or stderr
+	ToolOutputs []runToolOutput `json:"toolOutputs"`
+	Usage       runUsage        `json:"usage"`
+	SessionID   string          `json:"sessionId,omitempty"`
+	ExitCode    int             `json:"exitCode"`
+	Manifest    *runManifest    `json:"manifest,omitempty"`
+	Attestation *attestation    `json:"attestation,omitempty"`
+	CodeBlocks  []codeBlock     `json:"codeBlocks,omitempty"` // with "codeBlocks": true
+}
+
+// runToolOutput is the output of one completed opencode tool use.
+type runToolOutput struct {
+	Tool   string `json:"tool"`
+	Output string `json:"output"`
+}
+
+// runResultSchema is the outputSchema of opencode_run.
+var runResultSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"text": map[string]any{"type": "string"},
+		"toolOutputs": map[string]any{
+			"type": "array",
+			"items": map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"tool":   map[string]any{"type": "string"},
+					"output": map[string]any{"type": "string"},
+				},
+				"required": []string{"tool", "output"},
+			},
+		},
+		"usage": map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"cost":         map[string]any{"type": "number"},
+				"inputTokens":  map[string]any{"type": "integer"},
+				"outputTokens": map[string]any{"type": "integer"},
+			},
+			"required": []string{"cost", "inputTokens", "outputTokens"},
+		},
+		"sessionId":   map[string]any{"type": "string"},
+		"exitCode":    map[string]any{"type": "integer"},
+		"manifest":    map[string]any{"type": "object"},
+		"attestation": map[string]any{"type": "object"},
+		"codeBlocks": map[string]any{
+			"type": "array",
+			"items": map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"language": map[string]any{"type": "string"},
+					"filename": map[string]any{"type": "string"},
+					"code":     map[string]any{"type": "string"},
+				},
+				"required": []string{"code"},
+			},
+		},
+	},
+	"required": []string{"text", "toolOutputs", "usage", "exitCode"},
+}
+
+// runResult returns the structuredContent of an opencode_run result.
+func (r *toolCallResult) runResult() runResult {
+	out := runResult{
+		Text:        r.answer,
+		ToolOutputs: r.toolOutputs,
+		Usage:       r.usage,
+		SessionID:   r.sessionID,
+		ExitCode:    r.exitCode,
+		Manifest:    r.manifest,
+		Attestation: r.attestation,
+	}
+	if out.ToolOutputs == nil {
+		out.ToolOutputs = []runToolOutput{}
+	}
+	return out
+}
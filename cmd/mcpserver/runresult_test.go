@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Test opencode_run returns its answer, tool outputs and usage as structuredContent
+func TestRunStructuredContent(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "opencode")
+	events := `#!/bin/sh
+echo '{"type":"tool_use","sessionID":"ses_1","part":{"tool":"bash","state":{"status":"completed","output":"ok"}}}'
+echo '{"type":"text","part":{"text":"All tests pass."}}'
+echo '{"type":"step_finish","part":{"reason":"stop","cost":0.25,"tokens":{"input":120,"output":30}}}'
+`
+	if err := os.WriteFile(script, []byte(events), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	cfg := serverConfig{Target: script, DefaultTimeout: 5 * time.Second}
+	result, mErr := newToolHandler(cfg)(context.Background(), &toolCall{Name: toolRun, Arguments: []byte(`{"message":"run the tests"}`)})
+	if mErr != nil {
+		t.Fatal(mErr)
+	}
+	rr, ok := result.StructuredContent.(runResult)
+	if !ok {
+		t.Fatalf("structuredContent = %T", result.StructuredContent)
+	}
+	want := runResult{
+		Text:        "All tests pass.",
+		ToolOutputs: []runToolOutput{{Tool: "bash", Output: "ok"}},
+		Usage:       runUsage{Cost: 0.25, InputTokens: 120, OutputTokens: 30},
+		SessionID:   "ses_1",
+		Manifest:    rr.Manifest,
+	}
+	got, _ := json.Marshal(rr)
+	wantJSON, _ := json.Marshal(want)
+	if string(got) != string(wantJSON) {
+		t.Errorf("structuredContent = %s, want %s", got, wantJSON)
+	}
+	if rr.Manifest == nil {
+		t.Error("no manifest in structuredContent")
+	}
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	runsCollection = "runs"
+
+	// maxRunHistory is the number of runs kept per tenant; older runs are
+	// pruned when new ones are recorded.
+	maxRunHistory     = 1000
+	defaultRunsLimit  = 50
+	runMessagePreview = 200
+)
+
+// runRecord is the persisted metadata of one opencode_run execution.
+type runRecord struct {
+	ID           string         `json:"id"`
+	Tenant       string         `json:"tenant"`
+	Labels       []string       `json:"labels,omitempty"`
+	Cwd          string         `json:"cwd,omitempty"`
+	Model        string         `json:"model,omitempty"`
+	Session      string         `json:"session,omitempty"` // opencode session ID
+	Message      string         `json:"message"`           // truncated preview
+	Status       string         `json:"status"`            // ok, error or stalled
+	Error        string         `json:"error,omitempty"`
+	StartedAt    time.Time      `json:"startedAt"`
+	FinishedAt   time.Time      `json:"finishedAt"`
+	DurationMs   int64          `json:"durationMs"`
+	Cost         float64        `json:"cost,omitempty"`
+	InputTokens  int            `json:"inputTokens,omitempty"`
+	OutputTokens int            `json:"outputTokens,omitempty"`
+	Resources    *resourceUsage `json:"resources,omitempty"` // CPU time and peak memory of the opencode process
+	Artifacts    []artifactInfo `json:"artifacts,omitempty"`
+	Manifest     *runManifest   `json:"manifest,omitempty"`
+	Attestation  *attestation   `json:"attestation,omitempty"` // the signed manifest
+	Reproduce    *reproduction  `json:"reproduce,omitempty"`   // how to rerun the exact opencode invocation
+	ArchivedAt   *time.Time     `json:"archivedAt,omitempty"`  // hidden from listings until restored or purged
+	Stall        *stallReport   `json:"stall,omitempty"`       // what the process was doing when the watchdog stopped it
+}
+
+// runFilter selects runs from the history; zero fields match everything
+// except archived runs.
+type runFilter struct {
+	Label    string
+	Cwd      string
+	Status   string
+	Since    time.Time
+	Archived string // archivedOnly or archivedInclude
+	Limit    int
+}
+
+// runHistory stores run metadata per tenant.
+type runHistory struct {
+	store *store
+}
+
+func runKey(tenant, id string) string {
+	return tenantOrDefault(tenant) + "/" + id
+}
+
+func (h runHistory) record(rec runRecord) error {
+	if h.store == nil {
+		return errNoStore
+	}
+	rec.Tenant = tenantOrDefault(rec.Tenant)
+	if err := h.store.put(runsCollection, runKey(rec.Tenant, rec.ID), rec); err != nil {
+		return err
+	}
+	return h.prune(rec.Tenant)
+}
+
+// prune drops the oldest runs beyond maxRunHistory.
+func (h runHistory) prune(tenant string) error {
+	runs, err := h.query(tenant, runFilter{Archived: archivedInclude, Limit: -1})
+	if err != nil || len(runs) <= maxRunHistory {
+		return err
+	}
+	for _, r := range runs[maxRunHistory:] {
+		if _, err := h.store.delete(runsCollection, runKey(tenant, r.ID)); err != nil {
+			return err
+		}
+		if _, err := h.store.delete(transcriptsCollection, runKey(tenant, r.ID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h runHistory) get(tenant, id string) (runRecord, bool, error) {
+	var rec runRecord
+	if h.store == nil {
+		return rec, false, errNoStore
+	}
+	ok, err := h.store.get(runsCollection, runKey(tenant, id), &rec)
+	return rec, ok, err
+}
+
+// query returns the tenant's runs matching f, newest first. A negative
+// limit returns every match.
+func (h runHistory) query(tenant string, f runFilter) ([]runRecord, error) {
+	if h.store == nil {
+		return nil, errNoStore
+	}
+	runs := []runRecord{}
+	for _, doc := range h.store.list(runsCollection, runKey(tenant, "")) {
+		var rec runRecord
+		if err := json.Unmarshal(doc, &rec); err != nil {
+			return nil, err
+		}
+		if f.matches(rec) {
+			runs = append(runs, rec)
+		}
+	}
+	sort.SliceStable(runs, func(i, j int) bool { return runs[i].StartedAt.After(runs[j].StartedAt) })
+	limit := f.Limit
+	if limit == 0 {
+		limit = defaultRunsLimit
+	}
+	if limit > 0 && len(runs) > limit {
+		runs = runs[:limit]
+	}
+	return runs, nil
+}
+
+func (f runFilter) matches(rec runRecord) bool {
+	if f.Label != "" && !slices.Contains(rec.Labels, f.Label) {
+		return false
+	}
+	if f.Cwd != "" && rec.Cwd != f.Cwd {
+		return false
+	}
+	if f.Status != "" && rec.Status != f.Status {
+		return false
+	}
+	switch f.Archived {
+	case "":
+		if rec.archived() {
+			return false
+		}
+	case archivedOnly:
+		if !rec.archived() {
+			return false
+		}
+	}
+	return f.Since.IsZero() || !rec.StartedAt.Before(f.Since)
+}
+
+// parseSince accepts an RFC3339 timestamp or a look-back duration such as
+// "36h" or "7d".
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return time.Time{}, fmt.Errorf("invalid since %q (want RFC3339 time or duration like 24h, 7d)", s)
+	}
+	return now.Add(-d), nil
+}
+
+// historyMiddleware records every opencode_run in the run history.
+func historyMiddleware(cfg serverConfig) toolMiddleware {
+	history := runHistory{cfg.Store}
+	return func(next toolHandler) toolHandler {
+		return func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+			if call.Name != toolRun || cfg.Store == nil {
+				return next(ctx, call)
+			}
+			runArgs, mErr := parseRunArgs(call)
+			if mErr != nil {
+				return next(ctx, call)
+			}
+			if call.RunID == "" {
+				call.RunID = generateSessionID()
+			}
+			rec := runRecord{
+				ID:        call.RunID,
+				Tenant:    call.Tenant,
+				Labels:    runArgs.Labels,
+				Cwd:       runArgs.Cwd,
+				Model:     runArgs.Model,
+				Session:   runArgs.Session,
+				Message:   truncateForLog(runArgs.Message, runMessagePreview),
+				StartedAt: localTime(time.Now()),
+			}
+			if rec.Cwd == "" {
+				rec.Cwd = call.Cwd
+			}
+			if runArgs.Continue && runArgs.Session == "" {
+				if session, override := history.continueSession(rec.Tenant, rec.Cwd); override {
+					continueIn(call, session)
+					rec.Session = session
+				}
+			}
+
+			result, mErr := next(ctx, call)
+
+			rec.FinishedAt = localTime(time.Now())
+			rec.DurationMs = rec.FinishedAt.Sub(rec.StartedAt).Milliseconds()
+			switch {
+			case mErr != nil:
+				rec.Status, rec.Error = "error", mErr.Message
+			case result.stall != nil:
+				rec.Status, rec.Error, rec.Stall = "stalled", result.stall.String(), result.stall
+			case result.IsError:
+				rec.Status = "error"
+			default:
+				rec.Status = "ok"
+			}
+			if result != nil {
+				if result.sessionID != "" {
+					rec.Session = result.sessionID
+				}
+				rec.Cost = result.usage.Cost
+				rec.InputTokens = result.usage.InputTokens
+				rec.OutputTokens = result.usage.OutputTokens
+				rec.Resources = result.resources
+				rec.Artifacts = result.artifacts
+				rec.Manifest = result.manifest
+				rec.Attestation = result.attestation
+				rec.Reproduce = result.reproduction
+			}
+			if err := history.record(rec); err != nil {
+				log.Printf("[runs] failed to record run %s: %v", rec.ID, err)
+			}
+			if result != nil && len(result.transcript) > 0 {
+				t := runTranscript{Prompt: runArgs.Message, Entries: result.transcript}
+				if err := history.saveTranscript(rec.Tenant, rec.ID, t); err != nil {
+					log.Printf("[runs] failed to save transcript of run %s: %v", rec.ID, err)
+				}
+			}
+			return result, mErr
+		}
+	}
+}
+
+// continueIn replaces continue in call's arguments with session, or with a
+// new session when it is "".
+func continueIn(call *toolCall, session string) {
+	var args map[string]any
+	if json.Unmarshal(call.Arguments, &args) != nil {
+		return
+	}
+	delete(args, "continue")
+	if session != "" {
+		args["session"] = session
+	}
+	call.Arguments, _ = json.Marshal(args)
+	log.Printf("[runs] continue skips archived sessions: session=%q", session)
+}
+
+// runFilterFromQuery reads label, cwd, status, since, archived and limit.
+func runFilterFromQuery(get func(string) string) (runFilter, error) {
+	f := runFilter{Label: get("label"), Cwd: get("cwd"), Status: get("status"), Archived: get("archived")}
+	if err := validateArchivedFilter(f.Archived); err != nil {
+		return f, err
+	}
+	since, err := parseSince(get("since"), time.Now())
+	if err != nil {
+		return f, err
+	}
+	f.Since = since
+	if l := get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			return f, fmt.Errorf("invalid limit %q", l)
+		}
+		f.Limit = n
+	}
+	return f, nil
+}
+
+// historyArgs are the arguments of opencode_history.
+type historyArgs struct {
+	Label    string `json:"label"`
+	Cwd      string `json:"cwd"`
+	Status   string `json:"status"`
+	Since    string `json:"since"`
+	Archived string `json:"archived"`
+	Limit    int    `json:"limit"`
+}
+
+var historySchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"label":    map[string]any{"type": "string", "description": "Only runs tagged with this label"},
+		"cwd":      map[string]any{"type": "string", "description": "Only runs in this directory"},
+		"status":   map[string]any{"type": "string", "enum": []string{"ok", "error", "stalled"}, "description": "Only runs with this outcome"},
+		"since":    map[string]any{"type": "string", "description": "RFC3339 time or look-back such as 24h or 7d"},
+		"archived": map[string]any{"type": "string", "enum": []string{archivedOnly, archivedInclude}, "description": "Only archived runs, or include them (hidden by default)"},
+		"limit":    map[string]any{"type": "integer", "description": fmt.Sprintf("Maximum runs to return (default %d)", defaultRunsLimit)},
+	},
+}
+
+// historyTool answers opencode_history from the caller's run history.
+func historyTool(cfg serverConfig, call *toolCall) (*toolCallResult, *mcpError) {
+	var args historyArgs
+	if len(call.Arguments) > 0 {
+		if err := json.Unmarshal(call.Arguments, &args); err != nil {
+			return nil, errInvalidArguments.err("invalid arguments")
+		}
+	}
+	f, err := runFilterFromQuery(func(key string) string {
+		switch key {
+		case "label":
+			return args.Label
+		case "cwd":
+			return args.Cwd
+		case "status":
+			return args.Status
+		case "since":
+			return args.Since
+		case "archived":
+			return args.Archived
+		case "limit":
+			if args.Limit > 0 {
+				return strconv.Itoa(args.Limit)
+			}
+		}
+		return ""
+	})
+	if err != nil {
+		return nil, errInvalidArguments.err(err.Error())
+	}
+	runs, err := runHistory{cfg.Store}.query(call.Tenant, f)
+	if err != nil {
+		return nil, errInternal.err(err.Error())
+	}
+
+	var lines []string
+	for _, r := range runs {
+		line := fmt.Sprintf("%s  %s  %s  %s  %s", r.ID, r.StartedAt.Format(time.RFC3339), r.Status, r.Cwd, r.Message)
+		if len(r.Labels) > 0 {
+			line += "  [" + strings.Join(r.Labels, ",") + "]"
+		}
+		lines = append(lines, line)
+	}
+	return &toolCallResult{
+		Content:           []toolContent{{Type: "text", Text: strings.Join(lines, "\n")}},
+		StructuredContent: map[string]any{"runs": runs},
+	}, nil
+}
+
+// registerRunRoutes adds GET /runs and GET /runs/{id}. Archived runs are
+// still found by ID.
+func registerRunRoutes(mux *http.ServeMux, cfg serverConfig) {
+	history := runHistory{cfg.Store}
+
+	mux.HandleFunc("GET /runs", func(w http.ResponseWriter, r *http.Request) {
+		f, err := runFilterFromQuery(r.URL.Query().Get)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		runs, err := history.query(tenantFromRequest(r), f)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, runs)
+	})
+	mux.HandleFunc("GET /runs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		rec, ok, err := history.get(tenantFromRequest(r), r.PathValue("id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "run not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, rec)
+	})
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Test parseSince
+func TestParseSince(t *testing.T) {
+	now := time.Date(2025, 10, 8, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in      string
+		want    time.Time
+		wantErr bool
+	}{
+		{in: "", want: time.Time{}},
+		{in: "7d", want: now.AddDate(0, 0, -7)},
+		{in: "36h", want: now.Add(-36 * time.Hour)},
+		{in: "2025-10-01T00:00:00Z", want: time.Date(2025, 10, 1, 0, 0, 0, 0, time.UTC)},
+		{in: "last week", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseSince(tt.in, now)
+		if (err != nil) != tt.wantErr || !got.Equal(tt.want) {
+			t.Errorf("parseSince(%q) = %v, %v", tt.in, got, err)
+		}
+	}
+}
+
+// Test runs are recorded with labels and can be searched
+func TestRunHistory(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "opencode")
+	content := `#!/bin/sh
+for last; do :; done
+if [ "$last" = "fail" ]; then exit 2; fi
+echo '{"type":"text","sessionID":"ses_h","part":{"text":"ok"}}'
+echo '{"type":"step_finish","part":{"cost":0.5,"tokens":{"input":10,"output":5}}}'
+`
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	st, _ := openStore("")
+	cfg := serverConfig{Target: script, DefaultTimeout: 5 * time.Second, Store: st}
+	tools := newToolHandler(cfg)
+	run := func(tenant, args string) {
+		if _, mErr := tools(context.Background(), &toolCall{ID: json.RawMessage("1"), Name: toolRun, Tenant: tenant, Arguments: json.RawMessage(args)}); mErr != nil {
+			t.Fatalf("run: %v", mErr)
+		}
+	}
+	run("team-a", `{"message":"bump deps","model":"m","labels":["dependency-upgrade"]}`)
+	run("team-a", `{"message":"fail","model":"m"}`)
+	run("team-b", `{"message":"other tenant","model":"m","labels":["dependency-upgrade"]}`)
+
+	runs, err := runHistory{st}.query("team-a", runFilter{Label: "dependency-upgrade"})
+	if err != nil || len(runs) != 1 {
+		t.Fatalf("label query = %+v, %v", runs, err)
+	}
+	if r := runs[0]; r.Status != "ok" || r.Session != "ses_h" || r.Cost != 0.5 || r.Message != "bump deps" {
+		t.Errorf("run = %+v", r)
+	}
+
+	result, mErr := tools(context.Background(), &toolCall{ID: json.RawMessage("2"), Name: toolHistory, Tenant: "team-a", Arguments: json.RawMessage(`{"status":"error","since":"1h"}`)})
+	if mErr != nil {
+		t.Fatalf("history: %v", mErr)
+	}
+	if runs := result.StructuredContent.(map[string]any)["runs"].([]runRecord); len(runs) != 1 || runs[0].Message != "fail" {
+		t.Errorf("status query = %+v", runs)
+	}
+
+	mux := http.NewServeMux()
+	registerRunRoutes(mux, cfg)
+	req := httptest.NewRequest(http.MethodGet, "/runs?label=dependency-upgrade", nil)
+	req.Header.Set(tenantHeader, "team-b")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	var listed []runRecord
+	if err := json.Unmarshal(rec.Body.Bytes(), &listed); err != nil || len(listed) != 1 || listed[0].Tenant != "team-b" {
+		t.Errorf("GET /runs = %s", rec.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/runs/"+listed[0].ID, nil)
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET another tenant's run = %d, want 404", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/runs?since=yesterday", nil)
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid since = %d, want 400", rec.Code)
+	}
+}
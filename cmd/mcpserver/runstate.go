@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"opencode-mcp/internal/mcp"
+)
+
+const (
+	// budgetMethod warns a session that its tenant is running out of a
+	// daily quota.
+	budgetMethod = "notifications/opencode/budget"
+
+	// sessionStreamBuffer is how many notifications a slow GET stream may
+	// fall behind before further ones are dropped, and how many are kept
+	// for a client reconnecting with Last-Event-ID.
+	sessionStreamBuffer = 64
+	// sessionStreamKeepAlive is how often an idle GET stream gets a comment,
+	// so that proxies don't close it.
+	sessionStreamKeepAlive = 30 * time.Second
+)
+
+// budgetThresholds are the fractions of a daily quota whose crossing is
+// announced, highest first.
+var budgetThresholds = []float64{1, 0.8}
+
+// sessionStreams are the open GET /mcp streams of a session. They carry
+// the notifications that belong to no request's response: run state
+// changes, budget warnings and changes of the model list. Each has an
+// event ID, numbered per session, so a client that lost its stream can
+// reopen it with Last-Event-ID and miss nothing still in recent.
+type sessionStreams struct {
+	mu     sync.Mutex
+	subs   map[chan sseEvent]struct{}
+	seq    int
+	recent []sseEvent
+}
+
+// subscribe opens a stream, starting with the recent notifications after
+// lastEventID; stop closes it.
+func (s *session) subscribe(lastEventID int) (events <-chan sseEvent, stop func()) {
+	ch := make(chan sseEvent, sessionStreamBuffer)
+	s.streams.mu.Lock()
+	if s.streams.subs == nil {
+		s.streams.subs = map[chan sseEvent]struct{}{}
+	}
+	if lastEventID > 0 {
+		for _, ev := range s.streams.recent {
+			if id, _ := strconv.Atoi(ev.ID); id > lastEventID {
+				ch <- ev
+			}
+		}
+	}
+	s.streams.subs[ch] = struct{}{}
+	s.streams.mu.Unlock()
+	return ch, func() {
+		s.streams.mu.Lock()
+		delete(s.streams.subs, ch)
+		s.streams.mu.Unlock()
+	}
+}
+
+// streaming reports whether the session has an open stream.
+func (s *session) streaming() bool {
+	s.streams.mu.Lock()
+	defer s.streams.mu.Unlock()
+	return len(s.streams.subs) > 0
+}
+
+// end closes the session's open streams, when the client deleted it.
+func (s *session) end() {
+	s.streams.mu.Lock()
+	defer s.streams.mu.Unlock()
+	for ch := range s.streams.subs {
+		close(ch)
+		delete(s.streams.subs, ch)
+	}
+}
+
+// publish sends msg to the session's open streams, if any. Streams that
+// are too far behind miss it rather than hold up the run.
+func (s *session) publish(msg any) {
+	if s == nil {
+		return
+	}
+	s.streams.mu.Lock()
+	defer s.streams.mu.Unlock()
+	s.streams.seq++
+	ev := sseEvent{ID: strconv.Itoa(s.streams.seq), Msg: msg}
+	s.streams.recent = append(s.streams.recent, ev)
+	if len(s.streams.recent) > sessionStreamBuffer {
+		s.streams.recent = s.streams.recent[1:]
+	}
+	for ch := range s.streams.subs {
+		select {
+		case ch <- ev:
+		default:
+			log.Printf("[MCP] session=%s stream full, dropped a notification", s.id)
+		}
+	}
+}
+
+// broadcast publishes msg on every session of this instance that has an
+// open stream.
+func (s *sessionStore) broadcast(msg any) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, sess := range s.sessions {
+		if sess.streaming() {
+			sess.publish(msg)
+		}
+	}
+}
+
+// streaming reports whether any session of this instance has an open
+// stream.
+func (s *sessionStore) streaming() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, sess := range s.sessions {
+		if sess.streaming() {
+			return true
+		}
+	}
+	return false
+}
+
+// publishRunState tells the call's session, and the event bus, about a
+// state change of its run.
+func (c *toolCall) publishRunState(bus *eventBus, state string, fields map[string]any) {
+	data := map[string]any{"runId": c.RunID, "tool": c.Name}
+	for k, v := range fields {
+		data[k] = v
+	}
+	bus.publish("run."+state, c.Tenant, sessionID(c.Session), data)
+	if c.Session == nil {
+		return
+	}
+	params := map[string]any{
+		"requestId": c.ID,
+		"state":     state,
+		"time":      time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	for k, v := range data {
+		params[k] = v
+	}
+	c.Session.publish(mcp.Notification(mcp.MethodRunState, params))
+}
+
+// runStateMiddleware announces the end of every opencode_run on the event
+// bus and the caller's session streams, with the budget warnings it
+// triggers; dispatchTool announces queueing and start. It must run outside
+// historyMiddleware so that the finished run counts against the quota.
+func runStateMiddleware(cfg serverConfig) toolMiddleware {
+	return func(next toolHandler) toolHandler {
+		return func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+			if call.Name != toolRun || (call.Session == nil && cfg.Events == nil) {
+				return next(ctx, call)
+			}
+			if call.RunID == "" {
+				call.RunID = generateSessionID()
+			}
+			tcfg := cfg.forTenant(call.Tenant)
+			var before quotaUsage
+			var err error
+			if call.Session != nil {
+				if before, err = dailyUsage(tcfg, call.Tenant, time.Now()); err != nil {
+					log.Printf("[runs] usage of tenant %s: %v", tenantOrDefault(call.Tenant), err)
+				}
+			}
+
+			start := time.Now()
+			result, mErr := next(ctx, call)
+			status := "ok"
+			switch {
+			case ctx.Err() != nil:
+				status = "cancelled"
+			case result != nil && result.stall != nil:
+				status = "stalled"
+			case mErr != nil || result == nil || result.IsError:
+				status = "error"
+			}
+			call.publishRunState(cfg.Events, mcp.RunFinished, map[string]any{"status": status, "durationMs": time.Since(start).Milliseconds()})
+
+			if call.Session != nil && err == nil {
+				publishBudgetWarnings(tcfg, call, before)
+			}
+			return result, mErr
+		}
+	}
+}
+
+// publishBudgetWarnings warns the call's session when its run took the
+// tenant's usage past one of the budgetThresholds of a quota.
+func publishBudgetWarnings(cfg serverConfig, call *toolCall, before quotaUsage) {
+	q := cfg.Policy.Quota
+	if q.RunsPerDay == 0 && q.CostPerDay == 0 {
+		return
+	}
+	after, err := dailyUsage(cfg, call.Tenant, time.Now())
+	if err != nil {
+		log.Printf("[runs] usage of tenant %s: %v", tenantOrDefault(call.Tenant), err)
+		return
+	}
+	warn := func(quota string, used, limit float64, message string) {
+		call.Session.publish(mcp.Notification(budgetMethod, map[string]any{
+			"level":    "warning",
+			"quota":    quota,
+			"used":     used,
+			"limit":    limit,
+			"fraction": used / limit,
+			"message":  message,
+		}))
+	}
+	if t, ok := crossedThreshold(float64(before.Runs), float64(after.Runs), float64(q.RunsPerDay)); ok {
+		warn("runsPerDay", float64(after.Runs), float64(q.RunsPerDay),
+			call.sprintf("%.0f%% of the daily run quota used (%d of %d)", 100*t, after.Runs, q.RunsPerDay))
+	}
+	if t, ok := crossedThreshold(before.Cost, after.Cost, q.CostPerDay); ok {
+		warn("costPerDay", after.Cost, q.CostPerDay,
+			call.sprintf("%.0f%% of the daily cost quota used ($%.2f of $%.2f)", 100*t, after.Cost, q.CostPerDay))
+	}
+}
+
+// crossedThreshold returns the highest of budgetThresholds that usage
+// reached going from before to after, for a limit; ok is false for none or
+// an unlimited quota.
+func crossedThreshold(before, after, limit float64) (float64, bool) {
+	if limit <= 0 {
+		return 0, false
+	}
+	for _, t := range budgetThresholds {
+		if before < t*limit && after >= t*limit {
+			return t, true
+		}
+	}
+	return 0, false
+}
+
+// serveSessionStream answers GET /mcp: a stream of the session's
+// notifications that belong to no request, open until the client leaves.
+// A Last-Event-ID header resumes a previous stream.
+func serveSessionStream(w http.ResponseWriter, r *http.Request, sess *session) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	lastEventID, _ := strconv.Atoi(r.Header.Get("Last-Event-ID"))
+	events, stop := sess.subscribe(lastEventID)
+	defer stop()
+	log.Printf("[MCP] session=%s stream opened", sess.id)
+	defer log.Printf("[MCP] session=%s stream closed", sess.id)
+
+	w.Header().Set("Mcp-Session-Id", sess.id)
+	stream := &sseStream{w: w}
+	stream.open()
+	flusher.Flush()
+	keepAlive := time.NewTicker(sessionStreamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case msg, ok := <-events:
+			if !ok {
+				return // the session was deleted
+			}
+			stream.send(msg)
+		case <-keepAlive.C:
+			stream.comment("keep-alive")
+		}
+	}
+}
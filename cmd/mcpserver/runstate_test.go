@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"opencode-mcp/internal/fakeopencode"
+	"opencode-mcp/internal/mcp"
+)
+
+// Test a session's streams see its runs queue, start and finish, and the
+// quota warning of the run that used it up
+func TestRunStateNotifications(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(fakeopencode.EnvScript, fakeopencode.Script{Lines: []string{fakeopencode.Text("working")}, Hang: true}.Encode())
+	st, _ := openStore("")
+	cfg := serverConfig{
+		Target: exe, DefaultTimeout: 20 * time.Second, DefaultModel: "fake/model", Backend: backendCLI,
+		Store: st, Limiter: newRunLimiter(1), Running: newRunningRuns(),
+		Tenants: map[string]tenantPolicy{"team-a": {Quota: tenantQuota{RunsPerDay: 2}}},
+	}
+	tools := newToolHandler(cfg)
+	sess := &session{id: "s"}
+	events, stop := sess.subscribe(0)
+	defer stop()
+
+	type event struct {
+		method string
+		params map[string]any
+	}
+	next := func() event {
+		t.Helper()
+		select {
+		case ev := <-events:
+			m := ev.Msg.(map[string]any)
+			return event{m["method"].(string), m["params"].(map[string]any)}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no notification")
+			return event{}
+		}
+	}
+	run := func(ctx context.Context, id string) chan struct{} {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_, _ = tools(ctx, &toolCall{ID: json.RawMessage(id), Name: toolRun, Tenant: "team-a", Session: sess, Arguments: json.RawMessage(`{"message":"hi"}`)})
+		}()
+		return done
+	}
+
+	ctxA, cancelA := context.WithCancel(context.Background())
+	doneA := run(ctxA, `"a"`)
+	if e := next(); e.params["state"] != mcp.RunStarted || e.params["requestId"] == nil || e.params["runId"] == "" {
+		t.Fatalf("first event = %+v", e)
+	}
+	ctxB, cancelB := context.WithCancel(context.Background())
+	doneB := run(ctxB, `"b"`)
+	if e := next(); e.params["state"] != mcp.RunQueued || e.params["position"] != 1 || e.params["priority"] != priorityNormal {
+		t.Fatalf("second event = %+v", e)
+	}
+
+	cancelA()
+	<-doneA
+	seen := map[string]string{}
+	for len(seen) < 2 {
+		e := next()
+		id, _ := json.Marshal(e.params["requestId"])
+		seen[string(id)] = e.params["state"].(string)
+		if string(id) == `"a"` && e.params["status"] != "cancelled" {
+			t.Errorf("a finished with %+v", e.params)
+		}
+	}
+	if seen[`"a"`] != mcp.RunFinished || seen[`"b"`] != mcp.RunStarted {
+		t.Errorf("after cancelling a: %v", seen)
+	}
+
+	cancelB()
+	<-doneB
+	if e := next(); e.params["state"] != mcp.RunFinished {
+		t.Errorf("b: %+v", e)
+	}
+	if e := next(); e.method != budgetMethod || e.params["quota"] != "runsPerDay" || e.params["fraction"] != 1.0 {
+		t.Errorf("budget warning = %+v", e)
+	}
+	select {
+	case msg := <-events:
+		t.Errorf("unexpected %v", msg)
+	default:
+	}
+
+	for _, tt := range []struct {
+		before, after, limit float64
+		want                 float64
+		ok                   bool
+	}{
+		{0, 7, 10, 0, false},
+		{7, 8, 10, 0.8, true},
+		{7, 10, 10, 1, true},
+		{8, 9, 10, 0, false},
+		{0, 100, 0, 0, false},
+	} {
+		if got, ok := crossedThreshold(tt.before, tt.after, tt.limit); got != tt.want || ok != tt.ok {
+			t.Errorf("crossedThreshold(%v, %v, %v) = %v, %v", tt.before, tt.after, tt.limit, got, ok)
+		}
+	}
+}
+
+// Test GET /mcp streams the session's notifications
+func TestSessionStream(t *testing.T) {
+	sessions := &sessionStore{sessions: make(map[string]*session)}
+	srv := httptest.NewServer(newMCPHandler(sessions, serverConfig{}))
+	defer srv.Close()
+	get := func(id string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+		req.Header.Set("Accept", "text/event-stream")
+		if id != "" {
+			req.Header.Set("Mcp-Session-Id", id)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	if resp := get(""); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("without a session: %d", resp.StatusCode)
+	}
+	if resp := get("nope"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown session: %d", resp.StatusCode)
+	}
+
+	resp, err := http.Post(srv.URL, "application/json", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	sess := sessions.get(resp.Header.Get("Mcp-Session-Id"))
+	stream := get(sess.id)
+	defer stream.Body.Close()
+	if ct := stream.Header.Get("Content-Type"); stream.StatusCode != http.StatusOK || ct != "text/event-stream" {
+		t.Fatalf("stream = %d %s", stream.StatusCode, ct)
+	}
+	sess.publish(mcp.Notification(mcp.MethodRunState, map[string]any{"state": mcp.RunStarted}))
+	sc := bufio.NewScanner(stream.Body)
+	for sc.Scan() {
+		if data, ok := strings.CutPrefix(sc.Text(), "data: "); ok {
+			if !strings.Contains(data, `"method":"notifications/opencode/run"`) {
+				t.Errorf("streamed %s", data)
+			}
+			return
+		}
+	}
+	t.Fatalf("stream ended: %v", sc.Err())
+}
+
+// Test a reopened stream replays the notifications after Last-Event-ID
+func TestSessionStreamResume(t *testing.T) {
+	sess := &session{id: "s"}
+	for i := 0; i < sessionStreamBuffer+2; i++ {
+		sess.publish(mcp.Notification(mcp.MethodRunState, map[string]any{"n": i}))
+	}
+	last := sessionStreamBuffer + 2
+	events, stop := sess.subscribe(last - 2)
+	defer stop()
+	if len(events) != 2 {
+		t.Fatalf("replayed %d events, want 2", len(events))
+	}
+	if ev := <-events; ev.ID != strconv.Itoa(last-1) {
+		t.Errorf("first replayed ID = %s, want %d", ev.ID, last-1)
+	}
+
+	events, stop = sess.subscribe(0)
+	defer stop()
+	if len(events) != 0 {
+		t.Errorf("a new stream replayed %d events", len(events))
+	}
+}
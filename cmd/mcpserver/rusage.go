@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"sync"
+)
+
+// resourceUsage is what an opencode process consumed, for capacity planning
+// of shared hosts.
+type resourceUsage struct {
+	UserCPUMs int64 `json:"userCpuMs"`
+	SysCPUMs  int64 `json:"sysCpuMs"`
+	MaxRSSKB  int64 `json:"maxRssKb,omitempty"` // not reported on Windows
+}
+
+// processResources reads the resource usage of an exited process.
+func processResources(ps *os.ProcessState) *resourceUsage {
+	if ps == nil {
+		return nil
+	}
+	return &resourceUsage{
+		UserCPUMs: ps.UserTime().Milliseconds(),
+		SysCPUMs:  ps.SystemTime().Milliseconds(),
+		MaxRSSKB:  maxRSSKB(ps),
+	}
+}
+
+// runMetrics totals the resource usage of opencode processes since startup.
+// A nil *runMetrics records nothing.
+type runMetrics struct {
+	mu       sync.Mutex
+	snapshot runMetricsSnapshot
+}
+
+type runMetricsSnapshot struct {
+	Processes    int64 `json:"processes"`
+	UserCPUMs    int64 `json:"userCpuMs"`
+	SysCPUMs     int64 `json:"sysCpuMs"`
+	PeakMaxRSSKB int64 `json:"peakMaxRssKb"`
+}
+
+func (m *runMetrics) record(u *resourceUsage) {
+	if m == nil || u == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.snapshot.Processes++
+	m.snapshot.UserCPUMs += u.UserCPUMs
+	m.snapshot.SysCPUMs += u.SysCPUMs
+	m.snapshot.PeakMaxRSSKB = max(m.snapshot.PeakMaxRSSKB, u.MaxRSSKB)
+}
+
+func (m *runMetrics) get() runMetricsSnapshot {
+	if m == nil {
+		return runMetricsSnapshot{}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.snapshot
+}
+
+// memoryStatus reports the server's own memory use.
+func memoryStatus() map[string]any {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return map[string]any{
+		"heapAllocBytes": ms.HeapAlloc,
+		"sysBytes":       ms.Sys,
+		"goroutines":     runtime.NumGoroutine(),
+	}
+}
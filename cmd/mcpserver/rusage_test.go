@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// Test the resource usage of runs reaches the result, the history and the
+// totals
+func TestRunResources(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script")
+	}
+	script := filepath.Join(t.TempDir(), "opencode")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho '{\"type\":\"text\",\"part\":{\"text\":\"ok\"}}'\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	st, _ := openStore("")
+	cfg := serverConfig{Target: script, DefaultTimeout: 5 * time.Second, Store: st, Metrics: &runMetrics{}}
+	result, mErr := newToolHandler(cfg)(context.Background(), &toolCall{ID: json.RawMessage("1"), Name: toolRun, Arguments: json.RawMessage(`{"message":"hi","model":"m"}`)})
+	if mErr != nil {
+		t.Fatal(mErr)
+	}
+	res, ok := result.Meta["resources"].(*resourceUsage)
+	if !ok {
+		t.Fatalf("_meta = %v, want resources", result.Meta)
+	}
+	if runtime.GOOS == "linux" && res.MaxRSSKB <= 0 {
+		t.Errorf("maxRssKb = %d, want the peak RSS", res.MaxRSSKB)
+	}
+
+	runs, err := runHistory{st}.query("", runFilter{})
+	if err != nil || len(runs) != 1 || runs[0].Resources == nil || *runs[0].Resources != *res {
+		t.Errorf("recorded runs = %+v, %v", runs, err)
+	}
+	if m := cfg.Metrics.get(); m.Processes != 1 || m.PeakMaxRSSKB != res.MaxRSSKB {
+		t.Errorf("metrics = %+v", m)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// maxRSSKB is not reported on Windows.
+func maxRSSKB(*os.ProcessState) int64 {
+	return 0
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	releaseRepo       = "TIZ36/opencodecli-mcp"
+	githubAPI         = "https://api.github.com"
+	checksumsAsset    = "checksums.txt"
+	signatureAsset    = "checksums.txt.sig" // ed25519 signature of checksums.txt
+	updateHTTPTimeout = 5 * time.Minute
+)
+
+// releasePublicKey is the base64 ed25519 key release checksums are signed
+// with. It is set at build time (-ldflags "-X main.releasePublicKey=...");
+// when empty only checksums are verified.
+var releasePublicKey string
+
+type githubRelease struct {
+	TagName string        `json:"tag_name"`
+	Assets  []githubAsset `json:"assets"`
+}
+
+type githubAsset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+// updateOptions configures selfUpdate.
+type updateOptions struct {
+	APIBase   string
+	Repo      string
+	Version   string // release tag to install; empty means latest
+	Exe       string // binary to replace
+	PublicKey string
+	CheckOnly bool
+	Force     bool // reinstall even when already up to date
+}
+
+// releaseAssetName is the binary asset for the running platform.
+func releaseAssetName() string {
+	name := fmt.Sprintf("mcpserver_%s_%s", runtime.GOOS, runtime.GOARCH)
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// runSelfUpdate implements the self-update subcommand.
+func runSelfUpdate(args []string) error {
+	fs := flag.NewFlagSet("self-update", flag.ContinueOnError)
+	opts := updateOptions{APIBase: githubAPI, PublicKey: releasePublicKey}
+	fs.StringVar(&opts.Repo, "repo", releaseRepo, "GitHub repository to update from")
+	fs.StringVar(&opts.Version, "version", "", "release tag to install (default: latest)")
+	fs.BoolVar(&opts.CheckOnly, "check", false, "only report whether an update is available")
+	fs.BoolVar(&opts.Force, "force", false, "reinstall even if already up to date")
+	restartPID := fs.Int("restart", 0, "PID of a running server to restart gracefully after updating")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if opts.Exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), updateHTTPTimeout)
+	defer cancel()
+	updated, err := selfUpdate(ctx, opts)
+	if err != nil || !updated || *restartPID == 0 {
+		return err
+	}
+	p, err := os.FindProcess(*restartPID)
+	if err != nil {
+		return err
+	}
+	if err := p.Signal(syscall.SIGHUP); err != nil {
+		return fmt.Errorf("restart pid %d: %w", *restartPID, err)
+	}
+	fmt.Printf("Asked server (pid %d) to restart\n", *restartPID)
+	return nil
+}
+
+// selfUpdate downloads the release binary, verifies it against the signed
+// checksums and atomically replaces opts.Exe. It reports whether the binary
+// was replaced.
+func selfUpdate(ctx context.Context, opts updateOptions) (bool, error) {
+	rel, err := fetchRelease(ctx, opts)
+	if err != nil {
+		return false, err
+	}
+	latest := strings.TrimPrefix(rel.TagName, "v")
+	if compareVersions(latest, serverVersion) <= 0 && opts.Version == "" && !opts.Force {
+		fmt.Printf("mcpserver %s is up to date (latest release %s)\n", serverVersion, rel.TagName)
+		return false, nil
+	}
+	if opts.CheckOnly {
+		fmt.Printf("Update available: %s -> %s\n", serverVersion, rel.TagName)
+		return false, nil
+	}
+
+	assets := map[string]string{}
+	for _, a := range rel.Assets {
+		assets[a.Name] = a.URL
+	}
+	name := releaseAssetName()
+	if assets[name] == "" || assets[checksumsAsset] == "" {
+		return false, fmt.Errorf("release %s has no %s or %s", rel.TagName, name, checksumsAsset)
+	}
+
+	checksums, err := download(ctx, assets[checksumsAsset])
+	if err != nil {
+		return false, err
+	}
+	if opts.PublicKey != "" {
+		if assets[signatureAsset] == "" {
+			return false, fmt.Errorf("release %s is not signed (%s missing)", rel.TagName, signatureAsset)
+		}
+		sig, err := download(ctx, assets[signatureAsset])
+		if err != nil {
+			return false, err
+		}
+		if err := verifySignature(opts.PublicKey, checksums, sig); err != nil {
+			return false, err
+		}
+	} else {
+		fmt.Fprintln(os.Stderr, "warning: no release public key built in; verifying checksum only")
+	}
+	want, err := checksumFor(checksums, name)
+	if err != nil {
+		return false, err
+	}
+
+	binary, err := download(ctx, assets[name])
+	if err != nil {
+		return false, err
+	}
+	sum := sha256.Sum256(binary)
+	if got := hex.EncodeToString(sum[:]); got != want {
+		return false, fmt.Errorf("checksum mismatch for %s: got %s, want %s", name, got, want)
+	}
+	if err := replaceBinary(opts.Exe, binary); err != nil {
+		return false, err
+	}
+	fmt.Printf("Updated %s: %s -> %s\n", opts.Exe, serverVersion, rel.TagName)
+	return true, nil
+}
+
+func fetchRelease(ctx context.Context, opts updateOptions) (githubRelease, error) {
+	var rel githubRelease
+	url := fmt.Sprintf("%s/repos/%s/releases/latest", strings.TrimRight(opts.APIBase, "/"), opts.Repo)
+	if opts.Version != "" {
+		url = fmt.Sprintf("%s/repos/%s/releases/tags/%s", strings.TrimRight(opts.APIBase, "/"), opts.Repo, opts.Version)
+	}
+	body, err := download(ctx, url)
+	if err != nil {
+		return rel, err
+	}
+	if err := json.Unmarshal(body, &rel); err != nil {
+		return rel, fmt.Errorf("parse release: %w", err)
+	}
+	return rel, nil
+}
+
+func download(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// checksumFor finds name in a sha256sum-style checksums file.
+func checksumFor(checksums []byte, name string) (string, error) {
+	sc := bufio.NewScanner(bytes.NewReader(checksums))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("%s not listed in %s", name, checksumsAsset)
+}
+
+func verifySignature(publicKey string, msg, sig []byte) error {
+	key, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return errors.New("invalid release public key")
+	}
+	if raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig))); err == nil {
+		sig = raw
+	}
+	if !ed25519.Verify(ed25519.PublicKey(key), msg, sig) {
+		return fmt.Errorf("%s signature verification failed", checksumsAsset)
+	}
+	return nil
+}
+
+// replaceBinary atomically swaps exe for data, keeping its permissions.
+func replaceBinary(exe string, data []byte) error {
+	info, err := os.Stat(exe)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(exe), ".mcpserver-update-*")
+	if err != nil {
+		return fmt.Errorf("replace binary: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("replace binary: %w", err)
+	}
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("replace binary: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("replace binary: %w", err)
+	}
+	if runtime.GOOS == "windows" {
+		// A running executable can't be overwritten, but it can be renamed.
+		old := exe + ".old"
+		_ = os.Remove(old)
+		if err := os.Rename(exe, old); err != nil {
+			return fmt.Errorf("replace binary: %w", err)
+		}
+	}
+	if err := os.Rename(tmp.Name(), exe); err != nil {
+		return fmt.Errorf("replace binary: %w", err)
+	}
+	return nil
+}
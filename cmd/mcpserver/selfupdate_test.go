@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeRelease serves a GitHub release of version tag with the given assets.
+func fakeRelease(t *testing.T, tag string, assets map[string][]byte) *httptest.Server {
+	t.Helper()
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/repos/") {
+			rel := githubRelease{TagName: tag}
+			for name := range assets {
+				rel.Assets = append(rel.Assets, githubAsset{Name: name, URL: srv.URL + "/download/" + name})
+			}
+			_ = json.NewEncoder(w).Encode(rel)
+			return
+		}
+		data, ok := assets[strings.TrimPrefix(r.URL.Path, "/download/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(data)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// Test self-update verifies signature and checksum before swapping the binary
+func TestSelfUpdate(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newBinary := []byte("#!/bin/sh\necho new\n")
+	sum := sha256.Sum256(newBinary)
+	checksums := []byte(fmt.Sprintf("%s  %s\n", hex.EncodeToString(sum[:]), releaseAssetName()))
+	signed := map[string][]byte{
+		releaseAssetName(): newBinary,
+		checksumsAsset:     checksums,
+		signatureAsset:     []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(priv, checksums))),
+	}
+
+	exe := filepath.Join(t.TempDir(), "mcpserver")
+	reset := func() {
+		if err := os.WriteFile(exe, []byte("old"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	opts := func(srv *httptest.Server) updateOptions {
+		return updateOptions{APIBase: srv.URL, Repo: "o/r", Exe: exe, PublicKey: base64.StdEncoding.EncodeToString(pub)}
+	}
+	content := func() string {
+		b, _ := os.ReadFile(exe)
+		return string(b)
+	}
+
+	reset()
+	if updated, err := selfUpdate(context.Background(), opts(fakeRelease(t, "v99.0.0", signed))); err != nil || !updated {
+		t.Fatalf("selfUpdate = %v, %v", updated, err)
+	}
+	if content() != string(newBinary) {
+		t.Errorf("binary not replaced: %q", content())
+	}
+	if info, _ := os.Stat(exe); info.Mode().Perm() != 0755 {
+		t.Errorf("mode = %v", info.Mode())
+	}
+
+	reset()
+	if updated, err := selfUpdate(context.Background(), opts(fakeRelease(t, "v"+serverVersion, signed))); err != nil || updated {
+		t.Errorf("same version: selfUpdate = %v, %v", updated, err)
+	}
+
+	tampered := map[string][]byte{}
+	for k, v := range signed {
+		tampered[k] = v
+	}
+	tampered[releaseAssetName()] = []byte("evil")
+	unsigned := map[string][]byte{releaseAssetName(): newBinary, checksumsAsset: checksums}
+	forged := map[string][]byte{releaseAssetName(): newBinary, checksumsAsset: checksums, signatureAsset: []byte("AAAA")}
+	for name, assets := range map[string]map[string][]byte{"tampered": tampered, "unsigned": unsigned, "forged": forged} {
+		reset()
+		if _, err := selfUpdate(context.Background(), opts(fakeRelease(t, "v99.0.0", assets))); err == nil {
+			t.Errorf("%s release: selfUpdate succeeded", name)
+		}
+		if content() != "old" {
+			t.Errorf("%s release replaced the binary", name)
+		}
+	}
+}
+
+// Test checksum file parsing
+func TestChecksumFor(t *testing.T) {
+	checksums := []byte("abc123  other\nDEF456 *mcpserver_linux_amd64\n")
+	if got, err := checksumFor(checksums, "mcpserver_linux_amd64"); err != nil || got != "def456" {
+		t.Errorf("checksumFor = %q, %v", got, err)
+	}
+	if _, err := checksumFor(checksums, "missing"); err == nil {
+		t.Error("checksumFor(missing) = nil error")
+	}
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Backends for run/models/session/agent tools
+const (
+	backendCLI   = "cli"   // spawn MCP_TARGET and parse its output
+	backendServe = "serve" // talk to a running `opencode serve` over HTTP
+
+	defaultServeURL = "http://127.0.0.1:4096"
+)
+
+// servedTools are the tools answered by the serve backend; everything else
+// (opencode_exec, custom and plugin tools) always uses the CLI.
+var servedTools = map[string]bool{
+	toolRun:         true,
+	toolModels:      true,
+	toolSessionList: true,
+	toolAgentList:   true,
+}
+
+// serveClient is a minimal client for the `opencode serve` HTTP API.
+type serveClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+type serveSession struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	Time  struct {
+		Created int64 `json:"created"`
+		Updated int64 `json:"updated"`
+	} `json:"time"`
+}
+
+type serveAgent struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Mode        string `json:"mode"`
+}
+
+type serveMessage struct {
+	Info struct {
+		ID    string          `json:"id"`
+		Error json.RawMessage `json:"error,omitempty"`
+	} `json:"info"`
+	Parts []map[string]any `json:"parts"`
+}
+
+func newServeClient(baseURL string) *serveClient {
+	if baseURL == "" {
+		baseURL = defaultServeURL
+	}
+	return &serveClient{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client:  &http.Client{},
+	}
+}
+
+func (c *serveClient) url(path, dir string) string {
+	u := c.baseURL + path
+	if dir != "" {
+		u += "?" + url.Values{"directory": {dir}}.Encode()
+	}
+	return u
+}
+
+// do sends a JSON request and decodes the JSON response into out.
+func (c *serveClient) do(ctx context.Context, method, path, dir string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.url(path, dir), reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("opencode serve: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("opencode serve: %s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// listModels returns the models of every provider from GET /config/providers.
+func (c *serveClient) listModels(ctx context.Context) ([]modelInfo, error) {
+	var resp struct {
+		Providers []struct {
+			ID     string `json:"id"`
+			Models map[string]struct {
+				Name string     `json:"name"`
+				Cost *modelCost `json:"cost"`
+			} `json:"models"`
+		} `json:"providers"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/config/providers", "", nil, &resp); err != nil {
+		return nil, err
+	}
+	var models []modelInfo
+	for _, p := range resp.Providers {
+		for id, m := range p.Models {
+			info := newModelInfo(p.ID+"/"+id, m.Name)
+			info.Cost = m.Cost
+			models = append(models, info)
+		}
+	}
+	sort.Slice(models, func(i, j int) bool { return models[i].ID < models[j].ID })
+	return models, nil
+}
+
+// listSessions returns the sessions of dir, most recently updated first.
+func (c *serveClient) listSessions(ctx context.Context, dir string) ([]serveSession, error) {
+	var sessions []serveSession
+	if err := c.do(ctx, http.MethodGet, "/session", dir, nil, &sessions); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].Time.Updated > sessions[j].Time.Updated
+	})
+	return sessions, nil
+}
+
+func (c *serveClient) listAgents(ctx context.Context) ([]serveAgent, error) {
+	var agents []serveAgent
+	if err := c.do(ctx, http.MethodGet, "/agent", "", nil, &agents); err != nil {
+		return nil, err
+	}
+	return agents, nil
+}
+
+// callTool answers a served tool via the HTTP API.
+func (c *serveClient) callTool(ctx context.Context, cfg serverConfig, call *toolCall) (*toolCallResult, *mcpError) {
+	var lines []string
+	var structured any
+	switch call.Name {
+	case toolRun:
+		return c.run(ctx, cfg, call)
+
+	case toolModels:
+		models, err := c.listModels(ctx)
+		if err != nil {
+			return failedRun(err), nil
+		}
+		for _, m := range models {
+			lines = append(lines, m.ID)
+		}
+
+	case toolSessionList:
+		sessions, err := c.listSessions(ctx, call.Cwd)
+		if err != nil {
+			return failedRun(err), nil
+		}
+		return sessionListResult(call, sessions), nil
+
+	case toolAgentList:
+		agents, err := c.listAgents(ctx)
+		if err != nil {
+			return failedRun(err), nil
+		}
+		entries := make([]agentEntry, 0, len(agents))
+		for _, a := range agents {
+			entries = append(entries, agentEntry{Name: a.Name, Mode: a.Mode, Description: a.Description})
+			line := a.Name
+			if a.Mode != "" {
+				line += " (" + a.Mode + ")"
+			}
+			if a.Description != "" {
+				line += ": " + a.Description
+			}
+			lines = append(lines, line)
+		}
+		structured = map[string]any{"agents": entries}
+	}
+
+	ec := newEventCollector(call)
+	for _, line := range lines {
+		ec.rawLine(line)
+	}
+	result := ec.result("", 0)
+	result.StructuredContent = structured
+	return result, nil
+}
+
+// sessionListResult is the opencode_session_list result for sessions: a
+// "ID  Title  Updated" line each, and the entries as structuredContent.
+func sessionListResult(call *toolCall, sessions []serveSession) *toolCallResult {
+	ec := newEventCollector(call)
+	entries := make([]sessionEntry, 0, len(sessions))
+	for _, s := range sessions {
+		entries = append(entries, newSessionEntry(s))
+		updated := localTime(time.UnixMilli(s.Time.Updated)).Format(time.RFC3339)
+		ec.rawLine(fmt.Sprintf("%s  %s  %s", s.ID, s.Title, updated))
+	}
+	result := ec.result("", 0)
+	result.StructuredContent = map[string]any{"sessions": entries}
+	return result
+}
+
+// run sends the prompt to a session and maps the server's event stream to the
+// same notifications the CLI backend produces.
+func (c *serveClient) run(ctx context.Context, cfg serverConfig, call *toolCall) (*toolCallResult, *mcpError) {
+	runArgs, mErr := parseRunArgs(call)
+	if mErr != nil {
+		return nil, mErr
+	}
+	dir := runArgs.Cwd
+	if dir == "" {
+		dir = call.Cwd
+	}
+	if err := validateCwd(dir); err != nil {
+		return nil, errInvalidCwd.err(err.Error())
+	}
+	if err := cfg.Policy.checkDir(dir); err != nil {
+		return nil, errPolicyDenied.err(err.Error())
+	}
+
+	sessionID := runArgs.Session
+	if sessionID == "" && runArgs.Continue {
+		sessions, err := c.listSessions(ctx, dir)
+		if err != nil {
+			return failedRun(err), nil
+		}
+		if len(sessions) > 0 {
+			sessionID = sessions[0].ID
+		}
+	}
+	if sessionID == "" {
+		var created serveSession
+		if err := c.do(ctx, http.MethodPost, "/session", dir, map[string]any{}, &created); err != nil {
+			return failedRun(err), nil
+		}
+		sessionID = created.ID
+	}
+
+	body := map[string]any{"parts": buildServeParts(runArgs.Message, runArgs.Files, dir)}
+	if runArgs.Agent != "" {
+		body["agent"] = runArgs.Agent
+	}
+	model := runArgs.Model
+	if model == "" {
+		model = getDefaultModel(cfg)
+	}
+	if providerID, modelID, ok := strings.Cut(model, "/"); ok {
+		body["model"] = map[string]any{"providerID": providerID, "modelID": modelID}
+	}
+	log.Printf("[serve] run session=%s model=%s dir=%q message=%s", sessionID, model, dir, truncateForLog(runArgs.Message, 80))
+
+	ec := newEventCollector(call)
+	stream := newServePartStream(ec, sessionID)
+
+	// Subscribe before sending the prompt so no events are missed.
+	eventsCtx, stopEvents := context.WithCancel(ctx)
+	eventsDone := make(chan struct{})
+	if err := c.subscribe(eventsCtx, dir, stream, eventsDone); err != nil {
+		log.Printf("[serve] event stream unavailable, results only: %v", err)
+		close(eventsDone)
+	}
+
+	var msg serveMessage
+	err := c.do(ctx, http.MethodPost, "/session/"+url.PathEscape(sessionID)+"/message", dir, body, &msg)
+	stopEvents()
+	<-eventsDone
+	if err != nil {
+		return failedRun(err), nil
+	}
+
+	// Replay any parts the event stream didn't deliver.
+	for _, part := range msg.Parts {
+		stream.part(part)
+	}
+
+	result := ec.result("", 0)
+	if len(msg.Info.Error) > 0 && string(msg.Info.Error) != "null" {
+		result.Content[0].Text += "\n[error] " + string(msg.Info.Error)
+		result.IsError = true
+		result.setError(classifyFailure(nil, string(msg.Info.Error)))
+	}
+	result.Content[0].Text += "\n[session: " + sessionID + "]"
+	result.sessionID = sessionID
+	return result, nil
+}
+
+// subscribe reads GET /event in the background and feeds session parts to
+// stream until ctx is cancelled. done is closed when the reader exits.
+func (c *serveClient) subscribe(ctx context.Context, dir string, stream *servePartStream, done chan struct{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url("/event", dir), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return fmt.Errorf("GET /event: %s", resp.Status)
+	}
+
+	go func() {
+		defer close(done)
+		defer resp.Body.Close()
+		_ = scanLines(resp.Body, maxEventLine, func(line string) bool {
+			data, ok := strings.CutPrefix(line, "data: ")
+			if !ok {
+				return true
+			}
+			var event struct {
+				Type       string `json:"type"`
+				Properties struct {
+					Part map[string]any `json:"part"`
+				} `json:"properties"`
+			}
+			if err := json.Unmarshal([]byte(data), &event); err != nil || event.Type != "message.part.updated" {
+				return true
+			}
+			stream.part(event.Properties.Part)
+			return true
+		})
+	}()
+	return nil
+}
+
+// servePartStream converts message parts of one session into CLI-style
+// events, emitting each part once it is complete.
+type servePartStream struct {
+	mu        sync.Mutex
+	ec        *eventCollector
+	sessionID string
+	emitted   map[string]bool
+}
+
+func newServePartStream(ec *eventCollector, sessionID string) *servePartStream {
+	return &servePartStream{ec: ec, sessionID: sessionID, emitted: make(map[string]bool)}
+}
+
+func (s *servePartStream) part(part map[string]any) {
+	if part == nil {
+		return
+	}
+	if sid, _ := part["sessionID"].(string); sid != "" && sid != s.sessionID {
+		return
+	}
+	event, ok := servePartEvent(part)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, _ := part["id"].(string)
+	if id != "" {
+		if s.emitted[id] {
+			return
+		}
+		s.emitted[id] = true
+	}
+	s.ec.event(event)
+}
+
+// servePartEvent maps a serve API message part to the equivalent
+// `opencode run --format json` event. Incomplete parts are skipped.
+func servePartEvent(part map[string]any) (map[string]any, bool) {
+	partType, _ := part["type"].(string)
+	switch partType {
+	case "text":
+		if t, ok := part["time"].(map[string]any); ok && t["end"] == nil {
+			return nil, false
+		}
+		return map[string]any{"type": "text", "part": part}, true
+	case "tool":
+		state, _ := part["state"].(map[string]any)
+		status, _ := state["status"].(string)
+		if status != "completed" && status != "error" {
+			return nil, false
+		}
+		return map[string]any{"type": "tool_use", "part": part}, true
+	case "step-start":
+		return map[string]any{"type": "step_start", "part": part}, true
+	case "step-finish":
+		return map[string]any{"type": "step_finish", "part": part}, true
+	}
+	return nil, false
+}
+
+// buildServeParts builds the prompt parts: the message plus one file part per
+// attachment (relative paths are resolved against dir).
+func buildServeParts(message string, files []string, dir string) []map[string]any {
+	parts := []map[string]any{{"type": "text", "text": message}}
+	for _, f := range files {
+		path := f
+		if !filepath.IsAbs(path) && dir != "" {
+			path = filepath.Join(dir, path)
+		}
+		mimeType := mime.TypeByExtension(filepath.Ext(path))
+		if mimeType == "" {
+			mimeType = "text/plain"
+		}
+		parts = append(parts, map[string]any{
+			"type":     "file",
+			"mime":     mimeType,
+			"filename": filepath.Base(path),
+			"url":      "file://" + path,
+		})
+	}
+	return parts
+}
+
+func errorResult(err error) *toolCallResult {
+	return &toolCallResult{
+		Content: []toolContent{{Type: "text", Text: err.Error()}},
+		IsError: true,
+	}
+}
+
+// failedRun is errorResult for a failed request to opencode, tagged with
+// its catalogue code.
+func failedRun(err error) *toolCallResult {
+	result := errorResult(err)
+	result.setError(classifyFailure(err, err.Error()))
+	return result
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newFakeOpencodeServe emulates the parts of the `opencode serve` API used by
+// the serve backend.
+func newFakeOpencodeServe(t *testing.T) (*httptest.Server, *[]map[string]any) {
+	t.Helper()
+	events := make(chan string, 16)
+	var mu sync.Mutex
+	var prompts []map[string]any
+
+	textPart := map[string]any{
+		"id": "p1", "sessionID": "ses_1", "type": "text", "text": "Hello from serve",
+		"time": map[string]any{"start": 1, "end": 2},
+	}
+	toolPart := map[string]any{
+		"id": "p2", "sessionID": "ses_1", "type": "tool", "tool": "read",
+		"state": map[string]any{"status": "completed", "output": "file body"},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /config/providers", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"providers":[{"id":"anthropic","models":{"claude-sonnet-4":{}}},{"id":"openai","models":{"gpt-5":{}}}]}`))
+	})
+	mux.HandleFunc("GET /session", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[{"id":"ses_old","title":"Old","time":{"created":1,"updated":1000}},{"id":"ses_1","title":"Latest","time":{"created":2,"updated":2000}}]`))
+	})
+	mux.HandleFunc("POST /session", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"id":"ses_1","title":"New"}`))
+	})
+	mux.HandleFunc("GET /agent", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[{"name":"build","mode":"primary","description":"Default agent"}]`))
+	})
+	mux.HandleFunc("GET /event", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case ev := <-events:
+				fmt.Fprintf(w, "data: %s\n\n", ev)
+				w.(http.Flusher).Flush()
+			}
+		}
+	})
+	mux.HandleFunc("POST /session/{id}/message", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		body["sessionID"] = r.PathValue("id")
+		mu.Lock()
+		prompts = append(prompts, body)
+		mu.Unlock()
+
+		for _, p := range []map[string]any{textPart, toolPart} {
+			b, _ := json.Marshal(map[string]any{"type": "message.part.updated", "properties": map[string]any{"part": p}})
+			events <- string(b)
+		}
+		// Give the subscriber a moment to read the events.
+		time.Sleep(50 * time.Millisecond)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"info":  map[string]any{"id": "msg_1"},
+			"parts": []any{textPart, toolPart},
+		})
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv, &prompts
+}
+
+// Test servePartEvent mapping
+func TestServePartEvent(t *testing.T) {
+	tests := []struct {
+		name     string
+		part     map[string]any
+		wantType string
+		wantOK   bool
+	}{
+		{name: "finished text", part: map[string]any{"type": "text", "text": "hi", "time": map[string]any{"end": 1}}, wantType: "text", wantOK: true},
+		{name: "streaming text", part: map[string]any{"type": "text", "text": "h", "time": map[string]any{"start": 1}}, wantOK: false},
+		{name: "completed tool", part: map[string]any{"type": "tool", "state": map[string]any{"status": "completed"}}, wantType: "tool_use", wantOK: true},
+		{name: "running tool", part: map[string]any{"type": "tool", "state": map[string]any{"status": "running"}}, wantOK: false},
+		{name: "step start", part: map[string]any{"type": "step-start"}, wantType: "step_start", wantOK: true},
+		{name: "step finish", part: map[string]any{"type": "step-finish"}, wantType: "step_finish", wantOK: true},
+		{name: "unknown", part: map[string]any{"type": "snapshot"}, wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev, ok := servePartEvent(tt.part)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && ev["type"] != tt.wantType {
+				t.Errorf("type = %v, want %v", ev["type"], tt.wantType)
+			}
+		})
+	}
+}
+
+// Test the serve backend tools
+func TestServeBackend(t *testing.T) {
+	srv, prompts := newFakeOpencodeServe(t)
+	cfg := serverConfig{
+		Target:         "/nonexistent/opencode",
+		DefaultTimeout: 5 * time.Second,
+		Backend:        backendServe,
+		ServeURL:       srv.URL,
+	}
+	tools := newToolHandler(cfg)
+
+	call := func(name, args string) (*toolCallResult, []any) {
+		var notes []any
+		var mu sync.Mutex
+		c := &toolCall{ID: json.RawMessage("1"), Name: name, Arguments: json.RawMessage(args)}
+		c.notify = func(msg any) {
+			mu.Lock()
+			notes = append(notes, msg)
+			mu.Unlock()
+		}
+		result, mErr := tools(context.Background(), c)
+		if mErr != nil {
+			t.Fatalf("%s: unexpected error: %v", name, mErr)
+		}
+		return result, notes
+	}
+
+	result, _ := call(toolModels, `{}`)
+	if text := result.Content[0].Text; !strings.Contains(text, "anthropic/claude-sonnet-4") || !strings.Contains(text, "openai/gpt-5") {
+		t.Errorf("models = %q", text)
+	}
+
+	result, _ = call(toolSessionList, `{}`)
+	if text := result.Content[0].Text; strings.Index(text, "ses_1") > strings.Index(text, "ses_old") {
+		t.Errorf("sessions should be sorted by update time: %q", text)
+	}
+	if s, _ := result.StructuredContent.(map[string]any); len(s["sessions"].([]sessionEntry)) != 2 {
+		t.Errorf("structuredContent = %v", result.StructuredContent)
+	}
+
+	result, _ = call(toolAgentList, `{}`)
+	if text := result.Content[0].Text; !strings.Contains(text, "build (primary): Default agent") {
+		t.Errorf("agents = %q", text)
+	}
+
+	result, notes := call(toolRun, `{"message":"hi","model":"anthropic/claude-sonnet-4","files":["main.go"],"cwd":"/tmp"}`)
+	if result.IsError {
+		t.Fatalf("run failed: %+v", result)
+	}
+	text := result.Content[0].Text
+	if strings.Count(text, "Hello from serve") != 1 {
+		t.Errorf("expected text exactly once, got %q", text)
+	}
+	if !strings.Contains(text, "[Tool: read]\nfile body") || !strings.Contains(text, "[session: ses_1]") {
+		t.Errorf("run result = %q", text)
+	}
+	if len(notes) == 0 {
+		t.Error("expected streamed notifications")
+	}
+
+	if len(*prompts) != 1 {
+		t.Fatalf("prompts = %d, want 1", len(*prompts))
+	}
+	prompt := (*prompts)[0]
+	model, _ := prompt["model"].(map[string]any)
+	if model["providerID"] != "anthropic" || model["modelID"] != "claude-sonnet-4" {
+		t.Errorf("model = %v", prompt["model"])
+	}
+	parts, _ := prompt["parts"].([]any)
+	if len(parts) != 2 {
+		t.Fatalf("parts = %v", parts)
+	}
+	if file, _ := parts[1].(map[string]any); file["url"] != "file:///tmp/main.go" {
+		t.Errorf("file part = %v", file)
+	}
+}
+
+// Test serve backend connection errors surface as tool errors
+func TestServeBackendUnavailable(t *testing.T) {
+	cfg := serverConfig{
+		DefaultTimeout: 2 * time.Second,
+		Backend:        backendServe,
+		ServeURL:       "http://127.0.0.1:1",
+	}
+	result, mErr := newToolHandler(cfg)(context.Background(), &toolCall{ID: json.RawMessage("1"), Name: toolSessionList, Arguments: json.RawMessage(`{}`)})
+	if mErr != nil {
+		t.Fatalf("unexpected protocol error: %v", mErr)
+	}
+	if !result.IsError || !strings.Contains(result.Content[0].Text, "opencode serve") {
+		t.Errorf("result = %+v", result)
+	}
+}
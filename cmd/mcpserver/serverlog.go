@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// serverLogger names the server's own log lines in notifications/message,
+// apart from the "opencode" logger of routed opencode events.
+const serverLogger = "opencode-mcp"
+
+// logf writes a server log line about the call and also sends it to the
+// client as a notifications/message entry at level, so clients that can't
+// see the server's stderr can follow what it does. Debug lines only go to
+// sessions that asked for them with logging/setLevel.
+func (c *toolCall) logf(level, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	log.Print(msg)
+	if level == "debug" && (c.Session == nil || !c.Session.wantsDebug()) {
+		return
+	}
+	c.Notify(map[string]any{
+		"jsonrpc": "2.0",
+		"method":  "notifications/message",
+		"params": map[string]any{
+			"level":  level,
+			"logger": serverLogger,
+			"data":   msg,
+		},
+	})
+}
+
+// wantsDebug reports whether the client set its logging level to debug.
+func (s *session) wantsDebug() bool {
+	return s.logLevelSet.Load() && s.logLevel.Load() == 0
+}
@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+// Test server log lines reach the client at their level, debug only on request
+func TestCallLogf(t *testing.T) {
+	sess := &session{}
+	var levels []string
+	call := &toolCall{Session: sess, notify: func(msg any) {
+		params := msg.(map[string]any)["params"].(map[string]any)
+		if params["logger"] != serverLogger {
+			t.Errorf("logger = %v", params["logger"])
+		}
+		levels = append(levels, params["level"].(string))
+	}}
+	log := func() {
+		call.logf("debug", "[test] preview")
+		call.logf("info", "[test] exec")
+		call.logf("warning", "[test] stderr")
+	}
+	log()
+	if len(levels) != 2 || levels[0] != "info" || levels[1] != "warning" {
+		t.Errorf("default levels sent = %v", levels)
+	}
+
+	levels = nil
+	sess.logLevelSet.Store(true)
+	log()
+	if len(levels) != 3 || levels[0] != "debug" {
+		t.Errorf("debug levels sent = %v", levels)
+	}
+
+	levels = nil
+	sess.logLevel.Store(int32(logLevelRank("warning")))
+	log()
+	if len(levels) != 1 || levels[0] != "warning" {
+		t.Errorf("warning levels sent = %v", levels)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"path/filepath"
+)
+
+// Within an MCP session, opencode_run calls that name no opencode session
+// continue the conversation of the session's first run in the same
+// directory, so clients get a continuous conversation without managing
+// session IDs. "session" and "continue" still pick one explicitly, and
+// "newSession": true starts over, the new session being continued from
+// then on.
+
+// opencodeSessions maps the directories an MCP session ran opencode in to
+// the opencode session its runs there continue.
+type opencodeSessions map[string]string
+
+// opencodeSession returns the opencode session runs of s in dir continue.
+func (s *session) opencodeSession(dir string) string {
+	s.opencodeMu.Lock()
+	defer s.opencodeMu.Unlock()
+	return s.opencode[dir]
+}
+
+// setOpencodeSession makes runs of s in dir continue the opencode session
+// id. Unless replace, a session already set is kept, so concurrent first
+// runs agree on the earliest to finish.
+func (s *session) setOpencodeSession(dir, id string, replace bool) bool {
+	s.opencodeMu.Lock()
+	defer s.opencodeMu.Unlock()
+	if s.opencode[dir] == id || (!replace && s.opencode[dir] != "") {
+		return false
+	}
+	if s.opencode == nil {
+		s.opencode = opencodeSessions{}
+	}
+	s.opencode[dir] = id
+	return true
+}
+
+// opencodeSnapshot copies the opencode sessions of s, for the store.
+func (s *session) opencodeSnapshot() opencodeSessions {
+	s.opencodeMu.Lock()
+	defer s.opencodeMu.Unlock()
+	if len(s.opencode) == 0 {
+		return nil
+	}
+	m := make(opencodeSessions, len(s.opencode))
+	for dir, id := range s.opencode {
+		m[dir] = id
+	}
+	return m
+}
+
+// refreshOpencodeSessions adopts the opencode sessions another instance
+// sharing the store has recorded for s since.
+func (s *session) refreshOpencodeSessions(st *store) {
+	var stored storedSession
+	if ok, err := st.get(sessionsCollection, s.id, &stored); !ok || err != nil {
+		return
+	}
+	for dir, id := range stored.OpencodeSessions {
+		s.setOpencodeSession(dir, id, false)
+	}
+}
+
+// sessionMapMiddleware continues the MCP session's opencode session in
+// opencode_run calls that don't choose one, and records the session the
+// first run creates.
+func sessionMapMiddleware(cfg serverConfig) toolMiddleware {
+	return func(next toolHandler) toolHandler {
+		return func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+			sess := call.Session
+			if call.Name != toolRun || sess == nil {
+				return next(ctx, call)
+			}
+			var args map[string]any
+			if json.Unmarshal(call.Arguments, &args) != nil {
+				return next(ctx, call)
+			}
+			explicit, _ := args["session"].(string)
+			cont, _ := args["continue"].(bool)
+			fresh, _ := args["newSession"].(bool)
+			if explicit != "" || cont {
+				return next(ctx, call)
+			}
+			cwd, _ := args["cwd"].(string)
+			if cwd == "" {
+				cwd = call.Cwd
+			}
+			dir := cwd
+			if dir != "" {
+				dir = filepath.Clean(dir)
+			}
+			if cfg.Store != nil {
+				sess.refreshOpencodeSessions(cfg.Store)
+			}
+			delete(args, "newSession")
+			if id := sess.opencodeSession(dir); id != "" && !fresh {
+				args["session"] = id
+				log.Printf("[sessions] session=%s continues opencode session %s in %q", sess.id, id, dir)
+			}
+			call.Arguments, _ = json.Marshal(args)
+
+			result, mErr := next(ctx, call)
+			if result != nil && !result.IsError && result.sessionID != "" && sess.setOpencodeSession(dir, result.sessionID, fresh) {
+				log.Printf("[sessions] session=%s maps %q to opencode session %s", sess.id, dir, result.sessionID)
+				if cfg.Store != nil {
+					saveSession(cfg.Store, sess)
+				}
+			}
+			return result, mErr
+		}
+	}
+}
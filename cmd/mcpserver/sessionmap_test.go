@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test opencode_run calls of an MCP session continue the opencode session
+// of its first run, per directory, unless they choose one
+func TestSessionMapping(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "opencode")
+	content := `#!/bin/sh
+[ "$1" = models ] && exit 0
+n=$(ls "` + dir + `" | grep -c '^run')
+touch "` + dir + `/run$n"
+echo "{\"type\":\"text\",\"sessionID\":\"ses_$n\",\"part\":{\"text\":\"$*\"}}"
+`
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	st, _ := openStore("")
+	sessions := &sessionStore{sessions: make(map[string]*session), store: st}
+	sess := sessions.create("", clientCapabilities{})
+	tools := newToolHandler(serverConfig{Target: script, DefaultTimeout: 5 * time.Second, Store: st})
+	run := func(s *session, args string) (string, string) {
+		t.Helper()
+		result, mErr := tools(context.Background(), &toolCall{ID: json.RawMessage("1"), Name: toolRun, Session: s, Arguments: json.RawMessage(args)})
+		if mErr != nil || result.IsError {
+			t.Fatalf("run %s: %+v, %+v", args, result, mErr)
+		}
+		return result.answer, result.sessionID
+	}
+
+	if answer, id := run(sess, `{"message":"a","model":"m"}`); strings.Contains(answer, "--session") || id != "ses_0" {
+		t.Fatalf("first run: %q in %s", answer, id)
+	}
+	if answer, _ := run(sess, `{"message":"b","model":"m"}`); !strings.Contains(answer, "--session ses_0") {
+		t.Errorf("second run didn't continue ses_0: %q", answer)
+	}
+	if answer, _ := run(sess, `{"message":"c","model":"m","session":"ses_x"}`); !strings.Contains(answer, "--session ses_x") {
+		t.Errorf("explicit session overridden: %q", answer)
+	}
+	if answer, _ := run(sess, `{"message":"d","model":"m","cwd":"`+dir+`"}`); strings.Contains(answer, "--session") {
+		t.Errorf("other directory continued a session: %q", answer)
+	}
+	if answer, id := run(sess, `{"message":"e","model":"m","newSession":true}`); strings.Contains(answer, "--session") || id != "ses_4" {
+		t.Errorf("newSession run: %q in %s", answer, id)
+	}
+	if answer, _ := run(sess, `{"message":"f","model":"m"}`); !strings.Contains(answer, "--session ses_4") {
+		t.Errorf("run after newSession didn't continue ses_4: %q", answer)
+	}
+	if answer, _ := run(nil, `{"message":"g","model":"m"}`); strings.Contains(answer, "--session") {
+		t.Errorf("run without an MCP session continued one: %q", answer)
+	}
+
+	// Another instance sharing the store picks up the mapping
+	other := &sessionStore{sessions: make(map[string]*session), store: st}
+	if answer, _ := run(other.get(sess.id), `{"message":"h","model":"m"}`); !strings.Contains(answer, "--session ses_4") {
+		t.Errorf("run on another instance: %q", answer)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// signer signs run manifests with the server's Ed25519 key (MCP_SIGNING_KEY),
+// so downstream automation can check a result came from this instance
+// unmodified. A nil *signer signs nothing.
+type signer struct {
+	key   ed25519.PrivateKey
+	keyID string
+}
+
+// attestation is a signed run manifest. Payload is the manifest JSON exactly
+// as signed; verifiers check Signature over the decoded payload bytes, then
+// parse them.
+type attestation struct {
+	Algorithm string `json:"algorithm"` // always Ed25519
+	KeyID     string `json:"keyId"`
+	Payload   string `json:"payload"`   // base64
+	Signature string `json:"signature"` // base64
+}
+
+// loadSigner reads a PEM-encoded PKCS#8 Ed25519 private key, as written by
+// `openssl genpkey -algorithm ed25519`.
+func loadSigner(path string) (*signer, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("signing key: %w", err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("signing key %s: no PEM block", path)
+	}
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("signing key %s: %w", path, err)
+	}
+	key, ok := k.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key %s: not an Ed25519 key", path)
+	}
+	return newSigner(key), nil
+}
+
+func newSigner(key ed25519.PrivateKey) *signer {
+	sum := sha256.Sum256(key.Public().(ed25519.PublicKey))
+	return &signer{key: key, keyID: hex.EncodeToString(sum[:8])}
+}
+
+func (s *signer) sign(payload []byte) *attestation {
+	if s == nil {
+		return nil
+	}
+	return &attestation{
+		Algorithm: "Ed25519",
+		KeyID:     s.keyID,
+		Payload:   base64.StdEncoding.EncodeToString(payload),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, payload)),
+	}
+}
+
+// registerSigningRoutes adds GET /signing-key, which publishes the public
+// key attestations are checked against.
+func registerSigningRoutes(mux *http.ServeMux, cfg serverConfig) {
+	if cfg.Signer == nil {
+		return
+	}
+	pub := cfg.Signer.key.Public().(ed25519.PublicKey)
+	der, _ := x509.MarshalPKIXPublicKey(pub)
+	mux.HandleFunc("GET /signing-key", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{
+			"algorithm": "Ed25519",
+			"keyId":     cfg.Signer.keyID,
+			"publicKey": base64.StdEncoding.EncodeToString(pub),
+			"pem":       string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+		})
+	})
+}
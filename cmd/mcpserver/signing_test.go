@@ -0,0 +1,49 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Test the signing key loads from PKCS#8 PEM and its public half is served
+func TestSigningKey(t *testing.T) {
+	pub, key, _ := ed25519.GenerateKey(nil)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := loadSigner(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadSigner(filepath.Join(t.TempDir(), "missing.pem")); err == nil {
+		t.Error("missing key file loaded")
+	}
+
+	mux := http.NewServeMux()
+	registerSigningRoutes(mux, serverConfig{Signer: s})
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/signing-key", nil))
+	var resp struct {
+		KeyID     string `json:"keyId"`
+		PublicKey string `json:"publicKey"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("%v: %s", err, w.Body)
+	}
+	if resp.PublicKey != base64.StdEncoding.EncodeToString(pub) || resp.KeyID != s.sign([]byte("x")).KeyID {
+		t.Errorf("GET /signing-key = %s", w.Body)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"opencode-mcp/internal/mcp"
+)
+
+// Command output isn't always text: opencode_exec and /exec can print an
+// archive or an image. Converted to a JSON string, such output is mangled
+// beyond repair, so with MCP_BINARY_OUTPUT=resource (the default) binary
+// output is sniffed and delivered base64-encoded with its MIME type.
+// MCP_BINARY_OUTPUT=text keeps the lossy text conversion.
+const (
+	binaryOutputResource = "resource"
+	binaryOutputText     = "text"
+)
+
+func validateBinaryOutput(s string) error {
+	if s != "" && s != binaryOutputResource && s != binaryOutputText {
+		return fmt.Errorf("%q is not %q or %q", s, binaryOutputResource, binaryOutputText)
+	}
+	return nil
+}
+
+// binarySafe reports whether binary output is delivered base64-encoded.
+func (cfg serverConfig) binarySafe() bool {
+	return cfg.BinaryOutput != binaryOutputText
+}
+
+// sniffBinary reports whether data is binary rather than text, and its MIME
+// type if so. A partial chunk of a stream may end mid-rune, so for those
+// only the sniffed type decides.
+func sniffBinary(data []byte, partial bool) (mimeType string, binary bool) {
+	if len(data) == 0 {
+		return "", false
+	}
+	mimeType, _, _ = strings.Cut(http.DetectContentType(data), ";")
+	if strings.HasPrefix(mimeType, "text/") {
+		if bytes.IndexByte(data, 0) < 0 && (partial || utf8.Valid(data)) {
+			return "", false
+		}
+		mimeType = "application/octet-stream"
+	}
+	return mimeType, true
+}
+
+// binaryOutputURI names the binary output of a tool call in its result.
+const binaryOutputURI = "opencode://output/stdout"
+
+// setBinaryOutput replaces the text of a result whose command printed
+// binary data with a summary, followed by the data as a blob resource.
+func (r *toolCallResult) setBinaryOutput(data []byte, mimeType, stderr string, exitCode int) {
+	text := fmt.Sprintf("[binary output: %d bytes of %s]", len(data), mimeType)
+	if stderr != "" {
+		text += "\n\n[stderr]\n" + stderr
+	}
+	if exitCode != 0 {
+		text += fmt.Sprintf("\n[exit code: %d]", exitCode)
+	}
+	r.Content = []toolContent{
+		{Type: "text", Text: text},
+		{Type: "resource", Resource: &mcp.ResourceContents{
+			URI:      binaryOutputURI,
+			MimeType: mimeType,
+			Blob:     base64.StdEncoding.EncodeToString(data),
+		}},
+	}
+	r.answer = ""
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pngHeader starts every PNG file.
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+// Test text passes and binary data is recognized with its type
+func TestSniffBinary(t *testing.T) {
+	tests := []struct {
+		data    []byte
+		partial bool
+		want    string // MIME type; "" for text
+	}{
+		{[]byte("hello\nworld\n"), false, ""},
+		{[]byte("<html><body>hi</body></html>"), false, ""},
+		{[]byte("héllo"), false, ""},
+		{[]byte("h\xc3"), true, ""}, // a rune split across chunks
+		{[]byte("h\xc3"), false, "application/octet-stream"},
+		{pngHeader, false, "image/png"},
+		{[]byte("\x1f\x8b\x08\x00\x00\x00\x00\x00"), false, "application/x-gzip"},
+		{[]byte("text\x00with nul"), false, "application/octet-stream"},
+	}
+	for _, tt := range tests {
+		mimeType, binary := sniffBinary(tt.data, tt.partial)
+		if binary != (tt.want != "") || mimeType != tt.want {
+			t.Errorf("sniffBinary(%q, %v) = %q, %v; want %q", tt.data, tt.partial, mimeType, binary, tt.want)
+		}
+	}
+}
+
+// Test binary opencode_exec output comes back as a base64 resource, intact
+func TestExecBinaryOutput(t *testing.T) {
+	dir := t.TempDir()
+	image := append(append([]byte{}, pngHeader...), "\n\x00\xff body \r\n"...)
+	if err := os.WriteFile(filepath.Join(dir, "out.png"), image, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	script := filepath.Join(dir, "opencode")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\ncat "+filepath.Join(dir, "out.png")+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	call := func(mode string) *toolCallResult {
+		t.Helper()
+		cfg := serverConfig{Target: script, DefaultTimeout: 5 * time.Second, BinaryOutput: mode}
+		result, mErr := newToolHandler(cfg)(context.Background(), &toolCall{Name: toolExec, Arguments: json.RawMessage(`{"args":["export"]}`)})
+		if mErr != nil {
+			t.Fatal(mErr)
+		}
+		return result
+	}
+
+	result := call(binaryOutputResource)
+	if len(result.Content) != 2 || result.Content[1].Resource == nil {
+		t.Fatalf("content = %+v", result.Content)
+	}
+	if !strings.Contains(result.Content[0].Text, "image/png") {
+		t.Errorf("summary = %q", result.Content[0].Text)
+	}
+	res := result.Content[1].Resource
+	got, _ := base64.StdEncoding.DecodeString(res.Blob)
+	if res.MimeType != "image/png" || !bytes.Equal(got, image) {
+		t.Errorf("resource = %s %q, want the file intact", res.MimeType, got)
+	}
+
+	if result := call(binaryOutputText); len(result.Content) != 1 || result.Content[0].Resource != nil {
+		t.Errorf("text mode content = %+v", result.Content)
+	}
+}
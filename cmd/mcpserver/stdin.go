@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"sync/atomic"
+	"time"
+)
+
+// The stdin of /exec, /exec/stream and opencode_exec is either text
+// ("stdin") or base64 ("stdinBase64") for binary input, which JSON strings
+// can't carry. It is capped at MCP_STDIN_MAX_BYTES, and a command that
+// hasn't read all of it after MCP_STDIN_TIMEOUT is killed, rather than
+// holding the data and its run slot until the call times out.
+const (
+	defaultStdinMaxBytes = 1 << 20
+	defaultStdinTimeout  = 30 * time.Second
+)
+
+// stdinConfig limits the stdin of exec calls; 0 disables a limit.
+type stdinConfig struct {
+	MaxBytes int
+	Timeout  time.Duration
+}
+
+// errStdinTooLarge is returned for stdin over MaxBytes.
+var errStdinTooLarge = errors.New("stdin exceeds MCP_STDIN_MAX_BYTES")
+
+// stdin returns the decoded stdin of a, checked against c.
+func (c stdinConfig) stdin(a execArgs) ([]byte, error) {
+	data := []byte(a.Stdin)
+	if a.StdinBase64 != "" {
+		if a.Stdin != "" {
+			return nil, errors.New("pass either stdin or stdinBase64, not both")
+		}
+		if c.MaxBytes > 0 && base64.StdEncoding.DecodedLen(len(a.StdinBase64)) > c.MaxBytes+2 {
+			return nil, errStdinTooLarge
+		}
+		var err error
+		if data, err = base64.StdEncoding.DecodeString(a.StdinBase64); err != nil {
+			return nil, fmt.Errorf("invalid stdinBase64: %v", err)
+		}
+	}
+	if c.MaxBytes > 0 && len(data) > c.MaxBytes {
+		return nil, errStdinTooLarge
+	}
+	return data, nil
+}
+
+// bodyLimit caps the body of an /exec request: the stdin as JSON-escaped
+// text, at up to 6 bytes per byte, plus room for the other fields.
+func (c stdinConfig) bodyLimit() int64 {
+	if c.MaxBytes <= 0 {
+		return -1
+	}
+	return 6*int64(c.MaxBytes) + 64<<10
+}
+
+// decodeExecArgs decodes the body of an /exec request into req, returning
+// its stdin. It answers bad requests itself, returning false.
+func decodeExecArgs(w http.ResponseWriter, r *http.Request, c stdinConfig, req *execArgs) ([]byte, bool) {
+	if n := c.bodyLimit(); n > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, n)
+	}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, errStdinTooLarge.Error(), http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(w, "invalid JSON", http.StatusBadRequest)
+		}
+		return nil, false
+	}
+	if len(req.Args) == 0 {
+		http.Error(w, "missing args", http.StatusBadRequest)
+		return nil, false
+	}
+	stdin, err := c.stdin(*req)
+	switch {
+	case errors.Is(err, errStdinTooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return nil, false
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return stdin, true
+}
+
+// stdinFeed is the stdin of a command, which it must have read within the
+// deadline.
+type stdinFeed struct {
+	data     []byte
+	off      int
+	written  atomic.Int64 // what the command was handed: all but the last chunk read
+	timedOut atomic.Bool
+	timer    *time.Timer
+}
+
+// feedStdin sets data as the stdin of cmd. Unless timeout is 0, kill is
+// called when cmd hasn't read all of it timeout after the call, e.g. a
+// command that doesn't read stdin and keeps running.
+func feedStdin(cmd *exec.Cmd, data []byte, timeout time.Duration, kill func()) *stdinFeed {
+	f := &stdinFeed{data: data}
+	cmd.Stdin = f
+	if timeout > 0 && len(data) > 0 {
+		f.timer = time.AfterFunc(timeout, func() {
+			if f.written.Load() < int64(len(f.data)) {
+				f.timedOut.Store(true)
+				kill()
+			}
+		})
+	}
+	return f
+}
+
+// Read is called by the goroutine copying stdin into the pipe, once the
+// previous chunk is written.
+func (f *stdinFeed) Read(p []byte) (int, error) {
+	f.written.Store(int64(f.off))
+	if f.off >= len(f.data) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.data[f.off:])
+	f.off += n
+	return n, nil
+}
+
+// done stops the deadline once the command has exited, reporting whether
+// it was killed for not reading its stdin.
+func (f *stdinFeed) done() bool {
+	if f.timer != nil {
+		f.timer.Stop()
+	}
+	return f.timedOut.Load()
+}
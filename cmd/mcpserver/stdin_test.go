@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"opencode-mcp/internal/runner"
+)
+
+func TestDecodeExecArgs(t *testing.T) {
+	c := stdinConfig{MaxBytes: 8}
+	binary := base64.StdEncoding.EncodeToString([]byte{0xff, 0, 0xfe})
+	tests := []struct {
+		body   string
+		status int
+		stdin  []byte
+	}{
+		{`{"args":["x"],"stdin":"text"}`, http.StatusOK, []byte("text")},
+		{`{"args":["x"],"stdinBase64":"` + binary + `"}`, http.StatusOK, []byte{0xff, 0, 0xfe}},
+		{`{"args":["x"]}`, http.StatusOK, []byte{}},
+		{`{"args":["x"],"stdin":"123456789"}`, http.StatusRequestEntityTooLarge, nil},
+		{`{"args":["x"],"stdinBase64":"` + base64.StdEncoding.EncodeToString([]byte("123456789")) + `"}`, http.StatusRequestEntityTooLarge, nil},
+		{`{"args":["x"],"stdin":"` + strings.Repeat("a", 100<<10) + `"}`, http.StatusRequestEntityTooLarge, nil},
+		{`{"args":["x"],"stdin":"a","stdinBase64":"YQ=="}`, http.StatusBadRequest, nil},
+		{`{"args":["x"],"stdinBase64":"not base64!"}`, http.StatusBadRequest, nil},
+		{`{"args":[]}`, http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		var req execArgs
+		stdin, ok := decodeExecArgs(rec, httptest.NewRequest(http.MethodPost, "/exec", strings.NewReader(tt.body)), c, &req)
+		if ok != (tt.status == http.StatusOK) || (!ok && rec.Code != tt.status) || !bytes.Equal(stdin, tt.stdin) {
+			t.Errorf("%.60s: ok %v, status %d, stdin %q", tt.body, ok, rec.Code, stdin)
+		}
+	}
+}
+
+// Test a command that doesn't read its stdin is killed after the timeout,
+// and one that does is left alone
+func TestStdinTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell")
+	}
+	data := bytes.Repeat([]byte("x"), 1<<20) // more than a pipe holds
+	for _, tt := range []struct {
+		script   string
+		timedOut bool
+	}{
+		{"sleep 30", true},
+		{"cat >/dev/null", false},
+	} {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		cmd := exec.CommandContext(ctx, "sh", "-c", tt.script)
+		runner.KillProcessGroup(cmd)
+		feed := feedStdin(cmd, data, 300*time.Millisecond, cancel)
+		start := time.Now()
+		_ = cmd.Run()
+		if got := feed.done(); got != tt.timedOut || time.Since(start) > 10*time.Second {
+			t.Errorf("%s: timed out %v after %s", tt.script, got, time.Since(start))
+		}
+		cancel()
+	}
+}
+
+// Test opencode_exec takes binary stdin as base64 and fails with OC-2008
+// when the command doesn't read it
+func TestExecToolStdin(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell")
+	}
+	tools := newToolHandler(serverConfig{Target: "sh", DefaultTimeout: 20 * time.Second, Stdin: stdinConfig{MaxBytes: 2 << 20, Timeout: 300 * time.Millisecond}})
+	call := func(args any) *toolCallResult {
+		t.Helper()
+		raw, _ := json.Marshal(args)
+		result, mErr := tools(context.Background(), &toolCall{ID: json.RawMessage("1"), Name: toolExec, Arguments: raw})
+		if mErr != nil {
+			t.Fatalf("exec: %+v", mErr)
+		}
+		return result
+	}
+
+	result := call(execArgs{Args: []string{"-c", "od -An -tx1"}, StdinBase64: base64.StdEncoding.EncodeToString([]byte{0xff, 0x00, 0xfe})})
+	if result.IsError || !strings.Contains(result.Content[0].Text, "ff 00 fe") {
+		t.Errorf("binary stdin: %+v", result.Content)
+	}
+	result = call(execArgs{Args: []string{"-c", "sleep 30"}, Stdin: strings.Repeat("x", 1<<20)})
+	if !result.IsError || result.Meta["error"].(*errorData).Code != errStdinTimeout.Code {
+		t.Errorf("unread stdin: %+v", result)
+	}
+	if _, mErr := tools(context.Background(), &toolCall{ID: json.RawMessage("1"), Name: toolExec, Arguments: json.RawMessage(`{"args":["-c","true"],"stdin":"` + strings.Repeat("x", 3<<20) + `"}`)}); mErr == nil || mErr.Data.Code != errInvalidArguments.Code {
+		t.Errorf("oversized stdin: %+v", mErr)
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// store persists server state (sessions, prompts, run history,
+// transcripts, ...) as JSON documents grouped into collections. The
+// documents live in a storeDriver selected by MCP_STORE_URL: in memory, in
+// a JSON file, or in Redis or a SQL database shared by several instances.
+type store struct {
+	path     string // the JSON file of a file store, for the disk guard
+	driver   storeDriver
+	compress bool // transcripts are gzipped (MCP_COMPRESSION)
+}
+
+// storeDriver holds the encoded documents of a store. Implementations must
+// be safe for concurrent use.
+type storeDriver interface {
+	get(collection, key string) (json.RawMessage, bool, error)
+	put(collection, key string, doc json.RawMessage) error
+	delete(collection, key string) (bool, error)
+	// scan returns the documents of collection whose key starts with prefix.
+	scan(collection, prefix string) (map[string]json.RawMessage, error)
+	// lease takes or renews the lease name for holder until ttl from now,
+	// reporting false while another holder's lease is unexpired.
+	lease(name, holder string, ttl time.Duration) (bool, error)
+}
+
+// instanceID identifies this process as a lease holder.
+var instanceID = func() string {
+	host, _ := os.Hostname()
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("%s/%d/%s", host, os.Getpid(), hex.EncodeToString(b))
+}()
+
+// openStore loads the file store at path, creating it on first write. With
+// an empty path the store is memory-only.
+func openStore(path string) (*store, error) {
+	d := &fileDriver{path: path, data: make(map[string]map[string]json.RawMessage)}
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("open store: %w", err)
+		}
+		if len(b) > 0 {
+			if err := json.Unmarshal(b, &d.data); err != nil {
+				return nil, fmt.Errorf("open store %s: %w", path, err)
+			}
+		}
+	}
+	return &store{path: path, driver: d}, nil
+}
+
+// openStoreURL opens the store named by rawURL (MCP_STORE_URL):
+//
+//	memory:                      memory-only (the default)
+//	file:///var/lib/mcp.json     a JSON file rewritten on every change
+//	redis://:pass@host:6379/0    Redis hashes, one per collection
+//	postgres://user@host/db      a documents table in PostgreSQL
+//	sqlite:///var/lib/mcp.db     a documents table in SQLite
+//
+// The SQL drivers are database/sql drivers, which must be linked into the
+// binary (see README).
+func openStoreURL(rawURL string) (*store, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("MCP_STORE_URL: %w", err)
+	}
+	if !storeSchemes[u.Scheme] {
+		return nil, fmt.Errorf("MCP_STORE_URL: unsupported scheme %q (want memory, file, redis, postgres or sqlite)", u.Scheme)
+	}
+	switch u.Scheme {
+	case "", "memory":
+		return openStore("")
+	case "file":
+		return openStore(u.Path)
+	case "redis", "rediss":
+		d, err := openRedisDriver(u)
+		if err != nil {
+			return nil, err
+		}
+		return &store{driver: d}, nil
+	}
+	d, err := openSQLDriver(u)
+	if err != nil {
+		return nil, err
+	}
+	return &store{driver: d}, nil
+}
+
+var storeSchemes = map[string]bool{
+	"": true, "memory": true, "file": true,
+	"redis": true, "rediss": true,
+	"postgres": true, "postgresql": true, "sqlite": true,
+}
+
+// redactURL hides the password of a store URL for logs.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	if _, ok := u.User.Password(); u.User != nil && !ok && strings.HasPrefix(u.Scheme, "redis") {
+		u.User = url.User("xxxxx") // redis://secret@host
+	}
+	return u.Redacted()
+}
+
+// openStoreFromEnv opens the store configured by MCP_STORE_URL or
+// MCP_STORE_PATH.
+func openStoreFromEnv() (*store, error) {
+	path, rawURL := os.Getenv("MCP_STORE_PATH"), os.Getenv("MCP_STORE_URL")
+	if rawURL == "" {
+		return openStore(path)
+	}
+	if path != "" {
+		return nil, errors.New("set only one of MCP_STORE_PATH and MCP_STORE_URL")
+	}
+	return openStoreURL(rawURL)
+}
+
+// get decodes the document at collection/key into v. It reports false when
+// the document doesn't exist.
+func (s *store) get(collection, key string, v any) (bool, error) {
+	doc, ok, err := s.driver.get(collection, key)
+	if !ok || err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(doc, v)
+}
+
+// put stores v at collection/key, replacing any existing document.
+func (s *store) put(collection, key string, v any) error {
+	doc, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.driver.put(collection, key, doc)
+}
+
+// delete removes collection/key, reporting whether it existed.
+func (s *store) delete(collection, key string) (bool, error) {
+	return s.driver.delete(collection, key)
+}
+
+// list returns the documents of collection whose key starts with prefix,
+// ordered by key. Driver errors are logged and yield no documents.
+func (s *store) list(collection, prefix string) []json.RawMessage {
+	docs, err := s.driver.scan(collection, prefix)
+	if err != nil {
+		log.Printf("[store] list %s: %v", collection, err)
+		return nil
+	}
+	out := make([]json.RawMessage, 0, len(docs))
+	for _, k := range sortedKeys(docs) {
+		out = append(out, docs[k])
+	}
+	return out
+}
+
+func sortedKeys(docs map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(docs))
+	for k := range docs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sizes returns the encoded size of every document in collection by key.
+func (s *store) sizes(collection string) map[string]int64 {
+	docs, err := s.driver.scan(collection, "")
+	if err != nil {
+		log.Printf("[store] sizes %s: %v", collection, err)
+	}
+	out := make(map[string]int64, len(docs))
+	for k, doc := range docs {
+		out[k] = int64(len(doc))
+	}
+	return out
+}
+
+// acquire reports whether this instance holds the lease name for the next
+// ttl, taking or renewing it. Background jobs that write shared state run
+// only while they hold their lease, so instances sharing a store don't
+// double-fire them. Errors count as not holding it.
+func (s *store) acquire(name string, ttl time.Duration) bool {
+	ok, err := s.driver.lease(name, instanceID, ttl)
+	if err != nil {
+		log.Printf("[store] lease %s: %v", name, err)
+	}
+	return ok
+}
+
+// fileDriver keeps the documents in memory and, with a path, rewrites them
+// atomically to that JSON file on every change.
+type fileDriver struct {
+	mu   sync.RWMutex
+	path string
+	data map[string]map[string]json.RawMessage // collection → key → document
+
+	leases map[string]fileLease // only this process uses a file store
+}
+
+type fileLease struct {
+	holder  string
+	expires time.Time
+}
+
+func (d *fileDriver) get(collection, key string) (json.RawMessage, bool, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	doc, ok := d.data[collection][key]
+	return doc, ok, nil
+}
+
+func (d *fileDriver) put(collection, key string, doc json.RawMessage) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.data[collection] == nil {
+		d.data[collection] = make(map[string]json.RawMessage)
+	}
+	prev, existed := d.data[collection][key]
+	d.data[collection][key] = doc
+	if err := d.flush(); err != nil {
+		if existed {
+			d.data[collection][key] = prev
+		} else {
+			delete(d.data[collection], key)
+		}
+		return err
+	}
+	return nil
+}
+
+// putMany writes docs into collection with one flush, where put would
+// rewrite the file for every document.
+func (d *fileDriver) putMany(collection string, docs map[string]json.RawMessage) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.data[collection] == nil {
+		d.data[collection] = make(map[string]json.RawMessage)
+	}
+	prev := make(map[string]json.RawMessage)
+	for key, doc := range docs {
+		if old, ok := d.data[collection][key]; ok {
+			prev[key] = old
+		}
+		d.data[collection][key] = doc
+	}
+	if err := d.flush(); err != nil {
+		for key := range docs {
+			if old, ok := prev[key]; ok {
+				d.data[collection][key] = old
+			} else {
+				delete(d.data[collection], key)
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+func (d *fileDriver) delete(collection, key string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	prev, ok := d.data[collection][key]
+	if !ok {
+		return false, nil
+	}
+	delete(d.data[collection], key)
+	if err := d.flush(); err != nil {
+		d.data[collection][key] = prev
+		return false, err
+	}
+	return true, nil
+}
+
+func (d *fileDriver) scan(collection, prefix string) (map[string]json.RawMessage, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	out := make(map[string]json.RawMessage)
+	for k, doc := range d.data[collection] {
+		if strings.HasPrefix(k, prefix) {
+			out[k] = doc
+		}
+	}
+	return out, nil
+}
+
+func (d *fileDriver) lease(name, holder string, ttl time.Duration) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	if l, ok := d.leases[name]; ok && l.holder != holder && now.Before(l.expires) {
+		return false, nil
+	}
+	if d.leases == nil {
+		d.leases = make(map[string]fileLease)
+	}
+	d.leases[name] = fileLease{holder, now.Add(ttl)}
+	return true, nil
+}
+
+// flush writes the documents to disk. Callers must hold d.mu.
+func (d *fileDriver) flush() error {
+	if d.path == "" {
+		return nil
+	}
+	b, err := json.Marshal(d.data)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(d.path), ".store-*")
+	if err != nil {
+		return fmt.Errorf("write store: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write store: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), d.path); err != nil {
+		return fmt.Errorf("write store: %w", err)
+	}
+	return nil
+}
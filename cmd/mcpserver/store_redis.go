@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	redisTimeout   = 5 * time.Second
+	redisIdleConns = 8
+)
+
+// redisDriver stores each collection as a Redis hash named
+// <prefix>:<collection>, so every instance pointed at the same Redis shares
+// sessions, run history and transcripts. It speaks RESP directly; only the
+// handful of hash commands the store needs are used, plus a script for
+// leases.
+type redisDriver struct {
+	addr     string
+	tls      bool
+	user     string
+	password string
+	db       int
+	prefix   string // ?prefix=, default opencode-mcp
+	idle     chan *redisConn
+}
+
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// openRedisDriver connects to redis://[user[:password]@]host[:port][/db],
+// failing early when Redis is unreachable.
+func openRedisDriver(u *url.URL) (*redisDriver, error) {
+	d := &redisDriver{
+		addr:   u.Host,
+		tls:    u.Scheme == "rediss",
+		prefix: "opencode-mcp",
+		idle:   make(chan *redisConn, redisIdleConns),
+	}
+	if u.Port() == "" {
+		d.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		d.password, _ = u.User.Password()
+		if d.password != "" {
+			d.user = u.User.Username()
+		} else {
+			d.password = u.User.Username() // redis://secret@host
+		}
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		n, err := strconv.Atoi(db)
+		if err != nil {
+			return nil, fmt.Errorf("MCP_STORE_URL: redis database %q is not a number", db)
+		}
+		d.db = n
+	}
+	if p := u.Query().Get("prefix"); p != "" {
+		d.prefix = p
+	}
+	if _, err := d.do("PING"); err != nil {
+		return nil, fmt.Errorf("open store: redis %s: %w", d.addr, err)
+	}
+	return d, nil
+}
+
+func (d *redisDriver) hash(collection string) string {
+	return d.prefix + ":" + collection
+}
+
+func (d *redisDriver) get(collection, key string) (json.RawMessage, bool, error) {
+	v, err := d.do("HGET", d.hash(collection), key)
+	if err != nil || v == nil {
+		return nil, false, err
+	}
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, false, fmt.Errorf("redis: unexpected HGET reply %T", v)
+	}
+	return b, true, nil
+}
+
+func (d *redisDriver) put(collection, key string, doc json.RawMessage) error {
+	_, err := d.do("HSET", d.hash(collection), key, string(doc))
+	return err
+}
+
+func (d *redisDriver) delete(collection, key string) (bool, error) {
+	v, err := d.do("HDEL", d.hash(collection), key)
+	n, _ := v.(int64)
+	return n > 0, err
+}
+
+func (d *redisDriver) scan(collection, prefix string) (map[string]json.RawMessage, error) {
+	out := make(map[string]json.RawMessage)
+	cursor := "0"
+	for {
+		v, err := d.do("HSCAN", d.hash(collection), cursor, "MATCH", redisGlobEscape(prefix)+"*", "COUNT", "1000")
+		if err != nil {
+			return nil, err
+		}
+		reply, _ := v.([]any)
+		if len(reply) != 2 {
+			return nil, fmt.Errorf("redis: unexpected HSCAN reply")
+		}
+		next, _ := reply[0].([]byte)
+		fields, _ := reply[1].([]any)
+		for i := 0; i+1 < len(fields); i += 2 {
+			k, _ := fields[i].([]byte)
+			doc, _ := fields[i+1].([]byte)
+			out[string(k)] = doc
+		}
+		if cursor = string(next); cursor == "0" || cursor == "" {
+			return out, nil
+		}
+	}
+}
+
+// redisLeaseScript takes the lease KEYS[1] for holder ARGV[1], or renews
+// it, for ARGV[2] milliseconds, atomically.
+const redisLeaseScript = `if redis.call('GET', KEYS[1]) == ARGV[1] then
+  return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+  return 1
+end
+return 0`
+
+func (d *redisDriver) lease(name, holder string, ttl time.Duration) (bool, error) {
+	v, err := d.do("EVAL", redisLeaseScript, "1", d.prefix+":lease:"+name, holder, strconv.FormatInt(ttl.Milliseconds(), 10))
+	n, _ := v.(int64)
+	return n == 1, err
+}
+
+// redisGlobEscape quotes the glob metacharacters of a MATCH pattern.
+func redisGlobEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// do runs one command on an idle connection, dialing a new one if needed.
+// A connection that fails is closed rather than reused.
+func (d *redisDriver) do(args ...string) (any, error) {
+	var c *redisConn
+	select {
+	case c = <-d.idle:
+	default:
+		var err error
+		if c, err = d.dial(); err != nil {
+			return nil, err
+		}
+	}
+	v, err := c.roundTrip(args)
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		c.Close()
+		return nil, err
+	}
+	select {
+	case d.idle <- c:
+	default:
+		c.Close()
+	}
+	return v, err
+}
+
+func (d *redisDriver) dial() (*redisConn, error) {
+	dialer := &net.Dialer{Timeout: redisTimeout}
+	var conn net.Conn
+	var err error
+	if d.tls {
+		conn, err = tls.DialWithDialer(dialer, "tcp", d.addr, nil)
+	} else {
+		conn, err = dialer.Dial("tcp", d.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{Conn: conn, r: bufio.NewReader(conn)}
+	var setup [][]string
+	switch {
+	case d.user != "":
+		setup = append(setup, []string{"AUTH", d.user, d.password})
+	case d.password != "":
+		setup = append(setup, []string{"AUTH", d.password})
+	}
+	if d.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(d.db)})
+	}
+	for _, args := range setup {
+		if _, err := c.roundTrip(args); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("redis %s: %w", args[0], err)
+		}
+	}
+	return c, nil
+}
+
+// redisError is an error reply; the connection stays usable.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+func (c *redisConn) roundTrip(args []string) (any, error) {
+	_ = c.SetDeadline(time.Now().Add(redisTimeout))
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := io.WriteString(c, b.String()); err != nil {
+		return nil, err
+	}
+	return c.readReply()
+}
+
+// readReply decodes one RESP reply: simple strings as string, errors as
+// redisError, integers as int64, bulk strings as []byte (nil when absent)
+// and arrays as []any.
+func (c *redisConn) readReply() (any, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		out := make([]any, n)
+		for i := range out {
+			if out[i], err = c.readReply(); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// sqlDriver stores documents in one table of a SQL database:
+//
+//	mcp_documents(collection, doc_key, doc) PRIMARY KEY (collection, doc_key)
+//	mcp_leases(name PRIMARY KEY, holder, expires_at)
+//
+// It goes through database/sql. The drivers are linked in by build tag:
+// -tags postgres (lib/pq, store_sql_postgres.go) and -tags sqlite
+// (mattn/go-sqlite3, store_sql_sqlite.go); a build may link others.
+type sqlDriver struct {
+	db     *sql.DB
+	dollar bool // $1 placeholders (PostgreSQL) instead of ?
+}
+
+// sqlDriverNames are the database/sql drivers tried for each scheme, in order.
+var sqlDriverNames = map[string][]string{
+	"postgres":   {"pgx", "postgres"},
+	"postgresql": {"pgx", "postgres"},
+	"sqlite":     {"sqlite", "sqlite3"},
+}
+
+func openSQLDriver(u *url.URL) (*sqlDriver, error) {
+	name := registeredSQLDriver(sqlDriverNames[u.Scheme])
+	if name == "" {
+		return nil, fmt.Errorf("MCP_STORE_URL: no %s database/sql driver is linked into this binary (tried %s)", u.Scheme, strings.Join(sqlDriverNames[u.Scheme], ", "))
+	}
+	dsn := u.String()
+	if u.Scheme == "sqlite" {
+		dsn = u.Path // sqlite:///var/lib/mcp.db
+		if dsn == "" {
+			dsn = u.Opaque // sqlite:mcp.db
+		}
+	}
+	db, err := sql.Open(name, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open store: %w", err)
+	}
+	d := &sqlDriver{db: db, dollar: u.Scheme != "sqlite"}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS mcp_documents (
+		collection TEXT NOT NULL,
+		doc_key    TEXT NOT NULL,
+		doc        TEXT NOT NULL,
+		PRIMARY KEY (collection, doc_key)
+	)`); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("open store: %w", err)
+	}
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS mcp_leases (
+		name       TEXT PRIMARY KEY,
+		holder     TEXT NOT NULL,
+		expires_at BIGINT NOT NULL
+	)`); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("open store: %w", err)
+	}
+	return d, nil
+}
+
+func registeredSQLDriver(candidates []string) string {
+	for _, want := range candidates {
+		for _, have := range sql.Drivers() {
+			if want == have {
+				return want
+			}
+		}
+	}
+	return ""
+}
+
+// query rewrites the ? placeholders of q for the database.
+func (d *sqlDriver) query(q string) string {
+	if !d.dollar {
+		return q
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range q {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (d *sqlDriver) get(collection, key string) (json.RawMessage, bool, error) {
+	var doc string
+	err := d.db.QueryRow(d.query(`SELECT doc FROM mcp_documents WHERE collection = ? AND doc_key = ?`), collection, key).Scan(&doc)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return json.RawMessage(doc), true, nil
+}
+
+func (d *sqlDriver) put(collection, key string, doc json.RawMessage) error {
+	_, err := d.db.Exec(d.query(`INSERT INTO mcp_documents (collection, doc_key, doc) VALUES (?, ?, ?)
+		ON CONFLICT (collection, doc_key) DO UPDATE SET doc = excluded.doc`), collection, key, string(doc))
+	return err
+}
+
+func (d *sqlDriver) delete(collection, key string) (bool, error) {
+	res, err := d.db.Exec(d.query(`DELETE FROM mcp_documents WHERE collection = ? AND doc_key = ?`), collection, key)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (d *sqlDriver) scan(collection, prefix string) (map[string]json.RawMessage, error) {
+	rows, err := d.db.Query(d.query(`SELECT doc_key, doc FROM mcp_documents
+		WHERE collection = ? AND substr(doc_key, 1, ?) = ?`), collection, utf8.RuneCountInString(prefix), prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make(map[string]json.RawMessage)
+	for rows.Next() {
+		var key, doc string
+		if err := rows.Scan(&key, &doc); err != nil {
+			return nil, err
+		}
+		out[key] = json.RawMessage(doc)
+	}
+	return out, rows.Err()
+}
+
+// lease uses a row per lease rather than PostgreSQL advisory locks, which
+// belong to one connection of the pool and aren't available in SQLite. The
+// upsert only takes over a row that is ours or expired.
+func (d *sqlDriver) lease(name, holder string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	res, err := d.db.Exec(d.query(`INSERT INTO mcp_leases (name, holder, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET holder = excluded.holder, expires_at = excluded.expires_at
+		WHERE mcp_leases.holder = excluded.holder OR mcp_leases.expires_at < ?`),
+		name, holder, now.Add(ttl).UnixMilli(), now.UnixMilli())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
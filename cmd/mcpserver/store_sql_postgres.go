@@ -0,0 +1,6 @@
+//go:build postgres
+
+package main
+
+// Built with -tags postgres, the binary can use postgres:// store URLs.
+import _ "github.com/lib/pq"
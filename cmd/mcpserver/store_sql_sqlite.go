@@ -0,0 +1,7 @@
+//go:build sqlite
+
+package main
+
+// Built with -tags sqlite, the binary can use sqlite:// store URLs. The
+// driver is cgo: build with CGO_ENABLED=1 and a C compiler.
+import _ "github.com/mattn/go-sqlite3"
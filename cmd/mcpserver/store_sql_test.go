@@ -0,0 +1,77 @@
+//go:build sqlite
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Test the SQL driver against SQLite: documents, prefix scans, deletes,
+// leases, and that the data outlives the connection
+func TestSQLStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mcp.db")
+	s, err := openStoreURL("sqlite://" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, n := range map[string]int{"a/1": 1, "a/2": 2, "b/1": 3, "a%": 4, "é/1": 5} {
+		if err := s.put("things", key, map[string]int{"n": n}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.put("things", "a/2", map[string]int{"n": 20}); err != nil {
+		t.Fatal(err)
+	}
+	var v map[string]int
+	if ok, err := s.get("things", "a/2", &v); !ok || err != nil || v["n"] != 20 {
+		t.Errorf("get = %v, %v, %v", v, ok, err)
+	}
+	if ok, err := s.get("things", "zzz", &v); ok || err != nil {
+		t.Errorf("get missing = %v, %v", ok, err)
+	}
+	if ok, _ := s.get("other", "a/1", &v); ok {
+		t.Error("get found a key of another collection")
+	}
+	if docs := s.list("things", "a/"); len(docs) != 2 || string(docs[0]) != `{"n":1}` {
+		t.Errorf("list(a/) = %s", docs)
+	}
+	if docs := s.list("things", "a%"); len(docs) != 1 {
+		t.Errorf("list(a%%) = %s, want the prefix matched literally", docs)
+	}
+	if docs := s.list("things", "é/"); len(docs) != 1 {
+		t.Errorf("list(é/) = %s", docs)
+	}
+	if ok, _ := s.delete("things", "a/1"); !ok {
+		t.Error("delete should report an existing key")
+	}
+	if ok, _ := s.delete("things", "a/1"); ok {
+		t.Error("second delete should report a missing key")
+	}
+
+	if !s.acquire("janitor", 50*time.Millisecond) || !s.acquire("janitor", 50*time.Millisecond) {
+		t.Error("lease not taken or renewed")
+	}
+	if ok, err := s.driver.lease("janitor", "other", time.Minute); ok || err != nil {
+		t.Errorf("other holder took an unexpired lease: %v, %v", ok, err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if ok, err := s.driver.lease("janitor", "other", time.Minute); !ok || err != nil {
+		t.Errorf("other holder couldn't take an expired lease: %v, %v", ok, err)
+	}
+	if s.acquire("janitor", time.Minute) {
+		t.Error("took back a lease held by another")
+	}
+
+	reopened, err := openStoreURL("sqlite://" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if docs := reopened.list("things", ""); len(docs) != 4 {
+		t.Errorf("after reopen: %d documents, want 4", len(docs))
+	}
+	if ok, _ := reopened.driver.lease("janitor", "other", time.Minute); !ok {
+		t.Error("lease holder lost after reopen")
+	}
+}
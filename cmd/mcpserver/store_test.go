@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Test store persistence across reopen
+func TestStorePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	s, err := openStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.put("things", "a/1", map[string]int{"n": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.put("things", "b/1", map[string]int{"n": 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = openStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v map[string]int
+	if ok, err := s.get("things", "a/1", &v); !ok || err != nil || v["n"] != 1 {
+		t.Errorf("get = %v, %v, %v", v, ok, err)
+	}
+	if docs := s.list("things", "a/"); len(docs) != 1 {
+		t.Errorf("list(a/) = %d docs, want 1", len(docs))
+	}
+	if ok, _ := s.delete("things", "a/1"); !ok {
+		t.Error("delete should report an existing key")
+	}
+	if ok, _ := s.delete("things", "a/1"); ok {
+		t.Error("second delete should report a missing key")
+	}
+
+	s, _ = openStore(path)
+	if ok, _ := s.get("things", "a/1", &v); ok {
+		t.Error("deleted document came back after reopen")
+	}
+}
+
+// Test MCP_STORE_URL selects the driver
+func TestOpenStoreURL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	s, err := openStoreURL("file://" + path)
+	if err != nil || s.path != path {
+		t.Fatalf("file store = %+v, %v", s, err)
+	}
+	if s, err := openStoreURL("memory:"); err != nil || s.path != "" {
+		t.Errorf("memory store = %+v, %v", s, err)
+	}
+	if _, err := openStoreURL("mongodb://localhost"); err == nil || !strings.Contains(err.Error(), "unsupported scheme") {
+		t.Errorf("mongodb: err = %v", err)
+	}
+	// Without -tags postgres no PostgreSQL driver is linked in
+	if registeredSQLDriver(sqlDriverNames["postgres"]) == "" {
+		if _, err := openStoreURL("postgres://db/mcp"); err == nil || !strings.Contains(err.Error(), "no postgres database/sql driver") {
+			t.Errorf("postgres: err = %v", err)
+		}
+	}
+	if got := redactURL("redis://secret@cache:6379/1"); strings.Contains(got, "secret") {
+		t.Errorf("redactURL = %s", got)
+	}
+}
+
+// Test the SQL driver numbers placeholders for PostgreSQL only
+func TestSQLQuery(t *testing.T) {
+	q := `SELECT doc FROM t WHERE a = ? AND b = ?`
+	if got := (&sqlDriver{}).query(q); got != q {
+		t.Errorf("sqlite query = %s", got)
+	}
+	if got, want := (&sqlDriver{dollar: true}).query(q), `SELECT doc FROM t WHERE a = $1 AND b = $2`; got != want {
+		t.Errorf("postgres query = %s, want %s", got, want)
+	}
+}
+
+// Test the Redis driver against a minimal in-process RESP server
+func TestRedisStore(t *testing.T) {
+	addr := fakeRedis(t, "pw")
+	s, err := openStoreURL("redis://:pw@" + addr + "/2?prefix=test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, n := range map[string]int{"a/1": 1, "a/2": 2, "b/1": 3, "a*": 4} {
+		if err := s.put("things", key, map[string]int{"n": n}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var v map[string]int
+	if ok, err := s.get("things", "a/2", &v); !ok || err != nil || v["n"] != 2 {
+		t.Errorf("get = %v, %v, %v", v, ok, err)
+	}
+	if ok, err := s.get("things", "zzz", &v); ok || err != nil {
+		t.Errorf("get missing = %v, %v", ok, err)
+	}
+	if docs := s.list("things", "a/"); len(docs) != 2 || string(docs[0]) != `{"n":1}` {
+		t.Errorf("list(a/) = %s", docs)
+	}
+	if docs := s.list("things", "a*"); len(docs) != 1 {
+		t.Errorf("list(a*) = %s, want the glob escaped", docs)
+	}
+	if ok, _ := s.delete("things", "a/1"); !ok {
+		t.Error("delete should report an existing key")
+	}
+	if ok, _ := s.delete("things", "a/1"); ok {
+		t.Error("second delete should report a missing key")
+	}
+	if !s.acquire("janitor", time.Minute) || !s.acquire("janitor", time.Minute) {
+		t.Error("lease not taken or renewed")
+	}
+	if ok, err := s.driver.lease("janitor", "other", time.Minute); ok || err != nil {
+		t.Errorf("other holder took the lease: %v, %v", ok, err)
+	}
+	if _, err := openStoreURL("redis://:wrong@" + addr); err == nil {
+		t.Error("opened with a wrong password")
+	}
+}
+
+// Test a session created on one instance is accepted by another sharing
+// the store
+func TestSessionsShareStore(t *testing.T) {
+	st, _ := openStore("")
+	a := &sessionStore{sessions: make(map[string]*session), store: st}
+	b := &sessionStore{sessions: make(map[string]*session), store: st}
+	sess := a.create("", clientCapabilities{})
+	if got := b.get(sess.id); got == nil || !got.createdAt.Equal(sess.createdAt) {
+		t.Errorf("other instance: get = %+v", got)
+	}
+	if b.get("unknown") != nil {
+		t.Error("unknown session found")
+	}
+}
+
+// Test only one holder gets a lease until it expires
+func TestStoreLease(t *testing.T) {
+	s, _ := openStore("")
+	if !s.acquire("janitor", 50*time.Millisecond) {
+		t.Fatal("first acquire failed")
+	}
+	if !s.acquire("janitor", 50*time.Millisecond) {
+		t.Error("holder couldn't renew its lease")
+	}
+	if ok, _ := s.driver.lease("janitor", "other", time.Minute); ok {
+		t.Error("other holder took an unexpired lease")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if ok, _ := s.driver.lease("janitor", "other", time.Minute); !ok {
+		t.Error("other holder couldn't take an expired lease")
+	}
+	if s.acquire("janitor", time.Minute) {
+		t.Error("took back a lease held by another")
+	}
+}
+
+// fakeRedis serves the hash commands the store uses, requiring AUTH with
+// password, and returns its address.
+func fakeRedis(t *testing.T, password string) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	var mu sync.Mutex
+	hashes := map[string]map[string]string{}
+	leases := map[string]string{} // no expiry
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				rc := &redisConn{Conn: conn, r: bufio.NewReader(conn)}
+				authed := false
+				for {
+					v, err := rc.readReply()
+					if err != nil {
+						return
+					}
+					var args []string
+					for _, a := range v.([]any) {
+						args = append(args, string(a.([]byte)))
+					}
+					mu.Lock()
+					h := hashes[args[len(args)-1]]
+					if len(args) > 1 {
+						if hashes[args[1]] == nil {
+							hashes[args[1]] = map[string]string{}
+						}
+						h = hashes[args[1]]
+					}
+					var reply string
+					switch {
+					case args[0] == "AUTH" && args[len(args)-1] == password:
+						authed, reply = true, "+OK\r\n"
+					case !authed:
+						reply = "-NOAUTH Authentication required.\r\n"
+					case args[0] == "PING" || args[0] == "SELECT":
+						reply = "+OK\r\n"
+					case args[0] == "EVAL":
+						reply = ":0\r\n"
+						if holder, ok := leases[args[3]]; !ok || holder == args[4] {
+							leases[args[3]], reply = args[4], ":1\r\n"
+						}
+					case args[0] == "HSET":
+						h[args[2]] = args[3]
+						reply = ":1\r\n"
+					case args[0] == "HGET":
+						if doc, ok := h[args[2]]; ok {
+							reply = fmt.Sprintf("$%d\r\n%s\r\n", len(doc), doc)
+						} else {
+							reply = "$-1\r\n"
+						}
+					case args[0] == "HDEL":
+						_, ok := h[args[2]]
+						delete(h, args[2])
+						reply = ":0\r\n"
+						if ok {
+							reply = ":1\r\n"
+						}
+					case args[0] == "HSCAN":
+						var fields []string
+						for k, doc := range h {
+							if ok, _ := path.Match(args[4], k); ok {
+								fields = append(fields, fmt.Sprintf("$%d\r\n%s\r\n$%d\r\n%s\r\n", len(k), k, len(doc), doc))
+							}
+						}
+						reply = fmt.Sprintf("*2\r\n$1\r\n0\r\n*%d\r\n%s", 2*len(fields), strings.Join(fields, ""))
+					default:
+						reply = "-ERR unknown command\r\n"
+					}
+					mu.Unlock()
+					if _, err := io.WriteString(conn, reply); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+)
+
+const (
+	// tenantHeader names the tenant a request belongs to. tenantAuth sets
+	// it to the authenticated tenant before any handler reads it.
+	tenantHeader  = "X-MCP-Tenant"
+	defaultTenant = "default"
+)
+
+var tenantNameRe = regexp.MustCompile(`^[A-Za-z0-9_.\-]{1,64}$`)
+
+// tenantFromRequest returns the tenant of r, or defaultTenant when the
+// header is missing or malformed.
+func tenantFromRequest(r *http.Request) string {
+	if t := r.Header.Get(tenantHeader); tenantNameRe.MatchString(t) {
+		return t
+	}
+	return defaultTenant
+}
+
+// tenantOrDefault maps an unset tenant (e.g. internal calls) to defaultTenant.
+func tenantOrDefault(tenant string) string {
+	if tenant == "" {
+		return defaultTenant
+	}
+	return tenant
+}
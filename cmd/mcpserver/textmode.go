@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Older opencode releases have no `run --format json`: some reject the flag,
+// others ignore it and print plain text. Binaries found to lack it run in
+// text mode instead, whose output is returned as-is and marked unparsed.
+
+var (
+	textModeMu sync.Mutex
+	textMode   = map[string]bool{} // binary paths without --format json
+)
+
+// probeJSONFormat checks `<target> run --help` for --format and remembers
+// binaries without it. A help text it doesn't recognize proves nothing;
+// such binaries are caught on their first failed run instead.
+func probeJSONFormat(target string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	out, _ := exec.CommandContext(ctx, target, "run", "--help").CombinedOutput()
+	help := string(out)
+	if strings.Contains(help, "--model") && !strings.Contains(help, "--format") {
+		markTextMode(target, "run --help lists no --format")
+	}
+}
+
+// jsonFormatSupported reports whether target is not known to lack
+// --format json.
+func jsonFormatSupported(target string) bool {
+	textModeMu.Lock()
+	defer textModeMu.Unlock()
+	return !textMode[target]
+}
+
+func markTextMode(target, reason string) {
+	textModeMu.Lock()
+	defer textModeMu.Unlock()
+	if !textMode[target] {
+		textMode[target] = true
+		log.Printf("[textmode] %s does not support --format json (%s); runs fall back to unparsed plain text", target, reason)
+	}
+}
+
+// unknownFormatRe matches the errors CLIs print for an unknown --format flag.
+var unknownFormatRe = regexp.MustCompile(`(?i)(unknown|unrecognized|unexpected|invalid)\s+(option|argument|flag)s?\b[^\n]*format|format[^\n]*(unknown option|not supported)`)
+
+// rejectsJSONFormat reports whether stderr of a failed run says the CLI
+// doesn't know --format.
+func rejectsJSONFormat(stderr string) bool {
+	return unknownFormatRe.MatchString(stderr)
+}
+
+// hasJSONFormat reports whether args ask for --format json.
+func hasJSONFormat(args []string) bool {
+	for i := 0; i+1 < len(args); i++ {
+		if args[i] == "--format" && args[i+1] == "json" {
+			return true
+		}
+	}
+	return false
+}
+
+// textModeSpec returns spec without --format json, for plain-text output.
+func (spec commandSpec) textModeSpec() commandSpec {
+	args := make([]string, 0, len(spec.Args))
+	for i := 0; i < len(spec.Args); i++ {
+		if spec.Args[i] == "--format" && i+1 < len(spec.Args) && spec.Args[i+1] == "json" {
+			i++
+			continue
+		}
+		args = append(args, spec.Args[i])
+	}
+	spec.Args = args
+	spec.ParseEvents = false
+	spec.Unparsed = true
+	return spec
+}
+
+// markUnparsed flags a result whose opencode output could not be parsed as
+// events: its text is the CLI's plain output, best-effort cleaned up.
+func (r *toolCallResult) markUnparsed(target string) {
+	if r.Meta == nil {
+		r.Meta = map[string]any{}
+	}
+	r.Meta["unparsed"] = true
+	r.Meta["outputFormat"] = "text"
+	r.Content[0].Text += "\n[unparsed] " + target + " does not support --format json; this is its plain-text output"
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"opencode-mcp/internal/fakeopencode"
+)
+
+func forgetTextMode(t *testing.T, target string) {
+	t.Cleanup(func() {
+		textModeMu.Lock()
+		delete(textMode, target)
+		textModeMu.Unlock()
+	})
+}
+
+// Test runs fall back to unparsed plain text on CLIs without --format json
+func TestJSONFormatFallback(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	forgetTextMode(t, exe)
+	srv := e2eServer(t, fakeopencode.Script{NoJSONFormat: true, Lines: []string{"\x1b[1mplain answer\x1b[0m"}}, 20*time.Second)
+
+	for i := 0; i < 2; i++ {
+		r, err := e2eCall(context.Background(), t, srv.URL, map[string]any{"message": "hi"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.result.IsError || !strings.Contains(r.text(), "plain answer\n") || strings.Contains(r.text(), "\x1b") {
+			t.Errorf("call %d: text = %q", i, r.text())
+		}
+		if r.result.Meta["unparsed"] != true || !strings.Contains(r.text(), "[unparsed]") {
+			t.Errorf("call %d: not marked unparsed: %+v", i, r.result.Meta)
+		}
+		if jsonFormatSupported(exe) {
+			t.Fatal("the CLI was not remembered as lacking --format json")
+		}
+	}
+}
+
+// Test run --help is probed for --format
+func TestProbeJSONFormat(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	forgetTextMode(t, exe)
+	t.Setenv(fakeopencode.EnvScript, fakeopencode.Script{}.Encode())
+	if probeJSONFormat(exe); !jsonFormatSupported(exe) {
+		t.Error("a CLI listing --format was marked as lacking it")
+	}
+	t.Setenv(fakeopencode.EnvScript, fakeopencode.Script{NoJSONFormat: true}.Encode())
+	if probeJSONFormat(exe); jsonFormatSupported(exe) {
+		t.Error("a CLI without --format was not detected")
+	}
+	if rejectsJSONFormat("Error: unexpected argument '--format' found") != true || rejectsJSONFormat("rate limited") {
+		t.Error("rejectsJSONFormat misclassifies stderr")
+	}
+}
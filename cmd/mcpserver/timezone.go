@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// outputLocation is the timezone of timestamps in structured output and
+// logs (MCP_TIMEZONE, default UTC). Timestamps are always RFC3339 with an
+// explicit offset, so clients never have to guess the server's zone.
+var outputLocation = time.UTC
+
+// localTime converts t to the configured output timezone.
+func localTime(t time.Time) time.Time {
+	return t.In(outputLocation)
+}
+
+// configureTimestamps sets the output timezone from an IANA name such as
+// Europe/Berlin ("Local" for the host's zone, empty for UTC), and makes the
+// log prefix every line with an RFC3339 timestamp in it.
+func configureTimestamps(name string) error {
+	if name != "" {
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			return err
+		}
+		outputLocation = loc
+	}
+	log.SetFlags(0)
+	log.SetOutput(&timestampWriter{w: os.Stderr})
+	return nil
+}
+
+// timestampWriter prefixes each log line with the current time.
+type timestampWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (t *timestampWriter) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var buf bytes.Buffer
+	buf.WriteString(localTime(time.Now()).Format(time.RFC3339))
+	buf.WriteByte(' ')
+	buf.Write(p)
+	if _, err := t.w.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
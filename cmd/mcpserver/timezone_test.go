@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test timestamps are converted to the configured timezone, including in
+// log lines
+func TestOutputTimezone(t *testing.T) {
+	defer func(loc *time.Location) { outputLocation = loc }(outputLocation)
+	loc, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skip("no tzdata:", err)
+	}
+	outputLocation = loc
+
+	at := time.Date(2025, 10, 8, 12, 0, 0, 0, time.UTC)
+	if got := localTime(at).Format(time.RFC3339); got != "2025-10-08T21:00:00+09:00" {
+		t.Errorf("localTime = %s", got)
+	}
+
+	var b strings.Builder
+	w := &timestampWriter{w: &b}
+	if _, err := w.Write([]byte("[test] hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	stamp, line, _ := strings.Cut(b.String(), " ")
+	if ts, err := time.Parse(time.RFC3339, stamp); err != nil || !strings.HasSuffix(stamp, "+09:00") || time.Since(ts) > time.Minute {
+		t.Errorf("log timestamp = %q, %v", stamp, err)
+	}
+	if line != "[test] hello\n" {
+		t.Errorf("log line = %q", line)
+	}
+}
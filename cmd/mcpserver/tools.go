@@ -0,0 +1,648 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"opencode-mcp/internal/mcp"
+	"opencode-mcp/internal/runner"
+)
+
+// toolCall is a single tools/call invocation flowing through the middleware chain.
+type toolCall struct {
+	ID             json.RawMessage
+	Name           string
+	Arguments      json.RawMessage
+	Cwd            string // request-level default cwd
+	Session        *session
+	Tenant         string
+	RunID          string            // assigned when the call is recorded in the run history
+	ArtifactDir    string            // where the run may write artifacts; empty when disabled
+	Binary         *cliBinary        // opencode binary pinned for the call
+	IdempotencyKey string            // from the Idempotency-Key header or _meta.idempotencyKey
+	ProgressToken  json.RawMessage   // _meta.progressToken; nil when the client sent none
+	LastEventID    int               // last notification a resuming client saw
+	Locale         string            // language of progress messages
+	Routes         eventRoutes       // where the client wants each event type; nil for the defaults
+	Preferences    clientPreferences // saved for the caller's API key
+	estimate       *runEstimate      // completion estimate of an opencode_run; nil when there is none
+
+	// notify streams a JSON-RPC notification to the client; nil when the
+	// transport can't stream.
+	notify func(msg any)
+	// ask sends a request to the client and waits for its result; nil when
+	// the transport can't.
+	ask func(ctx context.Context, method string, params any) (json.RawMessage, error)
+	// claimed receives the call's entry when the idempotency middleware
+	// registers or finds it; nil when no long poll waits for it.
+	claimed func(entry *idempotentCall)
+	// appended receives each transcript entry of an opencode_run as opencode
+	// reports it, with the session it reported; nil when nothing follows it.
+	appended func(session string, entry transcriptEntry)
+}
+
+// toolHandler executes a tool call. A non-nil *mcpError is returned to the
+// client as a JSON-RPC error; tool failures are reported via result.IsError.
+type toolHandler func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError)
+
+// toolMiddleware wraps a toolHandler to add a cross-cutting concern.
+type toolMiddleware func(next toolHandler) toolHandler
+
+// chainTools wraps h with mws so that mws[0] is the outermost middleware.
+func chainTools(h toolHandler, mws ...toolMiddleware) toolHandler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// newToolHandler builds the tools/call handler shared by all transports.
+func newToolHandler(cfg serverConfig) toolHandler {
+	return chainTools(dispatchTool(cfg),
+		recoverMiddleware,
+		loggingMiddleware,
+		eventMiddleware(cfg),
+		toolToggleMiddleware(cfg),
+		schemaMiddleware(cfg),
+		idempotencyMiddleware(cfg.Idempotency),
+		dedupeMiddleware(cfg.Dedupe),
+		binaryMiddleware(cfg),
+		projectMiddleware(cfg),
+		preferencesMiddleware,
+		sessionMapMiddleware(cfg),
+		codeBlockMiddleware,
+		repoMiddleware(cfg),
+		elicitMiddleware(cfg),
+		policyMiddleware(cfg),
+		remoteFilesMiddleware(cfg),
+		messageLimitMiddleware(cfg),
+		diskGuardMiddleware(cfg),
+		analyticsMiddleware(cfg),
+		progressEstimateMiddleware(cfg),
+		runningMiddleware(cfg.Running),
+		runStateMiddleware(cfg),
+		transcriptFeedMiddleware(cfg),
+		historyMiddleware(cfg),
+		manifestMiddleware(cfg),
+		artifactMiddleware(cfg),
+		chaosMiddleware(cfg.Chaos),
+	)
+}
+
+// runHandler executes the opencode_run sub-calls of composite tools
+// (fan-out, pipeline, compare) so they are recorded like top-level runs.
+func runHandler(cfg serverConfig) toolHandler {
+	return chainTools(dispatchTool(cfg), eventMiddleware(cfg), binaryMiddleware(cfg), policyMiddleware(cfg), remoteFilesMiddleware(cfg), messageLimitMiddleware(cfg), diskGuardMiddleware(cfg), analyticsMiddleware(cfg), runningMiddleware(cfg.Running), runStateMiddleware(cfg), transcriptFeedMiddleware(cfg), historyMiddleware(cfg), manifestMiddleware(cfg), artifactMiddleware(cfg), chaosMiddleware(cfg.Chaos))
+}
+
+// newToolCall decodes tools/call params into a toolCall.
+func newToolCall(req mcpRequest) (*toolCall, *mcpError) {
+	var params toolCallParams
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		log.Printf("[tools/call] invalid params: %v", err)
+		return nil, errInvalidArguments.err("invalid params")
+	}
+	key := params.Meta.IdempotencyKey
+	if key == "" {
+		key = req.IdempotencyKey
+	}
+	return &toolCall{
+		ID:             req.ID,
+		Name:           params.Name,
+		Arguments:      params.Arguments,
+		Cwd:            req.Cwd,
+		Session:        req.Session,
+		Tenant:         req.Tenant,
+		IdempotencyKey: key,
+		ProgressToken:  params.Meta.ProgressToken,
+		LastEventID:    req.LastEventID,
+		Locale:         req.Locale,
+		Routes:         req.Routes,
+		Preferences:    req.Preferences,
+	}, nil
+}
+
+// Notify sends msg to the client if the transport supports streaming and
+// the client's logging level lets it through. Progress goes out with the
+// client's progress token, and not at all to clients that asked for none.
+func (c *toolCall) Notify(msg any) {
+	if c.notify == nil || (c.Session != nil && !c.Session.allowsNotification(msg)) {
+		return
+	}
+	if msg, ok := c.adaptProgress(msg); ok {
+		c.notify(msg)
+	}
+}
+
+// progress sends MCP notifications/progress for real-time client display.
+// Runs with an estimate report it as a percentage instead of the count.
+func (c *toolCall) progress(progress int, message string) {
+	params := map[string]any{
+		"progressToken": c.ID,
+		"progress":      progress,
+		"message":       message,
+	}
+	if pct, ok := c.estimate.percent(time.Now()); ok {
+		params["progress"], params["total"] = pct, 100
+	}
+	c.Notify(map[string]any{
+		"jsonrpc": "2.0",
+		"method":  "notifications/progress",
+		"params":  params,
+	})
+}
+
+// progressf sends a progress message from format, translated into the
+// call's language.
+func (c *toolCall) progressf(progress int, format string, args ...any) {
+	c.progress(progress, c.sprintf(format, args...))
+}
+
+// sprintf formats a message from format translated into the call's language.
+func (c *toolCall) sprintf(format string, args ...any) string {
+	return fmt.Sprintf(translateFormat(c.Locale, format), args...)
+}
+
+// loggingMiddleware logs the outcome and duration of every tool call.
+func loggingMiddleware(next toolHandler) toolHandler {
+	return func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+		start := time.Now()
+		call.logf("debug", "[tools/call] tool=%s id=%s", call.Name, call.ID)
+		result, mErr := next(ctx, call)
+		switch {
+		case mErr != nil:
+			call.logf("debug", "[tools/call] tool=%s id=%s error code=%d msg=%s duration=%s",
+				call.Name, call.ID, mErr.Code, mErr.Message, time.Since(start).Round(time.Millisecond))
+		case result != nil:
+			call.logf("debug", "[tools/call] tool=%s id=%s isError=%t duration=%s",
+				call.Name, call.ID, result.IsError, time.Since(start).Round(time.Millisecond))
+		}
+		return result, mErr
+	}
+}
+
+// recoverMiddleware turns a panic in a tool handler into an internal error.
+func recoverMiddleware(next toolHandler) toolHandler {
+	return func(ctx context.Context, call *toolCall) (result *toolCallResult, mErr *mcpError) {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("[tools/call] panic in tool=%s: %v", call.Name, r)
+				result, mErr = nil, errInternal.err("internal error")
+			}
+		}()
+		return next(ctx, call)
+	}
+}
+
+// dispatchTool is the innermost handler: it routes the call to a plugin or
+// a downstream server, or runs the CLI command backing a built-in or custom
+// tool.
+func dispatchTool(cfg serverConfig) toolHandler {
+	return func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+		cfg := cfg.forTenant(call.Tenant).withBinary(call.Binary).withAgent()
+		if pt, ok := findPluginTool(cfg, call.Name); ok {
+			return callPluginTool(ctx, pt, call), nil
+		}
+		if dt, ok := findServerTool(cfg, call.Name); ok {
+			return callServerTool(ctx, dt, call), nil
+		}
+		switch call.Name {
+		case toolModelInfo:
+			return modelInfoTool(cfg, call)
+		case toolFanout:
+			return fanoutTool(ctx, cfg, call)
+		case toolPipeline:
+			return pipelineTool(ctx, cfg, call)
+		case toolCompare:
+			return compareTool(ctx, cfg, call)
+		case toolEstimate:
+			return estimateTool(cfg, call)
+		case toolPromptSave, toolPromptDelete, toolPromptList:
+			return promptTool(cfg, call)
+		case toolHistory:
+			return historyTool(cfg, call)
+		case toolJobCancel:
+			return jobCancelTool(ctx, cfg, call)
+		case toolCompareRuns:
+			return compareRunsTool(cfg, call)
+		case toolProjectList:
+			return projectListTool(cfg), nil
+		case toolRun:
+			priority, mErr := runPriority(cfg.forTenant(call.Tenant), call)
+			if mErr != nil {
+				return nil, mErr
+			}
+			queued := func(position int) {
+				call.publishRunState(cfg.Events, mcp.RunQueued, map[string]any{"position": position, "priority": priority})
+			}
+			if err := cfg.Limiter.acquireNotify(ctx, priority, queued); err != nil {
+				return nil, errCancelled.err("cancelled while waiting for a free run slot")
+			}
+			defer cfg.Limiter.release(priority)
+			call.publishRunState(cfg.Events, mcp.RunStarted, map[string]any{"priority": priority})
+		}
+		if call.Name == toolSessionList && cfg.OpencodeStorage != "" {
+			if result, ok := localSessionList(cfg, call); ok {
+				return result, nil
+			}
+		}
+		if cfg.Backend == backendServe && servedTools[call.Name] && cfg.agentCLI() == nil {
+			if cfg.Policy.Egress != nil {
+				return nil, errPolicyDenied.err("the tenant's egress policy needs MCP_BACKEND=cli: opencode serve is shared by all tenants")
+			}
+			ctx, cancel := context.WithTimeout(ctx, cfg.DefaultTimeout)
+			defer cancel()
+			return newServeClient(cfg.ServeURL).callTool(ctx, cfg, call)
+		}
+
+		spec, mErr := buildToolCommand(cfg, call)
+		if mErr != nil {
+			return nil, mErr
+		}
+		if spec.Cwd == "" {
+			spec.Cwd = call.Cwd
+		}
+		if err := validateCwd(spec.Cwd); err != nil {
+			return nil, errInvalidCwd.err(err.Error())
+		}
+		if err := cfg.Policy.checkDir(spec.Cwd); err != nil {
+			return nil, errPolicyDenied.err(err.Error())
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, cfg.DefaultTimeout)
+		defer cancel()
+		return runToolCommand(ctx, cfg, call, spec)
+	}
+}
+
+// runToolArgs are the arguments of opencode_run.
+type runToolArgs struct {
+	Message  string   `json:"message"`
+	Cwd      string   `json:"cwd"`
+	Model    string   `json:"model"`
+	Session  string   `json:"session"`
+	Continue bool     `json:"continue"`
+	Files    []string `json:"files"`
+	Agent    string   `json:"agent,omitempty"`
+	Labels   []string `json:"labels,omitempty"`
+	Priority string   `json:"priority,omitempty"`
+	Dedupe   *bool    `json:"dedupe,omitempty"`
+}
+
+func parseRunArgs(call *toolCall) (runToolArgs, *mcpError) {
+	var runArgs runToolArgs
+	if err := json.Unmarshal(call.Arguments, &runArgs); err != nil {
+		return runArgs, errInvalidArguments.err("invalid arguments")
+	}
+	if runArgs.Message == "" {
+		return runArgs, errInvalidArguments.err("missing message")
+	}
+	return runArgs, nil
+}
+
+// runPriority returns the queue priority of an opencode_run call, defaulting
+// to the tenant's.
+func runPriority(cfg serverConfig, call *toolCall) (string, *mcpError) {
+	var args struct {
+		Priority string `json:"priority"`
+	}
+	_ = json.Unmarshal(call.Arguments, &args)
+	if err := validatePriority(args.Priority); err != nil {
+		return "", errInvalidArguments.err(err.Error())
+	}
+	if args.Priority == "" {
+		args.Priority = cfg.Policy.Priority
+	}
+	if args.Priority == "" {
+		args.Priority = priorityNormal
+	}
+	return args.Priority, nil
+}
+
+// commandSpec describes the CLI invocation backing a tool call.
+type commandSpec struct {
+	Args        []string
+	Cwd         string
+	Stdin       string
+	ParseEvents bool // parse stdout as an opencode --format json event stream
+	Unparsed    bool // text mode: the CLI has no --format json
+	// Events normalizes the output lines of a wrapped agent CLI
+	Events func(line string) (event map[string]any, known bool)
+}
+
+// buildToolCommand resolves the CLI invocation for a built-in or custom tool.
+func buildToolCommand(cfg serverConfig, call *toolCall) (commandSpec, *mcpError) {
+	var spec commandSpec
+	spec.ParseEvents = call.Name == toolRun
+	agent := cfg.agentCLI()
+	if agent != nil && call.Name != toolRun && call.Name != toolExec {
+		return spec, errInvalidArguments.err(fmt.Sprintf("%s is not supported by the %s agent", call.Name, agent.name))
+	}
+
+	switch call.Name {
+	case toolExec:
+		var args execArgs
+		if err := json.Unmarshal(call.Arguments, &args); err != nil {
+			return spec, errInvalidArguments.err("invalid arguments")
+		}
+		if len(args.Args) == 0 {
+			return spec, errInvalidArguments.err("missing args")
+		}
+		stdin, err := cfg.Stdin.stdin(args)
+		if err != nil {
+			return spec, errInvalidArguments.err(err.Error())
+		}
+		spec.Args = args.Args
+		spec.Cwd = args.Cwd
+		spec.Stdin = string(stdin)
+		log.Printf("[tools/call] exec args=%v cwd=%q", args.Args, spec.Cwd)
+
+	case toolRun:
+		runArgs, mErr := parseRunArgs(call)
+		if mErr != nil {
+			return spec, mErr
+		}
+
+		if agent != nil {
+			model := runArgs.Model
+			if model == "" {
+				model = cfg.Policy.DefaultModel
+			}
+			args, err := agent.runArgs(runArgs, model)
+			if err != nil {
+				return spec, errInvalidArguments.err(err.Error())
+			}
+			spec.Args, spec.Cwd = args, runArgs.Cwd
+			spec.ParseEvents, spec.Events = false, agent.event
+			log.Printf("[tools/call] run agent=%s message=%s model=%s cwd=%q",
+				agent.name, truncateForLog(runArgs.Message, 80), model, spec.Cwd)
+			break
+		}
+
+		// Use default model if not specified
+		model := runArgs.Model
+		if model == "" {
+			model = getDefaultModel(cfg)
+			if model != "" {
+				log.Printf("Using default model: %s", model)
+			}
+		}
+
+		spec.Args = runner.RunArgs(runArgs.Message, runner.RunOptions{
+			Model:    model,
+			Session:  runArgs.Session,
+			Continue: runArgs.Continue,
+			Agent:    runArgs.Agent,
+			Files:    runArgs.Files,
+		})
+		spec.Cwd = runArgs.Cwd
+		log.Printf("[tools/call] run message=%s model=%s cwd=%q session=%s files=%v",
+			truncateForLog(runArgs.Message, 80), model, spec.Cwd, runArgs.Session, runArgs.Files)
+
+	case toolModels:
+		spec.Args = []string{"models"}
+		log.Printf("[tools/call] models")
+
+	case toolSessionList:
+		spec.Args = []string{"session", "list"}
+		log.Printf("[tools/call] session list")
+
+	case toolAgentList:
+		spec.Args = []string{"agent", "list"}
+		log.Printf("[tools/call] agent list")
+
+	default:
+		tool, ok := findCustomTool(cfg, call.Name)
+		if !ok {
+			return spec, errUnknownTool.err(fmt.Sprintf("unknown tool: %s", call.Name))
+		}
+		args, cwd, err := tool.buildCommand(call.Arguments)
+		if err != nil {
+			return spec, errInvalidArguments.err(err.Error())
+		}
+		spec.Args = args
+		spec.Cwd = cwd
+		spec.ParseEvents = tool.parsesEvents()
+		log.Printf("[tools/call] custom tool=%s args=%v cwd=%q", tool.Name, spec.Args, spec.Cwd)
+	}
+
+	return spec, nil
+}
+
+// runToolCommand runs the command, streaming output to the client as
+// notifications, and assembles the final tool result.
+func runToolCommand(ctx context.Context, cfg serverConfig, call *toolCall, spec commandSpec) (*toolCallResult, *mcpError) {
+	if spec.ParseEvents && hasJSONFormat(spec.Args) && !jsonFormatSupported(cfg.Target) {
+		spec = spec.textModeSpec()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, cfg.Target, spec.Args...)
+	feed := feedStdin(cmd, []byte(spec.Stdin), cfg.Stdin.Timeout, cancel)
+	if spec.Cwd != "" {
+		cmd.Dir = spec.Cwd
+	}
+	if call.ArtifactDir != "" {
+		cmd.Env = append(os.Environ(), artifactEnv+"="+call.ArtifactDir)
+	}
+	release, err := prepareChild(cfg, cmd)
+	if err != nil {
+		return nil, errStartFailed.err(err.Error())
+	}
+	defer release()
+	runner.KillProcessGroup(cmd)
+
+	call.logf("info", "[tools/call] exec: %s %s (cwd=%q)", cfg.Target, strings.Join(spec.Args, " "), spec.Cwd)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, errStartFailed.err(err.Error())
+	}
+	stderrPipe, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, errStartFailed.err(err.Error())
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, errStartFailed.err(err.Error())
+	}
+	wd := newWatchdog(cfg.StallTimeout)
+	go wd.watch(cmd, cancel)
+
+	// Collect stderr in background
+	var stderrBuf strings.Builder
+	stderrDone := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(io.MultiWriter(&stderrBuf, wd), stderrPipe)
+		close(stderrDone)
+	}()
+
+	ec := newEventCollector(call)
+	adapters := adaptersFor(cfg.CLIVersion)
+	var strictErr string
+	parsed := false
+
+	// Keep the raw bytes of plain output in case they turn out binary
+	var rawOut bytes.Buffer
+	var out io.Reader = stdout
+	if !spec.ParseEvents && cfg.binarySafe() {
+		out = io.TeeReader(stdout, &rawOut)
+	}
+
+	// Stream stdout line by line for better JSON event handling
+	_ = scanLines(out, maxEventLine, func(line string) bool {
+		wd.line(line)
+		if line == "" {
+			return true
+		}
+
+		if spec.Events != nil {
+			if event, known := spec.Events(line); known {
+				if event != nil {
+					ec.event(event)
+				}
+				return true
+			}
+		}
+
+		// For opencode_run with --format json, parse and extract useful info
+		if spec.ParseEvents {
+			if raw, ok := runner.ParseEvent(line); ok {
+				event, known := normalizeEvent(adapters, raw)
+				switch {
+				case known && event != nil:
+					parsed = true
+					ec.event(event)
+				case !known && !cfg.StrictEvents:
+					// Unknown shape: forward as-is rather than dropping it
+					ec.event(raw)
+				}
+				if known || !cfg.StrictEvents {
+					return true
+				}
+			}
+			if cfg.StrictEvents {
+				strictErr = fmt.Sprintf("unrecognized opencode event (cli version %q): %s", cfg.CLIVersion, truncateForLog(line, 200))
+				call.logf("error", "[stream] strict mode: %s", strictErr)
+				cancel()
+				return false
+			}
+		}
+
+		if spec.Unparsed {
+			line = runner.StripANSI(line)
+		}
+		ec.rawLine(line)
+		return true
+	})
+
+	<-stderrDone
+	exitCode := 0
+	waitErr := cmd.Wait()
+	if waitErr != nil {
+		var exitErr *exec.ExitError
+		if errors.As(waitErr, &exitErr) {
+			exitCode = exitErr.ExitCode()
+		}
+	}
+	stall := wd.done()
+	stdinTimedOut := feed.done()
+
+	if spec.ParseEvents && hasJSONFormat(spec.Args) && !parsed && strictErr == "" && ctx.Err() == nil {
+		// The CLI rejected --format json, or ignored it and printed text
+		switch {
+		case exitCode != 0 && rejectsJSONFormat(stderrBuf.String()):
+			markTextMode(cfg.Target, "it rejected the flag")
+			return runToolCommand(ctx, cfg, call, spec.textModeSpec())
+		case exitCode == 0 && len(ec.lines) > 0:
+			markTextMode(cfg.Target, "it printed no JSON events")
+			spec.Unparsed = true
+		}
+	}
+
+	result := ec.result(stderrBuf.String(), exitCode)
+	if mimeType, binary := sniffBinary(rawOut.Bytes(), false); binary {
+		result.setBinaryOutput(rawOut.Bytes(), mimeType, stderrBuf.String(), exitCode)
+	}
+	if spec.Unparsed {
+		result.markUnparsed(cfg.Target)
+	}
+	result.reproduction = newReproduction(cfg, call.Tenant, spec.Args, spec.Cwd, spec.Stdin)
+	if result.Meta == nil {
+		result.Meta = map[string]any{}
+	}
+	result.Meta["reproduce"] = result.reproduction
+	if res := processResources(cmd.ProcessState); res != nil {
+		cfg.Metrics.record(res)
+		result.resources = res
+		result.Meta["resources"] = res
+	}
+	switch {
+	case strictErr != "":
+		result.Content[0].Text += "\n[error] " + strictErr
+		result.IsError = true
+		result.setError(errUnrecognizedEvent)
+	case stdinTimedOut:
+		result.Content[0].Text += "\n[error] " + call.sprintf("stdin not read within %s", cfg.Stdin.Timeout)
+		result.IsError = true
+		result.setError(errStdinTimeout)
+	case stall != nil:
+		result.Content[0].Text += "\n[stalled] " + stall.String()
+		result.IsError = true
+		result.setError(errStalled)
+		result.Meta["stall"] = stall
+		result.stall = stall
+	case result.IsError:
+		result.setError(classifyFailure(ctx.Err(), stderrBuf.String()))
+	}
+	if !result.IsError {
+		if v, ok := structuredListing(call.Name, ec.lines); ok {
+			result.StructuredContent = v
+		}
+	}
+	return result, nil
+}
+
+// maxEventLine is the longest opencode output line that is parsed.
+const maxEventLine = 1024 * 1024
+
+// scanLines calls fn with each line of r until fn returns false or r ends.
+// Unlike bufio.Scanner it skips lines longer than max instead of stopping,
+// which would leave the process blocked on a full pipe until it timed out.
+func scanLines(r io.Reader, max int, fn func(line string) bool) error {
+	br := bufio.NewReaderSize(r, max)
+	skipped := 0
+	for {
+		chunk, err := br.ReadSlice('\n')
+		switch {
+		case err == bufio.ErrBufferFull:
+			skipped += len(chunk)
+			continue
+		case skipped > 0:
+			log.Printf("[stream] skipped a %d-byte line (limit %d)", skipped+len(chunk), max)
+			skipped = 0
+		case len(chunk) > 0:
+			line := strings.TrimSuffix(strings.TrimSuffix(string(chunk), "\n"), "\r")
+			if !fn(line) {
+				return nil
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"opencode-mcp/internal/mcp"
+)
+
+// Test chainTools ordering
+func TestChainTools(t *testing.T) {
+	var order []string
+	mw := func(name string) toolMiddleware {
+		return func(next toolHandler) toolHandler {
+			return func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+				order = append(order, name+":before")
+				result, mErr := next(ctx, call)
+				order = append(order, name+":after")
+				return result, mErr
+			}
+		}
+	}
+	final := func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+		order = append(order, "handler")
+		return &toolCallResult{}, nil
+	}
+
+	h := chainTools(final, mw("outer"), mw("inner"))
+	if _, mErr := h(context.Background(), &toolCall{Name: "x"}); mErr != nil {
+		t.Fatalf("unexpected error: %v", mErr)
+	}
+
+	want := []string{"outer:before", "inner:before", "handler", "inner:after", "outer:after"}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("order = %v, want %v", order, want)
+	}
+}
+
+// Test recoverMiddleware
+func TestRecoverMiddleware(t *testing.T) {
+	h := recoverMiddleware(func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+		panic("boom")
+	})
+	result, mErr := h(context.Background(), &toolCall{Name: "x"})
+	if result != nil {
+		t.Errorf("result = %v, want nil", result)
+	}
+	if mErr == nil || mErr.Code != -32603 {
+		t.Errorf("error = %v, want internal error", mErr)
+	}
+}
+
+// Test that both tools/call paths go through the same handler chain
+func TestToolsCallPathsShareChain(t *testing.T) {
+	var calls []string
+	tools := chainTools(
+		func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+			call.progress(1, "working")
+			return &toolCallResult{Content: []toolContent{{Type: "text", Text: "done"}}}, nil
+		},
+		func(next toolHandler) toolHandler {
+			return func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+				calls = append(calls, call.Name)
+				return next(ctx, call)
+			}
+		},
+	)
+	req := mcpRequest{Request: mcp.Request{
+		JSONRPC: "2.0",
+		Method:  "tools/call",
+		ID:      json.RawMessage("1"),
+		Params:  json.RawMessage(`{"name":"audited","arguments":{}}`),
+	}}
+
+	// The JSON path goes through the dispatcher
+	d := newDispatcher(serverConfig{}, &sessionStore{sessions: map[string]*session{}}, tools)
+	rec := httptest.NewRecorder()
+	writeResponse(rec, &req, d.Dispatch(withRequest(context.Background(), &req), &req.Request))
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("JSON path Content-Type = %q", ct)
+	}
+	if strings.Contains(rec.Body.String(), "notifications/progress") {
+		t.Error("JSON path should not stream notifications")
+	}
+
+	rec = httptest.NewRecorder()
+	handleToolsCallSSE(rec, context.Background(), tools, req)
+	if ct := rec.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("SSE path Content-Type = %q", ct)
+	}
+	resp, _ := parseSSEResponse(rec.Body.Bytes())
+	if resp.Result == nil {
+		t.Errorf("SSE path missing final result: %s", rec.Body.String())
+	}
+
+	if !reflect.DeepEqual(calls, []string{"audited", "audited"}) {
+		t.Errorf("middleware saw %v, want both calls", calls)
+	}
+}
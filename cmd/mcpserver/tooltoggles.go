@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+
+	"opencode-mcp/internal/mcp"
+)
+
+// Tools can be disabled: in the config file with "disabledTools" patterns,
+// and at run time through the admin API, which overrides the config file
+// and is kept in the store so every instance sharing it agrees. Disabled
+// tools are left out of tools/list and can't be called. Sessions with an
+// open stream get notifications/tools/list_changed when the tool set
+// changes, so clients list the tools again.
+const (
+	toolTogglesCollection = "tool_toggles"
+
+	// toolWatchInterval is how often the tool set is compared with the one
+	// last announced, to notice changes made on other instances.
+	toolWatchInterval = 30 * time.Second
+)
+
+// toolToggle is an admin override of whether a tool is enabled.
+type toolToggle struct {
+	Name      string    `json:"name"`
+	Enabled   bool      `json:"enabled"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// toolToggles decides which tools are enabled.
+type toolToggles struct {
+	disabled []string // path.Match patterns from the config file
+	store    *store
+
+	mu     sync.Mutex
+	memory map[string]toolToggle // overrides without a store
+
+	changed chan struct{} // signalled when an override changes
+}
+
+func newToolToggles(disabled []string, st *store) *toolToggles {
+	return &toolToggles{disabled: disabled, store: st, memory: map[string]toolToggle{}, changed: make(chan struct{}, 1)}
+}
+
+func validateDisabledTools(patterns []string) error {
+	for i, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("disabledTools[%d]: invalid pattern %q", i, p)
+		}
+	}
+	return nil
+}
+
+// overrides returns the admin overrides by tool name.
+func (t *toolToggles) overrides() map[string]toolToggle {
+	m := map[string]toolToggle{}
+	if t.store == nil {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		for name, tt := range t.memory {
+			m[name] = tt
+		}
+		return m
+	}
+	for _, doc := range t.store.list(toolTogglesCollection, "") {
+		var tt toolToggle
+		if json.Unmarshal(doc, &tt) == nil {
+			m[tt.Name] = tt
+		}
+	}
+	return m
+}
+
+// configured reports whether the config file leaves the named tool enabled.
+func (t *toolToggles) configured(name string) bool {
+	for _, p := range t.disabled {
+		if ok, _ := path.Match(p, name); ok {
+			return false
+		}
+	}
+	return true
+}
+
+// enabled reports whether the named tool is enabled.
+func (t *toolToggles) enabled(name string) bool {
+	if t == nil {
+		return true
+	}
+	if tt, ok := t.overrides()[name]; ok {
+		return tt.Enabled
+	}
+	return t.configured(name)
+}
+
+// filter returns the enabled tools of tools.
+func (t *toolToggles) filter(tools []mcpTool) []mcpTool {
+	if t == nil {
+		return tools
+	}
+	overrides := t.overrides()
+	var out []mcpTool
+	for _, tool := range tools {
+		enabled := t.configured(tool.Name)
+		if tt, ok := overrides[tool.Name]; ok {
+			enabled = tt.Enabled
+		}
+		if enabled {
+			out = append(out, tool)
+		}
+	}
+	return out
+}
+
+// set overrides whether the named tool is enabled.
+func (t *toolToggles) set(name string, enabled bool) error {
+	tt := toolToggle{Name: name, Enabled: enabled, UpdatedAt: localTime(time.Now())}
+	if t.store == nil {
+		t.mu.Lock()
+		t.memory[name] = tt
+		t.mu.Unlock()
+	} else if err := t.store.put(toolTogglesCollection, name, tt); err != nil {
+		return err
+	}
+	t.signal()
+	return nil
+}
+
+// reset drops the override of the named tool, reporting false when there
+// was none.
+func (t *toolToggles) reset(name string) (bool, error) {
+	var ok bool
+	if t.store == nil {
+		t.mu.Lock()
+		_, ok = t.memory[name]
+		delete(t.memory, name)
+		t.mu.Unlock()
+	} else {
+		var err error
+		if ok, err = t.store.delete(toolTogglesCollection, name); err != nil {
+			return false, err
+		}
+	}
+	if ok {
+		t.signal()
+	}
+	return ok, nil
+}
+
+func (t *toolToggles) signal() {
+	select {
+	case t.changed <- struct{}{}:
+	default:
+	}
+}
+
+// toolToggleMiddleware rejects calls to disabled tools.
+func toolToggleMiddleware(cfg serverConfig) toolMiddleware {
+	return func(next toolHandler) toolHandler {
+		return func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+			if !cfg.ToolToggles.enabled(call.Name) {
+				return nil, errUnknownTool.err(fmt.Sprintf("tool %s is disabled", call.Name))
+			}
+			return next(ctx, call)
+		}
+	}
+}
+
+// watchTools announces changes of the tool set to the sessions with an
+// open stream, as soon as an override changes here and every interval for
+// those made elsewhere.
+func watchTools(cfg serverConfig, sessions *sessionStore, interval time.Duration) {
+	known := toolSetDigest(toolDefinitions(cfg))
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-cfg.ToolToggles.changed:
+		}
+		known = announceToolChanges(cfg, sessions, known)
+	}
+}
+
+// announceToolChanges broadcasts notifications/tools/list_changed when the
+// tool set differs from the one with digest known, returning the digest of
+// the set now.
+func announceToolChanges(cfg serverConfig, sessions *sessionStore, known string) string {
+	tools := toolDefinitions(cfg)
+	digest := toolSetDigest(tools)
+	if digest == known {
+		return known
+	}
+	log.Printf("[tools] tool set changed, %d tools", len(tools))
+	sessions.broadcast(mcp.Notification(mcp.MethodToolsListChanged, map[string]any{}))
+	return digest
+}
+
+// toolSetDigest identifies a tool set, definitions included.
+func toolSetDigest(tools []mcpTool) string {
+	b, _ := json.Marshal(tools)
+	return fmt.Sprintf("%x", sha256.Sum256(b))
+}
+
+// toolStatus is a tool as GET /admin/tools lists it.
+type toolStatus struct {
+	Name    string      `json:"name"`
+	Source  string      `json:"source"` // builtin, custom, plugin or server
+	Enabled bool        `json:"enabled"`
+	Config  bool        `json:"config"`             // enabled by the config file
+	Admin   *toolToggle `json:"override,omitempty"` // set through the admin API
+}
+
+// registerToolRoutes adds the admin API enabling and disabling tools.
+func registerToolRoutes(mux *http.ServeMux, cfg serverConfig, admin func(http.HandlerFunc) http.HandlerFunc) {
+	known := func(name string) (mcpTool, bool) {
+		for _, t := range allToolDefinitions(cfg) {
+			if t.Name == name {
+				return t, true
+			}
+		}
+		return mcpTool{}, false
+	}
+	mux.HandleFunc("GET /admin/tools", admin(func(w http.ResponseWriter, r *http.Request) {
+		sources := toolSources(cfg)
+		overrides := cfg.ToolToggles.overrides()
+		statuses := []toolStatus{}
+		for _, t := range allToolDefinitions(cfg) {
+			s := toolStatus{Name: t.Name, Source: sources[t.Name], Config: cfg.ToolToggles.configured(t.Name)}
+			s.Enabled = s.Config
+			if tt, ok := overrides[t.Name]; ok {
+				s.Enabled, s.Admin = tt.Enabled, &tt
+			}
+			statuses = append(statuses, s)
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"tools": statuses})
+	}))
+	mux.HandleFunc("PUT /admin/tools/{name}", admin(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Enabled *bool `json:"enabled"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Enabled == nil {
+			http.Error(w, `want {"enabled": true|false}`, http.StatusBadRequest)
+			return
+		}
+		name := r.PathValue("name")
+		if _, ok := known(name); !ok {
+			http.Error(w, "unknown tool", http.StatusNotFound)
+			return
+		}
+		if err := cfg.ToolToggles.set(name, *req.Enabled); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("[tools] %s enabled=%v", name, *req.Enabled)
+		writeJSON(w, http.StatusOK, map[string]any{"name": name, "enabled": *req.Enabled})
+	}))
+	mux.HandleFunc("DELETE /admin/tools/{name}", admin(func(w http.ResponseWriter, r *http.Request) {
+		ok, err := cfg.ToolToggles.reset(r.PathValue("name"))
+		switch {
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		case !ok:
+			http.Error(w, "no override for this tool", http.StatusNotFound)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func toolNames(tools []mcpTool) map[string]bool {
+	names := map[string]bool{}
+	for _, t := range tools {
+		names[t.Name] = true
+	}
+	return names
+}
+
+// Test config patterns and admin overrides decide which tools are listed
+// and callable
+func TestToolToggles(t *testing.T) {
+	if err := validateDisabledTools([]string{"opencode_[", "ok"}); err == nil || !strings.Contains(err.Error(), "disabledTools[0]") {
+		t.Errorf("invalid pattern: %v", err)
+	}
+	st, err := openStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := serverConfig{ToolToggles: newToolToggles([]string{"opencode_session_*"}, st)}
+	names := toolNames(toolDefinitions(cfg))
+	if names[toolSessionList] || !names[toolExec] || !names[toolRun] {
+		t.Errorf("tools with opencode_session_* disabled: %v", names)
+	}
+
+	if err := cfg.ToolToggles.set(toolExec, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.ToolToggles.set(toolSessionList, true); err != nil {
+		t.Fatal(err)
+	}
+	names = toolNames(toolDefinitions(cfg))
+	if names[toolExec] || !names[toolSessionList] {
+		t.Errorf("tools with overrides: %v", names)
+	}
+	h := newToolHandler(cfg)
+	if _, mErr := h(context.Background(), &toolCall{Name: toolExec, Arguments: json.RawMessage(`{"args":["--version"]}`)}); mErr == nil || mErr.Data.Code != errUnknownTool.Code {
+		t.Errorf("disabled tool call = %+v", mErr)
+	}
+
+	if ok, err := cfg.ToolToggles.reset(toolExec); !ok || err != nil {
+		t.Errorf("reset = %v, %v", ok, err)
+	}
+	if ok, _ := cfg.ToolToggles.reset(toolExec); ok {
+		t.Error("reset without an override")
+	}
+	if !cfg.ToolToggles.enabled(toolExec) {
+		t.Error("tool still disabled after reset")
+	}
+}
+
+// Test a changed tool set is announced once to sessions with a stream
+func TestAnnounceToolChanges(t *testing.T) {
+	cfg := serverConfig{ToolToggles: newToolToggles(nil, nil)}
+	sessions := &sessionStore{sessions: map[string]*session{"s": {id: "s"}}}
+	known := toolSetDigest(toolDefinitions(cfg))
+	events, stop := sessions.sessions["s"].subscribe(0)
+	defer stop()
+
+	if got := announceToolChanges(cfg, sessions, known); got != known || len(events) != 0 {
+		t.Error("notified without a change")
+	}
+	if err := cfg.ToolToggles.set(toolExec, false); err != nil {
+		t.Fatal(err)
+	}
+	known = announceToolChanges(cfg, sessions, known)
+	select {
+	case ev := <-events:
+		if method := ev.Msg.(map[string]any)["method"]; method != "notifications/tools/list_changed" {
+			t.Errorf("method = %v", method)
+		}
+	default:
+		t.Fatal("no notification")
+	}
+	announceToolChanges(cfg, sessions, known)
+	if len(events) != 0 {
+		t.Error("notified twice")
+	}
+}
+
+// Test the admin API lists, disables and resets tools
+func TestAdminToolRoutes(t *testing.T) {
+	cfg := serverConfig{Binaries: newBinarySwitch("opencode", ""), ToolToggles: newToolToggles([]string{toolModels}, nil)}
+	mux := http.NewServeMux()
+	registerAdminRoutes(mux, cfg, "secret")
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do(http.MethodPut, "/admin/tools/nope", `{"enabled":false}`); w.Code != http.StatusNotFound {
+		t.Errorf("unknown tool: status %d", w.Code)
+	}
+	if w := do(http.MethodPut, "/admin/tools/"+toolExec, `{}`); w.Code != http.StatusBadRequest {
+		t.Errorf("missing enabled: status %d", w.Code)
+	}
+	if w := do(http.MethodPut, "/admin/tools/"+toolExec, `{"enabled":false}`); w.Code != http.StatusOK {
+		t.Fatalf("disable: status %d: %s", w.Code, w.Body)
+	}
+
+	w := do(http.MethodGet, "/admin/tools", "")
+	var list struct {
+		Tools []toolStatus `json:"tools"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatalf("list: %s (%v)", w.Body, err)
+	}
+	statuses := map[string]toolStatus{}
+	for _, s := range list.Tools {
+		statuses[s.Name] = s
+	}
+	if s := statuses[toolExec]; s.Enabled || !s.Config || s.Admin == nil || s.Source != "builtin" {
+		t.Errorf("%s = %+v", toolExec, s)
+	}
+	if s := statuses[toolModels]; s.Enabled || s.Config || s.Admin != nil {
+		t.Errorf("%s = %+v", toolModels, s)
+	}
+
+	if w := do(http.MethodDelete, "/admin/tools/"+toolExec, ""); w.Code != http.StatusNoContent {
+		t.Errorf("reset: status %d", w.Code)
+	}
+	if w := do(http.MethodDelete, "/admin/tools/"+toolExec, ""); w.Code != http.StatusNotFound {
+		t.Errorf("reset again: status %d", w.Code)
+	}
+}
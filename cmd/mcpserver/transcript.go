@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"opencode-mcp/internal/runner"
+)
+
+const (
+	transcriptsCollection = "transcripts"
+
+	// maxTranscriptOutput caps each tool output kept in a transcript.
+	maxTranscriptOutput = 4000
+	// maxTranscriptEntries caps the entries of a stored transcript, so
+	// long runs don't bloat the store; their middle is left out.
+	maxTranscriptEntries = 200
+)
+
+// transcriptEntry is one step boundary, text part or finished tool call of
+// a run.
+type transcriptEntry struct {
+	Time   time.Time       `json:"time"`
+	Type   string          `json:"type"` // step, text, tool, or omitted for a gap left by trimmed
+	Text   string          `json:"text,omitempty"`
+	Tool   string          `json:"tool,omitempty"`
+	Status string          `json:"status,omitempty"`
+	Input  json.RawMessage `json:"input,omitempty"`
+	Output string          `json:"output,omitempty"`
+}
+
+// runTranscript is persisted next to the run record.
+type runTranscript struct {
+	Prompt  string            `json:"prompt"`
+	Entries []transcriptEntry `json:"entries"`
+}
+
+// transcriptEntryFrom converts an opencode event into a transcript entry.
+// Tool calls are only kept once they have finished.
+func transcriptEntryFrom(eventType string, data any) (transcriptEntry, bool) {
+	switch eventType {
+	case "step_start":
+		return transcriptEntry{Type: "step"}, true
+	case "text":
+		if text, ok := data.(string); ok && text != "" {
+			return transcriptEntry{Type: "text", Text: text}, true
+		}
+	case "tool_use":
+		m, ok := data.(map[string]any)
+		if !ok {
+			return transcriptEntry{}, false
+		}
+		status, _ := m["status"].(string)
+		if status != "completed" && status != "error" {
+			return transcriptEntry{}, false
+		}
+		e := transcriptEntry{Type: "tool", Status: status}
+		e.Tool, _ = m["tool"].(string)
+		if input, ok := m["input"]; ok && input != nil {
+			e.Input, _ = json.Marshal(input)
+		}
+		switch out := m["output"].(type) {
+		case string:
+			e.Output = out
+		case nil:
+		default:
+			b, _ := json.Marshal(out)
+			e.Output = string(b)
+		}
+		if status == "error" && e.Output == "" {
+			e.Output, _ = m["error"].(string)
+		}
+		e.Output = truncateForLog(e.Output, maxTranscriptOutput)
+		return e, true
+	}
+	return transcriptEntry{}, false
+}
+
+// trimmed returns t with at most maxTranscriptEntries entries: the start
+// and the end of a longer run, around an omitted entry counting the rest.
+func (t runTranscript) trimmed() runTranscript {
+	n := len(t.Entries)
+	if n <= maxTranscriptEntries {
+		return t
+	}
+	head := maxTranscriptEntries / 2
+	tail := maxTranscriptEntries - head - 1
+	entries := make([]transcriptEntry, 0, maxTranscriptEntries)
+	entries = append(entries, t.Entries[:head]...)
+	entries = append(entries, transcriptEntry{Time: t.Entries[head].Time, Type: "omitted", Text: fmt.Sprintf("%d entries omitted", n-head-tail)})
+	t.Entries = append(entries, t.Entries[n-tail:]...)
+	return t
+}
+
+func (h runHistory) saveTranscript(tenant, id string, t runTranscript) error {
+	if h.store == nil {
+		return errNoStore
+	}
+	return h.store.putCompressed(transcriptsCollection, runKey(tenant, id), t.trimmed())
+}
+
+func (h runHistory) transcript(tenant, id string) (runTranscript, bool, error) {
+	var t runTranscript
+	if h.store == nil {
+		return t, false, errNoStore
+	}
+	ok, err := h.store.getCompressed(transcriptsCollection, runKey(tenant, id), &t)
+	return t, ok, err
+}
+
+// renderTranscript formats a run as Markdown for pasting into PRs and
+// tickets: metadata, prompt, steps with collapsed tool calls, the final
+// answer and a cost footer.
+func renderTranscript(rec runRecord, t runTranscript) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# opencode run %s\n\n", rec.ID)
+
+	b.WriteString("| | |\n|---|---|\n")
+	row := func(k, v string) {
+		if v != "" {
+			fmt.Fprintf(&b, "| %s | %s |\n", k, strings.ReplaceAll(v, "|", `\|`))
+		}
+	}
+	row("Model", rec.Model)
+	row("Directory", rec.Cwd)
+	row("Session", rec.Session)
+	row("Status", rec.Status)
+	row("Started", rec.StartedAt.Format(time.RFC3339))
+	if len(rec.Labels) > 0 {
+		row("Labels", strings.Join(rec.Labels, ", "))
+	}
+
+	prompt := t.Prompt
+	if prompt == "" {
+		prompt = rec.Message
+	}
+	b.WriteString("\n## Prompt\n\n")
+	for _, line := range strings.Split(strings.TrimRight(prompt, "\n"), "\n") {
+		b.WriteString(strings.TrimRight("> "+line, " ") + "\n")
+	}
+
+	// The last text part is the answer; everything before it is a step.
+	entries, answer := t.Entries, ""
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Type == "text" {
+			answer = entries[i].Text
+			entries = append(entries[:i:i], entries[i+1:]...)
+			break
+		}
+	}
+	for len(entries) > 0 && entries[len(entries)-1].Type == "step" {
+		entries = entries[:len(entries)-1]
+	}
+
+	if hasSteps(entries) {
+		b.WriteString("\n## Steps\n")
+		step := 0
+		for _, e := range entries {
+			switch e.Type {
+			case "step":
+				step++
+				fmt.Fprintf(&b, "\n### Step %d\n", step)
+			case "text":
+				b.WriteString("\n" + strings.TrimSpace(e.Text) + "\n")
+			case "omitted":
+				b.WriteString("\n_… " + e.Text + " …_\n")
+			case "tool":
+				fmt.Fprintf(&b, "\n<details>\n<summary>Tool <code>%s</code> (%s)</summary>\n\n", e.Tool, e.Status)
+				if len(e.Input) > 0 {
+					b.WriteString(runner.MarkdownFence(string(e.Input), "json"))
+				}
+				if e.Output != "" {
+					b.WriteString(runner.MarkdownFence(e.Output, ""))
+				}
+				b.WriteString("</details>\n")
+			}
+		}
+	}
+
+	b.WriteString("\n## Answer\n\n")
+	switch {
+	case answer != "":
+		b.WriteString(strings.TrimSpace(answer) + "\n")
+	case rec.Error != "":
+		b.WriteString("**Error:** " + rec.Error + "\n")
+	default:
+		b.WriteString("_No answer._\n")
+	}
+
+	b.WriteString("\n---\n\n")
+	footer := []string{fmt.Sprintf("Cost: $%.4f", rec.Cost)}
+	if rec.InputTokens > 0 || rec.OutputTokens > 0 {
+		footer = append(footer, fmt.Sprintf("%d input / %d output tokens", rec.InputTokens, rec.OutputTokens))
+	}
+	footer = append(footer, (time.Duration(rec.DurationMs) * time.Millisecond).String())
+	b.WriteString("_" + strings.Join(footer, " · ") + "_\n")
+	return b.String()
+}
+
+// hasSteps reports whether entries contain anything besides step markers.
+func hasSteps(entries []transcriptEntry) bool {
+	for _, e := range entries {
+		if e.Type != "step" {
+			return true
+		}
+	}
+	return false
+}
+
+// registerTranscriptRoutes adds GET /calls/{id}/transcript.md.
+func registerTranscriptRoutes(mux *http.ServeMux, cfg serverConfig) {
+	history := runHistory{cfg.Store}
+
+	mux.HandleFunc("GET /calls/{id}/transcript.md", func(w http.ResponseWriter, r *http.Request) {
+		tenant, id := tenantFromRequest(r), r.PathValue("id")
+		rec, ok, err := history.get(tenant, id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "run not found", http.StatusNotFound)
+			return
+		}
+		t, _, err := history.transcript(tenant, id)
+		if err != nil {
+			log.Printf("[runs] transcript %s: %v", id, err)
+		}
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		_, _ = w.Write([]byte(renderTranscript(rec, t)))
+	})
+}
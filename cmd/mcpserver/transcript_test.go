@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test a run's transcript is rendered as Markdown
+func TestTranscriptEndpoint(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "opencode")
+	content := `#!/bin/sh
+echo '{"type":"step_start","part":{}}'
+echo '{"type":"text","sessionID":"ses_t","part":{"text":"Let me look."}}'
+printf '%s\n' '{"type":"tool_use","part":{"tool":"bash","state":{"status":"completed","input":{"command":"ls"},"output":"main.go\n` + "```" + `"}}}'
+echo '{"type":"step_finish","part":{"cost":0.25,"tokens":{"input":100,"output":20}}}'
+echo '{"type":"step_start","part":{}}'
+echo '{"type":"text","part":{"text":"There is one file."}}'
+echo '{"type":"step_finish","part":{"cost":0.25,"tokens":{"input":50,"output":10}}}'
+`
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	st, _ := openStore("")
+	cfg := serverConfig{Target: script, DefaultTimeout: 5 * time.Second, Store: st}
+	call := &toolCall{ID: json.RawMessage("1"), Name: toolRun, Tenant: "team-a", Arguments: json.RawMessage(`{"message":"list the files\nbriefly","model":"m"}`)}
+	if _, mErr := newToolHandler(cfg)(context.Background(), call); mErr != nil {
+		t.Fatalf("run: %v", mErr)
+	}
+
+	mux := http.NewServeMux()
+	registerTranscriptRoutes(mux, cfg)
+	get := func(tenant string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/calls/"+call.RunID+"/transcript.md", nil)
+		req.Header.Set(tenantHeader, tenant)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+	rec := get("team-a")
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/markdown") {
+		t.Fatalf("status = %d, content type = %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	md := rec.Body.String()
+	for _, want := range []string{
+		"# opencode run " + call.RunID,
+		"| Session | ses_t |",
+		"> list the files\n> briefly\n",
+		"### Step 1\n\nLet me look.",
+		"<summary>Tool <code>bash</code> (completed)</summary>",
+		"````\nmain.go\n```\n````",
+		"## Answer\n\nThere is one file.",
+		"Cost: $0.5000 · 150 input / 30 output tokens",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("transcript missing %q:\n%s", want, md)
+		}
+	}
+	if rec := get("team-b"); rec.Code != http.StatusNotFound {
+		t.Errorf("other tenant status = %d, want 404", rec.Code)
+	}
+}
+
+// Test long transcripts are stored with their start and end only
+func TestTranscriptTrimmed(t *testing.T) {
+	st, _ := openStore("")
+	h := runHistory{st}
+	var entries []transcriptEntry
+	for i := 0; i < 3*maxTranscriptEntries; i++ {
+		entries = append(entries, transcriptEntry{Type: "tool", Tool: "bash", Status: "completed", Output: strconv.Itoa(i)})
+	}
+	entries = append(entries, transcriptEntry{Type: "text", Text: "done"})
+	if err := h.saveTranscript("t", "long", runTranscript{Entries: entries}); err != nil {
+		t.Fatal(err)
+	}
+	got, _, _ := h.transcript("t", "long")
+	n := len(got.Entries)
+	if n != maxTranscriptEntries || got.Entries[0].Output != "0" || got.Entries[n-1].Text != "done" {
+		t.Fatalf("trimmed to %d entries: first %+v, last %+v", n, got.Entries[0], got.Entries[n-1])
+	}
+	gap := got.Entries[maxTranscriptEntries/2]
+	if gap.Type != "omitted" || gap.Text != strconv.Itoa(len(entries)-maxTranscriptEntries+1)+" entries omitted" {
+		t.Errorf("gap = %+v", gap)
+	}
+	if md := renderTranscript(runRecord{ID: "long"}, got); !strings.Contains(md, "entries omitted …_") || !strings.Contains(md, "## Answer\n\ndone") {
+		t.Errorf("rendered:\n%s", md[:500])
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"opencode-mcp/internal/mcp"
+)
+
+// Sessions can resources/subscribe to opencode://session/<id> resources
+// and get notifications/resources/updated whenever a run of that session
+// appends to its transcript, so a dashboard can follow an agent without
+// polling. The transcript of a run in progress is kept in memory until the
+// run is recorded, and resources/read renders it after the stored runs.
+// Subscriptions belong to the MCP session on this instance and end with it.
+
+// resourceUpdateInterval is how often a resource's subscribers are told
+// about it at most; updates in between are coalesced into one.
+const resourceUpdateInterval = time.Second
+
+// liveRun is a run whose transcript is still growing.
+type liveRun struct {
+	rec        runRecord
+	transcript runTranscript
+}
+
+// transcriptFeed keeps the transcripts of running opencode_runs and the
+// sessions subscribed to them. A nil *transcriptFeed keeps nothing.
+type transcriptFeed struct {
+	mu       sync.Mutex
+	interval time.Duration
+	live     map[string]*liveRun            // by runKey
+	subs     map[*session]map[string]string // subscribed URI -> tenant
+	windows  map[string]*bool               // tenant and URI -> updated since the last notification
+}
+
+func newTranscriptFeed() *transcriptFeed {
+	return &transcriptFeed{
+		interval: resourceUpdateInterval,
+		live:     map[string]*liveRun{},
+		subs:     map[*session]map[string]string{},
+		windows:  map[string]*bool{},
+	}
+}
+
+// subscribe sends sess the updates of uri, for tenant.
+func (f *transcriptFeed) subscribe(sess *session, tenant, uri string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.subs[sess] == nil {
+		f.subs[sess] = map[string]string{}
+	}
+	f.subs[sess][uri] = tenant
+}
+
+// unsubscribe stops the updates of uri to sess.
+func (f *transcriptFeed) unsubscribe(sess *session, uri string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.subs[sess], uri)
+	if len(f.subs[sess]) == 0 {
+		delete(f.subs, sess)
+	}
+}
+
+// forget drops the subscriptions of a session that ended.
+func (f *transcriptFeed) forget(sess *session) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.subs, sess)
+}
+
+// append adds entry to the live transcript of the run rec, in the opencode
+// session it reported, if any.
+func (f *transcriptFeed) append(rec runRecord, prompt, session string, entry transcriptEntry) {
+	f.mu.Lock()
+	key := runKey(rec.Tenant, rec.ID)
+	lr := f.live[key]
+	if lr == nil {
+		lr = &liveRun{rec: rec, transcript: runTranscript{Prompt: prompt}}
+		f.live[key] = lr
+	}
+	if session != "" {
+		lr.rec.Session = session
+	}
+	lr.transcript.Entries = append(lr.transcript.Entries, entry)
+	session = lr.rec.Session
+	f.mu.Unlock()
+	if session != "" {
+		f.updated(rec.Tenant, mcp.SessionURI(session))
+	}
+}
+
+// finish drops the live transcript of a run once it has been recorded.
+func (f *transcriptFeed) finish(tenant, id string) {
+	f.mu.Lock()
+	key := runKey(tenant, id)
+	lr := f.live[key]
+	delete(f.live, key)
+	f.mu.Unlock()
+	if lr != nil && lr.rec.Session != "" {
+		f.updated(tenant, mcp.SessionURI(lr.rec.Session))
+	}
+}
+
+// running returns copies of the tenant's live runs in the opencode session
+// id, oldest first.
+func (f *transcriptFeed) running(tenant, id string) []liveRun {
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var runs []liveRun
+	for _, lr := range f.live {
+		if lr.rec.Tenant == tenant && lr.rec.Session == id {
+			c := *lr
+			c.transcript.Entries = append([]transcriptEntry(nil), lr.transcript.Entries...)
+			runs = append(runs, c)
+		}
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].rec.StartedAt.Before(runs[j].rec.StartedAt) })
+	return runs
+}
+
+// updated notifies the subscribers of uri at once, unless they were
+// notified within the interval; then they are notified once it is over.
+func (f *transcriptFeed) updated(tenant, uri string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := tenant + " " + uri
+	if dirty, ok := f.windows[key]; ok {
+		*dirty = true
+		return
+	}
+	f.notifyLocked(tenant, uri)
+	f.windows[key] = new(bool)
+	time.AfterFunc(f.interval, func() { f.closeWindow(tenant, uri) })
+}
+
+func (f *transcriptFeed) closeWindow(tenant, uri string) {
+	f.mu.Lock()
+	key := tenant + " " + uri
+	dirty := f.windows[key]
+	delete(f.windows, key)
+	f.mu.Unlock()
+	if dirty != nil && *dirty {
+		f.updated(tenant, uri)
+	}
+}
+
+func (f *transcriptFeed) notifyLocked(tenant, uri string) {
+	for sess, uris := range f.subs {
+		if t, ok := uris[uri]; ok && t == tenant {
+			sess.publish(mcp.Notification(mcp.MethodResourcesUpdated, map[string]any{"uri": uri}))
+		}
+	}
+}
+
+// transcriptFeedMiddleware keeps the transcript of an opencode_run in the
+// feed while it runs. It wraps the run history, so the run is recorded
+// before it leaves the feed.
+func transcriptFeedMiddleware(cfg serverConfig) toolMiddleware {
+	return func(next toolHandler) toolHandler {
+		return func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+			if call.Name != toolRun || cfg.Transcripts == nil {
+				return next(ctx, call)
+			}
+			runArgs, mErr := parseRunArgs(call)
+			if mErr != nil {
+				return next(ctx, call)
+			}
+			if call.RunID == "" {
+				call.RunID = generateSessionID()
+			}
+			rec := runRecord{
+				ID:        call.RunID,
+				Tenant:    call.Tenant,
+				Labels:    runArgs.Labels,
+				Cwd:       runArgs.Cwd,
+				Model:     runArgs.Model,
+				Session:   runArgs.Session,
+				Message:   truncateForLog(runArgs.Message, runMessagePreview),
+				StartedAt: localTime(time.Now()),
+				Status:    "running",
+			}
+			if rec.Cwd == "" {
+				rec.Cwd = call.Cwd
+			}
+			call.appended = func(session string, entry transcriptEntry) {
+				cfg.Transcripts.append(rec, runArgs.Message, session, entry)
+			}
+			defer cfg.Transcripts.finish(call.Tenant, call.RunID)
+			return next(ctx, call)
+		}
+	}
+}
+
+// handleResourcesSubscribe answers resources/subscribe and
+// resources/unsubscribe for opencode session resources.
+func handleResourcesSubscribe(_ context.Context, cfg serverConfig, req *mcpRequest) (any, *mcpError) {
+	if cfg.Transcripts == nil {
+		return nil, rpcError(-32601, fmt.Sprintf("method not found: %s", req.Method))
+	}
+	var params struct {
+		URI string `json:"uri"`
+	}
+	if err := json.Unmarshal(req.Params, &params); err != nil || params.URI == "" {
+		return nil, rpcError(-32602, "invalid params")
+	}
+	if req.Session == nil {
+		return nil, errSessionRequired.err("missing Mcp-Session-Id")
+	}
+	if _, ok := mcp.SessionID(params.URI); !ok {
+		return nil, rpcError(-32602, fmt.Sprintf("only opencode session resources can be subscribed to, not %s", params.URI))
+	}
+	if req.Method == mcp.MethodResourcesUnsubscribe {
+		cfg.Transcripts.unsubscribe(req.Session, params.URI)
+	} else {
+		cfg.Transcripts.subscribe(req.Session, req.Tenant, params.URI)
+	}
+	log.Printf("[MCP] session=%s %s %s", req.Session.id, req.Method, params.URI)
+	return map[string]any{}, nil
+}
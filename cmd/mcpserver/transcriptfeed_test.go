@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"opencode-mcp/internal/mcp"
+)
+
+// nextUpdate returns the URI of the next notifications/resources/updated
+// on events, failing after a second.
+func nextUpdate(t *testing.T, events <-chan sseEvent) string {
+	t.Helper()
+	select {
+	case ev := <-events:
+		msg, _ := json.Marshal(ev.Msg)
+		var n struct {
+			Method string `json:"method"`
+			Params struct {
+				URI string `json:"uri"`
+			} `json:"params"`
+		}
+		_ = json.Unmarshal(msg, &n)
+		if n.Method != mcp.MethodResourcesUpdated {
+			t.Fatalf("notification %s", msg)
+		}
+		return n.Params.URI
+	case <-time.After(time.Second):
+		t.Fatal("no update")
+		return ""
+	}
+}
+
+// Test updates reach the subscribers of the tenant, coalesced per interval
+func TestTranscriptFeed(t *testing.T) {
+	feed := newTranscriptFeed()
+	feed.interval = 50 * time.Millisecond
+	mine, other := &session{id: "a"}, &session{id: "b"}
+	uri := mcp.SessionURI("ses_x")
+	feed.subscribe(mine, "team-a", uri)
+	feed.subscribe(other, "team-b", uri)
+	events, stop := mine.subscribe(0)
+	defer stop()
+	otherEvents, stopOther := other.subscribe(0)
+	defer stopOther()
+
+	rec := runRecord{ID: "r1", Tenant: "team-a", Status: "running"}
+	feed.append(rec, "hi", "ses_x", transcriptEntry{Type: "text", Text: "one"})
+	if got := nextUpdate(t, events); got != uri {
+		t.Errorf("updated %s", got)
+	}
+	feed.append(rec, "hi", "", transcriptEntry{Type: "text", Text: "two"})
+	feed.append(rec, "hi", "", transcriptEntry{Type: "text", Text: "three"})
+	if len(events) != 0 {
+		t.Error("not coalesced")
+	}
+	nextUpdate(t, events)
+	if runs := feed.running("team-a", "ses_x"); len(runs) != 1 || len(runs[0].transcript.Entries) != 3 || runs[0].transcript.Prompt != "hi" {
+		t.Errorf("running = %+v", runs)
+	}
+	if runs := feed.running("team-b", "ses_x"); len(runs) != 0 {
+		t.Errorf("other tenant's running = %+v", runs)
+	}
+	if len(otherEvents) != 0 {
+		t.Error("other tenant notified")
+	}
+
+	time.Sleep(2 * feed.interval)
+	feed.unsubscribe(mine, uri)
+	feed.finish("team-a", "r1")
+	if len(feed.running("team-a", "ses_x")) != 0 {
+		t.Error("finished run still live")
+	}
+	time.Sleep(2 * feed.interval)
+	if len(events) != 0 {
+		t.Error("notified after unsubscribe")
+	}
+}
+
+// Test a subscribed session follows a run's transcript while it runs
+func TestResourceSubscriptions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell script")
+	}
+	dir := t.TempDir()
+	done := filepath.Join(dir, "done")
+	script := filepath.Join(dir, "opencode")
+	body := "#!/bin/sh\n[ \"$1\" = models ] && exit 0\n" +
+		`echo '{"type":"text","sessionID":"ses_live","part":{"text":"first part"}}'` + "\n" +
+		"while [ ! -f " + done + " ]; do sleep 0.05; done\n" +
+		`echo '{"type":"text","sessionID":"ses_live","part":{"text":"second part"}}'` + "\n"
+	if err := os.WriteFile(script, []byte(body), 0755); err != nil {
+		t.Fatal(err)
+	}
+	cfg := serverConfig{Target: script, DefaultTimeout: 5 * time.Second, Transcripts: newTranscriptFeed()}
+	cfg.Transcripts.interval = 10 * time.Millisecond
+	sessions := &sessionStore{sessions: map[string]*session{}, transcripts: cfg.Transcripts}
+	sess := sessions.create("dashboard", clientCapabilities{})
+	events, stop := sess.subscribe(0)
+	defer stop()
+
+	srv := httptest.NewServer(newMCPHandler(sessions, cfg))
+	defer srv.Close()
+	call := func(session, method, params string, result any) *mcpError {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"`+method+`","params":`+params+`}`))
+		if session != "" {
+			req.Header.Set("Mcp-Session-Id", session)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var out struct {
+			Result json.RawMessage `json:"result"`
+			Error  *mcpError       `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			t.Fatal(err)
+		}
+		if out.Error == nil && result != nil {
+			_ = json.Unmarshal(out.Result, result)
+		}
+		return out.Error
+	}
+	uri := mcp.SessionURI("ses_live")
+	if err := call("", mcp.MethodResourcesSubscribe, `{"uri":"`+uri+`"}`, nil); err == nil {
+		t.Error("subscribed without a session")
+	}
+	if err := call(sess.id, mcp.MethodResourcesSubscribe, `{"uri":"`+mcp.EffectiveConfigURI+`"}`, nil); err == nil || err.Code != -32602 {
+		t.Errorf("subscribe to %s: %+v", mcp.EffectiveConfigURI, err)
+	}
+	if err := call(sess.id, mcp.MethodResourcesSubscribe, `{"uri":"`+uri+`"}`, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	ran := make(chan *mcpError, 1)
+	go func() {
+		_, mErr := newToolHandler(cfg)(context.Background(), &toolCall{ID: json.RawMessage("1"), Name: toolRun, Tenant: defaultTenant, Arguments: json.RawMessage(`{"message":"watch me"}`)})
+		ran <- mErr
+	}()
+	if got := nextUpdate(t, events); got != uri {
+		t.Errorf("updated %s", got)
+	}
+	var read struct {
+		Contents []mcp.ResourceContents `json:"contents"`
+	}
+	if err := call(sess.id, "resources/read", `{"uri":"`+uri+`"}`, &read); err != nil {
+		t.Fatal(err)
+	}
+	if text := read.Contents[0].Text; !strings.Contains(text, "first part") || !strings.Contains(text, "| Status | running |") {
+		t.Errorf("live transcript:\n%s", text)
+	}
+
+	if err := os.WriteFile(done, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if mErr := <-ran; mErr != nil {
+		t.Fatal(mErr)
+	}
+	nextUpdate(t, events) // the second part, and the run leaving the feed
+	if err := call(sess.id, "resources/read", `{"uri":"`+uri+`"}`, &read); err == nil {
+		t.Errorf("finished run read without a store: %+v", read)
+	}
+
+	sessions.remove(sess.id)
+	if len(cfg.Transcripts.subs) != 0 {
+		t.Error("subscriptions outlived the session")
+	}
+}
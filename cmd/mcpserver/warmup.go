@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWarmupInterval = 6 * time.Hour
+	warmupTimeout         = 30 * time.Minute // per command
+)
+
+// warmupStatus is a project's last warm-up, reported in /status.
+type warmupStatus struct {
+	Project  string    `json:"project"`
+	State    string    `json:"state"` // warming, ok or failed
+	Started  time.Time `json:"startedAt"`
+	Duration string    `json:"duration,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// warmupCache runs the "warmup" commands of registered projects (go mod
+// download, npm ci, ...) every interval, so runs that build or test find
+// the dependency caches filled. The commands run in the project's path
+// alongside any runs there, so they should be safe to repeat.
+type warmupCache struct {
+	interval time.Duration
+
+	mu     sync.Mutex
+	states map[string]warmupStatus
+}
+
+// newWarmupCache returns nil (no warm-up) for a non-positive interval.
+func newWarmupCache(interval time.Duration) *warmupCache {
+	if interval <= 0 {
+		return nil
+	}
+	return &warmupCache{interval: interval, states: make(map[string]warmupStatus)}
+}
+
+// start warms all projects now and then every interval.
+func (w *warmupCache) start(projects *projectRegistry) {
+	if w == nil {
+		return
+	}
+	go func() {
+		for {
+			w.warmAll(projects.list())
+			time.Sleep(w.interval)
+		}
+	}()
+}
+
+// warmAll warms the projects with warmup commands one at a time, and
+// forgets the status of projects that no longer have any.
+func (w *warmupCache) warmAll(projects []project) {
+	keep := map[string]bool{}
+	for _, p := range projects {
+		if len(p.Warmup) > 0 {
+			keep[p.Name] = true
+			w.warm(p)
+		}
+	}
+	w.mu.Lock()
+	for name := range w.states {
+		if !keep[name] {
+			delete(w.states, name)
+		}
+	}
+	w.mu.Unlock()
+}
+
+// warm runs p's warmup commands in order, stopping at the first failure.
+// It does nothing while p is already warming.
+func (w *warmupCache) warm(p project) {
+	if w == nil || len(p.Warmup) == 0 {
+		return
+	}
+	w.mu.Lock()
+	if w.states[p.Name].State == "warming" {
+		w.mu.Unlock()
+		return
+	}
+	st := warmupStatus{Project: p.Name, State: "warming", Started: time.Now()}
+	w.states[p.Name] = st
+	w.mu.Unlock()
+
+	log.Printf("[warmup] %s: %d commands in %s", p.Name, len(p.Warmup), p.Path)
+	var err error
+	for _, command := range p.Warmup {
+		if err = runWarmupCommand(p.Path, command); err != nil {
+			break
+		}
+	}
+	st.State, st.Duration = "ok", time.Since(st.Started).Round(time.Millisecond).String()
+	if err != nil {
+		st.State, st.Error = "failed", err.Error()
+		log.Printf("[warmup] %s: %v", p.Name, err)
+	} else {
+		log.Printf("[warmup] %s: done in %s", p.Name, st.Duration)
+	}
+	w.mu.Lock()
+	w.states[p.Name] = st
+	w.mu.Unlock()
+}
+
+func runWarmupCommand(dir, command string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), warmupTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		tail := strings.TrimSpace(string(out))
+		if len(tail) > 500 {
+			tail = "..." + tail[len(tail)-500:]
+		}
+		return fmt.Errorf("%s: %v: %s", command, err, tail)
+	}
+	return nil
+}
+
+// status returns the warm-up state of each project, ordered by name.
+func (w *warmupCache) status() []warmupStatus {
+	out := []warmupStatus{}
+	if w == nil {
+		return out
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, st := range w.states {
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Project < out[j].Project })
+	return out
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test warm-up runs each project's commands and reports their status
+func TestWarmup(t *testing.T) {
+	if newWarmupCache(0) != nil {
+		t.Error("MCP_WARMUP_INTERVAL=0 did not disable warm-up")
+	}
+	dir := t.TempDir()
+	w := newWarmupCache(time.Hour)
+	w.warmAll([]project{
+		{Name: "api", Path: dir, Warmup: []string{"echo deps > cache", "touch done"}},
+		{Name: "web", Path: dir, Warmup: []string{"echo broken >&2; exit 3", "touch never"}},
+		{Name: "docs", Path: dir},
+	})
+	if b, _ := os.ReadFile(filepath.Join(dir, "cache")); string(b) != "deps\n" {
+		t.Errorf("cache = %q", b)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "never")); err == nil {
+		t.Error("ran the commands after a failure")
+	}
+	st := w.status()
+	if len(st) != 2 || st[0].Project != "api" || st[0].State != "ok" || st[1].State != "failed" || !strings.Contains(st[1].Error, "broken") {
+		t.Errorf("status = %+v", st)
+	}
+
+	// Projects without warmup commands any more are dropped from the status
+	w.warmAll([]project{{Name: "api", Path: dir}})
+	if st := w.status(); len(st) != 0 {
+		t.Errorf("status after removal = %+v", st)
+	}
+	if err := (project{Name: "x", Path: dir, Warmup: []string{" "}}).validate(); err == nil {
+		t.Error("accepted an empty warmup command")
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"opencode-mcp/internal/runner"
+)
+
+// A child that stops printing but keeps running, e.g. stuck on an
+// interactive prompt, would hold its run slot until the run's timeout. The
+// watchdog notices when it has printed nothing for MCP_STALL_TIMEOUT, asks
+// its process group to exit with SIGTERM and, if it ignores that, kills the
+// group. The run then fails as stalled, with what the process was doing.
+const (
+	defaultStallTimeout = 5 * time.Minute
+
+	// stallKillGrace is how long a stalled child has to exit after SIGTERM.
+	stallKillGrace = 10 * time.Second
+)
+
+// stallReport describes a stalled child, in the result's _meta.stall and
+// the run record.
+type stallReport struct {
+	Silent     string `json:"silent"`               // how long it printed nothing
+	LastOutput string `json:"lastOutput,omitempty"` // the last line it printed
+	ProcState  string `json:"procState,omitempty"`  // State of /proc/<pid>/status, on Linux
+	Wchan      string `json:"wchan,omitempty"`      // kernel function it was waiting in, on Linux
+	Killed     bool   `json:"killed"`               // it ignored SIGTERM
+}
+
+func (r *stallReport) String() string {
+	s := fmt.Sprintf("no output for %s", r.Silent)
+	if r.ProcState != "" {
+		s += ", process " + r.ProcState
+		if r.Wchan != "" && r.Wchan != "0" {
+			s += " in " + r.Wchan
+		}
+	}
+	if r.Killed {
+		return s + "; killed after ignoring SIGTERM"
+	}
+	return s + "; terminated"
+}
+
+// watchdog watches the output of one child process.
+type watchdog struct {
+	timeout time.Duration
+	grace   time.Duration
+
+	mu       sync.Mutex
+	last     time.Time
+	lastLine string
+	report   *stallReport
+	exited   chan struct{}
+	stopped  chan struct{} // closed when watch returns
+}
+
+// newWatchdog returns nil, which watches nothing, for a non-positive
+// timeout.
+func newWatchdog(timeout time.Duration) *watchdog {
+	if timeout <= 0 {
+		return nil
+	}
+	return &watchdog{timeout: timeout, grace: stallKillGrace, last: time.Now(), exited: make(chan struct{}), stopped: make(chan struct{})}
+}
+
+// line records a line of output.
+func (w *watchdog) line(s string) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	w.last, w.lastLine = time.Now(), s
+	w.mu.Unlock()
+}
+
+// Write records output that isn't split into lines, e.g. stderr.
+func (w *watchdog) Write(p []byte) (int, error) {
+	if w != nil {
+		w.mu.Lock()
+		w.last = time.Now()
+		w.mu.Unlock()
+	}
+	return len(p), nil
+}
+
+// watch checks on cmd until done is called, stopping its process group
+// when it stalls; kill kills the group.
+func (w *watchdog) watch(cmd *exec.Cmd, kill func()) {
+	if w == nil {
+		return
+	}
+	defer close(w.stopped)
+	tick := time.NewTicker(min(w.timeout/4, time.Second))
+	defer tick.Stop()
+	for {
+		select {
+		case <-w.exited:
+			return
+		case <-tick.C:
+		}
+		w.mu.Lock()
+		silent := time.Since(w.last)
+		lastLine := w.lastLine
+		w.mu.Unlock()
+		if silent < w.timeout {
+			continue
+		}
+
+		report := &stallReport{Silent: silent.Round(100 * time.Millisecond).String(), LastOutput: truncateForLog(lastLine, 500)}
+		report.ProcState, report.Wchan = procState(cmd.Process.Pid)
+		log.Printf("[watchdog] pid %d printed nothing for %s, sending SIGTERM to its process group", cmd.Process.Pid, report.Silent)
+		if err := runner.TerminateProcessGroup(cmd); err != nil {
+			log.Printf("[watchdog] SIGTERM pid %d: %v", cmd.Process.Pid, err)
+		}
+		select {
+		case <-w.exited:
+		case <-time.After(w.grace):
+			report.Killed = true
+			log.Printf("[watchdog] pid %d ignored SIGTERM, killing its process group", cmd.Process.Pid)
+			kill()
+		}
+		log.Printf("[watchdog] pid %d stalled: %s", cmd.Process.Pid, report)
+		w.mu.Lock()
+		w.report = report
+		w.mu.Unlock()
+		return
+	}
+}
+
+// done tells watch that the child exited, and returns the report if it
+// stalled.
+func (w *watchdog) done() *stallReport {
+	if w == nil {
+		return nil
+	}
+	close(w.exited)
+	<-w.stopped
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.report
+}
+
+// procState reads what pid is doing from /proc; both are "" elsewhere.
+func procState(pid int) (state, wchan string) {
+	if status, err := os.ReadFile(fmt.Sprintf("/proc/%d/status", pid)); err == nil {
+		for _, line := range strings.Split(string(status), "\n") {
+			if v, ok := strings.CutPrefix(line, "State:"); ok {
+				state = strings.TrimSpace(v)
+				break
+			}
+		}
+	}
+	if b, err := os.ReadFile(fmt.Sprintf("/proc/%d/wchan", pid)); err == nil {
+		wchan = strings.TrimSpace(string(b))
+	}
+	return state, wchan
+}
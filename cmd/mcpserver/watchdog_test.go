@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"opencode-mcp/internal/runner"
+)
+
+// Test a run that stops printing is terminated and recorded as stalled
+func TestWatchdogStalledRun(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell")
+	}
+	script := filepath.Join(t.TempDir(), "opencode")
+	content := `#!/bin/sh
+[ "$1" = models ] && exit 0
+echo '{"type":"text","sessionID":"ses_w","part":{"text":"Continue? [y/N]"}}'
+exec sleep 30
+`
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	st, _ := openStore("")
+	cfg := serverConfig{Target: script, DefaultTimeout: 20 * time.Second, StallTimeout: 300 * time.Millisecond, Store: st}
+	start := time.Now()
+	result, mErr := newToolHandler(cfg)(context.Background(), &toolCall{ID: json.RawMessage("1"), Name: toolRun, Tenant: "team-a", RunID: "r1", Arguments: json.RawMessage(`{"message":"hi"}`)})
+	if mErr != nil {
+		t.Fatal(mErr)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("stalled run took %s", time.Since(start))
+	}
+	if !result.IsError || result.Meta["error"].(*errorData).Code != errStalled.Code || !strings.Contains(result.Content[0].Text, "[stalled] no output for") {
+		t.Errorf("result = %+v", result)
+	}
+	stall, _ := result.Meta["stall"].(*stallReport)
+	if stall == nil || stall.Killed || !strings.Contains(stall.LastOutput, "Continue?") {
+		t.Errorf("stall = %+v", stall)
+	}
+	rec, ok, _ := runHistory{st}.get("team-a", "r1")
+	if !ok || rec.Status != "stalled" || rec.Stall == nil {
+		t.Errorf("run record = %+v", rec)
+	}
+}
+
+// Test a stalled child that ignores SIGTERM is killed after the grace period
+func TestWatchdogKillsAfterGrace(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", `trap "" TERM; sleep 30`)
+	runner.KillProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	wd := newWatchdog(200 * time.Millisecond)
+	wd.grace = 200 * time.Millisecond
+	go wd.watch(cmd, cancel)
+
+	start := time.Now()
+	_ = cmd.Wait()
+	report := wd.done()
+	if report == nil || !report.Killed {
+		t.Fatalf("report = %+v", report)
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Errorf("killed after %s", time.Since(start))
+	}
+	if runtime.GOOS == "linux" && report.ProcState == "" {
+		t.Errorf("no /proc state in %+v", report)
+	}
+}
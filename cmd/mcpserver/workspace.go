@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// workspaceCache keeps clones of the repos tool calls name with "repo", one
+// directory per repo and branch under root. Before each call the clone is
+// reset to the remote branch; calls in the same clone run concurrently, but
+// never while it is being updated.
+type workspaceCache struct {
+	root   string
+	sshKey string // MCP_GIT_SSH_KEY; empty uses the server user's SSH setup
+
+	mu    sync.Mutex
+	locks map[string]*sync.RWMutex // by clone directory
+}
+
+// newWorkspaceCache returns nil (repos disabled) for an empty root.
+func newWorkspaceCache(root, sshKey string) *workspaceCache {
+	if root == "" {
+		return nil
+	}
+	return &workspaceCache{root: root, sshKey: sshKey, locks: make(map[string]*sync.RWMutex)}
+}
+
+// repoArgs are the workspace arguments of a tool call.
+type repoArgs struct {
+	Repo   string `json:"repo"`
+	Branch string `json:"branch"`
+	Depth  int    `json:"depth"` // 0 is the full history
+}
+
+var (
+	// Users and hosts can't start with "-", which ssh would take as an option.
+	scpRepoRe    = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*@([A-Za-z0-9][A-Za-z0-9.-]*):([A-Za-z0-9._/-]+)$`)
+	urlRepoRe    = regexp.MustCompile(`^(?:https|ssh)://(?:[A-Za-z0-9][A-Za-z0-9._-]*@)?([A-Za-z0-9][A-Za-z0-9.-]*)(?::\d+)?/([A-Za-z0-9._/-]+)$`)
+	branchNameRe = regexp.MustCompile(`^[A-Za-z0-9._/-]+$`)
+)
+
+// repoKey normalizes a repo URL to host/path, e.g. github.com/org/x for
+// git@github.com:org/x.git, which is what allowedRepos patterns match.
+// Only https, ssh and scp-style URLs are accepted: local paths and git's
+// other transports (file://, ext::) are not.
+func repoKey(repo string) (string, error) {
+	m := scpRepoRe.FindStringSubmatch(repo)
+	if m == nil {
+		m = urlRepoRe.FindStringSubmatch(repo)
+	}
+	if m == nil {
+		return "", fmt.Errorf("repo %q is not an https, ssh or user@host:path URL", repo)
+	}
+	p := strings.TrimSuffix(strings.Trim(m[2], "/"), ".git")
+	if p == "" || strings.Contains("/"+p+"/", "/../") || strings.Contains("/"+p+"/", "/./") {
+		return "", fmt.Errorf("repo %q has an invalid path", repo)
+	}
+	return strings.ToLower(m[1]) + "/" + p, nil
+}
+
+// allowsRepo reports whether key matches one of the tenant's allowedRepos
+// patterns (path.Match syntax, e.g. github.com/org/*). No patterns allow
+// nothing: cloning arbitrary repos must be opted into.
+func (p tenantPolicy) allowsRepo(key string) bool {
+	for _, pattern := range p.AllowedRepos {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *workspaceCache) lock(dir string) *sync.RWMutex {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l, ok := c.locks[dir]
+	if !ok {
+		l = &sync.RWMutex{}
+		c.locks[dir] = l
+	}
+	return l
+}
+
+// dir returns the clone directory of key and branch.
+func (c *workspaceCache) dir(key, branch string) string {
+	name := filepath.FromSlash(key)
+	if branch != "" {
+		name += "@" + url.PathEscape(branch)
+	}
+	return filepath.Join(c.root, name)
+}
+
+// sync clones repo into dir, or fetches it and resets the clone to the
+// remote branch, discarding changes of earlier runs. Ignored files (e.g.
+// dependency caches) are kept.
+func (c *workspaceCache) sync(ctx context.Context, repo string, a repoArgs, dir string) error {
+	ref := a.Branch
+	if ref == "" {
+		ref = "HEAD"
+	}
+	depth := []string{}
+	if a.Depth > 0 {
+		depth = []string{"--depth", strconv.Itoa(a.Depth)}
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err != nil {
+		if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+			return err
+		}
+		args := append([]string{"clone", "--no-tags"}, depth...)
+		if a.Branch != "" {
+			args = append(args, "--branch", a.Branch)
+		}
+		return c.git(ctx, "", append(args, "--", repo, dir)...)
+	}
+	if err := c.git(ctx, dir, "remote", "set-url", "origin", repo); err != nil {
+		return err
+	}
+	if err := c.git(ctx, dir, append(append([]string{"fetch", "--no-tags"}, depth...), "origin", ref)...); err != nil {
+		return err
+	}
+	if err := c.git(ctx, dir, "reset", "--hard", "FETCH_HEAD"); err != nil {
+		return err
+	}
+	return c.git(ctx, dir, "clean", "-ffd")
+}
+
+func (c *workspaceCache) git(ctx context.Context, dir string, args ...string) error {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if c.sshKey != "" {
+		cmd.Env = append(cmd.Env, "GIT_SSH_COMMAND=ssh -i "+c.sshKey+" -o IdentitiesOnly=yes -o StrictHostKeyChecking=accept-new")
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// repoMiddleware checks out a "repo" argument into the workspace cache and
+// runs the call there, as if it had passed the clone as "cwd".
+func repoMiddleware(cfg serverConfig) toolMiddleware {
+	return func(next toolHandler) toolHandler {
+		return func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+			var args map[string]any
+			if json.Unmarshal(call.Arguments, &args) != nil || args["repo"] == nil {
+				return next(ctx, call)
+			}
+			var a repoArgs
+			if err := json.Unmarshal(call.Arguments, &a); err != nil || a.Depth < 0 {
+				return nil, errInvalidArguments.err("invalid repo, branch or depth")
+			}
+			if cfg.Workspaces == nil {
+				return nil, errInvalidArguments.err("repo is not enabled on this server (MCP_WORKSPACE_DIR)")
+			}
+			if cwd, _ := args["cwd"].(string); cwd != "" {
+				return nil, errInvalidArguments.err("pass either repo or cwd/project, not both")
+			}
+			key, err := repoKey(a.Repo)
+			if err != nil {
+				return nil, errInvalidArguments.err(err.Error())
+			}
+			if a.Branch != "" && (!branchNameRe.MatchString(a.Branch) || strings.HasPrefix(a.Branch, "-") || strings.Contains(a.Branch, "..")) {
+				return nil, errInvalidArguments.err(fmt.Sprintf("invalid branch %q", a.Branch))
+			}
+			if !cfg.forTenant(call.Tenant).Policy.allowsRepo(key) {
+				return nil, errPolicyDenied.err(fmt.Sprintf("repo %s is not in the tenant's allowedRepos", key))
+			}
+
+			dir := cfg.Workspaces.dir(key, a.Branch)
+			l := cfg.Workspaces.lock(dir)
+			l.Lock()
+			log.Printf("[workspace] sync %s (branch=%q depth=%d) -> %s", key, a.Branch, a.Depth, dir)
+			call.Notify(map[string]any{
+				"jsonrpc": "2.0",
+				"method":  "notifications/message",
+				"params":  map[string]any{"level": "info", "data": map[string]any{"workspace": key, "status": "syncing"}},
+			})
+			syncCtx, cancel := context.WithTimeout(ctx, cfg.DefaultTimeout)
+			err = cfg.Workspaces.sync(syncCtx, a.Repo, a, dir)
+			cancel()
+			l.Unlock()
+			if err != nil {
+				log.Printf("[workspace] %s: %v", key, err)
+				return nil, errCheckoutFailed.err(err.Error())
+			}
+			l.RLock()
+			defer l.RUnlock()
+
+			delete(args, "repo")
+			delete(args, "branch")
+			delete(args, "depth")
+			args["cwd"] = dir
+			call.Arguments, _ = json.Marshal(args)
+			call.Cwd = dir
+			return next(ctx, call)
+		}
+	}
+}
+
+// withRepoArgs returns schema with "repo", "branch" and "depth" properties
+// added when it has a "cwd" one.
+func withRepoArgs(schema any) any {
+	s, ok := schema.(map[string]any)
+	if !ok {
+		return schema
+	}
+	props, _ := s["properties"].(map[string]any)
+	if _, ok := props["cwd"]; !ok {
+		return schema
+	}
+	p := make(map[string]any, len(props)+3)
+	for k, v := range props {
+		p[k] = v
+	}
+	p["repo"] = map[string]any{
+		"type":        "string",
+		"description": "Git URL (https or ssh) to clone and run in, instead of cwd",
+	}
+	p["branch"] = map[string]any{"type": "string", "description": "Branch of repo (default: the remote's default branch)"}
+	p["depth"] = map[string]any{"type": "integer", "minimum": 0, "description": "Shallow clone depth of repo (0: full history)"}
+	out := make(map[string]any, len(s))
+	for k, v := range s {
+		out[k] = v
+	}
+	out["properties"] = p
+	return out
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Test repo URLs normalize to host/path and unsafe ones are refused
+func TestRepoKey(t *testing.T) {
+	for repo, want := range map[string]string{
+		"git@github.com:acme/api.git":            "github.com/acme/api",
+		"https://GitHub.com/acme/api":            "github.com/acme/api",
+		"ssh://git@git.example.com:2222/x/y.git": "git.example.com/x/y",
+		"file:///srv/repo":                       "",
+		"/srv/repo":                              "",
+		"ext::sh -c touch% /tmp/x":               "",
+		"https://github.com/acme/../etc":         "",
+		"ssh://-oProxyCommand=x/acme/api":        "",
+		"-u@github.com:acme/api":                 "",
+	} {
+		got, err := repoKey(repo)
+		if got != want || (want == "") != (err != nil) {
+			t.Errorf("repoKey(%q) = %q, %v; want %q", repo, got, err, want)
+		}
+	}
+}
+
+// Test the middleware enforces allowedRepos before touching git
+func TestRepoMiddlewarePolicy(t *testing.T) {
+	cfg := serverConfig{
+		Workspaces:     newWorkspaceCache(t.TempDir(), ""),
+		DefaultTimeout: time.Minute,
+		Tenants:        map[string]tenantPolicy{"default": {AllowedRepos: []string{"github.com/acme/*"}}},
+	}
+	handler := repoMiddleware(cfg)(func(ctx context.Context, call *toolCall) (*toolCallResult, *mcpError) {
+		t.Errorf("call reached the tool: %s", call.Arguments)
+		return &toolCallResult{}, nil
+	})
+	for args, code := range map[string]string{
+		`{"message":"hi","repo":"git@github.com:other/api.git"}`:              errPolicyDenied.Code,
+		`{"message":"hi","repo":"git@github.com:acme/api.git","cwd":"/tmp"}`:  errInvalidArguments.Code,
+		`{"message":"hi","repo":"git@github.com:acme/api.git","branch":"-x"}`: errInvalidArguments.Code,
+		`{"message":"hi","repo":"git@github.com:acme/api.git","depth":-1}`:    errInvalidArguments.Code,
+		`{"message":"hi","repo":"/srv/repo"}`:                                 errInvalidArguments.Code,
+	} {
+		_, mErr := handler(context.Background(), &toolCall{Name: toolRun, Arguments: json.RawMessage(args)})
+		if mErr == nil || mErr.Data.Code != code {
+			t.Errorf("%s: %v, want %s", args, mErr, code)
+		}
+	}
+
+	cfg.Workspaces = nil
+	_, mErr := repoMiddleware(cfg)(nil)(context.Background(), &toolCall{Name: toolRun, Arguments: json.RawMessage(`{"repo":"git@github.com:acme/api.git"}`)})
+	if mErr == nil || mErr.Data.Code != errInvalidArguments.Code {
+		t.Errorf("without MCP_WORKSPACE_DIR: %v", mErr)
+	}
+}
+
+// Test sync clones once, then resets the clone to the remote branch
+func TestWorkspaceSync(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	c := newWorkspaceCache(filepath.Join(dir, "cache"), "")
+	ctx := context.Background()
+	origin, work := filepath.Join(dir, "origin.git"), filepath.Join(dir, "work")
+	commit := func(content string) {
+		if err := os.WriteFile(filepath.Join(work, "README"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		for _, args := range [][]string{{"add", "README"}, {"-c", "user.name=t", "-c", "user.email=t@t", "commit", "-qm", content}, {"push", "-q", "origin", "HEAD:main"}} {
+			if err := c.git(ctx, work, args...); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for _, args := range [][]string{{"init", "-q", "--bare", "-b", "main", origin}, {"clone", "-q", origin, work}} {
+		if err := c.git(ctx, "", args...); err != nil {
+			t.Skip(err) // git too old for init -b
+		}
+	}
+	commit("v1")
+
+	a := repoArgs{Branch: "main", Depth: 1}
+	clone := c.dir("example.com/acme/api", a.Branch)
+	if err := c.sync(ctx, origin, a, clone); err != nil {
+		t.Fatal(err)
+	}
+	_ = os.WriteFile(filepath.Join(clone, "README"), []byte("edited by a run"), 0644)
+	_ = os.WriteFile(filepath.Join(clone, "scratch"), []byte("x"), 0644)
+	commit("v2")
+	if err := c.sync(ctx, origin, a, clone); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(clone, "README")); string(b) != "v2" {
+		t.Errorf("README = %q, want v2", b)
+	}
+	if _, err := os.Stat(filepath.Join(clone, "scratch")); !os.IsNotExist(err) {
+		t.Errorf("untracked file survived the reset: %v", err)
+	}
+}
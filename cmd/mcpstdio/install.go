@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"opencode-mcp/internal/runner"
+)
+
+// mcpClient describes where an MCP client keeps its server configuration.
+type mcpClient struct {
+	serversKey string // top-level key holding the server map
+	path       func() (string, error)
+	entry      func(command string, env map[string]string) map[string]any
+}
+
+var mcpClients = map[string]mcpClient{
+	"claude": {
+		serversKey: "mcpServers",
+		path:       claudeDesktopConfigPath,
+		entry:      commandEntry,
+	},
+	"cursor": {
+		serversKey: "mcpServers",
+		path:       homePath(".cursor", "mcp.json"),
+		entry:      commandEntry,
+	},
+	"vscode": {
+		serversKey: "servers",
+		path: func() (string, error) {
+			return filepath.Abs(filepath.Join(".vscode", "mcp.json"))
+		},
+		entry: func(command string, env map[string]string) map[string]any {
+			e := commandEntry(command, env)
+			e["type"] = "stdio"
+			return e
+		},
+	},
+}
+
+func commandEntry(command string, env map[string]string) map[string]any {
+	e := map[string]any{"command": command, "args": []string{}}
+	if len(env) > 0 {
+		e["env"] = env
+	}
+	return e
+}
+
+func homePath(elem ...string) func() (string, error) {
+	return func() (string, error) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(append([]string{home}, elem...)...), nil
+	}
+}
+
+func claudeDesktopConfigPath() (string, error) {
+	switch runtime.GOOS {
+	case "darwin":
+		return homePath("Library", "Application Support", "Claude", "claude_desktop_config.json")()
+	case "windows":
+		dir, err := os.UserConfigDir() // %APPDATA%
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, "Claude", "claude_desktop_config.json"), nil
+	}
+	return homePath(".config", "Claude", "claude_desktop_config.json")()
+}
+
+// envFlag collects repeated -env KEY=VALUE flags.
+type envFlag map[string]string
+
+func (e envFlag) String() string { return fmt.Sprint(map[string]string(e)) }
+
+func (e envFlag) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok || k == "" {
+		return fmt.Errorf("want KEY=VALUE, got %q", s)
+	}
+	e[k] = v
+	return nil
+}
+
+// runInstall implements `install`: it adds this binary to an MCP client's
+// configuration, printing a diff before writing.
+func runInstall(args []string) error {
+	fs := flag.NewFlagSet("install", flag.ContinueOnError)
+	client := fs.String("client", "", "MCP client to configure: claude, cursor or vscode")
+	name := fs.String("name", "opencode", "server name in the client configuration")
+	configPath := fs.String("config", "", "configuration file to update (default: the client's standard location)")
+	binary := fs.String("binary", "", "path of the stdio server binary (default: this executable)")
+	yes := fs.Bool("yes", false, "apply without asking")
+	dryRun := fs.Bool("dry-run", false, "print the diff without writing")
+	env := envFlag{}
+	fs.Var(env, "env", "environment variable for the server, KEY=VALUE (repeatable)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	c, ok := mcpClients[*client]
+	if !ok {
+		return fmt.Errorf("unknown or missing -client %q (want claude, cursor or vscode)", *client)
+	}
+	command, err := resolveBinary(*binary)
+	if err != nil {
+		return err
+	}
+	if _, ok := env["MCP_TARGET"]; !ok {
+		// Clients start servers with a minimal PATH, so pin the CLI location.
+		if p, err := exec.LookPath(target); err == nil {
+			if abs, err := filepath.Abs(p); err == nil {
+				env["MCP_TARGET"] = abs
+			}
+		} else {
+			fmt.Fprintf(os.Stderr, "warning: %s not found in PATH; set -env MCP_TARGET=/path/to/opencode\n", target)
+		}
+	}
+
+	path := *configPath
+	if path == "" {
+		if path, err = c.path(); err != nil {
+			return err
+		}
+	}
+	before, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	after, err := updateClientConfig(before, c.serversKey, *name, c.entry(command, env))
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	if string(before) == string(after) {
+		fmt.Printf("%s is already up to date\n", path)
+		return nil
+	}
+	fmt.Printf("--- %s\n+++ %s\n", path, path)
+	fmt.Print(runner.LineDiff(string(before), string(after)))
+	if *dryRun {
+		return nil
+	}
+	if !*yes && !confirm("Apply these changes?") {
+		return errors.New("aborted")
+	}
+	if err := writeFileAtomic(path, after); err != nil {
+		return err
+	}
+	fmt.Printf("Updated %s; restart the client to load %q\n", path, *name)
+	return nil
+}
+
+// resolveBinary returns the absolute path of the server binary, refusing
+// binaries that won't outlive the current process (e.g. `go run`).
+func resolveBinary(path string) (string, error) {
+	if path == "" {
+		exe, err := os.Executable()
+		if err != nil {
+			return "", err
+		}
+		path = exe
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		path = real
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("binary: %w", err)
+	}
+	if !info.Mode().IsRegular() || (runtime.GOOS != "windows" && info.Mode().Perm()&0o111 == 0) {
+		return "", fmt.Errorf("binary %s is not an executable file", path)
+	}
+	if tmp, err := filepath.EvalSymlinks(os.TempDir()); err == nil && strings.HasPrefix(path, tmp+string(filepath.Separator)) {
+		return "", fmt.Errorf("binary %s is in a temporary directory; build or `go install` it and pass -binary", path)
+	}
+	return path, nil
+}
+
+// updateClientConfig sets servers[name] in the JSON config doc. Only that
+// entry changes: the other settings keep their order and formatting, so
+// the diff shows just the server.
+func updateClientConfig(doc []byte, serversKey, name string, entry map[string]any) ([]byte, error) {
+	if len(bytes.TrimSpace(doc)) == 0 {
+		out, err := json.MarshalIndent(map[string]any{serversKey: map[string]any{name: entry}}, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(out, '\n'), nil
+	}
+	if !json.Valid(doc) {
+		return nil, errors.New("parse config: invalid JSON")
+	}
+	start := bytes.IndexAny(doc, "{[\"")
+	members, err := objectMembers(doc, start)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(members) - 1; i >= 0; i-- {
+		if m := members[i]; m.key == serversKey {
+			if doc[m.start] != '{' {
+				return nil, fmt.Errorf("%q is not an object", serversKey)
+			}
+			return setMember(doc, m.start, name, entry)
+		}
+	}
+	return setMember(doc, start, serversKey, map[string]any{name: entry})
+}
+
+// jsonMember is a member of a JSON object, with the offsets of its value.
+type jsonMember struct {
+	key        string
+	start, end int
+}
+
+// objectMembers returns the members of the JSON object at doc[start:].
+func objectMembers(doc []byte, start int) ([]jsonMember, error) {
+	dec := json.NewDecoder(bytes.NewReader(doc[start:]))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, errors.New("the configuration is not a JSON object")
+	}
+	var members []jsonMember
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, _ := tok.(string)
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, err
+		}
+		end := start + int(dec.InputOffset())
+		members = append(members, jsonMember{key, end - len(value), end})
+	}
+	return members, nil
+}
+
+// setMember sets key in the JSON object at doc[start:] to v, replacing the
+// value of an existing member or appending a new one in the object's
+// indentation.
+func setMember(doc []byte, start int, key string, v any) ([]byte, error) {
+	members, err := objectMembers(doc, start)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(members) - 1; i >= 0; i-- {
+		if m := members[i]; m.key == key {
+			value, err := json.MarshalIndent(v, lineIndent(doc, m.start), "  ")
+			if err != nil {
+				return nil, err
+			}
+			return splice(doc, m.start, m.end, value), nil
+		}
+	}
+	quoted, _ := json.Marshal(key)
+	if len(members) == 0 {
+		outer := lineIndent(doc, start)
+		value, err := json.MarshalIndent(v, outer+"  ", "  ")
+		if err != nil {
+			return nil, err
+		}
+		end := start + bytes.IndexByte(doc[start:], '}') + 1
+		return splice(doc, start, end, []byte("{\n"+outer+"  "+string(quoted)+": "+string(value)+"\n"+outer+"}")), nil
+	}
+	last := members[len(members)-1]
+	indent := lineIndent(doc, last.start)
+	value, err := json.MarshalIndent(v, indent, "  ")
+	if err != nil {
+		return nil, err
+	}
+	return splice(doc, last.end, last.end, []byte(",\n"+indent+string(quoted)+": "+string(value))), nil
+}
+
+// lineIndent returns the leading whitespace of the line holding doc[pos].
+func lineIndent(doc []byte, pos int) string {
+	line := doc[bytes.LastIndexByte(doc[:pos], '\n')+1:]
+	return string(line[:len(line)-len(bytes.TrimLeft(line, " \t"))])
+}
+
+// splice returns doc with doc[start:end] replaced by b.
+func splice(doc []byte, start, end int, b []byte) []byte {
+	out := append([]byte{}, doc[:start]...)
+	out = append(out, b...)
+	return append(out, doc[end:]...)
+}
+
+func confirm(question string) bool {
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		fmt.Fprintln(os.Stderr, "stdin is not a terminal; pass -yes to apply")
+		return false
+	}
+	fmt.Printf("%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// writeFileAtomic replaces path, creating its directory if needed.
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".mcp-config-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
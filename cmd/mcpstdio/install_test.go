@@ -0,0 +1,139 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"opencode-mcp/internal/runner"
+)
+
+var testEntry = map[string]any{"command": "/bin/mcpstdio"}
+
+// Test updateClientConfig edits only the server entry and keeps the rest
+// of the config as it was
+func TestUpdateClientConfig(t *testing.T) {
+	for _, tt := range []struct{ name, doc, want string }{
+		{"empty", "", `{
+  "mcpServers": {
+    "opencode": {
+      "command": "/bin/mcpstdio"
+    }
+  }
+}
+`},
+		{"no servers", `{
+  "theme": "dark",
+  "alpha": 1
+}
+`, `{
+  "theme": "dark",
+  "alpha": 1,
+  "mcpServers": {
+    "opencode": {
+      "command": "/bin/mcpstdio"
+    }
+  }
+}
+`},
+		{"empty servers", `{"zeta": true, "mcpServers": {}}`, `{"zeta": true, "mcpServers": {
+  "opencode": {
+    "command": "/bin/mcpstdio"
+  }
+}}`},
+		{"other servers", `{
+  "zeta": true,
+  "mcpServers": {
+    "other": {"command": "x"}
+  },
+  "alpha": [1, 2]
+}
+`, `{
+  "zeta": true,
+  "mcpServers": {
+    "other": {"command": "x"},
+    "opencode": {
+      "command": "/bin/mcpstdio"
+    }
+  },
+  "alpha": [1, 2]
+}
+`},
+		{"replace", `{
+  "mcpServers": {
+    "opencode": {"command": "/old", "args": ["x"]},
+    "other": {"command": "x"}
+  }
+}
+`, `{
+  "mcpServers": {
+    "opencode": {
+      "command": "/bin/mcpstdio"
+    },
+    "other": {"command": "x"}
+  }
+}
+`},
+	} {
+		got, err := updateClientConfig([]byte(tt.doc), "mcpServers", "opencode", testEntry)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+// Test updateClientConfig rejects configs it cannot edit
+func TestUpdateClientConfigErrors(t *testing.T) {
+	for _, tt := range []struct{ doc, want string }{
+		{`{"mcpServers": `, "invalid JSON"},
+		{`[1, 2]`, "not a JSON object"},
+		{`{"mcpServers": null}`, `"mcpServers" is not an object`},
+		{`{"mcpServers": ["x"]}`, `"mcpServers" is not an object`},
+	} {
+		_, err := updateClientConfig([]byte(tt.doc), "mcpServers", "opencode", testEntry)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("updateClientConfig(%q) error = %v, want %q", tt.doc, err, tt.want)
+		}
+	}
+}
+
+// Test the diff install prints shows only the lines of the server entry
+func TestInstallDiff(t *testing.T) {
+	for _, tt := range []struct{ doc, want string }{
+		{"", `+ {
++   "mcpServers": {
++     "opencode": {
++       "command": "/bin/mcpstdio"
++     }
++   }
++ }
+`},
+		{`{
+  "theme": "dark",
+  "mcpServers": {
+    "opencode": {"command": "/old"}
+  }
+}
+`, `  {
+    "theme": "dark",
+    "mcpServers": {
+-     "opencode": {"command": "/old"}
++     "opencode": {
++       "command": "/bin/mcpstdio"
++     }
+    }
+  }
+`},
+	} {
+		after, err := updateClientConfig([]byte(tt.doc), "mcpServers", "opencode", testEntry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := runner.LineDiff(tt.doc, string(after)); got != tt.want {
+			t.Errorf("diff of %q: got\n%s\nwant\n%s", tt.doc, got, tt.want)
+		}
+	}
+}
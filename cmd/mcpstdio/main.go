@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,10 +11,16 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
+
+	"opencode-mcp/internal/mcp"
+	"opencode-mcp/internal/runner"
+	"opencode-mcp/internal/throttle"
 )
 
 // Stdio MCP server that wraps opencode-cli directly
@@ -25,55 +32,49 @@ const (
 	defaultModel   = "github-copilot/gpt-5.2-codex" // Codex 5.2 model
 )
 
-// Model cache
-var (
-	availableModels []string
-	modelCacheMu    sync.RWMutex
-	modelCacheTime  time.Time
-	modelCacheTTL   = 5 * time.Minute
-)
+// modelCache holds the IDs of the available models
+var modelCache runner.ModelCache[string]
 
-type mcpRequest struct {
-	JSONRPC string          `json:"jsonrpc"`
-	Method  string          `json:"method"`
-	Params  json.RawMessage `json:"params"`
-	ID      any             `json:"id"`
-}
-
-type mcpResponse struct {
-	JSONRPC string    `json:"jsonrpc"`
-	ID      any       `json:"id"`
-	Result  any       `json:"result,omitempty"`
-	Error   *mcpError `json:"error,omitempty"`
-}
+var target = getenv("MCP_TARGET", "opencode-cli")
 
-type mcpError struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
-}
+// version is set at build time (-ldflags "-X main.version=..."); when empty
+// the version comes from the build info.
+var version string
 
-type mcpTool struct {
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	InputSchema any    `json:"inputSchema"`
-}
+// serverInfo and instructions answer initialize (MCP_SERVER_NAME,
+// MCP_SERVER_VERSION and MCP_INSTRUCTIONS).
+var (
+	serverInfo = mcp.ServerInfo{
+		Name:    getenv("MCP_SERVER_NAME", mcp.ServerName),
+		Version: getenv("MCP_SERVER_VERSION", mcp.BuildVersion(version)),
+	}
+	instructions = os.Getenv("MCP_INSTRUCTIONS")
+)
 
-type toolContent struct {
-	Type string `json:"type"`
-	Text string `json:"text,omitempty"`
-}
+// notifyLimiter caps progress notifications per second (MCP_NOTIFY_RATE);
+// nil when unlimited.
+var notifyLimiter = newNotifyLimiter()
 
-type toolCallResult struct {
-	Content []toolContent `json:"content"`
-	IsError bool          `json:"isError,omitempty"`
+func newNotifyLimiter() *throttle.Limiter {
+	rate, err := strconv.Atoi(getenv("MCP_NOTIFY_RATE", "0"))
+	if err != nil {
+		log.Printf("invalid MCP_NOTIFY_RATE: %v", err)
+	}
+	return throttle.NewLimiter(rate)
 }
 
-var target = getenv("MCP_TARGET", "opencode-cli")
-
 func main() {
 	log.SetOutput(os.Stderr)
 	log.SetFlags(log.Ltime | log.Lshortfile)
 
+	if len(os.Args) > 1 && os.Args[1] == "install" {
+		if err := runInstall(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, "install:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Handle signals
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
@@ -87,287 +88,370 @@ func main() {
 	scanner := bufio.NewScanner(os.Stdin)
 	scanner.Buffer(make([]byte, 10*1024*1024), 10*1024*1024) // 10MB buffer
 
+	// Tool calls run concurrently so that notifications/cancelled can be
+	// read while they do
+	var calls sync.WaitGroup
 	for scanner.Scan() {
 		line := scanner.Text()
 		if line == "" {
 			continue
 		}
 
-		var req mcpRequest
-		if err := json.Unmarshal([]byte(line), &req); err != nil {
-			writeError(nil, -32700, "invalid JSON")
+		req, errResp := mcp.Decode([]byte(line))
+		if errResp != nil {
+			writeResponse(errResp)
 			continue
 		}
 
-		log.Printf("Request: method=%s id=%v", req.Method, req.ID)
-		handleRequest(req)
+		log.Printf("Request: method=%s id=%s", req.Method, req.ID)
+		calls.Add(1)
+		dispatcher.Go(context.Background(), req, func(resp *mcp.Response) {
+			defer calls.Done()
+			if resp != nil {
+				writeResponse(resp)
+			}
+		})
 	}
+	calls.Wait()
 
 	if err := scanner.Err(); err != nil {
 		log.Printf("stdin error: %v", err)
 	}
 }
 
-func handleRequest(req mcpRequest) {
-	switch req.Method {
-	case "initialize":
-		writeResponse(req.ID, map[string]any{
-			"protocolVersion": "2024-11-05",
-			"capabilities": map[string]any{
-				"tools": map[string]any{},
-			},
-			"serverInfo": map[string]any{
-				"name":    "opencode-mcp",
-				"version": "0.1.0",
-			},
-		})
+// dispatcher answers requests; tools are registered in newDispatcher.
+var dispatcher = newDispatcher()
 
-	case "notifications/initialized":
-		// No response needed for notifications
+// clientVersion is the protocol revision the client declared in
+// initialize. There is one client per process: its revision decides what
+// tools/list shows and whether progress needs a token.
+var clientVersion atomic.Value
 
-	case "tools/list":
-		writeResponse(req.ID, map[string]any{
-			"tools": getTools(),
-		})
+func protocolVersion() string {
+	v, _ := clientVersion.Load().(string)
+	return v
+}
 
-	case "tools/call":
-		handleToolsCall(req)
+func newDispatcher() *mcp.Dispatcher {
+	d := mcp.NewDispatcher()
+	d.Handle("initialize", func(_ context.Context, req *mcp.Request) (any, *mcp.Error) {
+		var params struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		}
+		_ = json.Unmarshal(req.Params, &params)
+		clientVersion.Store(params.ProtocolVersion)
+		return mcp.InitializeResult(params.ProtocolVersion, map[string]any{
+			"tools":       map[string]any{},
+			"resources":   map[string]any{},
+			"completions": map[string]any{},
+		}, serverInfo, instructions), nil
+	})
+	d.Tool(mcp.RunTool(), runTool)
+	d.Tool(mcp.ModelsTool(), func(ctx context.Context, req *mcp.Request, _ json.RawMessage) (*mcp.ToolResult, *mcp.Error) {
+		return runCommand(ctx, req, []string{"models"}, "", "", false)
+	})
+	d.Tool(mcp.ExecTool(), execTool)
+	d.Handle("tools/list", func(_ context.Context, req *mcp.Request) (any, *mcp.Error) {
+		tools, next, err := mcp.Paginate(req.Params, mcp.ToolsFor(d.Tools(), protocolVersion()), mcp.PageSize)
+		if err != nil {
+			return nil, err
+		}
+		return mcp.ListResult("tools", tools, next), nil
+	})
+	d.Handle("resources/list", listSessions)
+	d.Handle("resources/read", readSession)
+	d.Handle(mcp.MethodComplete, complete)
+	return d
+}
 
-	default:
-		writeError(req.ID, -32601, fmt.Sprintf("method not found: %s", req.Method))
+// listSessions answers resources/list with the opencode sessions of the
+// project in the server's working directory.
+func listSessions(ctx context.Context, req *mcp.Request) (any, *mcp.Error) {
+	output, err := cliOutput(ctx, runner.SessionListArgs()...)
+	if err != nil {
+		return nil, mcp.NewError(mcp.CodeServerError, fmt.Sprintf("session list: %v", err))
+	}
+	sessions, err := runner.ParseSessionList(output)
+	if err != nil {
+		return nil, mcp.NewError(mcp.CodeServerError, err.Error())
+	}
+	resources := make([]mcp.Resource, 0, len(sessions))
+	for _, s := range sessions {
+		r := mcp.Resource{URI: mcp.SessionURI(s.ID), Name: s.ID, Title: s.Title, MimeType: mcp.TranscriptMimeType}
+		if s.Time.Updated > 0 {
+			r.Description = "Updated " + time.UnixMilli(s.Time.Updated).UTC().Format(time.RFC3339)
+		}
+		resources = append(resources, r)
+	}
+	page, next, mErr := mcp.Paginate(req.Params, resources, mcp.PageSize)
+	if mErr != nil {
+		return nil, mErr
 	}
+	return mcp.ListResult("resources", page, next), nil
 }
 
-func getTools() []mcpTool {
-	return []mcpTool{
-		{
-			Name:        "opencode_run",
-			Description: "Run AI code assistant with a message. This is the main tool for code editing, analysis, and generation.",
-			InputSchema: map[string]any{
-				"type": "object",
-				"properties": map[string]any{
-					"message": map[string]any{
-						"type":        "string",
-						"description": "The message/prompt to send to the AI assistant",
-					},
-					"cwd": map[string]any{
-						"type":        "string",
-						"description": "Project directory to work in",
-					},
-					"model": map[string]any{
-						"type":        "string",
-						"description": "Model to use (e.g., 'github-copilot/claude-sonnet-4')",
-					},
-					"files": map[string]any{
-						"type":        "array",
-						"items":       map[string]any{"type": "string"},
-						"description": "File paths to attach to the message for context",
-					},
-				},
-				"required": []string{"message"},
-			},
-		},
-		{
-			Name:        "opencode_models",
-			Description: "List all available AI models",
-			InputSchema: map[string]any{
-				"type":       "object",
-				"properties": map[string]any{},
-			},
-		},
-		{
-			Name:        "opencode_exec",
-			Description: "Run any opencode-cli command with custom arguments",
-			InputSchema: map[string]any{
-				"type": "object",
-				"properties": map[string]any{
-					"args": map[string]any{
-						"type":        "array",
-						"items":       map[string]any{"type": "string"},
-						"description": "Command arguments",
-					},
-					"cwd": map[string]any{
-						"type":        "string",
-						"description": "Working directory",
-					},
-				},
-				"required": []string{"args"},
-			},
-		},
+// readSession answers resources/read with the transcript of a session.
+func readSession(ctx context.Context, req *mcp.Request) (any, *mcp.Error) {
+	var params struct {
+		URI string `json:"uri"`
+	}
+	if err := json.Unmarshal(req.Params, &params); err != nil || params.URI == "" {
+		return nil, mcp.NewError(mcp.CodeInvalidParams, "invalid params")
+	}
+	id, ok := mcp.SessionID(params.URI)
+	if !ok {
+		return nil, mcp.NewError(mcp.CodeResourceNotFound, fmt.Sprintf("resource not found: %s", params.URI))
+	}
+	output, err := cliOutput(ctx, runner.ExportArgs(id)...)
+	if err != nil {
+		return nil, mcp.NewError(mcp.CodeResourceNotFound, fmt.Sprintf("resource not found: %s (%v)", params.URI, err))
+	}
+	_, transcript, err := runner.RenderExport(output)
+	if err != nil {
+		return nil, mcp.NewError(mcp.CodeServerError, err.Error())
 	}
+	return map[string]any{"contents": []mcp.ResourceContents{{
+		URI:      params.URI,
+		MimeType: mcp.TranscriptMimeType,
+		Text:     transcript,
+	}}}, nil
 }
 
-func handleToolsCall(req mcpRequest) {
-	var params struct {
-		Name      string          `json:"name"`
-		Arguments json.RawMessage `json:"arguments"`
+// complete answers completion/complete: the model argument of
+// opencode_run completes from the cached model list.
+func complete(_ context.Context, req *mcp.Request) (any, *mcp.Error) {
+	params, err := mcp.ParseCompleteParams(req.Params)
+	if err != nil {
+		return nil, err
 	}
-	if err := json.Unmarshal(req.Params, &params); err != nil {
-		writeError(req.ID, -32602, "invalid params")
-		return
+	var candidates []string
+	if params.CompletesModel() {
+		candidates = fetchAvailableModels()
 	}
+	return mcp.Completion(candidates, params.Argument.Value), nil
+}
 
-	var cmdArgs []string
-	var cwd string
+// cliOutput runs opencode with args and returns its standard output.
+func cliOutput(ctx context.Context, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, target, args...)
+	runner.KillProcessGroup(cmd)
+	return cmd.Output()
+}
 
-	switch params.Name {
-	case "opencode_run":
-		var args struct {
-			Message string   `json:"message"`
-			Cwd     string   `json:"cwd"`
-			Model   string   `json:"model"`
-			Files   []string `json:"files"`
-		}
-		if err := json.Unmarshal(params.Arguments, &args); err != nil {
-			writeError(req.ID, -32602, "invalid arguments")
-			return
-		}
-		if args.Message == "" {
-			writeError(req.ID, -32602, "missing message")
-			return
-		}
+func runTool(ctx context.Context, req *mcp.Request, arguments json.RawMessage) (*mcp.ToolResult, *mcp.Error) {
+	var args struct {
+		Message  string   `json:"message"`
+		Cwd      string   `json:"cwd"`
+		Model    string   `json:"model"`
+		Session  string   `json:"session"`
+		Continue bool     `json:"continue"`
+		Agent    string   `json:"agent"`
+		Files    []string `json:"files"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, mcp.NewError(mcp.CodeInvalidParams, "invalid arguments")
+	}
+	if args.Message == "" {
+		return nil, mcp.NewError(mcp.CodeInvalidParams, "missing message")
+	}
 
-		// Use default model if not specified
-		model := args.Model
-		if model == "" {
-			model = getDefaultModel()
-			log.Printf("Using default model: %s", model)
-		}
+	// Use default model if not specified
+	model := args.Model
+	if model == "" {
+		model = getDefaultModel()
+		log.Printf("Using default model: %s", model)
+	}
 
-		cmdArgs = []string{"run", "--format", "json", "--model", model}
-		for _, f := range args.Files {
-			cmdArgs = append(cmdArgs, "--file", f)
-		}
-		cmdArgs = append(cmdArgs, args.Message)
-		cwd = args.Cwd
+	cmdArgs := runner.RunArgs(args.Message, runner.RunOptions{
+		Model:    model,
+		Session:  args.Session,
+		Continue: args.Continue,
+		Agent:    args.Agent,
+		Files:    args.Files,
+	})
+	start := time.Now()
+	writeRunState(req, mcp.RunStarted, nil)
+	result, mErr := runCommand(ctx, req, cmdArgs, args.Cwd, "", true)
+	status := "ok"
+	switch {
+	case ctx.Err() != nil:
+		status = "cancelled"
+	case mErr != nil || result.IsError:
+		status = "error"
+	}
+	writeRunState(req, mcp.RunFinished, map[string]any{"status": status, "durationMs": time.Since(start).Milliseconds()})
+	return result, mErr
+}
 
-	case "opencode_models":
-		cmdArgs = []string{"models"}
+// writeRunState tells the client about a state change of the opencode_run
+// of req. There is no queue here, so runs go straight to started.
+func writeRunState(req *mcp.Request, state string, fields map[string]any) {
+	params := map[string]any{
+		"requestId": req.ID,
+		"tool":      mcp.ToolRun,
+		"state":     state,
+		"time":      time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	for k, v := range fields {
+		params[k] = v
+	}
+	writeMessage(mcp.Notification(mcp.MethodRunState, params))
+}
 
-	case "opencode_exec":
-		var args struct {
-			Args []string `json:"args"`
-			Cwd  string   `json:"cwd"`
-		}
-		if err := json.Unmarshal(params.Arguments, &args); err != nil {
-			writeError(req.ID, -32602, "invalid arguments")
-			return
+func execTool(ctx context.Context, req *mcp.Request, arguments json.RawMessage) (*mcp.ToolResult, *mcp.Error) {
+	var args struct {
+		Args        []string `json:"args"`
+		Cwd         string   `json:"cwd"`
+		Stdin       string   `json:"stdin"`
+		StdinBase64 string   `json:"stdinBase64"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, mcp.NewError(mcp.CodeInvalidParams, "invalid arguments")
+	}
+	if len(args.Args) == 0 {
+		return nil, mcp.NewError(mcp.CodeInvalidParams, "missing args")
+	}
+	if args.StdinBase64 != "" {
+		if args.Stdin != "" {
+			return nil, mcp.NewError(mcp.CodeInvalidParams, "pass either stdin or stdinBase64, not both")
 		}
-		if len(args.Args) == 0 {
-			writeError(req.ID, -32602, "missing args")
-			return
+		stdin, err := base64.StdEncoding.DecodeString(args.StdinBase64)
+		if err != nil {
+			return nil, mcp.NewError(mcp.CodeInvalidParams, "invalid stdinBase64: "+err.Error())
 		}
-		cmdArgs = args.Args
-		cwd = args.Cwd
-
-	default:
-		writeError(req.ID, -32602, fmt.Sprintf("unknown tool: %s", params.Name))
-		return
+		args.Stdin = string(stdin)
 	}
+	return runCommand(ctx, req, args.Args, args.Cwd, args.Stdin, false)
+}
 
-	// Execute command
-	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+// runCommand runs opencode with cmdArgs. With events, its output is an
+// opencode_run event stream: text is streamed as progress notifications
+// and collected into the result, followed by the images the run's tools
+// attached and links to the files they wrote.
+func runCommand(ctx context.Context, req *mcp.Request, cmdArgs []string, cwd, stdin string, events bool) (*mcp.ToolResult, *mcp.Error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
 	defer cancel()
 
 	cmd := exec.CommandContext(ctx, target, cmdArgs...)
 	if cwd != "" {
 		cmd.Dir = cwd
 	}
+	cmd.Stdin = strings.NewReader(stdin)
 	cmd.Stderr = os.Stderr
+	runner.KillProcessGroup(cmd)
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		writeError(req.ID, -32000, err.Error())
-		return
+		return nil, mcp.NewError(mcp.CodeServerError, err.Error())
 	}
 
 	if err := cmd.Start(); err != nil {
-		writeError(req.ID, -32000, err.Error())
-		return
+		return nil, mcp.NewError(mcp.CodeServerError, err.Error())
 	}
 
-	// For opencode_run, stream progress notifications and collect text
 	var textCollector strings.Builder
+	var files runner.RunFiles
 
-	if params.Name == "opencode_run" {
+	if events {
+		// Progress goes out only with the client's token, except to
+		// clients from before tokens were required
+		token := mcp.ProgressToken(req.Params, req.ID, protocolVersion())
+		notify := throttle.New(notifyLimiter, writeMessage, mergeProgress)
 		scanner := bufio.NewScanner(stdout)
 		scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
 
 		for scanner.Scan() {
-			line := scanner.Text()
-			if line == "" {
-				continue
-			}
-
-			var event map[string]any
-			if err := json.Unmarshal([]byte(line), &event); err != nil {
+			event, ok := runner.ParseEvent(scanner.Text())
+			if !ok {
 				continue
 			}
-
-			eventType, _ := event["type"].(string)
-
-			// Extract text content
-			if eventType == "text" {
-				if part, ok := event["part"].(map[string]any); ok {
-					if text, ok := part["text"].(string); ok {
-						textCollector.WriteString(text)
-
-						// Send progress notification
-						writeNotification("notifications/progress", map[string]any{
-							"progressToken": req.ID,
-							"progress":      textCollector.Len(),
-							"message":       text,
-						})
-					}
+			files.Add(event)
+			if text, ok := runner.EventText(event); ok {
+				textCollector.WriteString(text)
+				if token == nil {
+					continue
 				}
+				notify.Send(mcp.Notification("notifications/progress", map[string]any{
+					"progressToken": token,
+					"progress":      textCollector.Len(),
+					"message":       text,
+				}))
 			}
 		}
+		// Drain what the scanner left (a line over its limit) so opencode
+		// isn't blocked writing to a full pipe
+		_, _ = io.Copy(io.Discard, stdout)
+		notify.Flush()
 	} else {
 		// For other tools, just read all output
 		output, _ := io.ReadAll(stdout)
 		textCollector.Write(output)
 	}
 
-	cmd.Wait()
+	isError := false
+	if err := cmd.Wait(); err != nil {
+		isError = true
+		fmt.Fprintf(&textCollector, "\n[exit code: %d]", cmd.ProcessState.ExitCode())
+	}
 
-	// Send final result
-	result := toolCallResult{
-		Content: []toolContent{{Type: "text", Text: textCollector.String()}},
-		IsError: false,
+	content := []mcp.Content{{Type: "text", Text: textCollector.String()}}
+	for _, img := range files.Images {
+		if img.Size <= mcp.MaxImageBytes {
+			content = append(content, mcp.ImageContent(img.MimeType, img.Data))
+		}
 	}
-	writeResponse(req.ID, result)
+	for _, p := range files.Paths {
+		if link, ok := mcp.FileLink(p); ok {
+			content = append(content, link)
+		}
+	}
+	return &mcp.ToolResult{
+		Content: mcp.ContentFor(content, protocolVersion()),
+		IsError: isError,
+	}, nil
 }
 
-func writeResponse(id any, result any) {
-	resp := mcpResponse{
-		JSONRPC: "2.0",
-		ID:      id,
-		Result:  result,
-	}
+// stdoutMu keeps the messages of concurrent calls from interleaving.
+var stdoutMu sync.Mutex
+
+func writeResponse(resp *mcp.Response) {
 	data, _ := json.Marshal(resp)
+	stdoutMu.Lock()
 	fmt.Println(string(data))
-	log.Printf("Response: id=%v len=%d", id, len(data))
+	stdoutMu.Unlock()
+	if resp.Error != nil {
+		log.Printf("Error: id=%s code=%d msg=%s", resp.ID, resp.Error.Code, resp.Error.Message)
+		return
+	}
+	log.Printf("Response: id=%s len=%d", resp.ID, len(data))
 }
 
-func writeError(id any, code int, message string) {
-	resp := mcpResponse{
-		JSONRPC: "2.0",
-		ID:      id,
-		Error:   &mcpError{Code: code, Message: message},
-	}
-	data, _ := json.Marshal(resp)
+func writeMessage(msg any) {
+	data, _ := json.Marshal(msg)
+	stdoutMu.Lock()
 	fmt.Println(string(data))
-	log.Printf("Error: id=%v code=%d msg=%s", id, code, message)
+	stdoutMu.Unlock()
 }
 
-func writeNotification(method string, params any) {
-	notification := map[string]any{
-		"jsonrpc": "2.0",
-		"method":  method,
-		"params":  params,
+// mergeProgress coalesces queued progress notifications: the text deltas
+// are concatenated and the latest progress kept.
+func mergeProgress(queued, next any) (any, throttle.Merge) {
+	qa, _ := queued.(map[string]any)
+	qb, _ := next.(map[string]any)
+	a, _ := qa["params"].(map[string]any)
+	b, _ := qb["params"].(map[string]any)
+	ta, okA := a["message"].(string)
+	tb, okB := b["message"].(string)
+	if !okA || !okB {
+		return nil, throttle.Separate
 	}
-	data, _ := json.Marshal(notification)
-	fmt.Println(string(data))
+	return mcp.Notification("notifications/progress", map[string]any{
+		"progressToken": b["progressToken"],
+		"progress":      b["progress"],
+		"message":       ta + tb,
+	}), throttle.Merged
 }
 
 func getenv(key, def string) string {
@@ -379,82 +463,23 @@ func getenv(key, def string) string {
 
 // fetchAvailableModels fetches and caches available models
 func fetchAvailableModels() []string {
-	modelCacheMu.RLock()
-	if len(availableModels) > 0 && time.Since(modelCacheTime) < modelCacheTTL {
-		models := availableModels
-		modelCacheMu.RUnlock()
-		return models
-	}
-	modelCacheMu.RUnlock()
-
-	modelCacheMu.Lock()
-	defer modelCacheMu.Unlock()
-
-	if len(availableModels) > 0 && time.Since(modelCacheTime) < modelCacheTTL {
-		return availableModels
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, target, "models")
-	output, err := cmd.Output()
-	if err != nil {
-		log.Printf("Failed to fetch models: %v", err)
-		return nil
-	}
-
-	var models []string
-	lines := strings.Split(string(output), "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line != "" && !strings.HasPrefix(line, "#") {
-			parts := strings.Fields(line)
-			if len(parts) > 0 {
-				models = append(models, parts[0])
-			}
+	return modelCache.Get(func(ctx context.Context) ([]string, error) {
+		output, err := exec.CommandContext(ctx, target, "models").Output()
+		if err != nil {
+			return nil, err
 		}
-	}
-
-	if len(models) > 0 {
-		availableModels = models
-		modelCacheTime = time.Now()
-		log.Printf("Cached %d available models", len(models))
-	}
-
-	return models
+		var models []string
+		for _, m := range runner.ParseModelsTable(string(output)) {
+			models = append(models, m.ID)
+		}
+		return models, nil
+	})
 }
 
 // getDefaultModel returns the best available model
 func getDefaultModel() string {
-	models := fetchAvailableModels()
-
-	// Preferred models (tested to work with --format json)
-	preferredModels := []string{
-		"github-copilot/gpt-5.2-codex",
-		"github-copilot/gpt-5.1-codex",
-		"github-copilot/gpt-4o",
-		"github-copilot/gpt-4.1",
-	}
-
-	for _, preferred := range preferredModels {
-		for _, available := range models {
-			if available == preferred {
-				return available
-			}
-		}
+	if model := runner.DefaultModel(fetchAvailableModels()); model != "" {
+		return model
 	}
-
-	// Return first github-copilot model
-	for _, available := range models {
-		if strings.HasPrefix(available, "github-copilot/") {
-			return available
-		}
-	}
-
-	if len(models) > 0 {
-		return models[0]
-	}
-
 	return defaultModel
 }
@@ -1,3 +1,9 @@
 module opencode-mcp
 
 go 1.22
+
+require (
+	github.com/klauspost/compress v1.18.0
+	github.com/lib/pq v1.10.9
+	github.com/mattn/go-sqlite3 v1.14.33
+)
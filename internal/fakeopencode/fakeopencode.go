@@ -0,0 +1,136 @@
+// Package fakeopencode is a scriptable stand-in for the opencode CLI in
+// end-to-end tests. A test binary becomes the fake when it is re-executed
+// with a script in the environment:
+//
+//	func TestMain(m *testing.M) {
+//		if fakeopencode.Active() {
+//			os.Exit(fakeopencode.Main(os.Args[1:]))
+//		}
+//		os.Exit(m.Run())
+//	}
+//
+// Tests then point the server at os.Executable() and set the script with
+// Setenv, which the server's child processes inherit.
+package fakeopencode
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvScript holds the JSON-encoded Script of the fake.
+const EnvScript = "FAKEOPENCODE_SCRIPT"
+
+// Script describes how the fake behaves.
+type Script struct {
+	Version string   `json:"version,omitempty"` // printed by --version; default 1.0.0-fake
+	Models  []string `json:"models,omitempty"`  // printed by models; default fake/model
+
+	// `run` prints a text event HugeLine bytes long when positive, then
+	// Lines, one per line, sleeping LineDelay after each. It then writes
+	// Stderr, and exits with ExitCode, or blocks until killed with Hang.
+	Lines     []string      `json:"lines,omitempty"`
+	LineDelay time.Duration `json:"lineDelay,omitempty"`
+	HugeLine  int           `json:"hugeLine,omitempty"`
+	Stderr    string        `json:"stderr,omitempty"`
+	ExitCode  int           `json:"exitCode,omitempty"`
+	Hang      bool          `json:"hang,omitempty"`
+
+	PIDFile string `json:"pidFile,omitempty"` // written with the pid of `run`, to check it was killed
+
+	// NoJSONFormat makes `run` behave like opencode releases without
+	// --format json: the flag is an unknown option.
+	NoJSONFormat bool `json:"noJSONFormat,omitempty"`
+}
+
+// Encode returns the value of EnvScript for s.
+func (s Script) Encode() string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// Text returns a text event line carrying text.
+func Text(text string) string {
+	b, _ := json.Marshal(map[string]any{"type": "text", "sessionID": "ses_fake", "part": map[string]any{"text": text}})
+	return string(b)
+}
+
+// Active reports whether this process should act as the fake.
+func Active() bool {
+	return os.Getenv(EnvScript) != ""
+}
+
+// Main runs the fake with the CLI arguments args and returns the exit code.
+func Main(args []string) int {
+	var s Script
+	if err := json.Unmarshal([]byte(os.Getenv(EnvScript)), &s); err != nil {
+		fmt.Fprintf(os.Stderr, "fakeopencode: invalid %s: %v\n", EnvScript, err)
+		return 2
+	}
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "fakeopencode: missing command")
+		return 2
+	}
+	switch args[0] {
+	case "--version":
+		fmt.Println(orDefault(s.Version, "1.0.0-fake"))
+		return 0
+	case "models":
+		models := s.Models
+		if len(models) == 0 {
+			models = []string{"fake/model"}
+		}
+		b, _ := json.Marshal(models)
+		fmt.Println(string(b))
+		return 0
+	case "run":
+		return run(s, args[1:])
+	}
+	fmt.Fprintf(os.Stderr, "fakeopencode: unsupported command %q\n", args[0])
+	return 2
+}
+
+func run(s Script, args []string) int {
+	for _, a := range args {
+		switch {
+		case a == "--help":
+			fmt.Println("opencode run [message..]\n\n  --model     model to use")
+			if !s.NoJSONFormat {
+				fmt.Println("  --format    output format: default or json")
+			}
+			return 0
+		case a == "--format" && s.NoJSONFormat:
+			fmt.Fprintln(os.Stderr, "error: unknown option '--format'")
+			return 1
+		}
+	}
+	if s.PIDFile != "" {
+		if err := os.WriteFile(s.PIDFile, []byte(strconv.Itoa(os.Getpid())), 0o644); err != nil {
+			fmt.Fprintf(os.Stderr, "fakeopencode: %v\n", err)
+			return 2
+		}
+	}
+	if s.HugeLine > 0 {
+		fmt.Println(Text(strings.Repeat("x", s.HugeLine)))
+	}
+	for _, line := range s.Lines {
+		fmt.Println(line)
+		time.Sleep(s.LineDelay)
+	}
+	fmt.Fprint(os.Stderr, s.Stderr)
+	for s.Hang {
+		time.Sleep(time.Hour) // until killed
+	}
+	return s.ExitCode
+}
+
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
@@ -0,0 +1,75 @@
+package mcp
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// MethodComplete asks for completions of an argument value.
+const MethodComplete = "completion/complete"
+
+// maxCompletions is the most values a completion result may carry.
+const maxCompletions = 100
+
+// CompleteParams are the params of completion/complete. Ref.Type is
+// "ref/prompt" (with Name) or "ref/resource" (with URI); this server also
+// completes tool arguments for clients that send "ref/tool" with Name.
+type CompleteParams struct {
+	Ref struct {
+		Type string `json:"type"`
+		Name string `json:"name,omitempty"`
+		URI  string `json:"uri,omitempty"`
+	} `json:"ref"`
+	Argument struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	} `json:"argument"`
+}
+
+// ParseCompleteParams decodes the params of completion/complete.
+func ParseCompleteParams(raw json.RawMessage) (CompleteParams, *Error) {
+	var p CompleteParams
+	if err := json.Unmarshal(raw, &p); err != nil || p.Ref.Type == "" || p.Argument.Name == "" {
+		return p, NewError(CodeInvalidParams, "invalid params: ref and argument.name are required")
+	}
+	return p, nil
+}
+
+// CompletesModel reports whether p asks for a model: the model argument of
+// a prompt, or of opencode_run.
+func (p CompleteParams) CompletesModel() bool {
+	if p.Argument.Name != "model" {
+		return false
+	}
+	return p.Ref.Type == "ref/prompt" || (p.Ref.Type == "ref/tool" && p.Ref.Name == ToolRun)
+}
+
+// Completion is the result of completion/complete for candidates filtered
+// by value: those starting with it first, then those containing it, both
+// case-insensitively and in candidate order.
+func Completion(candidates []string, value string) map[string]any {
+	value = strings.ToLower(value)
+	var prefix, contains []string
+	for _, c := range candidates {
+		lc := strings.ToLower(c)
+		switch {
+		case strings.HasPrefix(lc, value):
+			prefix = append(prefix, c)
+		case strings.Contains(lc, value):
+			contains = append(contains, c)
+		}
+	}
+	values := append(prefix, contains...)
+	total := len(values)
+	if total > maxCompletions {
+		values = values[:maxCompletions]
+	}
+	if values == nil {
+		values = []string{}
+	}
+	return map[string]any{"completion": map[string]any{
+		"values":  values,
+		"total":   total,
+		"hasMore": total > len(values),
+	}}
+}
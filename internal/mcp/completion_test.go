@@ -0,0 +1,50 @@
+package mcp
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// Test completions list prefix matches before substring matches
+func TestCompletion(t *testing.T) {
+	models := []string{"openai/gpt-5", "anthropic/claude-sonnet-4", "github-copilot/claude-sonnet-4", "openai/o3"}
+	completion := Completion(models, "Claude")["completion"].(map[string]any)
+	if want := []string{"anthropic/claude-sonnet-4", "github-copilot/claude-sonnet-4"}; !reflect.DeepEqual(completion["values"], want) {
+		t.Errorf("values = %v, want %v", completion["values"], want)
+	}
+	completion = Completion(models, "openai/")["completion"].(map[string]any)
+	if want := []string{"openai/gpt-5", "openai/o3"}; !reflect.DeepEqual(completion["values"], want) || completion["total"] != 2 || completion["hasMore"] != false {
+		t.Errorf("completion = %v", completion)
+	}
+
+	many := make([]string, 150)
+	for i := range many {
+		many[i] = "p/m"
+	}
+	completion = Completion(many, "")["completion"].(map[string]any)
+	if len(completion["values"].([]string)) != maxCompletions || completion["total"] != 150 || completion["hasMore"] != true {
+		t.Errorf("capped completion: total=%v hasMore=%v", completion["total"], completion["hasMore"])
+	}
+}
+
+// Test only model arguments of prompts and opencode_run are completed
+func TestCompletesModel(t *testing.T) {
+	for params, want := range map[string]bool{
+		`{"ref":{"type":"ref/prompt","name":"code-review"},"argument":{"name":"model","value":"an"}}`: true,
+		`{"ref":{"type":"ref/tool","name":"opencode_run"},"argument":{"name":"model","value":""}}`:    true,
+		`{"ref":{"type":"ref/tool","name":"opencode_exec"},"argument":{"name":"model","value":""}}`:   false,
+		`{"ref":{"type":"ref/prompt","name":"code-review"},"argument":{"name":"focus","value":""}}`:   false,
+	} {
+		p, err := ParseCompleteParams(json.RawMessage(params))
+		if err != nil {
+			t.Fatalf("%s: %v", params, err)
+		}
+		if got := p.CompletesModel(); got != want {
+			t.Errorf("%s: CompletesModel = %v, want %v", params, got, want)
+		}
+	}
+	if _, err := ParseCompleteParams(json.RawMessage(`{"argument":{"name":"model"}}`)); err == nil {
+		t.Error("missing ref accepted")
+	}
+}
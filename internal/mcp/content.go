@@ -0,0 +1,60 @@
+package mcp
+
+import (
+	"mime"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Content types of tool results besides text.
+const (
+	ContentImage        = "image"
+	ContentResourceLink = "resource_link"
+)
+
+// MaxImageBytes caps an image returned inline as image content; larger
+// ones are linked or left out.
+const MaxImageBytes = 1 << 20
+
+// ImageContent returns image content of base64 data.
+func ImageContent(mimeType, data string) Content {
+	return Content{Type: ContentImage, MimeType: mimeType, Data: data}
+}
+
+// ResourceLink returns a resource_link to uri.
+func ResourceLink(uri, name, mimeType string) Content {
+	return Content{Type: ContentResourceLink, URI: uri, Name: name, MimeType: mimeType}
+}
+
+// FileLink returns a resource_link to the local file at the absolute path
+// p, typed by its extension, reporting false for relative paths.
+func FileLink(p string) (Content, bool) {
+	if !filepath.IsAbs(p) {
+		return Content{}, false
+	}
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(p)}
+	if !strings.HasPrefix(u.Path, "/") { // C:/... on Windows
+		u.Path = "/" + u.Path
+	}
+	mimeType, _, _ := strings.Cut(mime.TypeByExtension(path.Ext(u.Path)), ";")
+	return ResourceLink(u.String(), path.Base(u.Path), mimeType), true
+}
+
+// ContentFor adapts content to clients of protocol revision version:
+// resource links become text for clients before VersionResourceLinks. An
+// empty version counts as current. content itself is not modified.
+func ContentFor(content []Content, version string) []Content {
+	if version == "" || version >= VersionResourceLinks {
+		return content
+	}
+	out := make([]Content, len(content))
+	for i, c := range content {
+		if c.Type == ContentResourceLink {
+			c = Content{Type: "text", Text: c.Name + ": " + c.URI}
+		}
+		out[i] = c
+	}
+	return out
+}
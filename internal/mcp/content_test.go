@@ -0,0 +1,32 @@
+package mcp
+
+import (
+	"runtime"
+	"testing"
+)
+
+// Test files are linked by file URI and links become text for old clients
+func TestContentFor(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix paths")
+	}
+	if _, ok := FileLink("src/main.go"); ok {
+		t.Error("relative path linked")
+	}
+	link, ok := FileLink("/work/my repo/notes.txt")
+	if !ok || link.Type != ContentResourceLink || link.URI != "file:///work/my%20repo/notes.txt" || link.Name != "notes.txt" || link.MimeType != "text/plain" {
+		t.Errorf("FileLink = %+v", link)
+	}
+
+	content := []Content{{Type: "text", Text: "done"}, ImageContent("image/png", "cG5n"), link}
+	if got := ContentFor(content, "2025-06-18"); got[2].Type != ContentResourceLink {
+		t.Errorf("2025-06-18 got %+v", got[2])
+	}
+	got := ContentFor(content, "2025-03-26")
+	if got[1].Type != ContentImage || got[2].Type != "text" || got[2].Text != "notes.txt: file:///work/my%20repo/notes.txt" {
+		t.Errorf("2025-03-26 got %+v", got)
+	}
+	if content[2].Type != ContentResourceLink {
+		t.Error("content modified")
+	}
+}
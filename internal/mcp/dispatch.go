@@ -0,0 +1,196 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Handler answers one request method. The result is marshalled into the
+// response.
+type Handler func(ctx context.Context, req *Request) (result any, err *Error)
+
+// ToolHandler runs one tool with the call's arguments.
+type ToolHandler func(ctx context.Context, req *Request, arguments json.RawMessage) (*ToolResult, *Error)
+
+// Dispatcher routes requests to method handlers and tools/call to tool
+// handlers, after checking the arguments against the tool's input schema.
+// It knows nothing of the transport: the transport decodes a message,
+// calls Dispatch and writes the response, if any.
+type Dispatcher struct {
+	methods   map[string]Handler
+	tools     []Tool
+	toolFuncs map[string]ToolHandler
+	inflight  *Inflight
+	scope     func(ctx context.Context) string
+}
+
+// NewDispatcher returns a dispatcher answering ping, tools/list and
+// tools/call, and stopping calls named by notifications/cancelled.
+// initialize is left to the server, which knows its capabilities.
+func NewDispatcher() *Dispatcher {
+	d := &Dispatcher{methods: map[string]Handler{}, toolFuncs: map[string]ToolHandler{}, inflight: NewInflight()}
+	d.Handle("ping", func(context.Context, *Request) (any, *Error) {
+		return map[string]any{}, nil
+	})
+	d.Handle("tools/list", func(_ context.Context, req *Request) (any, *Error) {
+		tools, next, err := Paginate(req.Params, d.Tools(), PageSize)
+		if err != nil {
+			return nil, err
+		}
+		return ListResult("tools", tools, next), nil
+	})
+	d.Handle("tools/call", d.callTool)
+	return d
+}
+
+// Handle registers h for method, replacing any handler before it.
+func (d *Dispatcher) Handle(method string, h Handler) {
+	d.methods[method] = h
+}
+
+// Tool registers a tool. Tools are listed in registration order.
+func (d *Dispatcher) Tool(t Tool, h ToolHandler) {
+	if _, ok := d.toolFuncs[t.Name]; !ok {
+		d.tools = append(d.tools, t)
+	}
+	d.toolFuncs[t.Name] = h
+}
+
+// Scope qualifies the keys of requests in flight with scope(ctx), for
+// transports serving several clients whose request IDs may collide, e.g.
+// the session ID and a space.
+func (d *Dispatcher) Scope(scope func(ctx context.Context) string) {
+	d.scope = scope
+}
+
+// Inflight returns the requests in flight, for transports that run some
+// requests outside Dispatch or end them all, e.g. with the session.
+func (d *Dispatcher) Inflight() *Inflight {
+	return d.inflight
+}
+
+// InflightKey returns the key of the request id under ctx's scope.
+func (d *Dispatcher) InflightKey(ctx context.Context, id json.RawMessage) string {
+	if d.scope == nil {
+		return string(id)
+	}
+	return d.scope(ctx) + string(id)
+}
+
+// Tools returns the registered tool definitions.
+func (d *Dispatcher) Tools() []Tool {
+	return append([]Tool(nil), d.tools...)
+}
+
+// Decode parses one JSON-RPC message, returning the error response for
+// invalid ones.
+func Decode(data []byte) (*Request, *Response) {
+	var req Request
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, ErrorResponse(nil, NewError(CodeParseError, "invalid JSON"))
+	}
+	if req.Method == "" {
+		return nil, ErrorResponse(req.ID, NewError(CodeInvalidRequest, "missing method"))
+	}
+	return &req, nil
+}
+
+// Dispatch runs the handler of req's method. It returns nil for
+// notifications and cancelled requests, which get no response.
+func (d *Dispatcher) Dispatch(ctx context.Context, req *Request) *Response {
+	if req.ID != nil {
+		var done func()
+		ctx, done = d.inflight.Start(ctx, d.InflightKey(ctx, req.ID))
+		defer done()
+	}
+	return d.dispatch(ctx, req)
+}
+
+// Go is Dispatch for transports reading one message at a time: tool calls
+// run in a goroutine, so that a notifications/cancelled read after Go
+// returns stops them. Other requests, and tool calls with invalid params,
+// are answered before Go returns. reply gets the response; nil when there
+// is none.
+func (d *Dispatcher) Go(ctx context.Context, req *Request, reply func(*Response)) {
+	if req.Method != "tools/call" || req.ID == nil {
+		reply(d.Dispatch(ctx, req))
+		return
+	}
+	if _, _, err := d.tool(req); err != nil {
+		reply(ErrorResponse(req.ID, err))
+		return
+	}
+	ctx, done := d.inflight.Start(ctx, d.InflightKey(ctx, req.ID))
+	go func() {
+		defer done()
+		reply(d.dispatch(ctx, req))
+	}()
+}
+
+func (d *Dispatcher) dispatch(ctx context.Context, req *Request) *Response {
+	if req.Method == "notifications/cancelled" {
+		var params CancelledParams
+		if json.Unmarshal(req.Params, &params) == nil && d.inflight.Cancel(d.InflightKey(ctx, params.RequestID)) {
+			log.Printf("[mcp] cancelled request %s: %s", params.RequestID, params.Reason)
+		}
+		return nil
+	}
+	h, ok := d.methods[req.Method]
+	if !ok {
+		// Notifications (initialized, cancelled, ...) are accepted
+		// without a response
+		if strings.HasPrefix(req.Method, "notifications/") {
+			return nil
+		}
+		return ErrorResponse(req.ID, NewError(CodeMethodNotFound, fmt.Sprintf("method not found: %s", req.Method)))
+	}
+	result, err := h(ctx, req)
+	if Cancelled(ctx) {
+		return nil
+	}
+	if err != nil {
+		return ErrorResponse(req.ID, err)
+	}
+	return Result(req.ID, result)
+}
+
+// tool returns the handler and arguments of a tools/call.
+func (d *Dispatcher) tool(req *Request) (ToolHandler, json.RawMessage, *Error) {
+	var params struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return nil, nil, NewError(CodeInvalidParams, "invalid params")
+	}
+	h, ok := d.toolFuncs[params.Name]
+	if !ok {
+		return nil, nil, NewError(CodeInvalidParams, fmt.Sprintf("unknown tool: %s", params.Name))
+	}
+	for _, t := range d.tools {
+		if t.Name != params.Name {
+			continue
+		}
+		if verr := ValidateArguments(t.InputSchema, params.Arguments); verr != nil {
+			err := NewError(CodeInvalidParams, "invalid arguments: "+verr.Error())
+			err.Data = map[string]any{"pointer": verr.Pointer}
+			return nil, nil, err
+		}
+	}
+	return h, params.Arguments, nil
+}
+
+func (d *Dispatcher) callTool(ctx context.Context, req *Request) (any, *Error) {
+	h, args, err := d.tool(req)
+	if err != nil {
+		return nil, err
+	}
+	result, err := h(ctx, req, args)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,107 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+// Test requests are routed to methods and tools, and notifications get no response
+func TestDispatcher(t *testing.T) {
+	d := NewDispatcher()
+	d.Tool(RunTool().WithProperties(map[string]any{"labels": map[string]any{"type": "array"}}), func(_ context.Context, _ *Request, args json.RawMessage) (*ToolResult, *Error) {
+		return &ToolResult{Content: []Content{{Type: "text", Text: string(args)}}}, nil
+	})
+	dispatch := func(line string) *Response {
+		t.Helper()
+		req, errResp := Decode([]byte(line))
+		if errResp != nil {
+			return errResp
+		}
+		return d.Dispatch(context.Background(), req)
+	}
+
+	if resp := dispatch(`{"jsonrpc":"2.0","id":1,"method":"ping"}`); resp == nil || resp.Error != nil || string(resp.ID) != "1" {
+		t.Errorf("ping = %+v", resp)
+	}
+	if resp := dispatch(`{"jsonrpc":"2.0","method":"notifications/initialized"}`); resp != nil {
+		t.Errorf("notification answered: %+v", resp)
+	}
+	for line, code := range map[string]int{
+		`not json`:                 CodeParseError,
+		`{"jsonrpc":"2.0","id":2}`: CodeInvalidRequest,
+		`{"jsonrpc":"2.0","id":3,"method":"resources/list"}`:                                   CodeMethodNotFound,
+		`{"jsonrpc":"2.0","id":4,"method":"tools/call","params":{"name":"nope"}}`:              CodeInvalidParams,
+		`{"jsonrpc":"2.0","id":5,"method":"tools/call","params":"not an object","extra":true}`: CodeInvalidParams,
+	} {
+		if resp := dispatch(line); resp == nil || resp.Error == nil || resp.Error.Code != code {
+			t.Errorf("%s = %+v, want error %d", line, resp, code)
+		}
+	}
+
+	resp := dispatch(`{"jsonrpc":"2.0","id":"a","method":"tools/list"}`)
+	tools := resp.Result.(map[string]any)["tools"].([]Tool)
+	props := tools[0].InputSchema.(map[string]any)["properties"].(map[string]any)
+	if len(tools) != 1 || props["labels"] == nil || props["message"] == nil {
+		t.Errorf("tools/list = %+v", tools)
+	}
+	if base := RunTool().InputSchema.(map[string]any)["properties"].(map[string]any); base["labels"] != nil {
+		t.Error("WithProperties changed the shared definition")
+	}
+
+	resp = dispatch(`{"jsonrpc":"2.0","id":6,"method":"tools/call","params":{"name":"opencode_run","arguments":{"message":"hi"}}}`)
+	if result, ok := resp.Result.(*ToolResult); !ok || result.Content[0].Text != `{"message":"hi"}` {
+		t.Errorf("tools/call = %+v", resp)
+	}
+
+	// A cancelled call gets no response
+	d.Tool(ModelsTool(), func(ctx context.Context, _ *Request, _ json.RawMessage) (*ToolResult, *Error) {
+		<-ctx.Done()
+		return &ToolResult{IsError: true}, nil
+	})
+	req, _ := Decode([]byte(`{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"name":"opencode_models"}}`))
+	replies := make(chan *Response, 1)
+	d.Go(context.Background(), req, func(resp *Response) { replies <- resp })
+	if resp := dispatch(`{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":7}}`); resp != nil {
+		t.Errorf("cancellation answered: %+v", resp)
+	}
+	if resp := <-replies; resp != nil {
+		t.Errorf("cancelled call answered: %+v", resp)
+	}
+}
+
+// Test a scoped dispatcher only cancels the requests of the same scope
+func TestDispatcherScope(t *testing.T) {
+	type scopeKey struct{}
+	d := NewDispatcher()
+	d.Scope(func(ctx context.Context) string { s, _ := ctx.Value(scopeKey{}).(string); return s + " " })
+	started := make(chan struct{})
+	d.Tool(ModelsTool(), func(ctx context.Context, _ *Request, _ json.RawMessage) (*ToolResult, *Error) {
+		started <- struct{}{}
+		<-ctx.Done()
+		return &ToolResult{IsError: true}, nil
+	})
+	a := context.WithValue(context.Background(), scopeKey{}, "a")
+	b := context.WithValue(context.Background(), scopeKey{}, "b")
+	call, _ := Decode([]byte(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"opencode_models"}}`))
+	cancel, _ := Decode([]byte(`{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":1}}`))
+
+	replies := make(chan *Response, 1)
+	d.Go(a, call, func(resp *Response) { replies <- resp })
+	<-started
+	d.Dispatch(b, cancel)
+	select {
+	case resp := <-replies:
+		t.Fatalf("cancelled from another scope: %+v", resp)
+	default:
+	}
+	if key := d.InflightKey(a, json.RawMessage("1")); key != "a 1" {
+		t.Errorf("InflightKey = %q", key)
+	}
+	if n := d.Inflight().CancelPrefix("a "); n != 1 {
+		t.Errorf("CancelPrefix = %d, want 1", n)
+	}
+	if resp := <-replies; resp != nil {
+		t.Errorf("cancelled call answered: %+v", resp)
+	}
+}
@@ -0,0 +1,91 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+)
+
+// ErrCancelled is the cause of the context of a request the client
+// cancelled with notifications/cancelled.
+var ErrCancelled = errors.New("cancelled by the client")
+
+// CancelledParams are the params of notifications/cancelled.
+type CancelledParams struct {
+	RequestID json.RawMessage `json:"requestId"`
+	Reason    string          `json:"reason,omitempty"`
+}
+
+// Inflight tracks the requests being handled, so that notifications/cancelled
+// can stop them. Keys are request IDs, qualified by whatever scopes them in
+// the transport, e.g. the session.
+type Inflight struct {
+	mu    sync.Mutex
+	calls map[string]*inflightCall
+}
+
+type inflightCall struct {
+	cancel context.CancelCauseFunc
+}
+
+// NewInflight returns an empty registry.
+func NewInflight() *Inflight {
+	return &Inflight{calls: map[string]*inflightCall{}}
+}
+
+// Start registers the request key, returning its context and the function
+// to call when it is done. A client reusing a key in flight can only cancel
+// the latest request.
+func (f *Inflight) Start(ctx context.Context, key string) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	c := &inflightCall{cancel}
+	f.mu.Lock()
+	f.calls[key] = c
+	f.mu.Unlock()
+	return ctx, func() {
+		f.mu.Lock()
+		if f.calls[key] == c {
+			delete(f.calls, key)
+		}
+		f.mu.Unlock()
+		cancel(nil)
+	}
+}
+
+// Cancel cancels the request key with ErrCancelled, reporting whether it was
+// in flight.
+func (f *Inflight) Cancel(key string) bool {
+	f.mu.Lock()
+	c, ok := f.calls[key]
+	delete(f.calls, key)
+	f.mu.Unlock()
+	if ok {
+		c.cancel(ErrCancelled)
+	}
+	return ok
+}
+
+// CancelPrefix cancels with ErrCancelled every request whose key starts
+// with prefix, e.g. all of a session's, returning how many were in flight.
+func (f *Inflight) CancelPrefix(prefix string) int {
+	f.mu.Lock()
+	var cancel []*inflightCall
+	for key, c := range f.calls {
+		if strings.HasPrefix(key, prefix) {
+			cancel = append(cancel, c)
+			delete(f.calls, key)
+		}
+	}
+	f.mu.Unlock()
+	for _, c := range cancel {
+		c.cancel(ErrCancelled)
+	}
+	return len(cancel)
+}
+
+// Cancelled reports whether ctx belongs to a request the client cancelled.
+func Cancelled(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), ErrCancelled)
+}
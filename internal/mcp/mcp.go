@@ -0,0 +1,124 @@
+// Package mcp holds what the HTTP and stdio servers share of the Model
+// Context Protocol: the JSON-RPC messages, the definitions of the tools
+// both offer, and a Dispatcher that routes requests to handlers
+// independently of the transport.
+package mcp
+
+import "encoding/json"
+
+// ProtocolVersion is the newest MCP revision the servers implement; see
+// SupportedVersions for the others.
+const ProtocolVersion = "2025-06-18"
+
+// JSON-RPC error codes.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeServerError    = -32000
+)
+
+// Request is a JSON-RPC request or notification.
+type Request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	ID      json.RawMessage `json:"id"` // echoed verbatim: decoding would turn 1 into 1.0 and round large ids
+}
+
+// Response is a JSON-RPC response carrying either Result or Error.
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"` // null when nil
+	Result  any             `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// Error is a JSON-RPC error.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string { return e.Message }
+
+// NewError returns an error with code and message.
+func NewError(code int, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// MethodRunState is the notification of a run's state changes, which
+// clients can show without polling: params.state is one of the Run*
+// states and params.requestId the tools/call it belongs to.
+const MethodRunState = "notifications/opencode/run"
+
+// MethodModelsChanged is the notification that the models opencode offers
+// changed: params.added and params.removed list the model IDs.
+const MethodModelsChanged = "notifications/opencode/models"
+
+// MethodToolsListChanged is the notification that the server's tools
+// changed, so clients should call tools/list again.
+const MethodToolsListChanged = "notifications/tools/list_changed"
+
+// States of a run in MethodRunState notifications.
+const (
+	RunQueued   = "queued"   // waiting for a free run slot
+	RunStarted  = "started"  // opencode is running
+	RunFinished = "finished" // params.status says how it ended
+)
+
+// Tool describes a tool in tools/list.
+type Tool struct {
+	Name         string `json:"name"`
+	Description  string `json:"description"`
+	InputSchema  any    `json:"inputSchema"`
+	OutputSchema any    `json:"outputSchema,omitempty"`
+
+	Annotations *ToolAnnotations `json:"annotations,omitempty"`
+}
+
+// Content is one item of a tool result's content.
+type Content struct {
+	Type string `json:"type"`
+	Text string `json:"text,omitempty"`
+
+	// resource_link fields; MimeType also types image Data
+	URI      string `json:"uri,omitempty"`
+	Name     string `json:"name,omitempty"`
+	MimeType string `json:"mimeType,omitempty"`
+
+	// image data, base64-encoded
+	Data string `json:"data,omitempty"`
+
+	// embedded resource
+	Resource *ResourceContents `json:"resource,omitempty"`
+
+	Meta map[string]any `json:"_meta,omitempty"`
+}
+
+// ToolResult is the result of tools/call.
+type ToolResult struct {
+	Content []Content `json:"content"`
+	IsError bool      `json:"isError,omitempty"`
+}
+
+// Result returns the response to id carrying result.
+func Result(id json.RawMessage, result any) *Response {
+	return &Response{JSONRPC: "2.0", ID: id, Result: result}
+}
+
+// ErrorResponse returns the response to id carrying err.
+func ErrorResponse(id json.RawMessage, err *Error) *Response {
+	return &Response{JSONRPC: "2.0", ID: id, Error: err}
+}
+
+// Notification returns a JSON-RPC notification.
+func Notification(method string, params map[string]any) map[string]any {
+	return map[string]any{
+		"jsonrpc": "2.0",
+		"method":  method,
+		"params":  params,
+	}
+}
@@ -0,0 +1,70 @@
+package mcp
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// PageSize is the most items a page of tools/list, prompts/list or
+// resources/list carries.
+const PageSize = 100
+
+// cursorPrefix marks the cursors this server issues, so a cursor from
+// another server, or a hand-made one, is rejected instead of misread.
+const cursorPrefix = "offset:"
+
+// Paginate returns the page of items the cursor param in params points to,
+// and the cursor of the next page, "" after the last one. Missing params
+// or cursor ask for the first page. Cursors are opaque to clients; they
+// encode an offset into the list, which is stable between calls.
+func Paginate[T any](params json.RawMessage, items []T, size int) ([]T, string, *Error) {
+	var p struct {
+		Cursor *string `json:"cursor"`
+	}
+	if len(params) > 0 {
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, "", NewError(CodeInvalidParams, "invalid params")
+		}
+	}
+	start := 0
+	if p.Cursor != nil {
+		var ok bool
+		if start, ok = decodeCursor(*p.Cursor); !ok || start > len(items) {
+			return nil, "", NewError(CodeInvalidParams, "invalid cursor")
+		}
+	}
+	end := start + size
+	if size <= 0 || end >= len(items) {
+		return items[start:], "", nil
+	}
+	return items[start:end], encodeCursor(end), nil
+}
+
+// ListResult is the result of a list method: items under key, with
+// nextCursor when there are more pages.
+func ListResult(key string, items any, next string) map[string]any {
+	result := map[string]any{key: items}
+	if next != "" {
+		result["nextCursor"] = next
+	}
+	return result
+}
+
+func encodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(cursorPrefix + strconv.Itoa(offset)))
+}
+
+func decodeCursor(cursor string) (int, bool) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, false
+	}
+	n, ok := strings.CutPrefix(string(b), cursorPrefix)
+	if !ok {
+		return 0, false
+	}
+	offset, err := strconv.Atoi(n)
+	return offset, err == nil && offset >= 0
+}
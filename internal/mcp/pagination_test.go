@@ -0,0 +1,41 @@
+package mcp
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// Test following nextCursor visits every item once, and foreign cursors
+// are rejected
+func TestPaginate(t *testing.T) {
+	items := []int{1, 2, 3, 4, 5}
+	var got []int
+	var params json.RawMessage
+	for pages := 0; ; pages++ {
+		if pages > len(items) {
+			t.Fatal("pagination does not end")
+		}
+		page, next, err := Paginate(params, items, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, page...)
+		if next == "" {
+			break
+		}
+		params, _ = json.Marshal(map[string]string{"cursor": next})
+	}
+	if !reflect.DeepEqual(got, items) {
+		t.Errorf("pages = %v, want %v", got, items)
+	}
+
+	if page, next, err := Paginate(json.RawMessage(`{}`), items, PageSize); err != nil || len(page) != 5 || next != "" {
+		t.Errorf("single page = %v, %q, %v", page, next, err)
+	}
+	for _, cursor := range []string{`"bogus"`, `"b2Zmc2V0Oi0x"`, `"` + encodeCursor(6) + `"`} {
+		if _, _, err := Paginate(json.RawMessage(`{"cursor":`+cursor+`}`), items, 2); err == nil || err.Code != CodeInvalidParams {
+			t.Errorf("cursor %s: err = %v", cursor, err)
+		}
+	}
+}
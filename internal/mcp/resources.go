@@ -0,0 +1,67 @@
+package mcp
+
+import "strings"
+
+// CodeResourceNotFound is the error of resources/read for an unknown URI.
+const CodeResourceNotFound = -32002
+
+// SessionURIPrefix starts the URI of an opencode session resource, whose
+// contents are the session's transcript.
+const SessionURIPrefix = "opencode://session/"
+
+// TranscriptMimeType is the type of session transcripts.
+const TranscriptMimeType = "text/markdown"
+
+// Resources describing the server itself, whose contents are JSON.
+const (
+	EffectiveConfigURI = "config://effective"
+	ModelCatalogURI    = "catalog://models"
+	JSONMimeType       = "application/json"
+)
+
+// Resource describes a resource in resources/list.
+type Resource struct {
+	URI         string `json:"uri"`
+	Name        string `json:"name"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	MimeType    string `json:"mimeType,omitempty"`
+}
+
+// ResourceContents is one item of the contents of resources/read, or an
+// embedded resource: Text for text, Blob (base64) for binary data.
+type ResourceContents struct {
+	URI      string `json:"uri"`
+	MimeType string `json:"mimeType,omitempty"`
+	Text     string `json:"text,omitempty"`
+	Blob     string `json:"blob,omitempty"`
+}
+
+// SessionURI returns the URI of the opencode session id.
+func SessionURI(id string) string {
+	return SessionURIPrefix + id
+}
+
+// SessionID returns the session of a SessionURI, reporting false for other
+// URIs. IDs are letters, digits, '_' and '-', and can't start with '-', so
+// they are safe to pass to opencode as arguments.
+func SessionID(uri string) (string, bool) {
+	id, ok := strings.CutPrefix(uri, SessionURIPrefix)
+	if !ok || id == "" || id[0] == '-' {
+		return "", false
+	}
+	for _, r := range id {
+		if !(r == '_' || r == '-' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			return "", false
+		}
+	}
+	return id, true
+}
+
+// Methods of resource subscriptions: a client subscribes to a resource's
+// URI and gets MethodResourcesUpdated, with params.uri, when it changes.
+const (
+	MethodResourcesSubscribe   = "resources/subscribe"
+	MethodResourcesUnsubscribe = "resources/unsubscribe"
+	MethodResourcesUpdated     = "notifications/resources/updated"
+)
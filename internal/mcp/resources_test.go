@@ -0,0 +1,15 @@
+package mcp
+
+import "testing"
+
+// Test session URIs round-trip and only accept IDs safe to pass to opencode
+func TestSessionID(t *testing.T) {
+	if id, ok := SessionID(SessionURI("ses_4fA-9")); !ok || id != "ses_4fA-9" {
+		t.Errorf("SessionID(SessionURI) = %q, %v", id, ok)
+	}
+	for _, uri := range []string{"opencode://session/", "opencode://session/-h", "opencode://session/a/b", "opencode://session/a b", "file:///ses_1"} {
+		if id, ok := SessionID(uri); ok {
+			t.Errorf("SessionID(%q) = %q", uri, id)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package mcp
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// ValidationError is an argument that doesn't match a tool's input schema.
+type ValidationError struct {
+	Pointer string // JSON pointer to the argument, e.g. "/files/0"; "" for the arguments object
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Pointer == "" {
+		return "arguments: " + e.Message
+	}
+	return e.Pointer + ": " + e.Message
+}
+
+// ValidateArguments checks the arguments of a tools/call against the tool's
+// input schema. It supports the JSON Schema keywords tool schemas use:
+// type, properties, required, additionalProperties, items, enum, const,
+// minimum, maximum, minLength, maxLength, minItems, maxItems, and $ref to
+// the schema's own $defs. Missing arguments are an empty object.
+func ValidateArguments(schema any, arguments json.RawMessage) *ValidationError {
+	if len(arguments) == 0 || string(arguments) == "null" {
+		arguments = json.RawMessage(`{}`)
+	}
+	var v any
+	if err := json.Unmarshal(arguments, &v); err != nil {
+		return &ValidationError{Message: "not valid JSON"}
+	}
+	root, _ := schema.(map[string]any)
+	if root == nil {
+		return nil
+	}
+	return validate(root, root, v, "")
+}
+
+// Closed returns schema with additionalProperties false, so arguments it
+// doesn't declare are rejected instead of silently ignored.
+func Closed(schema any) any {
+	s, ok := schema.(map[string]any)
+	if !ok {
+		return schema
+	}
+	if _, set := s["additionalProperties"]; set {
+		return schema
+	}
+	out := make(map[string]any, len(s)+1)
+	for k, v := range s {
+		out[k] = v
+	}
+	out["additionalProperties"] = false
+	return out
+}
+
+func validate(root, s map[string]any, v any, ptr string) *ValidationError {
+	if ref, ok := s["$ref"].(string); ok {
+		def, err := resolveRef(root, ref)
+		if err != nil {
+			return &ValidationError{ptr, err.Error()}
+		}
+		s = def
+	}
+	if t, ok := s["type"]; ok && !matchesType(t, v) {
+		return &ValidationError{ptr, fmt.Sprintf("must be %s, got %s", typeNames(t), jsonType(v))}
+	}
+	if enum, ok := s["enum"]; ok && !inEnum(enum, v) {
+		return &ValidationError{ptr, fmt.Sprintf("must be one of %s", compact(enum))}
+	}
+	if c, ok := s["const"]; ok && !sameJSON(c, v) {
+		return &ValidationError{ptr, fmt.Sprintf("must be %s", compact(c))}
+	}
+	switch v := v.(type) {
+	case map[string]any:
+		return validateObject(root, s, v, ptr)
+	case []any:
+		if n, ok := number(s["minItems"]); ok && float64(len(v)) < n {
+			return &ValidationError{ptr, fmt.Sprintf("must have at least %v items", n)}
+		}
+		if n, ok := number(s["maxItems"]); ok && float64(len(v)) > n {
+			return &ValidationError{ptr, fmt.Sprintf("must have at most %v items", n)}
+		}
+		if items, ok := asSchema(s["items"]); ok {
+			for i, item := range v {
+				if err := validate(root, items, item, fmt.Sprintf("%s/%d", ptr, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		length := len([]rune(v))
+		if n, ok := number(s["minLength"]); ok && float64(length) < n {
+			return &ValidationError{ptr, fmt.Sprintf("must be at least %v characters", n)}
+		}
+		if n, ok := number(s["maxLength"]); ok && float64(length) > n {
+			return &ValidationError{ptr, fmt.Sprintf("must be at most %v characters", n)}
+		}
+	case float64:
+		if n, ok := number(s["minimum"]); ok && v < n {
+			return &ValidationError{ptr, fmt.Sprintf("must be at least %v", n)}
+		}
+		if n, ok := number(s["maximum"]); ok && v > n {
+			return &ValidationError{ptr, fmt.Sprintf("must be at most %v", n)}
+		}
+	}
+	return nil
+}
+
+func validateObject(root, s map[string]any, v map[string]any, ptr string) *ValidationError {
+	for _, name := range stringList(s["required"]) {
+		if _, ok := v[name]; !ok {
+			return &ValidationError{ptr + "/" + escapePointer(name), "missing " + name}
+		}
+	}
+	props, _ := s["properties"].(map[string]any)
+	names := make([]string, 0, len(v))
+	for name := range v {
+		names = append(names, name)
+	}
+	sort.Strings(names) // report the same argument first every time
+	for _, name := range names {
+		p := ptr + "/" + escapePointer(name)
+		if prop, ok := asSchema(props[name]); ok {
+			if err := validate(root, prop, v[name], p); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, declared := props[name]; declared {
+			continue
+		}
+		switch extra := s["additionalProperties"].(type) {
+		case bool:
+			if !extra {
+				return &ValidationError{p, "is not a known argument"}
+			}
+		case map[string]any:
+			if err := validate(root, extra, v[name], p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolveRef returns the definition a local reference like "#/$defs/file"
+// points to.
+func resolveRef(root map[string]any, ref string) (map[string]any, error) {
+	path, ok := strings.CutPrefix(ref, "#/")
+	if !ok {
+		return nil, fmt.Errorf("unsupported $ref %q", ref)
+	}
+	var cur any = root
+	for _, part := range strings.Split(path, "/") {
+		m, _ := cur.(map[string]any)
+		cur = m[unescapePointer(part)]
+	}
+	def, ok := cur.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("unresolved $ref %q", ref)
+	}
+	return def, nil
+}
+
+func matchesType(t, v any) bool {
+	for _, name := range typeList(t) {
+		switch name {
+		case "object":
+			if _, ok := v.(map[string]any); ok {
+				return true
+			}
+		case "array":
+			if _, ok := v.([]any); ok {
+				return true
+			}
+		case "string":
+			if _, ok := v.(string); ok {
+				return true
+			}
+		case "boolean":
+			if _, ok := v.(bool); ok {
+				return true
+			}
+		case "number":
+			if _, ok := v.(float64); ok {
+				return true
+			}
+		case "integer":
+			if f, ok := v.(float64); ok && f == math.Trunc(f) {
+				return true
+			}
+		case "null":
+			if v == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func typeList(t any) []string {
+	if name, ok := t.(string); ok {
+		return []string{name}
+	}
+	return stringList(t)
+}
+
+func typeNames(t any) string {
+	return strings.Join(typeList(t), " or ")
+}
+
+func jsonType(v any) string {
+	switch v := v.(type) {
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	}
+	return "null"
+}
+
+func inEnum(enum, v any) bool {
+	values, ok := asList(enum)
+	if !ok {
+		return true
+	}
+	for _, e := range values {
+		if sameJSON(e, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameJSON compares values the way they'd be encoded, so the []string enums
+// of Go-built schemas match the strings decoded from arguments.
+func sameJSON(a, b any) bool {
+	return compact(a) == compact(b)
+}
+
+func compact(v any) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// asList returns v as a list, whether it was built in Go ([]string) or
+// decoded from JSON ([]any).
+func asList(v any) ([]any, bool) {
+	switch v := v.(type) {
+	case []any:
+		return v, true
+	case []string:
+		out := make([]any, len(v))
+		for i, s := range v {
+			out[i] = s
+		}
+		return out, true
+	}
+	return nil, false
+}
+
+func stringList(v any) []string {
+	list, _ := asList(v)
+	out := make([]string, 0, len(list))
+	for _, e := range list {
+		if s, ok := e.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func asSchema(v any) (map[string]any, bool) {
+	s, ok := v.(map[string]any)
+	return s, ok
+}
+
+func number(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+func escapePointer(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~", "~0"), "/", "~1")
+}
+
+func unescapePointer(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~1", "/"), "~0", "~")
+}
@@ -0,0 +1,50 @@
+package mcp
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// Test arguments are checked against the schema, naming the offending one
+func TestValidateArguments(t *testing.T) {
+	schema := Closed(map[string]any{
+		"type": "object",
+		"$defs": map[string]any{
+			"path": map[string]any{"type": "string", "minLength": 1},
+		},
+		"properties": map[string]any{
+			"message":  map[string]any{"type": "string"},
+			"priority": map[string]any{"type": "string", "enum": []string{"batch", "normal"}},
+			"depth":    map[string]any{"type": "integer", "minimum": 0},
+			"files":    map[string]any{"type": "array", "items": map[string]any{"$ref": "#/$defs/path"}},
+			"a/b":      map[string]any{"type": "boolean"},
+		},
+		"required": []string{"message"},
+	})
+	for _, tt := range []struct {
+		args    string
+		pointer string // "" for valid arguments
+	}{
+		{`{"message":"hi"}`, ""},
+		{`{"message":"hi","priority":"batch","depth":2,"files":["a.go"],"a/b":true}`, ""},
+		{``, "/message"},
+		{`{"message":3}`, "/message"},
+		{`{"message":"hi","priority":"urgent"}`, "/priority"},
+		{`{"message":"hi","depth":1.5}`, "/depth"},
+		{`{"message":"hi","depth":-1}`, "/depth"},
+		{`{"message":"hi","files":["a.go",""]}`, "/files/1"},
+		{`{"message":"hi","a/b":"yes"}`, "/a~1b"},
+		{`{"message":"hi","modle":"x"}`, "/modle"},
+	} {
+		err := ValidateArguments(schema, json.RawMessage(tt.args))
+		switch {
+		case tt.pointer == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", tt.args, err)
+		case tt.pointer != "" && (err == nil || err.Pointer != tt.pointer):
+			t.Errorf("%s: error = %v, want one at %s", tt.args, err, tt.pointer)
+		}
+	}
+	if err := ValidateArguments(schema, json.RawMessage(`[]`)); err == nil || err.Pointer != "" {
+		t.Errorf("array arguments: error = %v", err)
+	}
+}
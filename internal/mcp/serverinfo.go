@@ -0,0 +1,74 @@
+package mcp
+
+import (
+	"runtime/debug"
+	"strings"
+)
+
+// ServerName is the serverInfo name the servers report unless configured
+// otherwise.
+const ServerName = "opencode-mcp"
+
+// VersionInstructions introduced instructions in the initialize result.
+const VersionInstructions = "2025-03-26"
+
+// ServerInfo identifies the server in the initialize result.
+type ServerInfo struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// BuildVersion returns the version of the running binary: injected when
+// the build set one (-ldflags "-X main.version=$(git describe --tags)"),
+// else the module version of a go install, else the VCS revision go build
+// stamped into the binary ("-dirty" with uncommitted changes), else "dev".
+// A leading "v" is dropped, so versions compare with release tags.
+func BuildVersion(injected string) string {
+	if injected != "" {
+		return strings.TrimPrefix(injected, "v")
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "dev"
+	}
+	if v := info.Main.Version; v != "" && v != "(devel)" {
+		return strings.TrimPrefix(v, "v")
+	}
+	var revision string
+	var dirty bool
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			revision = s.Value
+		case "vcs.modified":
+			dirty = s.Value == "true"
+		}
+	}
+	if revision == "" {
+		return "dev"
+	}
+	if len(revision) > 12 {
+		revision = revision[:12]
+	}
+	if dirty {
+		revision += "-dirty"
+	}
+	return revision
+}
+
+// InitializeResult is the result of initialize for a client that requested
+// protocol revision requested. instructions, guidance on using the server
+// for the client's model, are left out when empty and for clients before
+// VersionInstructions.
+func InitializeResult(requested string, capabilities map[string]any, info ServerInfo, instructions string) map[string]any {
+	version := NegotiateVersion(requested)
+	result := map[string]any{
+		"protocolVersion": version,
+		"capabilities":    capabilities,
+		"serverInfo":      info,
+	}
+	if instructions != "" && version >= VersionInstructions {
+		result["instructions"] = instructions
+	}
+	return result
+}
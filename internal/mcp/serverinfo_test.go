@@ -0,0 +1,26 @@
+package mcp
+
+import "testing"
+
+// Test instructions only go to clients that know them, and injected
+// versions lose their "v"
+func TestInitializeResult(t *testing.T) {
+	info := ServerInfo{Name: "acme-agent", Version: BuildVersion("v1.4.0")}
+	if info.Version != "1.4.0" {
+		t.Errorf("BuildVersion = %q", info.Version)
+	}
+	if BuildVersion("") == "" {
+		t.Error("no version without an injected one")
+	}
+
+	got := InitializeResult("2025-06-18", map[string]any{}, info, "always pass cwd")
+	if got["protocolVersion"] != "2025-06-18" || got["serverInfo"] != info || got["instructions"] != "always pass cwd" {
+		t.Errorf("result = %v", got)
+	}
+	if got := InitializeResult("2024-11-05", map[string]any{}, info, "always pass cwd"); got["instructions"] != nil {
+		t.Errorf("instructions for 2024-11-05: %v", got)
+	}
+	if got := InitializeResult("2025-06-18", map[string]any{}, info, ""); got["instructions"] != nil {
+		t.Errorf("empty instructions: %v", got)
+	}
+}
@@ -0,0 +1,139 @@
+package mcp
+
+// Names of the tools both servers offer.
+const (
+	ToolExec   = "opencode_exec"
+	ToolRun    = "opencode_run"
+	ToolModels = "opencode_models"
+)
+
+// RunTool is the definition of opencode_run.
+func RunTool() Tool {
+	return Tool{
+		Name:        ToolRun,
+		Description: "Run AI code assistant with a message. This is the main tool for code editing, analysis, and generation.",
+		InputSchema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"message": map[string]any{
+					"type":        "string",
+					"description": "The message/prompt to send to the AI assistant",
+				},
+				"cwd": map[string]any{
+					"type":        "string",
+					"description": "Project directory to work in",
+				},
+				"model": map[string]any{
+					"type":        "string",
+					"description": "Model to use (e.g., 'github-copilot/claude-sonnet-4')",
+				},
+				"session": map[string]any{
+					"type":        "string",
+					"description": "Session ID to continue a previous conversation",
+				},
+				"continue": map[string]any{
+					"type":        "boolean",
+					"description": "Continue the last session",
+				},
+				"agent": map[string]any{
+					"type":        "string",
+					"description": "Agent to run with (e.g. 'plan' for read-only analysis)",
+				},
+				"files": map[string]any{
+					"type":        "array",
+					"items":       map[string]any{"type": "string"},
+					"description": "File paths to attach to the message for context (relative to cwd or absolute)",
+				},
+			},
+			"required":             []string{"message"},
+			"additionalProperties": false,
+		},
+		Annotations: opencodeAnnotations(),
+	}
+}
+
+// ExecTool is the definition of opencode_exec.
+func ExecTool() Tool {
+	return Tool{
+		Name:        ToolExec,
+		Description: "Run any opencode-cli command with custom arguments. Use this for advanced operations.",
+		InputSchema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"args": map[string]any{
+					"type":        "array",
+					"items":       map[string]any{"type": "string"},
+					"description": "Command arguments (e.g., ['run', '--model', 'gpt-4', 'Hello'])",
+				},
+				"cwd": map[string]any{
+					"type":        "string",
+					"description": "Working directory for the command",
+				},
+				"stdin": map[string]any{
+					"type":        "string",
+					"description": "Standard input to pass to the command",
+				},
+				"stdinBase64": map[string]any{
+					"type":        "string",
+					"description": "Binary standard input, base64-encoded (instead of stdin)",
+				},
+			},
+			"required":             []string{"args"},
+			"additionalProperties": false,
+		},
+		Annotations: opencodeAnnotations(),
+	}
+}
+
+// ModelsTool is the definition of opencode_models.
+func ModelsTool() Tool {
+	return Tool{
+		Name:        ToolModels,
+		Description: "List all available AI models",
+		InputSchema: map[string]any{
+			"type":                 "object",
+			"properties":           map[string]any{},
+			"additionalProperties": false,
+		},
+		Annotations: ReadOnly(),
+	}
+}
+
+// opencodeAnnotations describe the tools that run opencode, which edits
+// files and reaches model providers.
+func opencodeAnnotations() *ToolAnnotations {
+	a := Mutating(true, false)
+	a.OpenWorldHint = hint(true)
+	return a
+}
+
+// WithProperties returns t with props added to its input properties, for
+// arguments only one server supports.
+func (t Tool) WithProperties(props map[string]any) Tool {
+	s, ok := t.InputSchema.(map[string]any)
+	if !ok {
+		return t
+	}
+	old, _ := s["properties"].(map[string]any)
+	p := make(map[string]any, len(old)+len(props))
+	for k, v := range old {
+		p[k] = v
+	}
+	for k, v := range props {
+		p[k] = v
+	}
+	out := make(map[string]any, len(s))
+	for k, v := range s {
+		out[k] = v
+	}
+	out["properties"] = p
+	t.InputSchema = out
+	return t
+}
+
+// WithOutputSchema returns t declaring schema as the shape of its
+// structuredContent, for results only one server structures.
+func (t Tool) WithOutputSchema(schema any) Tool {
+	t.OutputSchema = schema
+	return t
+}
@@ -0,0 +1,100 @@
+package mcp
+
+import (
+	"encoding/json"
+	"slices"
+)
+
+// SupportedVersions are the MCP revisions the servers speak, newest first.
+var SupportedVersions = []string{"2025-06-18", "2025-03-26", "2024-11-05"}
+
+// Revisions that added to what the servers send.
+const (
+	// VersionAnnotations introduced tool annotations.
+	VersionAnnotations = "2025-03-26"
+	// VersionProgressTokens is the first revision whose clients are
+	// assumed to follow the spec on progress: they get it only when they
+	// ask with _meta.progressToken.
+	VersionProgressTokens = "2025-03-26"
+	// VersionStructuredContent introduced structuredContent and
+	// outputSchema.
+	VersionStructuredContent = "2025-06-18"
+	// VersionResourceLinks introduced resource_link content.
+	VersionResourceLinks = "2025-06-18"
+)
+
+// SupportsVersion reports whether version is one of SupportedVersions.
+func SupportsVersion(version string) bool {
+	return slices.Contains(SupportedVersions, version)
+}
+
+// NegotiateVersion answers the protocolVersion a client requested in
+// initialize: the same version when the servers speak it, else the newest
+// they do, which the client may accept or disconnect over.
+func NegotiateVersion(requested string) string {
+	if SupportsVersion(requested) {
+		return requested
+	}
+	return ProtocolVersion
+}
+
+// ProgressToken returns the token for the progress notifications of a
+// request with params and id from a client of protocol revision version:
+// the client's params._meta.progressToken, or for clients before
+// VersionProgressTokens (and those that declared no version) the request
+// ID. nil means the client wants no progress.
+func ProgressToken(params, id json.RawMessage, version string) json.RawMessage {
+	var p struct {
+		Meta struct {
+			ProgressToken json.RawMessage `json:"progressToken"`
+		} `json:"_meta"`
+	}
+	if json.Unmarshal(params, &p) == nil && p.Meta.ProgressToken != nil && string(p.Meta.ProgressToken) != "null" {
+		return p.Meta.ProgressToken
+	}
+	if version != "" && version >= VersionProgressTokens {
+		return nil
+	}
+	return id
+}
+
+// ToolsFor drops from tools what clients of protocol revision version
+// don't know. Revisions are dates, so they compare as strings; an empty
+// version counts as current. tools itself is not modified.
+func ToolsFor(tools []Tool, version string) []Tool {
+	if version == "" || version >= VersionStructuredContent {
+		return tools
+	}
+	out := slices.Clone(tools)
+	for i := range out {
+		out[i].OutputSchema = nil
+		if version < VersionAnnotations {
+			out[i].Annotations = nil
+		}
+	}
+	return out
+}
+
+// ToolAnnotations are hints about a tool's behaviour, for clients that
+// confirm or auto-approve calls. Unset hints take the spec's defaults.
+type ToolAnnotations struct {
+	Title           string `json:"title,omitempty"`
+	ReadOnlyHint    *bool  `json:"readOnlyHint,omitempty"`
+	DestructiveHint *bool  `json:"destructiveHint,omitempty"`
+	IdempotentHint  *bool  `json:"idempotentHint,omitempty"`
+	OpenWorldHint   *bool  `json:"openWorldHint,omitempty"`
+}
+
+// ReadOnly annotates a tool that changes nothing.
+func ReadOnly() *ToolAnnotations {
+	return &ToolAnnotations{ReadOnlyHint: hint(true)}
+}
+
+// Mutating annotates a tool that changes state: destructive when it may
+// overwrite or delete, idempotent when repeating a call changes nothing
+// more.
+func Mutating(destructive, idempotent bool) *ToolAnnotations {
+	return &ToolAnnotations{ReadOnlyHint: hint(false), DestructiveHint: hint(destructive), IdempotentHint: hint(idempotent)}
+}
+
+func hint(b bool) *bool { return &b }
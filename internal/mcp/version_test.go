@@ -0,0 +1,61 @@
+package mcp
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// Test the requested revision is echoed when supported, and tools/list
+// drops what older revisions don't know
+func TestNegotiateVersion(t *testing.T) {
+	for requested, want := range map[string]string{
+		"2024-11-05": "2024-11-05",
+		"2025-03-26": "2025-03-26",
+		"2025-06-18": "2025-06-18",
+		"":           ProtocolVersion,
+		"2024-10-07": ProtocolVersion,
+	} {
+		if got := NegotiateVersion(requested); got != want {
+			t.Errorf("NegotiateVersion(%q) = %q, want %q", requested, got, want)
+		}
+	}
+
+	tools := []Tool{RunTool(), {Name: "x", OutputSchema: map[string]any{}, Annotations: ReadOnly()}}
+	for _, tt := range []struct {
+		version                   string
+		annotations, outputSchema bool
+	}{
+		{"2024-11-05", false, false},
+		{"2025-03-26", true, false},
+		{"2025-06-18", true, true},
+		{"", true, true},
+	} {
+		got := ToolsFor(tools, tt.version)
+		if (got[0].Annotations != nil) != tt.annotations || (got[1].OutputSchema != nil) != tt.outputSchema {
+			t.Errorf("ToolsFor(%q) = %+v", tt.version, got)
+		}
+	}
+	if tools[0].Annotations == nil || tools[1].OutputSchema == nil {
+		t.Error("ToolsFor modified its argument")
+	}
+}
+
+// Test progress is keyed by the client's token, and by the request ID only
+// for clients from before tokens were required
+func TestProgressToken(t *testing.T) {
+	id := json.RawMessage(`7`)
+	for _, tt := range []struct {
+		params, version, want string
+	}{
+		{`{"_meta":{"progressToken":"tok"}}`, "2025-06-18", `"tok"`},
+		{`{"_meta":{"progressToken":3}}`, "2024-11-05", `3`},
+		{`{"name":"opencode_run"}`, "2025-06-18", ``},
+		{`{"_meta":{"progressToken":null}}`, "2025-03-26", ``},
+		{`{"name":"opencode_run"}`, "2024-11-05", `7`},
+		{`{}`, "", `7`},
+	} {
+		if got := ProgressToken(json.RawMessage(tt.params), id, tt.version); string(got) != tt.want {
+			t.Errorf("ProgressToken(%s, %q) = %s, want %s", tt.params, tt.version, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package runner
+
+// RunOptions are the optional flags of `opencode run`.
+type RunOptions struct {
+	Model    string // empty for opencode's default
+	Session  string
+	Continue bool
+	Agent    string
+	Files    []string
+}
+
+// RunArgs returns the arguments of `opencode run --format json` for
+// message.
+func RunArgs(message string, o RunOptions) []string {
+	args := []string{"run", "--format", "json"}
+	if o.Model != "" {
+		args = append(args, "--model", o.Model)
+	}
+	if o.Session != "" {
+		args = append(args, "--session", o.Session)
+	}
+	if o.Continue {
+		args = append(args, "--continue")
+	}
+	if o.Agent != "" {
+		args = append(args, "--agent", o.Agent)
+	}
+	for _, file := range o.Files {
+		args = append(args, "--file", file)
+	}
+	return append(args, message)
+}
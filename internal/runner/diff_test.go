@@ -0,0 +1,17 @@
+package runner
+
+import "testing"
+
+// Test LineDiff keeps common lines and marks removed and added ones
+func TestLineDiff(t *testing.T) {
+	for _, tt := range []struct{ a, b, want string }{
+		{"", "", ""},
+		{"a\nb\n", "a\nb", "  a\n  b\n"},
+		{"a\nb\nc", "a\nc\nd", "  a\n- b\n  c\n+ d\n"},
+		{"", "x", "+ x\n"},
+	} {
+		if got := LineDiff(tt.a, tt.b); got != tt.want {
+			t.Errorf("LineDiff(%q, %q) = %q, want %q", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
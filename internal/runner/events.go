@@ -0,0 +1,135 @@
+// Package runner holds what the HTTP and stdio servers share of running
+// opencode: the command line of a run, parsing its JSON event stream, and
+// the cached model list.
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ParseEvent decodes one line of `opencode run --format json` output.
+func ParseEvent(line string) (map[string]any, bool) {
+	var event map[string]any
+	if err := json.Unmarshal([]byte(line), &event); err != nil || event == nil {
+		return nil, false
+	}
+	return event, true
+}
+
+// EventText returns the text of a text event.
+func EventText(event map[string]any) (string, bool) {
+	if event["type"] != "text" {
+		return "", false
+	}
+	part, _ := event["part"].(map[string]any)
+	text, ok := part["text"].(string)
+	return text, ok
+}
+
+// EventData extracts readable content from opencode-cli JSON events: the
+// text of text events, a summary of tool uses and steps, else the event.
+func EventData(event map[string]any) any {
+	eventType, _ := event["type"].(string)
+	part, ok := event["part"].(map[string]any)
+	if !ok {
+		return event
+	}
+
+	switch eventType {
+	case "text":
+		if text, ok := part["text"].(string); ok {
+			return text
+		}
+	case "tool_use":
+		toolName, _ := part["tool"].(string)
+		if state, ok := part["state"].(map[string]any); ok {
+			status, _ := state["status"].(string)
+			result := map[string]any{
+				"tool":   toolName,
+				"status": status,
+			}
+			if input, ok := state["input"].(map[string]any); ok {
+				result["input"] = input
+			}
+			if output, ok := state["output"]; ok {
+				result["output"] = output
+			}
+			if errMsg, ok := state["error"].(string); ok && errMsg != "" {
+				result["error"] = errMsg
+			}
+			return result
+		}
+		return map[string]any{"tool": toolName, "status": "unknown"}
+	case "step_start":
+		reason, _ := part["reason"].(string)
+		return map[string]any{"type": "step_start", "reason": reason}
+	case "step_finish":
+		reason, _ := part["reason"].(string)
+		return map[string]any{"type": "step_finish", "reason": reason}
+	}
+
+	return event
+}
+
+// FormatEvents parses an opencode-cli JSON event stream and extracts readable text.
+// Preserves step_start, step_finish, tool_use (all states: in_progress, completed, error).
+func FormatEvents(jsonLines string) string {
+	var parts []string
+
+	lines := strings.Split(jsonLines, "\n")
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		event, ok := ParseEvent(line)
+		if !ok {
+			continue
+		}
+
+		eventType, _ := event["type"].(string)
+		part, ok := event["part"].(map[string]any)
+		if !ok {
+			continue
+		}
+
+		switch eventType {
+		case "text":
+			if text, ok := part["text"].(string); ok && text != "" {
+				parts = append(parts, text)
+			}
+		case "step_start":
+			if reason, ok := part["reason"].(string); ok && reason != "" {
+				parts = append(parts, fmt.Sprintf("\n[Step started: %s]\n", reason))
+			}
+		case "step_finish":
+			if reason, ok := part["reason"].(string); ok && reason != "" {
+				parts = append(parts, fmt.Sprintf("[Step finished: %s]\n", reason))
+			}
+		case "tool_use":
+			toolName, _ := part["tool"].(string)
+			if state, ok := part["state"].(map[string]any); ok {
+				status, _ := state["status"].(string)
+				switch status {
+				case "in_progress":
+					parts = append(parts, fmt.Sprintf("[Tool: %s] running...\n", toolName))
+				case "completed":
+					if output, ok := state["output"].(string); ok && output != "" {
+						parts = append(parts, fmt.Sprintf("[Tool: %s]\n%s\n", toolName, output))
+					}
+				case "error":
+					errMsg, _ := state["error"].(string)
+					if errMsg == "" {
+						errMsg = "unknown error"
+					}
+					parts = append(parts, fmt.Sprintf("[Tool: %s] error: %s\n", toolName, errMsg))
+				}
+			}
+		}
+	}
+
+	return strings.Join(parts, "")
+}
@@ -0,0 +1,107 @@
+package runner
+
+import "testing"
+
+// Test EventData
+func TestEventData(t *testing.T) {
+	tests := []struct {
+		name  string
+		event map[string]any
+		check func(t *testing.T, result any)
+	}{
+		{
+			name: "text event",
+			event: map[string]any{
+				"type": "text",
+				"part": map[string]any{
+					"text": "Hello, world!",
+				},
+			},
+			check: func(t *testing.T, result any) {
+				if result != "Hello, world!" {
+					t.Errorf("expected 'Hello, world!', got %v", result)
+				}
+			},
+		},
+		{
+			name: "tool_use event",
+			event: map[string]any{
+				"type": "tool_use",
+				"part": map[string]any{
+					"tool": "read_file",
+					"state": map[string]any{
+						"status": "completed",
+						"input":  map[string]any{"path": "/tmp/test.txt"},
+						"output": "file contents",
+					},
+				},
+			},
+			check: func(t *testing.T, result any) {
+				m, ok := result.(map[string]any)
+				if !ok {
+					t.Fatalf("expected map, got %T", result)
+				}
+				if m["tool"] != "read_file" {
+					t.Errorf("expected tool 'read_file', got %v", m["tool"])
+				}
+				if m["status"] != "completed" {
+					t.Errorf("expected status 'completed', got %v", m["status"])
+				}
+			},
+		},
+		{
+			name: "step_start event",
+			event: map[string]any{
+				"type": "step_start",
+				"part": map[string]any{
+					"reason": "user_request",
+				},
+			},
+			check: func(t *testing.T, result any) {
+				m, ok := result.(map[string]any)
+				if !ok {
+					t.Fatalf("expected map, got %T", result)
+				}
+				if m["type"] != "step_start" {
+					t.Errorf("expected type 'step_start', got %v", m["type"])
+				}
+			},
+		},
+		{
+			name: "event without part",
+			event: map[string]any{
+				"type": "unknown",
+				"data": "something",
+			},
+			check: func(t *testing.T, result any) {
+				m, ok := result.(map[string]any)
+				if !ok {
+					t.Fatalf("expected map, got %T", result)
+				}
+				if m["type"] != "unknown" {
+					t.Errorf("expected original event to be returned")
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := EventData(tt.event)
+			tt.check(t, result)
+		})
+	}
+}
+
+func BenchmarkEventData(b *testing.B) {
+	event := map[string]any{
+		"type": "text",
+		"part": map[string]any{
+			"text": "Hello, world!",
+		},
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		EventData(event)
+	}
+}
@@ -0,0 +1,91 @@
+package runner
+
+import (
+	"encoding/base64"
+	"strings"
+)
+
+// FileEditTools are the tools whose inputs name the files a run changed:
+// opencode's, and the edit events of wrapped agent CLIs.
+var FileEditTools = map[string]bool{"edit": true, "write": true, "multiedit": true, "patch": true}
+
+// EditedPaths returns the paths the input of a file edit tool names:
+// filePath or path, and the paths of codex file_change items.
+func EditedPaths(input map[string]any) []string {
+	var paths []string
+	for _, key := range []string{"filePath", "path"} {
+		if p, _ := input[key].(string); p != "" {
+			paths = append(paths, p)
+		}
+	}
+	changes, _ := input["changes"].([]any)
+	for _, c := range changes {
+		m, _ := c.(map[string]any)
+		if p, _ := m["path"].(string); p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+// Image is an image a tool attached to its result, e.g. a screenshot or
+// an image file it read.
+type Image struct {
+	MimeType string
+	Data     string // base64
+	Size     int    // decoded bytes
+}
+
+// imageFromDataURL decodes the base64 data: URL of an image attachment.
+func imageFromDataURL(u string) (Image, bool) {
+	meta, data, ok := strings.Cut(strings.TrimPrefix(u, "data:"), ",")
+	if !ok || !strings.HasPrefix(u, "data:") {
+		return Image{}, false
+	}
+	mimeType, isBase64 := strings.CutSuffix(meta, ";base64")
+	if !isBase64 || !strings.HasPrefix(mimeType, "image/") {
+		return Image{}, false
+	}
+	return Image{MimeType: mimeType, Data: data, Size: base64.StdEncoding.DecodedLen(len(data))}, true
+}
+
+// RunFiles collects what the tools of a run referenced besides their
+// output, for the run's result: the files they wrote or edited, each once,
+// and the images they attached.
+type RunFiles struct {
+	Paths  []string
+	Images []Image
+	seen   map[string]bool
+}
+
+// Add records the files and images of a completed tool_use event.
+func (f *RunFiles) Add(event map[string]any) {
+	if event["type"] != "tool_use" {
+		return
+	}
+	part, _ := event["part"].(map[string]any)
+	state, _ := part["state"].(map[string]any)
+	if state["status"] != "completed" {
+		return
+	}
+	if tool, _ := part["tool"].(string); FileEditTools[tool] {
+		input, _ := state["input"].(map[string]any)
+		for _, p := range EditedPaths(input) {
+			if !f.seen[p] {
+				if f.seen == nil {
+					f.seen = map[string]bool{}
+				}
+				f.seen[p] = true
+				f.Paths = append(f.Paths, p)
+			}
+		}
+	}
+	attachments, _ := state["attachments"].([]any)
+	for _, a := range attachments {
+		m, _ := a.(map[string]any)
+		u, _ := m["url"].(string)
+		if img, ok := imageFromDataURL(u); ok {
+			f.Images = append(f.Images, img)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package runner
+
+import "testing"
+
+// Test RunFiles collects each edited file once and image attachments
+func TestRunFiles(t *testing.T) {
+	toolUse := func(tool, status string, state map[string]any) map[string]any {
+		state["status"] = status
+		return map[string]any{"type": "tool_use", "part": map[string]any{"tool": tool, "state": state}}
+	}
+	var f RunFiles
+	f.Add(toolUse("write", "completed", map[string]any{"input": map[string]any{"filePath": "/w/a.go"}}))
+	f.Add(toolUse("edit", "completed", map[string]any{"input": map[string]any{"filePath": "/w/a.go"}}))
+	f.Add(toolUse("patch", "completed", map[string]any{"input": map[string]any{"changes": []any{map[string]any{"path": "/w/b.go"}}}}))
+	f.Add(toolUse("edit", "running", map[string]any{"input": map[string]any{"filePath": "/w/c.go"}}))
+	f.Add(toolUse("read", "completed", map[string]any{
+		"input": map[string]any{"filePath": "/w/shot.png"},
+		"attachments": []any{
+			map[string]any{"url": "data:image/png;base64,cG5n"},
+			map[string]any{"url": "data:text/plain;base64,aGk="},
+			map[string]any{"url": "https://example.com/x.png"},
+		},
+	}))
+	f.Add(map[string]any{"type": "text", "part": map[string]any{"text": "/w/d.go"}})
+
+	if len(f.Paths) != 2 || f.Paths[0] != "/w/a.go" || f.Paths[1] != "/w/b.go" {
+		t.Errorf("Paths = %v", f.Paths)
+	}
+	if len(f.Images) != 1 || f.Images[0] != (Image{MimeType: "image/png", Data: "cG5n", Size: 3}) {
+		t.Errorf("Images = %+v", f.Images)
+	}
+}
@@ -0,0 +1,181 @@
+package runner
+
+import (
+	"context"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ModelCache caches the model list for TTL, so tools/list and default
+// model lookups don't start opencode on every call. The zero value is
+// ready to use with a 5 minute TTL.
+type ModelCache[T any] struct {
+	TTL time.Duration
+
+	mu      sync.RWMutex
+	models  []T
+	fetched time.Time
+}
+
+const defaultModelCacheTTL = 5 * time.Minute
+
+func (c *ModelCache[T]) fresh() bool {
+	ttl := c.TTL
+	if ttl == 0 {
+		ttl = defaultModelCacheTTL
+	}
+	return len(c.models) > 0 && time.Since(c.fetched) < ttl
+}
+
+// Get returns the cached models, calling fetch when they are missing or
+// stale. An empty or failed fetch is not cached.
+func (c *ModelCache[T]) Get(fetch func(ctx context.Context) ([]T, error)) []T {
+	c.mu.RLock()
+	if c.fresh() {
+		models := c.models
+		c.mu.RUnlock()
+		return models
+	}
+	c.mu.RUnlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Double-check after acquiring write lock
+	if c.fresh() {
+		return c.models
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	models, err := fetch(ctx)
+	if err != nil {
+		log.Printf("Failed to fetch models: %v", err)
+		return nil
+	}
+	if len(models) > 0 {
+		c.models = models
+		c.fetched = time.Now()
+		log.Printf("Cached %d available models", len(models))
+	}
+	return models
+}
+
+// Set replaces the cached models, as if just fetched.
+func (c *ModelCache[T]) Set(models []T) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.models = models
+	c.fetched = time.Now()
+}
+
+// Invalidate forces the next Get to fetch again, e.g. after switching
+// binaries.
+func (c *ModelCache[T]) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fetched = time.Time{}
+}
+
+// PreferredModels are picked as the default model, in order, when
+// available (provider/model format per opencode.ai docs).
+var PreferredModels = []string{
+	"github-copilot/gpt-5.2-codex",
+	"github-copilot/gpt-5.1-codex",
+	"opencode/gpt-5.2-codex",
+	"opencode/gpt-5.1-codex",
+	"github-copilot/gpt-4o",
+	"github-copilot/claude-sonnet-4.5",
+}
+
+// DefaultModel picks the best of the available models: a preferred one,
+// else the first github-copilot or opencode model, else the first. It
+// returns "" when there are none, to let opencode use its own default.
+func DefaultModel(models []string) string {
+	for _, preferred := range PreferredModels {
+		for _, available := range models {
+			if available == preferred {
+				log.Printf("Selected preferred model: %s", available)
+				return available
+			}
+		}
+	}
+
+	for _, preferred := range PreferredModels {
+		for _, available := range models {
+			if strings.Contains(available, preferred) {
+				log.Printf("Selected partial match model: %s", available)
+				return available
+			}
+		}
+	}
+
+	for _, available := range models {
+		if strings.HasPrefix(available, "github-copilot/") || strings.HasPrefix(available, "opencode/") {
+			log.Printf("Selected first available model: %s", available)
+			return available
+		}
+	}
+
+	if len(models) > 0 {
+		log.Printf("Selected first available model: %s", models[0])
+		return models[0]
+	}
+	return ""
+}
+
+var ansiEscapeRe = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]`)
+
+// StripANSI removes terminal escape sequences from CLI output.
+func StripANSI(s string) string {
+	return ansiEscapeRe.ReplaceAllString(s, "")
+}
+
+// ModelLine is one model of the human-readable `models` output.
+type ModelLine struct {
+	ID          string // provider/name
+	DisplayName string // empty when the line has none
+}
+
+// ParseModelsTable parses the human-readable `models` output. Lines are
+// either a bare "provider/model" ID or an ID followed by a display name;
+// headers, separators and blank lines are skipped.
+func ParseModelsTable(output string) []ModelLine {
+	var models []ModelLine
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(StripANSI(line))
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "Available") {
+			continue
+		}
+		fields := strings.Fields(strings.Trim(line, "│|"))
+		idx := -1
+		for i, f := range fields {
+			if IsModelID(f) {
+				idx = i
+				break
+			}
+		}
+		if idx < 0 {
+			continue
+		}
+		id := fields[idx]
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		rest := strings.Join(fields[idx+1:], " ")
+		rest = strings.TrimSpace(strings.Trim(rest, "│|-–— "))
+		models = append(models, ModelLine{ID: id, DisplayName: rest})
+	}
+	return models
+}
+
+// IsModelID reports whether s looks like "provider/model".
+func IsModelID(s string) bool {
+	provider, name, ok := strings.Cut(s, "/")
+	return ok && provider != "" && name != "" && !strings.ContainsAny(provider, "()[]:,")
+}
@@ -0,0 +1,31 @@
+//go:build !windows
+
+package runner
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// KillProcessGroup makes cmd start its own process group and, when its
+// context is done, kills the whole group rather than only cmd: opencode
+// leaves language servers and shells behind otherwise. Call it after any
+// other SysProcAttr settings and before Start.
+func KillProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	cmd.Cancel = func() error {
+		if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err == nil {
+			return nil
+		}
+		return cmd.Process.Kill()
+	}
+}
+
+// TerminateProcessGroup asks the process group of cmd, started with
+// KillProcessGroup, to exit with SIGTERM.
+func TerminateProcessGroup(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+}
@@ -0,0 +1,12 @@
+package runner
+
+import "os/exec"
+
+// KillProcessGroup leaves cmd as it is: on Windows exec.CommandContext
+// already terminates the process, and its children are not tracked.
+func KillProcessGroup(cmd *exec.Cmd) {}
+
+// TerminateProcessGroup kills the process: Windows has no SIGTERM.
+func TerminateProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
@@ -0,0 +1,141 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Session is an opencode session as `session list --format json` and
+// `export` report it. Times are Unix milliseconds.
+type Session struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	Time  struct {
+		Created int64 `json:"created"`
+		Updated int64 `json:"updated"`
+	} `json:"time"`
+}
+
+// SessionListArgs are the arguments of `opencode session list` with JSON
+// output.
+func SessionListArgs() []string {
+	return []string{"session", "list", "--format", "json"}
+}
+
+// ParseSessionList parses the output of SessionListArgs.
+func ParseSessionList(output []byte) ([]Session, error) {
+	var sessions []Session
+	if err := json.Unmarshal(jsonStart(output), &sessions); err != nil {
+		return nil, fmt.Errorf("parse session list: %w", err)
+	}
+	return sessions, nil
+}
+
+// ExportArgs are the arguments of `opencode export`, which prints a session
+// and its messages as JSON.
+func ExportArgs(session string) []string {
+	return []string{"export", session}
+}
+
+// sessionExport is the output of `opencode export`.
+type sessionExport struct {
+	Info     Session `json:"info"`
+	Messages []struct {
+		Info struct {
+			Role    string `json:"role"`
+			ModelID string `json:"modelID"`
+			Time    struct {
+				Created int64 `json:"created"`
+			} `json:"time"`
+		} `json:"info"`
+		Parts []struct {
+			Type  string `json:"type"`
+			Text  string `json:"text"`
+			Tool  string `json:"tool"`
+			State struct {
+				Status string          `json:"status"`
+				Input  json.RawMessage `json:"input"`
+				Output string          `json:"output"`
+				Error  string          `json:"error"`
+			} `json:"state"`
+			Filename string `json:"filename"`
+		} `json:"parts"`
+	} `json:"messages"`
+}
+
+// RenderExport formats the output of ExportArgs as a Markdown transcript:
+// each message under its role, with tool calls collapsed. Reasoning and
+// step markers are left out.
+func RenderExport(output []byte) (Session, string, error) {
+	var export sessionExport
+	if err := json.Unmarshal(jsonStart(output), &export); err != nil {
+		return Session{}, "", fmt.Errorf("parse export: %w", err)
+	}
+	if export.Info.ID == "" {
+		return Session{}, "", fmt.Errorf("parse export: no session")
+	}
+
+	var b strings.Builder
+	title := export.Info.Title
+	if title == "" {
+		title = export.Info.ID
+	}
+	fmt.Fprintf(&b, "# %s\n", title)
+	for _, m := range export.Messages {
+		heading := "User"
+		if m.Info.Role == "assistant" {
+			heading = "Assistant"
+			if m.Info.ModelID != "" {
+				heading += " (" + m.Info.ModelID + ")"
+			}
+		}
+		if m.Info.Time.Created > 0 {
+			heading += " · " + time.UnixMilli(m.Info.Time.Created).UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(&b, "\n## %s\n", heading)
+		for _, p := range m.Parts {
+			switch p.Type {
+			case "text":
+				if text := strings.TrimSpace(p.Text); text != "" {
+					b.WriteString("\n" + text + "\n")
+				}
+			case "file":
+				fmt.Fprintf(&b, "\nAttached `%s`\n", p.Filename)
+			case "tool":
+				fmt.Fprintf(&b, "\n<details>\n<summary>Tool <code>%s</code> (%s)</summary>\n\n", p.Tool, p.State.Status)
+				if len(p.State.Input) > 0 && string(p.State.Input) != "null" {
+					b.WriteString(MarkdownFence(string(p.State.Input), "json"))
+				}
+				output := p.State.Output
+				if output == "" {
+					output = p.State.Error
+				}
+				if output != "" {
+					b.WriteString(MarkdownFence(output, ""))
+				}
+				b.WriteString("</details>\n")
+			}
+		}
+	}
+	return export.Info, b.String(), nil
+}
+
+// jsonStart skips anything opencode printed before its JSON output.
+func jsonStart(output []byte) []byte {
+	if i := bytes.IndexAny(output, "[{"); i > 0 {
+		return output[i:]
+	}
+	return output
+}
+
+// MarkdownFence wraps s in a code fence longer than any backtick run in s.
+func MarkdownFence(s, lang string) string {
+	fence := "```"
+	for strings.Contains(s, fence) {
+		fence += "`"
+	}
+	return fence + lang + "\n" + strings.TrimRight(s, "\n") + "\n" + fence + "\n\n"
+}
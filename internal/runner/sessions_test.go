@@ -0,0 +1,39 @@
+package runner
+
+import (
+	"strings"
+	"testing"
+)
+
+// Test session lists and exports are parsed, and exports rendered as Markdown
+func TestSessions(t *testing.T) {
+	sessions, err := ParseSessionList([]byte(`[{"id":"ses_1","title":"Fix it","time":{"created":1,"updated":2}}]`))
+	if err != nil || len(sessions) != 1 || sessions[0].ID != "ses_1" || sessions[0].Time.Updated != 2 {
+		t.Errorf("ParseSessionList = %+v, %v", sessions, err)
+	}
+
+	export := "Exporting session ses_1\n" + `{"info":{"id":"ses_1","title":"Fix it"},"messages":[
+{"info":{"role":"user","time":{"created":1700000000000}},"parts":[{"type":"text","text":"Fix the build"},{"type":"file","filename":"go.mod"}]},
+{"info":{"role":"assistant","modelID":"gpt-5"},"parts":[{"type":"reasoning","text":"hmm"},{"type":"tool","tool":"bash","state":{"status":"error","input":{"command":"make"},"error":"no rule"}},{"type":"text","text":"Done."}]}]}`
+	info, md, err := RenderExport([]byte(export))
+	if err != nil || info.Title != "Fix it" {
+		t.Fatalf("RenderExport = %+v, %v", info, err)
+	}
+	for _, want := range []string{
+		"# Fix it\n",
+		"## User · 2023-11-14T22:13:20Z\n\nFix the build\n\nAttached `go.mod`\n",
+		"## Assistant (gpt-5)\n",
+		"<summary>Tool <code>bash</code> (error)</summary>\n\n```json\n{\"command\":\"make\"}\n```\n\n```\nno rule\n```",
+		"</details>\n\nDone.\n",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("transcript lacks %q:\n%s", want, md)
+		}
+	}
+	if strings.Contains(md, "hmm") {
+		t.Errorf("reasoning rendered:\n%s", md)
+	}
+	if _, _, err := RenderExport([]byte(`{}`)); err == nil {
+		t.Error("RenderExport accepted an export without a session")
+	}
+}
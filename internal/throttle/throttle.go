@@ -0,0 +1,151 @@
+// Package throttle limits the rate of outbound MCP notifications. Messages
+// over the limit are queued, and merged with queued ones where the
+// transport allows it (e.g. text deltas), so a chatty run reaches the
+// client as fewer, larger notifications.
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket allowing Rate messages per second, in bursts
+// of up to Rate. Several Throttles may share one, e.g. the streams of one
+// session. A nil *Limiter allows everything.
+type Limiter struct {
+	rate float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter returns a Limiter for rate messages per second, or nil (no
+// limit) when rate <= 0.
+func NewLimiter(rate int) *Limiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &Limiter{rate: float64(rate), tokens: float64(rate)}
+}
+
+// take consumes a token if one is available, and otherwise reports how
+// long until the next one.
+func (l *Limiter) take(now time.Time) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.last.IsZero() {
+		l.tokens = min(l.rate, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
+
+// Merge is what a MergeFunc decided about a queued and the next message.
+type Merge int
+
+const (
+	Separate Merge = iota // send both, in order
+	Merged                // replace the queued message with the merged one
+	Commute               // order doesn't matter: try earlier queued messages
+)
+
+// MergeFunc decides whether next can be combined with a queued message.
+type MergeFunc func(queued, next any) (any, Merge)
+
+// Throttle sends the messages of one stream through a Limiter.
+type Throttle struct {
+	limiter *Limiter
+	send    func(msg any)
+	merge   MergeFunc
+
+	mu    sync.Mutex
+	queue []any
+	timer *time.Timer
+}
+
+// New returns a Throttle sending through send. merge may be nil.
+func New(l *Limiter, send func(msg any), merge MergeFunc) *Throttle {
+	return &Throttle{limiter: l, send: send, merge: merge}
+}
+
+// Send sends msg now if the limit allows and nothing is queued before it,
+// and otherwise queues it.
+func (t *Throttle) Send(msg any) {
+	if t.limiter == nil {
+		t.send(msg)
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.queue) > 0 {
+		t.enqueue(msg)
+		return
+	}
+	if ok, wait := t.limiter.take(time.Now()); ok {
+		t.send(msg)
+	} else {
+		t.queue = append(t.queue, msg)
+		t.schedule(wait)
+	}
+}
+
+// Flush sends everything queued regardless of the limit, e.g. before the
+// final response of a call.
+func (t *Throttle) Flush() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+	for _, msg := range t.queue {
+		t.send(msg)
+	}
+	t.queue = nil
+}
+
+// enqueue merges msg into the queue, or appends it.
+func (t *Throttle) enqueue(msg any) {
+	for i := len(t.queue) - 1; i >= 0 && t.merge != nil; i-- {
+		merged, m := t.merge(t.queue[i], msg)
+		if m == Merged {
+			t.queue[i] = merged
+			return
+		}
+		if m != Commute {
+			break
+		}
+	}
+	t.queue = append(t.queue, msg)
+}
+
+func (t *Throttle) schedule(wait time.Duration) {
+	if t.timer != nil {
+		return
+	}
+	t.timer = time.AfterFunc(wait, t.drain)
+}
+
+// drain sends queued messages while the limit allows.
+func (t *Throttle) drain() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.timer = nil
+	for len(t.queue) > 0 {
+		ok, wait := t.limiter.take(time.Now())
+		if !ok {
+			t.schedule(wait)
+			return
+		}
+		t.send(t.queue[0])
+		t.queue = t.queue[1:]
+	}
+}
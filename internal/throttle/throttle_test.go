@@ -0,0 +1,82 @@
+package throttle
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// Test messages over the rate are queued, merged and sent later in order
+func TestThrottle(t *testing.T) {
+	var mu sync.Mutex
+	var sent []any
+	send := func(msg any) {
+		mu.Lock()
+		defer mu.Unlock()
+		sent = append(sent, msg)
+	}
+	sentCopy := func() []any {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]any(nil), sent...)
+	}
+	// Strings are concatenated, and commute with ints
+	concat := func(queued, next any) (any, Merge) {
+		a, okA := queued.(string)
+		b, okB := next.(string)
+		switch {
+		case okA && okB:
+			return a + b, Merged
+		case okA != okB:
+			return nil, Commute
+		}
+		return nil, Separate
+	}
+
+	th := New(NewLimiter(2), send, concat)
+	for _, msg := range []any{"a", "b", "c", 1, "d", 2, "e"} {
+		th.Send(msg)
+	}
+	if got := sentCopy(); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Fatalf("sent within the burst = %v, want [a b]", got)
+	}
+
+	// The queue [cde 1 2] drains at 2/s
+	deadline := time.Now().Add(5 * time.Second)
+	for len(sentCopy()) < 5 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	want := []any{"a", "b", "cde", 1, 2}
+	got := sentCopy()
+	if len(got) != len(want) {
+		t.Fatalf("sent = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("sent = %v, want %v", got, want)
+		}
+	}
+
+	// Flush sends the queue at once
+	th.Send("g")
+	th.Send("h")
+	th.Flush()
+	if got := sentCopy(); got[len(got)-1] != "gh" && got[len(got)-1] != "h" {
+		t.Errorf("after Flush sent = %v", got)
+	}
+}
+
+// Test a nil Limiter sends everything immediately
+func TestThrottleUnlimited(t *testing.T) {
+	if NewLimiter(0) != nil {
+		t.Fatal("NewLimiter(0) should be unlimited")
+	}
+	n := 0
+	th := New(nil, func(any) { n++ }, nil)
+	for i := 0; i < 1000; i++ {
+		th.Send(i)
+	}
+	if n != 1000 {
+		t.Errorf("sent %d, want 1000", n)
+	}
+}